@@ -52,6 +52,34 @@ func (a *Client) GetClusterNodes(params *GetClusterNodesParams) (*GetClusterNode
 
 }
 
+/*
+GetClusterNodesStore gets nodes stored in the kvstore with their revision and last update
+*/
+func (a *Client) GetClusterNodesStore(params *GetClusterNodesStoreParams) (*GetClusterNodesStoreOK, error) {
+	// TODO: Validate the params before sending
+	if params == nil {
+		params = NewGetClusterNodesStoreParams()
+	}
+
+	result, err := a.transport.Submit(&runtime.ClientOperation{
+		ID:                 "GetClusterNodesStore",
+		Method:             "GET",
+		PathPattern:        "/cluster/nodes/store",
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Schemes:            []string{"http"},
+		Params:             params,
+		Reader:             &GetClusterNodesStoreReader{formats: a.formats},
+		Context:            params.Context,
+		Client:             params.HTTPClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*GetClusterNodesStoreOK), nil
+
+}
+
 /*
 GetConfig gets configuration of cilium daemon
 
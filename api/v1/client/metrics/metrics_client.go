@@ -24,6 +24,39 @@ type Client struct {
 	formats   strfmt.Registry
 }
 
+/*
+DeleteMetricsBpf resets b p f datapath metrics
+
+Zeroes the drop and forward counters of the BPF metrics map and the
+corresponding prometheus metrics, optionally restricted to a reason
+and direction.
+
+*/
+func (a *Client) DeleteMetricsBpf(params *DeleteMetricsBpfParams) (*DeleteMetricsBpfOK, error) {
+	// TODO: Validate the params before sending
+	if params == nil {
+		params = NewDeleteMetricsBpfParams()
+	}
+
+	result, err := a.transport.Submit(&runtime.ClientOperation{
+		ID:                 "DeleteMetricsBpf",
+		Method:             "DELETE",
+		PathPattern:        "/metrics/bpf",
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Schemes:            []string{"http"},
+		Params:             params,
+		Reader:             &DeleteMetricsBpfReader{formats: a.formats},
+		Context:            params.Context,
+		Client:             params.HTTPClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*DeleteMetricsBpfOK), nil
+
+}
+
 /*
 GetMetrics retrieves cilium metrics
 */
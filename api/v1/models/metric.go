@@ -15,6 +15,11 @@ import (
 // swagger:model Metric
 type Metric struct {
 
+	// Machine-readable remediation hint naming what to check, only
+	// set for non-zero drop counters with a known drop reason
+	//
+	Hint string `json:"hint,omitempty"`
+
 	// Labels of the metric
 	Labels map[string]string `json:"labels,omitempty"`
 
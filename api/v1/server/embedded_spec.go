@@ -56,6 +56,29 @@ func init() {
         }
       }
     },
+    "/cluster/nodes/store": {
+      "get": {
+        "description": "Retrieves all nodes of the kvstore node store as seen by this agent.\nNodes whose last update lags far behind the others may have stopped\nrefreshing their entry.\n",
+        "tags": [
+          "daemon"
+        ],
+        "summary": "Get nodes stored in the kvstore with their revision and last update",
+        "responses": {
+          "200": {
+            "description": "Success",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/NodeStoreEntry"
+              }
+            }
+          },
+          "404": {
+            "description": "Node store not available"
+          }
+        }
+      }
+    },
     "/config": {
       "get": {
         "description": "Returns the configuration of the Cilium daemon.\n",
@@ -877,6 +900,46 @@ func init() {
         }
       }
     },
+    "/metrics/bpf": {
+      "delete": {
+        "description": "Zeroes the drop and forward counters of the BPF metrics map and the\ncorresponding prometheus metrics, optionally restricted to a reason\nand direction.\n",
+        "tags": [
+          "metrics"
+        ],
+        "summary": "Reset BPF datapath metrics",
+        "parameters": [
+          {
+            "type": "integer",
+            "description": "Drop or forward reason code, requires direction",
+            "name": "reason",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "description": "Traffic direction (INGRESS or EGRESS), requires reason",
+            "name": "direction",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success"
+          },
+          "400": {
+            "description": "Invalid request (error parsing parameters)",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "500": {
+            "description": "Metrics could not be reset",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
     "/policy": {
       "get": {
         "description": "Returns the entire policy tree with all children.\n",
@@ -2362,6 +2425,10 @@ func init() {
       "description": "Metric information",
       "type": "object",
       "properties": {
+        "hint": {
+          "description": "Machine-readable remediation hint naming what to check, only\nset for non-zero drop counters with a known drop reason\n",
+          "type": "string"
+        },
         "labels": {
           "description": "Labels of the metric",
           "type": "object",
@@ -2462,6 +2529,29 @@ func init() {
         }
       }
     },
+    "NodeStoreEntry": {
+      "description": "Node as stored in the kvstore with metadata on its freshness",
+      "properties": {
+        "delete-at": {
+          "description": "Time at which the pending deletion of the node is handled. Only set\nfor nodes deleted from the kvstore within their delete delay.\n",
+          "type": "string",
+          "format": "date-time"
+        },
+        "last-update": {
+          "description": "Time the last update of the node was received from the kvstore",
+          "type": "string",
+          "format": "date-time"
+        },
+        "mod-revision": {
+          "description": "kvstore revision at which the node was last modified, 0 if unknown",
+          "type": "integer"
+        },
+        "name": {
+          "description": "Name of the node including the cluster association. This is typically\n\u003cclustername\u003e/\u003chostname\u003e.\n",
+          "type": "string"
+        }
+      }
+    },
     "Policy": {
       "description": "Policy definition",
       "type": "object",
@@ -2993,6 +3083,29 @@ func init() {
         }
       }
     },
+    "/cluster/nodes/store": {
+      "get": {
+        "description": "Retrieves all nodes of the kvstore node store as seen by this agent.\nNodes whose last update lags far behind the others may have stopped\nrefreshing their entry.\n",
+        "tags": [
+          "daemon"
+        ],
+        "summary": "Get nodes stored in the kvstore with their revision and last update",
+        "responses": {
+          "200": {
+            "description": "Success",
+            "schema": {
+              "type": "array",
+              "items": {
+                "$ref": "#/definitions/NodeStoreEntry"
+              }
+            }
+          },
+          "404": {
+            "description": "Node store not available"
+          }
+        }
+      }
+    },
     "/config": {
       "get": {
         "description": "Returns the configuration of the Cilium daemon.\n",
@@ -3919,6 +4032,46 @@ func init() {
         }
       }
     },
+    "/metrics/bpf": {
+      "delete": {
+        "description": "Zeroes the drop and forward counters of the BPF metrics map and the\ncorresponding prometheus metrics, optionally restricted to a reason\nand direction.\n",
+        "tags": [
+          "metrics"
+        ],
+        "summary": "Reset BPF datapath metrics",
+        "parameters": [
+          {
+            "type": "integer",
+            "description": "Drop or forward reason code, requires direction",
+            "name": "reason",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "description": "Traffic direction (INGRESS or EGRESS), requires reason",
+            "name": "direction",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success"
+          },
+          "400": {
+            "description": "Invalid request (error parsing parameters)",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "500": {
+            "description": "Metrics could not be reset",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          }
+        }
+      }
+    },
     "/policy": {
       "get": {
         "description": "Returns the entire policy tree with all children.\n",
@@ -5439,6 +5592,10 @@ func init() {
       "description": "Metric information",
       "type": "object",
       "properties": {
+        "hint": {
+          "description": "Machine-readable remediation hint naming what to check, only\nset for non-zero drop counters with a known drop reason\n",
+          "type": "string"
+        },
         "labels": {
           "description": "Labels of the metric",
           "type": "object",
@@ -5539,6 +5696,29 @@ func init() {
         }
       }
     },
+    "NodeStoreEntry": {
+      "description": "Node as stored in the kvstore with metadata on its freshness",
+      "properties": {
+        "delete-at": {
+          "description": "Time at which the pending deletion of the node is handled. Only set\nfor nodes deleted from the kvstore within their delete delay.\n",
+          "type": "string",
+          "format": "date-time"
+        },
+        "last-update": {
+          "description": "Time the last update of the node was received from the kvstore",
+          "type": "string",
+          "format": "date-time"
+        },
+        "mod-revision": {
+          "description": "kvstore revision at which the node was last modified, 0 if unknown",
+          "type": "integer"
+        },
+        "name": {
+          "description": "Name of the node including the cluster association. This is typically\n\u003cclustername\u003e/\u003chostname\u003e.\n",
+          "type": "string"
+        }
+      }
+    },
     "Policy": {
       "description": "Policy definition",
       "type": "object",
@@ -54,6 +54,9 @@ func NewCiliumAPI(spec *loads.Document) *CiliumAPI {
 		IPAMDeleteIPAMIPHandler: ipam.DeleteIPAMIPHandlerFunc(func(params ipam.DeleteIPAMIPParams) middleware.Responder {
 			return middleware.NotImplemented("operation IPAMDeleteIPAMIP has not yet been implemented")
 		}),
+		MetricsDeleteMetricsBpfHandler: metrics.DeleteMetricsBpfHandlerFunc(func(params metrics.DeleteMetricsBpfParams) middleware.Responder {
+			return middleware.NotImplemented("operation MetricsDeleteMetricsBpf has not yet been implemented")
+		}),
 		PolicyDeletePolicyHandler: policy.DeletePolicyHandlerFunc(func(params policy.DeletePolicyParams) middleware.Responder {
 			return middleware.NotImplemented("operation PolicyDeletePolicy has not yet been implemented")
 		}),
@@ -63,6 +66,9 @@ func NewCiliumAPI(spec *loads.Document) *CiliumAPI {
 		DaemonGetClusterNodesHandler: daemon.GetClusterNodesHandlerFunc(func(params daemon.GetClusterNodesParams) middleware.Responder {
 			return middleware.NotImplemented("operation DaemonGetClusterNodes has not yet been implemented")
 		}),
+		DaemonGetClusterNodesStoreHandler: daemon.GetClusterNodesStoreHandlerFunc(func(params daemon.GetClusterNodesStoreParams) middleware.Responder {
+			return middleware.NotImplemented("operation DaemonGetClusterNodesStore has not yet been implemented")
+		}),
 		DaemonGetConfigHandler: daemon.GetConfigHandlerFunc(func(params daemon.GetConfigParams) middleware.Responder {
 			return middleware.NotImplemented("operation DaemonGetConfig has not yet been implemented")
 		}),
@@ -199,12 +205,16 @@ type CiliumAPI struct {
 	PolicyDeleteFqdnCacheHandler policy.DeleteFqdnCacheHandler
 	// IPAMDeleteIPAMIPHandler sets the operation handler for the delete IP a m IP operation
 	IPAMDeleteIPAMIPHandler ipam.DeleteIPAMIPHandler
+	// MetricsDeleteMetricsBpfHandler sets the operation handler for the delete metrics bpf operation
+	MetricsDeleteMetricsBpfHandler metrics.DeleteMetricsBpfHandler
 	// PolicyDeletePolicyHandler sets the operation handler for the delete policy operation
 	PolicyDeletePolicyHandler policy.DeletePolicyHandler
 	// ServiceDeleteServiceIDHandler sets the operation handler for the delete service ID operation
 	ServiceDeleteServiceIDHandler service.DeleteServiceIDHandler
 	// DaemonGetClusterNodesHandler sets the operation handler for the get cluster nodes operation
 	DaemonGetClusterNodesHandler daemon.GetClusterNodesHandler
+	// DaemonGetClusterNodesStoreHandler sets the operation handler for the get cluster nodes store operation
+	DaemonGetClusterNodesStoreHandler daemon.GetClusterNodesStoreHandler
 	// DaemonGetConfigHandler sets the operation handler for the get config operation
 	DaemonGetConfigHandler daemon.GetConfigHandler
 	// DaemonGetDebuginfoHandler sets the operation handler for the get debuginfo operation
@@ -346,6 +356,10 @@ func (o *CiliumAPI) Validate() error {
 		unregistered = append(unregistered, "ipam.DeleteIPAMIPHandler")
 	}
 
+	if o.MetricsDeleteMetricsBpfHandler == nil {
+		unregistered = append(unregistered, "metrics.DeleteMetricsBpfHandler")
+	}
+
 	if o.PolicyDeletePolicyHandler == nil {
 		unregistered = append(unregistered, "policy.DeletePolicyHandler")
 	}
@@ -358,6 +372,10 @@ func (o *CiliumAPI) Validate() error {
 		unregistered = append(unregistered, "daemon.GetClusterNodesHandler")
 	}
 
+	if o.DaemonGetClusterNodesStoreHandler == nil {
+		unregistered = append(unregistered, "daemon.GetClusterNodesStoreHandler")
+	}
+
 	if o.DaemonGetConfigHandler == nil {
 		unregistered = append(unregistered, "daemon.GetConfigHandler")
 	}
@@ -603,6 +621,11 @@ func (o *CiliumAPI) initHandlerCache() {
 	}
 	o.handlers["DELETE"]["/ipam/{ip}"] = ipam.NewDeleteIPAMIP(o.context, o.IPAMDeleteIPAMIPHandler)
 
+	if o.handlers["DELETE"] == nil {
+		o.handlers["DELETE"] = make(map[string]http.Handler)
+	}
+	o.handlers["DELETE"]["/metrics/bpf"] = metrics.NewDeleteMetricsBpf(o.context, o.MetricsDeleteMetricsBpfHandler)
+
 	if o.handlers["DELETE"] == nil {
 		o.handlers["DELETE"] = make(map[string]http.Handler)
 	}
@@ -618,6 +641,11 @@ func (o *CiliumAPI) initHandlerCache() {
 	}
 	o.handlers["GET"]["/cluster/nodes"] = daemon.NewGetClusterNodes(o.context, o.DaemonGetClusterNodesHandler)
 
+	if o.handlers["GET"] == nil {
+		o.handlers["GET"] = make(map[string]http.Handler)
+	}
+	o.handlers["GET"]["/cluster/nodes/store"] = daemon.NewGetClusterNodesStore(o.context, o.DaemonGetClusterNodesStoreHandler)
+
 	if o.handlers["GET"] == nil {
 		o.handlers["GET"] = make(map[string]http.Handler)
 	}
@@ -15,6 +15,8 @@
  *  along with this program; if not, write to the Free Software
  *  Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
  */
+#define __MAGIC_FILE__ 8
+
 #include <linux/if_packet.h>
 
 #include <node_config.h>
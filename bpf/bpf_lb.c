@@ -31,6 +31,8 @@
  *  - LB_L4           - Enable L4 matching and mapping
  */
 
+#define __MAGIC_FILE__ 4
+
 #define DISABLE_LOOPBACK_LB
 
 #include <node_config.h>
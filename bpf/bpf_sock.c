@@ -16,6 +16,8 @@
  *  Foundation, Inc., 51 Franklin St, Fifth Floor, Boston, MA  02110-1301  USA
  */
 
+#define __MAGIC_FILE__ 6
+
 #include <node_config.h>
 #include <netdev_config.h>
 
@@ -24,6 +24,13 @@
 #include "dbg.h"
 #include "drop.h"
 
+/* Drops raised in this header are attributed to it rather than to the
+ * program including it, see sourceFiles in pkg/maps/metricsmap.
+ */
+#pragma push_macro("__MAGIC_FILE__")
+#undef __MAGIC_FILE__
+#define __MAGIC_FILE__ 11
+
 struct arp_eth {
 	unsigned char		ar_sha[ETH_ALEN];
 	__be32                  ar_sip;
@@ -93,4 +100,6 @@ error:
 	return send_drop_notify_error(skb, 0, ret, TC_ACT_SHOT, METRIC_EGRESS);
 }
 
+#pragma pop_macro("__MAGIC_FILE__")
+
 #endif /* __LIB_ARP__ */
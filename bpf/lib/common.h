@@ -208,7 +208,9 @@ struct metrics_key {
     __u8      reason;     //0: forwarded, >0 dropped
     __u8      dir:2,      //1: ingress 2: egress
               pad:6;
-    __u16     reserved[3]; // reserved for future extension
+    __u16     line;       // line of the drop, 0 if unknown
+    __u8      file;       // __MAGIC_FILE__ of the drop, 0 if unknown
+    __u8      reserved[3]; // reserved for future extension
 };
 
 
@@ -217,6 +219,15 @@ struct metrics_value {
      __u64	bytes;
 };
 
+/* Key of the endpoint metrics map, the value is struct metrics_value */
+struct ep_metrics_key {
+    __u16     ep_id;      // endpoint ID
+    __u8      reason;     //0: forwarded, >0 dropped
+    __u8      dir:2,      //1: ingress 2: egress
+              pad:6;
+    __u32     reserved;   // reserved for future extension
+};
+
 
 enum {
 	CILIUM_NOTIFY_UNSPEC,
@@ -23,6 +23,9 @@
  * int send_drop_notify_error(skb, error, exitcode, __u8 direction)
  *
  * If DROP_NOTIFY is not defined, the API will be compiled in as a NOP.
+ *
+ * Both are macros recording the line and __MAGIC_FILE__ of the caller in
+ * the metrics map.
  */
 
 #ifndef __LIB_DROP__
@@ -84,13 +87,16 @@ __section_tail(CILIUM_MAP_CALLS, CILIUM_CALL_DROP_NOTIFY) int __send_drop_notify
  * @dst_id:	designated destination endpoint ID
  * @reason:	Reason for drop
  * @exitcode:	error code to return to the kernel
+ * @line:	line of the caller
+ * @file:	__MAGIC_FILE__ of the caller
  *
  * Generate a notification to indicate a packet was dropped.
  *
  * NOTE: This is terminal function and will cause the BPF program to exit
  */
-static inline int send_drop_notify(struct __sk_buff *skb, __u32 src, __u32 dst,
-				   __u32 dst_id, int reason, int exitcode, __u8 direction)
+static inline int send_drop_notify_location(struct __sk_buff *skb, __u32 src, __u32 dst,
+					    __u32 dst_id, int reason, int exitcode, __u8 direction,
+					    __u16 line, __u8 file)
 {
 	skb->cb[0] = src;
 	skb->cb[1] = dst;
@@ -98,7 +104,7 @@ static inline int send_drop_notify(struct __sk_buff *skb, __u32 src, __u32 dst,
 	skb->cb[3] = dst_id;
 	skb->cb[4] = exitcode;
 
-	update_metrics(skb->len, direction, -reason);
+	update_metrics_location(skb->len, direction, -reason, line, file);
 
 	ep_tail_call(skb, CILIUM_CALL_DROP_NOTIFY);
 
@@ -107,19 +113,22 @@ static inline int send_drop_notify(struct __sk_buff *skb, __u32 src, __u32 dst,
 
 #else
 
-static inline int send_drop_notify(struct __sk_buff *skb, __u32 src, __u32 dst,
-				   __u32 dst_id, int reason, int exitcode, __u8 direction)
+static inline int send_drop_notify_location(struct __sk_buff *skb, __u32 src, __u32 dst,
+					    __u32 dst_id, int reason, int exitcode, __u8 direction,
+					    __u16 line, __u8 file)
 {
-	update_metrics(skb->len, direction, -reason);
+	update_metrics_location(skb->len, direction, -reason, line, file);
 	return exitcode;
 }
 
 #endif
 
-static inline int send_drop_notify_error(struct __sk_buff *skb, __u32 src, int error,
-                                         int exitcode, __u8 direction)
-{
-	return send_drop_notify(skb, src, 0, 0, error, exitcode, direction);
-}
+#define send_drop_notify(skb, src, dst, dst_id, reason, exitcode, direction)	\
+	send_drop_notify_location(skb, src, dst, dst_id, reason, exitcode,	\
+				  direction, __LINE__, __MAGIC_FILE__)
+
+#define send_drop_notify_error(skb, src, error, exitcode, direction)		\
+	send_drop_notify_location(skb, src, 0, 0, error, exitcode,		\
+				  direction, __LINE__, __MAGIC_FILE__)
 
 #endif /* __LIB_DROP__ */
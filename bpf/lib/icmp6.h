@@ -24,6 +24,13 @@
 #include "eth.h"
 #include "drop.h"
 
+/* Drops raised in this header are attributed to it rather than to the
+ * program including it, see sourceFiles in pkg/maps/metricsmap.
+ */
+#pragma push_macro("__MAGIC_FILE__")
+#undef __MAGIC_FILE__
+#define __MAGIC_FILE__ 12
+
 #define ICMP6_TYPE_OFFSET (sizeof(struct ipv6hdr) + offsetof(struct icmp6hdr, icmp6_type))
 #define ICMP6_CSUM_OFFSET (sizeof(struct ipv6hdr) + offsetof(struct icmp6hdr, icmp6_cksum))
 #define ICMP6_ND_TARGET_OFFSET (sizeof(struct ipv6hdr) + sizeof(struct icmp6hdr))
@@ -417,4 +424,6 @@ static inline int icmp6_handle(struct __sk_buff *skb, int nh_off,
 	return 0;
 }
 
+#pragma pop_macro("__MAGIC_FILE__")
+
 #endif
@@ -42,6 +42,17 @@ struct bpf_elf_map __section_maps METRICS_MAP = {
 	.flags		= CONDITIONAL_PREALLOC,
 };
 
+#ifdef ENABLE_EP_METRICS
+struct bpf_elf_map __section_maps EP_METRICS_MAP = {
+	.type		= BPF_MAP_TYPE_PERCPU_HASH,
+	.size_key	= sizeof(struct ep_metrics_key),
+	.size_value	= sizeof(struct metrics_value),
+	.pinning	= PIN_GLOBAL_NS,
+	.max_elem	= EP_METRICS_MAP_SIZE,
+	.flags		= CONDITIONAL_PREALLOC,
+};
+#endif
+
 #ifndef SKIP_POLICY_MAP
 /* Global map to jump into policy enforcement of receiving endpoint */
 struct bpf_elf_map __section_maps POLICY_CALL_MAP = {
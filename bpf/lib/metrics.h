@@ -31,22 +31,64 @@
 #include <stdint.h>
 #include <stdbool.h>
 
+/* __MAGIC_FILE__ identifies the program source file in the metrics key. It
+ * is defined by each program before including any header and redefined by
+ * headers raising drops themselves. It must be in sync with sourceFiles in
+ * pkg/maps/metricsmap/metricsmap.go.
+ */
+#ifndef __MAGIC_FILE__
+#define __MAGIC_FILE__ 0
+#endif
 
+#ifdef ENABLE_EP_METRICS
 /**
- * update_metrics
+ * update_ep_metrics
+ * @ep_id:	ID of the endpoint the packet is forwarded or dropped for
+ * @direction:	1: Ingress 2: Egress
+ * @reason:	reason for forwarding or dropping packet.
+ * Update the endpoint metrics map.
+ */
+static inline void update_ep_metrics(__u32 bytes, __u16 ep_id, __u8 direction,
+				     __u8 reason)
+{
+    struct metrics_value *entry, newEntry = {};
+    struct ep_metrics_key key = {};
+
+    key.ep_id  = ep_id;
+    key.reason = reason;
+    key.dir    = direction;
+
+    if ((entry = map_lookup_elem(&EP_METRICS_MAP, &key))) {
+            entry->count += 1;
+            entry->bytes += (__u64)bytes;
+    } else {
+            newEntry.count = 1;
+            newEntry.bytes = (__u64)bytes;
+            map_update_elem(&EP_METRICS_MAP, &key, &newEntry, 0);
+    }
+}
+#endif /* ENABLE_EP_METRICS */
+
+/**
+ * update_metrics_location
  * @direction:	1: Ingress 2: Egress
  * @reason:	reason for forwarding or dropping packet.
             	reason is 0 if packet is being forwarded, else reason
             	is the drop error code.
+ * @line:	line of the source file the packet was dropped at, 0 if unknown
+ * @file:	__MAGIC_FILE__ of the source file, 0 if unknown
  * Update the metrics map.
  */
-static inline void update_metrics(__u32 bytes, __u8 direction, __u8 reason)
+static inline void update_metrics_location(__u32 bytes, __u8 direction, __u8 reason,
+					   __u16 line, __u8 file)
 {
     struct metrics_value *entry, newEntry = {};
     struct metrics_key key = {};
 
     key.reason = reason;
     key.dir    = direction;
+    key.line   = line;
+    key.file   = file;
 
 
     if ((entry = map_lookup_elem(&METRICS_MAP, &key))) {
@@ -57,6 +99,24 @@ static inline void update_metrics(__u32 bytes, __u8 direction, __u8 reason)
             newEntry.bytes = (__u64)bytes;
             map_update_elem(&METRICS_MAP, &key, &newEntry, 0);
     }
+
+#if defined LXC_ID && defined ENABLE_EP_METRICS
+    /* Programs attached to an endpoint additionally account the packet
+     * to the endpoint if the metrics labeled by endpoint are enabled.
+     */
+    update_ep_metrics(bytes, LXC_ID, direction, reason);
+#endif
+}
+
+/**
+ * update_metrics
+ * @direction:	1: Ingress 2: Egress
+ * @reason:	reason for forwarding or dropping packet.
+ * Update the metrics map without a source location.
+ */
+static inline void update_metrics(__u32 bytes, __u8 direction, __u8 reason)
+{
+    update_metrics_location(bytes, direction, reason, 0, 0);
 }
 
 #endif /* __LIB_METRICS__ */
@@ -31,6 +31,13 @@
 #include "conntrack.h"
 #include "conntrack_map.h"
 
+/* Drops raised in this header are attributed to it rather than to the
+ * program including it, see sourceFiles in pkg/maps/metricsmap.
+ */
+#pragma push_macro("__MAGIC_FILE__")
+#undef __MAGIC_FILE__
+#define __MAGIC_FILE__ 10
+
 enum {
 	NAT_DIR_EGRESS  = TUPLE_F_OUT,
 	NAT_DIR_INGRESS = TUPLE_F_IN,
@@ -958,4 +965,7 @@ static __always_inline int snat_process(struct __sk_buff *skb, int dir)
 #endif
 	return ret;
 }
+
+#pragma pop_macro("__MAGIC_FILE__")
+
 #endif /* __LIB_NAT__ */
@@ -33,6 +33,7 @@ import (
 const (
 	reasonTitle    = "REASON"
 	directionTitle = "DIRECTION"
+	locationTitle  = "LOCATION"
 	packetsTitle   = "PACKETS"
 	bytesTitle     = "BYTES"
 )
@@ -68,17 +69,23 @@ func listMetrics(bpfMetricsList map[string][]string) {
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 5, 0, 3, ' ', 0)
-	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", reasonTitle, directionTitle, packetsTitle, bytesTitle)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", reasonTitle, directionTitle, locationTitle, packetsTitle, bytesTitle)
 
-	const numColumns = 4
+	const numColumns = 5
 	rows := [][numColumns]string{}
 
 	for key, value := range bpfMetricsList {
-		var reason, trafficDirection, packets, bytes string
+		var reason, trafficDirection, location, packets, bytes string
 		var keyIsValid, valueIsValid bool
 		var reasonCode, trafficDirectionCode uint8
 
-		reason, trafficDirection, keyIsValid = extractTwoValues(key)
+		// The location is only present if set by the datapath
+		keyFields := strings.SplitN(key, " location:", 2)
+		if len(keyFields) == 2 {
+			location = keyFields[1]
+		}
+
+		reason, trafficDirection, keyIsValid = extractTwoValues(keyFields[0])
 
 		if keyIsValid {
 			v, err := strconv.Atoi(reason)
@@ -97,14 +104,14 @@ func listMetrics(bpfMetricsList map[string][]string) {
 		}
 
 		if keyIsValid && valueIsValid {
-			rows = append(rows, [numColumns]string{monitorAPI.DropReason(reasonCode), metricsmap.MetricDirection(trafficDirectionCode), packets, bytes})
+			rows = append(rows, [numColumns]string{monitorAPI.DropReason(reasonCode), metricsmap.MetricDirection(trafficDirectionCode), location, packets, bytes})
 		} else {
 			// Fall back to best effort printing.
 			for i, v := range value {
 				if i == 0 {
-					rows = append(rows, [numColumns]string{key, v, "", ""})
+					rows = append(rows, [numColumns]string{key, v, "", "", ""})
 				} else {
-					rows = append(rows, [numColumns]string{"", v, "", ""})
+					rows = append(rows, [numColumns]string{"", v, "", "", ""})
 				}
 			}
 		}
@@ -123,7 +130,7 @@ func listMetrics(bpfMetricsList map[string][]string) {
 	})
 
 	for _, r := range rows {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r[0], r[1], r[2], r[3])
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r[0], r[1], r[2], r[3], r[4])
 	}
 
 	w.Flush()
@@ -0,0 +1,60 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/api/v1/client/metrics"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	bpfMetricsResetReason    int64
+	bpfMetricsResetDirection string
+)
+
+var bpfMetricsResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Reset BPF datapath traffic metrics",
+	Long: `Zeroes the drop and forward counters of the BPF metrics map and the
+corresponding prometheus metrics. If --reason and --direction are given, only
+the counters of that reason and direction are reset.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		params := metrics.NewDeleteMetricsBpfParams()
+
+		reasonSet := cmd.Flags().Changed("reason")
+		directionSet := cmd.Flags().Changed("direction")
+		if reasonSet != directionSet {
+			Fatalf("--reason and --direction must be specified together")
+		}
+		if reasonSet {
+			params.SetReason(&bpfMetricsResetReason)
+			params.SetDirection(&bpfMetricsResetDirection)
+		}
+
+		if _, err := client.Metrics.DeleteMetricsBpf(params); err != nil {
+			Fatalf("Unable to reset BPF metrics: %s", err)
+		}
+		fmt.Println("BPF metrics reset")
+	},
+}
+
+func init() {
+	bpfMetricsCmd.AddCommand(bpfMetricsResetCmd)
+	bpfMetricsResetCmd.Flags().Int64Var(&bpfMetricsResetReason, "reason", 0, "Drop or forward reason code to reset")
+	bpfMetricsResetCmd.Flags().StringVar(&bpfMetricsResetDirection, "direction", "", "Traffic direction to reset (INGRESS or EGRESS)")
+}
@@ -15,11 +15,18 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
 	"github.com/cilium/cilium/pkg/kvstore"
 
 	"github.com/spf13/cobra"
 )
 
+var deleteRateLimit int
+
 var kvstoreDeleteCmd = &cobra.Command{
 	Use:     "delete [options] <key>",
 	Short:   "Delete a key",
@@ -32,9 +39,7 @@ var kvstoreDeleteCmd = &cobra.Command{
 		}
 
 		if recursive {
-			if err := kvstore.DeletePrefix(args[0]); err != nil {
-				Fatalf("Unable to delete keys: %s", err)
-			}
+			deletePrefix(args[0])
 		} else {
 			if err := kvstore.Delete(args[0]); err != nil {
 				Fatalf("Unable to delete key: %s", err)
@@ -46,4 +51,33 @@ var kvstoreDeleteCmd = &cobra.Command{
 func init() {
 	kvstoreCmd.AddCommand(kvstoreDeleteCmd)
 	kvstoreDeleteCmd.Flags().BoolVar(&recursive, "recursive", false, "Recursive lookup")
+	kvstoreDeleteCmd.Flags().IntVar(&deleteRateLimit, "rate-limit", 0, "Maximum number of keys deleted per second when deleting recursively (0 to disable)")
+}
+
+// deletePrefix deletes all keys matching prefix in batches, reporting the
+// progress. The deletion is stopped on interrupt.
+func deletePrefix(prefix string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	defer signal.Stop(signalChan)
+	go func() {
+		select {
+		case <-signalChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	deleted, err := kvstore.DeletePrefixChunked(ctx, prefix, kvstore.DeletePrefixOptions{
+		RateLimit: deleteRateLimit,
+		Progress: func(deleted, total int) {
+			fmt.Printf("Deleted %d/%d keys\n", deleted, total)
+		},
+	})
+	if err != nil {
+		Fatalf("Unable to delete keys (%d keys deleted): %s", deleted, err)
+	}
 }
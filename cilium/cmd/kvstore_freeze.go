@@ -0,0 +1,48 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/identity/cache"
+
+	"github.com/spf13/cobra"
+)
+
+var kvstoreFreezeCmd = &cobra.Command{
+	Use:   "freeze <reason>",
+	Short: "Freeze the allocation of new identities cluster-wide",
+	Long: `Freeze the allocation of new identities by all agents sharing the kvstore.
+Endpoints requiring a new identity are queued until the allocation is thawed
+again, endpoints with labels which already have an identity are unaffected.`,
+	Example: "cilium kvstore freeze \"identity space exhausted\"",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			Fatalf("Please specify the reason for freezing the allocation of identities")
+		}
+
+		setupKvstore()
+
+		if err := cache.FreezeIdentities(context.Background(), strings.Join(args, " ")); err != nil {
+			Fatalf("Unable to freeze the allocation of identities: %s", err)
+		}
+	},
+}
+
+func init() {
+	kvstoreCmd.AddCommand(kvstoreFreezeCmd)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cilium/cilium/pkg/command"
+	"github.com/cilium/cilium/pkg/kvstore"
+	nodeStore "github.com/cilium/cilium/pkg/node/store"
+
+	"github.com/spf13/cobra"
+)
+
+var kvstoreSummariesCmd = &cobra.Command{
+	Use:   "summaries",
+	Short: "List the datapath summaries published by the nodes",
+	Long: `List the summaries of the drops and forwards published by all nodes
+sharing the kvstore. Nodes only publish summaries if enabled with
+--node-summary-interval. A summary is only rewritten when it changes, the
+time of the last change is listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setupKvstore()
+
+		summaries, err := nodeStore.ListNodeSummaries(kvstore.Client())
+		if err != nil {
+			Fatalf("Unable to list node summaries: %s", err)
+		}
+
+		if command.OutputJSON() {
+			if err := command.PrintOutput(summaries); err != nil {
+				os.Exit(1)
+			}
+		} else {
+			formatNodeSummaries(os.Stdout, summaries, time.Now())
+		}
+	},
+}
+
+func init() {
+	kvstoreCmd.AddCommand(kvstoreSummariesCmd)
+	command.AddJSONOutput(kvstoreSummariesCmd)
+}
+
+func formatNodeSummaries(w io.Writer, summaries []*nodeStore.NodeSummary, now time.Time) {
+	tab := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tab, "Cluster\tName\tForwarded Packets\tDropped Packets\tLast Change")
+
+	for _, summary := range summaries {
+		fmt.Fprintf(tab, "%s\t%s\t%d\t%d\t%s ago\n", summary.Cluster, summary.Name,
+			summary.Datapath.ForwardedPackets, summary.Datapath.DroppedPackets,
+			now.Sub(summary.Timestamp).Round(time.Second))
+	}
+	tab.Flush()
+}
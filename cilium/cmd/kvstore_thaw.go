@@ -0,0 +1,38 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/cilium/cilium/pkg/identity/cache"
+
+	"github.com/spf13/cobra"
+)
+
+var kvstoreThawCmd = &cobra.Command{
+	Use:     "thaw",
+	Short:   "Thaw the allocation of new identities frozen with 'cilium kvstore freeze'",
+	Example: "cilium kvstore thaw",
+	Run: func(cmd *cobra.Command, args []string) {
+		setupKvstore()
+
+		if err := cache.ThawIdentities(); err != nil {
+			Fatalf("Unable to thaw the allocation of identities: %s", err)
+		}
+	},
+}
+
+func init() {
+	kvstoreCmd.AddCommand(kvstoreThawCmd)
+}
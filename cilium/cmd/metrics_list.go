@@ -29,7 +29,11 @@ var MetricsListCmd = &cobra.Command{
 
 		w := tabwriter.NewWriter(os.Stdout, 5, 0, 3, ' ', 0)
 
-		fmt.Fprintln(w, "Metric\tLabels\tValue")
+		if verbose {
+			fmt.Fprintln(w, "Metric\tLabels\tValue\tHint")
+		} else {
+			fmt.Fprintln(w, "Metric\tLabels\tValue")
+		}
 		for _, metric := range res.Payload {
 			label := ""
 			if len(metric.Labels) > 0 {
@@ -39,7 +43,11 @@ var MetricsListCmd = &cobra.Command{
 				}
 				label = strings.Join(labelArray, " ")
 			}
-			fmt.Fprintf(w, "%s\t%s\t%f\n", metric.Name, label, metric.Value)
+			if verbose {
+				fmt.Fprintf(w, "%s\t%s\t%f\t%s\n", metric.Name, label, metric.Value, metric.Hint)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%f\n", metric.Name, label, metric.Value)
+			}
 		}
 		w.Flush()
 		os.Exit(0)
@@ -48,5 +56,6 @@ var MetricsListCmd = &cobra.Command{
 
 func init() {
 	metricsCmd.AddCommand(MetricsListCmd)
+	MetricsListCmd.Flags().BoolVar(&verbose, "verbose", false, "Show remediation hints of non-zero drop counters")
 	command.AddJSONOutput(MetricsListCmd)
 }
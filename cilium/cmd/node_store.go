@@ -0,0 +1,84 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	pkg "github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/command"
+
+	"github.com/spf13/cobra"
+)
+
+var nodeStaleAfter time.Duration
+
+var nodeStoreCmd = &cobra.Command{
+	Use:   "store",
+	Short: "List nodes of the kvstore node store",
+	Long: `List all nodes of the kvstore node store as seen by the agent along
+with the kvstore revision at which each node was last modified and the time
+the last update was received. Nodes only update their entry on changes, a
+large age alone does not indicate a problem, but nodes lagging far behind
+nodes known to have changed recently may have stopped refreshing. Nodes
+deleted from the kvstore are listed until their delete delay has passed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		resp, err := client.Daemon.GetClusterNodesStore(nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", pkg.Hint(err))
+			os.Exit(1)
+		}
+
+		if command.OutputJSON() {
+			if err := command.PrintOutput(resp.Payload); err != nil {
+				os.Exit(1)
+			}
+		} else {
+			formatNodeStore(os.Stdout, resp.Payload, time.Now(), nodeStaleAfter)
+		}
+	},
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeStoreCmd)
+	nodeStoreCmd.Flags().DurationVar(&nodeStaleAfter, "stale-after", 0, "Mark nodes not updated for this duration as stale (0 to disable)")
+	command.AddJSONOutput(nodeStoreCmd)
+}
+
+func formatNodeStore(w io.Writer, entries []*models.NodeStoreEntry, now time.Time, staleAfter time.Duration) {
+	tab := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tab, "Name\tRevision\tLast Update\tAge\tStatus")
+
+	for _, entry := range entries {
+		lastUpdate := time.Time(entry.LastUpdate)
+		age := now.Sub(lastUpdate).Round(time.Second)
+
+		status := "ok"
+		if deleteAt := time.Time(entry.DeleteAt); !deleteAt.IsZero() {
+			status = fmt.Sprintf("deleting in %s", deleteAt.Sub(now).Round(time.Second))
+		} else if staleAfter > 0 && age >= staleAfter {
+			status = "stale"
+		}
+
+		fmt.Fprintf(tab, "%s\t%d\t%s\t%s\t%s\n", entry.Name, entry.ModRevision,
+			lastUpdate.Format(time.RFC3339), age, status)
+	}
+	tab.Flush()
+}
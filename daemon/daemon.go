@@ -52,6 +52,7 @@ import (
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/maps/ctmap"
+	"github.com/cilium/cilium/pkg/maps/epmetricsmap"
 	"github.com/cilium/cilium/pkg/maps/eppolicymap"
 	ipcachemap "github.com/cilium/cilium/pkg/maps/ipcache"
 	"github.com/cilium/cilium/pkg/maps/lbmap"
@@ -60,6 +61,7 @@ import (
 	"github.com/cilium/cilium/pkg/maps/policymap"
 	"github.com/cilium/cilium/pkg/maps/sockmap"
 	"github.com/cilium/cilium/pkg/maps/tunnel"
+	"github.com/cilium/cilium/pkg/metrics"
 	monitoragent "github.com/cilium/cilium/pkg/monitor/agent"
 	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
 	"github.com/cilium/cilium/pkg/mtu"
@@ -82,6 +84,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sync/semaphore"
+	k8scache "k8s.io/client-go/tools/cache"
 )
 
 const (
@@ -161,6 +164,15 @@ type Daemon struct {
 	// resource name maps to is closed.
 	k8sResourceSynced map[string]chan struct{}
 
+	// k8sNodeWatcherMu protects k8sNodeStore and k8sNodeHandler
+	k8sNodeWatcherMu lock.Mutex
+
+	// k8sNodeStore is the local cache of the Kubernetes node watcher and
+	// k8sNodeHandler its event handler. Both are nil while Kubernetes
+	// nodes are not watched.
+	k8sNodeStore   k8scache.Store
+	k8sNodeHandler k8scache.ResourceEventHandler
+
 	// k8sSvcCache is a cache of all Kubernetes services and endpoints
 	k8sSvcCache k8s.ServiceCache
 
@@ -409,6 +421,20 @@ func (d *Daemon) initMaps() error {
 		return err
 	}
 
+	// The endpoint metrics map is only populated by the datapath if the
+	// metrics labeled by endpoint are enabled
+	if epmetricsmap.Enabled() {
+		if _, err := epmetricsmap.Metrics.OpenOrCreate(); err != nil {
+			return err
+		}
+		err := epmetricsmap.Init(func(id uint16) bool {
+			return endpointmanager.LookupCiliumID(id) != nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	if _, err := tunnel.TunnelMap.OpenOrCreate(); err != nil {
 		return err
 	}
@@ -425,12 +451,18 @@ func (d *Daemon) initMaps() error {
 	})
 
 	// Start the controller for periodic sync of the metrics map with
-	// the prometheus server.
+	// the prometheus server. Scrapes additionally flush the metrics map
+	// so that they never see values older than the scrape interval.
 	controller.NewManager().UpdateController("metricsmap-bpf-prom-sync",
 		controller.ControllerParams{
 			DoFunc:      metricsmap.SyncMetricsMap,
-			RunInterval: 5 * time.Second,
+			RunInterval: option.Config.MetricsMapSyncInterval,
 		})
+	metrics.RegisterScrapeHook(func(ctx context.Context) {
+		if err := metricsmap.Flush(ctx); err != nil {
+			log.WithError(err).Debug("Unable to flush metrics map before scrape")
+		}
+	})
 
 	// Clean all lb entries
 	if !option.Config.RestoreState {
@@ -770,6 +802,7 @@ func NewDaemon(dp datapath.Datapath) (*Daemon, *endpointRestoreState, error) {
 
 	debug.RegisterStatusObject("k8s-service-cache", &d.k8sSvcCache)
 	debug.RegisterStatusObject("ipam", d.ipam)
+	debug.RegisterStatusFunc("metricsmap-percpu", metricsmap.DebugStatus)
 
 	bootstrapStats.k8sInit.Start()
 	k8s.Configure(option.Config.K8sAPIServer, option.Config.K8sKubeConfigPath)
@@ -807,6 +840,10 @@ func NewDaemon(dp datapath.Datapath) (*Daemon, *endpointRestoreState, error) {
 			log.Info("k8s mode: Allowing localhost to reach local endpoints")
 		}
 
+		// Surface nodes with conflicting allocation CIDRs as events on
+		// the affected Kubernetes nodes
+		nodeMngr.SubscribeCIDRConflicts(k8s.NewNodeEventRecorder(k8s.Client()))
+
 		bootstrapStats.k8sInit.End(true)
 	}
 
@@ -862,6 +899,9 @@ func NewDaemon(dp datapath.Datapath) (*Daemon, *endpointRestoreState, error) {
 	// well known identities have already been initialized above
 	// Ignore the channel returned by this function, as we want the global
 	// identity allocator to run asynchronously.
+	// ipcache upserts are ordered after the identity events passed to
+	// UpdateIdentities() from here on.
+	ipcache.IPIdentityCache.EnableIdentityBarrier()
 	cache.InitIdentityAllocator(&d)
 
 	d.bootstrapClusterMesh(nodeMngr)
@@ -36,6 +36,7 @@ import (
 	"github.com/cilium/cilium/pkg/cgroups"
 	"github.com/cilium/cilium/pkg/cleanup"
 	"github.com/cilium/cilium/pkg/components"
+	"github.com/cilium/cilium/pkg/controller"
 	linuxdatapath "github.com/cilium/cilium/pkg/datapath/linux"
 	"github.com/cilium/cilium/pkg/datapath/loader"
 	"github.com/cilium/cilium/pkg/datapath/maps"
@@ -52,6 +53,7 @@ import (
 	"github.com/cilium/cilium/pkg/loadinfo"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/maps/epmetricsmap"
 	ipcachemap "github.com/cilium/cilium/pkg/maps/ipcache"
 	"github.com/cilium/cilium/pkg/metrics"
 	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
@@ -75,6 +77,7 @@ import (
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
 )
 
 var (
@@ -368,6 +371,9 @@ func init() {
 	flags.Bool(option.BlacklistConflictingRoutes, defaults.BlacklistConflictingRoutes, "Don't blacklist IP allocations conflicting with local non-cilium routes")
 	option.BindEnv(option.BlacklistConflictingRoutes)
 
+	flags.Bool(option.RejectConflictingNodeCIDRs, false, "Refuse to program nodes with allocation CIDRs overlapping with another known node")
+	option.BindEnv(option.RejectConflictingNodeCIDRs)
+
 	flags.Bool(option.AutoCreateCiliumNodeResource, defaults.AutoCreateCiliumNodeResource, "Automatically create CiliumNode resource for own node on startup")
 	option.BindEnv(option.AutoCreateCiliumNodeResource)
 
@@ -524,6 +530,9 @@ func init() {
 	flags.Uint(option.ProxyConnectTimeout, 1, "Time after which a TCP connect attempt is considered failed unless completed (in seconds)")
 	option.BindEnv(option.ProxyConnectTimeout)
 
+	flags.StringSlice(option.ProxylibPassthrough, []string{}, `List of "[<proto>:]<port>" rules for connections to be passed without L7 parsing by proxylib parsers`)
+	option.BindEnv(option.ProxylibPassthrough)
+
 	flags.Bool(option.DisableEnvoyVersionCheck, false, "Do not perform Envoy binary version check on startup")
 	flags.MarkHidden(option.DisableEnvoyVersionCheck)
 	// Disable version check if Envoy build is disabled
@@ -536,6 +545,44 @@ func init() {
 	flags.Duration(option.IdentityChangeGracePeriod, defaults.IdentityChangeGracePeriod, "Time to wait before using new identity on endpoint identity change")
 	option.BindEnv(option.IdentityChangeGracePeriod)
 
+	flags.Duration(option.IdentityQuarantinePeriod, defaults.IdentityQuarantinePeriod, "Time a released identity is held in quarantine before it can be reused (0 to disable)")
+	option.BindEnv(option.IdentityQuarantinePeriod)
+
+	flags.Bool(option.IdentityCompression, false, "Compress large global identities stored in the kvstore (requires all agents to support compressed identities)")
+	option.BindEnv(option.IdentityCompression)
+
+	flags.Bool(option.NodeDeltaUpdates, false, "Publish node changes to the kvstore as deltas against periodic snapshots (requires all agents to support delta updates)")
+	option.BindEnv(option.NodeDeltaUpdates)
+
+	flags.Bool(option.NodePreferLocal, false, "Re-write the local node to the kvstore when it is overwritten by another writer, e.g. a stale instance of the agent")
+	option.BindEnv(option.NodePreferLocal)
+
+	flags.Var(option.NewNamedMapOptions(option.NodeDeleteDelay, &option.Config.NodeDeleteDelay, option.NodeDeleteDelayValidator),
+		option.NodeDeleteDelay, fmt.Sprintf(`Per source delay before a node deletion is handled, e.g. "kvstore=30s" (sources without an entry use %s)`, defaults.NodeDeleteDelay))
+	option.BindEnv(option.NodeDeleteDelay)
+
+	flags.StringSlice(option.NodeAddressTypes, defaults.NodeAddressTypes, "Kubernetes node address types considered for the node IP, in order of preference")
+	option.BindEnv(option.NodeAddressTypes)
+
+	flags.StringSlice(option.NodeAddressPreferredCIDRs, []string{}, "CIDRs whose node addresses take precedence when selecting the node IP, e.g. the network used for tunneling")
+	option.BindEnv(option.NodeAddressPreferredCIDRs)
+
+	flags.StringSlice(option.NodeAddressExcludedCIDRs, []string{}, "CIDRs whose node addresses are ignored")
+	option.BindEnv(option.NodeAddressExcludedCIDRs)
+
+	flags.String(option.NodeRegistrationBackend, option.NodeRegistrationKVStore,
+		fmt.Sprintf("Backend through which the local node is registered and other nodes are discovered (%s, %s)", option.NodeRegistrationKVStore, option.NodeRegistrationCRD))
+	option.BindEnv(option.NodeRegistrationBackend)
+
+	flags.Bool(option.NodeRegistrationDualWrite, false, "Additionally publish the local node to the registration backend not selected by --"+option.NodeRegistrationBackend+", to migrate between backends")
+	option.BindEnv(option.NodeRegistrationDualWrite)
+
+	flags.Bool(option.ResolveNodeAddressDNS, false, "Resolve the InternalDNS and ExternalDNS addresses of nodes into node IPs")
+	option.BindEnv(option.ResolveNodeAddressDNS)
+
+	flags.Duration(option.NodeSummaryInterval, 0, "Interval in which a summary of the drops and forwards of the node is published to the kvstore (0 to disable)")
+	option.BindEnv(option.NodeSummaryInterval)
+
 	flags.String(option.IPAM, "", "Backend to use for IPAM")
 	option.BindEnv(option.IPAM)
 
@@ -560,6 +607,18 @@ func init() {
 	flags.Bool(option.K8sEventHandover, defaults.K8sEventHandover, "Enable k8s event handover to kvstore for improved scalability")
 	option.BindEnv(option.K8sEventHandover)
 
+	flags.Bool(option.K8sRewriteLegacyNodeAnnotations, false, "Rewrite node annotations written by older versions of Cilium to the current representation")
+	option.BindEnv(option.K8sRewriteLegacyNodeAnnotations)
+
+	flags.Duration(option.K8sNodeResyncPeriod, defaults.K8sNodeResyncPeriod, "Period in which all Kubernetes nodes are resynced from the local cache (0 to disable)")
+	option.BindEnv(option.K8sNodeResyncPeriod)
+
+	flags.Duration(option.K8sNodeRelistBackoffMin, defaults.K8sNodeRelistBackoffMin, "Minimum backoff between attempts to re-list a single Kubernetes node")
+	option.BindEnv(option.K8sNodeRelistBackoffMin)
+
+	flags.Duration(option.K8sNodeRelistBackoffMax, defaults.K8sNodeRelistBackoffMax, "Maximum backoff between attempts to re-list a single Kubernetes node")
+	option.BindEnv(option.K8sNodeRelistBackoffMax)
+
 	flags.String(option.K8sAPIServer, "", "Kubernetes api address server (for https use --k8s-kubeconfig-path instead)")
 	option.BindEnv(option.K8sAPIServer)
 
@@ -599,10 +658,43 @@ func init() {
 	flags.Duration(option.KVstorePeriodicSync, defaults.KVstorePeriodicSync, "Periodic KVstore synchronization interval")
 	option.BindEnv(option.KVstorePeriodicSync)
 
+	flags.Duration(option.KVstoreStoreReconcileInterval, defaults.KVstoreStoreReconcileInterval, "Interval in which shared stores are reconciled with the kvstore to repair missed events (0 to disable)")
+	option.BindEnv(option.KVstoreStoreReconcileInterval)
+
+	flags.Int(option.KVstoreMaxValueSize, defaults.KVstoreMaxValueSize, "Maximum size in bytes of a kvstore value, oversized values are rejected on write and ignored on read (0 to disable)")
+	option.BindEnv(option.KVstoreMaxValueSize)
+
+	flags.Int(option.KVstoreRateLimitReads, defaults.KVstoreRateLimit, "Maximum rate of kvstore read operations per second (0 to disable)")
+	option.BindEnv(option.KVstoreRateLimitReads)
+
+	flags.Int(option.KVstoreRateLimitWrites, defaults.KVstoreRateLimit, "Maximum rate of kvstore write operations per second (0 to disable)")
+	option.BindEnv(option.KVstoreRateLimitWrites)
+
+	flags.Int(option.KVstoreRateLimitLocks, defaults.KVstoreRateLimit, "Maximum rate of kvstore lock acquisitions per second (0 to disable)")
+	option.BindEnv(option.KVstoreRateLimitLocks)
+
+	flags.Int(option.KVstoreRateLimitBackground, defaults.KVstoreRateLimit, "Maximum rate of background kvstore operations per second, limited separately from foreground operations (0 to disable)")
+	option.BindEnv(option.KVstoreRateLimitBackground)
+
+	flags.Bool(option.KVstoreLockFairQueuing, false, "Acquire local kvstore locks in order of arrival so that lock attempts cannot be starved")
+	option.BindEnv(option.KVstoreLockFairQueuing)
+
+	flags.Int(option.KVstoreCircuitBreakerThreshold, defaults.KVstoreCircuitBreakerThreshold, "Number of consecutive failed kvstore operations after which operations fail immediately or are served from local caches (0 to disable)")
+	option.BindEnv(option.KVstoreCircuitBreakerThreshold)
+
+	flags.Duration(option.KVstoreCircuitBreakerTimeout, defaults.KVstoreCircuitBreakerTimeout, "Time the kvstore circuit breaker stays open before operations are attempted again")
+	option.BindEnv(option.KVstoreCircuitBreakerTimeout)
+
+	flags.Duration(option.KVstoreCircuitBreakerMaxAge, defaults.KVstoreCircuitBreakerMaxAge, "Maximum age of cached kvstore reads served while the kvstore circuit breaker is open")
+	option.BindEnv(option.KVstoreCircuitBreakerMaxAge)
+
 	flags.Var(option.NewNamedMapOptions(option.KVStoreOpt, &option.Config.KVStoreOpt, nil),
 		option.KVStoreOpt, "Key-value store options")
 	option.BindEnv(option.KVStoreOpt)
 
+	flags.String(option.KVStoreTenant, "", "Tenant prefix injected into all kvstore keys to share a kvstore between multiple installations")
+	option.BindEnv(option.KVStoreTenant)
+
 	flags.Uint(option.K8sWatcherQueueSize, 1024, "Queue size used to serialize each k8s event type")
 	option.BindEnv(option.K8sWatcherQueueSize)
 
@@ -653,6 +745,9 @@ func init() {
 	flags.StringSlice(option.Metrics, []string{}, "Metrics that should be enabled or disabled from the default metric list. (+metric_foo to enable metric_foo , -metric_bar to disable metric_bar)")
 	option.BindEnv(option.Metrics)
 
+	flags.Duration(option.MetricsMapSyncInterval, defaults.MetricsMapSyncInterval, "Interval in which the BPF metrics map is synced with the prometheus metrics")
+	option.BindEnv(option.MetricsMapSyncInterval)
+
 	flags.String(option.MonitorAggregationName, "None",
 		"Level of monitor aggregation for traces from the datapath")
 	option.BindEnvWithLegacyEnvFallback(option.MonitorAggregationName, "CILIUM_MONITOR_AGGREGATION_LEVEL")
@@ -790,6 +885,9 @@ func init() {
 	flags.String(option.WriteCNIConfigurationWhenReady, "", fmt.Sprintf("Write the CNI configuration as specified via --%s to path when agent is ready", option.ReadCNIConfiguration))
 	option.BindEnv(option.WriteCNIConfigurationWhenReady)
 
+	flags.String(option.AgentNotReadyTaintKey, "", "Key of the taint to remove from the Kubernetes node when the agent is ready and to add back on shutdown, e.g. node.cilium.io/agent-not-ready")
+	option.BindEnv(option.AgentNotReadyTaintKey)
+
 	viper.BindPFlags(flags)
 }
 
@@ -1247,6 +1345,23 @@ func (d *Daemon) initKVStore() {
 		}
 	}
 
+	// The etcd endpoints can be discovered from the endpoints of a
+	// Kubernetes service. The service cache is kept up to date by the k8s
+	// watcher so that changes of the etcd members are picked up by the
+	// periodic refresh of the endpoints.
+	if k8s.IsEnabled() && option.Config.KVStoreOpt[kvstore.EtcdDiscoveryServiceOption] != "" {
+		d.waitForCacheSync(k8sAPIGroupEndpointV1Core)
+		goopts.ServiceEndpoints = func(namespace, name string) ([]string, error) {
+			svcID := k8s.ServiceID{Namespace: namespace, Name: name}
+			return d.k8sSvcCache.GetBackendAddrs(svcID, kvstore.EtcdServicePortName), nil
+		}
+	}
+
+	// The kubernetes backend stores keys in ConfigMaps and Leases
+	if k8s.IsEnabled() && option.Config.KVStore == kvstore.KubernetesBackendName {
+		goopts.KubernetesClient = k8s.Client()
+	}
+
 	if err := kvstore.Setup(option.Config.KVStore, option.Config.KVStoreOpt, goopts); err != nil {
 		addrkey := fmt.Sprintf("%s.address", option.Config.KVStore)
 		addr := option.Config.KVStoreOpt[addrkey]
@@ -1347,6 +1462,9 @@ func runDaemon() {
 			d.dnsNameManager.CompleteBootstrap()
 			maps.CollectStaleMapGarbage()
 			maps.RemoveDisabledMaps()
+			if epmetricsmap.Enabled() {
+				epmetricsmap.StartGC()
+			}
 		}()
 	}
 
@@ -1406,6 +1524,10 @@ func runDaemon() {
 		}
 	}
 
+	if k8s.IsEnabled() && option.Config.AgentNotReadyTaintKey != "" {
+		manageAgentNotReadyTaint(option.Config.AgentNotReadyTaintKey)
+	}
+
 	errs := make(chan error, 1)
 
 	go func() {
@@ -1427,6 +1549,46 @@ func runDaemon() {
 	}
 }
 
+// agentNotReadyTaintTimeout is the maximum time to wait for the
+// kube-apiserver when adding the agent not ready taint on shutdown
+const agentNotReadyTaintTimeout = 10 * time.Second
+
+// manageAgentNotReadyTaint removes the taint with key taintKey from the
+// Kubernetes node in the background and adds it back when the agent shuts
+// down, so that no pods are scheduled to the node while the agent is not
+// running.
+func manageAgentNotReadyTaint(taintKey string) {
+	nodeName := node.GetName()
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.NodeName: nodeName,
+		"taint":            taintKey,
+	})
+
+	controller.NewManager().UpdateController("remove-k8s-node-agent-not-ready-taint",
+		controller.ControllerParams{
+			DoFunc: func(ctx context.Context) error {
+				if err := k8s.RemoveNodeTaint(ctx, k8s.Client(), nodeName, taintKey); err != nil {
+					scopedLog.WithError(err).Warning("Unable to remove agent not ready taint from node")
+					return err
+				}
+				scopedLog.Info("Removed agent not ready taint from node")
+				return nil
+			},
+		})
+
+	cleanup.DeferTerminationCleanupFunction(cleanUPWg, cleanUPSig, func() {
+		taint := v1.Taint{
+			Key:    taintKey,
+			Effect: v1.TaintEffectNoSchedule,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), agentNotReadyTaintTimeout)
+		defer cancel()
+		if err := k8s.AddNodeTaint(ctx, k8s.Client(), nodeName, taint); err != nil {
+			scopedLog.WithError(err).Warning("Unable to add agent not ready taint to node")
+		}
+	})
+}
+
 func (d *Daemon) instantiateAPI() *restapi.CiliumAPI {
 
 	swaggerSpec, err := loads.Analyzed(server.SwaggerJSON, "")
@@ -1445,6 +1607,9 @@ func (d *Daemon) instantiateAPI() *restapi.CiliumAPI {
 	// /cluster/nodes
 	api.DaemonGetClusterNodesHandler = NewGetClusterNodesHandler(d)
 
+	// /cluster/nodes/store
+	api.DaemonGetClusterNodesStoreHandler = NewGetClusterNodesStoreHandler(d)
+
 	// /config/
 	api.DaemonGetConfigHandler = NewGetConfigHandler(d)
 	api.DaemonPatchConfigHandler = NewPatchConfigHandler(d)
@@ -1514,6 +1679,7 @@ func (d *Daemon) instantiateAPI() *restapi.CiliumAPI {
 
 	// metrics
 	api.MetricsGetMetricsHandler = NewGetMetricsHandler(d)
+	api.MetricsDeleteMetricsBpfHandler = NewDeleteMetricsBPFHandler()
 
 	// /fqdn/cache
 	api.PolicyGetFqdnCacheHandler = NewGetFqdnCacheHandler(d)
@@ -77,7 +77,7 @@ func (d *Daemon) initHealth() {
 				if client == nil || err != nil {
 					var launchErr error
 					d.cleanupHealthEndpoint()
-					client, launchErr = health.LaunchAsEndpoint(ctx, d, &d.nodeDiscovery.LocalNode, d.mtuConfig)
+					client, launchErr = health.LaunchAsEndpoint(ctx, d, d.nodeDiscovery.GetLocalNode(), d.mtuConfig)
 					if launchErr != nil {
 						if err != nil {
 							return fmt.Errorf("failed to restart endpoint (check failed: %q): %s", err, launchErr)
@@ -105,7 +105,7 @@ func (d *Daemon) initHealth() {
 }
 
 func (d *Daemon) cleanupHealthEndpoint() {
-	localNode := d.nodeDiscovery.LocalNode
+	localNode := d.nodeDiscovery.GetLocalNode()
 
 	// Delete the process
 	health.KillEndpoint()
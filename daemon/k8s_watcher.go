@@ -26,6 +26,7 @@ import (
 	"time"
 
 	"github.com/cilium/cilium/pkg/annotation"
+	"github.com/cilium/cilium/pkg/backoff"
 	"github.com/cilium/cilium/pkg/comparator"
 	"github.com/cilium/cilium/pkg/controller"
 	"github.com/cilium/cilium/pkg/endpointmanager"
@@ -90,6 +91,16 @@ const (
 	metricUpdate   = "update"
 )
 
+const (
+	// nodeResyncPeriodic is the reason of node resyncs performed by the
+	// periodic resync of the node watcher
+	nodeResyncPeriodic = "periodic"
+
+	// nodeResyncForced is the reason of node resyncs requested via
+	// ResyncK8sNode()
+	nodeResyncForced = "forced"
+)
+
 var (
 	k8sCM = controller.NewManager()
 
@@ -844,70 +855,84 @@ func (d *Daemon) EnableK8sWatcher(queueSize uint) error {
 	go func() {
 		var once sync.Once
 		for {
-			_, nodeController := informer.NewInformer(
-				cache.NewListWatchFromClient(k8s.Client().CoreV1().RESTClient(),
-					"nodes", v1.NamespaceAll, fields.Everything()),
-				&v1.Node{},
-				0,
-				cache.ResourceEventHandlerFuncs{
-					AddFunc: func(obj interface{}) {
-						var valid, equal bool
-						defer func() { d.K8sEventReceived(metricNode, metricCreate, valid, equal) }()
-						if Node := k8s.CopyObjToV1Node(obj); Node != nil {
-							valid = true
+			nodeHandler := cache.ResourceEventHandlerFuncs{
+				AddFunc: func(obj interface{}) {
+					var valid, equal bool
+					defer func() { d.K8sEventReceived(metricNode, metricCreate, valid, equal) }()
+					if Node := k8s.CopyObjToV1Node(obj); Node != nil {
+						valid = true
+						serNodes.Enqueue(func() error {
+							start := time.Now()
+							err := d.addK8sNodeV1(Node)
+							metrics.KubernetesNodeEventHandlerDuration.WithLabelValues(metricCreate).Observe(time.Since(start).Seconds())
+							d.K8sEventProcessed(metricNode, metricCreate, err == nil)
+							return nil
+						}, serializer.NoRetry)
+					}
+				},
+				UpdateFunc: func(oldObj, newObj interface{}) {
+					var valid, equal bool
+					defer func() { d.K8sEventReceived(metricNode, metricUpdate, valid, equal) }()
+					if oldNode := k8s.CopyObjToV1Node(oldObj); oldNode != nil {
+						valid = true
+						if newNode := k8s.CopyObjToV1Node(newObj); newNode != nil {
+							// Updates without a new resource version
+							// are caused by the periodic resync
+							if oldNode.ResourceVersion == newNode.ResourceVersion {
+								metrics.KubernetesNodeResyncs.WithLabelValues(nodeResyncPeriodic).Inc()
+							}
+
+							if k8s.EqualV1Node(oldNode, newNode) {
+								equal = true
+								return
+							}
+
 							serNodes.Enqueue(func() error {
-								err := d.addK8sNodeV1(Node)
-								d.K8sEventProcessed(metricNode, metricCreate, err == nil)
+								start := time.Now()
+								err := d.updateK8sNodeV1(oldNode, newNode)
+								metrics.KubernetesNodeEventHandlerDuration.WithLabelValues(metricUpdate).Observe(time.Since(start).Seconds())
+								d.K8sEventProcessed(metricNode, metricUpdate, err == nil)
 								return nil
 							}, serializer.NoRetry)
 						}
-					},
-					UpdateFunc: func(oldObj, newObj interface{}) {
-						var valid, equal bool
-						defer func() { d.K8sEventReceived(metricNode, metricUpdate, valid, equal) }()
-						if oldNode := k8s.CopyObjToV1Node(oldObj); oldNode != nil {
-							valid = true
-							if newNode := k8s.CopyObjToV1Node(newObj); newNode != nil {
-								if k8s.EqualV1Node(oldNode, newNode) {
-									equal = true
-									return
-								}
-
-								serNodes.Enqueue(func() error {
-									err := d.updateK8sNodeV1(oldNode, newNode)
-									d.K8sEventProcessed(metricNode, metricUpdate, err == nil)
-									return nil
-								}, serializer.NoRetry)
-							}
+					}
+				},
+				DeleteFunc: func(obj interface{}) {
+					var valid, equal bool
+					defer func() { d.K8sEventReceived(metricNode, metricDelete, valid, equal) }()
+					node := k8s.CopyObjToV1Node(obj)
+					if node == nil {
+						deletedObj, ok := obj.(cache.DeletedFinalStateUnknown)
+						if !ok {
+							return
 						}
-					},
-					DeleteFunc: func(obj interface{}) {
-						var valid, equal bool
-						defer func() { d.K8sEventReceived(metricNode, metricDelete, valid, equal) }()
-						node := k8s.CopyObjToV1Node(obj)
+						// Delete was not observed by the watcher but is
+						// removed from kube-apiserver. This is the last
+						// known state and the object no longer exists.
+						node = k8s.CopyObjToV1Node(deletedObj.Obj)
 						if node == nil {
-							deletedObj, ok := obj.(cache.DeletedFinalStateUnknown)
-							if !ok {
-								return
-							}
-							// Delete was not observed by the watcher but is
-							// removed from kube-apiserver. This is the last
-							// known state and the object no longer exists.
-							node = k8s.CopyObjToV1Node(deletedObj.Obj)
-							if node == nil {
-								return
-							}
+							return
 						}
-						valid = true
-						serNodes.Enqueue(func() error {
-							err := d.deleteK8sNodeV1(node)
-							d.K8sEventProcessed(metricNode, metricDelete, err == nil)
-							return nil
-						}, serializer.NoRetry)
-					},
+					}
+					valid = true
+					serNodes.Enqueue(func() error {
+						start := time.Now()
+						err := d.deleteK8sNodeV1(node)
+						metrics.KubernetesNodeEventHandlerDuration.WithLabelValues(metricDelete).Observe(time.Since(start).Seconds())
+						d.K8sEventProcessed(metricNode, metricDelete, err == nil)
+						return nil
+					}, serializer.NoRetry)
 				},
+			}
+			nodeStore, nodeController := informer.NewInformer(
+				cache.NewListWatchFromClient(k8s.Client().CoreV1().RESTClient(),
+					"nodes", v1.NamespaceAll, fields.Everything()),
+				&v1.Node{},
+				option.Config.K8sNodeResyncPeriod,
+				nodeHandler,
 				k8s.ConvertToNode,
 			)
+			d.setK8sNodeWatcher(nodeStore, nodeHandler)
 			isConnected := make(chan struct{})
 			// once isConnected is closed, it will stop waiting on caches to be
 			// synchronized.
@@ -932,6 +957,7 @@ func (d *Daemon) EnableK8sWatcher(queueSize uint) error {
 
 			log.Info("Connected to KVStore, stopping k8s node watcher")
 
+			d.setK8sNodeWatcher(nil, nil)
 			d.k8sAPIGroups.removeAPI(k8sAPIGroupNodeV1Core)
 			// Create a new node controller when we are disconnected with the
 			// kvstore
@@ -1789,8 +1815,21 @@ func (d *Daemon) updateK8sV1Namespace(oldNS, newNS *types.Namespace) error {
 
 func (d *Daemon) updateK8sNodeTunneling(k8sNodeOld, k8sNodeNew *types.Node) error {
 	nodeNew := k8s.ParseNode(k8sNodeNew, node.FromKubernetes)
-	// Ignore own node
+	// Announce nodes about to be terminated so that they can be
+	// deregistered and drained ahead of the node deletion
+	if k8s.IsNodeTerminating(k8sNodeNew) {
+		d.nodeDiscovery.Manager.NodeTerminating(*nodeNew)
+	} else {
+		d.nodeDiscovery.Manager.NodeTerminationCancelled(*nodeNew)
+	}
+
+	// Ignore own node except for label changes which must be published
+	// so that policies selecting the node by labels are updated, and
+	// secondary allocation CIDRs assigned to expand the node
 	if nodeNew.Name == node.GetName() {
+		d.nodeDiscovery.UpdateLocalNodeLabels(nodeNew.Labels)
+		d.nodeDiscovery.UpdateSecondaryAllocCIDRs(nodeNew.IPv4SecondaryAllocCIDRs, nodeNew.IPv6SecondaryAllocCIDRs)
+		d.ipam.UpdateSecondaryAllocRanges(node.GetIPv4SecondaryAllocRanges(), node.GetIPv6SecondaryAllocRanges())
 		return nil
 	}
 
@@ -1920,6 +1959,63 @@ func (d *Daemon) deleteK8sNodeV1(k8sNode *types.Node) error {
 	return nil
 }
 
+// setK8sNodeWatcher sets the local cache and event handler of the running
+// Kubernetes node watcher, or nil if nodes are not watched
+func (d *Daemon) setK8sNodeWatcher(store cache.Store, handler cache.ResourceEventHandler) {
+	d.k8sNodeWatcherMu.Lock()
+	d.k8sNodeStore, d.k8sNodeHandler = store, handler
+	d.k8sNodeWatcherMu.Unlock()
+}
+
+// ResyncK8sNode re-lists the Kubernetes node with the given name from the
+// kube-apiserver and feeds it into the node watcher if it differs from the
+// cached version. This repairs a single node missed by the watcher without
+// re-listing all nodes. Failed attempts are retried with an exponential
+// backoff bounded by option.Config.K8sNodeRelistBackoffMin and
+// option.Config.K8sNodeRelistBackoffMax until ctx is cancelled.
+func (d *Daemon) ResyncK8sNode(ctx context.Context, name string) error {
+	d.k8sNodeWatcherMu.Lock()
+	store, handler := d.k8sNodeStore, d.k8sNodeHandler
+	d.k8sNodeWatcherMu.Unlock()
+
+	if store == nil {
+		return fmt.Errorf("kubernetes nodes are not being watched")
+	}
+
+	scopedLog := log.WithField(logfields.NodeName, name)
+	relistBackoff := backoff.Exponential{
+		Min:  option.Config.K8sNodeRelistBackoffMin,
+		Max:  option.Config.K8sNodeRelistBackoffMax,
+		Name: "k8s-node-relist-" + name,
+	}
+
+	for {
+		k8sNode, err := k8s.GetNode(ctx, k8s.Client(), name, k8s.GetNodeOptions{})
+		if err == nil || k8s.IsNodeNotFound(err) {
+			metrics.KubernetesNodeResyncs.WithLabelValues(nodeResyncForced).Inc()
+
+			var obj interface{}
+			if err == nil {
+				cached, exists, _ := store.GetByKey(name)
+				if cachedNode, ok := cached.(*types.Node); exists && ok &&
+					cachedNode.ResourceVersion == k8sNode.ResourceVersion {
+					scopedLog.Debug("Kubernetes node is up to date, skipping resync")
+					return nil
+				}
+				obj = k8sNode
+			}
+
+			scopedLog.Info("Resyncing Kubernetes node")
+			return informer.ResyncObject(store, handler, k8s.ConvertToNode, name, obj)
+		}
+
+		scopedLog.WithError(err).Warning("Unable to re-list Kubernetes node")
+		if err := relistBackoff.Wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
 // K8sEventProcessed is called to do metrics accounting for each processed
 // Kubernetes event
 func (d *Daemon) K8sEventProcessed(scope string, action string, status bool) {
@@ -16,10 +16,12 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"time"
 
 	restapi "github.com/cilium/cilium/api/v1/server/restapi/metrics"
 	"github.com/cilium/cilium/pkg/api"
+	"github.com/cilium/cilium/pkg/maps/metricsmap"
 	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/option"
 	"github.com/cilium/cilium/pkg/spanstat"
@@ -44,10 +46,51 @@ func (h *getMetrics) Handle(params restapi.GetMetricsParams) middleware.Responde
 			restapi.GetMetricsInternalServerErrorCode,
 			fmt.Errorf("Cannot gather metrics from daemon"))
 	}
+	metricsmap.AddDropHints(metrics)
 
 	return restapi.NewGetMetricsOK().WithPayload(metrics)
 }
 
+type deleteMetricsBPF struct{}
+
+// NewDeleteMetricsBPFHandler returns the handler resetting the BPF metrics
+func NewDeleteMetricsBPFHandler() restapi.DeleteMetricsBpfHandler {
+	return &deleteMetricsBPF{}
+}
+
+func (h *deleteMetricsBPF) Handle(params restapi.DeleteMetricsBpfParams) middleware.Responder {
+	var (
+		n   int
+		err error
+	)
+
+	switch {
+	case params.Reason == nil && params.Direction == nil:
+		n, err = metricsmap.ResetAll()
+	case params.Reason == nil || params.Direction == nil:
+		return api.Error(restapi.DeleteMetricsBpfBadRequestCode,
+			fmt.Errorf("reason and direction must be specified together"))
+	default:
+		if *params.Reason < 0 || *params.Reason > math.MaxUint8 {
+			return api.Error(restapi.DeleteMetricsBpfBadRequestCode,
+				fmt.Errorf("invalid reason %d", *params.Reason))
+		}
+		var dir uint8
+		if dir, err = metricsmap.ParseMetricDirection(*params.Direction); err != nil {
+			return api.Error(restapi.DeleteMetricsBpfBadRequestCode, err)
+		}
+		n, err = metricsmap.Reset(uint8(*params.Reason), dir)
+	}
+
+	if err != nil {
+		return api.Error(restapi.DeleteMetricsBpfFailureCode, err)
+	}
+
+	log.WithField("entries", n).Info("Reset BPF metrics")
+
+	return restapi.NewDeleteMetricsBpfOK()
+}
+
 func initMetrics() <-chan error {
 	var errs <-chan error
 
@@ -31,6 +31,7 @@ import (
 	"github.com/cilium/cilium/pkg/endpoint/regeneration"
 	"github.com/cilium/cilium/pkg/endpointmanager"
 	"github.com/cilium/cilium/pkg/eventqueue"
+	"github.com/cilium/cilium/pkg/identity"
 	"github.com/cilium/cilium/pkg/identity/cache"
 	"github.com/cilium/cilium/pkg/ipcache"
 	"github.com/cilium/cilium/pkg/labels"
@@ -70,15 +71,26 @@ func (d *Daemon) TriggerPolicyUpdates(force bool, reason string) {
 }
 
 // UpdateIdentities informs the policy package of all identity changes
-// and also triggers policy updates.
+// and also triggers policy updates. ipcache upserts waiting for the added
+// identities are applied once the selector cache knows the identities.
 //
 // The caller is responsible for making sure the same identity is not
 // present in both 'added' and 'deleted'.
 func (d *Daemon) UpdateIdentities(added, deleted cache.IdentityCache) {
 	d.policy.GetSelectorCache().UpdateIdentities(added, deleted)
+	ipcache.IPIdentityCache.UpdateKnownIdentities(identityIDs(added), identityIDs(deleted))
 	d.TriggerPolicyUpdates(false, "one or more identities created or deleted")
 }
 
+// identityIDs returns the numeric identities of all identities in c
+func identityIDs(c cache.IdentityCache) []identity.NumericIdentity {
+	ids := make([]identity.NumericIdentity, 0, len(c))
+	for id := range c {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
 type getPolicyResolve struct {
 	daemon *Daemon
 }
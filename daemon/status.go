@@ -128,7 +128,7 @@ func checkLocks(d *Daemon) {
 
 func (d *Daemon) getNodeStatus() *models.ClusterStatus {
 	clusterStatus := models.ClusterStatus{
-		Self: d.nodeDiscovery.LocalNode.Fullname(),
+		Self: d.nodeDiscovery.GetLocalNode().Fullname(),
 	}
 	for _, node := range d.nodeDiscovery.Manager.GetNodes() {
 		clusterStatus.Nodes = append(clusterStatus.Nodes, node.GetModel())
@@ -151,6 +151,24 @@ type getNodes struct {
 	clients map[int64]*clusterNodesClient
 }
 
+type getNodesStore struct {
+	d *Daemon
+}
+
+// NewGetClusterNodesStoreHandler returns the handler listing the nodes of
+// the kvstore node store
+func NewGetClusterNodesStoreHandler(d *Daemon) GetClusterNodesStoreHandler {
+	return &getNodesStore{d: d}
+}
+
+func (h *getNodesStore) Handle(params GetClusterNodesStoreParams) middleware.Responder {
+	entries := h.d.nodeDiscovery.Registrar.GetStoreEntries()
+	if entries == nil {
+		return NewGetClusterNodesStoreNotFound()
+	}
+	return NewGetClusterNodesStoreOK().WithPayload(entries)
+}
+
 func NewGetClusterNodesHandler(d *Daemon) GetClusterNodesHandler {
 	return &getNodes{
 		d:       d,
@@ -167,25 +185,48 @@ type clusterNodesClient struct {
 	lock.RWMutex
 	lastSync time.Time
 	*models.ClusterNodeStatus
+
+	// terminating contains the full names of all remote nodes which are
+	// about to be terminated. They are reported as removed so that
+	// clients such as cilium-health stop probing them while they drain.
+	terminating map[string]struct{}
 }
 
 func (c *clusterNodesClient) NodeAdd(newNode node.Node) error {
 	c.Lock()
-	c.NodesAdded = append(c.NodesAdded, newNode.GetModel())
+	if _, ok := c.terminating[newNode.Fullname()]; !ok {
+		c.NodesAdded = append(c.NodesAdded, newNode.GetModel())
+	}
 	c.Unlock()
 	return nil
 }
 
 func (c *clusterNodesClient) NodeUpdate(oldNode, newNode node.Node) error {
 	c.Lock()
-	c.NodesAdded = append(c.NodesAdded, newNode.GetModel())
-	c.NodesRemoved = append(c.NodesRemoved, oldNode.GetModel())
+	if _, ok := c.terminating[newNode.Fullname()]; !ok {
+		c.NodesAdded = append(c.NodesAdded, newNode.GetModel())
+		c.NodesRemoved = append(c.NodesRemoved, oldNode.GetModel())
+	}
 	c.Unlock()
 	return nil
 }
 
 func (c *clusterNodesClient) NodeDelete(node node.Node) error {
 	c.Lock()
+	if _, ok := c.terminating[node.Fullname()]; ok {
+		// The node has been reported as removed when it started
+		// terminating
+		delete(c.terminating, node.Fullname())
+		c.Unlock()
+		return nil
+	}
+	c.nodeRemoved(node)
+	c.Unlock()
+	return nil
+}
+
+// nodeRemoved reports node as removed. Must be called with the mutex held.
+func (c *clusterNodesClient) nodeRemoved(node node.Node) {
 	// If the node was added/updated and removed before the clusterNodesClient
 	// was aware of it then we can safely remove it from the list of added
 	// nodes and not set it in the list of removed nodes.
@@ -200,8 +241,32 @@ func (c *clusterNodesClient) NodeDelete(node node.Node) error {
 	} else {
 		c.NodesRemoved = append(c.NodesRemoved, node.GetModel())
 	}
+}
+
+// NodeTerminating implements nodemanager.NodeTerminationHandler. Remote nodes
+// which are about to be terminated are reported as removed.
+func (c *clusterNodesClient) NodeTerminating(node node.Node) {
+	if node.IsLocal() {
+		return
+	}
+
+	c.Lock()
+	if _, ok := c.terminating[node.Fullname()]; !ok {
+		c.terminating[node.Fullname()] = struct{}{}
+		c.nodeRemoved(node)
+	}
+	c.Unlock()
+}
+
+// NodeTerminationCancelled implements nodemanager.NodeTerminationHandler.
+// Remote nodes which are no longer terminating are reported as added again.
+func (c *clusterNodesClient) NodeTerminationCancelled(node node.Node) {
+	c.Lock()
+	if _, ok := c.terminating[node.Fullname()]; ok {
+		delete(c.terminating, node.Fullname())
+		c.NodesAdded = append(c.NodesAdded, node.GetModel())
+	}
 	c.Unlock()
-	return nil
 }
 
 func (c *clusterNodesClient) NodeValidateImplementation(node node.Node) error {
@@ -219,6 +284,7 @@ func (h *getNodes) cleanupClients() {
 	for k, v := range h.clients {
 		if v.lastSync.Before(past) {
 			h.d.nodeDiscovery.Manager.Unsubscribe(v)
+			h.d.nodeDiscovery.Manager.UnsubscribeTermination(v)
 			delete(h.clients, k)
 		}
 	}
@@ -264,10 +330,12 @@ func (h *getNodes) Handle(params GetClusterNodesParams) middleware.Responder {
 			lastSync: time.Now(),
 			ClusterNodeStatus: &models.ClusterNodeStatus{
 				ClientID: clientID,
-				Self:     h.d.nodeDiscovery.LocalNode.Fullname(),
+				Self:     h.d.nodeDiscovery.GetLocalNode().Fullname(),
 			},
+			terminating: map[string]struct{}{},
 		}
 		h.d.nodeDiscovery.Manager.Subscribe(c)
+		h.d.nodeDiscovery.Manager.SubscribeTermination(c)
 
 		// Clean up other clients before adding a new one
 		h.cleanupClients()
@@ -280,7 +348,7 @@ func (h *getNodes) Handle(params GetClusterNodesParams) middleware.Responder {
 	// added / removed.
 	c.ClusterNodeStatus = &models.ClusterNodeStatus{
 		ClientID: clientID,
-		Self:     h.d.nodeDiscovery.LocalNode.Fullname(),
+		Self:     h.d.nodeDiscovery.GetLocalNode().Fullname(),
 	}
 	c.lastSync = time.Now()
 	c.Unlock()
@@ -518,7 +586,7 @@ func (d *Daemon) startStatusCollector() {
 			Name: "cluster",
 			Probe: func(ctx context.Context) (interface{}, error) {
 				clusterStatus := &models.ClusterStatus{
-					Self: d.nodeDiscovery.LocalNode.Fullname(),
+					Self: d.nodeDiscovery.GetLocalNode().Fullname(),
 				}
 				return clusterStatus, nil
 			},
@@ -19,19 +19,81 @@ import (
 
 	"github.com/cilium/cilium/pkg/identity/cache"
 	"github.com/cilium/cilium/pkg/kvstore/allocator"
+	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+	nodeStore "github.com/cilium/cilium/pkg/node/store"
+
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	// identityGCInterval is the interval in which allocator identities are
 	// attempted to be expired from the kvstore
 	identityGCInterval time.Duration
+
+	// identityIdlePeriod is the period after which unused identities are
+	// reported as idle, 0 disables the report
+	identityIdlePeriod time.Duration
 )
 
+// liveNodeSuffixes returns a NodeAliveFunc considering all IP addresses of
+// the nodes currently present in the node store as alive. Returns nil if the
+// node store is empty as no conclusion can be made about removed nodes in
+// that case.
+func liveNodeSuffixes(nodes *store.SharedStore) allocator.NodeAliveFunc {
+	suffixes := map[string]struct{}{}
+	for _, key := range nodes.SharedKeysMap() {
+		if n, ok := key.(*node.Node); ok {
+			for _, addr := range n.IPAddresses {
+				suffixes[addr.IP.String()] = struct{}{}
+			}
+		}
+	}
+
+	if len(suffixes) == 0 {
+		return nil
+	}
+
+	return func(suffix string) bool {
+		_, ok := suffixes[suffix]
+		return ok
+	}
+}
+
+// reportIdleIdentities logs all identities which have not been used for
+// identityIdlePeriod
+func reportIdleIdentities(a *allocator.Allocator) {
+	idle := a.IdleIDs(identityIdlePeriod)
+	if len(idle) == 0 {
+		return
+	}
+
+	for _, id := range idle {
+		log.WithFields(logrus.Fields{
+			logfields.Identity: id.ID,
+			"labels":           id.Key,
+			"lastUse":          id.LastUse,
+			"users":            id.Users,
+		}).Info("Security identity is idle")
+	}
+	log.WithField("count", len(idle)).Infof("Found security identities unused for %s", identityIdlePeriod)
+}
+
 func startIdentityGC() {
 	log.Infof("Starting security identity garbage collector with %s interval...", identityGCInterval)
 	a := allocator.NewAllocatorForGC(cache.IdentitiesPath)
 
+	nodes, err := store.JoinSharedStore(store.Configuration{
+		Prefix:     nodeStore.NodeStorePrefix,
+		KeyCreator: nodeStore.KeyCreator,
+	})
+	if err != nil {
+		log.WithError(err).Warning("Unable to join node store, slave keys of removed nodes will expire with their lease")
+	}
+
 	keysToDelete := map[string]uint64{}
+	slaveKeysToDelete := map[string]uint64{}
 	go func() {
 		for {
 			keysToDelete2, err := a.RunGC(keysToDelete)
@@ -39,6 +101,20 @@ func startIdentityGC() {
 				log.WithError(err).Warning("Unable to run security identity garbage collector")
 			} else {
 				keysToDelete = keysToDelete2
+				if identityIdlePeriod > 0 {
+					reportIdleIdentities(a)
+				}
+			}
+
+			if nodes != nil {
+				if isAlive := liveNodeSuffixes(nodes); isAlive != nil {
+					slaveKeysToDelete2, err := a.RunSlaveKeyGC(slaveKeysToDelete, isAlive)
+					if err != nil {
+						log.WithError(err).Warning("Unable to garbage collect slave keys of removed nodes")
+					} else {
+						slaveKeysToDelete = slaveKeysToDelete2
+					}
+				}
 			}
 
 			<-time.After(identityGCInterval)
@@ -17,7 +17,7 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"strings"
+	"fmt"
 	"sync"
 	"time"
 
@@ -25,19 +25,18 @@ import (
 	"github.com/cilium/cilium/pkg/k8s"
 	cilium_v2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/cilium/cilium/pkg/k8s/client/clientset/versioned/typed/cilium.io/v2"
-	"github.com/cilium/cilium/pkg/k8s/informer"
 	"github.com/cilium/cilium/pkg/k8s/utils"
 	k8sversion "github.com/cilium/cilium/pkg/k8s/version"
 	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/node"
 	nodeStore "github.com/cilium/cilium/pkg/node/store"
 	"github.com/cilium/cilium/pkg/option"
 	"github.com/cilium/cilium/pkg/serializer"
 
-	"k8s.io/api/core/v1"
+	"github.com/sirupsen/logrus"
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
@@ -46,8 +45,35 @@ import (
 var (
 	// kvNodeGCInterval duration for which the nodes are GC in the KVStore.
 	kvNodeGCInterval time.Duration
+
+	// nodeStoreGCGraceRounds is the number of node store synchronization
+	// periods after which the unrefreshed entry of a node removed from
+	// Kubernetes is deleted, 0 disables the periodic deletion
+	nodeStoreGCGraceRounds int
 )
 
+// kvstoreNodeSync synchronizes the Kubernetes nodes observed by a node
+// watcher into the kvstore. All changes are serialized via queue.
+type kvstoreNodeSync struct {
+	ciliumNodeStore *store.SharedStore
+	queue           *serializer.FunctionQueue
+}
+
+func (s *kvstoreNodeSync) NodeUpdated(n node.Node) {
+	s.queue.Enqueue(func() error {
+		s.ciliumNodeStore.UpdateKeySync(&n)
+		return nil
+	}, serializer.NoRetry)
+}
+
+func (s *kvstoreNodeSync) NodeDeleted(n node.Node) {
+	s.queue.Enqueue(func() error {
+		s.ciliumNodeStore.DeleteLocalKey(&n)
+		deleteCiliumNode(n.Name)
+		return nil
+	}, serializer.NoRetry)
+}
+
 func runNodeWatcher() error {
 	log.Info("Starting to synchronize k8s nodes to kvstore...")
 
@@ -61,88 +87,48 @@ func runNodeWatcher() error {
 		return err
 	}
 
-	k8sNodeStore, nodeController := informer.NewInformer(
-		cache.NewListWatchFromClient(k8s.Client().CoreV1().RESTClient(),
-			"nodes", v1.NamespaceAll, fields.Everything()),
-		&v1.Node{},
-		0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				if n := k8s.CopyObjToV1Node(obj); n != nil {
-					serNodes.Enqueue(func() error {
-						nodeNew := k8s.ParseNode(n, node.FromKubernetes)
-						ciliumNodeStore.UpdateKeySync(nodeNew)
-						return nil
-					}, serializer.NoRetry)
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				if oldNode := k8s.CopyObjToV1Node(oldObj); oldNode != nil {
-					if newNode := k8s.CopyObjToV1Node(newObj); newNode != nil {
-						if k8s.EqualV1Node(oldNode, newNode) {
-							return
-						}
-
-						serNodes.Enqueue(func() error {
-							newNode := k8s.ParseNode(newNode, node.FromKubernetes)
-							ciliumNodeStore.UpdateKeySync(newNode)
-							return nil
-						}, serializer.NoRetry)
-					}
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				n := k8s.CopyObjToV1Node(obj)
-				if n == nil {
-					deletedObj, ok := obj.(cache.DeletedFinalStateUnknown)
-					if !ok {
-						return
-					}
-					// Delete was not observed by the watcher but is
-					// removed from kube-apiserver. This is the last
-					// known state and the object no longer exists.
-					n = k8s.CopyObjToV1Node(deletedObj.Obj)
-					if n == nil {
-						return
-					}
-				}
-				serNodes.Enqueue(func() error {
-					deletedNode := k8s.ParseNode(n, node.FromKubernetes)
-					ciliumNodeStore.DeleteLocalKey(deletedNode)
-					deleteCiliumNode(n.Name)
-					return nil
-				}, serializer.NoRetry)
-			},
-		},
-		k8s.ConvertToNode,
-	)
-	go nodeController.Run(wait.NeverStop)
+	nodeWatcher := k8s.NewNodeWatcher(k8s.Client(), &kvstoreNodeSync{
+		ciliumNodeStore: ciliumNodeStore,
+		queue:           serNodes,
+	}, 0)
+	nodeWatcher.Start()
+	k8sNodeStore := nodeWatcher.Store()
 
 	go func() {
-		cache.WaitForCacheSync(wait.NeverStop, nodeController.HasSynced)
+		nodeWatcher.WaitForCacheSync(wait.NeverStop)
+		gc := &kvstoreNodeGC{
+			ciliumNodeStore: ciliumNodeStore,
+			k8sNodeStore:    k8sNodeStore,
+		}
 		serNodes.Enqueue(func() error {
 			// Since we serialize all events received from k8s we know that
 			// at this point the list in k8sNodeStore should be the source of truth
 			// and we need to delete all nodes in the kvNodeStore that are *not*
 			// present in the k8sNodeStore.
-
-			listOfK8sNodes := k8sNodeStore.ListKeys()
-
-			kvStoreNodes := ciliumNodeStore.SharedKeysMap()
-			for _, k8sNode := range listOfK8sNodes {
-				// The remaining kvStoreNodes are leftovers
-				kvStoreNodeName := node.GetKeyNodeName(option.Config.ClusterName, k8sNode)
-				delete(kvStoreNodes, kvStoreNodeName)
-			}
-
-			for _, kvStoreNode := range kvStoreNodes {
-				if strings.HasPrefix(kvStoreNode.GetKeyName(), option.Config.ClusterName) {
-					ciliumNodeStore.DeleteLocalKey(kvStoreNode)
-				}
-			}
-
+			gc.deleteStaleNodes(1)
 			return nil
 		}, serializer.NoRetry)
+
+		if nodeStoreGCGraceRounds <= 0 {
+			return
+		}
+
+		// Entries of removed nodes can be re-published afterwards, e.g.
+		// when kept alive by the lease of another writer. They are
+		// deleted once they have not been refreshed for the grace period.
+		controller.NewManager().UpdateController("kvstore-node-store-gc",
+			controller.ControllerParams{
+				RunInterval: option.Config.KVstorePeriodicSync,
+				DoFunc: func(ctx context.Context) error {
+					serNodes.Enqueue(func() error {
+						for _, n := range gc.deleteStaleNodes(nodeStoreGCGraceRounds) {
+							emitNodeStoreGCEvent(n)
+						}
+						return nil
+					}, serializer.NoRetry)
+					return nil
+				},
+			})
 	}()
 
 	go func() {
@@ -217,6 +203,97 @@ func runNodeWatcher() error {
 	return nil
 }
 
+// staleNodeEntry is the state of a node store entry of a node removed from
+// Kubernetes
+type staleNodeEntry struct {
+	// modRevision is the revision of the entry when it was found stale
+	modRevision uint64
+
+	// rounds is the number of consecutive runs the entry was found stale
+	rounds int
+}
+
+// kvstoreNodeGC deletes the node store entries of nodes removed from
+// Kubernetes. Runs must be serialized with the node events.
+type kvstoreNodeGC struct {
+	ciliumNodeStore *store.SharedStore
+	k8sNodeStore    cache.Store
+
+	// stale are the entries found stale in the previous run, indexed by
+	// key name
+	stale map[string]staleNodeEntry
+}
+
+// deleteStaleNodes deletes the node store entries of the local cluster
+// whose Kubernetes node no longer exists and which have not been modified in
+// graceRounds consecutive runs. Returns the nodes whose entry has been
+// deleted.
+func (gc *kvstoreNodeGC) deleteStaleNodes(graceRounds int) []node.Node {
+	info := gc.ciliumNodeStore.SharedKeysInfo()
+	stale := map[string]staleNodeEntry{}
+	deleted := []node.Node{}
+
+	for name, key := range gc.ciliumNodeStore.SharedKeysMap() {
+		n, ok := key.(*node.Node)
+		if !ok || n.Cluster != option.Config.ClusterName {
+			continue
+		}
+		// Consider the node alive if its existence cannot be determined
+		if _, exists, err := gc.k8sNodeStore.GetByKey(n.Name); exists || err != nil {
+			continue
+		}
+
+		entry := staleNodeEntry{modRevision: info[name].ModRevision, rounds: 1}
+		if prev, ok := gc.stale[name]; ok && prev.modRevision == entry.modRevision {
+			entry.rounds = prev.rounds + 1
+		}
+		if entry.rounds < graceRounds {
+			stale[name] = entry
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			logfields.NodeName: n.Name,
+			"rounds":           entry.rounds,
+		}).Info("Deleting node store entry of removed Kubernetes node")
+		gc.ciliumNodeStore.DeleteLocalKey(n)
+		deleted = append(deleted, *n)
+	}
+
+	gc.stale = stale
+	return deleted
+}
+
+// emitNodeStoreGCEvent records a Kubernetes event for the deletion of the
+// stale node store entry of n
+func emitNodeStoreGCEvent(n node.Node) {
+	now := meta_v1.Now()
+	event := &core_v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			GenerateName: n.Name + ".",
+		},
+		InvolvedObject: core_v1.ObjectReference{
+			Kind: "Node",
+			Name: n.Name,
+		},
+		Reason: "StaleNodeStoreEntryDeleted",
+		Message: fmt.Sprintf("Deleted node store entry of node %s not refreshed for %d synchronization periods after the node has been removed",
+			n.Name, nodeStoreGCGraceRounds),
+		Source: core_v1.EventSource{
+			Component: "cilium-operator",
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           core_v1.EventTypeWarning,
+	}
+
+	if _, err := k8s.Client().CoreV1().Events(meta_v1.NamespaceDefault).Create(event); err != nil {
+		log.WithError(err).WithField(logfields.NodeName, n.Name).
+			Warning("Unable to emit event for deleted stale node store entry")
+	}
+}
+
 func updateCNP(ciliumClient v2.CiliumV2Interface, cnp *cilium_v2.CiliumNetworkPolicy, nodesToDelete map[string]cilium_v2.Timestamp, capabilities k8sversion.ServerCapabilities) {
 	if len(nodesToDelete) == 0 {
 		return
@@ -19,6 +19,8 @@ import (
 
 	"github.com/cilium/cilium/pkg/k8s"
 	"github.com/cilium/cilium/pkg/k8s/informer"
+	"github.com/cilium/cilium/pkg/k8s/types"
+	"github.com/cilium/cilium/pkg/kvstore"
 	"github.com/cilium/cilium/pkg/kvstore/store"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/metrics"
@@ -27,6 +29,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
@@ -40,6 +43,19 @@ var (
 	servicesStore     *store.SharedStore
 )
 
+// getServiceEndpoints returns the addresses of the endpoints of a Kubernetes
+// service for the discovery of etcd endpoints. The endpoints are read from
+// the apiserver as the service cache is only populated after the kvstore has
+// been set up.
+func getServiceEndpoints(namespace, name string) ([]string, error) {
+	ep, err := k8s.Client().CoreV1().Endpoints(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	_, endpoints := k8s.ParseEndpoints(&types.Endpoints{Endpoints: ep})
+	return endpoints.BackendAddrs(kvstore.EtcdServicePortName), nil
+}
+
 func k8sServiceHandler() {
 	for {
 		event, ok := <-k8sSvcCache.Events
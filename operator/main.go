@@ -104,6 +104,8 @@ func init() {
 	flags.StringVar(&k8sKubeConfigPath, "k8s-kubeconfig-path", "", "Absolute path of the kubernetes kubeconfig file")
 	flags.StringVar(&kvStore, "kvstore", "", "Key-value store type")
 	flags.Var(option.NewNamedMapOptions("kvstore-opts", &kvStoreOpts, nil), "kvstore-opt", "Key-value store options")
+	flags.String(option.KVStoreTenant, "", "Tenant prefix injected into all kvstore keys to share a kvstore between multiple installations")
+	option.BindEnv(option.KVStoreTenant)
 	flags.Uint16Var(&apiServerPort, "api-server-port", 9234, "Port on which the operator should serve API requests")
 	flags.String(option.IPAM, "", "Backend to use for IPAM")
 	option.BindEnv(option.IPAM)
@@ -114,7 +116,9 @@ func init() {
 	flags.BoolVar(&enableCepGC, "cilium-endpoint-gc", true, "Enable CiliumEndpoint garbage collector")
 	flags.DurationVar(&ciliumEndpointGCInterval, "cilium-endpoint-gc-interval", time.Minute*30, "GC interval for cilium endpoints")
 	flags.DurationVar(&identityGCInterval, "identity-gc-interval", time.Minute*10, "GC interval for security identities")
+	flags.DurationVar(&identityIdlePeriod, "identity-idle-period", 0, "Report security identities unused for this period after each identity GC run (0 to disable)")
 	flags.DurationVar(&kvNodeGCInterval, "nodes-gc-interval", time.Minute*2, "GC interval for nodes store in the kvstore")
+	flags.IntVar(&nodeStoreGCGraceRounds, "node-store-gc-grace-rounds", 3, "Number of kvstore synchronization periods after which unrefreshed node store entries of removed Kubernetes nodes are deleted (0 to disable)")
 	flags.Int64Var(&eniParallelWorkers, "eni-parallel-workers", 50, "Maximum number of parallel workers used by ENI allocator")
 	flags.String(option.K8sNamespaceName, "", "Name of the Kubernetes namespace in which Cilium Operator is deployed in")
 	flags.MarkHidden(option.K8sNamespaceName)
@@ -156,6 +160,10 @@ func initConfig() {
 	option.Config.ClusterID = viper.GetInt(option.ClusterIDName)
 	option.Config.DisableCiliumEndpointCRD = viper.GetBool(option.DisableCiliumEndpointCRDName)
 	option.Config.K8sNamespace = viper.GetString(option.K8sNamespaceName)
+	option.Config.KVStoreTenant = viper.GetString(option.KVStoreTenant)
+	if err := option.ValidateKVStoreTenant(option.Config.KVStoreTenant); err != nil {
+		log.WithError(err).Fatal("Invalid operator configuration")
+	}
 
 	viper.SetEnvPrefix("cilium")
 	viper.SetConfigName("cilium-operator")
@@ -255,6 +263,18 @@ func runOperator(cmd *cobra.Command) {
 		} else {
 			scopedLog.Info("cilium-operator running without service synchronization: automatic etcd service translation disabled")
 		}
+		if k8s.IsEnabled() && kvStoreOpts[kvstore.EtcdDiscoveryServiceOption] != "" {
+			if goopts == nil {
+				goopts = &kvstore.ExtraOptions{}
+			}
+			goopts.ServiceEndpoints = getServiceEndpoints
+		}
+		if k8s.IsEnabled() && kvStore == kvstore.KubernetesBackendName {
+			if goopts == nil {
+				goopts = &kvstore.ExtraOptions{}
+			}
+			goopts.KubernetesClient = k8s.Client()
+		}
 		scopedLog.Info("Connecting to kvstore...")
 		if err := kvstore.Setup(kvStore, kvStoreOpts, goopts); err != nil {
 			scopedLog.WithError(err).Fatal("Unable to setup kvstore")
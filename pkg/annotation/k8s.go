@@ -29,6 +29,10 @@ const (
 	// V6CIDRName is the annotation name used to store the IPv6
 	// pod CIDR in the node's annotations.
 	V6CIDRName = Prefix + ".network.ipv6-pod-cidr"
+	// SecondaryCIDRsName is the annotation name used to store the
+	// comma-separated list of secondary pod CIDRs of both address
+	// families in the node's annotations.
+	SecondaryCIDRsName = Prefix + ".network.secondary-pod-cidrs"
 
 	// V4HealthName is the annotation name used to store the IPv4
 	// address of the cilium-health endpoint in the node's annotations.
@@ -56,3 +60,22 @@ const (
 	// sharing local endpoints.
 	SharedService = Prefix + "shared-service"
 )
+
+// Node annotations written by older versions of Cilium. They are converted
+// to their current counterparts when parsing Kubernetes nodes.
+const (
+	// V4CIDRNameLegacy is the deprecated name of V4CIDRName
+	V4CIDRNameLegacy = Prefix + ".network.ipv4-cidr"
+	// V6CIDRNameLegacy is the deprecated name of V6CIDRName
+	V6CIDRNameLegacy = Prefix + ".network.ipv6-cidr"
+
+	// V4HealthNameLegacy is the deprecated name of V4HealthName
+	V4HealthNameLegacy = Prefix + ".network.ipv4-health"
+	// V6HealthNameLegacy is the deprecated name of V6HealthName
+	V6HealthNameLegacy = Prefix + ".network.ipv6-health"
+
+	// CiliumHostIPLegacy is the deprecated name of CiliumHostIP
+	CiliumHostIPLegacy = Prefix + ".network.ipv4-host-ip"
+	// CiliumHostIPv6Legacy is the deprecated name of CiliumHostIPv6
+	CiliumHostIPv6Legacy = Prefix + ".network.ipv6-host-ip"
+)
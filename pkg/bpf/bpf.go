@@ -52,27 +52,31 @@ const (
 	BPF_MAP_TYPE_REUSEPORT_SOCKARRAY = 20
 
 	// BPF syscall command constants. Must match enum bpf_cmd from linux/bpf.h
-	BPF_MAP_CREATE          = 0
-	BPF_MAP_LOOKUP_ELEM     = 1
-	BPF_MAP_UPDATE_ELEM     = 2
-	BPF_MAP_DELETE_ELEM     = 3
-	BPF_MAP_GET_NEXT_KEY    = 4
-	BPF_PROG_LOAD           = 5
-	BPF_OBJ_PIN             = 6
-	BPF_OBJ_GET             = 7
-	BPF_PROG_ATTACH         = 8
-	BPF_PROG_DETACH         = 9
-	BPF_PROG_TEST_RUN       = 10
-	BPF_PROG_GET_NEXT_ID    = 11
-	BPF_MAP_GET_NEXT_ID     = 12
-	BPF_PROG_GET_FD_BY_ID   = 13
-	BPF_MAP_GET_FD_BY_ID    = 14
-	BPF_OBJ_GET_INFO_BY_FD  = 15
-	BPF_PROG_QUERY          = 16
-	BPF_RAW_TRACEPOINT_OPEN = 17
-	BPF_BTF_LOAD            = 18
-	BPF_BTF_GET_FD_BY_ID    = 19
-	BPF_TASK_FD_QUERY       = 20
+	BPF_MAP_CREATE                 = 0
+	BPF_MAP_LOOKUP_ELEM            = 1
+	BPF_MAP_UPDATE_ELEM            = 2
+	BPF_MAP_DELETE_ELEM            = 3
+	BPF_MAP_GET_NEXT_KEY           = 4
+	BPF_PROG_LOAD                  = 5
+	BPF_OBJ_PIN                    = 6
+	BPF_OBJ_GET                    = 7
+	BPF_PROG_ATTACH                = 8
+	BPF_PROG_DETACH                = 9
+	BPF_PROG_TEST_RUN              = 10
+	BPF_PROG_GET_NEXT_ID           = 11
+	BPF_MAP_GET_NEXT_ID            = 12
+	BPF_PROG_GET_FD_BY_ID          = 13
+	BPF_MAP_GET_FD_BY_ID           = 14
+	BPF_OBJ_GET_INFO_BY_FD         = 15
+	BPF_PROG_QUERY                 = 16
+	BPF_RAW_TRACEPOINT_OPEN        = 17
+	BPF_BTF_LOAD                   = 18
+	BPF_BTF_GET_FD_BY_ID           = 19
+	BPF_TASK_FD_QUERY              = 20
+	BPF_MAP_LOOKUP_AND_DELETE_ELEM = 21
+	BPF_MAP_FREEZE                 = 22
+	BPF_BTF_GET_NEXT_ID            = 23
+	BPF_MAP_LOOKUP_BATCH           = 24
 
 	// Flags for BPF_MAP_UPDATE_ELEM. Must match values from linux/bpf.h
 	BPF_ANY     = 0
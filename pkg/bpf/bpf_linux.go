@@ -17,6 +17,7 @@
 package bpf
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -242,6 +243,68 @@ func GetNextKey(fd int, key, nextKey unsafe.Pointer) error {
 	return GetNextKeyFromPointers(fd, uintptr(unsafe.Pointer(&uba)), unsafe.Sizeof(uba))
 }
 
+// This struct must be in sync with union bpf_attr's anonymous struct used by
+// BPF_MAP_*_BATCH commands
+type bpfAttrMapOpBatch struct {
+	inBatch   uint64
+	outBatch  uint64
+	keys      uint64
+	values    uint64
+	count     uint32
+	mapFd     uint32
+	elemFlags uint64
+	flags     uint64
+}
+
+// ErrBatchNotSupported is returned by LookupBatch if the kernel does not
+// support batch operations on the map
+var ErrBatchNotSupported = errors.New("batch operations not supported")
+
+// LookupBatch looks up at most count elements of the map in fd starting at
+// the position inBatch, which must be nil for the first call, and stores
+// their keys and values in keys and values. The position to continue at is
+// stored in outBatch. Returns the number of elements looked up and true if
+// the end of the map has been reached.
+func LookupBatch(fd int, inBatch, outBatch, keys, values unsafe.Pointer, count uint32) (uint32, bool, error) {
+	uba := bpfAttrMapOpBatch{
+		inBatch:  uint64(uintptr(inBatch)),
+		outBatch: uint64(uintptr(outBatch)),
+		keys:     uint64(uintptr(keys)),
+		values:   uint64(uintptr(values)),
+		count:    count,
+		mapFd:    uint32(fd),
+	}
+
+	var duration *spanstat.SpanStat
+	if option.Config.MetricsConfig.BPFSyscallDurationEnabled {
+		duration = spanstat.Start()
+	}
+	ret, _, err := unix.Syscall(
+		unix.SYS_BPF,
+		BPF_MAP_LOOKUP_BATCH,
+		uintptr(unsafe.Pointer(&uba)),
+		unsafe.Sizeof(uba),
+	)
+	if option.Config.MetricsConfig.BPFSyscallDurationEnabled {
+		metrics.BPFSyscallDuration.WithLabelValues(metricOpLookupBatch, metrics.Errno2Outcome(err)).Observe(duration.End(err == 0 || err == unix.ENOENT).Total().Seconds())
+	}
+
+	switch {
+	case ret == 0 && err == 0:
+		return uba.count, false, nil
+	case err == unix.ENOENT:
+		// The count is updated to the number of elements
+		// looked up before the end of the map was reached
+		return uba.count, true, nil
+	case err == unix.EINVAL || err == unix.ENOSYS || err == unix.EOPNOTSUPP || err == 524:
+		// Kernels without support for the command return EINVAL,
+		// map types without support for it ENOTSUPP (524)
+		return 0, false, ErrBatchNotSupported
+	}
+
+	return 0, false, fmt.Errorf("Unable to lookup batch in map with file descriptor %d: %s", fd, err)
+}
+
 // This struct must be in sync with union bpf_attr's anonymous struct used by
 // BPF_OBJ_*_ commands
 type bpfAttrObjOp struct {
@@ -179,6 +179,17 @@ func (m *Map) WithNonPersistent() *Map {
 	return m
 }
 
+// SetPerCPUValue replaces the value used to decode the per-CPU values of
+// the map and adjusts the size read from the map to the given number of
+// cpus. It is used when the number of possible CPUs changes after the map
+// has been created.
+func (m *Map) SetPerCPUValue(mapValue MapValue, cpus int) {
+	m.lock.Lock()
+	m.MapValue = mapValue
+	m.ReadValueSize = m.ValueSize * uint32(cpus)
+	m.lock.Unlock()
+}
+
 func (m *Map) commonName() string {
 	if m.cachedCommonName != "" {
 		return m.cachedCommonName
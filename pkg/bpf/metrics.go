@@ -20,6 +20,7 @@ const (
 	metricOpLookup           = "lookup"
 	metricOpDelete           = "delete"
 	metricOpGetNextKey       = "getNextKey"
+	metricOpLookupBatch      = "lookupBatch"
 	metricOpObjPin           = "objPin"
 	metricOpObjGet           = "objGet"
 	metricOpGetFDByID        = "getFDByID"
@@ -63,8 +63,8 @@ type remoteCluster struct {
 	// - remoteIdentityCache
 	mutex lock.RWMutex
 
-	// store is the shared store representing all nodes in the remote cluster
-	remoteNodes *store.SharedStore
+	// remoteNodes is the node store of the remote cluster
+	remoteNodes *nodeStore.RemoteNodeStore
 
 	// remoteServices is the shared store representing services in remote
 	// clusters
@@ -158,13 +158,8 @@ func (rc *remoteCluster) restartRemoteConnection() {
 					return err
 				}
 
-				remoteNodes, err := store.JoinSharedStore(store.Configuration{
-					Prefix:                  path.Join(nodeStore.NodeStorePrefix, rc.name),
-					KeyCreator:              rc.mesh.conf.NodeKeyCreator,
-					SynchronizationInterval: time.Minute,
-					Backend:                 backend,
-					Observer:                rc.mesh.conf.NodeObserver(),
-				})
+				remoteNodes, err := nodeStore.WatchRemoteNodeStore(backend, rc.name,
+					rc.mesh.conf.NodeKeyCreator, rc.mesh.conf.NodeObserver())
 				if err != nil {
 					backend.Close()
 					return err
@@ -191,7 +186,7 @@ func (rc *remoteCluster) restartRemoteConnection() {
 				ipCacheWatcher := ipcache.NewIPIdentityWatcher(backend)
 				go ipCacheWatcher.Watch()
 
-				remoteIdentityCache := cache.WatchRemoteIdentities(backend)
+				remoteIdentityCache := cache.WatchRemoteIdentities(rc.name, backend)
 
 				rc.mutex.Lock()
 				rc.remoteNodes = remoteNodes
@@ -21,6 +21,7 @@ import (
 	"github.com/cilium/cilium/pkg/bpf"
 	"github.com/cilium/cilium/pkg/maps/configmap"
 	"github.com/cilium/cilium/pkg/maps/ctmap"
+	"github.com/cilium/cilium/pkg/maps/epmetricsmap"
 	"github.com/cilium/cilium/pkg/maps/eppolicymap"
 	ipcachemap "github.com/cilium/cilium/pkg/maps/ipcache"
 	"github.com/cilium/cilium/pkg/maps/lbmap"
@@ -62,6 +63,7 @@ func CheckStructAlignments(path string) error {
 		"endpoint_info":        {reflect.TypeOf(lxcmap.EndpointInfo{})},
 		"metrics_key":          {reflect.TypeOf(metricsmap.Key{})},
 		"metrics_value":        {reflect.TypeOf(metricsmap.Value{})},
+		"ep_metrics_key":       {reflect.TypeOf(epmetricsmap.Key{})},
 		"policy_key":           {reflect.TypeOf(policymap.PolicyKey{})},
 		"policy_entry":         {reflect.TypeOf(policymap.PolicyEntry{})},
 		"sock_key":             {reflect.TypeOf(sockmap.SockmapKey{})},
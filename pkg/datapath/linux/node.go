@@ -28,6 +28,7 @@ import (
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/maps/tunnel"
 	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/node/addressing"
 	"github.com/cilium/cilium/pkg/option"
 
 	"github.com/sirupsen/logrus"
@@ -142,12 +143,13 @@ func deleteTunnelMapping(oldCIDR *cidr.CIDR, quietMode bool) {
 	}
 }
 
-func createDirectRouteSpec(CIDR *cidr.CIDR, nodeIP net.IP) (routeSpec *netlink.Route, err error) {
+func createDirectRouteSpec(CIDR *cidr.CIDR, nodeIP net.IP, mtu int) (routeSpec *netlink.Route, err error) {
 	var routes []netlink.Route
 
 	routeSpec = &netlink.Route{
 		Dst: CIDR.IPNet,
 		Gw:  nodeIP,
+		MTU: mtu,
 	}
 
 	routes, err = netlink.RouteGet(nodeIP)
@@ -203,8 +205,8 @@ func createDirectRouteSpec(CIDR *cidr.CIDR, nodeIP net.IP) (routeSpec *netlink.R
 	return
 }
 
-func installDirectRoute(CIDR *cidr.CIDR, nodeIP net.IP) (routeSpec *netlink.Route, err error) {
-	routeSpec, err = createDirectRouteSpec(CIDR, nodeIP)
+func installDirectRoute(CIDR *cidr.CIDR, nodeIP net.IP, mtu int) (routeSpec *netlink.Route, err error) {
+	routeSpec, err = createDirectRouteSpec(CIDR, nodeIP, mtu)
 	if err != nil {
 		return
 	}
@@ -214,7 +216,7 @@ func installDirectRoute(CIDR *cidr.CIDR, nodeIP net.IP) (routeSpec *netlink.Rout
 }
 
 func (n *linuxNodeHandler) lookupDirectRoute(CIDR *cidr.CIDR, nodeIP net.IP) ([]netlink.Route, error) {
-	routeSpec, err := createDirectRouteSpec(CIDR, nodeIP)
+	routeSpec, err := createDirectRouteSpec(CIDR, nodeIP, 0)
 	if err != nil {
 		return nil, err
 	}
@@ -226,7 +228,21 @@ func (n *linuxNodeHandler) lookupDirectRoute(CIDR *cidr.CIDR, nodeIP net.IP) ([]
 	return netlink.RouteListFiltered(family, routeSpec, netlink.RT_FILTER_DST|netlink.RT_FILTER_GW|netlink.RT_FILTER_OIF)
 }
 
-func (n *linuxNodeHandler) updateDirectRoute(oldCIDR, newCIDR *cidr.CIDR, oldIP, newIP net.IP, firstAddition, directRouteEnabled bool) error {
+// directRouteMTU returns the MTU of the direct routes towards peer. The
+// routes inherit the MTU of the device unless peer announces a smaller
+// MTU than the local one.
+func (n *linuxNodeHandler) directRouteMTU(peer *node.Node) int {
+	if peer == nil {
+		return 0
+	}
+	localMTU := n.nodeConfig.MtuConfig.GetDeviceMTU()
+	if mtu := peer.PeerMTU(localMTU); mtu < localMTU {
+		return mtu
+	}
+	return 0
+}
+
+func (n *linuxNodeHandler) updateDirectRoute(oldCIDR, newCIDR *cidr.CIDR, oldIP, newIP net.IP, oldMTU, newMTU int, firstAddition, directRouteEnabled bool) error {
 	if !directRouteEnabled {
 		// When the protocol family is disabled, the initial node addition will
 		// trigger a deletion to clean up leftover entries. The deletion happens
@@ -237,13 +253,14 @@ func (n *linuxNodeHandler) updateDirectRoute(oldCIDR, newCIDR *cidr.CIDR, oldIP,
 		return nil
 	}
 
-	if cidrNodeMappingUpdateRequired(oldCIDR, newCIDR, oldIP, newIP, 0, 0) {
+	if cidrNodeMappingUpdateRequired(oldCIDR, newCIDR, oldIP, newIP, 0, 0) || (newCIDR != nil && oldMTU != newMTU) {
 		log.WithFields(logrus.Fields{
 			logfields.IPAddr: newIP,
 			"allocCIDR":      newCIDR,
+			"mtu":            newMTU,
 		}).Debug("Updating direct route")
 
-		if routeSpec, err := installDirectRoute(newCIDR, newIP); err != nil {
+		if routeSpec, err := installDirectRoute(newCIDR, newIP, newMTU); err != nil {
 			log.WithError(err).Warningf("Unable to install direct node route %s", routeSpec.String())
 			return err
 		}
@@ -418,6 +435,29 @@ func (n *linuxNodeHandler) NodeAdd(newNode node.Node) error {
 	return nil
 }
 
+// NodeBatchAdd adds multiple nodes at once, e.g. all nodes of the initial
+// synchronization, while acquiring the handler lock only once. The nodes are
+// all programmed even if the programming of some of them fails, the first
+// error is returned.
+func (n *linuxNodeHandler) NodeBatchAdd(newNodes []node.Node) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	var firstErr error
+	for i := range newNodes {
+		newNode := newNodes[i]
+		n.nodes[newNode.Identity()] = &newNode
+
+		if n.isInitialized {
+			if err := n.nodeUpdate(nil, &newNode, true); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
 func (n *linuxNodeHandler) NodeUpdate(oldNode, newNode node.Node) error {
 	n.mutex.Lock()
 	defer n.mutex.Unlock()
@@ -431,6 +471,47 @@ func (n *linuxNodeHandler) NodeUpdate(oldNode, newNode node.Node) error {
 	return nil
 }
 
+// NodeAddressesChanged is called after a node update added or removed
+// addresses of changedNode. The tunnel mappings and the IPsec states of the
+// new addresses are installed by NodeUpdate(), the IPsec states of the
+// removed CiliumInternalIPs are removed here as nodeUpdate() only upserts the
+// states of the current addresses.
+func (n *linuxNodeHandler) NodeAddressesChanged(changedNode node.Node, added, removed []node.Address) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if !n.isInitialized || !n.nodeConfig.EnableIPSec || changedNode.IsLocal() {
+		return nil
+	}
+
+	for _, addr := range removed {
+		if addr.Type != addressing.NodeCiliumInternalIP {
+			continue
+		}
+
+		ipv6 := addr.IP.To4() == nil
+		if addr.IP.Equal(changedNode.GetCiliumInternalIP(ipv6)) {
+			continue
+		}
+
+		allocCIDR := changedNode.IPv4AllocCIDR
+		if ipv6 {
+			allocCIDR = changedNode.IPv6AllocCIDR
+		}
+		if allocCIDR == nil {
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			logfields.NodeName: changedNode.Fullname(),
+			logfields.IPAddr:   addr.IP,
+		}).Debug("Removing IPsec states of removed node address")
+		ipsec.DeleteIPsecEndpoint(&net.IPNet{IP: addr.IP, Mask: allocCIDR.Mask})
+	}
+
+	return nil
+}
+
 func upsertIPsecLog(err error, spec string, loc, rem *net.IPNet, spi uint8) {
 	scopedLog := log.WithFields(logrus.Fields{
 		logfields.Reason: spec,
@@ -579,18 +660,60 @@ func (n *linuxNodeHandler) subnetEncryption() bool {
 	return len(n.nodeConfig.IPv4PodSubnets) > 0 || len(n.nodeConfig.IPv6PodSubnets) > 0
 }
 
+// findCIDR returns the CIDR of list equal to c or nil if c is not in list
+func findCIDR(list []*cidr.CIDR, c *cidr.CIDR) *cidr.CIDR {
+	for _, candidate := range list {
+		if candidate != nil && candidate.String() == c.String() {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// updateSecondaryDirectRoutes installs the direct routes for the secondary
+// allocation CIDRs of a node and removes the routes of CIDRs no longer
+// announced
+func (n *linuxNodeHandler) updateSecondaryDirectRoutes(oldCIDRs, newCIDRs []*cidr.CIDR, oldIP, newIP net.IP, oldMTU, newMTU int, firstAddition, directRouteEnabled bool) {
+	for _, newCIDR := range newCIDRs {
+		n.updateDirectRoute(findCIDR(oldCIDRs, newCIDR), newCIDR, oldIP, newIP, oldMTU, newMTU, firstAddition, directRouteEnabled)
+	}
+	_, removed := cidr.DiffCIDRLists(oldCIDRs, newCIDRs)
+	for _, oldCIDR := range removed {
+		n.deleteDirectRoute(oldCIDR, oldIP)
+	}
+}
+
+// updateSecondaryTunnelMappings updates the tunnel mappings for the secondary
+// allocation CIDRs of a node and removes the mappings of CIDRs no longer
+// announced
+func updateSecondaryTunnelMappings(oldCIDRs, newCIDRs []*cidr.CIDR, oldIP, newIP net.IP, firstAddition, encapEnabled bool, oldEncryptKey, newEncryptKey uint8) {
+	for _, newCIDR := range newCIDRs {
+		updateTunnelMapping(findCIDR(oldCIDRs, newCIDR), newCIDR, oldIP, newIP, firstAddition, encapEnabled, oldEncryptKey, newEncryptKey)
+	}
+	_, removed := cidr.DiffCIDRLists(oldCIDRs, newCIDRs)
+	for _, oldCIDR := range removed {
+		deleteTunnelMapping(oldCIDR, false)
+	}
+}
+
 func (n *linuxNodeHandler) nodeUpdate(oldNode, newNode *node.Node, firstAddition bool) error {
 	var (
-		oldIP4Cidr, oldIP6Cidr *cidr.CIDR
-		oldIP4, oldIP6         net.IP
-		newIP4                 = newNode.GetNodeIP(false)
-		newIP6                 = newNode.GetNodeIP(true)
-		oldKey, newKey         uint8
+		oldIP4Cidr, oldIP6Cidr             *cidr.CIDR
+		oldIP4Secondary, oldIP6Secondary   []*cidr.CIDR
+		oldIP4AllocCIDRs, oldIP6AllocCIDRs []*cidr.CIDR
+		oldIP4, oldIP6                     net.IP
+		newIP4                             = newNode.GetNodeIP(false)
+		newIP6                             = newNode.GetNodeIP(true)
+		oldKey, newKey                     uint8
 	)
 
 	if oldNode != nil {
 		oldIP4Cidr = oldNode.IPv4AllocCIDR
 		oldIP6Cidr = oldNode.IPv6AllocCIDR
+		oldIP4Secondary = oldNode.IPv4SecondaryAllocCIDRs
+		oldIP6Secondary = oldNode.IPv6SecondaryAllocCIDRs
+		oldIP4AllocCIDRs = oldNode.GetIPv4AllocCIDRs()
+		oldIP6AllocCIDRs = oldNode.GetIPv6AllocCIDRs()
 		oldIP4 = oldNode.GetNodeIP(false)
 		oldIP6 = oldNode.GetNodeIP(true)
 		oldKey = oldNode.EncryptionKey
@@ -607,8 +730,8 @@ func (n *linuxNodeHandler) nodeUpdate(oldNode, newNode *node.Node, firstAddition
 
 	if newNode.IsLocal() {
 		if n.nodeConfig.EnableLocalNodeRoute {
-			n.updateOrRemoveNodeRoutes([]*cidr.CIDR{oldIP4Cidr}, []*cidr.CIDR{newNode.IPv4AllocCIDR})
-			n.updateOrRemoveNodeRoutes([]*cidr.CIDR{oldIP6Cidr}, []*cidr.CIDR{newNode.IPv6AllocCIDR})
+			n.updateOrRemoveNodeRoutes(oldIP4AllocCIDRs, newNode.GetIPv4AllocCIDRs())
+			n.updateOrRemoveNodeRoutes(oldIP6AllocCIDRs, newNode.GetIPv6AllocCIDRs())
 		}
 		if n.subnetEncryption() {
 			n.enableSubnetIPsec(n.nodeConfig.IPv4PodSubnets, n.nodeConfig.IPv6PodSubnets)
@@ -617,8 +740,11 @@ func (n *linuxNodeHandler) nodeUpdate(oldNode, newNode *node.Node, firstAddition
 	}
 
 	if n.nodeConfig.EnableAutoDirectRouting {
-		n.updateDirectRoute(oldIP4Cidr, newNode.IPv4AllocCIDR, oldIP4, newIP4, firstAddition, n.nodeConfig.EnableIPv4)
-		n.updateDirectRoute(oldIP6Cidr, newNode.IPv6AllocCIDR, oldIP6, newIP6, firstAddition, n.nodeConfig.EnableIPv6)
+		oldMTU, newMTU := n.directRouteMTU(oldNode), n.directRouteMTU(newNode)
+		n.updateDirectRoute(oldIP4Cidr, newNode.IPv4AllocCIDR, oldIP4, newIP4, oldMTU, newMTU, firstAddition, n.nodeConfig.EnableIPv4)
+		n.updateDirectRoute(oldIP6Cidr, newNode.IPv6AllocCIDR, oldIP6, newIP6, oldMTU, newMTU, firstAddition, n.nodeConfig.EnableIPv6)
+		n.updateSecondaryDirectRoutes(oldIP4Secondary, newNode.IPv4SecondaryAllocCIDRs, oldIP4, newIP4, oldMTU, newMTU, firstAddition, n.nodeConfig.EnableIPv4)
+		n.updateSecondaryDirectRoutes(oldIP6Secondary, newNode.IPv6SecondaryAllocCIDRs, oldIP6, newIP6, oldMTU, newMTU, firstAddition, n.nodeConfig.EnableIPv6)
 		return nil
 	}
 
@@ -629,24 +755,24 @@ func (n *linuxNodeHandler) nodeUpdate(oldNode, newNode *node.Node, firstAddition
 		updateTunnelMapping(oldIP4Cidr, newNode.IPv4AllocCIDR, oldIP4, newIP4, firstAddition, n.nodeConfig.EnableIPv4, oldKey, newKey)
 		// Not a typo, the IPv4 host IP is used to build the IPv6 overlay
 		updateTunnelMapping(oldIP6Cidr, newNode.IPv6AllocCIDR, oldIP4, newIP4, firstAddition, n.nodeConfig.EnableIPv6, oldKey, newKey)
+		updateSecondaryTunnelMappings(oldIP4Secondary, newNode.IPv4SecondaryAllocCIDRs, oldIP4, newIP4, firstAddition, n.nodeConfig.EnableIPv4, oldKey, newKey)
+		updateSecondaryTunnelMappings(oldIP6Secondary, newNode.IPv6SecondaryAllocCIDRs, oldIP4, newIP4, firstAddition, n.nodeConfig.EnableIPv6, oldKey, newKey)
 
 		if !n.nodeConfig.UseSingleClusterRoute {
-			n.updateOrRemoveNodeRoutes([]*cidr.CIDR{oldIP4Cidr}, []*cidr.CIDR{newNode.IPv4AllocCIDR})
-			n.updateOrRemoveNodeRoutes([]*cidr.CIDR{oldIP6Cidr}, []*cidr.CIDR{newNode.IPv6AllocCIDR})
+			n.updateOrRemoveNodeRoutes(oldIP4AllocCIDRs, newNode.GetIPv4AllocCIDRs())
+			n.updateOrRemoveNodeRoutes(oldIP6AllocCIDRs, newNode.GetIPv6AllocCIDRs())
 		}
 
 		return nil
 	} else if firstAddition {
 		// When encapsulation is disabled, then the initial node addition
 		// triggers a removal of eventual old tunnel map entries.
-		deleteTunnelMapping(newNode.IPv4AllocCIDR, true)
-		deleteTunnelMapping(newNode.IPv6AllocCIDR, true)
+		for _, allocCIDR := range append(newNode.GetIPv4AllocCIDRs(), newNode.GetIPv6AllocCIDRs()...) {
+			deleteTunnelMapping(allocCIDR, true)
 
-		if rt, _ := n.lookupNodeRoute(newNode.IPv4AllocCIDR); rt != nil {
-			n.deleteNodeRoute(newNode.IPv4AllocCIDR)
-		}
-		if rt, _ := n.lookupNodeRoute(newNode.IPv6AllocCIDR); rt != nil {
-			n.deleteNodeRoute(newNode.IPv6AllocCIDR)
+			if rt, _ := n.lookupNodeRoute(allocCIDR); rt != nil {
+				n.deleteNodeRoute(allocCIDR)
+			}
 		}
 	}
 
@@ -680,15 +806,21 @@ func (n *linuxNodeHandler) nodeDelete(oldNode *node.Node) error {
 	if n.nodeConfig.EnableAutoDirectRouting {
 		n.deleteDirectRoute(oldNode.IPv4AllocCIDR, oldIP4)
 		n.deleteDirectRoute(oldNode.IPv6AllocCIDR, oldIP6)
+		for _, allocCIDR := range oldNode.IPv4SecondaryAllocCIDRs {
+			n.deleteDirectRoute(allocCIDR, oldIP4)
+		}
+		for _, allocCIDR := range oldNode.IPv6SecondaryAllocCIDRs {
+			n.deleteDirectRoute(allocCIDR, oldIP6)
+		}
 	}
 
 	if n.nodeConfig.EnableEncapsulation {
-		deleteTunnelMapping(oldNode.IPv4AllocCIDR, false)
-		deleteTunnelMapping(oldNode.IPv6AllocCIDR, false)
+		for _, allocCIDR := range append(oldNode.GetIPv4AllocCIDRs(), oldNode.GetIPv6AllocCIDRs()...) {
+			deleteTunnelMapping(allocCIDR, false)
 
-		if !n.nodeConfig.UseSingleClusterRoute {
-			n.deleteNodeRoute(oldNode.IPv4AllocCIDR)
-			n.deleteNodeRoute(oldNode.IPv6AllocCIDR)
+			if !n.nodeConfig.UseSingleClusterRoute {
+				n.deleteNodeRoute(allocCIDR)
+			}
 		}
 	}
 
@@ -21,7 +21,10 @@ import (
 
 	"github.com/cilium/cilium/pkg/checker"
 	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/datapath"
 	"github.com/cilium/cilium/pkg/datapath/fake"
+	"github.com/cilium/cilium/pkg/mtu"
+	"github.com/cilium/cilium/pkg/node"
 
 	"gopkg.in/check.v1"
 )
@@ -81,3 +84,28 @@ func (s *linuxTestSuite) TestCreateNodeRoute(c *check.C) {
 	c.Assert(*generatedRoute.Nexthop, checker.DeepEquals, fakeNodeAddressing.IPv6().Router())
 	c.Assert(generatedRoute.Local, checker.DeepEquals, fakeNodeAddressing.IPv6().PrimaryExternal())
 }
+
+func (s *linuxTestSuite) TestNodeBatchAdd(c *check.C) {
+	nodeHandler := NewNodeHandler(DatapathConfiguration{}, fake.NewNodeAddressing())
+	bh, ok := nodeHandler.(datapath.NodeBatchHandler)
+	c.Assert(ok, check.Equals, true)
+
+	// Nodes added before the handler is initialized are only cached
+	nodes := []node.Node{{Name: "node1"}, {Name: "node2"}}
+	c.Assert(bh.NodeBatchAdd(nodes), check.IsNil)
+
+	handler := nodeHandler.(*linuxNodeHandler)
+	c.Assert(handler.nodes, check.HasLen, 2)
+	c.Assert(handler.nodes[nodes[0].Identity()].Name, check.Equals, "node1")
+	c.Assert(handler.nodes[nodes[1].Identity()].Name, check.Equals, "node2")
+}
+
+func (s *linuxTestSuite) TestDirectRouteMTU(c *check.C) {
+	nodeHandler := NewNodeHandler(DatapathConfiguration{}, fake.NewNodeAddressing()).(*linuxNodeHandler)
+	nodeHandler.nodeConfig.MtuConfig = mtu.NewConfiguration(0, false, false, 1500)
+
+	c.Assert(nodeHandler.directRouteMTU(nil), check.Equals, 0)
+	c.Assert(nodeHandler.directRouteMTU(&node.Node{}), check.Equals, 0)
+	c.Assert(nodeHandler.directRouteMTU(&node.Node{MTU: 9000}), check.Equals, 0)
+	c.Assert(nodeHandler.directRouteMTU(&node.Node{MTU: 1450}), check.Equals, 1450)
+}
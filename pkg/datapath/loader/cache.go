@@ -50,6 +50,7 @@ var (
 		"SNAT_IPV6_EXTERNAL",   // Global
 		"cilium_ct",            // All CT maps, including local
 		"cilium_encrypt_state", // Global
+		"cilium_ep_metrics",    // Global
 		"cilium_events",        // Global
 		"cilium_ipcache",       // Global
 		"cilium_lb",            // Global
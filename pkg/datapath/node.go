@@ -134,3 +134,22 @@ type NodeHandler interface {
 	// has changed
 	NodeConfigurationChanged(config LocalNodeConfiguration) error
 }
+
+// NodeBatchHandler is implemented by node handlers able to add multiple nodes
+// at once, e.g. to program routes and tunnels once for all nodes of the
+// initial synchronization. Node handlers not implementing it receive a
+// NodeAdd() call per node instead.
+type NodeBatchHandler interface {
+	// NodeBatchAdd is called when multiple nodes are discovered for the
+	// first time
+	NodeBatchAdd(newNodes []node.Node) error
+}
+
+// NodeAddressHandler is implemented by node handlers able to update only the
+// addresses of a node which have changed, e.g. IPsec states or tunnel
+// endpoints, instead of reconciling the whole node on NodeUpdate().
+type NodeAddressHandler interface {
+	// NodeAddressesChanged is called after a node update has added or
+	// removed addresses of a known node
+	NodeAddressesChanged(n node.Node, added, removed []node.Address) error
+}
@@ -110,6 +110,15 @@ const (
 	// option.IdentityChangeGracePeriod
 	IdentityChangeGracePeriod = 5 * time.Second
 
+	// IdentityAllocationBulkLimit is the maximum number of global identity
+	// allocations of normal priority performed against the kvstore in
+	// parallel. Critical allocations are not subject to the limit.
+	IdentityAllocationBulkLimit = 32
+
+	// IdentityQuarantinePeriod is the default value for
+	// option.IdentityQuarantinePeriod
+	IdentityQuarantinePeriod = 0 * time.Second
+
 	// ExecTimeout is a timeout for executing commands.
 	ExecTimeout = 300 * time.Second
 
@@ -171,6 +180,31 @@ const (
 	// KVstorePeriodicSync is the default kvstore periodic sync interval
 	KVstorePeriodicSync = 5 * time.Minute
 
+	// KVstoreStoreReconcileInterval is the default interval in which
+	// shared stores are reconciled with the contents of the kvstore
+	KVstoreStoreReconcileInterval = 10 * time.Minute
+
+	// KVstoreMaxValueSize is the default maximum size in bytes of a value
+	// stored in the kvstore
+	KVstoreMaxValueSize = 512 * 1024
+
+	// KVstoreRateLimit is the default maximum rate per second of each
+	// class of kvstore operations, 0 disables rate limiting
+	KVstoreRateLimit = 0
+
+	// KVstoreCircuitBreakerThreshold is the default number of consecutive
+	// failed kvstore operations opening the circuit breaker, 0 disables
+	// the circuit breaker
+	KVstoreCircuitBreakerThreshold = 0
+
+	// KVstoreCircuitBreakerTimeout is the default time the kvstore circuit
+	// breaker stays open
+	KVstoreCircuitBreakerTimeout = 10 * time.Second
+
+	// KVstoreCircuitBreakerMaxAge is the default maximum age of cached
+	// reads served while the kvstore circuit breaker is open
+	KVstoreCircuitBreakerMaxAge = 5 * time.Minute
+
 	// PolicyQueueSize is the default queue size for policy-related events.
 	PolicyQueueSize = 100
 
@@ -212,6 +246,18 @@ const (
 	// clusters.
 	K8sEventHandover = false
 
+	// K8sNodeResyncPeriod is the default period in which all Kubernetes
+	// nodes are resynced from the local cache. Disabled by default.
+	K8sNodeResyncPeriod = time.Duration(0)
+
+	// K8sNodeRelistBackoffMin is the default minimum backoff between
+	// attempts to re-list a single Kubernetes node
+	K8sNodeRelistBackoffMin = time.Second
+
+	// K8sNodeRelistBackoffMax is the default maximum backoff between
+	// attempts to re-list a single Kubernetes node
+	K8sNodeRelistBackoffMax = time.Minute
+
 	// LoopbackIPv4 is the default address for service loopback
 	LoopbackIPv4 = "169.254.42.1"
 
@@ -241,11 +287,27 @@ const (
 	// ring buffer interacting with the kernel
 	MonitorBufferPages = 64
 
+	// MetricsMapSyncInterval is the default value for
+	// option.MetricsMapSyncInterval
+	MetricsMapSyncInterval = 5 * time.Second
+
 	// NodeDeleteDelay is the delay before an unreliable node delete is
 	// handled. During this delay, the node can re-appear and the delete
 	// event is ignored.
 	NodeDeleteDelay = 30 * time.Second
 
+	// NodeAddressDNSTTL is the time for which the resolved IPs of a node
+	// address DNS name are used before the name is resolved again
+	NodeAddressDNSTTL = 5 * time.Minute
+
+	// NodeAddressDNSTimeout is the timeout of the resolution of a node
+	// address DNS name
+	NodeAddressDNSTimeout = 2 * time.Second
+
+	// NodeRegistrationWatchdogInterval is the interval in which the
+	// registration of the local node in the kvstore is verified
+	NodeRegistrationWatchdogInterval = time.Minute
+
 	// KVstoreLeaseTTL is the time-to-live of the kvstore lease.
 	KVstoreLeaseTTL = 15 * time.Minute
 
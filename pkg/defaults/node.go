@@ -42,6 +42,12 @@ const (
 	// HostDevice is the name of the device that connects the cilium IP
 	// space with the host's networking model
 	HostDevice = "cilium_host"
+
+	// TunnelPortVXLAN is the UDP port used for VXLAN encapsulation
+	TunnelPortVXLAN = 8472
+
+	// TunnelPortGeneve is the UDP port used for Geneve encapsulation
+	TunnelPortGeneve = 6081
 )
 
 var (
@@ -74,4 +80,8 @@ var (
 
 	// IPv4DefaultRoute is the default IPv4 route.
 	IPv4DefaultRoute = net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}
+
+	// NodeAddressTypes are the Kubernetes node address types considered
+	// for the node IP, in order of preference
+	NodeAddressTypes = []string{"InternalIP", "ExternalIP"}
 )
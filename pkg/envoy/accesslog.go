@@ -63,11 +63,15 @@ func GetProtocol(httpProtocol cilium.HttpProtocol) string {
 	}
 }
 
-// GetFlowType returns the type of flow (request|response)
+// GetFlowType returns the type of flow (request|response|event)
 func GetFlowType(m *cilium.LogEntry) accesslog.FlowType {
 	// the fall back type is request
 	result := accesslog.TypeRequest
 
+	if _, ok := m.GetGenericL7().GetFields()[accesslog.FieldEvent]; ok {
+		return accesslog.TypeEvent
+	}
+
 	if m != nil {
 		switch m.EntryType {
 		case cilium.EntryType_Denied:
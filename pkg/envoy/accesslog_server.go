@@ -19,11 +19,13 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/cilium/cilium/pkg/flowdebug"
+	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/proxy/accesslog"
 	"github.com/cilium/cilium/pkg/proxy/logger"
 
@@ -128,6 +130,20 @@ func (s *accessLogServer) accessLogger(conn *net.UnixConn) {
 	}
 }
 
+// observeVerdictLatency records the verdict latency reported by proxylib
+// parsers in generic L7 log entries, if any.
+func observeVerdictLatency(l7 *cilium.L7LogEntry) {
+	latency, ok := l7.GetFields()[accesslog.FieldVerdictLatency]
+	if !ok {
+		return
+	}
+	us, err := strconv.ParseUint(latency, 10, 64)
+	if err != nil {
+		return
+	}
+	metrics.ProxyL7VerdictLatency.WithLabelValues(l7.GetProto()).Observe((time.Duration(us) * time.Microsecond).Seconds())
+}
+
 func (s *accessLogServer) logRecord(localEndpoint logger.EndpointUpdater, pblog *cilium.LogEntry) {
 	// TODO: Support Kafka.
 
@@ -145,6 +161,7 @@ func (s *accessLogServer) logRecord(localEndpoint logger.EndpointUpdater, pblog
 			Proto:  l7.GetProto(),
 			Fields: l7.GetFields(),
 		})
+		observeVerdictLatency(l7)
 	} else {
 		// Default to the deprecated HTTP log format
 		l7tags = logger.LogTags.HTTP(&accesslog.LogRecordHTTP{
@@ -167,6 +184,12 @@ func (s *accessLogServer) logRecord(localEndpoint logger.EndpointUpdater, pblog
 
 	r.Log()
 
+	// Synthetic events emitted by parsers are neither requests nor
+	// responses and are not accounted in the proxy statistics
+	if r.Type == accesslog.TypeEvent {
+		return
+	}
+
 	// Update stats for the endpoint.
 	ingress := r.ObservationPoint == accesslog.Ingress
 	request := r.Type == accesslog.TypeRequest
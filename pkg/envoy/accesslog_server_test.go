@@ -17,6 +17,8 @@
 package envoy
 
 import (
+	"github.com/cilium/cilium/pkg/proxy/accesslog"
+
 	"github.com/cilium/proxy/go/cilium/api"
 
 	. "gopkg.in/check.v1"
@@ -40,3 +42,19 @@ func (k *AccessLogServerSuite) TestParseURL(c *C) {
 		c.Assert(u.Path, Equals, "/foo")
 	}
 }
+
+func (k *AccessLogServerSuite) TestGetFlowType(c *C) {
+	c.Assert(GetFlowType(nil), Equals, accesslog.TypeRequest)
+	c.Assert(GetFlowType(&cilium.LogEntry{EntryType: cilium.EntryType_Response}), Equals, accesslog.TypeResponse)
+
+	event := &cilium.LogEntry{
+		EntryType: cilium.EntryType_Request,
+		L7: &cilium.LogEntry_GenericL7{
+			GenericL7: &cilium.L7LogEntry{
+				Proto:  "r2d2",
+				Fields: map[string]string{accesslog.FieldEvent: "malformed-frame"},
+			},
+		},
+	}
+	c.Assert(GetFlowType(event), Equals, accesslog.TypeEvent)
+}
@@ -282,16 +282,22 @@ func StartXDSServer(stateDir string) *XDSServer {
 		httpFilterChainProto.Filters[1].ConfigType.(*envoy_api_v2_listener.Filter_Config).Config.Fields["route_config"].GetStructValue().Fields["virtual_hosts"].GetListValue().Values[0].GetStructValue().Fields["routes"].GetListValue().Values[1].GetStructValue().Fields["route"].GetStructValue().Fields["idle_timeout"] = &structpb.Value{Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{"seconds": {Kind: &structpb.Value_NumberValue{NumberValue: float64(idleTimeout)}}}}}}
 	}
 
+	proxylibParams := map[string]*structpb.Value{
+		"access-log-path": {Kind: &structpb.Value_StringValue{StringValue: accessLogPath}},
+		"xds-path":        {Kind: &structpb.Value_StringValue{StringValue: xdsPath}},
+	}
+	// Connections matching the passthrough rules bypass L7 parsing
+	if len(option.Config.ProxylibPassthrough) > 0 {
+		proxylibParams["passthrough"] = &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: strings.Join(option.Config.ProxylibPassthrough, ",")}}
+	}
+
 	tcpFilterChainProto := &envoy_api_v2_listener.FilterChain{
 		Filters: []*envoy_api_v2_listener.Filter{{
 			Name: "cilium.network",
 			ConfigType: &envoy_api_v2_listener.Filter_Config{
 				Config: &structpb.Struct{Fields: map[string]*structpb.Value{
-					"proxylib": {Kind: &structpb.Value_StringValue{StringValue: "libcilium.so"}},
-					"proxylib_params": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
-						"access-log-path": {Kind: &structpb.Value_StringValue{StringValue: accessLogPath}},
-						"xds-path":        {Kind: &structpb.Value_StringValue{StringValue: xdsPath}},
-					}}}},
+					"proxylib":        {Kind: &structpb.Value_StringValue{StringValue: "libcilium.so"}},
+					"proxylib_params": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: proxylibParams}}},
 					// "l7_proto": {Kind: &structpb.Value_StringValue{StringValue: "parsername"}},
 					// "policy_name": {Kind: &structpb.Value_StringValue{StringValue: "1.2.3.4"}},
 				}},
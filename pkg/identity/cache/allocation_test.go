@@ -82,6 +82,17 @@ func (s *IdentityCacheTestSuite) TestAllocateIdentityReserved(c *C) {
 	c.Assert(isNew, Equals, false)
 }
 
+func (s *IdentityCacheTestSuite) TestAllocationPriority(c *C) {
+	lbls := labels.NewLabelsFromModel([]string{"k8s:app=web", "k8s:io.kubernetes.pod.namespace=default"})
+	c.Assert(allocationPriority(lbls), Equals, allocator.PriorityNormal)
+
+	lbls = labels.NewLabelsFromModel([]string{"k8s:k8s-app=kube-dns", "k8s:io.kubernetes.pod.namespace=kube-system"})
+	c.Assert(allocationPriority(lbls), Equals, allocator.PriorityCritical)
+
+	lbls = labels.NewLabelsFromModel([]string{"reserved:host", "k8s:app=web"})
+	c.Assert(allocationPriority(lbls), Equals, allocator.PriorityCritical)
+}
+
 type IdentityAllocatorSuite struct{}
 
 type IdentityAllocatorEtcdSuite struct {
@@ -19,8 +19,10 @@ import (
 	"fmt"
 	"path"
 
+	"github.com/cilium/cilium/pkg/defaults"
 	"github.com/cilium/cilium/pkg/identity"
 	"github.com/cilium/cilium/pkg/idpool"
+	k8sConst "github.com/cilium/cilium/pkg/k8s/apis/cilium.io"
 	"github.com/cilium/cilium/pkg/kvstore"
 	"github.com/cilium/cilium/pkg/kvstore/allocator"
 	"github.com/cilium/cilium/pkg/labels"
@@ -32,6 +34,11 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// systemNamespace is the namespace of system-critical pods
+	systemNamespace = "kube-system"
+)
+
 // globalIdentity is the structure used to store an identity in the kvstore
 type globalIdentity struct {
 	labels.LabelArray
@@ -130,12 +137,20 @@ func InitIdentityAllocator(owner IdentityAllocatorOwner) <-chan struct{} {
 		setupMutex.Lock()
 		defer setupMutex.Unlock()
 
-		a, err := allocator.NewAllocator(IdentitiesPath, globalIdentity{},
+		opts := []allocator.AllocatorOption{
 			allocator.WithMax(maxID), allocator.WithMin(minID),
 			allocator.WithSuffix(owner.GetNodeSuffix()),
 			allocator.WithEvents(evs),
 			allocator.WithMasterKeyProtection(),
-			allocator.WithPrefixMask(idpool.ID(option.Config.ClusterID<<identity.ClusterIDShift)))
+			allocator.WithBulkAllocationLimit(defaults.IdentityAllocationBulkLimit),
+			allocator.WithQuarantine(option.Config.IdentityQuarantinePeriod),
+			allocator.WithPrefixMask(idpool.ID(option.Config.ClusterID << identity.ClusterIDShift)),
+		}
+		if option.Config.IdentityCompression {
+			opts = append(opts, allocator.WithCompression())
+		}
+
+		a, err := allocator.NewAllocator(IdentitiesPath, globalIdentity{}, opts...)
 		if err != nil {
 			log.WithError(err).Fatal("Unable to initialize identity allocator")
 		}
@@ -203,6 +218,26 @@ func IdentityAllocationIsLocal(lbls labels.Labels) bool {
 	return LookupReservedIdentityByLabels(lbls) != nil
 }
 
+// allocationPriority returns the priority of the global identity allocation
+// for the given labels. Identities including reserved labels, e.g. of the
+// host or remote nodes, and identities of pods in the kube-system namespace
+// are allocated with critical priority so that they are not delayed by bulk
+// pod churn.
+func allocationPriority(lbls labels.Labels) allocator.AllocationPriority {
+	for _, l := range lbls {
+		if l.Source == labels.LabelSourceReserved {
+			return allocator.PriorityCritical
+		}
+	}
+
+	if ns, ok := lbls[k8sConst.PodNamespaceLabel]; ok &&
+		ns.Source == labels.LabelSourceK8s && ns.Value == systemNamespace {
+		return allocator.PriorityCritical
+	}
+
+	return allocator.PriorityNormal
+}
+
 // AllocateIdentity allocates an identity described by the specified labels. If
 // an identity for the specified set of labels already exist, the identity is
 // re-used and reference counting is performed, otherwise a new identity is
@@ -254,7 +289,8 @@ func AllocateIdentity(ctx context.Context, owner IdentityAllocatorOwner, lbls la
 		return nil, false, fmt.Errorf("allocator not initialized")
 	}
 
-	idp, isNew, err := IdentityAllocator.Allocate(ctx, globalIdentity{lbls.LabelArray()})
+	idp, isNew, err := IdentityAllocator.AllocateWithPriority(ctx, globalIdentity{lbls.LabelArray()},
+		allocationPriority(lbls))
 	if err != nil {
 		return nil, false, err
 	}
@@ -337,7 +373,19 @@ func ReleaseSlice(ctx context.Context, owner IdentityAllocatorOwner, identities
 
 // WatchRemoteIdentities starts watching for identities in another kvstore and
 // syncs all identities to the local identity cache.
-func WatchRemoteIdentities(backend kvstore.BackendOperations) *allocator.RemoteCache {
+func WatchRemoteIdentities(clusterName string, backend kvstore.BackendOperations) *allocator.RemoteCache {
 	<-globalIdentityAllocatorInitialized
-	return IdentityAllocator.WatchRemoteKVStore(backend, IdentitiesPath)
+	return IdentityAllocator.WatchRemoteKVStore(clusterName, backend, IdentitiesPath)
+}
+
+// FreezeIdentities freezes the allocation of new global identities by all
+// agents sharing the kvstore, see allocator.Allocator.Freeze()
+func FreezeIdentities(ctx context.Context, reason string) error {
+	return allocator.NewAllocatorForGC(IdentitiesPath).Freeze(ctx, reason)
+}
+
+// ThawIdentities resumes the allocation of new global identities frozen
+// with FreezeIdentities()
+func ThawIdentities() error {
+	return allocator.NewAllocatorForGC(IdentitiesPath).Thaw()
 }
@@ -16,6 +16,7 @@ package cache
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/cilium/cilium/pkg/identity"
 	"github.com/cilium/cilium/pkg/idpool"
@@ -104,9 +105,10 @@ func (l *localIdentityCache) lookupOrCreate(lbls labels.Labels) (*identity.Ident
 
 	if l.events != nil {
 		l.events <- allocator.AllocatorEvent{
-			Typ: kvstore.EventTypeCreate,
-			ID:  idpool.ID(id.ID),
-			Key: globalIdentity{id.LabelArray},
+			Typ:       kvstore.EventTypeCreate,
+			ID:        idpool.ID(id.ID),
+			Key:       globalIdentity{id.LabelArray},
+			Timestamp: time.Now(),
 		}
 	}
 
@@ -136,8 +138,9 @@ func (l *localIdentityCache) release(id *identity.Identity) bool {
 
 			if l.events != nil {
 				l.events <- allocator.AllocatorEvent{
-					Typ: kvstore.EventTypeDelete,
-					ID:  idpool.ID(id.ID),
+					Typ:       kvstore.EventTypeDelete,
+					ID:        idpool.ID(id.ID),
+					Timestamp: time.Now(),
 				}
 			}
 
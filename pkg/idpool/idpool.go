@@ -16,6 +16,7 @@ package idpool
 
 import (
 	"math/rand"
+	"sort"
 	"strconv"
 	"time"
 
@@ -98,6 +99,16 @@ func (p *IDPool) LeaseAvailableID() ID {
 	return p.idCache.leaseAvailableID()
 }
 
+// LeaseID leases the specified ID if it is available in the pool. Returns true
+// if the ID was leased as a result of this call. Like for LeaseAvailableID(),
+// the ID must be Use()d or Release()d afterwards.
+func (p *IDPool) LeaseID(id ID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.idCache.leaseID(id)
+}
+
 // AllocateID returns a random available ID. Unlike LeaseAvailableID, the ID is
 // immediately marked for use and there is no need to call Use().
 func (p *IDPool) AllocateID() ID {
@@ -151,6 +162,67 @@ func (p *IDPool) Remove(id ID) bool {
 	return p.idCache.remove(id)
 }
 
+// Stats is a snapshot of the utilization of an IDPool
+type Stats struct {
+	// Available is the number of IDs available for leasing
+	Available uint64
+
+	// Leased is the number of IDs currently leased
+	Leased uint64
+
+	// InUse is the number of IDs in the range minID..maxID which are
+	// neither available nor leased
+	InUse uint64
+
+	// LargestFreeRange is the number of IDs in the largest range of
+	// consecutive available IDs. A low value compared to Available
+	// indicates a fragmented ID space.
+	LargestFreeRange uint64
+}
+
+// Stats returns statistics about the utilization of the pool
+func (p *IDPool) Stats() Stats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := Stats{
+		Available: uint64(len(p.idCache.ids)),
+		Leased:    uint64(len(p.idCache.leased)),
+	}
+
+	ids := make([]ID, 0, len(p.idCache.ids))
+	var inRange uint64
+	for id := range p.idCache.ids {
+		ids = append(ids, id)
+		if id >= p.minID && id <= p.maxID {
+			inRange++
+		}
+	}
+	for id := range p.idCache.leased {
+		if id >= p.minID && id <= p.maxID {
+			inRange++
+		}
+	}
+	if p.maxID >= p.minID {
+		stats.InUse = uint64(p.maxID-p.minID) + 1 - inRange
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	var current uint64
+	for i := range ids {
+		if i > 0 && ids[i] == ids[i-1]+1 {
+			current++
+		} else {
+			current = 1
+		}
+		if current > stats.LargestFreeRange {
+			stats.LargestFreeRange = current
+		}
+	}
+
+	return stats
+}
+
 type idCache struct {
 	// ids is a slice of IDs available in this idCache.
 	ids map[ID]struct{}
@@ -203,6 +275,18 @@ func (c *idCache) leaseAvailableID() ID {
 	return id
 }
 
+// leaseID leases the ID if it is available
+func (c *idCache) leaseID(id ID) bool {
+	if _, ok := c.ids[id]; !ok {
+		return false
+	}
+
+	delete(c.ids, id)
+	c.leased[id] = struct{}{}
+
+	return true
+}
+
 // release makes the ID available again if it is currently
 // leased and has no effect otherwise. Returns true if the
 // ID was made available as a result of this call.
@@ -347,3 +347,42 @@ func (s *IDPoolTestSuite) TestAllocateID(c *C) {
 		c.Assert(p.Insert(id), Equals, true)
 	}
 }
+
+func (s *IDPoolTestSuite) TestLeaseID(c *C) {
+	p := NewIDPool(ID(1), ID(5))
+
+	c.Assert(p.LeaseID(ID(3)), Equals, true)
+	// A leased ID can't be leased again
+	c.Assert(p.LeaseID(ID(3)), Equals, false)
+	// IDs outside of the range are not available
+	c.Assert(p.LeaseID(ID(6)), Equals, false)
+
+	c.Assert(p.Use(ID(3)), Equals, true)
+	c.Assert(p.LeaseID(ID(3)), Equals, false)
+
+	c.Assert(p.Insert(ID(3)), Equals, true)
+	c.Assert(p.LeaseID(ID(3)), Equals, true)
+	c.Assert(p.Release(ID(3)), Equals, true)
+	c.Assert(p.LeaseID(ID(3)), Equals, true)
+}
+
+func (s *IDPoolTestSuite) TestStats(c *C) {
+	p := NewIDPool(ID(1), ID(10))
+	c.Assert(p.Stats(), checker.DeepEquals, Stats{Available: 10, LargestFreeRange: 10})
+
+	c.Assert(p.Remove(ID(4)), Equals, true)
+	c.Assert(p.LeaseID(ID(8)), Equals, true)
+	c.Assert(p.Stats(), checker.DeepEquals, Stats{
+		Available:        8,
+		Leased:           1,
+		InUse:            1,
+		LargestFreeRange: 3,
+	})
+
+	c.Assert(p.Use(ID(8)), Equals, true)
+	c.Assert(p.Stats(), checker.DeepEquals, Stats{
+		Available:        8,
+		InUse:            2,
+		LargestFreeRange: 3,
+	})
+}
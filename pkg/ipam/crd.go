@@ -15,6 +15,7 @@
 package ipam
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"reflect"
@@ -95,7 +96,8 @@ func newNodeStore(nodeName string, owner Owner) *nodeStore {
 
 		// Tie the CiliumNode custom resource lifecycle to the
 		// lifecycle of the Kubernetes node
-		if k8sNode, err := k8s.GetNode(k8s.Client(), node.GetName()); err != nil {
+		if k8sNode, err := k8s.GetNode(context.TODO(), k8s.Client(), node.GetName(),
+			k8s.GetNodeOptions{Timeout: k8s.DefaultGetNodeTimeout}); err != nil {
 			log.Warning("Kubernetes node resource representing own node is not available, cannot set OwnerReference")
 		} else {
 			nodeResource.ObjectMeta.OwnerReferences = []metav1.OwnerReference{{
@@ -18,10 +18,12 @@ import (
 	"net"
 	"strings"
 
+	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/datapath"
 	"github.com/cilium/cilium/pkg/defaults"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
 	"github.com/cilium/cilium/pkg/option"
 	cnitypes "github.com/cilium/cilium/plugins/cilium-cni/types"
 
@@ -92,11 +94,13 @@ func NewIPAM(nodeAddressing datapath.NodeAddressing, c Configuration, owner Owne
 		}).Info("Initializing hostscope IPAM")
 
 		if c.EnableIPv6 {
-			ipam.IPv6Allocator = newHostScopeAllocator(nodeAddressing.IPv6().AllocationCIDR().IPNet)
+			ipam.IPv6Allocator = newMultiRangeAllocator(nodeAddressing.IPv6().AllocationCIDR().IPNet,
+				node.GetIPv6SecondaryAllocRanges())
 		}
 
 		if c.EnableIPv4 {
-			ipam.IPv4Allocator = newHostScopeAllocator(nodeAddressing.IPv4().AllocationCIDR().IPNet)
+			ipam.IPv4Allocator = newMultiRangeAllocator(nodeAddressing.IPv4().AllocationCIDR().IPNet,
+				node.GetIPv4SecondaryAllocRanges())
 		}
 	case option.IPAMCRD, option.IPAMENI:
 		log.Info("Initializing CRD-based IPAM")
@@ -114,6 +118,21 @@ func NewIPAM(nodeAddressing datapath.NodeAddressing, c Configuration, owner Owne
 	return ipam
 }
 
+// UpdateSecondaryAllocRanges updates the secondary allocation ranges of the
+// node out of which IPs are allocated once the primary allocation range has
+// been exhausted. Only the hostscope IPAM allocates out of secondary ranges.
+func (ipam *IPAM) UpdateSecondaryAllocRanges(v4, v6 []*cidr.CIDR) {
+	ipam.allocatorMutex.Lock()
+	defer ipam.allocatorMutex.Unlock()
+
+	if a, ok := ipam.IPv4Allocator.(*multiRangeAllocator); ok {
+		a.setSecondaryRanges(v4)
+	}
+	if a, ok := ipam.IPv6Allocator.(*multiRangeAllocator); ok {
+		a.setSecondaryRanges(v6)
+	}
+}
+
 func nextIP(ip net.IP) {
 	for j := len(ip) - 1; j >= 0; j-- {
 		ip[j]++
@@ -0,0 +1,165 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/cidr"
+)
+
+// secondaryRange is a secondary allocation range of the node
+type secondaryRange struct {
+	allocator *hostScopeAllocator
+
+	// removed is true if the range is no longer assigned to the node. No
+	// IPs are allocated out of a removed range, it is released once all
+	// of its IPs have been released.
+	removed bool
+}
+
+// multiRangeAllocator allocates IPs out of the primary allocation range of
+// the node and falls back to the secondary allocation ranges once the
+// primary range has been exhausted. Access is serialized by the
+// allocatorMutex of the IPAM.
+type multiRangeAllocator struct {
+	primary   *hostScopeAllocator
+	secondary map[string]*secondaryRange
+	// order is the order in which the secondary ranges were assigned
+	order []string
+}
+
+func newMultiRangeAllocator(primary *net.IPNet, secondary []*cidr.CIDR) *multiRangeAllocator {
+	m := &multiRangeAllocator{
+		primary:   newHostScopeAllocator(primary).(*hostScopeAllocator),
+		secondary: map[string]*secondaryRange{},
+	}
+	m.setSecondaryRanges(secondary)
+	return m
+}
+
+// setSecondaryRanges sets the secondary allocation ranges. Ranges which are
+// no longer assigned are released once all of their IPs have been released.
+func (m *multiRangeAllocator) setSecondaryRanges(ranges []*cidr.CIDR) {
+	assigned := make(map[string]struct{}, len(ranges))
+	for _, c := range ranges {
+		key := c.String()
+		assigned[key] = struct{}{}
+
+		if r, ok := m.secondary[key]; ok {
+			r.removed = false
+			continue
+		}
+
+		m.secondary[key] = &secondaryRange{allocator: newHostScopeAllocator(c.IPNet).(*hostScopeAllocator)}
+		m.order = append(m.order, key)
+		log.Infof("Allocating IPs out of secondary allocation range %s", key)
+	}
+
+	for key, r := range m.secondary {
+		if _, ok := assigned[key]; !ok {
+			r.removed = true
+			m.releaseUnused(key)
+		}
+	}
+}
+
+// releaseUnused releases the secondary range if it has been removed and no
+// IPs are allocated out of it anymore
+func (m *multiRangeAllocator) releaseUnused(key string) {
+	r := m.secondary[key]
+	if !r.removed || r.allocator.allocator.Used() != 0 {
+		return
+	}
+
+	delete(m.secondary, key)
+	for i, k := range m.order {
+		if k == key {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// lookup returns the allocator of the range containing ip and the key of the
+// secondary range, which is empty for the primary range
+func (m *multiRangeAllocator) lookup(ip net.IP) (*hostScopeAllocator, string) {
+	if m.primary.allocCIDR.Contains(ip) {
+		return m.primary, ""
+	}
+	for _, key := range m.order {
+		if r := m.secondary[key]; r.allocator.allocCIDR.Contains(ip) {
+			return r.allocator, key
+		}
+	}
+	return nil, ""
+}
+
+func (m *multiRangeAllocator) Allocate(ip net.IP, owner string) (*AllocationResult, error) {
+	a, _ := m.lookup(ip)
+	if a == nil {
+		return nil, fmt.Errorf("IP %s is not within any allocation range of the node", ip)
+	}
+	return a.Allocate(ip, owner)
+}
+
+func (m *multiRangeAllocator) Release(ip net.IP) error {
+	a, key := m.lookup(ip)
+	if a == nil {
+		return fmt.Errorf("IP %s is not within any allocation range of the node", ip)
+	}
+	if err := a.Release(ip); err != nil {
+		return err
+	}
+	if key != "" {
+		m.releaseUnused(key)
+	}
+	return nil
+}
+
+func (m *multiRangeAllocator) AllocateNext(owner string) (*AllocationResult, error) {
+	result, err := m.primary.AllocateNext(owner)
+	if err == nil {
+		return result, nil
+	}
+
+	for _, key := range m.order {
+		r := m.secondary[key]
+		if r.removed {
+			continue
+		}
+		if result, secondaryErr := r.allocator.AllocateNext(owner); secondaryErr == nil {
+			return result, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (m *multiRangeAllocator) Dump() (map[string]string, string) {
+	alloc, status := m.primary.Dump()
+	statuses := []string{status}
+	for _, key := range m.order {
+		secondaryAlloc, secondaryStatus := m.secondary[key].allocator.Dump()
+		for ip, owner := range secondaryAlloc {
+			alloc[ip] = owner
+		}
+		statuses = append(statuses, secondaryStatus)
+	}
+
+	return alloc, strings.Join(statuses, ", ")
+}
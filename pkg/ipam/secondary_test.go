@@ -0,0 +1,64 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package ipam
+
+import (
+	"net"
+
+	"github.com/cilium/cilium/pkg/cidr"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *IPAMSuite) TestMultiRangeAllocator(c *C) {
+	a := newMultiRangeAllocator(cidr.MustParseCIDR("10.0.0.0/30").IPNet, nil)
+
+	// The primary range is used until it is exhausted
+	for i := 0; i < 2; i++ {
+		result, err := a.AllocateNext("test")
+		c.Assert(err, IsNil)
+		c.Assert(cidr.MustParseCIDR("10.0.0.0/30").Contains(result.IP), Equals, true)
+	}
+	_, err := a.AllocateNext("test")
+	c.Assert(err, Not(IsNil))
+
+	// IPs are allocated out of secondary ranges afterwards
+	secondary := cidr.MustParseCIDR("10.1.0.0/30")
+	a.setSecondaryRanges([]*cidr.CIDR{secondary})
+	_, err = a.Allocate(net.ParseIP("10.1.0.2"), "test")
+	c.Assert(err, IsNil)
+	result, err := a.AllocateNext("test")
+	c.Assert(err, IsNil)
+	c.Assert(result.IP.String(), Equals, "10.1.0.1")
+
+	_, err = a.Allocate(net.ParseIP("10.2.0.1"), "test")
+	c.Assert(err, Not(IsNil))
+
+	alloc, _ := a.Dump()
+	c.Assert(len(alloc), Equals, 4)
+
+	// A removed range is no longer used for allocations but is retained
+	// until all of its IPs have been released
+	a.setSecondaryRanges(nil)
+	_, err = a.AllocateNext("test")
+	c.Assert(err, Not(IsNil))
+	c.Assert(a.Release(result.IP), IsNil)
+	c.Assert(len(a.secondary), Equals, 1)
+	c.Assert(a.Release(net.ParseIP("10.1.0.2")), IsNil)
+	c.Assert(len(a.secondary), Equals, 0)
+	c.Assert(len(a.order), Equals, 0)
+}
@@ -0,0 +1,241 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipcache
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// identityBarrierTimeout is the maximum time an upsert is deferred
+	// waiting for the event of its identity. The upsert is applied after
+	// the timeout even if the identity has not been announced so that
+	// identities never announced, e.g. of remote clusters not being
+	// watched, do not prevent the upsert.
+	identityBarrierTimeout = 10 * time.Second
+)
+
+// parkedUpsert is an upsert deferred by the identity barrier
+type parkedUpsert struct {
+	entry UpsertEntry
+	timer *time.Timer
+}
+
+// identityBarrier defers ipcache upserts referring to global identities which
+// have not been announced by the identity allocator yet. Without it, an IP
+// learned from the kvstore or from a node event may be inserted into the
+// datapath before the policy layer knows its identity, classifying traffic
+// of the IP with an identity no policy selects yet.
+type identityBarrier struct {
+	// mutex protects all fields below. It is held while upserts are
+	// applied so that upserts of the same IP are applied in the order in
+	// which they were issued.
+	mutex lock.Mutex
+
+	// enabled is true if upserts are deferred until their identity is
+	// known
+	enabled bool
+
+	// known is the set of identities announced by the identity allocator
+	known map[identity.NumericIdentity]struct{}
+
+	// parked maps IPs to their deferred upsert
+	parked map[string]*parkedUpsert
+}
+
+func newIdentityBarrier() *identityBarrier {
+	return &identityBarrier{
+		known:  map[identity.NumericIdentity]struct{}{},
+		parked: map[string]*parkedUpsert{},
+	}
+}
+
+// isKnownLocked returns true if upserts referring to id can be applied
+// immediately. Must be called with b.mutex held.
+func (b *identityBarrier) isKnownLocked(id identity.NumericIdentity) bool {
+	if !b.enabled || id.IsReservedIdentity() || id.HasLocalScope() {
+		return true
+	}
+	_, ok := b.known[id]
+	return ok
+}
+
+// cancelLocked drops the upsert deferred for ip, if any. Must be called with
+// b.mutex held.
+func (b *identityBarrier) cancelLocked(ip string) {
+	if p, ok := b.parked[ip]; ok {
+		p.timer.Stop()
+		delete(b.parked, ip)
+	}
+}
+
+// EnableIdentityBarrier enables the ordering of upserts performed with
+// UpsertOrdered after the events of the identities they refer to. Once
+// enabled, UpdateKnownIdentities must be called for all identities announced
+// by the identity allocator.
+func (ipc *IPCache) EnableIdentityBarrier() {
+	ipc.barrier.mutex.Lock()
+	ipc.barrier.enabled = true
+	ipc.barrier.mutex.Unlock()
+}
+
+// UpdateKnownIdentities records the identities added and deleted by the
+// identity allocator and applies all upserts deferred waiting for the added
+// identities. It must be called after the identities have been propagated to
+// the policy layer.
+func (ipc *IPCache) UpdateKnownIdentities(added, deleted []identity.NumericIdentity) {
+	b := ipc.barrier
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, id := range deleted {
+		delete(b.known, id)
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	var entries []UpsertEntry
+	for _, id := range added {
+		b.known[id] = struct{}{}
+	}
+	for ip, p := range b.parked {
+		if _, ok := b.known[p.entry.Identity.ID]; ok {
+			p.timer.Stop()
+			delete(b.parked, ip)
+			entries = append(entries, p.entry)
+		}
+	}
+
+	if len(entries) != 0 {
+		ipc.UpsertBatch(entries)
+	}
+}
+
+// UpsertOrdered upserts entries into the ipcache like UpsertBatch. If the
+// identity barrier is enabled, entries referring to a global identity which
+// has not been announced by the identity allocator yet are deferred until the
+// identity is announced, see UpdateKnownIdentities, or identityBarrierTimeout
+// has passed. Any upsert deferred for the IP of an entry is replaced by the
+// entry so that the last event for an IP always wins. Returns the number of
+// IPs for which the upsert failed, see Upsert. Deferred upserts are not
+// counted.
+func (ipc *IPCache) UpsertOrdered(entries []UpsertEntry) int {
+	b := ipc.barrier
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	ready := ipc.parkUnknownLocked(entries)
+	if len(ready) == 0 {
+		return 0
+	}
+
+	ipc.mutex.Lock()
+	defer ipc.mutex.Unlock()
+	_, failed := ipc.upsertBatchLocked(ready)
+	return failed
+}
+
+// parkUnknownLocked defers the upsert of all entries referring to an
+// identity not known yet and returns the entries which can be upserted right
+// away. Any upsert deferred for the IP of an entry is cancelled. Must be
+// called with the barrier mutex held.
+func (ipc *IPCache) parkUnknownLocked(entries []UpsertEntry) []UpsertEntry {
+	b := ipc.barrier
+	ready := make([]UpsertEntry, 0, len(entries))
+	for _, e := range entries {
+		b.cancelLocked(e.IP)
+		if b.isKnownLocked(e.Identity.ID) {
+			ready = append(ready, e)
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			logfields.IPAddr:   e.IP,
+			logfields.Identity: e.Identity.ID,
+		}).Debug("Deferring ipcache upsert until identity is known")
+
+		p := &parkedUpsert{entry: e}
+		p.timer = time.AfterFunc(identityBarrierTimeout, func() {
+			ipc.releaseParked(p)
+		})
+		b.parked[e.IP] = p
+	}
+
+	return ready
+}
+
+// ReplaceOrdered removes the IPs in deleted owned by source from the ipcache
+// and upserts entries like UpsertOrdered. The deletions and the upserts of
+// entries referring to known identities are applied while holding the
+// ipcache lock, listeners never observe a state in which only some of them
+// have been applied. Returns the number of IPs for which the upsert failed,
+// see UpsertOrdered.
+func (ipc *IPCache) ReplaceOrdered(deleted []string, source Source, entries []UpsertEntry) int {
+	b := ipc.barrier
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, ip := range deleted {
+		b.cancelLocked(ip)
+	}
+	ready := ipc.parkUnknownLocked(entries)
+
+	ipc.mutex.Lock()
+	defer ipc.mutex.Unlock()
+	for _, ip := range deleted {
+		ipc.deleteLocked(ip, source)
+	}
+	_, failed := ipc.upsertBatchLocked(ready)
+	return failed
+}
+
+// releaseParked applies the deferred upsert p unless it has been applied or
+// replaced in the meantime
+func (ipc *IPCache) releaseParked(p *parkedUpsert) {
+	b := ipc.barrier
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.parked[p.entry.IP] != p {
+		return
+	}
+	delete(b.parked, p.entry.IP)
+
+	log.WithFields(logrus.Fields{
+		logfields.IPAddr:   p.entry.IP,
+		logfields.Identity: p.entry.Identity.ID,
+	}).Warning("Identity not known after timeout, upserting ipcache entry anyway")
+
+	ipc.UpsertBatch([]UpsertEntry{p.entry})
+}
+
+// DeleteOrdered removes ip from the ipcache like Delete and drops any upsert
+// of ip deferred by the identity barrier.
+func (ipc *IPCache) DeleteOrdered(ip string, source Source) {
+	b := ipc.barrier
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.cancelLocked(ip)
+	ipc.Delete(ip, source)
+}
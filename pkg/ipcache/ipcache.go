@@ -85,6 +85,10 @@ type IPCache struct {
 	v6PrefixLengths map[int]int
 
 	listeners []IPIdentityMappingListener
+
+	// barrier orders upserts after the events of the identities they
+	// refer to, see UpsertOrdered
+	barrier *identityBarrier
 }
 
 // Implementation represents a concrete datapath implementation of the IPCache
@@ -104,6 +108,7 @@ func NewIPCache() *IPCache {
 		ipToHostIPCache:   map[string]IPKeyPair{},
 		v4PrefixLengths:   map[int]int{},
 		v6PrefixLengths:   map[int]int{},
+		barrier:           newIdentityBarrier(),
 	}
 }
 
@@ -248,6 +253,55 @@ func (ipc *IPCache) getHostIPCache(ip string) (net.IP, uint8) {
 // hostIP is the location of the given IP. It is optional (may be nil) and is
 // propagated to the listeners.
 func (ipc *IPCache) Upsert(ip string, hostIP net.IP, hostKey uint8, newIdentity Identity) bool {
+	ipc.mutex.Lock()
+	defer ipc.mutex.Unlock()
+	return ipc.upsertLocked(ip, hostIP, hostKey, newIdentity)
+}
+
+// UpsertEntry is an entry of a batch of upserts, see UpsertBatch
+type UpsertEntry struct {
+	IP       string
+	HostIP   net.IP
+	HostKey  uint8
+	Identity Identity
+}
+
+// UpsertBatch adds or updates all entries in the ipcache while acquiring the
+// ipcache lock only once. Entries for the same IP are deduplicated, the last
+// entry for an IP wins. Returns the number of IPs for which the upsert
+// succeeded, see Upsert.
+func (ipc *IPCache) UpsertBatch(entries []UpsertEntry) int {
+	ipc.mutex.Lock()
+	defer ipc.mutex.Unlock()
+	upserted, _ := ipc.upsertBatchLocked(entries)
+	return upserted
+}
+
+// upsertBatchLocked adds or updates all entries in the ipcache, see
+// UpsertBatch. Returns the number of IPs for which the upsert succeeded and
+// failed. Must be called with ipc.mutex held.
+func (ipc *IPCache) upsertBatchLocked(entries []UpsertEntry) (upserted, failed int) {
+	last := make(map[string]int, len(entries))
+	for i := range entries {
+		last[entries[i].IP] = i
+	}
+
+	for i, e := range entries {
+		if last[e.IP] != i {
+			continue
+		}
+		if ipc.upsertLocked(e.IP, e.HostIP, e.HostKey, e.Identity) {
+			upserted++
+		} else {
+			failed++
+		}
+	}
+	return upserted, failed
+}
+
+// upsertLocked adds or updates an IP in the ipcache, see Upsert. Must be
+// called with ipc.mutex held.
+func (ipc *IPCache) upsertLocked(ip string, hostIP net.IP, hostKey uint8, newIdentity Identity) bool {
 	scopedLog := log
 	if option.Config.Debug {
 		scopedLog = log.WithFields(logrus.Fields{
@@ -257,9 +311,6 @@ func (ipc *IPCache) Upsert(ip string, hostIP net.IP, hostKey uint8, newIdentity
 		})
 	}
 
-	ipc.mutex.Lock()
-	defer ipc.mutex.Unlock()
-
 	var cidr *net.IPNet
 	var oldIdentity *identity.NumericIdentity
 	callbackListeners := true
@@ -20,9 +20,11 @@ import (
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/cilium/cilium/pkg/checker"
 	identityPkg "github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/testutils"
 
 	. "gopkg.in/check.v1"
 )
@@ -262,3 +264,133 @@ func (s *IPCacheTestSuite) TestAllowOverwrite(c *C) {
 	c.Assert(allowOverwrite(FromCIDR, FromAgentLocal), Equals, true)
 	c.Assert(allowOverwrite(FromCIDR, FromCIDR), Equals, true)
 }
+
+func (s *IPCacheTestSuite) TestUpsertBatch(c *C) {
+	ipc := NewIPCache()
+
+	hostIP := net.ParseIP("192.168.0.1")
+	upserted := ipc.UpsertBatch([]UpsertEntry{
+		{IP: "10.0.0.1", HostIP: hostIP, Identity: Identity{ID: 1, Source: FromKVStore}},
+		{IP: "10.0.0.2", HostIP: hostIP, Identity: Identity{ID: 2, Source: FromKVStore}},
+		// The last entry for an IP wins
+		{IP: "10.0.0.1", HostIP: hostIP, Identity: Identity{ID: 3, Source: FromKVStore}},
+		{IP: "invalid", Identity: Identity{ID: 4, Source: FromKVStore}},
+	})
+	c.Assert(upserted, Equals, 2)
+
+	id, exists := ipc.LookupByIP("10.0.0.1")
+	c.Assert(exists, Equals, true)
+	c.Assert(id.ID, Equals, identityPkg.NumericIdentity(3))
+	_, exists = ipc.LookupByIdentity(1)
+	c.Assert(exists, Equals, false)
+
+	id, exists = ipc.LookupByIP("10.0.0.2")
+	c.Assert(exists, Equals, true)
+	c.Assert(id.ID, Equals, identityPkg.NumericIdentity(2))
+
+	// Entries owned by another source are not overwritten
+	upserted = ipc.UpsertBatch([]UpsertEntry{
+		{IP: "10.0.0.1", Identity: Identity{ID: 5, Source: FromKubernetes}},
+	})
+	c.Assert(upserted, Equals, 0)
+	id, _ = ipc.LookupByIP("10.0.0.1")
+	c.Assert(id.ID, Equals, identityPkg.NumericIdentity(3))
+}
+
+func (s *IPCacheTestSuite) TestIdentityBarrier(c *C) {
+	ipc := NewIPCache()
+	global := identityPkg.NumericIdentity(1000)
+
+	// Without the barrier, upserts are applied immediately
+	ipc.UpsertOrdered([]UpsertEntry{{IP: "10.0.0.1", Identity: Identity{ID: global, Source: FromKVStore}}})
+	_, exists := ipc.LookupByIP("10.0.0.1")
+	c.Assert(exists, Equals, true)
+
+	ipc.EnableIdentityBarrier()
+
+	// Reserved identities are always known, unknown global identities
+	// are deferred
+	ipc.UpsertOrdered([]UpsertEntry{
+		{IP: "10.0.0.2", Identity: Identity{ID: identityPkg.ReservedIdentityHost, Source: FromKVStore}},
+		{IP: "10.0.0.3", Identity: Identity{ID: global + 1, Source: FromKVStore}},
+		{IP: "10.0.0.4", Identity: Identity{ID: global + 1, Source: FromKVStore}},
+	})
+	_, exists = ipc.LookupByIP("10.0.0.2")
+	c.Assert(exists, Equals, true)
+	_, exists = ipc.LookupByIP("10.0.0.3")
+	c.Assert(exists, Equals, false)
+
+	// A later upsert of the same IP replaces the deferred upsert
+	ipc.UpsertOrdered([]UpsertEntry{{IP: "10.0.0.3", Identity: Identity{ID: identityPkg.ReservedIdentityHost, Source: FromKVStore}}})
+
+	// A deletion drops the deferred upsert
+	ipc.DeleteOrdered("10.0.0.4", FromKVStore)
+
+	ipc.UpsertOrdered([]UpsertEntry{{IP: "10.0.0.5", Identity: Identity{ID: global + 1, Source: FromKVStore}}})
+	ipc.UpdateKnownIdentities([]identityPkg.NumericIdentity{global + 1}, nil)
+
+	id, exists := ipc.LookupByIP("10.0.0.3")
+	c.Assert(exists, Equals, true)
+	c.Assert(id.ID, Equals, identityPkg.ReservedIdentityHost)
+	_, exists = ipc.LookupByIP("10.0.0.4")
+	c.Assert(exists, Equals, false)
+	id, exists = ipc.LookupByIP("10.0.0.5")
+	c.Assert(exists, Equals, true)
+	c.Assert(id.ID, Equals, global+1)
+
+	// Upserts of known identities are applied immediately
+	ipc.UpsertOrdered([]UpsertEntry{{IP: "10.0.0.6", Identity: Identity{ID: global + 1, Source: FromKVStore}}})
+	_, exists = ipc.LookupByIP("10.0.0.6")
+	c.Assert(exists, Equals, true)
+
+	// Upserts of identities never announced are applied after the timeout
+	oldTimeout := identityBarrierTimeout
+	identityBarrierTimeout = 10 * time.Millisecond
+	defer func() { identityBarrierTimeout = oldTimeout }()
+
+	ipc.UpdateKnownIdentities(nil, []identityPkg.NumericIdentity{global + 1})
+	ipc.UpsertOrdered([]UpsertEntry{{IP: "10.0.0.7", Identity: Identity{ID: global + 1, Source: FromKVStore}}})
+	c.Assert(testutils.WaitUntil(func() bool {
+		_, exists := ipc.LookupByIP("10.0.0.7")
+		return exists
+	}, 5*time.Second), IsNil)
+}
+
+func (s *IPCacheTestSuite) TestReplaceOrdered(c *C) {
+	ipc := NewIPCache()
+	health := identityPkg.ReservedIdentityHealth
+
+	ipc.UpsertOrdered([]UpsertEntry{
+		{IP: "10.0.0.1", Identity: Identity{ID: health, Source: FromKVStore}},
+		{IP: "10.0.0.2", Identity: Identity{ID: health, Source: FromAgentLocal}},
+	})
+
+	ipc.ReplaceOrdered([]string{"10.0.0.1", "10.0.0.2"}, FromKVStore, []UpsertEntry{
+		{IP: "10.0.0.3", Identity: Identity{ID: health, Source: FromKVStore}},
+	})
+	_, exists := ipc.LookupByIP("10.0.0.1")
+	c.Assert(exists, Equals, false)
+	id, exists := ipc.LookupByIP("10.0.0.3")
+	c.Assert(exists, Equals, true)
+	c.Assert(id.ID, Equals, health)
+
+	// Entries owned by another source are not removed
+	_, exists = ipc.LookupByIP("10.0.0.2")
+	c.Assert(exists, Equals, true)
+
+	// Failed upserts are reported
+	failed := ipc.UpsertOrdered([]UpsertEntry{
+		{IP: "10.0.0.2", Identity: Identity{ID: health, Source: FromKVStore}},
+		{IP: "10.0.0.3", Identity: Identity{ID: health, Source: FromKVStore}},
+	})
+	c.Assert(failed, Equals, 1)
+
+	// A replacement drops upserts deferred for the removed IPs
+	global := identityPkg.NumericIdentity(1000)
+	ipc.EnableIdentityBarrier()
+	ipc.UpsertOrdered([]UpsertEntry{{IP: "10.0.0.4", Identity: Identity{ID: global, Source: FromKVStore}}})
+	ipc.ReplaceOrdered([]string{"10.0.0.4"}, FromKVStore, nil)
+	ipc.UpdateKnownIdentities([]identityPkg.NumericIdentity{global}, nil)
+	_, exists = ipc.LookupByIP("10.0.0.4")
+	c.Assert(exists, Equals, false)
+}
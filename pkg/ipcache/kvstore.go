@@ -104,7 +104,7 @@ func newKVReferenceCounter(s store) *kvReferenceCounter {
 // UpsertIPToKVStore updates / inserts the provided IP->Identity mapping into the
 // kvstore, which will subsequently trigger an event in NewIPIdentityWatcher().
 func UpsertIPToKVStore(ctx context.Context, IP, hostIP net.IP, ID identity.NumericIdentity, key uint8, metadata string) error {
-	ipKey := path.Join(IPIdentitiesPath, AddressSpace, IP.String())
+	ipKey := path.Join(kvstore.TenantPath(IPIdentitiesPath), AddressSpace, IP.String())
 	ipIDPair := identity.IPIdentityPair{
 		IP:       IP,
 		ID:       ID,
@@ -137,7 +137,7 @@ func UpsertIPToKVStore(ctx context.Context, IP, hostIP net.IP, ID identity.Numer
 // keyToIPNet returns the IPNet describing the key, whether it is a host, and
 // an error (if one occurs)
 func keyToIPNet(key string) (parsedPrefix *net.IPNet, host bool, err error) {
-	requiredPrefix := fmt.Sprintf("%s/", path.Join(IPIdentitiesPath, AddressSpace))
+	requiredPrefix := fmt.Sprintf("%s/", path.Join(kvstore.TenantPath(IPIdentitiesPath), AddressSpace))
 	if !strings.HasPrefix(key, requiredPrefix) {
 		err = fmt.Errorf("found invalid key %s outside of prefix %s", key, IPIdentitiesPath)
 		return
@@ -172,7 +172,7 @@ func keyToIPNet(key string) (parsedPrefix *net.IPNet, host bool, err error) {
 // from the kvstore, which will subsequently trigger an event in
 // NewIPIdentityWatcher().
 func DeleteIPFromKVStore(ctx context.Context, ip string) error {
-	ipKey := path.Join(IPIdentitiesPath, AddressSpace, ip)
+	ipKey := path.Join(kvstore.TenantPath(IPIdentitiesPath), AddressSpace, ip)
 	globalMap.Lock()
 	delete(globalMap.marshaledIPIDPairs, ipKey)
 	globalMap.Unlock()
@@ -208,7 +208,7 @@ func (iw *IPIdentityWatcher) Watch() {
 
 	var scopedLog *logrus.Entry
 restart:
-	watcher := iw.backend.ListAndWatch("endpointIPWatcher", IPIdentitiesPath, 512)
+	watcher := iw.backend.ListAndWatch("endpointIPWatcher", kvstore.TenantPath(IPIdentitiesPath), 512)
 
 	for {
 		select {
@@ -266,10 +266,15 @@ restart:
 					continue
 				}
 
-				IPIdentityCache.Upsert(ip, ipIDPair.HostIP, ipIDPair.Key, Identity{
-					ID:     ipIDPair.ID,
-					Source: FromKVStore,
-				})
+				IPIdentityCache.UpsertOrdered([]UpsertEntry{{
+					IP:      ip,
+					HostIP:  ipIDPair.HostIP,
+					HostKey: ipIDPair.Key,
+					Identity: Identity{
+						ID:     ipIDPair.ID,
+						Source: FromKVStore,
+					},
+				}})
 
 			case kvstore.EventTypeDelete:
 				// Value is not present in deletion event;
@@ -301,7 +306,7 @@ restart:
 					// The key no longer exists in the
 					// local cache, it is safe to remove
 					// from the datapath ipcache.
-					IPIdentityCache.Delete(ip, FromKVStore)
+					IPIdentityCache.DeleteOrdered(ip, FromKVStore)
 				}
 			}
 
@@ -100,13 +100,13 @@ func (k8sCli K8sClient) AnnotateNode(nodeName string, v4CIDR, v6CIDR *cidr.CIDR,
 
 	controller.NewManager().UpdateController("update-k8s-node-annotations",
 		controller.ControllerParams{
-			DoFunc: func(_ context.Context) error {
+			DoFunc: func(ctx context.Context) error {
 				err := updateNodeAnnotation(k8sCli, nodeName, v4CIDR, v6CIDR, v4HealthIP, v6HealthIP, v4CiliumHostIP, v6CiliumHostIP)
 				if err != nil {
 					scopedLog.WithFields(logrus.Fields{}).WithError(err).Warn("Unable to patch node resource with annotation")
 					return err
 				}
-				return SetNodeNetworkUnavailableFalse(k8sCli, nodeName)
+				return SetNodeNetworkUnavailableFalse(ctx, k8sCli, nodeName)
 			},
 		})
 
@@ -27,6 +27,7 @@ import (
 	"github.com/cilium/cilium/pkg/labels"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node/addressing"
 	"github.com/cilium/cilium/pkg/policy/api"
 
 	"github.com/go-openapi/swag"
@@ -582,6 +583,86 @@ type NodeSpec struct {
 	//
 	// +optional
 	IPAM IPAMSpec `json:"ipam,omitempty"`
+
+	// Addresses is the list of all addresses of the node. Set when the
+	// node is registered through the CiliumNode custom resource.
+	//
+	// +optional
+	Addresses []NodeAddress `json:"addresses,omitempty"`
+
+	// HealthAddressing is the addressing information used for health
+	// connectivity checking of the node
+	//
+	// +optional
+	HealthAddressing HealthAddressingSpec `json:"health,omitempty"`
+
+	// Encryption is the encryption configuration of the node
+	//
+	// +optional
+	Encryption EncryptionSpec `json:"encryption,omitempty"`
+
+	// Datapath is the datapath configuration of the node required by
+	// other nodes to reach it
+	//
+	// +optional
+	Datapath DatapathSpec `json:"datapath,omitempty"`
+}
+
+// NodeAddress is a node address
+type NodeAddress struct {
+	// Type is the type of the node address
+	Type addressing.AddressType `json:"type,omitempty"`
+
+	// IP is an IP of a node
+	IP string `json:"ip,omitempty"`
+}
+
+// HealthAddressingSpec is the addressing information required to do
+// connectivity health checking
+type HealthAddressingSpec struct {
+	// IPv4 is the IPv4 address of the IPv4 health endpoint
+	//
+	// +optional
+	IPv4 string `json:"ipv4,omitempty"`
+
+	// IPv6 is the IPv6 address of the IPv4 health endpoint
+	//
+	// +optional
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// EncryptionSpec defines the encryption relevant configuration of a node
+type EncryptionSpec struct {
+	// Key is the index to the key to use for encryption or 0 if
+	// encryption is disabled
+	//
+	// +optional
+	Key int `json:"key,omitempty"`
+
+	// PublicKey is the encryption public key of the node
+	//
+	// +optional
+	PublicKey string `json:"public-key,omitempty"`
+}
+
+// DatapathSpec is the datapath configuration of a node
+type DatapathSpec struct {
+	// MTU is the MTU of the network device used by the node to reach its
+	// peers
+	//
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+
+	// TunnelProtocol is the encapsulation protocol used by the node
+	//
+	// +optional
+	TunnelProtocol string `json:"tunnel-protocol,omitempty"`
+
+	// TunnelPort is the UDP destination port of encapsulated traffic sent
+	// to the node
+	//
+	// +optional
+	TunnelPort int `json:"tunnel-port,omitempty"`
 }
 
 // ENISpec is the ENI specification of a node. This specification is considered
@@ -669,6 +750,13 @@ type IPAMSpec struct {
 	//
 	// +optional
 	Pool map[string]AllocationIP `json:"pool,omitempty"`
+
+	// PodCIDRs is the list of CIDRs available to the node for allocation.
+	// The first CIDR of each address family is the primary allocation
+	// CIDR of the node.
+	//
+	// +optional
+	PodCIDRs []string `json:"pod-cidrs,omitempty"`
 }
 
 // NodeStatus is the status of a node
@@ -327,6 +327,22 @@ func (in *CiliumNodeList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatapathSpec) DeepCopyInto(out *DatapathSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatapathSpec.
+func (in *DatapathSpec) DeepCopy() *DatapathSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DatapathSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ENI) DeepCopyInto(out *ENI) {
 	*out = *in
@@ -406,6 +422,38 @@ func (in *ENIStatus) DeepCopy() *ENIStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EncryptionSpec) DeepCopyInto(out *EncryptionSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EncryptionSpec.
+func (in *EncryptionSpec) DeepCopy() *EncryptionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(EncryptionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthAddressingSpec) DeepCopyInto(out *HealthAddressingSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthAddressingSpec.
+func (in *HealthAddressingSpec) DeepCopy() *HealthAddressingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthAddressingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IPAMSpec) DeepCopyInto(out *IPAMSpec) {
 	*out = *in
@@ -416,6 +464,11 @@ func (in *IPAMSpec) DeepCopyInto(out *IPAMSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.PodCIDRs != nil {
+		in, out := &in.PodCIDRs, &out.PodCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -452,11 +505,35 @@ func (in *IPAMStatus) DeepCopy() *IPAMStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeAddress) DeepCopyInto(out *NodeAddress) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeAddress.
+func (in *NodeAddress) DeepCopy() *NodeAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeSpec) DeepCopyInto(out *NodeSpec) {
 	*out = *in
 	in.ENI.DeepCopyInto(&out.ENI)
 	in.IPAM.DeepCopyInto(&out.IPAM)
+	if in.Addresses != nil {
+		in, out := &in.Addresses, &out.Addresses
+		*out = make([]NodeAddress, len(*in))
+		copy(*out, *in)
+	}
+	out.HealthAddressing = in.HealthAddressing
+	out.Encryption = in.Encryption
+	out.Datapath = in.Datapath
 	return
 }
 
@@ -57,6 +57,31 @@ func (e *Endpoints) String() string {
 	return strings.Join(backends, ",")
 }
 
+// BackendAddrs returns the addresses, in the form "<ip>:<port>", of all
+// backends, sorted. The port named portName is used if the backend exposes
+// it, otherwise the backend must expose a single port.
+func (e *Endpoints) BackendAddrs(portName string) []string {
+	if e == nil {
+		return nil
+	}
+
+	addrs := make([]string, 0, len(e.Backends))
+	for ip, ports := range e.Backends {
+		port, ok := ports[portName]
+		if !ok && len(ports) == 1 {
+			for _, p := range ports {
+				port = p
+			}
+		}
+		if port == nil {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(int(port.Port))))
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
 // newEndpoints returns a new Endpoints
 func newEndpoints() *Endpoints {
 	return &Endpoints{
@@ -223,7 +223,14 @@ func EqualV1Node(node1, node2 *types.Node) bool {
 			return false
 		}
 	}
-	return true
+
+	// A node starting to terminate must be announced to the node manager
+	if IsNodeTerminating(node1) != IsNodeTerminating(node2) {
+		return false
+	}
+
+	// Node labels can be selected by policies and must thus be propagated
+	return comparator.MapStringEquals(node1.GetLabels(), node2.GetLabels())
 }
 
 func EqualV1Namespace(ns1, ns2 *types.Namespace) bool {
@@ -471,6 +478,7 @@ func ConvertToNode(obj interface{}) interface{} {
 			ObjectMeta:      concreteObj.ObjectMeta,
 			StatusAddresses: concreteObj.Status.Addresses,
 			SpecPodCIDR:     concreteObj.Spec.PodCIDR,
+			SpecTaints:      concreteObj.Spec.Taints,
 		}
 		*concreteObj = v1.Node{}
 		return p
@@ -486,6 +494,7 @@ func ConvertToNode(obj interface{}) interface{} {
 				ObjectMeta:      node.ObjectMeta,
 				StatusAddresses: node.Status.Addresses,
 				SpecPodCIDR:     node.Spec.PodCIDR,
+				SpecTaints:      node.Spec.Taints,
 			},
 		}
 		*node = v1.Node{}
@@ -544,6 +544,69 @@ func (s *K8sSuite) Test_EqualV1Node(c *C) {
 			},
 			want: true,
 		},
+		{
+			name: "Nodes with different labels",
+			args: args{
+				o1: &types.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "Node1",
+						Labels: map[string]string{
+							"role": "ingress",
+						},
+					},
+				},
+				o2: &types.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "Node1",
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "Nodes with a termination taint added",
+			args: args{
+				o1: &types.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "Node1",
+					},
+				},
+				o2: &types.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "Node1",
+					},
+					SpecTaints: []v1.Taint{
+						{
+							Key:    "ToBeDeletedByClusterAutoscaler",
+							Effect: v1.TaintEffectNoSchedule,
+						},
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "Nodes with different taints should return true as we only care about termination taints",
+			args: args{
+				o1: &types.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "Node1",
+					},
+				},
+				o2: &types.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "Node1",
+					},
+					SpecTaints: []v1.Taint{
+						{
+							Key:    "dedicated",
+							Effect: v1.TaintEffectNoSchedule,
+						},
+					},
+				},
+			},
+			want: true,
+		},
 	}
 	for _, tt := range tests {
 		got := EqualV1Node(tt.args.o1, tt.args.o2)
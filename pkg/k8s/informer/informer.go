@@ -93,3 +93,44 @@ func NewInformerWithStore(
 	}
 	return cache.New(cfg)
 }
+
+// ResyncObject feeds the latest state of a single object retrieved from the
+// kube-apiserver into clientState and h the same way the informer processes
+// received objects. If obj is nil, the object no longer exists and the
+// object stored under key is deleted. This allows to repair a single object
+// without re-listing all objects watched by the informer. As the informer
+// may process events of the same object concurrently, h must tolerate
+// repeated events.
+func ResyncObject(
+	clientState cache.Store,
+	h cache.ResourceEventHandler,
+	convertFunc ConvertFunc,
+	key string,
+	obj interface{},
+) error {
+	if obj == nil {
+		old, exists, err := clientState.GetByKey(key)
+		if err != nil || !exists {
+			return err
+		}
+		if err := clientState.Delete(old); err != nil {
+			return err
+		}
+		h.OnDelete(old)
+		return nil
+	}
+
+	obj = convertFunc(obj)
+	if old, exists, err := clientState.Get(obj); err == nil && exists {
+		if err := clientState.Update(obj); err != nil {
+			return err
+		}
+		h.OnUpdate(old, obj)
+	} else {
+		if err := clientState.Add(obj); err != nil {
+			return err
+		}
+		h.OnAdd(obj)
+	}
+	return nil
+}
@@ -14,7 +14,7 @@
 
 // +build !privileged_tests
 
-package informer
+package informer_test
 
 import (
 	"encoding/json"
@@ -25,6 +25,7 @@ import (
 
 	"github.com/cilium/cilium/pkg/annotation"
 	"github.com/cilium/cilium/pkg/k8s"
+	"github.com/cilium/cilium/pkg/k8s/informer"
 
 	. "gopkg.in/check.v1"
 	"k8s.io/api/core/v1"
@@ -449,7 +450,7 @@ func (k *K8sIntegrationSuite) benchmarkInformer(nCycles int, newInformer bool, c
 	}
 
 	if newInformer {
-		_, controller := NewInformer(
+		_, controller := informer.NewInformer(
 			lw,
 			&v1.Node{},
 			0,
@@ -566,3 +567,32 @@ func (k *K8sIntegrationSuite) Benchmark_K8sInformer(c *C) {
 
 	k.benchmarkInformer(nCycles, false, c)
 }
+
+func (k *K8sIntegrationSuite) TestResyncObject(c *C) {
+	store := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+	events := []string{}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			events = append(events, "add "+obj.(*v1.Node).ResourceVersion)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			events = append(events, "update "+oldObj.(*v1.Node).ResourceVersion+"->"+newObj.(*v1.Node).ResourceVersion)
+		},
+		DeleteFunc: func(obj interface{}) {
+			events = append(events, "delete "+obj.(*v1.Node).ResourceVersion)
+		},
+	}
+	identity := func(obj interface{}) interface{} { return obj }
+	node := func(resourceVersion string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", ResourceVersion: resourceVersion}}
+	}
+
+	c.Assert(informer.ResyncObject(store, handler, identity, "node1", node("1")), IsNil)
+	c.Assert(informer.ResyncObject(store, handler, identity, "node1", node("2")), IsNil)
+	c.Assert(informer.ResyncObject(store, handler, identity, "node1", nil), IsNil)
+	// Deleting an unknown object is a no-op
+	c.Assert(informer.ResyncObject(store, handler, identity, "node1", nil), IsNil)
+
+	c.Assert(events, DeepEquals, []string{"add 1", "update 1->2", "delete 2"})
+	c.Assert(store.ListKeys(), HasLen, 0)
+}
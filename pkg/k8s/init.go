@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/cilium/cilium/pkg/backoff"
+	"github.com/cilium/cilium/pkg/cidr"
 	cilium_v2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
 	"github.com/cilium/cilium/pkg/k8s/types"
 	k8sversion "github.com/cilium/cilium/pkg/k8s/version"
@@ -62,7 +63,7 @@ func retrieveNodeInformation(nodeName string) (*node.Node, error) {
 	requireIPv4CIDR := option.Config.K8sRequireIPv4PodCIDR
 	requireIPv6CIDR := option.Config.K8sRequireIPv6PodCIDR
 
-	k8sNode, err := GetNode(Client(), nodeName)
+	k8sNode, err := GetNode(context.TODO(), Client(), nodeName, GetNodeOptions{Timeout: DefaultGetNodeTimeout})
 	if err != nil {
 		// If no CIDR is required, retrieving the node information is
 		// optional
@@ -74,6 +75,12 @@ func retrieveNodeInformation(nodeName string) (*node.Node, error) {
 
 	}
 
+	if option.Config.K8sRewriteLegacyNodeAnnotations {
+		if err := rewriteLegacyNodeAnnotations(Client(), k8sNode); err != nil {
+			log.WithError(err).Warning("Unable to rewrite legacy node annotations")
+		}
+	}
+
 	nodeInterface := ConvertToNode(k8sNode)
 	if nodeInterface == nil {
 		// This will never happen and the GetNode on line 63 will be soon
@@ -110,6 +117,15 @@ func useNodeCIDR(n *node.Node) {
 			}).Warn("k8s: Can't use IPv6 CIDR range from k8s")
 		}
 	}
+
+	var v4Secondary, v6Secondary []*cidr.CIDR
+	if option.Config.EnableIPv4 {
+		v4Secondary = n.IPv4SecondaryAllocCIDRs
+	}
+	if option.Config.EnableIPv6 {
+		v6Secondary = n.IPv6SecondaryAllocCIDRs
+	}
+	node.SetSecondaryAllocRanges(v4Secondary, v6Secondary)
 }
 
 // Init initializes the Kubernetes package. It is required to call Configure()
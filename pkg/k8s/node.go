@@ -15,14 +15,16 @@
 package k8s
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"time"
 
-	"github.com/cilium/cilium/pkg/annotation"
 	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/k8s/types"
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/node"
 	"github.com/cilium/cilium/pkg/node/addressing"
 	"github.com/cilium/cilium/pkg/option"
@@ -31,6 +33,7 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 // ParseNodeAddressType converts a Kubernetes NodeAddressType to a Cilium
@@ -49,6 +52,90 @@ func ParseNodeAddressType(k8sAddress v1.NodeAddressType) (addressing.AddressType
 	return convertedAddr, err
 }
 
+// nodeTerminationTaints is the list of taints set by cloud providers and
+// cluster autoscalers on nodes which are about to be terminated.
+var nodeTerminationTaints = []string{
+	// Set by the cluster-autoscaler before scaling down a node
+	"ToBeDeletedByClusterAutoscaler",
+	// Set by the cloud node lifecycle controller when the instance of
+	// the node has been shut down
+	"node.cloudprovider.kubernetes.io/shutdown",
+}
+
+// IsNodeTerminating returns true if the kubernetes node carries a taint
+// marking it as about to be terminated.
+func IsNodeTerminating(k8sNode *types.Node) bool {
+	for _, taint := range k8sNode.SpecTaints {
+		for _, key := range nodeTerminationTaints {
+			if taint.Key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isNodeAddressTypeEnabled returns true if addresses of type t are
+// considered when parsing nodes
+func isNodeAddressTypeEnabled(t v1.NodeAddressType) bool {
+	for _, enabled := range option.Config.NodeAddressTypes {
+		if string(t) == enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// isNodeAddressExcluded returns true if ip is within one of the excluded
+// node address CIDRs
+func isNodeAddressExcluded(ip net.IP) bool {
+	for _, ipnet := range option.Config.NodeAddressExcludedCIDRs {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendResolvedAddresses resolves the DNS address addr and appends the
+// resolved IPs not already known to addrs
+func appendResolvedAddresses(addrs []node.Address, addr v1.NodeAddress, scopedLog *logrus.Entry) []node.Address {
+	ipType := resolvedAddressType(addr.Type)
+	if !isNodeAddressTypeEnabled(ipType) {
+		return addrs
+	}
+	addressType, err := ParseNodeAddressType(ipType)
+	if err != nil {
+		scopedLog.WithError(err).Warn("invalid address type for node")
+	}
+
+	ips, err := nodeDNSCache.resolve(addr.Address)
+	if err != nil {
+		scopedLog.WithError(err).WithFields(logrus.Fields{
+			"name": addr.Address,
+			"type": addr.Type,
+		}).Warn("Unable to resolve node DNS address")
+	}
+
+	for _, ip := range ips {
+		if isNodeAddressExcluded(ip) || hasNodeAddress(addrs, ip) {
+			continue
+		}
+		addrs = append(addrs, node.Address{Type: addressType, IP: ip})
+	}
+	return addrs
+}
+
+// hasNodeAddress returns true if addrs contains ip
+func hasNodeAddress(addrs []node.Address, ip net.IP) bool {
+	for _, addr := range addrs {
+		if addr.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // ParseNode parses a kubernetes node to a cilium node
 func ParseNode(k8sNode *types.Node, source node.Source) *node.Node {
 	scopedLog := log.WithFields(logrus.Fields{
@@ -56,12 +143,15 @@ func ParseNode(k8sNode *types.Node, source node.Source) *node.Node {
 		logfields.K8sNodeID: k8sNode.UID,
 	})
 	addrs := []node.Address{}
+	var dnsAddrs []v1.NodeAddress
 	for _, addr := range k8sNode.StatusAddresses {
-		// We only care about this address types,
+		if option.Config.ResolveNodeAddressDNS && isNodeAddressDNS(addr.Type) && addr.Address != "" {
+			dnsAddrs = append(dnsAddrs, addr)
+			continue
+		}
+		// We only care about the configured address types,
 		// we ignore all other types.
-		switch addr.Type {
-		case v1.NodeInternalIP, v1.NodeExternalIP:
-		default:
+		if !isNodeAddressTypeEnabled(addr.Type) {
 			continue
 		}
 		// If the address is not set let's not parse it at all.
@@ -78,6 +168,14 @@ func ParseNode(k8sNode *types.Node, source node.Source) *node.Node {
 			continue
 		}
 
+		if isNodeAddressExcluded(ip) {
+			scopedLog.WithFields(logrus.Fields{
+				logfields.IPAddr: addr.Address,
+				"type":           addr.Type,
+			}).Debug("Ignoring excluded node IP")
+			continue
+		}
+
 		addressType, err := ParseNodeAddressType(addr.Type)
 
 		if err != nil {
@@ -90,24 +188,12 @@ func ParseNode(k8sNode *types.Node, source node.Source) *node.Node {
 		}
 		addrs = append(addrs, na)
 	}
-
-	k8sNodeAddHostIP := func(annotation string) {
-		if ciliumInternalIP, ok := k8sNode.Annotations[annotation]; !ok || ciliumInternalIP == "" {
-			scopedLog.Debugf("Missing %s. Annotation required when IPSec Enabled", annotation)
-		} else if ip := net.ParseIP(ciliumInternalIP); ip == nil {
-			scopedLog.Debugf("ParseIP %s error", ciliumInternalIP)
-		} else {
-			na := node.Address{
-				Type: addressing.NodeCiliumInternalIP,
-				IP:   ip,
-			}
-			addrs = append(addrs, na)
-			scopedLog.Debugf("Add NodeCiliumInternalIP: %s", ip)
-		}
+	// DNS names are resolved after all IPs have been parsed so that the
+	// published IPs take precedence over resolved IPs of the same type
+	for _, addr := range dnsAddrs {
+		addrs = appendResolvedAddresses(addrs, addr, scopedLog)
 	}
-
-	k8sNodeAddHostIP(annotation.CiliumHostIP)
-	k8sNodeAddHostIP(annotation.CiliumHostIPv6)
+	node.SortAddresses(addrs)
 
 	newNode := &node.Node{
 		Name:        k8sNode.Name,
@@ -116,84 +202,151 @@ func ParseNode(k8sNode *types.Node, source node.Source) *node.Node {
 		Source:      source,
 	}
 
-	if len(k8sNode.SpecPodCIDR) != 0 {
-		if allocCIDR, err := cidr.ParseCIDR(k8sNode.SpecPodCIDR); err != nil {
-			scopedLog.WithError(err).WithField(logfields.V4Prefix, k8sNode.SpecPodCIDR).Warn("Invalid PodCIDR value for node")
-		} else {
-			if allocCIDR.IP.To4() != nil {
-				newNode.IPv4AllocCIDR = allocCIDR
-			} else {
-				newNode.IPv6AllocCIDR = allocCIDR
-			}
+	if len(k8sNode.Labels) != 0 {
+		newNode.Labels = make(map[string]string, len(k8sNode.Labels))
+		for k, v := range k8sNode.Labels {
+			newNode.Labels[k] = v
 		}
+		node.NormalizeTopologyLabels(newNode.Labels)
 	}
-	// Spec.PodCIDR takes precedence since it's
-	// the CIDR assigned by k8s controller manager
-	// In case it's invalid or empty then we fall back to our annotations.
-	if newNode.IPv4AllocCIDR == nil {
-		if ipv4CIDR, ok := k8sNode.Annotations[annotation.V4CIDRName]; !ok || ipv4CIDR == "" {
-			scopedLog.Debug("Empty IPv4 CIDR annotation in node")
-		} else {
-			allocCIDR, err := cidr.ParseCIDR(ipv4CIDR)
-			if err != nil {
-				scopedLog.WithError(err).WithField(logfields.V4Prefix, ipv4CIDR).Error("BUG, invalid IPv4 annotation CIDR in node")
-			} else {
-				newNode.IPv4AllocCIDR = allocCIDR
-			}
-		}
+
+	podCIDRs := k8sNode.SpecPodCIDRs
+	if len(podCIDRs) == 0 && len(k8sNode.SpecPodCIDR) != 0 {
+		podCIDRs = []string{k8sNode.SpecPodCIDR}
 	}
+	parsePodCIDRs(podCIDRs, newNode, scopedLog)
 
-	if newNode.IPv6AllocCIDR == nil {
-		if ipv6CIDR, ok := k8sNode.Annotations[annotation.V6CIDRName]; !ok || ipv6CIDR == "" {
-			scopedLog.Debug("Empty IPv6 CIDR annotation in node")
-		} else {
-			allocCIDR, err := cidr.ParseCIDR(ipv6CIDR)
-			if err != nil {
-				scopedLog.WithError(err).WithField(logfields.V6Prefix, ipv6CIDR).Error("BUG, invalid IPv6 annotation CIDR in node")
-			} else {
-				newNode.IPv6AllocCIDR = allocCIDR
-			}
-		}
+	// Annotations are parsed after the spec so that the spec takes
+	// precedence, e.g. Spec.PodCIDR over the CIDR annotations. Annotations
+	// written by older versions of Cilium are converted beforehand.
+	annotations, legacy := NormalizeNodeAnnotations(k8sNode.Annotations)
+	if len(legacy) != 0 {
+		scopedLog.Debug("Converted node annotations written by an older version of Cilium")
 	}
+	parseNodeAnnotations(annotations, newNode, scopedLog)
 
-	if newNode.IPv4HealthIP == nil {
-		if healthIP, ok := k8sNode.Annotations[annotation.V4HealthName]; !ok || healthIP == "" {
-			scopedLog.Debug("Empty IPv4 health endpoint annotation in node")
-		} else if ip := net.ParseIP(healthIP); ip == nil {
-			scopedLog.WithField(logfields.V4HealthIP, healthIP).Error("BUG, invalid IPv4 health endpoint annotation in node")
-		} else {
-			newNode.IPv4HealthIP = ip
-		}
+	// Drop malformed addresses and CIDRs, e.g. from hand-edited
+	// annotations, before they reach the ipcache and the datapath
+	for _, err := range newNode.Sanitize() {
+		metrics.NodeValidationFailures.WithLabelValues(err.Reason, "sanitized").Inc()
+		scopedLog.WithError(err).Warning("Ignoring invalid field of node")
 	}
 
-	if newNode.IPv6HealthIP == nil {
-		if healthIP, ok := k8sNode.Annotations[annotation.V6HealthName]; !ok || healthIP == "" {
-			scopedLog.Debug("Empty IPv6 health endpoint annotation in node")
-		} else if ip := net.ParseIP(healthIP); ip == nil {
-			scopedLog.WithField(logfields.V6HealthIP, healthIP).Error("BUG, invalid IPv6 health endpoint annotation in node")
-		} else {
-			newNode.IPv6HealthIP = ip
+	return newNode
+}
+
+// parsePodCIDRs assigns the pod CIDRs of a dual-stack node to the allocation
+// CIDRs of n. At most one CIDR per address family is used, additional CIDRs of
+// a family are ignored.
+func parsePodCIDRs(podCIDRs []string, n *node.Node, scopedLog *logrus.Entry) {
+	for _, podCIDR := range podCIDRs {
+		allocCIDR, err := cidr.ParseCIDR(podCIDR)
+		if err != nil {
+			scopedLog.WithError(err).WithField(logfields.V4Prefix, podCIDR).Warn("Invalid PodCIDR value for node")
+			continue
+		}
+
+		field, prefixField := &n.IPv6AllocCIDR, logfields.V6Prefix
+		if allocCIDR.IP.To4() != nil {
+			field, prefixField = &n.IPv4AllocCIDR, logfields.V4Prefix
+		}
+
+		if *field != nil {
+			scopedLog.WithField(prefixField, podCIDR).Warn("Ignoring additional PodCIDR of the same address family for node")
+			continue
 		}
+		*field = allocCIDR
 	}
+}
 
-	return newNode
+// DefaultGetNodeTimeout is the timeout of GetNode for callers on the startup
+// path which must not block indefinitely against a slow kube-apiserver
+const DefaultGetNodeTimeout = 10 * time.Second
+
+// GetNodeOptions are the options of GetNode
+type GetNodeOptions struct {
+	// Timeout is the maximum time to wait for the kube-apiserver. Zero
+	// means that only the deadline of the context applies.
+	Timeout time.Duration
+
+	// Store is an optional informer store of *v1.Node objects. If the node
+	// is found in the store, it is returned without querying the
+	// kube-apiserver.
+	Store cache.Store
 }
 
 // GetNode returns the kubernetes nodeName's node information from the
-// kubernetes api server
-func GetNode(c kubernetes.Interface, nodeName string) (*v1.Node, error) {
-	// Try to retrieve node's cidr and addresses from k8s's configuration
-	return c.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+// kubernetes api server, or from opts.Store if the node is cached. Returns
+// an error if ctx is cancelled or opts.Timeout expires before the
+// kube-apiserver responds. Errors are returned as NodeError.
+func GetNode(ctx context.Context, c kubernetes.Interface, nodeName string, opts GetNodeOptions) (*v1.Node, error) {
+	if opts.Store != nil {
+		obj, exists, err := opts.Store.GetByKey(nodeName)
+		if err == nil && exists {
+			if k8sNode, ok := obj.(*v1.Node); ok {
+				return k8sNode.DeepCopy(), nil
+			}
+		}
+	}
+
+	if opts.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		node *v1.Node
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Try to retrieve node's cidr and addresses from k8s's configuration
+		k8sNode, err := c.CoreV1().Nodes().Get(nodeName, metav1.GetOptions{})
+		done <- result{node: k8sNode, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.node, newNodeError("get", nodeName, r.err)
+	case <-ctx.Done():
+		return nil, newNodeError("get", nodeName, ctx.Err())
+	}
 }
 
+// nodeNetworkUnavailableReason is the reason of the NodeNetworkUnavailable
+// condition set by Cilium
+const nodeNetworkUnavailableReason = "CiliumIsUp"
+
 // SetNodeNetworkUnavailableFalse sets Kubernetes NodeNetworkUnavailable to
-// false as Cilium is managing the network connectivity.
+// false as Cilium is managing the network connectivity. The node is not
+// patched if the condition is already set. Errors are retried according to
+// their NodeErrorClass until ctx is cancelled.
 // https://kubernetes.io/docs/concepts/architecture/nodes/#condition
-func SetNodeNetworkUnavailableFalse(c kubernetes.Interface, nodeName string) error {
+func SetNodeNetworkUnavailableFalse(ctx context.Context, c kubernetes.Interface, nodeName string) error {
+	return retryNodeOperation(ctx, "set-network-available", nodeName, func() error {
+		return setNodeNetworkUnavailableFalse(ctx, c, nodeName)
+	})
+}
+
+func setNodeNetworkUnavailableFalse(ctx context.Context, c kubernetes.Interface, nodeName string) error {
+	k8sNode, err := GetNode(ctx, c, nodeName, GetNodeOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, condition := range k8sNode.Status.Conditions {
+		if condition.Type == v1.NodeNetworkUnavailable &&
+			condition.Status == v1.ConditionFalse &&
+			condition.Reason == nodeNetworkUnavailableReason {
+			return nil
+		}
+	}
+
 	condition := v1.NodeCondition{
 		Type:               v1.NodeNetworkUnavailable,
 		Status:             v1.ConditionFalse,
-		Reason:             "CiliumIsUp",
+		Reason:             nodeNetworkUnavailableReason,
 		Message:            "Cilium is running on this node",
 		LastTransitionTime: metav1.Now(),
 		LastHeartbeatTime:  metav1.Now(),
@@ -206,3 +359,52 @@ func SetNodeNetworkUnavailableFalse(c kubernetes.Interface, nodeName string) err
 	_, err = c.CoreV1().Nodes().PatchStatus(nodeName, patch)
 	return err
 }
+
+// RemoveNodeTaint removes all taints with the given key from the Kubernetes
+// node nodeName. Errors are retried according to their NodeErrorClass.
+func RemoveNodeTaint(ctx context.Context, c kubernetes.Interface, nodeName, taintKey string) error {
+	return retryNodeOperation(ctx, "remove-taint", nodeName, func() error {
+		k8sNode, err := GetNode(ctx, c, nodeName, GetNodeOptions{})
+		if err != nil {
+			return err
+		}
+
+		taints := make([]v1.Taint, 0, len(k8sNode.Spec.Taints))
+		for _, taint := range k8sNode.Spec.Taints {
+			if taint.Key != taintKey {
+				taints = append(taints, taint)
+			}
+		}
+		if len(taints) == len(k8sNode.Spec.Taints) {
+			return nil
+		}
+
+		k8sNode = k8sNode.DeepCopy()
+		k8sNode.Spec.Taints = taints
+		_, err = c.CoreV1().Nodes().Update(k8sNode)
+		return err
+	})
+}
+
+// AddNodeTaint adds taint to the Kubernetes node nodeName unless a taint
+// with the same key and effect is already present. Errors are retried
+// according to their NodeErrorClass.
+func AddNodeTaint(ctx context.Context, c kubernetes.Interface, nodeName string, taint v1.Taint) error {
+	return retryNodeOperation(ctx, "add-taint", nodeName, func() error {
+		k8sNode, err := GetNode(ctx, c, nodeName, GetNodeOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, t := range k8sNode.Spec.Taints {
+			if t.MatchTaint(&taint) {
+				return nil
+			}
+		}
+
+		k8sNode = k8sNode.DeepCopy()
+		k8sNode.Spec.Taints = append(k8sNode.Spec.Taints, taint)
+		_, err = c.CoreV1().Nodes().Update(k8sNode)
+		return err
+	})
+}
@@ -0,0 +1,257 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/annotation"
+	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NodeAnnotationFieldManager is the field manager owning the node
+// annotations written by a NodeAnnotationWriter
+const NodeAnnotationFieldManager = "cilium-agent"
+
+// nodeApplyPatch is the server-side apply configuration of the node
+// annotations
+type nodeApplyPatch struct {
+	APIVersion string                 `json:"apiVersion"`
+	Kind       string                 `json:"kind"`
+	Metadata   nodeApplyPatchMetadata `json:"metadata"`
+}
+
+type nodeApplyPatchMetadata struct {
+	Name        string            `json:"name"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// NodeAnnotationWriter writes the Cilium annotations of a node. Annotations
+// are collected with the Set functions and written in a single request by
+// Flush(). The annotations are written with server-side apply and owned by
+// NodeAnnotationFieldManager, annotations removed from the writer are thus
+// removed from the node as well. If the kube-apiserver does not support
+// server-side apply, the annotations are written with a strategic merge
+// patch instead.
+type NodeAnnotationWriter struct {
+	client   kubernetes.Interface
+	nodeName string
+
+	// apply sends an apply patch of the node to the kube-apiserver
+	apply func(ctx context.Context, nodeName string, patch []byte) error
+
+	// mutex protects all fields below
+	mutex lock.Mutex
+
+	// annotations are the annotations of the node owned by the writer
+	annotations map[string]string
+
+	// removed are the annotations removed since the last successful
+	// write, only required for strategic merge patches
+	removed map[string]struct{}
+
+	// generation is incremented on each change of annotations
+	generation uint64
+
+	// written is the generation last written successfully
+	written uint64
+
+	// applyUnsupported is true if the kube-apiserver has rejected apply
+	// patches
+	applyUnsupported bool
+}
+
+// NewNodeAnnotationWriter returns a writer of the annotations of the node
+// with the given name
+func NewNodeAnnotationWriter(c kubernetes.Interface, nodeName string) *NodeAnnotationWriter {
+	w := &NodeAnnotationWriter{
+		client:      c,
+		nodeName:    nodeName,
+		annotations: map[string]string{},
+		removed:     map[string]struct{}{},
+	}
+	w.apply = w.restApply
+	return w
+}
+
+// Set sets the annotation name to value. An empty value removes the
+// annotation.
+func (w *NodeAnnotationWriter) Set(name, value string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	old, ok := w.annotations[name]
+	switch {
+	case value == "" && ok:
+		delete(w.annotations, name)
+		w.removed[name] = struct{}{}
+	case value != "" && (!ok || old != value):
+		w.annotations[name] = value
+		delete(w.removed, name)
+	default:
+		return
+	}
+	w.generation++
+}
+
+// SetCIDRs sets the allocation CIDR annotations. A nil CIDR removes the
+// respective annotation.
+func (w *NodeAnnotationWriter) SetCIDRs(v4CIDR, v6CIDR *cidr.CIDR) {
+	w.Set(annotation.V4CIDRName, cidrString(v4CIDR))
+	w.Set(annotation.V6CIDRName, cidrString(v6CIDR))
+}
+
+// SetHealthIPs sets the health IP annotations. A nil IP removes the
+// respective annotation.
+func (w *NodeAnnotationWriter) SetHealthIPs(v4HealthIP, v6HealthIP net.IP) {
+	w.Set(annotation.V4HealthName, ipString(v4HealthIP))
+	w.Set(annotation.V6HealthName, ipString(v6HealthIP))
+}
+
+// SetCiliumHostIPs sets the cilium_host IP annotations. A nil IP removes the
+// respective annotation.
+func (w *NodeAnnotationWriter) SetCiliumHostIPs(v4CiliumHostIP, v6CiliumHostIP net.IP) {
+	w.Set(annotation.CiliumHostIP, ipString(v4CiliumHostIP))
+	w.Set(annotation.CiliumHostIPv6, ipString(v6CiliumHostIP))
+}
+
+func cidrString(c *cidr.CIDR) string {
+	if c == nil {
+		return ""
+	}
+	return c.String()
+}
+
+func ipString(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
+
+// Flush writes all annotations of the writer to the node in a single request
+// if they have changed since the last successful write. Conflicts and
+// transient errors are retried.
+func (w *NodeAnnotationWriter) Flush(ctx context.Context) error {
+	w.mutex.Lock()
+	if w.generation == w.written {
+		w.mutex.Unlock()
+		return nil
+	}
+	generation := w.generation
+	annotations := make(map[string]string, len(w.annotations))
+	for name, value := range w.annotations {
+		annotations[name] = value
+	}
+	removed := make([]string, 0, len(w.removed))
+	for name := range w.removed {
+		removed = append(removed, name)
+	}
+	applyUnsupported := w.applyUnsupported
+	w.mutex.Unlock()
+
+	var err error
+	if !applyUnsupported {
+		err = w.flushApply(ctx, annotations)
+		if k8serrors.IsUnsupportedMediaType(err) {
+			log.WithField(logfields.NodeName, w.nodeName).
+				Info("Server-side apply not supported, patching node annotations instead")
+			applyUnsupported = true
+		}
+	}
+	if applyUnsupported {
+		err = w.flushPatch(ctx, annotations, removed)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.applyUnsupported = applyUnsupported
+	if err != nil {
+		return err
+	}
+	if w.generation == generation {
+		w.removed = map[string]struct{}{}
+	}
+	w.written = generation
+	return nil
+}
+
+// flushApply writes annotations with server-side apply
+func (w *NodeAnnotationWriter) flushApply(ctx context.Context, annotations map[string]string) error {
+	patch, err := json.Marshal(nodeApplyPatch{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Metadata: nodeApplyPatchMetadata{
+			Name:        w.nodeName,
+			Annotations: annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return retryNodeOperation(ctx, "apply-annotations", w.nodeName, func() error {
+		return w.apply(ctx, w.nodeName, patch)
+	})
+}
+
+// flushPatch writes annotations with a strategic merge patch, removed
+// annotations are deleted
+func (w *NodeAnnotationWriter) flushPatch(ctx context.Context, annotations map[string]string, removed []string) error {
+	values := make(map[string]*string, len(annotations)+len(removed))
+	for name := range annotations {
+		value := annotations[name]
+		values[name] = &value
+	}
+	for _, name := range removed {
+		values[name] = nil
+	}
+
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":%s}}`, raw))
+
+	return retryNodeOperation(ctx, "patch-annotations", w.nodeName, func() error {
+		_, err := w.client.CoreV1().Nodes().Patch(w.nodeName, types.StrategicMergePatchType, patch)
+		return err
+	})
+}
+
+// restApply sends an apply patch of the node. Ownership of the annotations
+// is forced as they may have been written by older versions of Cilium with
+// a different field manager.
+func (w *NodeAnnotationWriter) restApply(ctx context.Context, nodeName string, patch []byte) error {
+	return w.client.CoreV1().RESTClient().Patch(types.ApplyPatchType).
+		Context(ctx).
+		Resource("nodes").
+		Name(nodeName).
+		Param("fieldManager", NodeAnnotationFieldManager).
+		Param("force", "true").
+		Body(patch).
+		Do().
+		Error()
+}
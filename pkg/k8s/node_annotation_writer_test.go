@@ -0,0 +1,150 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cilium/cilium/pkg/annotation"
+	"github.com/cilium/cilium/pkg/cidr"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func (s *K8sSuite) TestNodeAnnotationWriterApply(c *C) {
+	oldBackoff := nodeRetryBackoff
+	nodeRetryBackoff.Min = time.Millisecond
+	nodeRetryBackoff.Max = time.Millisecond
+	defer func() { nodeRetryBackoff = oldBackoff }()
+
+	w := NewNodeAnnotationWriter(&fake.Clientset{}, "node1")
+
+	var patches []nodeApplyPatch
+	conflicts := 1
+	w.apply = func(ctx context.Context, nodeName string, patch []byte) error {
+		c.Assert(nodeName, Equals, "node1")
+		if conflicts > 0 {
+			conflicts--
+			return k8serrors.NewConflict(v1.Resource("nodes"), nodeName, errors.New("conflict"))
+		}
+		var p nodeApplyPatch
+		c.Assert(json.Unmarshal(patch, &p), IsNil)
+		patches = append(patches, p)
+		return nil
+	}
+
+	// Nothing to write
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(patches, HasLen, 0)
+
+	// All annotations are written in a single request, conflicts are
+	// retried
+	v4CIDR := cidr.MustParseCIDR("10.1.0.0/16")
+	w.SetCIDRs(v4CIDR, nil)
+	w.SetHealthIPs(net.ParseIP("10.1.0.2"), nil)
+	w.SetCiliumHostIPs(net.ParseIP("10.1.0.1"), net.ParseIP("f00d::1"))
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(conflicts, Equals, 0)
+	c.Assert(patches, HasLen, 1)
+	c.Assert(patches[0].APIVersion, Equals, "v1")
+	c.Assert(patches[0].Kind, Equals, "Node")
+	c.Assert(patches[0].Metadata.Name, Equals, "node1")
+	c.Assert(patches[0].Metadata.Annotations, DeepEquals, map[string]string{
+		annotation.V4CIDRName:     "10.1.0.0/16",
+		annotation.V4HealthName:   "10.1.0.2",
+		annotation.CiliumHostIP:   "10.1.0.1",
+		annotation.CiliumHostIPv6: "f00d::1",
+	})
+
+	// Unchanged annotations are not written again
+	w.SetCIDRs(v4CIDR, nil)
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(patches, HasLen, 1)
+
+	// Removed annotations are omitted from the apply patch
+	w.SetCiliumHostIPs(net.ParseIP("10.1.0.1"), nil)
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(patches, HasLen, 2)
+	c.Assert(patches[1].Metadata.Annotations, DeepEquals, map[string]string{
+		annotation.V4CIDRName:   "10.1.0.0/16",
+		annotation.V4HealthName: "10.1.0.2",
+		annotation.CiliumHostIP: "10.1.0.1",
+	})
+
+	// Failed writes are retried by the next flush
+	w.apply = func(ctx context.Context, nodeName string, patch []byte) error {
+		return k8serrors.NewForbidden(v1.Resource("nodes"), nodeName, errors.New("forbidden"))
+	}
+	w.Set(annotation.V4HealthName, "10.1.0.3")
+	c.Assert(ClassifyNodeError(w.Flush(context.Background())), Equals, NodeErrorForbidden)
+	w.apply = func(ctx context.Context, nodeName string, patch []byte) error {
+		var p nodeApplyPatch
+		c.Assert(json.Unmarshal(patch, &p), IsNil)
+		patches = append(patches, p)
+		return nil
+	}
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(patches, HasLen, 3)
+	c.Assert(patches[2].Metadata.Annotations[annotation.V4HealthName], Equals, "10.1.0.3")
+}
+
+func (s *K8sSuite) TestNodeAnnotationWriterPatchFallback(c *C) {
+	fakeClient := &fake.Clientset{}
+	var patches []string
+	fakeClient.AddReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, string(action.(k8stesting.PatchAction).GetPatch()))
+		return true, &v1.Node{}, nil
+	})
+
+	w := NewNodeAnnotationWriter(fakeClient, "node1")
+	applied := 0
+	w.apply = func(ctx context.Context, nodeName string, patch []byte) error {
+		applied++
+		return &k8serrors.StatusError{ErrStatus: metav1.Status{
+			Status: metav1.StatusFailure,
+			Code:   415,
+			Reason: metav1.StatusReasonUnsupportedMediaType,
+		}}
+	}
+
+	w.Set(annotation.V4CIDRName, "10.1.0.0/16")
+	w.Set(annotation.CiliumHostIP, "10.1.0.1")
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(applied, Equals, 1)
+	c.Assert(patches, DeepEquals, []string{
+		`{"metadata":{"annotations":{"io.cilium.network.ipv4-cilium-host":"10.1.0.1","io.cilium.network.ipv4-pod-cidr":"10.1.0.0/16"}}}`,
+	})
+
+	// Apply is not attempted again, removed annotations are deleted by
+	// the patch
+	w.Set(annotation.CiliumHostIP, "")
+	c.Assert(w.Flush(context.Background()), IsNil)
+	c.Assert(applied, Equals, 1)
+	c.Assert(patches, HasLen, 2)
+	c.Assert(patches[1], Equals,
+		`{"metadata":{"annotations":{"io.cilium.network.ipv4-cilium-host":null,"io.cilium.network.ipv4-pod-cidr":"10.1.0.0/16"}}}`)
+}
@@ -0,0 +1,208 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/annotation"
+	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/node/addressing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NodeAnnotation maps an annotation of a Kubernetes node to a field of the
+// cilium node parsed by ParseNode
+type NodeAnnotation struct {
+	// Name is the name of the annotation
+	Name string
+
+	// Field is the name of the field of node.Node populated from the
+	// annotation. It is only used for logging.
+	Field string
+
+	// Parse parses the value of the annotation and assigns it to the
+	// field of n. Returning an error marks the value as invalid, in which
+	// case n must not have been modified. Parse is only called if the
+	// annotation is present and not empty.
+	Parse func(value string, n *node.Node) error
+}
+
+var (
+	// nodeAnnotationsMutex protects nodeAnnotations
+	nodeAnnotationsMutex lock.RWMutex
+
+	// nodeAnnotations is the list of node annotations parsed by
+	// ParseNode, in the order in which they are parsed
+	nodeAnnotations = []NodeAnnotation{
+		{
+			Name:  annotation.CiliumHostIP,
+			Field: "IPAddresses",
+			Parse: parseCiliumInternalIP,
+		},
+		{
+			Name:  annotation.CiliumHostIPv6,
+			Field: "IPAddresses",
+			Parse: parseCiliumInternalIP,
+		},
+		{
+			// Spec.PodCIDR takes precedence since it's the CIDR
+			// assigned by k8s controller manager. The annotation is
+			// only used in case it's invalid or empty.
+			Name:  annotation.V4CIDRName,
+			Field: "IPv4AllocCIDR",
+			Parse: func(value string, n *node.Node) error {
+				return parseAllocCIDR(value, &n.IPv4AllocCIDR)
+			},
+		},
+		{
+			Name:  annotation.V6CIDRName,
+			Field: "IPv6AllocCIDR",
+			Parse: func(value string, n *node.Node) error {
+				return parseAllocCIDR(value, &n.IPv6AllocCIDR)
+			},
+		},
+		{
+			Name:  annotation.SecondaryCIDRsName,
+			Field: "SecondaryAllocCIDRs",
+			Parse: parseSecondaryAllocCIDRs,
+		},
+		{
+			Name:  annotation.V4HealthName,
+			Field: "IPv4HealthIP",
+			Parse: func(value string, n *node.Node) error {
+				return parseIP(value, &n.IPv4HealthIP)
+			},
+		},
+		{
+			Name:  annotation.V6HealthName,
+			Field: "IPv6HealthIP",
+			Parse: func(value string, n *node.Node) error {
+				return parseIP(value, &n.IPv6HealthIP)
+			},
+		},
+	}
+)
+
+// RegisterNodeAnnotation adds a to the node annotations parsed by ParseNode.
+// Annotations are parsed in the order of registration after the built-in
+// annotations. Returns an error if the annotation is already registered.
+func RegisterNodeAnnotation(a NodeAnnotation) error {
+	if a.Name == "" || a.Parse == nil {
+		return fmt.Errorf("node annotation requires a name and a parser")
+	}
+
+	nodeAnnotationsMutex.Lock()
+	defer nodeAnnotationsMutex.Unlock()
+
+	for _, registered := range nodeAnnotations {
+		if registered.Name == a.Name {
+			return fmt.Errorf("node annotation %s already registered", a.Name)
+		}
+	}
+	nodeAnnotations = append(nodeAnnotations, a)
+	return nil
+}
+
+// parseNodeAnnotations populates n from the annotations of the Kubernetes
+// node. Missing and invalid annotations are logged and otherwise ignored.
+func parseNodeAnnotations(annotations map[string]string, n *node.Node, scopedLog *logrus.Entry) {
+	nodeAnnotationsMutex.RLock()
+	defer nodeAnnotationsMutex.RUnlock()
+
+	for _, a := range nodeAnnotations {
+		annotationLog := scopedLog.WithFields(logrus.Fields{
+			"annotation": a.Name,
+			"field":      a.Field,
+		})
+
+		value, ok := annotations[a.Name]
+		if !ok || value == "" {
+			annotationLog.Debug("Empty annotation in node")
+			continue
+		}
+
+		if err := a.Parse(value, n); err != nil {
+			annotationLog.WithError(err).WithField("value", value).Warning("Ignoring invalid annotation in node")
+		}
+	}
+}
+
+// parseCiliumInternalIP adds the IP in value to the addresses of n as
+// NodeCiliumInternalIP
+func parseCiliumInternalIP(value string, n *node.Node) error {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address")
+	}
+	n.IPAddresses = append(n.IPAddresses, node.Address{
+		Type: addressing.NodeCiliumInternalIP,
+		IP:   ip,
+	})
+	return nil
+}
+
+// parseAllocCIDR parses the CIDR in value into field unless field has
+// already been populated from the node spec
+func parseAllocCIDR(value string, field **cidr.CIDR) error {
+	if *field != nil {
+		return nil
+	}
+	allocCIDR, err := cidr.ParseCIDR(value)
+	if err != nil {
+		return err
+	}
+	*field = allocCIDR
+	return nil
+}
+
+// parseSecondaryAllocCIDRs parses the comma-separated list of CIDRs in value
+// into the secondary allocation CIDRs of n of the respective address family
+func parseSecondaryAllocCIDRs(value string, n *node.Node) error {
+	var v4CIDRs, v6CIDRs []*cidr.CIDR
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		allocCIDR, err := cidr.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		if allocCIDR.IP.To4() != nil {
+			v4CIDRs = append(v4CIDRs, allocCIDR)
+		} else {
+			v6CIDRs = append(v6CIDRs, allocCIDR)
+		}
+	}
+	n.IPv4SecondaryAllocCIDRs = v4CIDRs
+	n.IPv6SecondaryAllocCIDRs = v6CIDRs
+	return nil
+}
+
+// parseIP parses the IP in value into field
+func parseIP(value string, field *net.IP) error {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address")
+	}
+	*field = ip
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/annotation"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// legacyNodeAnnotations maps node annotation names written by older versions
+// of Cilium to their current names
+var legacyNodeAnnotations = map[string]string{
+	annotation.V4CIDRNameLegacy:     annotation.V4CIDRName,
+	annotation.V6CIDRNameLegacy:     annotation.V6CIDRName,
+	annotation.V4HealthNameLegacy:   annotation.V4HealthName,
+	annotation.V6HealthNameLegacy:   annotation.V6HealthName,
+	annotation.CiliumHostIPLegacy:   annotation.CiliumHostIP,
+	annotation.CiliumHostIPv6Legacy: annotation.CiliumHostIPv6,
+}
+
+// nodeAnnotationNormalizers maps node annotation names to functions
+// converting values in formats written by older versions of Cilium to the
+// current format. A normalizer returns an error if the value is invalid, in
+// which case the value is left untouched and rejected by the parser.
+var nodeAnnotationNormalizers = map[string]func(string) (string, error){
+	annotation.V4CIDRName:     normalizeCIDR,
+	annotation.V6CIDRName:     normalizeCIDR,
+	annotation.V4HealthName:   normalizeIP,
+	annotation.V6HealthName:   normalizeIP,
+	annotation.CiliumHostIP:   normalizeIP,
+	annotation.CiliumHostIPv6: normalizeIP,
+}
+
+// normalizeIP converts an IP address, optionally written as a host prefix
+// such as "10.0.0.1/32", to its canonical representation
+func normalizeIP(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if ip, ipNet, err := net.ParseCIDR(value); err == nil {
+		if ones, bits := ipNet.Mask.Size(); ones != bits {
+			return "", fmt.Errorf("prefix %s is not a single address", value)
+		}
+		return ip.String(), nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return "", fmt.Errorf("invalid IP address")
+	}
+	return ip.String(), nil
+}
+
+// normalizeCIDR converts a CIDR, optionally with host bits set such as
+// "10.0.1.5/24", to its canonical representation
+func normalizeCIDR(value string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(value))
+	if err != nil {
+		return "", err
+	}
+	return ipNet.String(), nil
+}
+
+// NormalizeNodeAnnotations converts node annotations written by older
+// versions of Cilium to the current representation. Annotations with legacy
+// names are renamed unless the current name is present as well, and values
+// in legacy formats are converted. The annotations passed in are not
+// modified. Returns the normalized annotations along with the annotations
+// to patch on the node to persist the normalization, mapping legacy names to
+// nil. The patch is empty if no annotation required normalization.
+func NormalizeNodeAnnotations(annotations map[string]string) (map[string]string, map[string]*string) {
+	normalized := make(map[string]string, len(annotations))
+	patch := map[string]*string{}
+
+	for name, value := range annotations {
+		normalized[name] = value
+	}
+
+	for legacy, current := range legacyNodeAnnotations {
+		value, ok := normalized[legacy]
+		if !ok {
+			continue
+		}
+		delete(normalized, legacy)
+		patch[legacy] = nil
+		if _, ok := normalized[current]; !ok {
+			normalized[current] = value
+			patch[current] = &value
+		}
+	}
+
+	for name, normalize := range nodeAnnotationNormalizers {
+		value, ok := normalized[name]
+		if !ok || value == "" {
+			continue
+		}
+		normalizedValue, err := normalize(value)
+		if err != nil || normalizedValue == value {
+			continue
+		}
+		normalized[name] = normalizedValue
+		patch[name] = &normalizedValue
+	}
+
+	return normalized, patch
+}
+
+// rewriteLegacyNodeAnnotations persists the normalization of the
+// annotations of k8sNode written by older versions of Cilium, replacing
+// legacy annotation names and values with the current representation
+func rewriteLegacyNodeAnnotations(c kubernetes.Interface, k8sNode *v1.Node) error {
+	_, patch := NormalizeNodeAnnotations(k8sNode.Annotations)
+	if len(patch) == 0 {
+		return nil
+	}
+
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		logfields.NodeName: k8sNode.Name,
+		"annotations":      string(raw),
+	}).Info("Rewriting node annotations written by an older version of Cilium")
+
+	_, err = c.CoreV1().Nodes().Patch(k8sNode.Name, types.StrategicMergePatchType,
+		[]byte(fmt.Sprintf(`{"metadata":{"annotations":%s}}`, raw)))
+	return err
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package k8s
+
+import (
+	"strconv"
+
+	"github.com/cilium/cilium/pkg/annotation"
+	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/k8s/types"
+	"github.com/cilium/cilium/pkg/node"
+
+	. "gopkg.in/check.v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/testing"
+)
+
+func (s *K8sSuite) TestParseNodeInvalidAnnotations(c *C) {
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				annotation.V4CIDRName:     "10.254.0.0",
+				annotation.V4HealthName:   "not-an-ip",
+				annotation.V6HealthName:   "f00d::1",
+				annotation.CiliumHostIP:   "10.254.0.1",
+				annotation.CiliumHostIPv6: "invalid",
+			},
+		},
+	}
+
+	n := ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4AllocCIDR, IsNil)
+	c.Assert(n.IPv4HealthIP, IsNil)
+	c.Assert(n.IPv6HealthIP.String(), Equals, "f00d::1")
+	c.Assert(n.IPAddresses, HasLen, 1)
+	c.Assert(n.IPAddresses[0].IP.String(), Equals, "10.254.0.1")
+}
+
+func (s *K8sSuite) TestRegisterNodeAnnotation(c *C) {
+	mtuAnnotation := annotation.Prefix + ".network.test-mtu"
+	err := RegisterNodeAnnotation(NodeAnnotation{
+		Name:  mtuAnnotation,
+		Field: "MTU",
+		Parse: func(value string, n *node.Node) error {
+			mtu, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			n.MTU = mtu
+			return nil
+		},
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(RegisterNodeAnnotation(NodeAnnotation{Name: mtuAnnotation, Parse: parseIP4}), Not(IsNil))
+	c.Assert(RegisterNodeAnnotation(NodeAnnotation{Name: annotation.V4CIDRName, Parse: parseIP4}), Not(IsNil))
+	c.Assert(RegisterNodeAnnotation(NodeAnnotation{Name: "missing-parser"}), Not(IsNil))
+
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "node1",
+			Annotations: map[string]string{mtuAnnotation: "1450"},
+		},
+	}
+	c.Assert(ParseNode(k8sNode, node.FromAgentLocal).MTU, Equals, 1450)
+
+	k8sNode.Annotations[mtuAnnotation] = "invalid"
+	c.Assert(ParseNode(k8sNode, node.FromAgentLocal).MTU, Equals, 0)
+}
+
+func parseIP4(value string, n *node.Node) error {
+	return parseIP(value, &n.IPv4HealthIP)
+}
+
+func (s *K8sSuite) TestNormalizeNodeAnnotations(c *C) {
+	annotations := map[string]string{
+		annotation.V4CIDRNameLegacy:   "10.254.1.5/24",
+		annotation.V4HealthNameLegacy: "10.254.1.2",
+		annotation.V4HealthName:       "10.254.1.3",
+		annotation.CiliumHostIP:       "10.254.1.1/32",
+		annotation.V6HealthName:       "f00d:0:0:0::1",
+		annotation.CiliumHostIPv6:     "invalid",
+		"unrelated":                   "value",
+	}
+
+	normalized, patch := NormalizeNodeAnnotations(annotations)
+	c.Assert(normalized, checker.DeepEquals, map[string]string{
+		annotation.V4CIDRName:     "10.254.1.0/24",
+		annotation.V4HealthName:   "10.254.1.3",
+		annotation.CiliumHostIP:   "10.254.1.1",
+		annotation.V6HealthName:   "f00d::1",
+		annotation.CiliumHostIPv6: "invalid",
+		"unrelated":               "value",
+	})
+	c.Assert(patch, HasLen, 5)
+	c.Assert(patch[annotation.V4CIDRNameLegacy], IsNil)
+	c.Assert(patch[annotation.V4HealthNameLegacy], IsNil)
+	c.Assert(*patch[annotation.V4CIDRName], Equals, "10.254.1.0/24")
+	c.Assert(*patch[annotation.CiliumHostIP], Equals, "10.254.1.1")
+	c.Assert(*patch[annotation.V6HealthName], Equals, "f00d::1")
+
+	// The annotations passed in are not modified
+	c.Assert(annotations, HasLen, 7)
+	c.Assert(annotations[annotation.V4CIDRNameLegacy], Equals, "10.254.1.5/24")
+
+	// Current annotations do not require a patch
+	_, patch = NormalizeNodeAnnotations(normalized)
+	c.Assert(patch, HasLen, 0)
+
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "node1",
+			Annotations: annotations,
+		},
+	}
+	n := ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.254.1.0/24")
+	c.Assert(n.IPv4HealthIP.String(), Equals, "10.254.1.3")
+	c.Assert(n.IPv6HealthIP.String(), Equals, "f00d::1")
+	c.Assert(n.IPAddresses, HasLen, 1)
+	c.Assert(n.IPAddresses[0].IP.String(), Equals, "10.254.1.1")
+}
+
+func (s *K8sSuite) TestRewriteLegacyNodeAnnotations(c *C) {
+	k8sNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				annotation.CiliumHostIPLegacy: "10.254.1.1",
+			},
+		},
+	}
+
+	patches := 0
+	fakeK8sClient := &fake.Clientset{}
+	fakeK8sClient.AddReactor("patch", "nodes",
+		func(action testing.Action) (bool, runtime.Object, error) {
+			patches++
+			c.Assert(string(action.(testing.PatchAction).GetPatch()), Equals,
+				`{"metadata":{"annotations":{"io.cilium.network.ipv4-cilium-host":"10.254.1.1","io.cilium.network.ipv4-host-ip":null}}}`)
+			return true, k8sNode, nil
+		})
+
+	c.Assert(rewriteLegacyNodeAnnotations(fakeK8sClient, k8sNode), IsNil)
+	c.Assert(patches, Equals, 1)
+
+	// Nodes without legacy annotations are not patched
+	k8sNode.Annotations = map[string]string{annotation.CiliumHostIP: "10.254.1.1"}
+	c.Assert(rewriteLegacyNodeAnnotations(fakeK8sClient, k8sNode), IsNil)
+	c.Assert(patches, Equals, 1)
+}
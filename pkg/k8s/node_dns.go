@@ -0,0 +1,117 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/lock"
+
+	"k8s.io/api/core/v1"
+)
+
+// NodeAddressResolver resolves the DNS names of node addresses
+type NodeAddressResolver interface {
+	// LookupIP returns the IPs of host along with the time for which
+	// they may be used before host must be resolved again
+	LookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error)
+}
+
+// netResolver is a NodeAddressResolver using the resolver of the net
+// package. The TTL of the records is not exposed, the IPs are used for
+// defaults.NodeAddressDNSTTL.
+type netResolver struct{}
+
+func (netResolver) LookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, defaults.NodeAddressDNSTTL, nil
+}
+
+// dnsCacheEntry is the result of the resolution of a DNS name
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// nodeAddressDNSCache caches the resolved node address DNS names so that
+// nodes are not resolved on every update. Failed resolutions keep the IPs
+// of the previous resolution, if any, until the next successful one.
+type nodeAddressDNSCache struct {
+	resolver NodeAddressResolver
+
+	// mutex protects entries
+	mutex   lock.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+// nodeDNSCache resolves the DNS addresses of all nodes parsed by ParseNode
+var nodeDNSCache = newNodeAddressDNSCache(netResolver{})
+
+func newNodeAddressDNSCache(resolver NodeAddressResolver) *nodeAddressDNSCache {
+	return &nodeAddressDNSCache{
+		resolver: resolver,
+		entries:  map[string]dnsCacheEntry{},
+	}
+}
+
+// resolve returns the IPs of host, resolving host if it is unknown or its
+// TTL has expired
+func (c *nodeAddressDNSCache) resolve(host string) ([]net.IP, error) {
+	c.mutex.Lock()
+	entry, ok := c.entries[host]
+	c.mutex.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaults.NodeAddressDNSTimeout)
+	defer cancel()
+	ips, ttl, err := c.resolver.LookupIP(ctx, host)
+	if err != nil {
+		// Keep using the stale IPs rather than dropping the address
+		return entry.ips, err
+	}
+
+	c.mutex.Lock()
+	c.entries[host] = dnsCacheEntry{ips: ips, expires: time.Now().Add(ttl)}
+	c.mutex.Unlock()
+	return ips, nil
+}
+
+// isNodeAddressDNS returns true if addresses of type t hold a DNS name
+func isNodeAddressDNS(t v1.NodeAddressType) bool {
+	return t == v1.NodeInternalDNS || t == v1.NodeExternalDNS
+}
+
+// resolvedAddressType returns the address type of the IPs resolved from an
+// address of DNS address type t. Resolved IPs are treated like IPs of the
+// corresponding IP type so that they are subject to the configured node
+// address types.
+func resolvedAddressType(t v1.NodeAddressType) v1.NodeAddressType {
+	if t == v1.NodeInternalDNS {
+		return v1.NodeInternalIP
+	}
+	return v1.NodeExternalIP
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/k8s/types"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/node"
+	nodeAddressing "github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/option"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeResolver resolves names from a static map and counts the lookups
+type fakeResolver struct {
+	mutex   lock.Mutex
+	records map[string][]net.IP
+	ttl     time.Duration
+	lookups int
+}
+
+func (r *fakeResolver) LookupIP(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lookups++
+	ips, ok := r.records[host]
+	if !ok {
+		return nil, 0, errors.New("no such host")
+	}
+	return ips, r.ttl, nil
+}
+
+func (r *fakeResolver) set(host string, ips ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(ips) == 0 {
+		delete(r.records, host)
+		return
+	}
+	r.records[host] = nil
+	for _, ip := range ips {
+		r.records[host] = append(r.records[host], net.ParseIP(ip))
+	}
+}
+
+func (r *fakeResolver) getLookups() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lookups
+}
+
+func (s *K8sSuite) TestNodeAddressDNSCache(c *C) {
+	resolver := &fakeResolver{records: map[string][]net.IP{}, ttl: time.Hour}
+	cache := newNodeAddressDNSCache(resolver)
+
+	resolver.set("node1.example.com", "192.0.2.1")
+	ips, err := cache.resolve("node1.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(ips, checker.DeepEquals, []net.IP{net.ParseIP("192.0.2.1")})
+
+	// Names are not resolved again before their TTL has expired
+	resolver.set("node1.example.com", "192.0.2.2")
+	ips, err = cache.resolve("node1.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(ips, checker.DeepEquals, []net.IP{net.ParseIP("192.0.2.1")})
+	c.Assert(resolver.getLookups(), Equals, 1)
+
+	resolver.ttl = 0
+	resolver.set("node2.example.com", "192.0.2.3")
+	_, err = cache.resolve("node2.example.com")
+	c.Assert(err, IsNil)
+	resolver.set("node2.example.com", "192.0.2.4")
+	ips, err = cache.resolve("node2.example.com")
+	c.Assert(err, IsNil)
+	c.Assert(ips, checker.DeepEquals, []net.IP{net.ParseIP("192.0.2.4")})
+
+	// Failed resolutions return the IPs of the previous resolution
+	resolver.set("node2.example.com")
+	ips, err = cache.resolve("node2.example.com")
+	c.Assert(err, NotNil)
+	c.Assert(ips, checker.DeepEquals, []net.IP{net.ParseIP("192.0.2.4")})
+}
+
+func (s *K8sSuite) TestParseNodeResolveDNS(c *C) {
+	oldCache := nodeDNSCache
+	defer func() {
+		nodeDNSCache = oldCache
+		option.Config.ResolveNodeAddressDNS = false
+	}()
+
+	resolver := &fakeResolver{records: map[string][]net.IP{}, ttl: time.Hour}
+	resolver.set("node1.internal", "10.0.0.1", "10.0.0.2")
+	resolver.set("node1.external", "198.51.100.1")
+	nodeDNSCache = newNodeAddressDNSCache(resolver)
+
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+		},
+		StatusAddresses: []v1.NodeAddress{
+			{Type: v1.NodeInternalDNS, Address: "node1.internal"},
+			{Type: v1.NodeExternalDNS, Address: "node1.external"},
+			{Type: v1.NodeExternalDNS, Address: "unknown.external"},
+			{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+		},
+	}
+
+	// DNS addresses are ignored unless resolution is enabled
+	n := ParseNode(k8sNode, node.FromKubernetes)
+	c.Assert(n.IPAddresses, checker.DeepEquals, []node.Address{
+		{Type: nodeAddressing.NodeInternalIP, IP: net.ParseIP("10.0.0.2")},
+	})
+	c.Assert(resolver.getLookups(), Equals, 0)
+
+	option.Config.ResolveNodeAddressDNS = true
+	n = ParseNode(k8sNode, node.FromKubernetes)
+	c.Assert(n.IPAddresses, checker.DeepEquals, []node.Address{
+		{Type: nodeAddressing.NodeInternalIP, IP: net.ParseIP("10.0.0.2")},
+		{Type: nodeAddressing.NodeInternalIP, IP: net.ParseIP("10.0.0.1")},
+		{Type: nodeAddressing.NodeExternalIP, IP: net.ParseIP("198.51.100.1")},
+	})
+	c.Assert(n.GetNodeIP(false).String(), Equals, "10.0.0.2")
+}
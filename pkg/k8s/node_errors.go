@@ -0,0 +1,189 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cilium/cilium/pkg/backoff"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NodeErrorClass is the class of an error returned by a node API operation
+type NodeErrorClass int
+
+const (
+	// NodeErrorOther is the class of all errors not covered by another
+	// class. These errors are not retried.
+	NodeErrorOther NodeErrorClass = iota
+
+	// NodeErrorRetryable is the class of transient errors, e.g. timeouts,
+	// throttling and internal errors of the kube-apiserver
+	NodeErrorRetryable
+
+	// NodeErrorConflict is the class of conflicting updates of the node
+	NodeErrorConflict
+
+	// NodeErrorForbidden is the class of errors due to missing RBAC
+	// permissions
+	NodeErrorForbidden
+
+	// NodeErrorNotFound is the class of errors due to the node not
+	// existing
+	NodeErrorNotFound
+)
+
+func (c NodeErrorClass) String() string {
+	switch c {
+	case NodeErrorRetryable:
+		return "retryable"
+	case NodeErrorConflict:
+		return "conflict"
+	case NodeErrorForbidden:
+		return "forbidden"
+	case NodeErrorNotFound:
+		return "not-found"
+	default:
+		return "other"
+	}
+}
+
+// nodeRetryAttempts is the maximum number of attempts of a node operation
+// per class of error. Classes not listed are not retried.
+var nodeRetryAttempts = map[NodeErrorClass]int{
+	NodeErrorRetryable: 5,
+	NodeErrorConflict:  5,
+}
+
+// nodeRetryBackoff is the backoff between attempts of a node operation
+var nodeRetryBackoff = backoff.Exponential{
+	Min:    time.Duration(200) * time.Millisecond,
+	Max:    time.Duration(10) * time.Second,
+	Factor: 2.0,
+}
+
+// NodeError is the error returned by node API operations
+type NodeError struct {
+	// Op is the name of the failed operation
+	Op string
+
+	// NodeName is the name of the node
+	NodeName string
+
+	// Class is the class of Err
+	Class NodeErrorClass
+
+	// Err is the error returned by the kube-apiserver or the context
+	Err error
+}
+
+// Error returns the error message of the node error
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("%s of node %s failed (%s): %s", e.Op, e.NodeName, e.Class, e.Err)
+}
+
+// Status returns the API status of the wrapped error so that the helpers of
+// k8s.io/apimachinery/pkg/api/errors can be used with node errors
+func (e *NodeError) Status() metav1.Status {
+	if s, ok := e.Err.(k8serrors.APIStatus); ok {
+		return s.Status()
+	}
+	return metav1.Status{
+		Status:  metav1.StatusFailure,
+		Reason:  metav1.StatusReasonUnknown,
+		Message: e.Err.Error(),
+	}
+}
+
+// newNodeError wraps err returned by operation op on node nodeName into a
+// NodeError. Returns nil if err is nil and err if it is a NodeError already.
+func newNodeError(op, nodeName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*NodeError); ok {
+		return err
+	}
+	return &NodeError{
+		Op:       op,
+		NodeName: nodeName,
+		Class:    ClassifyNodeError(err),
+		Err:      err,
+	}
+}
+
+// ClassifyNodeError returns the class of err
+func ClassifyNodeError(err error) NodeErrorClass {
+	if e, ok := err.(*NodeError); ok {
+		return e.Class
+	}
+
+	switch {
+	case err == context.DeadlineExceeded,
+		k8serrors.IsServerTimeout(err),
+		k8serrors.IsTimeout(err),
+		k8serrors.IsTooManyRequests(err),
+		k8serrors.IsInternalError(err),
+		k8serrors.IsServiceUnavailable(err):
+		return NodeErrorRetryable
+	case k8serrors.IsConflict(err):
+		return NodeErrorConflict
+	case k8serrors.IsForbidden(err):
+		return NodeErrorForbidden
+	case k8serrors.IsNotFound(err):
+		return NodeErrorNotFound
+	default:
+		return NodeErrorOther
+	}
+}
+
+// IsNodeNotFound returns true if err reports that the node does not exist
+func IsNodeNotFound(err error) bool {
+	return err != nil && ClassifyNodeError(err) == NodeErrorNotFound
+}
+
+// retryNodeOperation runs fn until it succeeds, the retry policy of the class
+// of the returned error is exhausted or ctx is cancelled. The last error is
+// returned as NodeError.
+func retryNodeOperation(ctx context.Context, op, nodeName string, fn func() error) error {
+	backoff := nodeRetryBackoff
+	backoff.Name = "k8s-node-" + op
+
+	for attempt := 1; ; attempt++ {
+		err := newNodeError(op, nodeName, fn())
+		if err == nil {
+			return nil
+		}
+
+		class := ClassifyNodeError(err)
+		if attempt >= nodeRetryAttempts[class] {
+			return err
+		}
+
+		log.WithError(err).WithFields(logrus.Fields{
+			logfields.NodeName: nodeName,
+			"attempt":          attempt,
+		}).Debug("Retrying node operation")
+		if waitErr := backoff.Wait(ctx); waitErr != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package k8s
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func (s *K8sSuite) TestClassifyNodeError(c *C) {
+	nodes := v1.Resource("nodes")
+	c.Assert(ClassifyNodeError(k8serrors.NewServerTimeout(nodes, "get", 1)), Equals, NodeErrorRetryable)
+	c.Assert(ClassifyNodeError(k8serrors.NewTooManyRequests("throttled", 1)), Equals, NodeErrorRetryable)
+	c.Assert(ClassifyNodeError(k8serrors.NewInternalError(errors.New("internal"))), Equals, NodeErrorRetryable)
+	c.Assert(ClassifyNodeError(context.DeadlineExceeded), Equals, NodeErrorRetryable)
+	c.Assert(ClassifyNodeError(k8serrors.NewConflict(nodes, "node1", errors.New("conflict"))), Equals, NodeErrorConflict)
+	c.Assert(ClassifyNodeError(k8serrors.NewForbidden(nodes, "node1", errors.New("forbidden"))), Equals, NodeErrorForbidden)
+	c.Assert(ClassifyNodeError(k8serrors.NewNotFound(nodes, "node1")), Equals, NodeErrorNotFound)
+	c.Assert(ClassifyNodeError(errors.New("other")), Equals, NodeErrorOther)
+
+	// Node errors keep the API status of the wrapped error
+	err := newNodeError("get", "node1", k8serrors.NewNotFound(nodes, "node1"))
+	c.Assert(ClassifyNodeError(err), Equals, NodeErrorNotFound)
+	c.Assert(IsNodeNotFound(err), Equals, true)
+	c.Assert(k8serrors.IsNotFound(err), Equals, true)
+	c.Assert(newNodeError("patch", "node1", err), Equals, err)
+	c.Assert(newNodeError("get", "node1", nil), IsNil)
+	c.Assert(IsNodeNotFound(nil), Equals, false)
+}
+
+func (s *K8sSuite) TestRetryNodeOperation(c *C) {
+	oldBackoff := nodeRetryBackoff
+	nodeRetryBackoff.Min = time.Millisecond
+	nodeRetryBackoff.Max = time.Millisecond
+	defer func() { nodeRetryBackoff = oldBackoff }()
+
+	nodes := v1.Resource("nodes")
+
+	// Conflicts are retried until the operation succeeds
+	attempts := 0
+	err := retryNodeOperation(context.Background(), "test", "node1", func() error {
+		attempts++
+		if attempts < 3 {
+			return k8serrors.NewConflict(nodes, "node1", errors.New("conflict"))
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(attempts, Equals, 3)
+
+	// Retryable errors are retried until the policy is exhausted
+	attempts = 0
+	err = retryNodeOperation(context.Background(), "test", "node1", func() error {
+		attempts++
+		return k8serrors.NewServerTimeout(nodes, "get", 1)
+	})
+	c.Assert(ClassifyNodeError(err), Equals, NodeErrorRetryable)
+	c.Assert(attempts, Equals, nodeRetryAttempts[NodeErrorRetryable])
+
+	// Forbidden errors are not retried
+	attempts = 0
+	err = retryNodeOperation(context.Background(), "test", "node1", func() error {
+		attempts++
+		return k8serrors.NewForbidden(nodes, "node1", errors.New("forbidden"))
+	})
+	c.Assert(ClassifyNodeError(err), Equals, NodeErrorForbidden)
+	c.Assert(attempts, Equals, 1)
+	nodeErr, ok := err.(*NodeError)
+	c.Assert(ok, Equals, true)
+	c.Assert(nodeErr.Op, Equals, "test")
+	c.Assert(nodeErr.NodeName, Equals, "node1")
+
+	// A cancelled context stops the retries
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	err = retryNodeOperation(ctx, "test", "node1", func() error {
+		attempts++
+		return k8serrors.NewServerTimeout(nodes, "get", 1)
+	})
+	c.Assert(err, Not(IsNil))
+	c.Assert(attempts, Equals, 1)
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// NodeEventComponent is the source component of node events recorded
+	// by the agent
+	NodeEventComponent = "cilium-agent"
+
+	// NodeEventReasonCIDRConflict is the reason of events recorded for
+	// nodes with allocation CIDRs overlapping with another node
+	NodeEventReasonCIDRConflict = "CIDRConflict"
+)
+
+// NodeEventRecorder records Kubernetes events on the Node object of the
+// local node
+type NodeEventRecorder struct {
+	client kubernetes.Interface
+
+	mutex lock.Mutex
+
+	// cidrConflicts is the rejected state of each conflict recorded,
+	// indexed by the full name of the node conflicting with the local
+	// node
+	cidrConflicts map[string]bool
+}
+
+// NewNodeEventRecorder returns a NodeEventRecorder recording events with
+// client
+func NewNodeEventRecorder(client kubernetes.Interface) *NodeEventRecorder {
+	return &NodeEventRecorder{
+		client:        client,
+		cidrConflicts: map[string]bool{},
+	}
+}
+
+// newNodeEvent returns an event of type eventType on the Node object with
+// the name nodeName
+func newNodeEvent(nodeName, eventType, reason, message string) *v1.Event {
+	now := metav1.Now()
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: nodeName + ".",
+			Namespace:    metav1.NamespaceDefault,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			// Node events are identified by the name of the node,
+			// like the events recorded by the kubelet
+			UID: k8sTypes.UID(nodeName),
+		},
+		Reason:  reason,
+		Message: message,
+		Type:    eventType,
+		Source: v1.EventSource{
+			Component: NodeEventComponent,
+			Host:      node.GetName(),
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+}
+
+// record creates event in the background so that callers holding locks are
+// never blocked by the kube-apiserver
+func (r *NodeEventRecorder) record(event *v1.Event) {
+	go func() {
+		if _, err := r.client.CoreV1().Events(event.Namespace).Create(event); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				logfields.NodeName: event.InvolvedObject.Name,
+				"reason":           event.Reason,
+			}).Warning("Unable to record node event")
+		}
+	}()
+}
+
+// NodeCIDRConflict records a warning event on the local node if it is
+// involved in the conflict between n and other. Every agent learns about
+// every conflict, only recording conflicts of the local node avoids that
+// each conflict is recorded by all agents of the cluster. Each conflict is
+// recorded once per pair of nodes unless the rejected state changes.
+func (r *NodeEventRecorder) NodeCIDRConflict(n, other node.Node, rejected bool) {
+	action := "programmed anyway"
+	if rejected {
+		action = "rejected"
+	}
+
+	var peer, message string
+	switch {
+	case n.IsLocal():
+		peer = other.Fullname()
+		message = fmt.Sprintf("Allocation CIDRs overlap with node %s, node has been %s", peer, action)
+	case other.IsLocal():
+		peer = n.Fullname()
+		message = fmt.Sprintf("Allocation CIDRs overlap with node %s, which has been %s", peer, action)
+	default:
+		return
+	}
+
+	r.mutex.Lock()
+	prev, ok := r.cidrConflicts[peer]
+	r.cidrConflicts[peer] = rejected
+	r.mutex.Unlock()
+	if ok && prev == rejected {
+		return
+	}
+
+	r.record(newNodeEvent(node.GetName(), v1.EventTypeWarning, NodeEventReasonCIDRConflict, message))
+}
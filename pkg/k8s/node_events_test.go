@@ -0,0 +1,65 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package k8s
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/testutils"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func (s *K8sSuite) TestNodeCIDRConflictEvent(c *C) {
+	oldClusterName := option.Config.ClusterName
+	option.Config.ClusterName = "local"
+	defer func() { option.Config.ClusterName = oldClusterName }()
+	oldName := node.GetName()
+	node.SetName("n4")
+	defer node.SetName(oldName)
+
+	client := fake.NewSimpleClientset()
+	r := NewNodeEventRecorder(client)
+
+	listEvents := func() []v1.Event {
+		events, err := client.CoreV1().Events(metav1.NamespaceDefault).List(metav1.ListOptions{})
+		c.Assert(err, IsNil)
+		return events.Items
+	}
+
+	// Conflicts not involving the local node are not recorded
+	r.NodeCIDRConflict(node.Node{Name: "n1", Cluster: "remote"}, node.Node{Name: "n2", Cluster: "remote"}, true)
+	r.NodeCIDRConflict(node.Node{Name: "n1", Cluster: "local"}, node.Node{Name: "n2", Cluster: "local"}, true)
+
+	// The event is recorded on the local node, once per pair of nodes
+	r.NodeCIDRConflict(node.Node{Name: "n3", Cluster: "remote"}, node.Node{Name: "n4", Cluster: "local"}, true)
+	r.NodeCIDRConflict(node.Node{Name: "n3", Cluster: "remote"}, node.Node{Name: "n4", Cluster: "local"}, true)
+	c.Assert(testutils.WaitUntil(func() bool { return len(listEvents()) > 0 }, 5*time.Second), IsNil)
+	time.Sleep(50 * time.Millisecond)
+
+	events := listEvents()
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].InvolvedObject.Kind, Equals, "Node")
+	c.Assert(events[0].InvolvedObject.Name, Equals, "n4")
+	c.Assert(events[0].Type, Equals, v1.EventTypeWarning)
+	c.Assert(events[0].Reason, Equals, NodeEventReasonCIDRConflict)
+}
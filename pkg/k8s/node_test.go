@@ -17,17 +17,27 @@
 package k8s
 
 import (
+	"context"
+	"errors"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/cilium/cilium/pkg/annotation"
 	"github.com/cilium/cilium/pkg/checker"
 	"github.com/cilium/cilium/pkg/k8s/types"
 	"github.com/cilium/cilium/pkg/node"
 	nodeAddressing "github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/option"
 
 	. "gopkg.in/check.v1"
 	"k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
 )
 
 func (s *K8sSuite) TestParseNode(c *C) {
@@ -86,6 +96,152 @@ func (s *K8sSuite) TestParseNode(c *C) {
 	c.Assert(n.IPv6AllocCIDR.String(), Equals, "f00d:aaaa:bbbb:cccc:dddd:eeee::/112")
 }
 
+func (s *K8sSuite) TestParseNodeAddressFilters(c *C) {
+	oldTypes := option.Config.NodeAddressTypes
+	oldExcluded := option.Config.NodeAddressExcludedCIDRs
+	defer func() {
+		option.Config.NodeAddressTypes = oldTypes
+		option.Config.NodeAddressExcludedCIDRs = oldExcluded
+	}()
+
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+		},
+		StatusAddresses: []v1.NodeAddress{
+			{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+			{Type: v1.NodeExternalIP, Address: "198.51.100.2"},
+			{Type: v1.NodeInternalIP, Address: "192.168.1.2"},
+			{Type: v1.NodeHostName, Address: "node1"},
+		},
+	}
+
+	option.Config.NodeAddressTypes = []string{"ExternalIP", "InternalIP"}
+	_, excluded, _ := net.ParseCIDR("10.0.0.0/8")
+	option.Config.NodeAddressExcludedCIDRs = []*net.IPNet{excluded}
+
+	n := ParseNode(k8sNode, node.FromKubernetes)
+	c.Assert(n.IPAddresses, checker.DeepEquals, []node.Address{
+		{Type: nodeAddressing.NodeExternalIP, IP: net.ParseIP("198.51.100.2")},
+		{Type: nodeAddressing.NodeInternalIP, IP: net.ParseIP("192.168.1.2")},
+	})
+	c.Assert(n.GetNodeIP(false).String(), Equals, "198.51.100.2")
+
+	// Address types not configured are ignored
+	option.Config.NodeAddressTypes = []string{"InternalIP"}
+	n = ParseNode(k8sNode, node.FromKubernetes)
+	c.Assert(n.IPAddresses, checker.DeepEquals, []node.Address{
+		{Type: nodeAddressing.NodeInternalIP, IP: net.ParseIP("192.168.1.2")},
+	})
+}
+
+func (s *K8sSuite) TestParseNodeSanitize(c *C) {
+	// Annotations of the wrong address family are dropped
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				annotation.V6CIDRName:     "10.254.0.0/16",
+				annotation.V4HealthName:   "f00d::1",
+				annotation.CiliumHostIP:   "0.0.0.0",
+				annotation.CiliumHostIPv6: "f00d::2",
+			},
+		},
+		SpecPodCIDR: "10.1.0.0/16",
+	}
+
+	n := ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.1.0.0/16")
+	c.Assert(n.IPv6AllocCIDR, IsNil)
+	c.Assert(n.IPv4HealthIP, IsNil)
+	c.Assert(n.GetCiliumInternalIP(false), IsNil)
+	c.Assert(n.GetCiliumInternalIP(true).String(), Equals, "f00d::2")
+	c.Assert(n.Validate(), IsNil)
+}
+
+func (s *K8sSuite) TestParseNodeDualStack(c *C) {
+	// PodCIDRs takes precedence over PodCIDR and annotations
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				annotation.V4CIDRName: "10.254.0.0/16",
+				annotation.V6CIDRName: "f00d:aaaa:bbbb:cccc:dddd:eeee::/112",
+			},
+		},
+		SpecPodCIDR:  "10.1.0.0/16",
+		SpecPodCIDRs: []string{"10.1.0.0/16", "f00d:1111::/112"},
+	}
+
+	n := ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4AllocCIDR, NotNil)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.1.0.0/16")
+	c.Assert(n.IPv6AllocCIDR, NotNil)
+	c.Assert(n.IPv6AllocCIDR.String(), Equals, "f00d:1111::/112")
+
+	// Only the first CIDR of each family is used, invalid CIDRs are skipped
+	k8sNode = &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node2",
+		},
+		SpecPodCIDRs: []string{"invalid", "10.1.0.0/16", "10.2.0.0/16", "f00d:1111::/112", "f00d:2222::/112"},
+	}
+
+	n = ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4AllocCIDR, NotNil)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.1.0.0/16")
+	c.Assert(n.IPv6AllocCIDR, NotNil)
+	c.Assert(n.IPv6AllocCIDR.String(), Equals, "f00d:1111::/112")
+}
+
+func (s *K8sSuite) TestParseNodeTopologyLabels(c *C) {
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Labels: map[string]string{
+				"failure-domain.beta.kubernetes.io/zone":   "eu-central-1a",
+				"failure-domain.beta.kubernetes.io/region": "eu-central-1",
+			},
+		},
+	}
+
+	n := ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.Labels[node.TopologyZoneLabel], Equals, "eu-central-1a")
+	c.Assert(n.Labels[node.TopologyRegionLabel], Equals, "eu-central-1")
+	c.Assert(n.Zone(), Equals, "eu-central-1a")
+	c.Assert(n.Region(), Equals, "eu-central-1")
+
+	// The labels of the k8s node are not modified
+	_, ok := k8sNode.Labels[node.TopologyZoneLabel]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *K8sSuite) TestParseNodeSecondaryCIDRs(c *C) {
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+			Annotations: map[string]string{
+				annotation.SecondaryCIDRsName: "10.2.0.0/16, f00d:1111::/112,10.3.0.0/16",
+			},
+		},
+		SpecPodCIDR: "10.1.0.0/16",
+	}
+
+	n := ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.1.0.0/16")
+	c.Assert(len(n.IPv4SecondaryAllocCIDRs), Equals, 2)
+	c.Assert(n.IPv4SecondaryAllocCIDRs[0].String(), Equals, "10.2.0.0/16")
+	c.Assert(n.IPv4SecondaryAllocCIDRs[1].String(), Equals, "10.3.0.0/16")
+	c.Assert(len(n.IPv6SecondaryAllocCIDRs), Equals, 1)
+	c.Assert(n.IPv6SecondaryAllocCIDRs[0].String(), Equals, "f00d:1111::/112")
+
+	// An invalid CIDR invalidates the whole annotation
+	k8sNode.Annotations[annotation.SecondaryCIDRsName] = "10.2.0.0/16,invalid"
+	n = ParseNode(k8sNode, node.FromAgentLocal)
+	c.Assert(n.IPv4SecondaryAllocCIDRs, IsNil)
+	c.Assert(n.IPv6SecondaryAllocCIDRs, IsNil)
+}
+
 func Test_ParseNodeAddressType(t *testing.T) {
 	type args struct {
 		k8sNodeType v1.NodeAddressType
@@ -178,3 +334,132 @@ func Test_ParseNodeAddressType(t *testing.T) {
 		})
 	}
 }
+
+func (s *K8sSuite) TestIsNodeTerminating(c *C) {
+	k8sNode := &types.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node1",
+		},
+	}
+	c.Assert(IsNodeTerminating(k8sNode), Equals, false)
+
+	k8sNode.SpecTaints = []v1.Taint{
+		{Key: "dedicated", Value: "ingress", Effect: v1.TaintEffectNoSchedule},
+	}
+	c.Assert(IsNodeTerminating(k8sNode), Equals, false)
+
+	k8sNode.SpecTaints = append(k8sNode.SpecTaints, v1.Taint{
+		Key:    "node.cloudprovider.kubernetes.io/shutdown",
+		Effect: v1.TaintEffectNoSchedule,
+	})
+	c.Assert(IsNodeTerminating(k8sNode), Equals, true)
+}
+
+func (s *K8sSuite) TestNodeTaints(c *C) {
+	k8sNode := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{
+				{Key: "node.cilium.io/agent-not-ready", Effect: v1.TaintEffectNoSchedule},
+				{Key: "other", Effect: v1.TaintEffectNoExecute},
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(k8sNode)
+
+	err := RemoveNodeTaint(context.Background(), client, "node1", "node.cilium.io/agent-not-ready")
+	c.Assert(err, IsNil)
+	n, err := GetNode(context.Background(), client, "node1", GetNodeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(n.Spec.Taints, checker.DeepEquals, []v1.Taint{{Key: "other", Effect: v1.TaintEffectNoExecute}})
+
+	// Removing a taint which is not present is a no-op
+	err = RemoveNodeTaint(context.Background(), client, "node1", "node.cilium.io/agent-not-ready")
+	c.Assert(err, IsNil)
+
+	taint := v1.Taint{Key: "node.cilium.io/agent-not-ready", Effect: v1.TaintEffectNoSchedule}
+	err = AddNodeTaint(context.Background(), client, "node1", taint)
+	c.Assert(err, IsNil)
+	// Adding the same taint again does not duplicate it
+	err = AddNodeTaint(context.Background(), client, "node1", taint)
+	c.Assert(err, IsNil)
+	n, err = GetNode(context.Background(), client, "node1", GetNodeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(n.Spec.Taints, checker.DeepEquals, []v1.Taint{{Key: "other", Effect: v1.TaintEffectNoExecute}, taint})
+
+	err = RemoveNodeTaint(context.Background(), client, "unknown", "node.cilium.io/agent-not-ready")
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *K8sSuite) TestSetNodeNetworkUnavailableFalse(c *C) {
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+	})
+
+	// The first patch fails with a conflict and is retried
+	conflicts := 0
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, k8serrors.NewConflict(v1.Resource("nodes"), "node1", errors.New("conflict"))
+		}
+		return false, nil, nil
+	})
+
+	err := SetNodeNetworkUnavailableFalse(context.Background(), client, "node1")
+	c.Assert(err, IsNil)
+	c.Assert(conflicts, Equals, 1)
+
+	n, err := GetNode(context.Background(), client, "node1", GetNodeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(len(n.Status.Conditions), Equals, 1)
+	c.Assert(n.Status.Conditions[0].Type, Equals, v1.NodeNetworkUnavailable)
+	c.Assert(n.Status.Conditions[0].Status, Equals, v1.ConditionFalse)
+
+	// The condition is already set, the node is not patched again
+	client.ClearActions()
+	err = SetNodeNetworkUnavailableFalse(context.Background(), client, "node1")
+	c.Assert(err, IsNil)
+	for _, action := range client.Actions() {
+		c.Assert(action.GetVerb(), Not(Equals), "patch")
+	}
+
+	// Non-transient errors are not retried
+	err = SetNodeNetworkUnavailableFalse(context.Background(), client, "unknown")
+	c.Assert(k8serrors.IsNotFound(err), Equals, true)
+}
+
+func (s *K8sSuite) TestGetNode(c *C) {
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+	})
+
+	n, err := GetNode(context.Background(), client, "node1", GetNodeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(n.Name, Equals, "node1")
+
+	// Nodes in the store are returned without querying the apiserver
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	err = store.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "cached"}})
+	c.Assert(err, IsNil)
+	client.ClearActions()
+	n, err = GetNode(context.Background(), client, "cached", GetNodeOptions{Store: store})
+	c.Assert(err, IsNil)
+	c.Assert(n.Name, Equals, "cached")
+	c.Assert(len(client.Actions()), Equals, 0)
+
+	// Nodes missing in the store are retrieved from the apiserver
+	n, err = GetNode(context.Background(), client, "node1", GetNodeOptions{Store: store})
+	c.Assert(err, IsNil)
+	c.Assert(n.Name, Equals, "node1")
+
+	// A slow apiserver results in a timeout
+	block := make(chan struct{})
+	defer close(block)
+	client.PrependReactor("get", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		<-block
+		return false, nil, nil
+	})
+	_, err = GetNode(context.Background(), client, "node1", GetNodeOptions{Timeout: 10 * time.Millisecond})
+	c.Assert(err, Not(IsNil))
+}
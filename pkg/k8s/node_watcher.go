@@ -0,0 +1,197 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cilium/cilium/pkg/k8s/informer"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// MinNodeResyncPeriod is the minimum resync period of a NodeWatcher
+	MinNodeResyncPeriod = time.Minute
+
+	// MaxNodeResyncPeriod is the maximum resync period of a NodeWatcher
+	MaxNodeResyncPeriod = 24 * time.Hour
+)
+
+// NodeEventHandler is notified about the Kubernetes nodes observed by a
+// NodeWatcher
+type NodeEventHandler interface {
+	// NodeUpdated is called when a node has been added or has changed
+	NodeUpdated(n node.Node)
+
+	// NodeDeleted is called when a node has been deleted
+	NodeDeleted(n node.Node)
+}
+
+// NodeWatcher watches Kubernetes nodes, converts them with ParseNode and
+// passes them to a NodeEventHandler. Updates which do not change the parsed
+// node, including the updates caused by periodic resyncs, are not passed to
+// the handler.
+type NodeWatcher struct {
+	handler    NodeEventHandler
+	store      cache.Store
+	controller cache.Controller
+
+	// mutex protects delivered
+	mutex lock.Mutex
+
+	// delivered maps node names to the hash of the node last passed to
+	// the handler
+	delivered map[string]string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// boundedNodeResyncPeriod returns period bounded to [MinNodeResyncPeriod,
+// MaxNodeResyncPeriod]. A period of zero disables resyncs and is returned
+// as is.
+func boundedNodeResyncPeriod(period time.Duration) time.Duration {
+	switch {
+	case period <= 0:
+		return 0
+	case period < MinNodeResyncPeriod:
+		return MinNodeResyncPeriod
+	case period > MaxNodeResyncPeriod:
+		return MaxNodeResyncPeriod
+	default:
+		return period
+	}
+}
+
+// NewNodeWatcher returns a watcher of the Kubernetes nodes of client c
+// notifying handler. All nodes are resynced every resyncPeriod, bounded to
+// [MinNodeResyncPeriod, MaxNodeResyncPeriod]. A resyncPeriod of zero disables
+// resyncs. The watcher must be started with Start().
+func NewNodeWatcher(c kubernetes.Interface, handler NodeEventHandler, resyncPeriod time.Duration) *NodeWatcher {
+	w := &NodeWatcher{
+		handler:   handler,
+		delivered: map[string]string{},
+		stop:      make(chan struct{}),
+	}
+
+	w.store, w.controller = informer.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return c.CoreV1().Nodes().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return c.CoreV1().Nodes().Watch(options)
+			},
+		},
+		&v1.Node{},
+		boundedNodeResyncPeriod(resyncPeriod),
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.update(obj)
+			},
+			UpdateFunc: func(_, newObj interface{}) {
+				w.update(newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				if deletedObj, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					// Delete was not observed by the watcher but is
+					// removed from kube-apiserver. This is the last
+					// known state and the object no longer exists.
+					obj = deletedObj.Obj
+				}
+				w.delete(obj)
+			},
+		},
+		ConvertToNode,
+	)
+
+	return w
+}
+
+func (w *NodeWatcher) update(obj interface{}) {
+	k8sNode := CopyObjToV1Node(obj)
+	if k8sNode == nil {
+		return
+	}
+
+	n := ParseNode(k8sNode, node.FromKubernetes)
+	hash := n.GetHash()
+
+	w.mutex.Lock()
+	if w.delivered[n.Name] == hash {
+		w.mutex.Unlock()
+		log.WithField(logfields.NodeName, n.Name).Debug("Ignoring update of unchanged Kubernetes node")
+		return
+	}
+	w.delivered[n.Name] = hash
+	w.mutex.Unlock()
+
+	w.handler.NodeUpdated(*n)
+}
+
+func (w *NodeWatcher) delete(obj interface{}) {
+	k8sNode := CopyObjToV1Node(obj)
+	if k8sNode == nil {
+		return
+	}
+
+	n := ParseNode(k8sNode, node.FromKubernetes)
+
+	w.mutex.Lock()
+	delete(w.delivered, n.Name)
+	w.mutex.Unlock()
+
+	w.handler.NodeDeleted(*n)
+}
+
+// Start starts watching Kubernetes nodes in the background
+func (w *NodeWatcher) Start() {
+	go w.controller.Run(w.stop)
+}
+
+// Stop stops watching Kubernetes nodes
+func (w *NodeWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+// HasSynced returns true once the initial list of nodes has been passed to
+// the handler
+func (w *NodeWatcher) HasSynced() bool {
+	return w.controller.HasSynced()
+}
+
+// WaitForCacheSync waits until the initial list of nodes has been passed to
+// the handler or stop is closed. Returns true if the nodes have been synced.
+func (w *NodeWatcher) WaitForCacheSync(stop <-chan struct{}) bool {
+	return cache.WaitForCacheSync(stop, w.controller.HasSynced)
+}
+
+// Store returns the local cache of the watcher. It contains *types.Node
+// objects keyed by node name.
+func (w *NodeWatcher) Store() cache.Store {
+	return w.store
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package k8s
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/testutils"
+
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type fakeNodeEventHandler struct {
+	mutex   lock.Mutex
+	updated []node.Node
+	deleted []node.Node
+}
+
+func (h *fakeNodeEventHandler) NodeUpdated(n node.Node) {
+	h.mutex.Lock()
+	h.updated = append(h.updated, n)
+	h.mutex.Unlock()
+}
+
+func (h *fakeNodeEventHandler) NodeDeleted(n node.Node) {
+	h.mutex.Lock()
+	h.deleted = append(h.deleted, n)
+	h.mutex.Unlock()
+}
+
+func (h *fakeNodeEventHandler) counts() (int, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.updated), len(h.deleted)
+}
+
+func (s *K8sSuite) TestBoundedNodeResyncPeriod(c *C) {
+	c.Assert(boundedNodeResyncPeriod(0), Equals, time.Duration(0))
+	c.Assert(boundedNodeResyncPeriod(time.Second), Equals, MinNodeResyncPeriod)
+	c.Assert(boundedNodeResyncPeriod(time.Hour), Equals, time.Hour)
+	c.Assert(boundedNodeResyncPeriod(48*time.Hour), Equals, MaxNodeResyncPeriod)
+}
+
+func (s *K8sSuite) TestNodeWatcher(c *C) {
+	client := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node1",
+			Labels: map[string]string{"foo": "bar"},
+		},
+	})
+	// ConvertToNode clears the objects it converts, pass copies of the
+	// objects of the tracker to the watcher
+	nodes := v1.SchemeGroupVersion.WithResource("nodes")
+	client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := client.Tracker().List(nodes, v1.SchemeGroupVersion.WithKind("Node"), "")
+		if err != nil {
+			return true, nil, err
+		}
+		return true, obj.DeepCopyObject(), nil
+	})
+	client.PrependWatchReactor("nodes", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		w, err := client.Tracker().Watch(nodes, "")
+		if err != nil {
+			return true, nil, err
+		}
+		return true, watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+			e.Object = e.Object.DeepCopyObject()
+			return e, true
+		}), nil
+	})
+	handler := &fakeNodeEventHandler{}
+
+	w := NewNodeWatcher(client, handler, 0)
+	w.Start()
+	defer w.Stop()
+
+	stop := make(chan struct{})
+	time.AfterFunc(5*time.Second, func() { close(stop) })
+	c.Assert(w.WaitForCacheSync(stop), Equals, true)
+	c.Assert(w.HasSynced(), Equals, true)
+
+	updated, _ := handler.counts()
+	c.Assert(updated, Equals, 1)
+	handler.mutex.Lock()
+	c.Assert(handler.updated[0].Name, Equals, "node1")
+	c.Assert(handler.updated[0].Labels["foo"], Equals, "bar")
+	handler.mutex.Unlock()
+	_, exists, err := w.Store().GetByKey("node1")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// A change not affecting the parsed node is not passed to the handler
+	k8sNode, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	k8sNode.Spec.Unschedulable = true
+	_, err = client.CoreV1().Nodes().Update(k8sNode)
+	c.Assert(err, IsNil)
+
+	// A change of the labels is passed to the handler
+	k8sNode.Labels["foo"] = "baz"
+	_, err = client.CoreV1().Nodes().Update(k8sNode)
+	c.Assert(err, IsNil)
+	c.Assert(testutils.WaitUntil(func() bool {
+		updated, _ := handler.counts()
+		return updated == 2
+	}, 5*time.Second), IsNil)
+
+	err = client.CoreV1().Nodes().Delete("node1", &metav1.DeleteOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(testutils.WaitUntil(func() bool {
+		_, deleted := handler.counts()
+		return deleted == 1
+	}, 5*time.Second), IsNil)
+
+	updated, _ = handler.counts()
+	c.Assert(updated, Equals, 2)
+	handler.mutex.Lock()
+	c.Assert(handler.updated[1].Labels["foo"], Equals, "baz")
+	c.Assert(handler.deleted[0].Name, Equals, "node1")
+	handler.mutex.Unlock()
+}
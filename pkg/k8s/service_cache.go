@@ -119,6 +119,14 @@ func (s *ServiceCache) GetRandomBackendIP(svcID ServiceID) *loadbalancer.L3n4Add
 	return nil
 }
 
+// GetBackendAddrs returns the addresses, in the form "<ip>:<port>", of the
+// local backends of the given service, see Endpoints.BackendAddrs
+func (s *ServiceCache) GetBackendAddrs(svcID ServiceID, portName string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.endpoints[svcID].BackendAddrs(portName)
+}
+
 // UpdateService parses a Kubernetes service and adds or updates it in the
 // ServiceCache. Returns the ServiceID unless the Kubernetes service could not
 // be parsed and a bool to indicate whether the service was changed in the
@@ -300,6 +300,35 @@ func (s *K8sSuite) TestServiceCache(c *check.C) {
 	}, 2*time.Second), check.IsNil)
 }
 
+func (s *K8sSuite) TestGetBackendAddrs(c *check.C) {
+	svcCache := NewServiceCache()
+	svcID := ServiceID{Name: "etcd", Namespace: "kube-system"}
+	c.Assert(svcCache.GetBackendAddrs(svcID, "client"), check.HasLen, 0)
+
+	svcCache.UpdateEndpoints(&types.Endpoints{
+		Endpoints: &v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "etcd",
+				Namespace: "kube-system",
+			},
+			Subsets: []v1.EndpointSubset{
+				{
+					Addresses: []v1.EndpointAddress{{IP: "2.2.2.2"}, {IP: "1.1.1.1"}},
+					Ports: []v1.EndpointPort{
+						{Name: "client", Port: 2379, Protocol: v1.ProtocolTCP},
+						{Name: "peer", Port: 2380, Protocol: v1.ProtocolTCP},
+					},
+				},
+			},
+		},
+	})
+
+	c.Assert(svcCache.GetBackendAddrs(svcID, "client"), checker.DeepEquals, []string{"1.1.1.1:2379", "2.2.2.2:2379"})
+
+	// The port is ambiguous
+	c.Assert(svcCache.GetBackendAddrs(svcID, "metrics"), check.HasLen, 0)
+}
+
 func (s *K8sSuite) TestCacheActionString(c *check.C) {
 	c.Assert(UpdateService.String(), check.Equals, "service-updated")
 	c.Assert(DeleteService.String(), check.Equals, "service-deleted")
@@ -64,6 +64,11 @@ type Node struct {
 	Type            v1.NodeAddressType
 	StatusAddresses []v1.NodeAddress
 	SpecPodCIDR     string
+	// SpecPodCIDRs are the pod CIDRs of a dual-stack node, at most one per
+	// address family. If set, SpecPodCIDR equals the first entry. It is
+	// only populated by clients exposing Spec.PodCIDRs (Kubernetes >= 1.16).
+	SpecPodCIDRs []string
+	SpecTaints   []v1.Taint
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
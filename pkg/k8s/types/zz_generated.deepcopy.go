@@ -149,6 +149,18 @@ func (in *Node) DeepCopyInto(out *Node) {
 		*out = make([]v1.NodeAddress, len(*in))
 		copy(*out, *in)
 	}
+	if in.SpecPodCIDRs != nil {
+		in, out := &in.SpecPodCIDRs, &out.SpecPodCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SpecTaints != nil {
+		in, out := &in.SpecTaints, &out.SpecTaints
+		*out = make([]v1.Taint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -18,9 +18,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cilium/cilium/pkg/backoff"
@@ -29,6 +32,7 @@ import (
 	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/option"
 	"github.com/cilium/cilium/pkg/uuid"
 
@@ -37,6 +41,10 @@ import (
 
 var (
 	log = logging.DefaultLogger.WithField(logfields.LogSubsys, "allocator")
+
+	// ErrShuttingDown is returned by Allocate() and Release() after
+	// Shutdown() has been called
+	ErrShuttingDown = errors.New("allocator is shutting down")
 )
 
 const (
@@ -47,6 +55,11 @@ const (
 	// listTimeout is the time to wait for the initial list operation to
 	// succeed when creating a new allocator
 	listTimeout = 3 * time.Minute
+
+	// maxDeterministicProbes is the number of IDs probed sequentially
+	// starting at the ID derived from the key hash before falling back to
+	// a random ID when deterministic ID selection is enabled
+	maxDeterministicProbes = 1024
 )
 
 // Allocator is a distributed ID allocator backed by a KVstore. It maps
@@ -138,6 +151,11 @@ type Allocator struct {
 	// agent.
 	slaveKeysMutex lock.Mutex
 
+	// pendingSlaveKeys are the slave keys of released keys which could
+	// not be deleted from the kvstore. Their deletion is retried by the
+	// local key sync and on Shutdown(). Protected by slaveKeysMutex.
+	pendingSlaveKeys map[string]struct{}
+
 	// lockPrefix is the prefix to use for all kvstore locks. This prefix
 	// is different from the idPrefix and valuePrefix to simplify watching
 	// for ID and key changes.
@@ -170,6 +188,10 @@ type Allocator struct {
 	// backoffTemplate is the backoff configuration while allocating
 	backoffTemplate backoff.Exponential
 
+	// bulkLane if set, limits the number of PriorityNormal allocations
+	// performed in parallel
+	bulkLane chan struct{}
+
 	// mainCache is the main cache, representing the allocator contents of
 	// the primary kvstore connection
 	mainCache cache
@@ -197,6 +219,68 @@ type Allocator struct {
 
 	// disableGC disables the garbage collector
 	disableGC bool
+
+	// deterministicIDs if true, causes the ID candidate for a new key to
+	// be derived from the hash of the key
+	deterministicIDs bool
+
+	// syncInterval is the interval in which locally used keys are
+	// synchronized with the kvstore. If 0, option.Config.KVstorePeriodicSync
+	// is used.
+	syncInterval time.Duration
+
+	// mirror if set, maintains a read-only copy of the ID space in the
+	// kvstore
+	mirror *mirror
+
+	// shutdownMutex protects shuttingDown and the addition of in-flight
+	// operations
+	shutdownMutex lock.RWMutex
+
+	// shuttingDown is true once Shutdown() has been called
+	shuttingDown bool
+
+	// inflight tracks Allocate() and Release() calls in progress
+	inflight sync.WaitGroup
+
+	// tracer if set, is notified about the lifecycle of allocations
+	tracer Tracer
+
+	// spanTracer if set, emits spans for the housekeeping routines
+	spanTracer SpanTracer
+
+	// quarantine if set, holds released IDs before they are returned to
+	// idPool
+	quarantine *quarantine
+
+	// retryBudget if set, limits the number of allocation retries across
+	// all concurrent allocations
+	retryBudget *retryBudget
+
+	// tenant is the tenant prefix injected into all kvstore keys of the
+	// allocator
+	tenant string
+
+	// compression is true if large values are compressed before being
+	// written to the kvstore
+	compression bool
+
+	// freezeKey is the kvstore key of the cluster-wide freeze flag. It is
+	// derived from the basePrefix.
+	freezeKey string
+
+	// freeze is the state of the cluster-wide freeze flag
+	freeze *freezeState
+
+	// usage tracks the time IDs were last used
+	usage *usageTracker
+
+	// freezeWatcher watches freezeKey
+	freezeWatcher *kvstore.Watcher
+
+	// operationTimeout is the timeout of each kvstore operation if the
+	// context of the caller has no deadline. If 0, no timeout is applied.
+	operationTimeout time.Duration
 }
 
 func locklessCapability() bool {
@@ -209,10 +293,15 @@ type AllocatorOption func(*Allocator)
 
 // NewAllocatorForGC returns an allocator  that can be used to run RunGC()
 func NewAllocatorForGC(basePath string) *Allocator {
+	basePath = kvstore.TenantPath(basePath)
 	return &Allocator{
 		idPrefix:    path.Join(basePath, "id"),
 		valuePrefix: path.Join(basePath, "value"),
 		lockPrefix:  path.Join(basePath, "locks"),
+		freezeKey:   path.Join(basePath, "freeze"),
+		usage:       newUsageTracker(time.Now()),
+
+		operationTimeout: defaultOperationTimeout,
 	}
 }
 
@@ -227,37 +316,58 @@ func NewAllocatorForGC(basePath string) *Allocator {
 //  - WithSuffix(string) - customize the node specifix suffix to attach to keys
 //  - WithMin(id) - minimum ID to allocate (default: 1)
 //  - WithMax(id) - maximum ID to allocate (default max(uint64))
+//  - WithDeterministicIDs() - derive ID candidates from the key hash
+//  - WithSyncInterval(interval) - interval of the local key sync
+//  - WithMirror(prefix, chunkSize) - maintain a read-only mirror of the ID space
+//  - WithTracer(tracer) - trace the lifecycle of allocations
+//  - WithSpanTracer(tracer) - emit spans for housekeeping routines
+//  - WithBulkAllocationLimit(limit) - limit parallel bulk allocations
+//  - WithQuarantine(period) - delay the reuse of released IDs
+//  - WithRetryBudget(retries) - limit retries shared by all allocations (default unlimited)
+//  - WithTenant(tenant) - inject a tenant prefix into all keys
+//  - WithCompression() - compress large values written to the kvstore
+//  - WithDefaultOperationTimeout(timeout) - timeout of kvstore operations
 //
-// After creation, IDs can be allocated with Allocate() and released with
-// Release()
+// After creation, IDs can be allocated with Allocate() or
+// AllocateWithPriority() and released with Release()
 func NewAllocator(basePath string, typ AllocatorKey, opts ...AllocatorOption) (*Allocator, error) {
 	if kvstore.Client() == nil {
 		return nil, fmt.Errorf("kvstore client not configured")
 	}
 
 	a := &Allocator{
-		keyType:      typ,
-		basePrefix:   basePath,
-		idPrefix:     path.Join(basePath, "id"),
-		valuePrefix:  path.Join(basePath, "value"),
-		lockPrefix:   path.Join(basePath, "locks"),
-		min:          idpool.ID(1),
-		max:          idpool.ID(^uint64(0)),
-		localKeys:    newLocalKeys(),
-		stopGC:       make(chan struct{}),
-		suffix:       uuid.NewUUID().String()[:10],
-		lockless:     locklessCapability(),
-		remoteCaches: map[*RemoteCache]struct{}{},
+		keyType:          typ,
+		tenant:           option.Config.KVStoreTenant,
+		min:              idpool.ID(1),
+		max:              idpool.ID(^uint64(0)),
+		localKeys:        newLocalKeys(),
+		stopGC:           make(chan struct{}),
+		pendingSlaveKeys: map[string]struct{}{},
+		suffix:           uuid.NewUUID().String()[:10],
+		lockless:         locklessCapability(),
+		remoteCaches:     map[*RemoteCache]struct{}{},
 		backoffTemplate: backoff.Exponential{
 			Min:    time.Duration(20) * time.Millisecond,
 			Factor: 2.0,
 		},
+		usage:            newUsageTracker(time.Now()),
+		operationTimeout: defaultOperationTimeout,
 	}
 
 	for _, fn := range opts {
 		fn(a)
 	}
 
+	a.basePrefix = kvstore.TenantPrefix(a.tenant, basePath)
+	a.idPrefix = path.Join(a.basePrefix, "id")
+	a.valuePrefix = path.Join(a.basePrefix, "value")
+	a.lockPrefix = path.Join(a.basePrefix, "locks")
+	a.freezeKey = path.Join(a.basePrefix, "freeze")
+	a.freeze = newFreezeState()
+	if a.mirror != nil {
+		a.mirror.prefix = kvstore.TenantPrefix(a.tenant, a.mirror.prefix)
+	}
+
 	a.mainCache = newCache(kvstore.Client(), a.idPrefix)
 
 	// invalid prefixes are only deleted from the main cache
@@ -276,8 +386,12 @@ func NewAllocator(basePath string, typ AllocatorKey, opts ...AllocatorOption) (*
 	}
 
 	a.idPool = idpool.NewIDPool(a.min, a.max)
+	if a.quarantine != nil {
+		a.startQuarantineExpiration()
+	}
 
 	a.initialListDone = a.mainCache.start(a)
+	a.startFreezeWatcher()
 	if !a.disableGC {
 		go func() {
 			select {
@@ -331,6 +445,28 @@ func WithMasterKeyProtection() AllocatorOption {
 	return func(a *Allocator) { a.enableMasterKeyProtection = true }
 }
 
+// WithDeterministicIDs derives the initial ID candidate of a new key from a
+// hash of the key. If the candidate is in use, subsequent IDs are probed
+// sequentially. This makes ID assignment mostly stable across reinstalls of
+// a cluster, e.g. to correlate IDs between environments.
+func WithDeterministicIDs() AllocatorOption {
+	return func(a *Allocator) { a.deterministicIDs = true }
+}
+
+// WithSyncInterval sets the interval in which locally used keys are
+// synchronized with the kvstore. Each run is jittered to avoid many nodes
+// synchronizing at the same time.
+func WithSyncInterval(interval time.Duration) AllocatorOption {
+	return func(a *Allocator) { a.syncInterval = interval }
+}
+
+// WithTenant injects the prefix of tenant into all kvstore keys of the
+// allocator, overriding the tenant configured via option.Config.KVStoreTenant.
+// See kvstore.TenantPrefix().
+func WithTenant(tenant string) AllocatorOption {
+	return func(a *Allocator) { a.tenant = tenant }
+}
+
 // WithoutGC disables the use of the garbage collector
 func WithoutGC() AllocatorOption {
 	return func(a *Allocator) { a.disableGC = true }
@@ -340,12 +476,99 @@ func WithoutGC() AllocatorOption {
 func (a *Allocator) Delete() {
 	close(a.stopGC)
 	a.mainCache.stop()
+	if a.freezeWatcher != nil {
+		a.freezeWatcher.Stop()
+	}
 
 	if a.events != nil {
 		close(a.events)
 	}
 }
 
+// Shutdown gracefully stops the allocator. New calls to Allocate() and
+// Release() are rejected with ErrShuttingDown, calls in progress are given
+// until ctx expires to complete. Afterwards, the garbage collector and the
+// watchers are stopped as with Delete(). Unlike Delete(), this avoids
+// leaving behind master keys without slave keys when an allocation is
+// interrupted halfway.
+//
+// An error is returned if ctx expired before all in-flight operations have
+// completed. The allocator is stopped regardless.
+func (a *Allocator) Shutdown(ctx context.Context) error {
+	a.shutdownMutex.Lock()
+	if a.shuttingDown {
+		a.shutdownMutex.Unlock()
+		return ErrShuttingDown
+	}
+	a.shuttingDown = true
+	a.shutdownMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		a.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = fmt.Errorf("in-flight allocator operations did not complete: %s", ctx.Err())
+	}
+
+	// Retry the deletion of slave keys which failed on release so that
+	// other nodes do not have to wait for their lease to expire
+	if flushErr := a.deletePendingSlaveKeys(ctx); flushErr != nil && err == nil {
+		err = flushErr
+	}
+
+	a.Delete()
+
+	return err
+}
+
+// deletePendingSlaveKeys retries the deletion of all slave keys of released
+// keys which could not be deleted on release. An error is returned if ctx
+// expired before all of them have been deleted.
+func (a *Allocator) deletePendingSlaveKeys(ctx context.Context) error {
+	a.slaveKeysMutex.Lock()
+	defer a.slaveKeysMutex.Unlock()
+
+	for valueKey := range a.pendingSlaveKeys {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%d slave keys left to delete: %s", len(a.pendingSlaveKeys), ctx.Err())
+		}
+
+		if err := kvstore.Delete(valueKey); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{fieldKey: valueKey}).Warning("Unable to delete slave key")
+			continue
+		}
+		delete(a.pendingSlaveKeys, valueKey)
+	}
+
+	return nil
+}
+
+// beginOperation registers an in-flight operation. Returns false if the
+// allocator is shutting down, in which case the operation must not proceed.
+// Otherwise, endOperation() must be called when the operation completes.
+func (a *Allocator) beginOperation() bool {
+	a.shutdownMutex.RLock()
+	defer a.shutdownMutex.RUnlock()
+
+	if a.shuttingDown {
+		return false
+	}
+	a.inflight.Add(1)
+	return true
+}
+
+// endOperation marks an operation registered with beginOperation() as
+// completed
+func (a *Allocator) endOperation() {
+	a.inflight.Done()
+}
+
 // WaitForInitialSync waits until the initial sync is complete
 func (a *Allocator) WaitForInitialSync(ctx context.Context) error {
 	select {
@@ -359,6 +582,9 @@ func (a *Allocator) WaitForInitialSync(ctx context.Context) error {
 
 // lockPath locks a key in the scope of an allocator
 func (a *Allocator) lockPath(ctx context.Context, key string) (*kvstore.Lock, error) {
+	ctx, cancel := a.operationContext(ctx)
+	defer cancel()
+
 	suffix := strings.TrimPrefix(key, a.basePrefix)
 	return kvstore.LockPath(ctx, path.Join(a.lockPrefix, suffix))
 }
@@ -368,6 +594,21 @@ func (a *Allocator) DeleteAllKeys() {
 	kvstore.DeletePrefix(a.basePrefix)
 }
 
+// PoolStats returns statistics about the utilization of the ID space of the
+// allocator
+func (a *Allocator) PoolStats() idpool.Stats {
+	return a.idPool.Stats()
+}
+
+// updatePoolMetrics reports the utilization of the ID space to the metrics
+func (a *Allocator) updatePoolMetrics() {
+	stats := a.PoolStats()
+	metrics.KVStoreAllocatorPoolIDs.WithLabelValues(a.idPrefix, "available").Set(float64(stats.Available))
+	metrics.KVStoreAllocatorPoolIDs.WithLabelValues(a.idPrefix, "leased").Set(float64(stats.Leased))
+	metrics.KVStoreAllocatorPoolIDs.WithLabelValues(a.idPrefix, "in_use").Set(float64(stats.InUse))
+	metrics.KVStoreAllocatorPoolLargestFreeRange.WithLabelValues(a.idPrefix).Set(float64(stats.LargestFreeRange))
+}
+
 // RangeFunc is the function called by RangeCache
 type RangeFunc func(idpool.ID, AllocatorKey)
 
@@ -383,10 +624,42 @@ func (a *Allocator) ForeachCache(cb RangeFunc) {
 	a.remoteCachesMutex.RUnlock()
 }
 
-// Selects an available ID.
+// leaseDeterministicID leases the ID derived from the hash of the key or, if
+// that ID is not available, one of the subsequent IDs. Returns NoID if none
+// of the probed IDs is available.
+func (a *Allocator) leaseDeterministicID(key string) idpool.ID {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	size := uint64(a.max-a.min) + 1
+	if size == 0 {
+		// min..max covers the entire uint64 space
+		size = ^uint64(0)
+	}
+	offset := h.Sum64() % size
+
+	for probe := uint64(0); probe < maxDeterministicProbes && probe < size; probe++ {
+		id := a.min + idpool.ID((offset+probe)%size)
+		if a.idPool.LeaseID(id) {
+			return id
+		}
+	}
+
+	return idpool.NoID
+}
+
+// Selects an available ID for the given key.
 // Returns a triple of the selected ID ORed with prefixMask,
 // the ID string and the originally selected ID.
-func (a *Allocator) selectAvailableID() (idpool.ID, string, idpool.ID) {
+func (a *Allocator) selectAvailableID(key string) (idpool.ID, string, idpool.ID) {
+	if a.deterministicIDs {
+		if id := a.leaseDeterministicID(key); id != idpool.NoID {
+			unmaskedID := id
+			id |= a.prefixMask
+			return id, id.String(), unmaskedID
+		}
+	}
+
 	if id := a.idPool.LeaseAvailableID(); id != idpool.NoID {
 		unmaskedID := id
 		id |= a.prefixMask
@@ -400,10 +673,16 @@ func (a *Allocator) createValueNodeKey(ctx context.Context, key string, newID id
 	// add a new key /value/<key>/<node> to account for the reference
 	// The key is protected with a TTL/lease and will expire after LeaseTTL
 	valueKey := path.Join(a.valuePrefix, key, a.suffix)
-	if _, err := kvstore.UpdateIfDifferentIfLocked(ctx, valueKey, []byte(newID.String()), true, lock); err != nil {
+	ctx, cancel := a.operationContext(ctx)
+	defer cancel()
+	if _, err := kvstore.UpdateIfDifferentIfLocked(ctx, valueKey, a.encodeValue(newID.String()), true, lock); err != nil {
 		return fmt.Errorf("unable to create value-node key '%s': %s", valueKey, err)
 	}
 
+	// The key is in use again, a failed deletion on an earlier release
+	// must no longer be retried. The caller holds a.slaveKeysMutex.
+	delete(a.pendingSlaveKeys, valueKey)
+
 	// mark the key as verified in the local cache
 	if err := a.localKeys.verify(key); err != nil {
 		log.WithError(err).Error("BUG: Unable to verify local key")
@@ -430,7 +709,9 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 	kvstore.Trace("Allocating key in kvstore", nil, logrus.Fields{fieldKey: key})
 
 	k := key.GetKey()
+	start := time.Now()
 	lock, err := a.lockPath(ctx, k)
+	a.traceKVStoreOp(key, TraceOpLock, start, err)
 	if err != nil {
 		return 0, false, err
 	}
@@ -438,8 +719,12 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 	defer lock.Unlock()
 
 	// fetch first key that matches /value/<key> while ignoring the
-	// node suffix
-	value, err := a.GetIfLocked(ctx, key, lock)
+	// node suffix. The cache is bypassed as it may still contain an ID
+	// whose master key has been deleted by the garbage collector, reusing
+	// it would leave the key with two IDs in the cluster.
+	start = time.Now()
+	value, err := a.GetNoCacheIfLocked(ctx, key, lock)
+	a.traceKVStoreOp(key, TraceOpGet, start, err)
 	if err != nil {
 		return 0, false, err
 	}
@@ -457,7 +742,11 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 		if value != 0 {
 			// re-create master key
 			keyPath := path.Join(a.idPrefix, strconv.FormatUint(uint64(value), 10))
-			success, err := kvstore.CreateOnlyIfLocked(ctx, keyPath, []byte(k), false, lock)
+			start = time.Now()
+			opCtx, cancel := a.operationContext(ctx)
+			success, err := kvstore.CreateOnlyIfLocked(opCtx, keyPath, a.encodeValue(k), false, lock)
+			cancel()
+			a.traceKVStoreOp(key, TraceOpCreateMasterKey, start, err)
 			if err != nil || !success {
 				return 0, false, fmt.Errorf("unable to create master key '%s': %s", keyPath, err)
 			}
@@ -469,7 +758,10 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 		}
 	}
 	if value != 0 {
-		if err = a.createValueNodeKey(ctx, k, value, lock); err != nil {
+		start = time.Now()
+		err = a.createValueNodeKey(ctx, k, value, lock)
+		a.traceKVStoreOp(key, TraceOpCreateSlaveKey, start, err)
+		if err != nil {
 			a.localKeys.release(k)
 			return 0, false, fmt.Errorf("unable to create slave key '%s': %s", k, err)
 		}
@@ -479,7 +771,13 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 		return value, false, nil
 	}
 
-	id, strID, unmaskedID := a.selectAvailableID()
+	// Only the creation of new master keys is refused while frozen, keys
+	// which already have an ID have been handled above
+	if err := a.checkFrozen(); err != nil {
+		return 0, false, err
+	}
+
+	id, strID, unmaskedID := a.selectAvailableID(k)
 	if id == 0 {
 		return 0, false, fmt.Errorf("no more available IDs in configured space")
 	}
@@ -501,23 +799,33 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 	// start over
 	if id != oldID {
 		releaseKeyAndID()
-		return 0, false, fmt.Errorf("another writer has allocated this key")
+		return 0, false, conflictError{fmt.Errorf("another writer has allocated this key")}
 	}
 
 	// create /id/<ID> and fail if it already exists
 	keyPath := path.Join(a.idPrefix, strID)
-	success, err := kvstore.CreateOnlyIfLocked(ctx, keyPath, []byte(k), false, lock)
+	start = time.Now()
+	opCtx, cancel := a.operationContext(ctx)
+	success, err := kvstore.CreateOnlyIfLocked(opCtx, keyPath, a.encodeValue(k), false, lock)
+	cancel()
+	a.traceKVStoreOp(key, TraceOpCreateMasterKey, start, err)
 	if err != nil || !success {
 		// Creation failed. Another agent most likely beat us to allocating this
 		// ID, retry.
 		releaseKeyAndID()
-		return 0, false, fmt.Errorf("unable to create master key '%s': %s", keyPath, err)
+		if err != nil {
+			return 0, false, fmt.Errorf("unable to create master key '%s': %s", keyPath, err)
+		}
+		return 0, false, conflictError{fmt.Errorf("unable to create master key '%s': key exists", keyPath)}
 	}
 
 	// Notify pool that leased ID is now in-use.
 	a.idPool.Use(unmaskedID)
 
-	if err = a.createValueNodeKey(ctx, k, id, lock); err != nil {
+	start = time.Now()
+	err = a.createValueNodeKey(ctx, k, id, lock)
+	a.traceKVStoreOp(key, TraceOpCreateSlaveKey, start, err)
+	if err != nil {
 		// We will leak the master key here as the key has already been
 		// exposed and may be in use by other nodes. The garbage
 		// collector will release it again.
@@ -535,18 +843,38 @@ func (a *Allocator) lockedAllocate(ctx context.Context, key AllocatorKey) (idpoo
 // most likely due to a parallel allocation of the same ID by another user,
 // allocation is re-attempted for maxAllocAttempts times.
 //
+// If the allocator has been frozen with Freeze(), allocations requiring a new
+// ID are queued until the allocator is thawed. If ctx is cancelled before,
+// a FrozenError is returned.
+//
 // Returns the ID allocated to the key, if the ID had to be allocated, then
 // true is returned. An error is returned in case of failure.
 func (a *Allocator) Allocate(ctx context.Context, key AllocatorKey) (idpool.ID, bool, error) {
-	var (
-		err   error
-		value idpool.ID
-		isNew bool
-		k     = key.GetKey()
-	)
+	return a.AllocateWithPriority(ctx, key, PriorityNormal)
+}
+
+// AllocateWithPriority is Allocate() with a priority hint. Allocations with
+// PriorityCritical bypass the limit of parallel bulk allocations and retry
+// with a shorter backoff so that they do not sit behind mass allocations
+// caused by pod churn.
+func (a *Allocator) AllocateWithPriority(ctx context.Context, key AllocatorKey, prio AllocationPriority) (value idpool.ID, isNew bool, err error) {
+	k := key.GetKey()
 
 	log.WithField(fieldKey, key).Debug("Allocating key")
 
+	if a.tracer != nil {
+		start := time.Now()
+		a.tracer.OnAllocateStart(key)
+		defer func() {
+			a.tracer.OnAllocateDone(key, value, isNew, time.Since(start), err)
+		}()
+	}
+
+	if !a.beginOperation() {
+		return 0, false, ErrShuttingDown
+	}
+	defer a.endOperation()
+
 	select {
 	case <-a.initialListDone:
 	case <-ctx.Done():
@@ -559,13 +887,20 @@ func (a *Allocator) Allocate(ctx context.Context, key AllocatorKey) (idpool.ID,
 	if val := a.localKeys.use(k); val != idpool.NoID {
 		kvstore.Trace("Reusing local id", nil, logrus.Fields{fieldID: val, fieldKey: key})
 		a.mainCache.insert(key, val)
+		a.touchID(val, k)
 		return val, false, nil
 	}
 
-	kvstore.Trace("Allocating from kvstore", nil, logrus.Fields{fieldKey: key})
+	kvstore.Trace("Allocating from kvstore", nil, logrus.Fields{fieldKey: key, fieldPriority: prio})
+
+	releaseLane, err := a.acquireLane(ctx, prio)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { releaseLane() }()
 
 	// make a copy of the template and customize it
-	boff := a.backoffTemplate
+	boff := a.backoffFor(prio)
 	boff.Name = key.String()
 
 	for attempt := 0; attempt < maxAllocAttempts; attempt++ {
@@ -573,6 +908,8 @@ func (a *Allocator) Allocate(ctx context.Context, key AllocatorKey) (idpool.ID,
 		value, isNew, err = a.lockedAllocate(ctx, key)
 		if err == nil {
 			a.mainCache.insert(key, value)
+			a.touchID(value, k)
+			a.retrySucceeded()
 			log.WithField(fieldKey, key).WithField(fieldID, value).Debug("Allocated key")
 			return value, isNew, nil
 		}
@@ -582,6 +919,26 @@ func (a *Allocator) Allocate(ctx context.Context, key AllocatorKey) (idpool.ID,
 			logfields.Attempt: attempt,
 		})
 
+		// Allocations refused by a frozen allocator are queued until
+		// the allocator is thawed without consuming any attempts. The
+		// lane is released while waiting so that queued allocations do
+		// not block the allocation of keys which already have an ID.
+		if IsFrozen(err) {
+			scopedLog.WithError(err).Warning("Queuing key allocation until the allocator is thawed")
+			releaseLane()
+			releaseLane = func() {}
+			if waitErr := a.waitForThaw(ctx); waitErr != nil {
+				return 0, false, waitErr
+			}
+			lane, laneErr := a.acquireLane(ctx, prio)
+			if laneErr != nil {
+				return 0, false, laneErr
+			}
+			releaseLane = lane
+			attempt--
+			continue
+		}
+
 		select {
 		case <-ctx.Done():
 			scopedLog.WithError(ctx.Err()).Warning("Ongoing key allocation has been cancelled")
@@ -590,6 +947,11 @@ func (a *Allocator) Allocate(ctx context.Context, key AllocatorKey) (idpool.ID,
 			scopedLog.WithError(err).Warning("Key allocation attempt failed")
 		}
 
+		if attempt+1 < maxAllocAttempts && !a.allowRetry(prio, err) {
+			scopedLog.Warning("Retry budget exhausted, giving up on key allocation")
+			return 0, false, fmt.Errorf("key allocation failed and retry budget exhausted: %s", err)
+		}
+
 		if waitErr := boff.Wait(ctx); waitErr != nil {
 			return 0, false, waitErr
 		}
@@ -644,7 +1006,7 @@ func (a *Allocator) GetNoCacheIfLocked(ctx context.Context, key AllocatorKey, lo
 	//
 	// Only key1 should match
 	prefix := path.Join(a.valuePrefix, key.GetKey())
-	pairs, err := kvstore.ListPrefixIfLocked(prefix, lock)
+	pairs, err := a.listPrefix(ctx, prefix, lock)
 	kvstore.Trace("ListPrefixLocked", err, logrus.Fields{fieldPrefix: prefix, "entries": len(pairs)})
 	if err != nil {
 		return 0, err
@@ -652,9 +1014,8 @@ func (a *Allocator) GetNoCacheIfLocked(ctx context.Context, key AllocatorKey, lo
 
 	for k, v := range pairs {
 		if prefixMatchesKey(prefix, k) {
-			id, err := strconv.ParseUint(string(v.Data), 10, 64)
-			if err == nil {
-				return idpool.ID(id), nil
+			if id, err := parseSlaveValue(v.Data); err == nil {
+				return id, nil
 			}
 		}
 	}
@@ -680,7 +1041,7 @@ func (a *Allocator) GetNoCache(ctx context.Context, key AllocatorKey) (idpool.ID
 	//
 	// Only key1 should match
 	prefix := path.Join(a.valuePrefix, key.GetKey())
-	pairs, err := kvstore.ListPrefix(prefix)
+	pairs, err := a.listPrefix(ctx, prefix, nil)
 	kvstore.Trace("ListPrefix", err, logrus.Fields{fieldPrefix: prefix, "entries": len(pairs)})
 	if err != nil {
 		return 0, err
@@ -688,9 +1049,8 @@ func (a *Allocator) GetNoCache(ctx context.Context, key AllocatorKey) (idpool.ID
 
 	for k, v := range pairs {
 		if prefixMatchesKey(prefix, k) {
-			id, err := strconv.ParseUint(string(v.Data), 10, 64)
-			if err == nil {
-				return idpool.ID(id), nil
+			if id, err := parseSlaveValue(v.Data); err == nil {
+				return id, nil
 			}
 		}
 	}
@@ -698,19 +1058,86 @@ func (a *Allocator) GetNoCache(ctx context.Context, key AllocatorKey) (idpool.ID
 	return idpool.NoID, nil
 }
 
-// GetByID returns the key associated with an ID. Returns nil if no key is
-// associated with the ID.
+// GetByID returns the key associated with an ID. The main cache and the
+// caches of all remote kvstores being watched are consulted before falling
+// back to the kvstore. Returns nil if no key is associated with the ID.
 func (a *Allocator) GetByID(id idpool.ID) (AllocatorKey, error) {
 	if key := a.mainCache.getByID(id); key != nil {
 		return key, nil
 	}
 
+	a.remoteCachesMutex.RLock()
+	for rc := range a.remoteCaches {
+		if key := rc.cache.getByID(id); key != nil {
+			a.remoteCachesMutex.RUnlock()
+			return key, nil
+		}
+	}
+	a.remoteCachesMutex.RUnlock()
+
 	v, err := kvstore.Get(path.Join(a.idPrefix, id.String()))
 	if err != nil {
 		return nil, err
 	}
 
-	return a.keyType.PutKey(string(v))
+	key, err := decodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.keyType.PutKey(key)
+}
+
+// GetByIDFromCluster returns the key associated with an ID in the remote
+// cluster with the given name. The cache of the remote cluster is consulted
+// first, if the ID is not found, the kvstore of the remote cluster is queried
+// directly. Returns nil if no key is associated with the ID.
+func (a *Allocator) GetByIDFromCluster(clusterName string, id idpool.ID) (AllocatorKey, error) {
+	var remote *RemoteCache
+
+	a.remoteCachesMutex.RLock()
+	for rc := range a.remoteCaches {
+		if rc.clusterName == clusterName {
+			remote = rc
+			break
+		}
+	}
+	a.remoteCachesMutex.RUnlock()
+
+	if remote == nil {
+		return nil, fmt.Errorf("no remote cache for cluster %s", clusterName)
+	}
+
+	if key := remote.cache.getByID(id); key != nil {
+		return key, nil
+	}
+
+	v, err := remote.cache.backend.Get(path.Join(remote.cache.prefix, id.String()))
+	if err != nil || v == nil {
+		return nil, err
+	}
+
+	key, err := decodeValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.keyType.PutKey(key)
+}
+
+// parseSlaveValue parses the ID stored in the value of a slave key
+func parseSlaveValue(value []byte) (idpool.ID, error) {
+	v, err := decodeValue(value)
+	if err != nil {
+		return idpool.NoID, err
+	}
+
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return idpool.NoID, err
+	}
+
+	return idpool.ID(id), nil
 }
 
 // Release releases the use of an ID associated with the provided key. After
@@ -719,6 +1146,11 @@ func (a *Allocator) GetByID(id idpool.ID) (AllocatorKey, error) {
 func (a *Allocator) Release(ctx context.Context, key AllocatorKey) (lastUse bool, err error) {
 	log.WithField(fieldKey, key).Info("Releasing key")
 
+	if !a.beginOperation() {
+		return false, ErrShuttingDown
+	}
+	defer a.endOperation()
+
 	select {
 	case <-a.initialListDone:
 	case <-ctx.Done():
@@ -730,6 +1162,8 @@ func (a *Allocator) Release(ctx context.Context, key AllocatorKey) (lastUse bool
 	a.slaveKeysMutex.Lock()
 	defer a.slaveKeysMutex.Unlock()
 
+	a.touchID(a.localKeys.lookupKey(k), k)
+
 	// release the key locally, if it was the last use, remove the node
 	// specific value key to remove the global reference mark
 	lastUse, err = a.localKeys.release(k)
@@ -744,7 +1178,8 @@ func (a *Allocator) Release(ctx context.Context, key AllocatorKey) (lastUse bool
 		// does not need to be deleted with a lock as its protected by the
 		// a.slaveKeysMutex
 		if err := kvstore.Delete(valueKey); err != nil {
-			log.WithError(err).WithFields(logrus.Fields{fieldKey: key}).Warning("Ignoring node specific ID")
+			log.WithError(err).WithFields(logrus.Fields{fieldKey: key}).Warning("Unable to delete node specific ID, retrying later")
+			a.pendingSlaveKeys[valueKey] = struct{}{}
 		}
 
 		// if a.lockless {
@@ -758,43 +1193,84 @@ func (a *Allocator) Release(ctx context.Context, key AllocatorKey) (lastUse bool
 }
 
 // RunGC scans the kvstore for unused master keys and removes them
-func (a *Allocator) RunGC(staleKeysPrevRound map[string]uint64) (map[string]uint64, error) {
+func (a *Allocator) RunGC(staleKeysPrevRound map[string]uint64) (staleKeys map[string]uint64, err error) {
+	var allocated kvstore.KeyValuePairs
+	deleted := 0
+
+	span := a.startSpan(HousekeepingGC)
+	defer func() {
+		span.SetAttribute(SpanAttrKeys, int64(len(allocated)))
+		span.SetAttribute(SpanAttrStale, int64(len(staleKeys)))
+		span.SetAttribute(SpanAttrDeleted, int64(deleted))
+		span.End(err)
+	}()
+
+	// All kvstore operations are performed as background operations so
+	// the garbage collector never delays foreground allocations
+	ctx := kvstore.WithPriority(context.Background(), kvstore.PriorityBackground)
+
 	// fetch list of all /id/ keys
-	allocated, err := kvstore.ListPrefix(a.idPrefix)
+	allocated, err = kvstore.ListPrefixContext(ctx, a.idPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("list failed: %s", err)
 	}
 
-	staleKeys := map[string]uint64{}
+	staleKeys = map[string]uint64{}
+	seenIDs := map[idpool.ID]struct{}{}
+	defer func() {
+		if err == nil && a.usage != nil {
+			a.usage.retain(seenIDs)
+		}
+	}()
 
 	// iterate over /id/
 	for key, v := range allocated {
+		id, parseErr := strconv.ParseUint(path.Base(key), 10, 64)
+		if parseErr == nil {
+			seenIDs[idpool.ID(id)] = struct{}{}
+		}
+
 		// if a.lockless {
 		// FIXME: Add DeleteOnZeroCount support
 		// }
 
-		lock, err := a.lockPath(context.Background(), key)
+		lock, err := a.lockPath(ctx, key)
 		if err != nil {
 			log.WithError(err).WithField(fieldKey, key).Warning("allocator garbage collector was unable to lock key")
 			continue
 		}
 
+		value, err := decodeValue(v.Data)
+		if err != nil {
+			log.WithError(err).WithField(fieldKey, key).Warning("allocator garbage collector was unable to decode key")
+			lock.Unlock()
+			continue
+		}
+
 		// fetch list of all /value/<key> keys
-		valueKeyPrefix := path.Join(a.valuePrefix, string(v.Data))
-		pairs, err := kvstore.ListPrefixIfLocked(valueKeyPrefix, lock)
+		valueKeyPrefix := path.Join(a.valuePrefix, value)
+		pairs, err := kvstore.ListPrefixIfLockedContext(ctx, valueKeyPrefix, lock)
 		if err != nil {
 			log.WithError(err).WithField(fieldPrefix, valueKeyPrefix).Warning("allocator garbage collector was unable to list keys")
 			lock.Unlock()
 			continue
 		}
 
-		hasUsers := false
-		for k := range pairs {
+		users := 0
+		var revision uint64
+		for k, pair := range pairs {
 			if prefixMatchesKey(valueKeyPrefix, k) {
-				hasUsers = true
-				break
+				users++
+				if pair.ModRevision > revision {
+					revision = pair.ModRevision
+				}
 			}
 		}
+		hasUsers := users > 0
+
+		if parseErr == nil && a.usage != nil {
+			a.usage.observe(idpool.ID(id), value, users, revision, time.Now())
+		}
 
 		// if ID has no user, delete it
 		if !hasUsers {
@@ -804,10 +1280,14 @@ func (a *Allocator) RunGC(staleKeysPrevRound map[string]uint64) (map[string]uint
 			})
 			// Only delete if this key was previously marked as to be deleted
 			if modRev, ok := staleKeysPrevRound[key]; ok && modRev == v.ModRevision {
-				if err := kvstore.DeleteIfLocked(key, lock); err != nil {
+				if err := kvstore.DeleteIfLockedContext(ctx, key, lock); err != nil {
 					scopedLog.WithError(err).Warning("Unable to delete unused allocator master key")
 				} else {
 					scopedLog.Info("Deleted unused allocator master key")
+					deleted++
+					if parseErr == nil && a.usage != nil {
+						a.usage.forget(idpool.ID(id))
+					}
 				}
 			} else {
 				// If the key was not found mark it to be delete in the next RunGC
@@ -821,40 +1301,124 @@ func (a *Allocator) RunGC(staleKeysPrevRound map[string]uint64) (map[string]uint
 	return staleKeys, nil
 }
 
+// NodeAliveFunc returns true if the node owning slave keys with the given
+// suffix is still part of the cluster
+type NodeAliveFunc func(suffix string) bool
+
+// RunSlaveKeyGC scans the kvstore for slave keys owned by nodes which are no
+// longer part of the cluster according to isAlive and removes them. This
+// releases the references held by removed nodes without waiting for the lease
+// of the slave keys to expire.
+//
+// A slave key is only deleted if it has already been found stale and
+// unmodified in the previous round as passed in via staleKeysPrevRound. This
+// protects the slave keys of nodes which have just joined the cluster but
+// are not yet known to isAlive. Returns the slave keys found stale in this
+// round.
+func (a *Allocator) RunSlaveKeyGC(staleKeysPrevRound map[string]uint64, isAlive NodeAliveFunc) (map[string]uint64, error) {
+	// fetch list of all /value/ keys
+	slaveKeys, err := kvstore.ListPrefix(a.valuePrefix)
+	if err != nil {
+		return nil, fmt.Errorf("list failed: %s", err)
+	}
+
+	staleKeys := map[string]uint64{}
+
+	for key, v := range slaveKeys {
+		suffix := path.Base(key)
+		if isAlive(suffix) {
+			continue
+		}
+
+		scopedLog := log.WithFields(logrus.Fields{
+			fieldKey:    key,
+			fieldSuffix: suffix,
+		})
+
+		// Only delete if this key was previously marked as to be deleted
+		if modRev, ok := staleKeysPrevRound[key]; ok && modRev == v.ModRevision {
+			if err := kvstore.Delete(key); err != nil {
+				scopedLog.WithError(err).Warning("Unable to delete slave key of removed node")
+			} else {
+				scopedLog.Info("Deleted slave key of removed node")
+			}
+		} else {
+			staleKeys[key] = v.ModRevision
+		}
+	}
+
+	return staleKeys, nil
+}
+
 func (a *Allocator) recreateMasterKey(id idpool.ID, value string, reliablyMissing bool) {
 	var (
 		err       error
 		recreated bool
 		keyPath   = path.Join(a.idPrefix, id.String())
 		valueKey  = path.Join(a.valuePrefix, value, a.suffix)
+		ctx       = kvstore.WithPriority(context.Background(), kvstore.PriorityBackground)
 	)
 
+	// The key may have been released since id and value were looked up.
+	// The local use is looked up again after the kvstore has been
+	// updated, the kvstore operations are performed without holding
+	// a.slaveKeysMutex to not block allocations and releases meanwhile.
+	a.slaveKeysMutex.Lock()
+	use := a.localKeys.lookupUse(value)
+	a.slaveKeysMutex.Unlock()
+	if use == nil || use.val != id {
+		return
+	}
+
 	if reliablyMissing {
-		recreated, err = kvstore.CreateOnly(context.TODO(), keyPath, []byte(value), false)
+		recreated, err = kvstore.CreateOnly(ctx, keyPath, a.encodeValue(value), false)
 	} else {
-		recreated, err = kvstore.UpdateIfDifferent(context.TODO(), keyPath, []byte(value), false)
+		recreated, err = kvstore.UpdateIfDifferent(ctx, keyPath, a.encodeValue(value), false)
 	}
 	switch {
 	case err != nil:
 		log.WithError(err).WithField(fieldKey, keyPath).Warning("Unable to re-create missing master key")
 	case recreated:
 		log.WithField(fieldKey, keyPath).Warning("Re-created missing master key")
+		metrics.KVStoreAllocatorLocalKeysRecreated.WithLabelValues(a.idPrefix, "master").Inc()
 	}
 
 	// Also re-create the slave key in case it has been deleted. This will
 	// ensure that the next garbage collection cycle of any participating
 	// node does not remove the master key again.
 	if reliablyMissing {
-		recreated, err = kvstore.CreateOnly(context.TODO(), valueKey, []byte(id.String()), true)
+		recreated, err = kvstore.CreateOnly(ctx, valueKey, a.encodeValue(id.String()), true)
 	} else {
-		recreated, err = kvstore.UpdateIfDifferent(context.TODO(), valueKey, []byte(id.String()), true)
+		recreated, err = kvstore.UpdateIfDifferent(ctx, valueKey, a.encodeValue(id.String()), true)
 	}
 	switch {
 	case err != nil:
 		log.WithError(err).WithField(fieldKey, valueKey).Warning("Unable to re-create missing slave key")
-	case recreated:
-		log.WithField(fieldKey, valueKey).Warning("Re-created missing slave key")
+		return
+	case !recreated:
+		return
 	}
+
+	a.slaveKeysMutex.Lock()
+	defer a.slaveKeysMutex.Unlock()
+
+	// A slave key re-created for a key released in the meantime would
+	// make it point to a stale ID while other nodes allocate a new one
+	// for the same key. Restore the slave key of the current local use.
+	if current := a.localKeys.lookupUse(value); current != use {
+		if current == nil {
+			if err := kvstore.Delete(valueKey); err != nil {
+				log.WithError(err).WithField(fieldKey, valueKey).Warning("Unable to delete slave key of released key, retrying later")
+				a.pendingSlaveKeys[valueKey] = struct{}{}
+			}
+		} else if _, err := kvstore.UpdateIfDifferent(ctx, valueKey, a.encodeValue(current.val.String()), true); err != nil {
+			log.WithError(err).WithField(fieldKey, valueKey).Warning("Unable to restore slave key of re-allocated key")
+		}
+		return
+	}
+
+	log.WithField(fieldKey, valueKey).Warning("Re-created missing slave key")
+	metrics.KVStoreAllocatorLocalKeysRecreated.WithLabelValues(a.idPrefix, "slave").Inc()
 }
 
 // syncLocalKeys checks the kvstore and verifies that a master key exists for
@@ -862,33 +1426,74 @@ func (a *Allocator) recreateMasterKey(id idpool.ID, value string, reliablyMissin
 // some reason.
 func (a *Allocator) syncLocalKeys() error {
 	// Create a local copy of all local allocations to not require to hold
-	// any locks while performing kvstore operations. Local use can
-	// disappear while we perform the sync but that is fine as worst case,
-	// a master key is created for a slave key that no longer exists. The
-	// garbage collector will remove it again.
+	// any locks while listing. Local use can disappear while we perform
+	// the sync, recreateMasterKey skips keys which have been released in
+	// the meantime.
 	ids := a.localKeys.getVerifiedIDs()
 
+	span := a.startSpan(HousekeepingSyncLocalKeys)
+	span.SetAttribute(SpanAttrKeys, int64(len(ids)))
+	defer span.End(nil)
+
 	for id, value := range ids {
 		a.recreateMasterKey(id, value, false)
 	}
 
-	return nil
+	return a.deletePendingSlaveKeys(context.TODO())
+}
+
+// syncJitter is the maximum fraction of the sync interval by which each
+// run of the local key sync is randomly delayed or advanced
+const syncJitter = 0.1
+
+// jitteredInterval returns interval randomly adjusted by up to +/- syncJitter
+func jitteredInterval(interval time.Duration) time.Duration {
+	delta := (rand.Float64()*2 - 1) * syncJitter * float64(interval)
+	return interval + time.Duration(delta)
 }
 
 func (a *Allocator) startLocalKeySync() {
+	interval := a.syncInterval
+	if interval == 0 {
+		interval = option.Config.KVstorePeriodicSync
+	}
+
 	go func(a *Allocator) {
+		cacheInSync := true
 		for {
+			syncStart := time.Now()
 			if err := a.syncLocalKeys(); err != nil {
 				log.WithError(err).WithFields(logrus.Fields{fieldPrefix: a.idPrefix}).
 					Warning("Unable to run local key sync routine")
 			}
+			metrics.KVStoreAllocatorLocalKeySyncDuration.WithLabelValues(a.idPrefix).
+				Observe(time.Since(syncStart).Seconds())
+
+			// Verify the cache against the kvstore to repair the
+			// cache in case watch events have been lost
+			inSync, err := a.mainCache.verify(a, !cacheInSync)
+			if err != nil {
+				log.WithError(err).WithFields(logrus.Fields{fieldPrefix: a.idPrefix}).
+					Warning("Unable to verify allocator cache")
+			} else {
+				cacheInSync = inSync
+			}
+
+			a.updatePoolMetrics()
+
+			if a.mirror != nil {
+				if err := a.mirror.sync(a); err != nil {
+					log.WithError(err).WithFields(logrus.Fields{fieldPrefix: a.idPrefix}).
+						Warning("Unable to update allocator mirror")
+				}
+			}
 
 			select {
 			case <-a.stopGC:
 				log.WithFields(logrus.Fields{fieldPrefix: a.idPrefix}).
 					Debug("Stopped master key sync routine")
 				return
-			case <-time.After(option.Config.KVstorePeriodicSync):
+			case <-time.After(jitteredInterval(interval)):
 			}
 		}
 	}(a)
@@ -907,25 +1512,37 @@ type AllocatorEvent struct {
 
 	// Key is the key associated with the ID
 	Key AllocatorKey
+
+	// ModRevision is the kvstore revision of the change which caused the
+	// event. Revisions are monotonically increasing for a given kvstore
+	// and can be used to detect stale or out-of-order events across
+	// reconnects. It is 0 for events not originating from a kvstore.
+	ModRevision uint64
+
+	// Timestamp is the time at which the event was generated
+	Timestamp time.Time
 }
 
 // RemoteCache represents the cache content of an additional kvstore managing
 // identities. The contents are not directly accessible but will be merged into
 // the ForeachCache() function.
 type RemoteCache struct {
-	cache     cache
-	allocator *Allocator
+	cache       cache
+	allocator   *Allocator
+	clusterName string
 }
 
 // WatchRemoteKVStore starts watching an allocator base prefix the kvstore
 // represents by the provided backend. A local cache of all identities of that
 // kvstore will be maintained in the RemoteCache structure returned and will
 // start being reported in the identities returned by the ForeachCache()
-// function.
-func (a *Allocator) WatchRemoteKVStore(backend kvstore.BackendOperations, prefix string) *RemoteCache {
+// function. The cache can be looked up by clusterName using
+// GetByIDFromCluster().
+func (a *Allocator) WatchRemoteKVStore(clusterName string, backend kvstore.BackendOperations, prefix string) *RemoteCache {
 	rc := &RemoteCache{
-		cache:     newCache(backend, path.Join(prefix, "id")),
-		allocator: a,
+		cache:       newCache(backend, path.Join(prefix, "id")),
+		allocator:   a,
+		clusterName: clusterName,
 	}
 
 	a.remoteCachesMutex.Lock()
@@ -937,6 +1554,11 @@ func (a *Allocator) WatchRemoteKVStore(backend kvstore.BackendOperations, prefix
 	return rc
 }
 
+// ClusterName returns the name of the cluster the remote cache belongs to
+func (rc *RemoteCache) ClusterName() string {
+	return rc.clusterName
+}
+
 // Close stops watching for identities in the kvstore associated with the
 // remote cache and will clear the local cache.
 func (rc *RemoteCache) Close() {
@@ -91,7 +91,7 @@ func (s *AllocatorSuite) TestSelectID(c *C) {
 
 	// allocate all available IDs
 	for i := minID; i <= maxID; i++ {
-		id, val, unmaskedID := a.selectAvailableID()
+		id, val, unmaskedID := a.selectAvailableID(fmt.Sprintf("key-%d", i))
 		c.Assert(id, Not(Equals), idpool.NoID)
 		c.Assert(val, Equals, id.String())
 		c.Assert(id, Equals, unmaskedID)
@@ -99,7 +99,7 @@ func (s *AllocatorSuite) TestSelectID(c *C) {
 	}
 
 	// we should be out of IDs
-	id, val, unmaskedID := a.selectAvailableID()
+	id, val, unmaskedID := a.selectAvailableID("key-out-of-ids")
 	c.Assert(id, Equals, idpool.ID(0))
 	c.Assert(id, Equals, unmaskedID)
 	c.Assert(val, Equals, "")
@@ -115,7 +115,7 @@ func (s *AllocatorSuite) TestPrefixMask(c *C) {
 
 	// allocate all available IDs
 	for i := minID; i <= maxID; i++ {
-		id, val, unmaskedID := a.selectAvailableID()
+		id, val, unmaskedID := a.selectAvailableID(fmt.Sprintf("key-%d", i))
 		c.Assert(id, Not(Equals), idpool.NoID)
 		c.Assert(id>>16, Equals, idpool.ID(1))
 		c.Assert(id, Not(Equals), unmaskedID)
@@ -125,6 +125,202 @@ func (s *AllocatorSuite) TestPrefixMask(c *C) {
 	a.Delete()
 }
 
+type SelectIDSuite struct{}
+
+var _ = Suite(&SelectIDSuite{})
+
+func (s *SelectIDSuite) TestSelectDeterministicID(c *C) {
+	newAllocator := func() *Allocator {
+		return &Allocator{
+			min:              idpool.ID(1),
+			max:              idpool.ID(1000),
+			idPool:           idpool.NewIDPool(idpool.ID(1), idpool.ID(1000)),
+			deterministicIDs: true,
+		}
+	}
+
+	a1, a2 := newAllocator(), newAllocator()
+
+	// The same key must result in the same ID across allocators
+	id1, _, _ := a1.selectAvailableID("foo")
+	id2, _, _ := a2.selectAvailableID("foo")
+	c.Assert(id1, Not(Equals), idpool.NoID)
+	c.Assert(id1, Equals, id2)
+
+	// On collision, the next ID is probed
+	a3 := newAllocator()
+	a3.idPool.Remove(id1)
+	id3, _, _ := a3.selectAvailableID("foo")
+	if id1 == a3.max {
+		c.Assert(id3, Equals, a3.min)
+	} else {
+		c.Assert(id3, Equals, id1+1)
+	}
+}
+
+func (s *SelectIDSuite) TestShutdownDrainsInflight(c *C) {
+	a := &Allocator{
+		stopGC:    make(chan struct{}),
+		mainCache: newCache(nil, testPrefix),
+	}
+
+	c.Assert(a.beginOperation(), Equals, true)
+
+	done := make(chan error)
+	go func() {
+		done <- a.Shutdown(context.Background())
+	}()
+
+	// Shutdown must wait for the in-flight operation
+	select {
+	case <-done:
+		c.Fatal("Shutdown() returned with operation in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	a.endOperation()
+	c.Assert(<-done, IsNil)
+
+	c.Assert(a.beginOperation(), Equals, false)
+	_, _, err := a.Allocate(context.Background(), TestType("foo"))
+	c.Assert(err, Equals, ErrShuttingDown)
+	_, err = a.Release(context.Background(), TestType("foo"))
+	c.Assert(err, Equals, ErrShuttingDown)
+}
+
+func (s *SelectIDSuite) TestShutdownTimeout(c *C) {
+	a := &Allocator{
+		stopGC:    make(chan struct{}),
+		mainCache: newCache(nil, testPrefix),
+	}
+
+	c.Assert(a.beginOperation(), Equals, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(a.Shutdown(ctx), Not(IsNil))
+}
+
+func (s *SelectIDSuite) TestShutdownDeletesPendingSlaveKeys(c *C) {
+	backend := newMemBackend()
+	chaosBackends.mutex.Lock()
+	chaosBackends.backend = backend
+	chaosBackends.mutex.Unlock()
+	kvstore.SetupDummy(chaosBackendName)
+	defer kvstore.Close()
+
+	a := &Allocator{
+		stopGC:           make(chan struct{}),
+		mainCache:        newCache(nil, testPrefix),
+		pendingSlaveKeys: map[string]struct{}{},
+	}
+
+	// A slave key whose deletion failed on release
+	valueKey := testPrefix + "/value/foo/node1"
+	backend.set(valueKey, []byte("1"))
+	a.pendingSlaveKeys[valueKey] = struct{}{}
+
+	c.Assert(a.Shutdown(context.Background()), IsNil)
+	c.Assert(len(a.pendingSlaveKeys), Equals, 0)
+	value, err := kvstore.Get(valueKey)
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+}
+
+func (s *SelectIDSuite) TestRecreateMasterKeyReleased(c *C) {
+	backend := newMemBackend()
+	chaosBackends.mutex.Lock()
+	chaosBackends.backend = backend
+	chaosBackends.mutex.Unlock()
+	kvstore.SetupDummy(chaosBackendName)
+	defer kvstore.Close()
+
+	a := &Allocator{
+		idPrefix:         testPrefix + "/id",
+		valuePrefix:      testPrefix + "/value",
+		suffix:           "node1",
+		localKeys:        newLocalKeys(),
+		pendingSlaveKeys: map[string]struct{}{},
+	}
+	valueKey := testPrefix + "/value/foo/node1"
+
+	// The key is released while its master key is being re-created,
+	// a.slaveKeysMutex must not be held by recreateMasterKey meanwhile
+	release := func(reallocate idpool.ID) {
+		a.slaveKeysMutex.Lock()
+		defer a.slaveKeysMutex.Unlock()
+		_, err := a.localKeys.release("foo")
+		c.Assert(err, IsNil)
+		c.Assert(kvstore.Delete(valueKey), IsNil)
+		if reallocate != idpool.NoID {
+			_, err = a.localKeys.allocate("foo", reallocate)
+			c.Assert(err, IsNil)
+			c.Assert(kvstore.Set(valueKey, []byte(reallocate.String())), IsNil)
+		}
+	}
+
+	_, err := a.localKeys.allocate("foo", idpool.ID(1))
+	c.Assert(err, IsNil)
+	backend.onWrite = func(key string) {
+		if key == testPrefix+"/id/1" {
+			release(idpool.NoID)
+		}
+	}
+	a.recreateMasterKey(idpool.ID(1), "foo", true)
+
+	// The slave key of the released key must not be re-created
+	value, err := kvstore.Get(valueKey)
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	_, err = a.localKeys.allocate("foo", idpool.ID(2))
+	c.Assert(err, IsNil)
+	backend.onWrite = func(key string) {
+		if key == testPrefix+"/id/2" {
+			release(idpool.ID(3))
+		}
+	}
+	a.recreateMasterKey(idpool.ID(2), "foo", false)
+
+	// The slave key must point to the ID of the new allocation
+	value, err = kvstore.Get(valueKey)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "3")
+}
+
+func (s *SelectIDSuite) TestJitteredInterval(c *C) {
+	interval := time.Minute
+	for i := 0; i < 100; i++ {
+		d := jitteredInterval(interval)
+		c.Assert(d >= interval-6*time.Second, Equals, true)
+		c.Assert(d <= interval+6*time.Second, Equals, true)
+	}
+}
+
+func (s *SelectIDSuite) TestAllocationLanes(c *C) {
+	a := &Allocator{}
+	WithBulkAllocationLimit(1)(a)
+
+	release, err := a.acquireLane(context.Background(), PriorityNormal)
+	c.Assert(err, IsNil)
+
+	// The bulk lane is exhausted, further normal allocations must wait
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.acquireLane(ctx, PriorityNormal)
+	c.Assert(err, Not(IsNil))
+
+	// Critical allocations bypass the bulk lane
+	releaseCritical, err := a.acquireLane(context.Background(), PriorityCritical)
+	c.Assert(err, IsNil)
+	releaseCritical()
+
+	release()
+	release, err = a.acquireLane(context.Background(), PriorityNormal)
+	c.Assert(err, IsNil)
+	release()
+}
+
 func (s *AllocatorSuite) BenchmarkAllocate(c *C) {
 	allocatorName := randomTestName()
 	maxID := idpool.ID(256 + c.N)
@@ -181,6 +377,42 @@ func (s *AllocatorSuite) TestGC(c *C) {
 	c.Assert(key, Equals, TestType(""))
 }
 
+func (s *AllocatorSuite) TestSlaveKeyGC(c *C) {
+	allocatorName := randomTestName()
+	allocator, err := NewAllocator(allocatorName, TestType(""), WithMax(idpool.ID(256)), WithSuffix("removed"), WithoutGC())
+	c.Assert(err, IsNil)
+	c.Assert(allocator, Not(IsNil))
+	defer allocator.DeleteAllKeys()
+	defer allocator.Delete()
+
+	allocator.DeleteAllKeys()
+
+	key := TestType("1;")
+	_, _, err = allocator.Allocate(context.Background(), key)
+	c.Assert(err, IsNil)
+
+	isAlive := func(suffix string) bool { return suffix != "removed" }
+	slaveKey := path.Join(allocator.valuePrefix, key.GetKey(), "removed")
+
+	keysToDelete := map[string]uint64{}
+	keysToDelete, err = allocator.RunSlaveKeyGC(keysToDelete, isAlive)
+	c.Assert(err, IsNil)
+	c.Assert(len(keysToDelete), Equals, 1)
+
+	// The slave key is only removed in the second round
+	v, err := kvstore.Get(slaveKey)
+	c.Assert(err, IsNil)
+	c.Assert(v, Not(IsNil))
+
+	keysToDelete, err = allocator.RunSlaveKeyGC(keysToDelete, isAlive)
+	c.Assert(err, IsNil)
+	c.Assert(len(keysToDelete), Equals, 0)
+
+	v, err = kvstore.Get(slaveKey)
+	c.Assert(err, IsNil)
+	c.Assert(v, IsNil)
+}
+
 func testAllocator(c *C, maxID idpool.ID, allocatorName string, suffix string) {
 	allocator, err := NewAllocator(allocatorName, TestType(""), WithMax(maxID),
 		WithSuffix(suffix), WithoutGC())
@@ -420,7 +652,7 @@ func (s *AllocatorSuite) TestRemoteCache(c *C) {
 	}
 
 	// watch the prefix in the same kvstore via a 2nd watcher
-	rc := allocator.WatchRemoteKVStore(kvstore.Client(), testName)
+	rc := allocator.WatchRemoteKVStore("remote", kvstore.Client(), testName)
 	c.Assert(rc, Not(IsNil))
 
 	// wait for remote cache to be populated
@@ -15,17 +15,26 @@
 package allocator
 
 import (
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cilium/cilium/pkg/idpool"
 	"github.com/cilium/cilium/pkg/kvstore"
 	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/metrics"
 
 	"github.com/sirupsen/logrus"
 )
 
+// errListAborted is reported as error of the cache list span if the watcher
+// is stopped before the initial list has completed
+var errListAborted = errors.New("watcher stopped before initial list completed")
+
 // idMap provides mapping from ID to an AllocatorKey
 type idMap map[idpool.ID]AllocatorKey
 
@@ -64,6 +73,14 @@ type cache struct {
 	// watcher has exited
 	stopWatchWg sync.WaitGroup
 
+	// restartMutex serializes the restart of the watcher by verify() with
+	// stop()
+	restartMutex lock.Mutex
+
+	// stopped is true once stop() has been called, the watcher is no
+	// longer restarted afterwards. Protected by restartMutex.
+	stopped bool
+
 	// deleteInvalid enables deletion of identities outside of the valid
 	// prefix
 	deleteInvalidPrefixes bool
@@ -138,8 +155,14 @@ func (c *cache) start(a *Allocator) waitChan {
 		logger := c.getLogger()
 		logger.Info("Starting to watch allocation changes")
 
+		listSpan := a.startSpan(HousekeepingCacheList)
+		listed := false
 		watcher := c.backend.ListAndWatch(c.prefix, c.prefix, 512)
 
+		// lastRevision is the highest kvstore revision observed by the
+		// watcher
+		var lastRevision uint64
+
 		for {
 			select {
 			case event, ok := <-watcher.Events:
@@ -148,18 +171,67 @@ func (c *cache) start(a *Allocator) waitChan {
 				}
 				if event.Typ == kvstore.EventTypeListDone {
 					c.mutex.Lock()
+					// IDs which were cached before a resync but are no
+					// longer present have been missed while watching
+					var staleIDs []idpool.ID
+					// Stale IDs still in use locally are kept and
+					// their master key is re-created, as on deletion
+					recreate := map[idpool.ID]string{}
+					for id, key := range c.cache {
+						if _, ok := c.nextCache[id]; ok {
+							continue
+						}
+						if a.enableMasterKeyProtection {
+							if value := a.localKeys.lookupID(id); value != "" {
+								c.nextCache[id] = key
+								if key != nil {
+									c.nextKeyCache[key.GetKey()] = id
+								}
+								recreate[id] = value
+								continue
+							}
+						}
+						staleIDs = append(staleIDs, id)
+					}
 					// nextCache is valid, point the live cache to it
 					c.cache = c.nextCache
 					c.keyCache = c.nextKeyCache
+					listSpan.SetAttribute(SpanAttrKeys, int64(len(c.cache)))
+					listSpan.SetAttribute(SpanAttrStale, int64(len(staleIDs)))
 					c.mutex.Unlock()
 
+					for id, value := range recreate {
+						a.recreateMasterKey(id, value, true)
+					}
+
+					for _, id := range staleIDs {
+						a.releaseID(id)
+						if a.usage != nil {
+							a.usage.forget(id)
+						}
+						if a.events != nil {
+							a.events <- AllocatorEvent{
+								Typ:         kvstore.EventTypeDelete,
+								ID:          id,
+								ModRevision: lastRevision,
+								Timestamp:   time.Now(),
+							}
+						}
+					}
+
 					// report that the list operation has
 					// been completed and the allocator is
 					// ready to use
+					listSpan.End(nil)
+					listed = true
 					close(listDone)
 					continue
 				}
 
+				if event.ModRevision > lastRevision {
+					lastRevision = event.ModRevision
+				}
+
 				id := c.keyToID(event.Key, c.deleteInvalidPrefixes)
 				if id != 0 {
 					c.mutex.Lock()
@@ -167,14 +239,17 @@ func (c *cache) start(a *Allocator) waitChan {
 					var key AllocatorKey
 
 					if len(event.Value) > 0 {
-						var err error
-						key, err = a.keyType.PutKey(string(event.Value))
+						value, err := decodeValue(event.Value)
+						if err == nil {
+							key, err = a.keyType.PutKey(value)
+						}
 						if err != nil {
 							c.getLogger().WithError(err).WithField(fieldKey, event.Value).
 								Warning("Unable to unmarshal allocator key")
 						}
 					}
 					debugFields := c.getLogger().WithFields(logrus.Fields{fieldKey: key, fieldID: id})
+					var recreate string
 
 					switch event.Typ {
 					case kvstore.EventTypeCreate:
@@ -183,7 +258,8 @@ func (c *cache) start(a *Allocator) waitChan {
 						if key != nil {
 							c.nextKeyCache[key.GetKey()] = id
 						}
-						a.idPool.Remove(id)
+						a.reserveID(id)
+						a.touchCachedID(id, key)
 
 					case kvstore.EventTypeModify:
 						kvstore.Trace("Modifying id in cache", nil, debugFields.Data)
@@ -195,13 +271,15 @@ func (c *cache) start(a *Allocator) waitChan {
 						if key != nil {
 							c.nextKeyCache[key.GetKey()] = id
 						}
+						a.touchCachedID(id, key)
 
 					case kvstore.EventTypeDelete:
 						kvstore.Trace("Removing id from cache", nil, debugFields.Data)
 
 						if a.enableMasterKeyProtection {
-							if value := a.localKeys.lookupID(id); value != "" {
-								a.recreateMasterKey(id, value, true)
+							// the master key is re-created once
+							// c.mutex has been released
+							if recreate = a.localKeys.lookupID(id); recreate != "" {
 								break
 							}
 						}
@@ -211,15 +289,24 @@ func (c *cache) start(a *Allocator) waitChan {
 						}
 
 						delete(c.nextCache, id)
-						a.idPool.Insert(id)
+						a.releaseID(id)
+						if a.usage != nil {
+							a.usage.forget(id)
+						}
 					}
 					c.mutex.Unlock()
 
+					if recreate != "" {
+						a.recreateMasterKey(id, recreate, true)
+					}
+
 					if a.events != nil {
 						a.events <- AllocatorEvent{
-							Typ: event.Typ,
-							ID:  idpool.ID(id),
-							Key: key,
+							Typ:         event.Typ,
+							ID:          idpool.ID(id),
+							Key:         key,
+							ModRevision: event.ModRevision,
+							Timestamp:   time.Now(),
 						}
 					}
 				}
@@ -230,6 +317,9 @@ func (c *cache) start(a *Allocator) waitChan {
 		}
 
 	abort:
+		if !listed {
+			listSpan.End(errListAborted)
+		}
 		watcher.Stop()
 		// Signal that watcher is done
 		c.stopWatchWg.Done()
@@ -239,6 +329,14 @@ func (c *cache) start(a *Allocator) waitChan {
 }
 
 func (c *cache) stop() {
+	c.restartMutex.Lock()
+	c.stopped = true
+	c.stopWatcher()
+	c.restartMutex.Unlock()
+}
+
+// stopWatcher stops the watcher and waits for it to exit
+func (c *cache) stopWatcher() {
 	select {
 	case c.stopChan <- true:
 	default:
@@ -282,3 +380,112 @@ func (c *cache) insert(key AllocatorKey, val idpool.ID) {
 	c.nextKeyCache[key.GetKey()] = val
 	c.mutex.Unlock()
 }
+
+// cacheChecksum is a checksum of the contents of a cache consisting of the
+// number of entries and an order independent hash of all id to key mappings
+type cacheChecksum struct {
+	count int
+	hash  uint64
+}
+
+func (c cacheChecksum) String() string {
+	return fmt.Sprintf("%d/%x", c.count, c.hash)
+}
+
+// add adds the id to key mapping to the checksum
+func (c *cacheChecksum) add(id idpool.ID, key string) {
+	h := fnv.New64a()
+	h.Write([]byte(id.String()))
+	h.Write([]byte{'='})
+	h.Write([]byte(key))
+
+	c.count++
+	c.hash ^= h.Sum64()
+}
+
+// checksum returns the checksum of the live cache. IDs cached without a key,
+// i.e. with a value which could not be parsed, are accounted with an empty key
+// to match kvstoreChecksum().
+func (c *cache) checksum() cacheChecksum {
+	sum := cacheChecksum{}
+
+	c.mutex.RLock()
+	for id, key := range c.cache {
+		var k string
+		if key != nil {
+			k = key.GetKey()
+		}
+		sum.add(id, k)
+	}
+	c.mutex.RUnlock()
+
+	return sum
+}
+
+// kvstoreChecksum lists all master keys in the kvstore and returns the
+// checksum of the listed id to key mappings. Values are parsed the same way
+// as by the watcher populating the cache, values which cannot be parsed are
+// accounted with an empty key.
+func (c *cache) kvstoreChecksum(a *Allocator) (cacheChecksum, error) {
+	sum := cacheChecksum{}
+
+	pairs, err := c.backend.ListPrefix(c.prefix)
+	if err != nil {
+		return sum, err
+	}
+
+	for k, v := range pairs {
+		if id := c.keyToID(k, false); id != idpool.NoID {
+			var key string
+			if len(v.Data) > 0 {
+				if value, err := decodeValue(v.Data); err == nil {
+					if allocatorKey, err := a.keyType.PutKey(value); err == nil && allocatorKey != nil {
+						key = allocatorKey.GetKey()
+					}
+				}
+			}
+			sum.add(id, key)
+		}
+	}
+
+	return sum, nil
+}
+
+// verify compares the checksum of the cache with the checksum of the kvstore
+// contents. As the cache can lag behind the kvstore, a mismatch is only
+// considered to be a divergence if it has been detected by the previous
+// verification round as well. In that case the watcher is restarted to
+// resynchronize the cache. Returns true if the cache and kvstore are in sync.
+func (c *cache) verify(a *Allocator, mismatchPrevRound bool) (bool, error) {
+	kvstoreSum, err := c.kvstoreChecksum(a)
+	if err != nil {
+		return false, err
+	}
+
+	cacheSum := c.checksum()
+	if cacheSum == kvstoreSum {
+		return true, nil
+	}
+
+	scopedLog := c.getLogger().WithFields(logrus.Fields{
+		"cacheChecksum":   cacheSum.String(),
+		"kvstoreChecksum": kvstoreSum.String(),
+	})
+
+	if !mismatchPrevRound {
+		scopedLog.Debug("Allocator cache checksum mismatch, verifying again in next round")
+		return false, nil
+	}
+
+	scopedLog.Warning("Allocator cache diverged from kvstore, resynchronizing")
+	metrics.KVStoreAllocatorCacheRepairs.WithLabelValues(c.prefix).Inc()
+
+	c.restartMutex.Lock()
+	if !c.stopped {
+		c.stopWatcher()
+		c.start(a)
+	}
+	c.restartMutex.Unlock()
+
+	return false, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	. "gopkg.in/check.v1"
+)
+
+type CacheSuite struct{}
+
+var _ = Suite(&CacheSuite{})
+
+func (s *CacheSuite) TestChecksum(c *C) {
+	cache := cache{cache: idMap{}}
+	c.Assert(cache.checksum(), Equals, cacheChecksum{})
+
+	cache.cache[idpool.ID(1)] = TestType("foo")
+	cache.cache[idpool.ID(2)] = TestType("bar")
+
+	// The checksum must not depend on the order of insertion
+	sum := cacheChecksum{}
+	sum.add(idpool.ID(2), "bar")
+	sum.add(idpool.ID(1), "foo")
+	c.Assert(cache.checksum(), Equals, sum)
+	c.Assert(sum.count, Equals, 2)
+
+	// A changed mapping must result in a different checksum
+	cache.cache[idpool.ID(2)] = TestType("baz")
+	c.Assert(cache.checksum(), Not(Equals), sum)
+
+	// A missing entry must result in a different checksum
+	delete(cache.cache, idpool.ID(2))
+	c.Assert(cache.checksum().count, Equals, 1)
+	c.Assert(cache.checksum(), Not(Equals), sum)
+}
+
+func (s *CacheSuite) TestChecksumUnparsableValue(c *C) {
+	backend := newMemBackend()
+	a := &Allocator{keyType: TestType("")}
+	cache := cache{
+		backend: backend,
+		prefix:  "test/id",
+		cache:   idMap{idpool.ID(1): nil, idpool.ID(2): TestType("foo")},
+	}
+
+	// The watcher caches values which cannot be decompressed without a
+	// key, the kvstore listing must account for them the same way
+	backend.set("test/id/1", invalidCompressedValue)
+	backend.set("test/id/2", []byte("foo"))
+
+	sum, err := cache.kvstoreChecksum(a)
+	c.Assert(err, IsNil)
+	c.Assert(sum, Equals, cache.checksum())
+
+	inSync, err := cache.verify(a, true)
+	c.Assert(err, IsNil)
+	c.Assert(inSync, Equals, true)
+}
+
+func (s *CacheSuite) TestGetByIDRemoteCaches(c *C) {
+	a := &Allocator{
+		keyType:      TestType(""),
+		mainCache:    cache{cache: idMap{idpool.ID(1): TestType("foo")}},
+		remoteCaches: map[*RemoteCache]struct{}{},
+	}
+	rc := &RemoteCache{
+		cache:       cache{cache: idMap{idpool.ID(2): TestType("bar")}},
+		allocator:   a,
+		clusterName: "cluster1",
+	}
+	a.remoteCaches[rc] = struct{}{}
+	c.Assert(rc.ClusterName(), Equals, "cluster1")
+
+	key, err := a.GetByID(idpool.ID(1))
+	c.Assert(err, IsNil)
+	c.Assert(key, Equals, TestType("foo"))
+
+	key, err = a.GetByID(idpool.ID(2))
+	c.Assert(err, IsNil)
+	c.Assert(key, Equals, TestType("bar"))
+
+	key, err = a.GetByIDFromCluster("cluster1", idpool.ID(2))
+	c.Assert(err, IsNil)
+	c.Assert(key, Equals, TestType("bar"))
+
+	_, err = a.GetByIDFromCluster("cluster2", idpool.ID(2))
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *CacheSuite) TestResyncKeepsLocalIDs(c *C) {
+	backend := newMemBackend()
+	chaosBackends.mutex.Lock()
+	chaosBackends.backend = backend
+	chaosBackends.mutex.Unlock()
+	kvstore.SetupDummy(chaosBackendName)
+	defer kvstore.Close()
+
+	a := &Allocator{
+		keyType:                   TestType(""),
+		idPrefix:                  testPrefix + "/id",
+		valuePrefix:               testPrefix + "/value",
+		suffix:                    "node1",
+		idPool:                    idpool.NewIDPool(1, 10),
+		localKeys:                 newLocalKeys(),
+		enableMasterKeyProtection: true,
+		events:                    make(AllocatorEventChan, 10),
+	}
+	a.mainCache = newCache(backend, a.idPrefix)
+
+	// Both IDs were cached before the resync but have been deleted from
+	// the kvstore without the watcher noticing, ID 1 is still used locally
+	_, err := a.localKeys.allocate("foo", idpool.ID(1))
+	c.Assert(err, IsNil)
+	c.Assert(a.localKeys.verify("foo"), IsNil)
+	a.mainCache.cache = idMap{idpool.ID(1): TestType("foo"), idpool.ID(2): TestType("bar")}
+	a.mainCache.keyCache = keyMap{"foo": idpool.ID(1), "bar": idpool.ID(2)}
+
+	<-a.mainCache.start(a)
+	a.mainCache.stop()
+
+	c.Assert(a.mainCache.get("foo"), Equals, idpool.ID(1))
+	c.Assert(a.mainCache.get("bar"), Equals, idpool.NoID)
+
+	value, err := kvstore.Get(testPrefix + "/id/1")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "foo")
+	value, err = kvstore.Get(testPrefix + "/value/foo/node1")
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "1")
+
+	// Only the ID no longer in use is reported as deleted
+	c.Assert(len(a.events), Equals, 1)
+	event := <-a.events
+	c.Assert(event.Typ, Equals, kvstore.EventTypeDelete)
+	c.Assert(event.ID, Equals, idpool.ID(2))
+}
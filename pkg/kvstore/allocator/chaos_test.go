@@ -0,0 +1,776 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/lock"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	// chaosBackendName is the name under which memBackend is registered
+	chaosBackendName = "allocator-chaos"
+
+	// chaosPrefix is the base path of the allocators of the chaos test
+	chaosPrefix = "chaos-prefix"
+
+	// defaultChaosDuration is the duration of the chaos test unless
+	// overwritten with the CHAOS_DURATION environment variable, e.g.
+	// CHAOS_DURATION=1h to continuously run the test
+	defaultChaosDuration = 2 * time.Second
+)
+
+// memBackend is an in-memory kvstore backend. Locks, conditional writes and
+// watches are implemented with the same semantics as the etcd backend.
+type memBackend struct {
+	mutex    lock.Mutex
+	revision uint64
+	data     map[string]kvstore.Value
+	locks    map[string]*memLock
+	watchers map[*memWatcher]struct{}
+
+	// unlocked is closed and replaced whenever a lock is released
+	unlocked chan struct{}
+
+	// onDelete if set, is called with the key and value of each deleted
+	// key while the backend is locked
+	onDelete func(key string, value []byte)
+
+	// onWrite if set, is called with each key written by CreateOnly or
+	// UpdateIfDifferent after the backend has been unlocked
+	onWrite func(key string)
+}
+
+func newMemBackend() *memBackend {
+	return &memBackend{
+		data:     map[string]kvstore.Value{},
+		locks:    map[string]*memLock{},
+		watchers: map[*memWatcher]struct{}{},
+		unlocked: make(chan struct{}),
+	}
+}
+
+// memLock is a lock of a memBackend
+type memLock struct {
+	backend *memBackend
+	path    string
+}
+
+func (l *memLock) Unlock() error {
+	l.backend.mutex.Lock()
+	defer l.backend.mutex.Unlock()
+
+	if l.backend.locks[l.path] != l {
+		return fmt.Errorf("lock %s not held", l.path)
+	}
+	delete(l.backend.locks, l.path)
+	close(l.backend.unlocked)
+	l.backend.unlocked = make(chan struct{})
+	return nil
+}
+
+func (l *memLock) Comparator() interface{} {
+	return l
+}
+
+// memWatcher queues the events of a watcher so that writers never block on
+// slow consumers
+type memWatcher struct {
+	watcher *kvstore.Watcher
+	mutex   lock.Mutex
+	queue   []kvstore.KeyValueEvent
+	notify  chan struct{}
+}
+
+func (w *memWatcher) enqueue(event kvstore.KeyValueEvent) {
+	w.mutex.Lock()
+	w.queue = append(w.queue, event)
+	w.mutex.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *memWatcher) run(b *memBackend) {
+	defer func() {
+		b.mutex.Lock()
+		delete(b.watchers, w)
+		b.mutex.Unlock()
+		w.watcher.Stopped()
+	}()
+
+	for {
+		select {
+		case <-w.notify:
+		case <-w.watcher.StopChan():
+			return
+		}
+
+		w.mutex.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mutex.Unlock()
+
+		for _, event := range queue {
+			select {
+			case w.watcher.Events <- event:
+			case <-w.watcher.StopChan():
+				return
+			}
+		}
+	}
+}
+
+// checkLock returns an error if lock is not nil and no longer held. Must be
+// called with mutex held.
+func (b *memBackend) checkLock(lock kvstore.KVLocker) error {
+	if lock == nil {
+		return nil
+	}
+	if l, ok := lock.Comparator().(*memLock); !ok || b.locks[l.path] != l {
+		return errors.New("lock lost")
+	}
+	return nil
+}
+
+// set writes a key and notifies watchers. Must be called with mutex held.
+func (b *memBackend) set(key string, value []byte) {
+	typ := kvstore.EventTypeCreate
+	if _, ok := b.data[key]; ok {
+		typ = kvstore.EventTypeModify
+	}
+
+	b.revision++
+	data := append([]byte(nil), value...)
+	b.data[key] = kvstore.Value{Data: data, ModRevision: b.revision}
+	b.notify(kvstore.KeyValueEvent{Typ: typ, Key: key, Value: data, ModRevision: b.revision})
+}
+
+// delete deletes a key and notifies watchers. Must be called with mutex
+// held.
+func (b *memBackend) delete(key string) {
+	v, ok := b.data[key]
+	if !ok {
+		return
+	}
+
+	if b.onDelete != nil {
+		b.onDelete(key, v.Data)
+	}
+
+	b.revision++
+	delete(b.data, key)
+	b.notify(kvstore.KeyValueEvent{Typ: kvstore.EventTypeDelete, Key: key, ModRevision: b.revision})
+}
+
+func (b *memBackend) notify(event kvstore.KeyValueEvent) {
+	for w := range b.watchers {
+		if strings.HasPrefix(event.Key, w.watcher.Prefix()) {
+			w.enqueue(event)
+		}
+	}
+}
+
+// sortedKeys returns the keys matching prefix in lexical order. Must be
+// called with mutex held.
+func (b *memBackend) sortedKeys(prefix string) []string {
+	keys := []string{}
+	for key := range b.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b *memBackend) Connected() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func (b *memBackend) Disconnected() <-chan struct{} {
+	return make(chan struct{})
+}
+
+func (b *memBackend) Status() (string, error) {
+	return "in-memory", nil
+}
+
+func (b *memBackend) LockPath(ctx context.Context, path string) (kvstore.KVLocker, error) {
+	for {
+		b.mutex.Lock()
+		if _, ok := b.locks[path]; !ok {
+			l := &memLock{backend: b, path: path}
+			b.locks[path] = l
+			b.mutex.Unlock()
+			return l, nil
+		}
+		unlocked := b.unlocked
+		b.mutex.Unlock()
+
+		select {
+		case <-unlocked:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *memBackend) Get(key string) ([]byte, error) {
+	return b.GetIfLocked(key, nil)
+}
+
+func (b *memBackend) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return b.Get(key)
+}
+
+func (b *memBackend) GetIfLocked(key string, lock kvstore.KVLocker) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return nil, err
+	}
+	if v, ok := b.data[key]; ok {
+		return v.Data, nil
+	}
+	return nil, nil
+}
+
+func (b *memBackend) GetIfLockedContext(ctx context.Context, key string, lock kvstore.KVLocker) ([]byte, error) {
+	return b.GetIfLocked(key, lock)
+}
+
+func (b *memBackend) GetPrefix(ctx context.Context, prefix string) (string, []byte, error) {
+	return b.GetPrefixIfLocked(ctx, prefix, nil)
+}
+
+func (b *memBackend) GetPrefixIfLocked(ctx context.Context, prefix string, lock kvstore.KVLocker) (string, []byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return "", nil, err
+	}
+	if keys := b.sortedKeys(prefix); len(keys) > 0 {
+		return keys[0], b.data[keys[0]].Data, nil
+	}
+	return "", nil, nil
+}
+
+func (b *memBackend) Set(key string, value []byte) error {
+	b.mutex.Lock()
+	b.set(key, value)
+	b.mutex.Unlock()
+	return nil
+}
+
+func (b *memBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	return b.Set(key, value)
+}
+
+func (b *memBackend) Delete(key string) error {
+	return b.DeleteIfLocked(key, nil)
+}
+
+func (b *memBackend) DeleteContext(ctx context.Context, key string) error {
+	return b.Delete(key)
+}
+
+func (b *memBackend) DeleteIfLocked(key string, lock kvstore.KVLocker) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return err
+	}
+	b.delete(key)
+	return nil
+}
+
+func (b *memBackend) DeleteIfLockedContext(ctx context.Context, key string, lock kvstore.KVLocker) error {
+	return b.DeleteIfLocked(key, lock)
+}
+
+func (b *memBackend) DeletePrefix(prefix string) error {
+	b.mutex.Lock()
+	for _, key := range b.sortedKeys(prefix) {
+		b.delete(key)
+	}
+	b.mutex.Unlock()
+	return nil
+}
+
+func (b *memBackend) DeletePrefixContext(ctx context.Context, prefix string) error {
+	return b.DeletePrefix(prefix)
+}
+
+func (b *memBackend) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	return b.UpdateIfLocked(ctx, key, value, lease, nil)
+}
+
+func (b *memBackend) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock kvstore.KVLocker) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return err
+	}
+	b.set(key, value)
+	return nil
+}
+
+func (b *memBackend) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	return b.UpdateIfDifferentIfLocked(ctx, key, value, lease, nil)
+}
+
+func (b *memBackend) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock kvstore.KVLocker) (bool, error) {
+	updated, err := b.updateIfDifferent(key, value, lock)
+	if updated && b.onWrite != nil {
+		b.onWrite(key)
+	}
+	return updated, err
+}
+
+func (b *memBackend) updateIfDifferent(key string, value []byte, lock kvstore.KVLocker) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return false, err
+	}
+	if v, ok := b.data[key]; ok && bytes.Equal(v.Data, value) {
+		return false, nil
+	}
+	b.set(key, value)
+	return true, nil
+}
+
+func (b *memBackend) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	return b.CreateOnlyIfLocked(ctx, key, value, lease, nil)
+}
+
+func (b *memBackend) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock kvstore.KVLocker) (bool, error) {
+	created, err := b.createOnly(key, value, lock)
+	if created && b.onWrite != nil {
+		b.onWrite(key)
+	}
+	return created, err
+}
+
+func (b *memBackend) createOnly(key string, value []byte, lock kvstore.KVLocker) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return false, err
+	}
+	if _, ok := b.data[key]; ok {
+		return false, nil
+	}
+	b.set(key, value)
+	return true, nil
+}
+
+func (b *memBackend) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.data[condKey]; !ok {
+		return fmt.Errorf("key %s does not exist", condKey)
+	}
+	b.set(key, value)
+	return nil
+}
+
+func (b *memBackend) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	return b.CreateIfExists(condKey, key, value, lease)
+}
+
+func (b *memBackend) ListPrefix(prefix string) (kvstore.KeyValuePairs, error) {
+	return b.ListPrefixIfLocked(prefix, nil)
+}
+
+func (b *memBackend) ListPrefixContext(ctx context.Context, prefix string) (kvstore.KeyValuePairs, error) {
+	return b.ListPrefix(prefix)
+}
+
+func (b *memBackend) ListPrefixIfLocked(prefix string, lock kvstore.KVLocker) (kvstore.KeyValuePairs, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.checkLock(lock); err != nil {
+		return nil, err
+	}
+	pairs := kvstore.KeyValuePairs{}
+	for _, key := range b.sortedKeys(prefix) {
+		pairs[key] = b.data[key]
+	}
+	return pairs, nil
+}
+
+func (b *memBackend) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock kvstore.KVLocker) (kvstore.KeyValuePairs, error) {
+	return b.ListPrefixIfLocked(prefix, lock)
+}
+
+func (b *memBackend) Watch(w *kvstore.Watcher) {
+	mw := &memWatcher{watcher: w, notify: make(chan struct{}, 1)}
+
+	// The initial list and the registration of the watcher are atomic so
+	// that no event is missed in between
+	b.mutex.Lock()
+	for _, key := range b.sortedKeys(w.Prefix()) {
+		v := b.data[key]
+		mw.enqueue(kvstore.KeyValueEvent{Typ: kvstore.EventTypeCreate, Key: key, Value: v.Data, ModRevision: v.ModRevision})
+	}
+	mw.enqueue(kvstore.KeyValueEvent{Typ: kvstore.EventTypeListDone})
+	b.watchers[mw] = struct{}{}
+	b.mutex.Unlock()
+
+	go mw.run(b)
+}
+
+func (b *memBackend) Close() {}
+
+func (b *memBackend) LeaseStatus(ctx context.Context) (kvstore.LeaseStatus, error) {
+	return kvstore.LeaseStatus{ID: "in-memory", TTL: time.Hour, Remaining: time.Hour}, nil
+}
+
+func (b *memBackend) GetCapabilities() kvstore.Capabilities {
+	return kvstore.Capabilities(0)
+}
+
+func (b *memBackend) Encode(in []byte) string {
+	return base64.URLEncoding.EncodeToString(in)
+}
+
+func (b *memBackend) Decode(in string) ([]byte, error) {
+	return base64.URLEncoding.DecodeString(in)
+}
+
+func (b *memBackend) ListAndWatch(name, prefix string, chanSize int) *kvstore.Watcher {
+	w := kvstore.NewWatcher(name, prefix, chanSize)
+	b.Watch(w)
+	return w
+}
+
+// memBackendFactory creates clients of the memBackend currently set as
+// backend
+type memBackendFactory struct {
+	mutex   lock.Mutex
+	backend *memBackend
+}
+
+func (f *memBackendFactory) ValidateConfig(opts map[string]string) error {
+	return nil
+}
+
+func (f *memBackendFactory) NewClient(opts map[string]string, extraOpts *kvstore.ExtraOptions) (kvstore.BackendOperations, chan error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	errChan := make(chan error)
+	close(errChan)
+	return f.backend, errChan
+}
+
+var chaosBackends = &memBackendFactory{}
+
+func init() {
+	if err := kvstore.RegisterBackend(chaosBackendName, chaosBackends); err != nil {
+		panic(err)
+	}
+}
+
+// chaosHarness tracks the keys held by the simulated nodes of the chaos test
+// and records violations of the allocator invariants
+type chaosHarness struct {
+	mutex lock.Mutex
+
+	// ids is the ID of each key held by at least one node
+	ids map[string]idpool.ID
+
+	// owners is the key of each ID held by at least one node
+	owners map[idpool.ID]string
+
+	// refs is the number of references held by each node for each key
+	refs []map[string]int
+
+	violations []string
+}
+
+func newChaosHarness(nodes int) *chaosHarness {
+	h := &chaosHarness{
+		ids:    map[string]idpool.ID{},
+		owners: map[idpool.ID]string{},
+		refs:   make([]map[string]int, nodes),
+	}
+	for i := range h.refs {
+		h.refs[i] = map[string]int{}
+	}
+	return h
+}
+
+// violation records a violation of an invariant. Must be called with mutex
+// held.
+func (h *chaosHarness) violation(format string, args ...interface{}) {
+	h.violations = append(h.violations, fmt.Sprintf(format, args...))
+}
+
+// allocated records the allocation of id to key by node
+func (h *chaosHarness) allocated(node int, key string, id idpool.ID) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if heldID, ok := h.ids[key]; ok && heldID != id {
+		h.violation("key %s allocated ID %d while held with ID %d", key, id, heldID)
+		return
+	}
+	if owner, ok := h.owners[id]; ok && owner != key {
+		h.violation("ID %d allocated to key %s while held by key %s", id, key, owner)
+		return
+	}
+
+	h.ids[key] = id
+	h.owners[id] = key
+	h.refs[node][key]++
+}
+
+// release selects a key held by node and records its release before the
+// release is performed. Returns false if node holds no key.
+func (h *chaosHarness) release(node int) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := make([]string, 0, len(h.refs[node]))
+	for key := range h.refs[node] {
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return "", false
+	}
+	sort.Strings(keys)
+	key := keys[rand.Intn(len(keys))]
+
+	h.refs[node][key]--
+	if h.refs[node][key] == 0 {
+		delete(h.refs[node], key)
+	}
+
+	for _, refs := range h.refs {
+		if refs[key] > 0 {
+			return key, true
+		}
+	}
+	delete(h.owners, h.ids[key])
+	delete(h.ids, key)
+	return key, true
+}
+
+// deleted verifies that a master key deleted from the kvstore is not held
+// by any node
+func (h *chaosHarness) deleted(idPrefix, key string) {
+	if path.Dir(key) != idPrefix {
+		return
+	}
+	id, err := strconv.ParseUint(path.Base(key), 10, 64)
+	if err != nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if owner, ok := h.owners[idpool.ID(id)]; ok {
+		h.violation("master key of ID %d deleted while held by key %s", id, owner)
+	}
+}
+
+// verifyRefs verifies that the local reference counts of node match the
+// references recorded by the harness
+func (h *chaosHarness) verifyRefs(node int, a *Allocator) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	a.localKeys.RLock()
+	defer a.localKeys.RUnlock()
+
+	for key, refs := range h.refs[node] {
+		if k, ok := a.localKeys.keys[key]; !ok || k.refcnt != uint64(refs) {
+			h.violation("node %d holds %d references of key %s but local refcount is %v", node, refs, key, k)
+		}
+	}
+	for key, k := range a.localKeys.keys {
+		if _, ok := h.refs[node][key]; !ok {
+			h.violation("node %d holds no reference of key %s but local refcount is %d", node, key, k.refcnt)
+		}
+	}
+}
+
+func chaosDuration(c *C) time.Duration {
+	s := os.Getenv("CHAOS_DURATION")
+	if s == "" {
+		return defaultChaosDuration
+	}
+	d, err := time.ParseDuration(s)
+	c.Assert(err, IsNil)
+	return d
+}
+
+type ChaosSuite struct {
+	backend *memBackend
+}
+
+var _ = Suite(&ChaosSuite{})
+
+func (s *ChaosSuite) SetUpTest(c *C) {
+	s.backend = newMemBackend()
+	chaosBackends.mutex.Lock()
+	chaosBackends.backend = s.backend
+	chaosBackends.mutex.Unlock()
+	kvstore.SetupDummy(chaosBackendName)
+}
+
+func (s *ChaosSuite) TearDownTest(c *C) {
+	kvstore.DeletePrefix(chaosPrefix)
+	kvstore.Close()
+}
+
+// TestAllocatorChaos runs concurrent allocations, releases and garbage
+// collection cycles of multiple simulated nodes and verifies that no two keys
+// share an ID, reference counts are consistent and the garbage collector
+// never deletes IDs in use.
+func (s *ChaosSuite) TestAllocatorChaos(c *C) {
+	const (
+		nodes          = 4
+		workersPerNode = 3
+		numKeys        = 32
+	)
+
+	duration := chaosDuration(c)
+	c.Logf("Running allocator chaos test for %s", duration)
+
+	h := newChaosHarness(nodes)
+	allocators := make([]*Allocator, nodes)
+	for i := range allocators {
+		a, err := NewAllocator(chaosPrefix, TestType(""), WithMax(idpool.ID(1024)),
+			WithSuffix(fmt.Sprintf("node%d", i)), WithMasterKeyProtection(),
+			WithSyncInterval(50*time.Millisecond))
+		c.Assert(err, IsNil)
+		c.Assert(a, Not(IsNil))
+		allocators[i] = a
+	}
+	defer func() {
+		for _, a := range allocators {
+			a.Delete()
+		}
+	}()
+
+	idPrefix := allocators[0].idPrefix
+	s.backend.mutex.Lock()
+	s.backend.onDelete = func(key string, value []byte) {
+		h.deleted(idPrefix, key)
+	}
+	s.backend.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for node, a := range allocators {
+		for i := 0; i < workersPerNode; i++ {
+			wg.Add(1)
+			go func(node int, a *Allocator) {
+				defer wg.Done()
+				for ctx.Err() == nil {
+					if rand.Intn(2) == 0 {
+						key := fmt.Sprintf("key%d", rand.Intn(numKeys))
+						id, _, err := a.Allocate(ctx, TestType(key))
+						if err == nil {
+							h.allocated(node, key, id)
+						}
+					} else if key, ok := h.release(node); ok {
+						// The release must not be cancelled once recorded
+						if _, err := a.Release(context.Background(), TestType(key)); err != nil {
+							h.mutex.Lock()
+							h.violation("node %d failed to release key %s: %s", node, key, err)
+							h.mutex.Unlock()
+						}
+					}
+				}
+			}(node, a)
+		}
+	}
+
+	gc := NewAllocatorForGC(chaosPrefix)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var staleKeys map[string]uint64
+		for ctx.Err() == nil {
+			var err error
+			if staleKeys, err = gc.RunGC(staleKeys); err != nil {
+				h.mutex.Lock()
+				h.violation("garbage collection failed: %s", err)
+				h.mutex.Unlock()
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	wg.Wait()
+
+	for node, a := range allocators {
+		h.verifyRefs(node, a)
+	}
+	c.Assert(h.violations, HasLen, 0)
+
+	// Release all remaining references
+	for node, a := range allocators {
+		for {
+			key, ok := h.release(node)
+			if !ok {
+				break
+			}
+			_, err := a.Release(context.Background(), TestType(key))
+			c.Assert(err, IsNil)
+		}
+		h.verifyRefs(node, a)
+	}
+	c.Assert(h.violations, HasLen, 0)
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"github.com/cilium/cilium/pkg/kvstore"
+)
+
+// compressionThreshold is the minimum size in bytes of a value for it to be
+// compressed
+const compressionThreshold = 128
+
+// WithCompression enables the compression of large values written to the
+// kvstore by the allocator. Compressed values are read by all allocators
+// regardless of this option, but not by versions predating compression
+// support, so compression must only be enabled once all nodes have been
+// upgraded.
+func WithCompression() AllocatorOption {
+	return func(a *Allocator) { a.compression = true }
+}
+
+// encodeValue returns the representation of value to be written to the
+// kvstore. The value is wrapped in the kvstore value envelope and compressed
+// with snappy if compression is enabled and the value is large enough to
+// benefit from compression. All other values are written as-is so that
+// they remain readable by allocators without compression support.
+func (a *Allocator) encodeValue(value string) []byte {
+	if !a.compression || len(value) < compressionThreshold {
+		return []byte(value)
+	}
+
+	encoded, err := kvstore.EncodeValue([]byte(value), kvstore.CompressionSnappy, compressionThreshold)
+	if err != nil || len(encoded) >= len(value) {
+		return []byte(value)
+	}
+
+	return encoded
+}
+
+// decodeValue returns the plain value of a value read from the kvstore which
+// may or may not be compressed
+func decodeValue(value []byte) (string, error) {
+	plain, err := kvstore.DecodeValue(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"strings"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	. "gopkg.in/check.v1"
+)
+
+// invalidCompressedValue is an enveloped value which cannot be decompressed
+var invalidCompressedValue = append([]byte{kvstore.EnvelopeMarker, kvstore.EnvelopeVersion, byte(kvstore.CompressionSnappy)}, "invalid"...)
+
+type CompressionSuite struct{}
+
+var _ = Suite(&CompressionSuite{})
+
+func (s *CompressionSuite) TestEncodeDecodeValue(c *C) {
+	large := strings.Repeat("k8s:io.kubernetes.pod.namespace=default;", 16)
+
+	// Without compression, values are written as-is
+	a := &Allocator{}
+	c.Assert(string(a.encodeValue(large)), Equals, large)
+
+	WithCompression()(a)
+	encoded := a.encodeValue(large)
+	c.Assert(len(encoded) < len(large), Equals, true)
+	c.Assert(encoded[:kvstore.EnvelopeHeaderLen], DeepEquals, []byte{kvstore.EnvelopeMarker, kvstore.EnvelopeVersion, byte(kvstore.CompressionSnappy)})
+
+	decoded, err := decodeValue(encoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, Equals, large)
+
+	// Small values are not compressed
+	c.Assert(string(a.encodeValue("k8s:foo=bar;")), Equals, "k8s:foo=bar;")
+
+	// Plain values written by allocators without compression are read
+	decoded, err = decodeValue([]byte(large))
+	c.Assert(err, IsNil)
+	c.Assert(decoded, Equals, large)
+
+	_, err = decodeValue(invalidCompressedValue)
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *CompressionSuite) TestParseSlaveValue(c *C) {
+	id, err := parseSlaveValue([]byte("1001"))
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, idpool.ID(1001))
+
+	_, err = parseSlaveValue([]byte("foo"))
+	c.Assert(err, Not(IsNil))
+}
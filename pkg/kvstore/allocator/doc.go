@@ -13,4 +13,19 @@
 // limitations under the License.
 
 // Package allocator provides a kvstore based ID allocator
+//
+// The allocator never compares its local clock with the clocks of other
+// nodes or of the kvstore. Local periods, e.g. the quarantine of released
+// IDs, the idle tracking and the retry budget, are measured as differences of
+// time.Now() values, which use the monotonic clock and are thus not affected
+// by wall clock adjustments. Changes made by other nodes are ordered and aged
+// by kvstore revisions, e.g. slave keys are only garbage collected if their
+// modification revision did not change between two runs, and kvstore leases
+// are expired by the kvstore itself.
+//
+// Each kvstore operation of an allocation, e.g. acquiring the lock of the key,
+// listing the slave keys and creating the master and slave keys, is bounded
+// by the deadline of the caller's context or, in its absence, by the default
+// operation timeout of the allocator. An allocation stalled by a kvstore
+// partition thus fails and releases its locks instead of hanging indefinitely.
 package allocator
@@ -0,0 +1,174 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/lock"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// freezeWatcherChanSize is the size of the channel to buffer events
+	// of the freeze flag
+	freezeWatcherChanSize = 8
+)
+
+// FrozenError is returned when the allocation of a new ID is refused because
+// the allocator has been frozen cluster-wide with Freeze(). Keys which
+// already have an ID can still be allocated.
+type FrozenError struct {
+	// Reason is the reason given when the allocator was frozen
+	Reason string
+}
+
+// Error returns the error message
+func (e *FrozenError) Error() string {
+	return fmt.Sprintf("allocation of new IDs is frozen: %s", e.Reason)
+}
+
+// IsFrozen returns true if err was caused by a frozen allocator
+func IsFrozen(err error) bool {
+	_, ok := err.(*FrozenError)
+	return ok
+}
+
+// freezeState is the local view of the cluster-wide freeze flag
+type freezeState struct {
+	// mutex protects all fields below
+	mutex lock.RWMutex
+
+	// frozen is true while the freeze flag is set in the kvstore
+	frozen bool
+
+	// reason is the value of the freeze flag
+	reason string
+
+	// thawed is closed when the freeze flag is removed. It is replaced
+	// whenever the allocator is frozen again.
+	thawed chan struct{}
+}
+
+func newFreezeState() *freezeState {
+	thawed := make(chan struct{})
+	close(thawed)
+	return &freezeState{thawed: thawed}
+}
+
+// set updates the state to the freeze flag of the kvstore
+func (f *freezeState) set(frozen bool, reason string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch {
+	case frozen && !f.frozen:
+		f.thawed = make(chan struct{})
+	case !frozen && f.frozen:
+		close(f.thawed)
+	}
+	f.frozen, f.reason = frozen, reason
+}
+
+// check returns a channel which is closed once the allocator is thawed and a
+// FrozenError if the allocator is frozen
+func (f *freezeState) check() (<-chan struct{}, error) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	if f.frozen {
+		return f.thawed, &FrozenError{Reason: f.reason}
+	}
+	return f.thawed, nil
+}
+
+// Freeze sets the cluster-wide freeze flag of the allocator. All allocators
+// sharing the kvstore prefix stop creating new master keys and queue the
+// allocation of new IDs until Thaw() is called. Keys which already have an ID
+// can still be allocated. This is a brake for operators during incidents
+// affecting the ID space.
+func (a *Allocator) Freeze(ctx context.Context, reason string) error {
+	if err := kvstore.Update(ctx, a.freezeKey, []byte(reason), false); err != nil {
+		return fmt.Errorf("unable to set freeze flag '%s': %s", a.freezeKey, err)
+	}
+	return nil
+}
+
+// Thaw removes the cluster-wide freeze flag of the allocator set with
+// Freeze(). Queued allocations are retried automatically.
+func (a *Allocator) Thaw() error {
+	if err := kvstore.Delete(a.freezeKey); err != nil {
+		return fmt.Errorf("unable to remove freeze flag '%s': %s", a.freezeKey, err)
+	}
+	return nil
+}
+
+// checkFrozen returns a FrozenError if the allocation of new IDs is frozen
+func (a *Allocator) checkFrozen() error {
+	if a.freeze == nil {
+		return nil
+	}
+	_, err := a.freeze.check()
+	return err
+}
+
+// waitForThaw blocks until the allocator is thawed or ctx is cancelled. In
+// the latter case, the FrozenError is returned.
+func (a *Allocator) waitForThaw(ctx context.Context) error {
+	if a.freeze == nil {
+		return nil
+	}
+
+	thawed, err := a.freeze.check()
+	if err == nil {
+		return nil
+	}
+
+	select {
+	case <-thawed:
+		return nil
+	case <-ctx.Done():
+		return err
+	}
+}
+
+// startFreezeWatcher watches the freeze flag in the kvstore until the
+// allocator is deleted
+func (a *Allocator) startFreezeWatcher() {
+	a.freezeWatcher = kvstore.Client().ListAndWatch(a.freezeKey, a.freezeKey, freezeWatcherChanSize)
+
+	go func() {
+		for event := range a.freezeWatcher.Events {
+			// The watch is on a prefix, ignore keys sharing it
+			if event.Key != a.freezeKey {
+				continue
+			}
+
+			scopedLog := log.WithFields(logrus.Fields{fieldPrefix: a.idPrefix})
+			switch event.Typ {
+			case kvstore.EventTypeCreate, kvstore.EventTypeModify:
+				reason := string(event.Value)
+				a.freeze.set(true, reason)
+				scopedLog.WithField("reason", reason).Warning("Allocation of new IDs has been frozen cluster-wide")
+			case kvstore.EventTypeDelete:
+				a.freeze.set(false, "")
+				scopedLog.Info("Allocation of new IDs has been thawed, retrying queued allocations")
+			}
+		}
+	}()
+}
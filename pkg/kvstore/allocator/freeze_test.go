@@ -0,0 +1,165 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type FreezeSuite struct{}
+
+var _ = Suite(&FreezeSuite{})
+
+func (s *FreezeSuite) TestFreezeState(c *C) {
+	a := &Allocator{freeze: newFreezeState()}
+	c.Assert(a.checkFrozen(), IsNil)
+	c.Assert(a.waitForThaw(context.Background()), IsNil)
+
+	a.freeze.set(true, "identity incident")
+	err := a.checkFrozen()
+	c.Assert(IsFrozen(err), Equals, true)
+	c.Assert(err.(*FrozenError).Reason, Equals, "identity incident")
+
+	// Refreezing, e.g. to update the reason, keeps queued allocations
+	// waiting
+	a.freeze.set(true, "still investigating")
+	c.Assert(a.checkFrozen().(*FrozenError).Reason, Equals, "still investigating")
+
+	// Waiting is aborted with the FrozenError when the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	c.Assert(IsFrozen(a.waitForThaw(ctx)), Equals, true)
+
+	// Queued allocations continue once the allocator is thawed
+	done := make(chan error)
+	go func() { done <- a.waitForThaw(context.Background()) }()
+	a.freeze.set(false, "")
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("waitForThaw did not return after thaw")
+	}
+	c.Assert(a.checkFrozen(), IsNil)
+
+	// Allocators without freeze state, e.g. for garbage collection, are
+	// never frozen
+	c.Assert((&Allocator{}).checkFrozen(), IsNil)
+	c.Assert(IsFrozen(ErrShuttingDown), Equals, false)
+}
+
+// lookupTracer signals each lookup of the ID of a key
+type lookupTracer struct {
+	lookups chan string
+}
+
+func (t *lookupTracer) OnAllocateStart(key AllocatorKey) {}
+
+func (t *lookupTracer) OnKVStoreOp(key AllocatorKey, op TraceOp, duration time.Duration, err error) {
+	if op == TraceOpGet {
+		select {
+		case t.lookups <- key.GetKey():
+		default:
+		}
+	}
+}
+
+func (t *lookupTracer) OnAllocateDone(key AllocatorKey, id idpool.ID, isNew bool, duration time.Duration, err error) {
+}
+
+func (s *FreezeSuite) TestAllocateWhileFrozen(c *C) {
+	backend := newMemBackend()
+	chaosBackends.mutex.Lock()
+	chaosBackends.backend = backend
+	chaosBackends.mutex.Unlock()
+	kvstore.SetupDummy(chaosBackendName)
+	defer kvstore.Close()
+	defer kvstore.DeletePrefix("freeze-prefix")
+
+	tracer := &lookupTracer{lookups: make(chan string, 16)}
+	a, err := NewAllocator("freeze-prefix", TestType(""), WithMax(idpool.ID(256)),
+		WithSuffix("a"), WithoutGC(), WithBulkAllocationLimit(1), WithTracer(tracer))
+	c.Assert(err, IsNil)
+	defer a.Delete()
+
+	b, err := NewAllocator("freeze-prefix", TestType(""), WithMax(idpool.ID(256)),
+		WithSuffix("b"), WithoutGC())
+	c.Assert(err, IsNil)
+	defer b.Delete()
+
+	existingID, _, err := b.Allocate(context.Background(), TestType("existing"))
+	c.Assert(err, IsNil)
+
+	c.Assert(a.Freeze(context.Background(), "identity incident"), IsNil)
+	c.Assert(testutils.WaitUntil(func() bool { return IsFrozen(a.checkFrozen()) }, 5*time.Second), IsNil)
+	for len(tracer.lookups) > 0 {
+		<-tracer.lookups
+	}
+
+	type result struct {
+		id    idpool.ID
+		isNew bool
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		id, isNew, err := a.Allocate(context.Background(), TestType("new"))
+		done <- result{id, isNew, err}
+	}()
+
+	// The allocation is refused after the lookup of the key and must then
+	// release the bulk lane while it is queued
+	select {
+	case key := <-tracer.lookups:
+		c.Assert(key, Equals, "new")
+	case <-time.After(5 * time.Second):
+		c.Fatal("allocation did not look up the key")
+	}
+	c.Assert(testutils.WaitUntil(func() bool { return len(a.bulkLane) == 0 }, 5*time.Second), IsNil)
+
+	// Keys which already have an ID can be allocated through the lane
+	// meanwhile
+	id, isNew, err := a.Allocate(context.Background(), TestType("existing"))
+	c.Assert(err, IsNil)
+	c.Assert(isNew, Equals, false)
+	c.Assert(id, Equals, existingID)
+
+	select {
+	case r := <-done:
+		c.Fatalf("allocation completed while frozen: %+v", r)
+	default:
+	}
+
+	c.Assert(a.Thaw(), IsNil)
+	select {
+	case r := <-done:
+		c.Assert(r.err, IsNil)
+		c.Assert(r.isNew, Equals, true)
+		c.Assert(r.id, Not(Equals), idpool.NoID)
+		c.Assert(r.id, Not(Equals), existingID)
+	case <-time.After(5 * time.Second):
+		c.Fatal("queued allocation did not complete after thaw")
+	}
+	c.Assert(len(a.bulkLane), Equals, 0)
+}
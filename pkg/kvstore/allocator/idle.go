@@ -0,0 +1,193 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"sort"
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+// IdleID is an ID which has not been used for a period of time
+type IdleID struct {
+	// ID is the idle ID
+	ID idpool.ID
+
+	// Key is the key the ID is allocated to, if known
+	Key string
+
+	// LastUse is the time the ID was last seen in use. IDs which have not
+	// been seen in use since the allocator was created report the creation
+	// time of the allocator.
+	LastUse time.Time
+
+	// Users is the number of slave keys referencing the ID as observed by
+	// the last garbage collector run, or -1 if not observed
+	Users int
+}
+
+// idUsage is the usage observed for an ID
+type idUsage struct {
+	key      string
+	lastUse  time.Time
+	revision uint64
+	users    int
+}
+
+// usageTracker tracks the time IDs were last used. An ID counts as used
+// when it is allocated or released locally, when its master key is created
+// or modified, and when a slave key referencing it is created or modified
+// by any node.
+type usageTracker struct {
+	// start is the time tracking started. No usage is known before, IDs
+	// are thus never considered idle for longer than since start.
+	start time.Time
+
+	// mutex protects ids
+	mutex lock.Mutex
+
+	// ids maps IDs to their observed usage
+	ids map[idpool.ID]*idUsage
+}
+
+func newUsageTracker(now time.Time) *usageTracker {
+	return &usageTracker{
+		start: now,
+		ids:   map[idpool.ID]*idUsage{},
+	}
+}
+
+// get returns the usage of id, creating it if needed. Must be called with
+// mutex held.
+func (u *usageTracker) get(id idpool.ID) *idUsage {
+	usage, ok := u.ids[id]
+	if !ok {
+		usage = &idUsage{lastUse: u.start, users: -1}
+		u.ids[id] = usage
+	}
+	return usage
+}
+
+// touch records a use of id at time now
+func (u *usageTracker) touch(id idpool.ID, key string, now time.Time) {
+	u.mutex.Lock()
+	usage := u.get(id)
+	if key != "" {
+		usage.key = key
+	}
+	if now.After(usage.lastUse) {
+		usage.lastUse = now
+	}
+	u.mutex.Unlock()
+}
+
+// observe records the slave keys of id found by the garbage collector.
+// revision is the highest modification revision of all slave keys, the ID
+// counts as used if it increased since the last observation.
+func (u *usageTracker) observe(id idpool.ID, key string, users int, revision uint64, now time.Time) {
+	u.mutex.Lock()
+	usage := u.get(id)
+	usage.key = key
+	usage.users = users
+	if revision > usage.revision {
+		// The first observation only establishes the baseline
+		if usage.revision != 0 && now.After(usage.lastUse) {
+			usage.lastUse = now
+		}
+		usage.revision = revision
+	}
+	u.mutex.Unlock()
+}
+
+// forget removes id, e.g. because its master key has been deleted
+func (u *usageTracker) forget(id idpool.ID) {
+	u.mutex.Lock()
+	delete(u.ids, id)
+	u.mutex.Unlock()
+}
+
+// retain removes all IDs not in ids, e.g. because their master key has been
+// deleted by another node
+func (u *usageTracker) retain(ids map[idpool.ID]struct{}) {
+	u.mutex.Lock()
+	for id := range u.ids {
+		if _, ok := ids[id]; !ok {
+			delete(u.ids, id)
+		}
+	}
+	u.mutex.Unlock()
+}
+
+// idle returns all IDs not used within period before now for which inUse
+// returns false, sorted by ID
+func (u *usageTracker) idle(now time.Time, period time.Duration, inUse func(idpool.ID) bool) []IdleID {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	idle := []IdleID{}
+	for id, usage := range u.ids {
+		if now.Sub(usage.lastUse) < period || inUse(id) {
+			continue
+		}
+		idle = append(idle, IdleID{
+			ID:      id,
+			Key:     usage.key,
+			LastUse: usage.lastUse,
+			Users:   usage.users,
+		})
+	}
+
+	sort.Slice(idle, func(i, j int) bool { return idle[i].ID < idle[j].ID })
+	return idle
+}
+
+// touchID records a use of id if usage tracking is enabled
+func (a *Allocator) touchID(id idpool.ID, key string) {
+	if a.usage != nil && id != idpool.NoID {
+		a.usage.touch(id, key, time.Now())
+	}
+}
+
+// touchCachedID records a use of id on creation or modification of its
+// master key
+func (a *Allocator) touchCachedID(id idpool.ID, key AllocatorKey) {
+	var k string
+	if key != nil {
+		k = key.GetKey()
+	}
+	a.touchID(id, k)
+}
+
+// IdleIDs returns all IDs known to the allocator which have not been used
+// for at least period, sorted by ID. IDs referenced by local keys are always
+// considered in use. Usage is only tracked while the allocator exists, an
+// ID can thus not be reported idle before period has passed since the
+// allocator was created.
+//
+// The report is meant to support the planning of cleanups of the ID space.
+// Idle IDs may still be referenced, e.g. by policies or by long-lived users
+// which allocated them before, and must not be released based on this
+// report alone.
+func (a *Allocator) IdleIDs(period time.Duration) []IdleID {
+	if a.usage == nil {
+		return []IdleID{}
+	}
+
+	return a.usage.idle(time.Now(), period, func(id idpool.ID) bool {
+		return a.localKeys != nil && a.localKeys.lookupID(id) != ""
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+
+	. "gopkg.in/check.v1"
+)
+
+type IdleSuite struct{}
+
+var _ = Suite(&IdleSuite{})
+
+func (s *IdleSuite) TestUsageTracker(c *C) {
+	start := time.Now()
+	u := newUsageTracker(start)
+	never := func(idpool.ID) bool { return false }
+
+	u.touch(idpool.ID(1), "foo", start.Add(time.Minute))
+	u.observe(idpool.ID(2), "bar", 2, 10, start.Add(time.Minute))
+	u.observe(idpool.ID(3), "baz", 1, 20, start.Add(time.Minute))
+
+	// The first observation only establishes the baseline
+	idle := u.idle(start.Add(time.Minute), time.Minute, never)
+	c.Assert(idle, DeepEquals, []IdleID{
+		{ID: idpool.ID(2), Key: "bar", LastUse: start, Users: 2},
+		{ID: idpool.ID(3), Key: "baz", LastUse: start, Users: 1},
+	})
+
+	// Unchanged slave keys are no activity, modified slave keys are
+	u.observe(idpool.ID(2), "bar", 1, 10, start.Add(2*time.Minute))
+	u.observe(idpool.ID(3), "baz", 2, 30, start.Add(2*time.Minute))
+
+	idle = u.idle(start.Add(2*time.Minute), time.Minute, never)
+	c.Assert(idle, DeepEquals, []IdleID{
+		{ID: idpool.ID(1), Key: "foo", LastUse: start.Add(time.Minute), Users: -1},
+		{ID: idpool.ID(2), Key: "bar", LastUse: start, Users: 1},
+	})
+
+	// IDs in use are never reported
+	idle = u.idle(start.Add(2*time.Minute), time.Minute, func(id idpool.ID) bool { return id == 1 })
+	c.Assert(idle, HasLen, 1)
+	c.Assert(idle[0].ID, Equals, idpool.ID(2))
+
+	u.forget(idpool.ID(1))
+	u.retain(map[idpool.ID]struct{}{3: {}})
+	c.Assert(u.idle(start.Add(time.Hour), time.Minute, never), DeepEquals, []IdleID{
+		{ID: idpool.ID(3), Key: "baz", LastUse: start.Add(2 * time.Minute), Users: 2},
+	})
+}
+
+func (s *IdleSuite) TestIdleIDsSkipsLocalKeys(c *C) {
+	a := &Allocator{
+		localKeys: newLocalKeys(),
+		usage:     newUsageTracker(time.Now().Add(-time.Hour)),
+	}
+
+	a.usage.observe(idpool.ID(1), "foo", 1, 1, time.Now())
+	a.usage.observe(idpool.ID(2), "bar", 1, 1, time.Now())
+	_, err := a.localKeys.allocate("foo", idpool.ID(1))
+	c.Assert(err, IsNil)
+
+	idle := a.IdleIDs(time.Minute)
+	c.Assert(idle, HasLen, 1)
+	c.Assert(idle[0].ID, Equals, idpool.ID(2))
+
+	a.touchID(idpool.ID(2), "bar")
+	c.Assert(a.IdleIDs(time.Minute), HasLen, 0)
+}
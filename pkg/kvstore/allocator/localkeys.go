@@ -98,6 +98,15 @@ func (lk *localKeys) lookupKey(key string) idpool.ID {
 	return idpool.NoID
 }
 
+// lookupUse returns the local use of key or nil. A key released and
+// allocated again is represented by a different local use.
+func (lk *localKeys) lookupUse(key string) *localKey {
+	lk.RLock()
+	defer lk.RUnlock()
+
+	return lk.keys[key]
+}
+
 // lookupID returns the key for a given ID or an empty string
 func (lk *localKeys) lookupID(id idpool.ID) string {
 	lk.RLock()
@@ -15,9 +15,11 @@
 package allocator
 
 const (
-	fieldID     = "id"
-	fieldKey    = "key"
-	fieldPrefix = "prefix"
-	fieldValue  = "value"
-	fieldRefCnt = "refcnt"
+	fieldID       = "id"
+	fieldKey      = "key"
+	fieldPrefix   = "prefix"
+	fieldValue    = "value"
+	fieldRefCnt   = "refcnt"
+	fieldSuffix   = "suffix"
+	fieldPriority = "priority"
 )
@@ -0,0 +1,211 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MirrorChunk is a single document of the read-only mirror of an allocator
+// ID space. The mirror consists of Chunks documents stored under
+// <mirrorPrefix>/<Chunk>, each holding a subset of the ID to key mappings.
+type MirrorChunk struct {
+	// Chunk is the index of this chunk
+	Chunk int `json:"chunk"`
+
+	// Chunks is the total number of chunks of the mirror
+	Chunks int `json:"chunks"`
+
+	// IDs maps the allocated IDs to the key associated with each ID
+	IDs map[idpool.ID]string `json:"ids"`
+}
+
+// mirrorWriterKey is the key below the mirror prefix holding the suffix of
+// the allocator elected to write the mirror
+const mirrorWriterKey = "writer"
+
+// mirror writes a compacted copy of the allocator cache into the kvstore
+type mirror struct {
+	// prefix is the kvstore prefix under which the chunks are stored
+	prefix string
+
+	// chunkSize is the maximum number of IDs per chunk. If 0, the mirror
+	// is stored as a single chunk.
+	chunkSize int
+}
+
+// WithMirror enables writing a read-only mirror of the ID to key mappings
+// under the specified prefix on each run of the local key sync. Consumers can
+// read the entire ID space with ReadMirror() instead of listing all master
+// keys. If chunkSize is greater than 0, the mirror is split into multiple
+// documents of at most chunkSize IDs each. Of all allocators configured with
+// the same prefix, a single one is elected to write the mirror.
+func WithMirror(prefix string, chunkSize int) AllocatorOption {
+	return func(a *Allocator) { a.mirror = &mirror{prefix: prefix, chunkSize: chunkSize} }
+}
+
+// buildMirrorChunks splits the ID to key mappings into chunks of at most
+// chunkSize IDs. IDs are sorted so that the chunk contents remain stable as
+// long as the ID space does not change.
+func buildMirrorChunks(ids map[idpool.ID]string, chunkSize int) []MirrorChunk {
+	sorted := make([]idpool.ID, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	if chunkSize <= 0 || chunkSize > len(sorted) {
+		chunkSize = len(sorted)
+	}
+
+	numChunks := 1
+	if chunkSize > 0 {
+		numChunks = (len(sorted) + chunkSize - 1) / chunkSize
+	}
+
+	chunks := make([]MirrorChunk, numChunks)
+	for i := range chunks {
+		chunks[i] = MirrorChunk{
+			Chunk:  i,
+			Chunks: numChunks,
+			IDs:    map[idpool.ID]string{},
+		}
+	}
+
+	for i, id := range sorted {
+		chunks[i/chunkSize].IDs[id] = ids[id]
+	}
+
+	return chunks
+}
+
+// elect returns true if a is the allocator elected to write the mirror. The
+// writer key is attached to the kvstore lease of the writer so that another
+// allocator takes over as soon as the writer is gone.
+func (m *mirror) elect(a *Allocator) (bool, error) {
+	key := path.Join(m.prefix, mirrorWriterKey)
+	if _, err := kvstore.CreateOnly(context.TODO(), key, []byte(a.suffix), true); err != nil {
+		return false, fmt.Errorf("unable to create mirror writer key %s: %s", key, err)
+	}
+
+	writer, err := kvstore.Get(key)
+	if err != nil {
+		return false, fmt.Errorf("unable to read mirror writer key %s: %s", key, err)
+	}
+
+	return string(writer) == a.suffix, nil
+}
+
+// sync writes the current content of the allocator cache to the mirror if a
+// is the elected writer
+func (m *mirror) sync(a *Allocator) error {
+	if elected, err := m.elect(a); err != nil || !elected {
+		return err
+	}
+
+	ids := map[idpool.ID]string{}
+	a.mainCache.foreach(func(id idpool.ID, key AllocatorKey) {
+		if key != nil {
+			ids[id] = key.GetKey()
+		}
+	})
+
+	chunks := buildMirrorChunks(ids, m.chunkSize)
+	for _, chunk := range chunks {
+		value, err := json.Marshal(chunk)
+		if err != nil {
+			return fmt.Errorf("unable to marshal mirror chunk: %s", err)
+		}
+
+		key := path.Join(m.prefix, strconv.Itoa(chunk.Chunk))
+		if _, err := kvstore.UpdateIfDifferent(context.TODO(), key, value, false); err != nil {
+			return fmt.Errorf("unable to write mirror chunk %s: %s", key, err)
+		}
+	}
+
+	// Remove chunks left behind by a previous, larger mirror. The
+	// existing chunks are listed as the previous mirror may have been
+	// written by another writer or before a restart.
+	pairs, err := kvstore.ListPrefix(m.prefix + "/")
+	if err != nil {
+		return fmt.Errorf("unable to list mirror chunks: %s", err)
+	}
+	for key := range pairs {
+		chunk, err := strconv.Atoi(path.Base(key))
+		if err != nil || chunk < len(chunks) {
+			continue
+		}
+		if err := kvstore.Delete(key); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{fieldKey: key}).
+				Warning("Unable to delete stale mirror chunk")
+		}
+	}
+
+	return nil
+}
+
+// ReadMirror reads the mirror of an allocator ID space written under the
+// specified prefix and returns the ID to key mappings. All chunks are read
+// with a single listing of the prefix so that they reflect the same kvstore
+// revision.
+func ReadMirror(prefix string) (map[idpool.ID]string, error) {
+	pairs, err := kvstore.ListPrefix(prefix + "/")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list mirror chunks: %s", err)
+	}
+
+	chunks := map[int]MirrorChunk{}
+	for key, value := range pairs {
+		i, err := strconv.Atoi(path.Base(key))
+		if err != nil || path.Dir(key) != prefix {
+			continue
+		}
+
+		var chunk MirrorChunk
+		if err := json.Unmarshal(value.Data, &chunk); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal mirror chunk %s: %s", key, err)
+		}
+		chunks[i] = chunk
+	}
+
+	ids := map[idpool.ID]string{}
+	for i, numChunks := 0, 1; i < numChunks; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			return nil, fmt.Errorf("mirror chunk %s not found", path.Join(prefix, strconv.Itoa(i)))
+		}
+		if i > 0 && chunk.Chunks != numChunks {
+			return nil, fmt.Errorf("mirror chunk %s is part of a mirror of %d chunks instead of %d",
+				path.Join(prefix, strconv.Itoa(i)), chunk.Chunks, numChunks)
+		}
+
+		numChunks = chunk.Chunks
+		for id, k := range chunk.IDs {
+			ids[id] = k
+		}
+	}
+
+	return ids, nil
+}
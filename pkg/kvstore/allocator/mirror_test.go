@@ -0,0 +1,136 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"encoding/json"
+	"path"
+	"strconv"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	. "gopkg.in/check.v1"
+)
+
+type MirrorSuite struct {
+	backend *memBackend
+}
+
+var _ = Suite(&MirrorSuite{})
+
+func (s *MirrorSuite) SetUpTest(c *C) {
+	s.backend = newMemBackend()
+	chaosBackends.mutex.Lock()
+	chaosBackends.backend = s.backend
+	chaosBackends.mutex.Unlock()
+	kvstore.SetupDummy(chaosBackendName)
+}
+
+func (s *MirrorSuite) TearDownTest(c *C) {
+	kvstore.Close()
+}
+
+func (s *MirrorSuite) TestBuildMirrorChunks(c *C) {
+	ids := map[idpool.ID]string{1: "a", 2: "b", 3: "c", 4: "d", 5: "e"}
+
+	chunks := buildMirrorChunks(ids, 0)
+	c.Assert(len(chunks), Equals, 1)
+	c.Assert(chunks[0].IDs, DeepEquals, ids)
+
+	chunks = buildMirrorChunks(ids, 2)
+	c.Assert(len(chunks), Equals, 3)
+	c.Assert(chunks[0].IDs, DeepEquals, map[idpool.ID]string{1: "a", 2: "b"})
+	c.Assert(chunks[1].IDs, DeepEquals, map[idpool.ID]string{3: "c", 4: "d"})
+	c.Assert(chunks[2].IDs, DeepEquals, map[idpool.ID]string{5: "e"})
+	for i, chunk := range chunks {
+		c.Assert(chunk.Chunk, Equals, i)
+		c.Assert(chunk.Chunks, Equals, 3)
+	}
+
+	chunks = buildMirrorChunks(map[idpool.ID]string{}, 2)
+	c.Assert(len(chunks), Equals, 1)
+	c.Assert(len(chunks[0].IDs), Equals, 0)
+}
+
+func (s *MirrorSuite) TestMirrorChunkJSON(c *C) {
+	chunk := buildMirrorChunks(map[idpool.ID]string{1000: "foo"}, 0)[0]
+
+	out, err := json.Marshal(chunk)
+	c.Assert(err, IsNil)
+
+	var decoded MirrorChunk
+	c.Assert(json.Unmarshal(out, &decoded), IsNil)
+	c.Assert(decoded, DeepEquals, chunk)
+}
+
+func (s *MirrorSuite) TestMirrorSingleWriter(c *C) {
+	m := &mirror{prefix: "test/mirror", chunkSize: 1}
+	a := &Allocator{suffix: "a", mainCache: cache{cache: idMap{1: TestType("foo")}}}
+	b := &Allocator{suffix: "b", mainCache: cache{cache: idMap{1: TestType("bar"), 2: TestType("baz")}}}
+
+	// Chunks of a larger mirror written before a restart must be removed
+	s.backend.set("test/mirror/3", []byte("{}"))
+
+	c.Assert(m.sync(a), IsNil)
+	c.Assert(m.sync(b), IsNil)
+
+	ids, err := ReadMirror("test/mirror")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, map[idpool.ID]string{1: "foo"})
+
+	pairs, err := kvstore.ListPrefix("test/mirror/")
+	c.Assert(err, IsNil)
+	c.Assert(len(pairs), Equals, 2)
+	_, ok := pairs["test/mirror/"+mirrorWriterKey]
+	c.Assert(ok, Equals, true)
+	_, ok = pairs["test/mirror/0"]
+	c.Assert(ok, Equals, true)
+
+	// Once the writer is gone, another allocator takes over
+	c.Assert(kvstore.Delete("test/mirror/"+mirrorWriterKey), IsNil)
+	c.Assert(m.sync(b), IsNil)
+
+	ids, err = ReadMirror("test/mirror")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, map[idpool.ID]string{1: "bar", 2: "baz"})
+}
+
+func (s *MirrorSuite) TestReadMirrorInconsistent(c *C) {
+	chunks := buildMirrorChunks(map[idpool.ID]string{1: "foo", 2: "bar"}, 1)
+	for _, chunk := range chunks {
+		value, err := json.Marshal(chunk)
+		c.Assert(err, IsNil)
+		s.backend.set(path.Join("test/mirror", strconv.Itoa(chunk.Chunk)), value)
+	}
+
+	ids, err := ReadMirror("test/mirror")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, map[idpool.ID]string{1: "foo", 2: "bar"})
+
+	// A chunk of a mirror with a different number of chunks must not be
+	// merged into the result
+	value, err := json.Marshal(buildMirrorChunks(map[idpool.ID]string{1: "foo", 2: "bar", 3: "baz"}, 1)[0])
+	c.Assert(err, IsNil)
+	s.backend.set("test/mirror/0", value)
+	_, err = ReadMirror("test/mirror")
+	c.Assert(err, Not(IsNil))
+
+	c.Assert(kvstore.Delete("test/mirror/0"), IsNil)
+	_, err = ReadMirror("test/mirror")
+	c.Assert(err, Not(IsNil))
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cilium/cilium/pkg/backoff"
+)
+
+// AllocationPriority is the priority hint passed to AllocateWithPriority()
+type AllocationPriority int
+
+const (
+	// PriorityNormal is the priority of bulk allocations, e.g. caused by
+	// pod churn. Allocations of this priority are subject to the bulk
+	// allocation limit.
+	PriorityNormal AllocationPriority = iota
+
+	// PriorityCritical is the priority of allocations which must not wait
+	// behind bulk allocations, e.g. for host, remote-node or
+	// system-critical endpoints. Allocations of this priority bypass the
+	// bulk allocation limit and retry with a shorter backoff.
+	PriorityCritical
+)
+
+// String returns the name of the priority
+func (p AllocationPriority) String() string {
+	switch p {
+	case PriorityCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+// criticalBackoffTemplate is the backoff configuration used while
+// allocating keys with PriorityCritical
+var criticalBackoffTemplate = backoff.Exponential{
+	Min:    time.Duration(5) * time.Millisecond,
+	Max:    time.Duration(500) * time.Millisecond,
+	Factor: 2.0,
+}
+
+// WithBulkAllocationLimit limits the number of PriorityNormal allocations
+// which can be performed against the kvstore in parallel. Additional
+// allocations wait for a slot to become available. PriorityCritical
+// allocations are never subject to the limit.
+func WithBulkAllocationLimit(limit int) AllocatorOption {
+	return func(a *Allocator) {
+		if limit > 0 {
+			a.bulkLane = make(chan struct{}, limit)
+		}
+	}
+}
+
+// acquireLane blocks until the allocation of the given priority may proceed
+// against the kvstore. The returned function must be called to release the
+// lane after the allocation has completed.
+func (a *Allocator) acquireLane(ctx context.Context, prio AllocationPriority) (func(), error) {
+	if prio == PriorityCritical || a.bulkLane == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case a.bulkLane <- struct{}{}:
+		return func() { <-a.bulkLane }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("allocation was cancelled while waiting for a bulk allocation slot: %s", ctx.Err())
+	}
+}
+
+// backoffFor returns a copy of the backoff template to use for an allocation
+// of the given priority
+func (a *Allocator) backoffFor(prio AllocationPriority) backoff.Exponential {
+	if prio == PriorityCritical {
+		return criticalBackoffTemplate
+	}
+	return a.backoffTemplate
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/metrics"
+)
+
+const (
+	// minQuarantineCheckInterval is the lower bound of the interval in
+	// which quarantined IDs are checked for expiration
+	minQuarantineCheckInterval = time.Second
+)
+
+// quarantine holds released IDs for a period of time before returning them
+// to the ID pool. This prevents the immediate reuse of an ID which may still
+// be referenced by other nodes, e.g. in BPF policy maps which have not yet
+// been updated.
+type quarantine struct {
+	// period is the time an ID is kept in quarantine
+	period time.Duration
+
+	// mutex protects ids
+	mutex lock.Mutex
+
+	// ids maps quarantined IDs to the time they have been released
+	ids map[idpool.ID]time.Time
+}
+
+// WithQuarantine enables the quarantine of released IDs. An ID released in
+// the kvstore is only returned to the pool of available IDs after period has
+// passed.
+func WithQuarantine(period time.Duration) AllocatorOption {
+	return func(a *Allocator) {
+		if period > 0 {
+			a.quarantine = newQuarantine(period)
+		}
+	}
+}
+
+func newQuarantine(period time.Duration) *quarantine {
+	return &quarantine{
+		period: period,
+		ids:    map[idpool.ID]time.Time{},
+	}
+}
+
+// add places id into quarantine. The release time of an ID already in
+// quarantine is not modified.
+func (q *quarantine) add(id idpool.ID, now time.Time) {
+	q.mutex.Lock()
+	if _, ok := q.ids[id]; !ok {
+		q.ids[id] = now
+	}
+	q.mutex.Unlock()
+}
+
+// remove removes id from quarantine, e.g. because the ID has been allocated
+// again by another node. Returns true if the ID was quarantined.
+func (q *quarantine) remove(id idpool.ID) bool {
+	q.mutex.Lock()
+	_, ok := q.ids[id]
+	delete(q.ids, id)
+	q.mutex.Unlock()
+	return ok
+}
+
+// expire removes and returns all IDs quarantined for at least the quarantine
+// period. Also returns the number of IDs remaining in quarantine and the age
+// of the oldest of them.
+func (q *quarantine) expire(now time.Time) (expired []idpool.ID, remaining int, oldest time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for id, released := range q.ids {
+		age := now.Sub(released)
+		if age < 0 {
+			// The clock went backwards, which is only possible for
+			// times lacking a monotonic clock reading. Restart the
+			// quarantine period instead of holding the ID until the
+			// wall clock catches up.
+			q.ids[id] = now
+			age = 0
+		}
+		if age >= q.period {
+			expired = append(expired, id)
+			delete(q.ids, id)
+		} else if age > oldest {
+			oldest = age
+		}
+	}
+
+	return expired, len(q.ids), oldest
+}
+
+// checkInterval returns the interval in which the quarantine is checked for
+// expired IDs
+func (q *quarantine) checkInterval() time.Duration {
+	interval := q.period / 10
+	if interval < minQuarantineCheckInterval {
+		interval = minQuarantineCheckInterval
+	}
+	return interval
+}
+
+// releaseID returns an ID released in the kvstore to the ID pool, either
+// directly or, if enabled, after the quarantine period.
+func (a *Allocator) releaseID(id idpool.ID) {
+	if a.quarantine != nil {
+		a.quarantine.add(id, time.Now())
+		return
+	}
+	a.idPool.Insert(id)
+}
+
+// reserveID removes an ID allocated in the kvstore from the ID pool and from
+// the quarantine.
+func (a *Allocator) reserveID(id idpool.ID) {
+	if a.quarantine != nil {
+		a.quarantine.remove(id)
+	}
+	a.idPool.Remove(id)
+}
+
+// expireQuarantine returns all IDs whose quarantine period has passed to the
+// ID pool and reports the state of the quarantine to the metrics.
+func (a *Allocator) expireQuarantine() {
+	expired, remaining, oldest := a.quarantine.expire(time.Now())
+	for _, id := range expired {
+		a.idPool.Insert(id)
+	}
+
+	metrics.KVStoreAllocatorQuarantineIDs.WithLabelValues(a.idPrefix).Set(float64(remaining))
+	metrics.KVStoreAllocatorQuarantineOldest.WithLabelValues(a.idPrefix).Set(oldest.Seconds())
+}
+
+// startQuarantineExpiration periodically returns IDs whose quarantine period
+// has passed to the ID pool until the allocator is deleted.
+func (a *Allocator) startQuarantineExpiration() {
+	go func() {
+		for {
+			select {
+			case <-a.stopGC:
+				return
+			case <-time.After(a.quarantine.checkInterval()):
+				a.expireQuarantine()
+			}
+		}
+	}()
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+
+	. "gopkg.in/check.v1"
+)
+
+type QuarantineSuite struct{}
+
+var _ = Suite(&QuarantineSuite{})
+
+func (s *QuarantineSuite) TestQuarantineExpire(c *C) {
+	q := newQuarantine(time.Minute)
+	now := time.Now()
+
+	q.add(idpool.ID(1), now.Add(-2*time.Minute))
+	q.add(idpool.ID(2), now.Add(-30*time.Second))
+	q.add(idpool.ID(3), now)
+
+	// Re-adding an ID must not reset its release time
+	q.add(idpool.ID(1), now)
+
+	expired, remaining, oldest := q.expire(now)
+	c.Assert(expired, DeepEquals, []idpool.ID{1})
+	c.Assert(remaining, Equals, 2)
+	c.Assert(oldest, Equals, 30*time.Second)
+
+	c.Assert(q.remove(idpool.ID(2)), Equals, true)
+	c.Assert(q.remove(idpool.ID(2)), Equals, false)
+
+	expired, remaining, _ = q.expire(now.Add(time.Minute))
+	c.Assert(expired, DeepEquals, []idpool.ID{3})
+	c.Assert(remaining, Equals, 0)
+}
+
+func (s *QuarantineSuite) TestQuarantineClockBackwards(c *C) {
+	q := newQuarantine(time.Minute)
+	// Strip the monotonic clock reading to simulate a wall clock step
+	now := time.Now().Round(0)
+
+	q.add(idpool.ID(1), now.Add(time.Hour))
+
+	// The quarantine period restarts instead of lasting another hour
+	expired, remaining, oldest := q.expire(now)
+	c.Assert(expired, HasLen, 0)
+	c.Assert(remaining, Equals, 1)
+	c.Assert(oldest, Equals, time.Duration(0))
+
+	expired, _, _ = q.expire(now.Add(time.Minute))
+	c.Assert(expired, DeepEquals, []idpool.ID{1})
+}
+
+func (s *QuarantineSuite) TestQuarantineDelaysReuse(c *C) {
+	a := &Allocator{
+		min:    idpool.ID(1),
+		max:    idpool.ID(1),
+		idPool: idpool.NewIDPool(idpool.ID(1), idpool.ID(1)),
+	}
+	WithQuarantine(time.Hour)(a)
+
+	a.reserveID(idpool.ID(1))
+	c.Assert(a.idPool.LeaseAvailableID(), Equals, idpool.NoID)
+
+	// The released ID must not be available until the quarantine expired
+	a.releaseID(idpool.ID(1))
+	a.expireQuarantine()
+	c.Assert(a.idPool.LeaseAvailableID(), Equals, idpool.NoID)
+
+	a.quarantine.period = 0
+	a.expireQuarantine()
+	c.Assert(a.idPool.LeaseAvailableID(), Equals, idpool.ID(1))
+}
+
+func (s *QuarantineSuite) TestQuarantineCheckInterval(c *C) {
+	c.Assert(newQuarantine(time.Second).checkInterval(), Equals, minQuarantineCheckInterval)
+	c.Assert(newQuarantine(time.Hour).checkInterval(), Equals, 6*time.Minute)
+}
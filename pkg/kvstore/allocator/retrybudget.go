@@ -0,0 +1,146 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/metrics"
+)
+
+const (
+	// retryBudgetRefillRate is the number of retries per second added to
+	// the budget regardless of the outcome of allocations
+	retryBudgetRefillRate = 1.0
+
+	// retryBudgetSuccessDeposit is the number of retries added to the
+	// budget for each successful allocation
+	retryBudgetSuccessDeposit = 0.5
+)
+
+// retryBudget is a token bucket limiting the number of allocation retries
+// shared by all concurrent allocations of an allocator. Each retry withdraws
+// a token, successful allocations and the passing of time deposit tokens.
+// Under sustained failure, e.g. during kvstore incidents, the budget is
+// depleted and retries are shed instead of multiplying the load on the
+// kvstore. The budget recovers gradually once allocations succeed again.
+type retryBudget struct {
+	// mutex protects all fields below
+	mutex lock.Mutex
+
+	// max is the maximum number of tokens in the budget
+	max float64
+
+	// tokens is the number of retries currently available
+	tokens float64
+
+	// lastRefill is the last time tokens were added based on the
+	// refill rate
+	lastRefill time.Time
+}
+
+// WithRetryBudget sets the number of allocation retries which can be
+// performed in a burst across all concurrent allocations of the allocator.
+// Retries exceeding the budget are shed and the allocation fails. Only
+// attempts which failed with a kvstore error consume the budget, attempts
+// which lost the race for an ID against another writer are always retried.
+// A budget of 0, the default, disables the retry budget.
+func WithRetryBudget(retries int) AllocatorOption {
+	return func(a *Allocator) {
+		if retries > 0 {
+			a.retryBudget = newRetryBudget(retries)
+		} else {
+			a.retryBudget = nil
+		}
+	}
+}
+
+func newRetryBudget(retries int) *retryBudget {
+	return &retryBudget{
+		max:        float64(retries),
+		tokens:     float64(retries),
+		lastRefill: time.Now(),
+	}
+}
+
+// refill adds tokens based on the time passed since the last refill. The
+// mutex must be held.
+func (b *retryBudget) refill(now time.Time) {
+	if elapsed := now.Sub(b.lastRefill); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * retryBudgetRefillRate
+		if b.tokens > b.max {
+			b.tokens = b.max
+		}
+	}
+	b.lastRefill = now
+}
+
+// withdraw takes a token for a retry out of the budget. Returns false if the
+// budget is exhausted and the retry must be shed.
+func (b *retryBudget) withdraw(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// deposit adds tokens to the budget for a successful allocation
+func (b *retryBudget) deposit(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill(now)
+	b.tokens += retryBudgetSuccessDeposit
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// conflictError is returned by an allocation attempt which lost the race for
+// an ID against another writer. Such attempts did not fail because of the
+// kvstore and are retried without consuming the retry budget.
+type conflictError struct {
+	error
+}
+
+// allowRetry returns true if an allocation of the given priority which failed
+// with err may be retried. Allocations with PriorityCritical and attempts
+// which lost the race for an ID are never shed.
+func (a *Allocator) allowRetry(prio AllocationPriority, err error) bool {
+	if _, conflict := err.(conflictError); conflict {
+		return true
+	}
+	if a.retryBudget == nil || prio == PriorityCritical {
+		return true
+	}
+	if !a.retryBudget.withdraw(time.Now()) {
+		metrics.KVStoreAllocatorRetriesShed.WithLabelValues(a.idPrefix).Inc()
+		return false
+	}
+	return true
+}
+
+// retrySucceeded replenishes the retry budget after a successful allocation
+func (a *Allocator) retrySucceeded() {
+	if a.retryBudget != nil {
+		a.retryBudget.deposit(time.Now())
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RetryBudgetSuite struct{}
+
+var _ = Suite(&RetryBudgetSuite{})
+
+func (s *RetryBudgetSuite) TestRetryBudget(c *C) {
+	b := newRetryBudget(2)
+	now := b.lastRefill
+
+	// Sustained failure depletes the budget
+	c.Assert(b.withdraw(now), Equals, true)
+	c.Assert(b.withdraw(now), Equals, true)
+	c.Assert(b.withdraw(now), Equals, false)
+
+	// Successful allocations recover the budget gradually
+	b.deposit(now)
+	c.Assert(b.withdraw(now), Equals, false)
+	b.deposit(now)
+	c.Assert(b.withdraw(now), Equals, true)
+	c.Assert(b.withdraw(now), Equals, false)
+
+	// The budget is refilled over time, up to its maximum
+	now = now.Add(time.Hour)
+	c.Assert(b.withdraw(now), Equals, true)
+	c.Assert(b.withdraw(now), Equals, true)
+	c.Assert(b.withdraw(now), Equals, false)
+}
+
+func (s *RetryBudgetSuite) TestAllowRetry(c *C) {
+	a := &Allocator{}
+	backendErr := errors.New("kvstore unavailable")
+	c.Assert(a.allowRetry(PriorityNormal, backendErr), Equals, true)
+
+	WithRetryBudget(1)(a)
+	a.retryBudget.lastRefill = time.Now().Add(time.Hour)
+
+	c.Assert(a.allowRetry(PriorityNormal, backendErr), Equals, true)
+	c.Assert(a.allowRetry(PriorityNormal, backendErr), Equals, false)
+
+	// Critical allocations are never shed
+	c.Assert(a.allowRetry(PriorityCritical, backendErr), Equals, true)
+
+	// Lost races for an ID do not consume the budget
+	c.Assert(a.allowRetry(PriorityNormal, conflictError{errors.New("key exists")}), Equals, true)
+
+	WithRetryBudget(0)(a)
+	c.Assert(a.allowRetry(PriorityNormal, backendErr), Equals, true)
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+)
+
+const (
+	// defaultOperationTimeout is the default timeout of each kvstore
+	// operation performed by the allocator if the context of the caller
+	// has no deadline
+	defaultOperationTimeout = time.Minute
+)
+
+// WithDefaultOperationTimeout sets the timeout applied to each kvstore
+// operation performed by the allocator, e.g. acquiring a lock, creating a key
+// or listing a prefix, if the context passed by the caller has no deadline.
+// This bounds the time an allocation can hang during a kvstore partition while
+// holding locks. A timeout of 0 disables the default timeout.
+func WithDefaultOperationTimeout(timeout time.Duration) AllocatorOption {
+	return func(a *Allocator) { a.operationTimeout = timeout }
+}
+
+// operationContext returns the context of a single kvstore operation. If ctx
+// has no deadline, the default operation timeout of the allocator is applied.
+// The returned cancel function must be called once the operation completed.
+func (a *Allocator) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || a.operationTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, a.operationTimeout)
+}
+
+// listPrefix lists all keys matching prefix, optionally only if lock is still
+// held. The kvstore list operations do not accept a context, the list is
+// abandoned if the context of the operation expires before it completes.
+func (a *Allocator) listPrefix(ctx context.Context, prefix string, lock kvstore.KVLocker) (kvstore.KeyValuePairs, error) {
+	ctx, cancel := a.operationContext(ctx)
+	defer cancel()
+
+	type listResult struct {
+		pairs kvstore.KeyValuePairs
+		err   error
+	}
+
+	done := make(chan listResult, 1)
+	go func() {
+		var r listResult
+		if lock != nil {
+			r.pairs, r.err = kvstore.ListPrefixIfLocked(prefix, lock)
+		} else {
+			r.pairs, r.err = kvstore.ListPrefix(prefix)
+		}
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return r.pairs, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("listing prefix %s cancelled: %s", prefix, ctx.Err())
+	}
+}
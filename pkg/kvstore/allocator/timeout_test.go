@@ -0,0 +1,61 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type TimeoutSuite struct{}
+
+var _ = Suite(&TimeoutSuite{})
+
+func (s *TimeoutSuite) TestOperationContext(c *C) {
+	a := &Allocator{operationTimeout: defaultOperationTimeout}
+
+	// The default timeout is applied to contexts without deadline
+	ctx, cancel := a.operationContext(context.Background())
+	deadline, ok := ctx.Deadline()
+	c.Assert(ok, Equals, true)
+	c.Assert(time.Until(deadline) <= defaultOperationTimeout, Equals, true)
+	cancel()
+	c.Assert(ctx.Err(), Equals, context.Canceled)
+
+	// The deadline of the caller takes precedence
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer parentCancel()
+	ctx, cancel = a.operationContext(parent)
+	c.Assert(ctx, Equals, parent)
+	cancel()
+	c.Assert(parent.Err(), IsNil)
+
+	// A timeout of 0 disables the default timeout
+	WithDefaultOperationTimeout(0)(a)
+	ctx, cancel = a.operationContext(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	c.Assert(ok, Equals, false)
+
+	WithDefaultOperationTimeout(time.Millisecond)(a)
+	ctx, cancel = a.operationContext(context.Background())
+	defer cancel()
+	<-ctx.Done()
+	c.Assert(ctx.Err(), Equals, context.DeadlineExceeded)
+}
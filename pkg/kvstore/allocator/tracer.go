@@ -0,0 +1,145 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+)
+
+// TraceOp is the kind of kvstore operation reported to Tracer.OnKVStoreOp()
+type TraceOp string
+
+const (
+	// TraceOpLock is the acquisition of the distributed lock protecting
+	// the key
+	TraceOpLock TraceOp = "lock"
+
+	// TraceOpGet is the lookup of the ID currently associated with the key
+	TraceOpGet TraceOp = "get"
+
+	// TraceOpCreateMasterKey is the creation of the master key
+	TraceOpCreateMasterKey TraceOp = "create-master-key"
+
+	// TraceOpCreateSlaveKey is the creation of the slave key
+	TraceOpCreateSlaveKey TraceOp = "create-slave-key"
+)
+
+// Tracer is notified about the lifecycle of allocations. It allows to
+// attribute the latency of an allocation to lock waits and individual
+// kvstore operations. All functions are called synchronously from the
+// allocating goroutine and must not block.
+type Tracer interface {
+	// OnAllocateStart is called when the allocation of key starts
+	OnAllocateStart(key AllocatorKey)
+
+	// OnKVStoreOp is called after each kvstore operation performed while
+	// allocating key. A single allocation may perform an operation
+	// multiple times if attempts are retried.
+	OnKVStoreOp(key AllocatorKey, op TraceOp, duration time.Duration, err error)
+
+	// OnAllocateDone is called when the allocation of key has completed,
+	// successfully or not
+	OnAllocateDone(key AllocatorKey, id idpool.ID, isNew bool, duration time.Duration, err error)
+}
+
+// WithTracer sets a tracer to be notified about the lifecycle of allocations.
+// If the tracer also implements SpanTracer, it is used to emit spans for the
+// housekeeping routines as well.
+func WithTracer(tracer Tracer) AllocatorOption {
+	return func(a *Allocator) {
+		a.tracer = tracer
+		if spanTracer, ok := tracer.(SpanTracer); ok {
+			a.spanTracer = spanTracer
+		}
+	}
+}
+
+// traceKVStoreOp reports a kvstore operation started at start to the tracer,
+// if configured
+func (a *Allocator) traceKVStoreOp(key AllocatorKey, op TraceOp, start time.Time, err error) {
+	if a.tracer != nil {
+		a.tracer.OnKVStoreOp(key, op, time.Since(start), err)
+	}
+}
+
+// HousekeepingOp is a housekeeping routine of the allocator reported to
+// SpanTracer.StartSpan()
+type HousekeepingOp string
+
+const (
+	// HousekeepingGC is a run of the master key garbage collector
+	HousekeepingGC HousekeepingOp = "gc"
+
+	// HousekeepingSyncLocalKeys is a run of the local key sync which
+	// recreates missing master keys of local allocations
+	HousekeepingSyncLocalKeys HousekeepingOp = "sync-local-keys"
+
+	// HousekeepingCacheList is the initial listing of all IDs when the
+	// cache starts watching the kvstore
+	HousekeepingCacheList HousekeepingOp = "cache-list"
+)
+
+// Attributes attached to housekeeping spans
+const (
+	// SpanAttrKeys is the number of keys processed
+	SpanAttrKeys = "keys"
+
+	// SpanAttrStale is the number of keys found stale
+	SpanAttrStale = "stale"
+
+	// SpanAttrDeleted is the number of keys deleted
+	SpanAttrDeleted = "deleted"
+)
+
+// Span is an in-progress trace span of a housekeeping routine
+type Span interface {
+	// SetAttribute attaches a numeric attribute to the span
+	SetAttribute(key string, value int64)
+
+	// End completes the span. A non-nil err marks the span as failed.
+	End(err error)
+}
+
+// SpanTracer emits trace spans for the housekeeping routines of the
+// allocator, e.g. as OpenTelemetry spans.
+// This allows cluster-wide traces to show which housekeeping routines contend
+// with latency sensitive allocations.
+type SpanTracer interface {
+	// StartSpan starts a span for a run of op in the allocator of the
+	// given kvstore prefix
+	StartSpan(op HousekeepingOp, prefix string) Span
+}
+
+// WithSpanTracer sets a tracer to emit spans for the housekeeping routines.
+// Without a span tracer, no spans are emitted.
+func WithSpanTracer(tracer SpanTracer) AllocatorOption {
+	return func(a *Allocator) { a.spanTracer = tracer }
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value int64) {}
+func (noopSpan) End(err error)                        {}
+
+// startSpan starts a span for op with the configured span tracer. A no-op
+// span is returned if no span tracer is configured.
+func (a *Allocator) startSpan(op HousekeepingOp) Span {
+	if a.spanTracer == nil {
+		return noopSpan{}
+	}
+	return a.spanTracer.StartSpan(op, a.idPrefix)
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package allocator
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/cilium/pkg/idpool"
+	"github.com/cilium/cilium/pkg/lock"
+
+	. "gopkg.in/check.v1"
+)
+
+type recordingTracer struct {
+	mutex  lock.Mutex
+	events []string
+}
+
+func (t *recordingTracer) record(event string) {
+	t.mutex.Lock()
+	t.events = append(t.events, event)
+	t.mutex.Unlock()
+}
+
+func (t *recordingTracer) OnAllocateStart(key AllocatorKey) {
+	t.record("start")
+}
+
+func (t *recordingTracer) OnKVStoreOp(key AllocatorKey, op TraceOp, duration time.Duration, err error) {
+	t.record(string(op))
+}
+
+func (t *recordingTracer) OnAllocateDone(key AllocatorKey, id idpool.ID, isNew bool, duration time.Duration, err error) {
+	t.record("done")
+}
+
+func (s *AllocatorSuite) TestTracer(c *C) {
+	tracer := &recordingTracer{}
+	allocator, err := NewAllocator(randomTestName(), TestType(""), WithMax(idpool.ID(256)),
+		WithSuffix("a"), WithoutGC(), WithTracer(tracer))
+	c.Assert(err, IsNil)
+	defer allocator.DeleteAllKeys()
+	defer allocator.Delete()
+
+	_, isNew, err := allocator.Allocate(context.Background(), TestType("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(isNew, Equals, true)
+
+	c.Assert(tracer.events, DeepEquals, []string{
+		"start",
+		string(TraceOpLock),
+		string(TraceOpGet),
+		string(TraceOpCreateMasterKey),
+		string(TraceOpCreateSlaveKey),
+		"done",
+	})
+
+	// A local reuse of the key does not involve any kvstore operation
+	tracer.events = nil
+	_, _, err = allocator.Allocate(context.Background(), TestType("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(tracer.events, DeepEquals, []string{"start", "done"})
+}
+
+// recordingSpanTracer records the housekeeping spans in addition to the
+// allocation events
+type recordingSpanTracer struct {
+	recordingTracer
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	op     HousekeepingOp
+	prefix string
+	attrs  map[string]int64
+	ended  bool
+	err    error
+}
+
+func (s *recordingSpan) SetAttribute(key string, value int64) {
+	s.attrs[key] = value
+}
+
+func (s *recordingSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+func (t *recordingSpanTracer) StartSpan(op HousekeepingOp, prefix string) Span {
+	span := &recordingSpan{op: op, prefix: prefix, attrs: map[string]int64{}}
+	t.mutex.Lock()
+	t.spans = append(t.spans, span)
+	t.mutex.Unlock()
+	return span
+}
+
+func (s *SelectIDSuite) TestSyncLocalKeysSpan(c *C) {
+	tracer := &recordingSpanTracer{}
+	a := &Allocator{
+		idPrefix:  "test/id",
+		localKeys: newLocalKeys(),
+	}
+	WithTracer(tracer)(a)
+
+	c.Assert(a.syncLocalKeys(), IsNil)
+	c.Assert(tracer.spans, HasLen, 1)
+	c.Assert(*tracer.spans[0], DeepEquals, recordingSpan{
+		op:     HousekeepingSyncLocalKeys,
+		prefix: "test/id",
+		attrs:  map[string]int64{SpanAttrKeys: 0},
+		ended:  true,
+	})
+
+	// Without a span tracer, no spans are emitted
+	a = &Allocator{
+		idPrefix:  "test/id",
+		localKeys: newLocalKeys(),
+	}
+	WithTracer(&recordingTracer{})(a)
+	c.Assert(a.spanTracer, IsNil)
+	c.Assert(a.syncLocalKeys(), IsNil)
+
+	// A span tracer can be configured independently of the tracer
+	spanTracer := &recordingSpanTracer{}
+	WithSpanTracer(spanTracer)(a)
+	c.Assert(a.syncLocalKeys(), IsNil)
+	c.Assert(spanTracer.spans, HasLen, 1)
+	c.Assert(spanTracer.spans[0].op, Equals, HousekeepingSyncLocalKeys)
+}
+
+func (s *AllocatorSuite) TestGCSpan(c *C) {
+	tracer := &recordingSpanTracer{}
+	allocator, err := NewAllocator(randomTestName(), TestType(""), WithMax(idpool.ID(256)),
+		WithSuffix("a"), WithoutGC(), WithTracer(tracer))
+	c.Assert(err, IsNil)
+	defer allocator.DeleteAllKeys()
+	defer allocator.Delete()
+
+	_, _, err = allocator.Allocate(context.Background(), TestType("foo"))
+	c.Assert(err, IsNil)
+
+	_, err = allocator.RunGC(nil)
+	c.Assert(err, IsNil)
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	var gc *recordingSpan
+	for _, span := range tracer.spans {
+		if span.op == HousekeepingGC {
+			gc = span
+		}
+	}
+	c.Assert(gc, Not(IsNil))
+	c.Assert(gc.ended, Equals, true)
+	c.Assert(gc.err, IsNil)
+	c.Assert(gc.attrs[SpanAttrKeys], Equals, int64(1))
+	c.Assert(gc.attrs[SpanAttrStale], Equals, int64(0))
+}
@@ -16,9 +16,13 @@ package kvstore
 
 import (
 	"context"
+	"fmt"
 	"time"
 
+	"github.com/cilium/cilium/pkg/lock"
+
 	"google.golang.org/grpc"
+	"k8s.io/client-go/kubernetes"
 )
 
 type backendOption struct {
@@ -42,6 +46,15 @@ type ExtraOptions struct {
 	// ClusterSizeDependantInterval defines the function to calculate
 	// intervals based on cluster size
 	ClusterSizeDependantInterval func(baseInterval time.Duration) time.Duration
+
+	// ServiceEndpoints returns the addresses, in the form "<ip>:<port>",
+	// of the endpoints of a Kubernetes service. It is required to
+	// discover etcd endpoints via a Kubernetes service.
+	ServiceEndpoints func(namespace, name string) ([]string, error)
+
+	// KubernetesClient is the client used by the kubernetes backend to
+	// store keys in ConfigMaps and Leases.
+	KubernetesClient kubernetes.Interface
 }
 
 // StatusCheckInterval returns the interval of status checks depending on the
@@ -99,6 +112,9 @@ type backendModule interface {
 }
 
 var (
+	// registeredBackendsMutex protects registeredBackends
+	registeredBackendsMutex lock.RWMutex
+
 	// registeredBackends is a slice of all backends that have registered
 	// itself via registerBackend()
 	registeredBackends = map[string]backendModule{}
@@ -106,15 +122,30 @@ var (
 
 // registerBackend must be called by kvstore backends to register themselves
 func registerBackend(name string, module backendModule) {
+	if err := addBackend(name, module); err != nil {
+		log.Panic(err)
+	}
+}
+
+// addBackend adds module to the registered backends. Returns an error if a
+// backend with the same name is already registered.
+func addBackend(name string, module backendModule) error {
+	registeredBackendsMutex.Lock()
+	defer registeredBackendsMutex.Unlock()
+
 	if _, ok := registeredBackends[name]; ok {
-		log.Panicf("backend with name '%s' already registered", name)
+		return fmt.Errorf("backend with name '%s' already registered", name)
 	}
 
 	registeredBackends[name] = module
+	return nil
 }
 
 // getBackend finds a registered backend by name
 func getBackend(name string) backendModule {
+	registeredBackendsMutex.RLock()
+	defer registeredBackendsMutex.RUnlock()
+
 	if backend, ok := registeredBackends[name]; ok {
 		return backend.createInstance()
 	}
@@ -144,9 +175,16 @@ type BackendOperations interface {
 	// Get returns value of key
 	Get(key string) ([]byte, error)
 
+	// GetContext is Get with a context carrying the priority of the
+	// operation and limiting the time spent waiting for the rate limiter
+	GetContext(ctx context.Context, key string) ([]byte, error)
+
 	// GetIfLocked returns value of key if the client is still holding the given lock.
 	GetIfLocked(key string, lock KVLocker) ([]byte, error)
 
+	// GetIfLockedContext is GetIfLocked with a context
+	GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error)
+
 	// GetPrefix returns the first key which matches the prefix and its value
 	GetPrefix(ctx context.Context, prefix string) (string, []byte, error)
 
@@ -156,14 +194,26 @@ type BackendOperations interface {
 	// Set sets value of key
 	Set(key string, value []byte) error
 
+	// SetContext is Set with a context
+	SetContext(ctx context.Context, key string, value []byte) error
+
 	// Delete deletes a key
 	Delete(key string) error
 
+	// DeleteContext is Delete with a context
+	DeleteContext(ctx context.Context, key string) error
+
 	// DeleteIfLocked deletes a key if the client is still holding the given lock.
 	DeleteIfLocked(key string, lock KVLocker) error
 
+	// DeleteIfLockedContext is DeleteIfLocked with a context
+	DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error
+
 	DeletePrefix(path string) error
 
+	// DeletePrefixContext is DeletePrefix with a context
+	DeletePrefixContext(ctx context.Context, path string) error
+
 	// Update atomically creates a key or fails if it already exists
 	Update(ctx context.Context, key string, value []byte, lease bool) error
 
@@ -182,15 +232,25 @@ type BackendOperations interface {
 	// CreateOnlyIfLocked atomically creates a key if the client is still holding the given lock or fails if it already exists
 	CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error)
 
-	// CreateIfExists creates a key with the value only if key condKey exists
+	// CreateIfExists creates a key with the value only if key condKey
+	// exists. Returns ErrConditionalKeyAbsent if condKey does not exist.
 	CreateIfExists(condKey, key string, value []byte, lease bool) error
 
+	// CreateIfExistsContext is CreateIfExists with a context
+	CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error
+
 	// ListPrefix returns a list of keys matching the prefix
 	ListPrefix(prefix string) (KeyValuePairs, error)
 
+	// ListPrefixContext is ListPrefix with a context
+	ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error)
+
 	// ListPrefixIfLocked returns a list of keys matching the prefix only if the client is still holding the given lock.
 	ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error)
 
+	// ListPrefixIfLockedContext is ListPrefixIfLocked with a context
+	ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error)
+
 	// Watch starts watching for changes in a prefix. If list is true, the
 	// current keys matching the prefix will be listed and reported as new
 	// keys first.
@@ -199,6 +259,10 @@ type BackendOperations interface {
 	// Close closes the kvstore client
 	Close()
 
+	// LeaseStatus returns the status of the lease shared by all keys
+	// created by the client with lease=true
+	LeaseStatus(ctx context.Context) (LeaseStatus, error)
+
 	// GetCapabilities returns the capabilities of the backend
 	GetCapabilities() Capabilities
 
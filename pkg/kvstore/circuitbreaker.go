@@ -0,0 +1,607 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/option"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxCircuitBreakerCacheSize is the maximum total size in bytes of the keys
+// and values of the read results kept to be served while the circuit
+// breaker is open
+const maxCircuitBreakerCacheSize = 16 * 1024 * 1024
+
+// CircuitState is the state of the kvstore circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed is the normal state, all operations are passed to
+	// the kvstore
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen is the state after consecutive failures. Operations
+	// fail immediately or are served from the local cache.
+	CircuitOpen
+
+	// CircuitHalfOpen is the state after the open timeout has passed. A
+	// single probing operation is passed to the kvstore, its success
+	// closes the circuit and its failure opens it again.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// ErrCircuitOpen is returned by kvstore operations which are not attempted
+// because the circuit breaker is open
+var ErrCircuitOpen = errors.New("kvstore circuit breaker is open")
+
+// CircuitStateFunc is called on each state change of the circuit breaker
+type CircuitStateFunc func(from, to CircuitState)
+
+var (
+	// circuitObserversMutex protects circuitObservers
+	circuitObserversMutex lock.RWMutex
+
+	// circuitObservers are notified about state changes of the circuit
+	// breaker
+	circuitObservers []CircuitStateFunc
+)
+
+// SubscribeCircuitBreaker registers fn to be called on each state change of
+// the kvstore circuit breaker. fn is called synchronously and must not block.
+func SubscribeCircuitBreaker(fn CircuitStateFunc) {
+	circuitObserversMutex.Lock()
+	circuitObservers = append(circuitObservers, fn)
+	circuitObserversMutex.Unlock()
+}
+
+// circuitBreakerBackend wraps a BackendOperations and stops passing
+// operations to the kvstore after a number of consecutive failures. Instead
+// of all callers blocking on their own retries against an unavailable
+// kvstore, operations fail immediately with ErrCircuitOpen. Reads of keys
+// and prefixes which have been read successfully before are served from a
+// local cache.
+type circuitBreakerBackend struct {
+	BackendOperations
+
+	// threshold is the number of consecutive failures which open the
+	// circuit
+	threshold int
+
+	// timeout is the time the circuit stays open before operations are
+	// attempted again
+	timeout time.Duration
+
+	// maxAge is the maximum age of cached reads served while the circuit
+	// is open
+	maxAge time.Duration
+
+	// mutex protects all fields below
+	mutex lock.Mutex
+
+	state    CircuitState
+	failures int
+	openedAt time.Time
+
+	// probing is true while the probing operation of the half-open
+	// circuit is in progress
+	probing bool
+
+	// values and lists are the results of the last successful Get and
+	// ListPrefix operations by key and prefix
+	values map[string]cachedValue
+	lists  map[string]cachedList
+
+	// cacheSize is the total size of all cached reads in bytes
+	cacheSize int
+}
+
+// cachedValue is the cached result of a Get
+type cachedValue struct {
+	value    []byte
+	cachedAt time.Time
+	size     int
+}
+
+// cachedList is the cached result of a ListPrefix
+type cachedList struct {
+	pairs    KeyValuePairs
+	cachedAt time.Time
+	size     int
+}
+
+// circuitBreakerClient wraps the client with a circuitBreakerBackend if the
+// circuit breaker is enabled
+func circuitBreakerClient(c BackendOperations) BackendOperations {
+	if c == nil || option.Config.KVstoreCircuitBreakerThreshold <= 0 {
+		return c
+	}
+	return newCircuitBreakerBackend(c, option.Config.KVstoreCircuitBreakerThreshold,
+		option.Config.KVstoreCircuitBreakerTimeout, option.Config.KVstoreCircuitBreakerMaxAge)
+}
+
+func newCircuitBreakerBackend(c BackendOperations, threshold int, timeout, maxAge time.Duration) *circuitBreakerBackend {
+	return &circuitBreakerBackend{
+		BackendOperations: c,
+		threshold:         threshold,
+		timeout:           timeout,
+		maxAge:            maxAge,
+		values:            map[string]cachedValue{},
+		lists:             map[string]cachedList{},
+	}
+}
+
+// setState changes the state and returns a function notifying the observers
+// about the change. The mutex must be held, the returned function must be
+// called after releasing it.
+func (b *circuitBreakerBackend) setState(state CircuitState) func() {
+	from := b.state
+	if from == state {
+		return func() {}
+	}
+	b.state = state
+
+	return func() {
+		log.WithFields(logrus.Fields{
+			"from": from,
+			"to":   state,
+		}).Info("kvstore circuit breaker changed state")
+
+		circuitObserversMutex.RLock()
+		for _, fn := range circuitObservers {
+			fn(from, state)
+		}
+		circuitObserversMutex.RUnlock()
+	}
+}
+
+// allow returns ErrCircuitOpen if the operation must not be attempted.
+// Returns true if the operation is the probe of the half-open circuit.
+func (b *circuitBreakerBackend) allow() (probe bool, err error) {
+	b.mutex.Lock()
+	notify := func() {}
+	defer func() { notify() }()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.timeout {
+			return false, ErrCircuitOpen
+		}
+		notify = b.setState(CircuitHalfOpen)
+	case CircuitHalfOpen:
+		// Only a single operation probes the kvstore at a time
+		if b.probing {
+			return false, ErrCircuitOpen
+		}
+	default:
+		return false, nil
+	}
+
+	b.probing = true
+	return true, nil
+}
+
+// isUnavailable returns true if err indicates that the kvstore could not be
+// reached. Errors returned by a reachable kvstore, e.g. because a value is
+// too large, a conditional key is absent or a lock has been lost, say
+// nothing about its health.
+func isUnavailable(err error) bool {
+	switch err {
+	case nil, ErrConditionalKeyAbsent, ErrLockLeaseExpired:
+		return false
+	}
+	if _, ok := err.(*ErrValueTooLarge); ok {
+		return false
+	}
+	return true
+}
+
+// done records the outcome err of an attempted operation with the context
+// ctx. probe must be the value returned by allow().
+func (b *circuitBreakerBackend) done(ctx context.Context, probe bool, err error) {
+	b.mutex.Lock()
+	notify := func() {}
+	defer func() { notify() }()
+	defer b.mutex.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	// Operations cancelled or timed out by the caller say nothing about
+	// the health of the kvstore
+	if err == context.Canceled || ctx.Err() != nil {
+		return
+	}
+
+	// While the circuit is half-open, only the outcome of the probe
+	// changes the state. Operations attempted before the circuit opened
+	// may still complete.
+	if b.state == CircuitHalfOpen && !probe {
+		return
+	}
+
+	if !isUnavailable(err) {
+		b.failures = 0
+		notify = b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		notify = b.setState(CircuitOpen)
+	}
+}
+
+// pruneCache removes all cached reads older than maxAge. The mutex must be
+// held.
+func (b *circuitBreakerBackend) pruneCache() {
+	for k, v := range b.values {
+		if time.Since(v.cachedAt) > b.maxAge {
+			b.cacheSize -= v.size
+			delete(b.values, k)
+		}
+	}
+	for p, l := range b.lists {
+		if time.Since(l.cachedAt) > b.maxAge {
+			b.cacheSize -= l.size
+			delete(b.lists, p)
+		}
+	}
+}
+
+// reserveCache makes room for a cached read of size bytes replacing a cached
+// read of oldSize bytes. Returns false if the cache is full. The mutex must
+// be held.
+func (b *circuitBreakerBackend) reserveCache(size, oldSize int) bool {
+	if b.cacheSize-oldSize+size > maxCircuitBreakerCacheSize {
+		b.pruneCache()
+	}
+	return b.cacheSize-oldSize+size <= maxCircuitBreakerCacheSize
+}
+
+// cacheValue stores the result of a successful Get
+func (b *circuitBreakerBackend) cacheValue(key string, value []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	size := len(key) + len(value)
+	old := b.values[key]
+	if !b.reserveCache(size, old.size) {
+		return
+	}
+	b.cacheSize += size - old.size
+	b.values[key] = cachedValue{value: value, cachedAt: time.Now(), size: size}
+}
+
+// cacheList stores the result of a successful ListPrefix
+func (b *circuitBreakerBackend) cacheList(prefix string, pairs KeyValuePairs) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	size := len(prefix)
+	for k, v := range pairs {
+		size += len(k) + len(v.Data)
+	}
+	old := b.lists[prefix]
+	if !b.reserveCache(size, old.size) {
+		return
+	}
+	b.cacheSize += size - old.size
+	b.lists[prefix] = cachedList{pairs: pairs, cachedAt: time.Now(), size: size}
+}
+
+// lookupValue returns the cached result of a Get of key if it is not older
+// than maxAge
+func (b *circuitBreakerBackend) lookupValue(key string) ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	v, ok := b.values[key]
+	if !ok || time.Since(v.cachedAt) > b.maxAge {
+		return nil, false
+	}
+	return v.value, true
+}
+
+// lookupList returns the cached result of a ListPrefix of prefix if it is
+// not older than maxAge
+func (b *circuitBreakerBackend) lookupList(prefix string) (KeyValuePairs, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	l, ok := b.lists[prefix]
+	if !ok || time.Since(l.cachedAt) > b.maxAge {
+		return nil, false
+	}
+	return l.pairs, true
+}
+
+// invalidate removes all cached reads affected by a write to key. If prefix
+// is true, all keys with the prefix key have been written.
+func (b *circuitBreakerBackend) invalidate(key string, prefix bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for k, v := range b.values {
+		if k == key || (prefix && strings.HasPrefix(k, key)) {
+			b.cacheSize -= v.size
+			delete(b.values, k)
+		}
+	}
+	for p, l := range b.lists {
+		if strings.HasPrefix(key, p) || (prefix && strings.HasPrefix(p, key)) {
+			b.cacheSize -= l.size
+			delete(b.lists, p)
+		}
+	}
+}
+
+// write performs a write operation on key with the context ctx through the
+// circuit breaker
+func (b *circuitBreakerBackend) write(ctx context.Context, key string, prefix bool, op func() error) error {
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+	err = op()
+	b.done(ctx, probe, err)
+	if err == nil {
+		b.invalidate(key, prefix)
+	}
+	return err
+}
+
+// LockPath implements BackendOperations
+func (b *circuitBreakerBackend) LockPath(ctx context.Context, path string) (KVLocker, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	l, err := b.BackendOperations.LockPath(ctx, path)
+	b.done(ctx, probe, err)
+	return l, err
+}
+
+// Get implements BackendOperations. If the circuit is open, the value of
+// the last successful read of key is returned.
+func (b *circuitBreakerBackend) Get(key string) ([]byte, error) {
+	return b.GetContext(context.TODO(), key)
+}
+
+// GetContext implements BackendOperations
+func (b *circuitBreakerBackend) GetContext(ctx context.Context, key string) ([]byte, error) {
+	probe, err := b.allow()
+	if err != nil {
+		if v, ok := b.lookupValue(key); ok {
+			return v, nil
+		}
+		return nil, err
+	}
+	v, err := b.BackendOperations.GetContext(ctx, key)
+	b.done(ctx, probe, err)
+	if err == nil {
+		b.cacheValue(key, v)
+	}
+	return v, err
+}
+
+// GetIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
+	return b.GetIfLockedContext(context.TODO(), key, lock)
+}
+
+// GetIfLockedContext implements BackendOperations
+func (b *circuitBreakerBackend) GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.BackendOperations.GetIfLockedContext(ctx, key, lock)
+	b.done(ctx, probe, err)
+	return v, err
+}
+
+// GetPrefix implements BackendOperations
+func (b *circuitBreakerBackend) GetPrefix(ctx context.Context, prefix string) (string, []byte, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return "", nil, err
+	}
+	k, v, err := b.BackendOperations.GetPrefix(ctx, prefix)
+	b.done(ctx, probe, err)
+	return k, v, err
+}
+
+// GetPrefixIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) GetPrefixIfLocked(ctx context.Context, prefix string, lock KVLocker) (string, []byte, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return "", nil, err
+	}
+	k, v, err := b.BackendOperations.GetPrefixIfLocked(ctx, prefix, lock)
+	b.done(ctx, probe, err)
+	return k, v, err
+}
+
+// ListPrefix implements BackendOperations. If the circuit is open, the
+// result of the last successful listing of prefix is returned.
+func (b *circuitBreakerBackend) ListPrefix(prefix string) (KeyValuePairs, error) {
+	return b.ListPrefixContext(context.TODO(), prefix)
+}
+
+// ListPrefixContext implements BackendOperations
+func (b *circuitBreakerBackend) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	probe, err := b.allow()
+	if err != nil {
+		if pairs, ok := b.lookupList(prefix); ok {
+			return pairs, nil
+		}
+		return nil, err
+	}
+	pairs, err := b.BackendOperations.ListPrefixContext(ctx, prefix)
+	b.done(ctx, probe, err)
+	if err == nil {
+		b.cacheList(prefix, pairs)
+	}
+	return pairs, err
+}
+
+// ListPrefixIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
+	return b.ListPrefixIfLockedContext(context.TODO(), prefix, lock)
+}
+
+// ListPrefixIfLockedContext implements BackendOperations
+func (b *circuitBreakerBackend) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := b.BackendOperations.ListPrefixIfLockedContext(ctx, prefix, lock)
+	b.done(ctx, probe, err)
+	return pairs, err
+}
+
+// Set implements BackendOperations
+func (b *circuitBreakerBackend) Set(key string, value []byte) error {
+	return b.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (b *circuitBreakerBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	return b.write(ctx, key, false, func() error {
+		return b.BackendOperations.SetContext(ctx, key, value)
+	})
+}
+
+// Delete implements BackendOperations
+func (b *circuitBreakerBackend) Delete(key string) error {
+	return b.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (b *circuitBreakerBackend) DeleteContext(ctx context.Context, key string) error {
+	return b.write(ctx, key, false, func() error {
+		return b.BackendOperations.DeleteContext(ctx, key)
+	})
+}
+
+// DeleteIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) DeleteIfLocked(key string, lock KVLocker) error {
+	return b.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (b *circuitBreakerBackend) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	return b.write(ctx, key, false, func() error {
+		return b.BackendOperations.DeleteIfLockedContext(ctx, key, lock)
+	})
+}
+
+// DeletePrefix implements BackendOperations
+func (b *circuitBreakerBackend) DeletePrefix(path string) error {
+	return b.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (b *circuitBreakerBackend) DeletePrefixContext(ctx context.Context, path string) error {
+	return b.write(ctx, path, true, func() error {
+		return b.BackendOperations.DeletePrefixContext(ctx, path)
+	})
+}
+
+// Update implements BackendOperations
+func (b *circuitBreakerBackend) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	return b.write(ctx, key, false, func() error {
+		return b.BackendOperations.Update(ctx, key, value, lease)
+	})
+}
+
+// UpdateIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) error {
+	return b.write(ctx, key, false, func() error {
+		return b.BackendOperations.UpdateIfLocked(ctx, key, value, lease, lock)
+	})
+}
+
+// UpdateIfDifferent implements BackendOperations
+func (b *circuitBreakerBackend) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (recreated bool, err error) {
+	err = b.write(ctx, key, false, func() (err error) {
+		recreated, err = b.BackendOperations.UpdateIfDifferent(ctx, key, value, lease)
+		return err
+	})
+	return recreated, err
+}
+
+// UpdateIfDifferentIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (recreated bool, err error) {
+	err = b.write(ctx, key, false, func() (err error) {
+		recreated, err = b.BackendOperations.UpdateIfDifferentIfLocked(ctx, key, value, lease, lock)
+		return err
+	})
+	return recreated, err
+}
+
+// CreateOnly implements BackendOperations
+func (b *circuitBreakerBackend) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (success bool, err error) {
+	err = b.write(ctx, key, false, func() (err error) {
+		success, err = b.BackendOperations.CreateOnly(ctx, key, value, lease)
+		return err
+	})
+	return success, err
+}
+
+// CreateOnlyIfLocked implements BackendOperations
+func (b *circuitBreakerBackend) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (success bool, err error) {
+	err = b.write(ctx, key, false, func() (err error) {
+		success, err = b.BackendOperations.CreateOnlyIfLocked(ctx, key, value, lease, lock)
+		return err
+	})
+	return success, err
+}
+
+// CreateIfExists implements BackendOperations
+func (b *circuitBreakerBackend) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return b.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (b *circuitBreakerBackend) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	return b.write(ctx, key, false, func() error {
+		return b.BackendOperations.CreateIfExistsContext(ctx, condKey, key, value, lease)
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// flakyBackend is a BackendOperations whose operations fail while err is set
+type flakyBackend struct {
+	BackendOperations
+	err   error
+	calls int
+}
+
+func (f *flakyBackend) GetContext(ctx context.Context, key string) ([]byte, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte("value"), nil
+}
+
+func (f *flakyBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	f.calls++
+	return f.err
+}
+
+func (s *independentSuite) TestCircuitBreaker(c *C) {
+	var transitions []CircuitState
+	SubscribeCircuitBreaker(func(from, to CircuitState) {
+		transitions = append(transitions, to)
+	})
+	defer func() {
+		circuitObserversMutex.Lock()
+		circuitObservers = nil
+		circuitObserversMutex.Unlock()
+	}()
+
+	backend := &flakyBackend{}
+	b := newCircuitBreakerBackend(backend, 2, time.Hour, time.Hour)
+
+	v, err := b.Get("foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(v), Equals, "value")
+
+	// Consecutive failures open the circuit
+	backend.err = errors.New("unavailable")
+	c.Assert(b.Set("bar", nil), Not(IsNil))
+	c.Assert(b.Set("bar", nil), Not(IsNil))
+	c.Assert(transitions, DeepEquals, []CircuitState{CircuitOpen})
+	c.Assert(backend.calls, Equals, 3)
+
+	// Operations are no longer attempted, reads are served from the cache
+	c.Assert(b.Set("bar", nil), Equals, ErrCircuitOpen)
+	v, err = b.Get("foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(v), Equals, "value")
+	_, err = b.Get("baz")
+	c.Assert(err, Equals, ErrCircuitOpen)
+	c.Assert(backend.calls, Equals, 3)
+
+	// After the timeout, a failure opens the circuit again
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	c.Assert(b.Set("bar", nil), Not(IsNil))
+	c.Assert(b.state, Equals, CircuitOpen)
+	c.Assert(backend.calls, Equals, 4)
+
+	// and a success closes it
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	backend.err = nil
+	c.Assert(b.Set("foo", nil), IsNil)
+	c.Assert(transitions, DeepEquals, []CircuitState{
+		CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed,
+	})
+
+	// The write invalidated the cached read
+	c.Assert(b.values, HasLen, 0)
+	c.Assert(b.cacheSize, Equals, 0)
+}
+
+func (s *independentSuite) TestCircuitBreakerIgnoresNonTransportErrors(c *C) {
+	backend := &flakyBackend{}
+	b := newCircuitBreakerBackend(backend, 1, time.Hour, time.Hour)
+
+	for _, err := range []error{
+		ErrConditionalKeyAbsent,
+		ErrLockLeaseExpired,
+		&ErrValueTooLarge{Key: "foo", Size: 2, MaxSize: 1},
+	} {
+		backend.err = err
+		c.Assert(b.Set("foo", nil), Equals, err)
+		c.Assert(b.state, Equals, CircuitClosed)
+	}
+
+	// Expired contexts of the caller are not counted either
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	backend.err = errors.New("unavailable")
+	c.Assert(b.write(ctx, "foo", false, func() error { return backend.err }), Not(IsNil))
+	c.Assert(b.state, Equals, CircuitClosed)
+
+	c.Assert(b.Set("foo", nil), Not(IsNil))
+	c.Assert(b.state, Equals, CircuitOpen)
+}
+
+func (s *independentSuite) TestCircuitBreakerSingleProbe(c *C) {
+	backend := &flakyBackend{err: errors.New("unavailable")}
+	b := newCircuitBreakerBackend(backend, 1, time.Hour, time.Hour)
+	c.Assert(b.Set("foo", nil), Not(IsNil))
+	c.Assert(b.state, Equals, CircuitOpen)
+
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	probe, err := b.allow()
+	c.Assert(err, IsNil)
+	c.Assert(probe, Equals, true)
+	c.Assert(b.state, Equals, CircuitHalfOpen)
+
+	// Other operations are rejected while the probe is in progress
+	_, err = b.allow()
+	c.Assert(err, Equals, ErrCircuitOpen)
+
+	// and outcomes of operations other than the probe are ignored
+	b.done(context.TODO(), false, nil)
+	c.Assert(b.state, Equals, CircuitHalfOpen)
+
+	b.done(context.TODO(), true, nil)
+	c.Assert(b.state, Equals, CircuitClosed)
+	c.Assert(b.probing, Equals, false)
+}
+
+func (s *independentSuite) TestCircuitBreakerCache(c *C) {
+	backend := &flakyBackend{}
+	b := newCircuitBreakerBackend(backend, 1, time.Hour, time.Minute)
+
+	_, err := b.Get("foo")
+	c.Assert(err, IsNil)
+	c.Assert(b.cacheSize, Equals, len("foo")+len("value"))
+
+	backend.err = errors.New("unavailable")
+	_, err = b.Get("foo")
+	c.Assert(err, Not(IsNil))
+	c.Assert(b.state, Equals, CircuitOpen)
+
+	v, err := b.Get("foo")
+	c.Assert(err, IsNil)
+	c.Assert(string(v), Equals, "value")
+
+	// Reads older than the maximum age are no longer served
+	cached := b.values["foo"]
+	cached.cachedAt = time.Now().Add(-2 * time.Minute)
+	b.values["foo"] = cached
+	_, err = b.Get("foo")
+	c.Assert(err, Equals, ErrCircuitOpen)
+
+	// Expired reads are pruned to make room for new ones
+	b.mutex.Lock()
+	c.Assert(b.reserveCache(maxCircuitBreakerCacheSize, 0), Equals, true)
+	c.Assert(b.values, HasLen, 0)
+	c.Assert(b.cacheSize, Equals, 0)
+
+	// Reads exceeding the maximum size are not cached
+	c.Assert(b.reserveCache(maxCircuitBreakerCacheSize+1, 0), Equals, false)
+	b.mutex.Unlock()
+}
@@ -26,6 +26,13 @@ var (
 	defaultClientSet = make(chan struct{})
 )
 
+// wrapClient wraps a client returned by a backend module with the write
+// interceptors, the client-side metrics, the circuit breaker and the rate
+// limiter
+func wrapClient(c BackendOperations) BackendOperations {
+	return interceptClient(instrumentClient(circuitBreakerClient(rateLimitClient(c))))
+}
+
 func initClient(module backendModule, opts *ExtraOptions) error {
 	c, errChan := module.newClient(opts)
 	if c == nil {
@@ -33,7 +40,7 @@ func initClient(module backendModule, opts *ExtraOptions) error {
 		log.WithError(err).Fatalf("Unable to create etcd client")
 	}
 
-	defaultClient = c
+	defaultClient = wrapClient(c)
 	select {
 	case <-defaultClientSet:
 		// avoid closing channel already closed.
@@ -47,6 +54,7 @@ func initClient(module backendModule, opts *ExtraOptions) error {
 			log.WithError(err).Fatalf("Unable to connect to kvstore")
 		}
 		deleteLegacyPrefixes()
+		startLeaseHealthReporting(defaultClient)
 	}()
 
 	return nil
@@ -81,5 +89,6 @@ func NewClient(selectedBackend string, opts map[string]string, options *ExtraOpt
 		return nil, errChan
 	}
 
-	return module.newClient(options)
+	c, errChan := module.newClient(options)
+	return wrapClient(c), errChan
 }
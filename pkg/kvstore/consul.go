@@ -175,6 +175,11 @@ type consulClient struct {
 	extraOptions   *ExtraOptions
 	disconnectedMu lock.RWMutex
 	disconnected   chan struct{}
+
+	// leaseMutex protects lastRenew
+	leaseMutex lock.RWMutex
+	// lastRenew is the time the lease was last renewed successfully
+	lastRenew time.Time
 }
 
 func newConsulClient(config *consulAPI.Config, opts *ExtraOptions) (BackendOperations, error) {
@@ -229,6 +234,7 @@ func newConsulClient(config *consulAPI.Config, opts *ExtraOptions) (BackendOpera
 		controllers:  controller.NewManager(),
 		extraOptions: opts,
 		disconnected: make(chan struct{}),
+		lastRenew:    time.Now(),
 	}
 
 	client.controllers.UpdateController(fmt.Sprintf("consul-lease-keepalive-%p", c),
@@ -241,6 +247,10 @@ func newConsulClient(config *consulAPI.Config, opts *ExtraOptions) (BackendOpera
 					close(client.disconnected)
 					client.disconnected = make(chan struct{})
 					client.disconnectedMu.Unlock()
+				} else {
+					client.leaseMutex.Lock()
+					client.lastRenew = time.Now()
+					client.leaseMutex.Unlock()
 				}
 				return err
 			},
@@ -259,7 +269,12 @@ func (cl *ConsulLocker) Comparator() interface{} {
 	return nil
 }
 
-func (c *consulClient) LockPath(ctx context.Context, path string) (KVLocker, error) {
+func (c *consulClient) LockPath(ctx context.Context, path string) (locker KVLocker, err error) {
+	duration := spanstat.Start()
+	defer func() {
+		increaseMetric(path, metricLock, "Lock", duration.EndError(err).Total(), err)
+	}()
+
 	lockKey, err := c.LockOpts(&consulAPI.LockOptions{Key: getLockPath(path)})
 	if err != nil {
 		return nil, err
@@ -286,6 +301,18 @@ func (c *consulClient) LockPath(ctx context.Context, path string) (KVLocker, err
 	return nil, fmt.Errorf("maximum retries (%d) reached", maxLockRetries)
 }
 
+// quarantinePairs returns all pairs with values not exceeding the maximum
+// value size
+func quarantinePairs(pairs consulAPI.KVPairs) consulAPI.KVPairs {
+	validPairs := pairs[:0]
+	for _, pair := range pairs {
+		if !quarantineValue(pair.Key, pair.Value) {
+			validPairs = append(validPairs, pair)
+		}
+	}
+	return validPairs
+}
+
 // Watch starts watching for changes in a prefix
 func (c *consulClient) Watch(w *Watcher) {
 	// Last known state of all KVPairs matching the prefix
@@ -317,6 +344,10 @@ func (c *consulClient) Watch(w *Watcher) {
 			goto wait
 		}
 
+		// Oversized values are treated as if the key did not exist
+		// which removes previously valid values from all caches
+		pairs = quarantinePairs(pairs)
+
 		for _, newPair := range pairs {
 			oldPair, ok := localState[newPair.Key]
 
@@ -329,17 +360,19 @@ func (c *consulClient) Watch(w *Watcher) {
 
 				queueStart := spanstat.Start()
 				w.Events <- KeyValueEvent{
-					Typ:   EventTypeCreate,
-					Key:   newPair.Key,
-					Value: newPair.Value,
+					Typ:         EventTypeCreate,
+					Key:         newPair.Key,
+					Value:       newPair.Value,
+					ModRevision: newPair.ModifyIndex,
 				}
 				trackEventQueued(newPair.Key, EventTypeCreate, queueStart.End(true).Total())
 			} else if oldPair.ModifyIndex != newPair.ModifyIndex {
 				queueStart := spanstat.Start()
 				w.Events <- KeyValueEvent{
-					Typ:   EventTypeModify,
-					Key:   newPair.Key,
-					Value: newPair.Value,
+					Typ:         EventTypeModify,
+					Key:         newPair.Key,
+					Value:       newPair.Value,
+					ModRevision: newPair.ModifyIndex,
 				}
 				trackEventQueued(newPair.Key, EventTypeModify, queueStart.End(true).Total())
 			}
@@ -353,9 +386,10 @@ func (c *consulClient) Watch(w *Watcher) {
 		for k, deletedPair := range localState {
 			queueStart := spanstat.Start()
 			w.Events <- KeyValueEvent{
-				Typ:   EventTypeDelete,
-				Key:   deletedPair.Key,
-				Value: deletedPair.Value,
+				Typ:         EventTypeDelete,
+				Key:         deletedPair.Key,
+				Value:       deletedPair.Value,
+				ModRevision: nextIndex,
 			}
 			trackEventQueued(deletedPair.Key, EventTypeDelete, queueStart.End(true).Total())
 			delete(localState, k)
@@ -434,48 +468,87 @@ func (c *consulClient) Status() (string, error) {
 	return "Consul: " + leader, err
 }
 
+// DeletePrefix implements BackendOperations
 func (c *consulClient) DeletePrefix(path string) error {
+	return c.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (c *consulClient) DeletePrefixContext(ctx context.Context, path string) error {
 	duration := spanstat.Start()
-	_, err := c.Client.KV().DeleteTree(path, nil)
+	opts := &consulAPI.WriteOptions{}
+	_, err := c.Client.KV().DeleteTree(path, opts.WithContext(ctx))
 	increaseMetric(path, metricDelete, "DeletePrefix", duration.EndError(err).Total(), err)
 	return err
 }
 
 // Set sets value of key
 func (c *consulClient) Set(key string, value []byte) error {
+	return c.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (c *consulClient) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
 	duration := spanstat.Start()
-	_, err := c.KV().Put(&consulAPI.KVPair{Key: key, Value: value}, nil)
+	opts := &consulAPI.WriteOptions{}
+	_, err := c.KV().Put(&consulAPI.KVPair{Key: key, Value: value}, opts.WithContext(ctx))
 	increaseMetric(key, metricSet, "Set", duration.EndError(err).Total(), err)
 	return err
 }
 
 // DeleteIfLocked deletes a key if the client is still holding the given lock.
 func (c *consulClient) DeleteIfLocked(key string, lock KVLocker) error {
-	return c.Delete(key)
+	return c.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (c *consulClient) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	return c.DeleteContext(ctx, key)
 }
 
 // Delete deletes a key
 func (c *consulClient) Delete(key string) error {
+	return c.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (c *consulClient) DeleteContext(ctx context.Context, key string) error {
 	duration := spanstat.Start()
-	_, err := c.KV().Delete(key, nil)
+	opts := &consulAPI.WriteOptions{}
+	_, err := c.KV().Delete(key, opts.WithContext(ctx))
 	increaseMetric(key, metricDelete, "Delete", duration.EndError(err).Total(), err)
 	return err
 }
 
 // GetIfLocked returns value of key if the client is still holding the given lock.
 func (c *consulClient) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
-	return c.Get(key)
+	return c.GetIfLockedContext(context.TODO(), key, lock)
+}
+
+// GetIfLockedContext implements BackendOperations
+func (c *consulClient) GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error) {
+	return c.GetContext(ctx, key)
 }
 
 // Get returns value of key
 func (c *consulClient) Get(key string) ([]byte, error) {
+	return c.GetContext(context.TODO(), key)
+}
+
+// GetContext implements BackendOperations
+func (c *consulClient) GetContext(ctx context.Context, key string) ([]byte, error) {
 	duration := spanstat.Start()
-	pair, _, err := c.KV().Get(key, nil)
+	opts := &consulAPI.QueryOptions{}
+	pair, _, err := c.KV().Get(key, opts.WithContext(ctx))
 	increaseMetric(key, metricRead, "Get", duration.EndError(err).Total(), err)
 	if err != nil {
 		return nil, err
 	}
-	if pair == nil {
+	if pair == nil || quarantineValue(key, pair.Value) {
 		return nil, nil
 	}
 	return pair.Value, nil
@@ -496,11 +569,13 @@ func (c *consulClient) GetPrefix(ctx context.Context, prefix string) (string, []
 		return "", nil, err
 	}
 
-	if len(pairs) == 0 {
-		return "", nil, nil
+	for _, pair := range pairs {
+		if !quarantineValue(pair.Key, pair.Value) {
+			return pair.Key, pair.Value, nil
+		}
 	}
 
-	return pairs[0].Key, pairs[0].Value, nil
+	return "", nil, nil
 }
 
 // UpdateIfLocked atomically creates a key or fails if it already exists if the client is still holding the given lock.
@@ -510,6 +585,10 @@ func (c *consulClient) UpdateIfLocked(ctx context.Context, key string, value []b
 
 // Update creates or updates a key with the value
 func (c *consulClient) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
 	k := &consulAPI.KVPair{Key: key, Value: value}
 
 	if lease {
@@ -558,6 +637,10 @@ func (c *consulClient) CreateOnlyIfLocked(ctx context.Context, key string, value
 
 // CreateOnly creates a key with the value and will fail if the key already exists
 func (c *consulClient) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if err := checkValueSize(key, value); err != nil {
+		return false, err
+	}
+
 	k := &consulAPI.KVPair{
 		Key:         key,
 		Value:       value,
@@ -579,14 +662,14 @@ func (c *consulClient) CreateOnly(ctx context.Context, key string, value []byte,
 }
 
 // createIfExists creates a key with the value only if key condKey exists
-func (c *consulClient) createIfExists(condKey, key string, value []byte, lease bool) error {
+func (c *consulClient) createIfExists(ctx context.Context, condKey, key string, value []byte, lease bool) error {
 	// Consul does not support transactions which would allow to check for
 	// the presence of a conditional key if the key is not the key being
 	// manipulated
 	//
 	// Lock the conditional key to serialize all CreateIfExists() calls
 
-	l, err := LockPath(context.Background(), condKey)
+	l, err := LockPath(ctx, condKey)
 	if err != nil {
 		return fmt.Errorf("unable to lock condKey for CreateIfExists: %s", err)
 	}
@@ -594,16 +677,19 @@ func (c *consulClient) createIfExists(condKey, key string, value []byte, lease b
 	defer l.Unlock()
 
 	// Create the key if it does not exist
-	if _, err := c.CreateOnly(context.TODO(), key, value, lease); err != nil {
+	if _, err := c.CreateOnly(ctx, key, value, lease); err != nil {
 		return err
 	}
 
 	// Consul does not support transactions which would allow to check for
 	// the presence of another key
-	masterKey, err := c.Get(condKey)
+	masterKey, err := c.GetContext(ctx, condKey)
 	if err != nil || masterKey == nil {
-		c.Delete(key)
-		return fmt.Errorf("conditional key not present")
+		c.DeleteContext(ctx, key)
+		if err != nil {
+			return err
+		}
+		return ErrConditionalKeyAbsent
 	}
 
 	return nil
@@ -611,21 +697,37 @@ func (c *consulClient) createIfExists(condKey, key string, value []byte, lease b
 
 // CreateIfExists creates a key with the value only if key condKey exists
 func (c *consulClient) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return c.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (c *consulClient) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
 	duration := spanstat.Start()
-	err := c.createIfExists(condKey, key, value, lease)
+	err := c.createIfExists(ctx, condKey, key, value, lease)
 	increaseMetric(key, metricSet, "CreateIfExists", duration.EndError(err).Total(), err)
 	return err
 }
 
 // ListPrefixIfLocked returns a list of keys matching the prefix only if the client is still holding the given lock.
 func (c *consulClient) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
-	return c.ListPrefix(prefix)
+	return c.ListPrefixIfLockedContext(context.TODO(), prefix, lock)
+}
+
+// ListPrefixIfLockedContext implements BackendOperations
+func (c *consulClient) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	return c.ListPrefixContext(ctx, prefix)
 }
 
 // ListPrefix returns a map of matching keys
 func (c *consulClient) ListPrefix(prefix string) (KeyValuePairs, error) {
+	return c.ListPrefixContext(context.TODO(), prefix)
+}
+
+// ListPrefixContext implements BackendOperations
+func (c *consulClient) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
 	duration := spanstat.Start()
-	pairs, _, err := c.KV().List(prefix, nil)
+	opts := &consulAPI.QueryOptions{}
+	pairs, _, err := c.KV().List(prefix, opts.WithContext(ctx))
 	increaseMetric(prefix, metricRead, "ListPrefix", duration.EndError(err).Total(), err)
 	if err != nil {
 		return nil, err
@@ -633,6 +735,10 @@ func (c *consulClient) ListPrefix(prefix string) (KeyValuePairs, error) {
 
 	p := KeyValuePairs(make(map[string]Value, len(pairs)))
 	for i := 0; i < len(pairs); i++ {
+		if quarantineValue(pairs[i].Key, pairs[i].Value) {
+			continue
+		}
+
 		p[pairs[i].Key] = Value{
 			Data:        pairs[i].Value,
 			ModRevision: pairs[i].ModifyIndex,
@@ -642,6 +748,25 @@ func (c *consulClient) ListPrefix(prefix string) (KeyValuePairs, error) {
 	return p, nil
 }
 
+// LeaseStatus returns the status of the consul session. Consul does not
+// expose the remaining TTL of a session, it is derived from the time of the
+// last successful renewal.
+func (c *consulClient) LeaseStatus(ctx context.Context) (LeaseStatus, error) {
+	c.leaseMutex.RLock()
+	lastRenew := c.lastRenew
+	c.leaseMutex.RUnlock()
+
+	status := LeaseStatus{
+		ID:  c.lease,
+		TTL: option.Config.KVstoreLeaseTTL,
+	}
+	if remaining := status.TTL - time.Since(lastRenew); remaining > 0 {
+		status.Remaining = remaining
+	}
+
+	return status, nil
+}
+
 // Close closes the consul session
 func (c *consulClient) Close() {
 	if c.controllers != nil {
@@ -0,0 +1,116 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// defaultDeleteBatchSize is the default number of keys deleted per
+	// batch by DeletePrefixChunked
+	defaultDeleteBatchSize = 100
+)
+
+// DeleteProgressFunc is called by DeletePrefixChunked after each batch with
+// the number of keys deleted so far and the total number of keys to delete
+type DeleteProgressFunc func(deleted, total int)
+
+// DeletePrefixOptions are the options of DeletePrefixChunked
+type DeletePrefixOptions struct {
+	// BatchSize is the maximum number of keys deleted before progress is
+	// reported and cancellation is checked. If 0, defaultDeleteBatchSize
+	// is used.
+	BatchSize int
+
+	// RateLimit is the maximum number of keys deleted per second. If 0,
+	// the deletion is not rate limited.
+	RateLimit int
+
+	// Progress is called after each batch if specified
+	Progress DeleteProgressFunc
+}
+
+// DeletePrefixChunked deletes all keys matching a prefix in bounded batches.
+// Unlike DeletePrefix, the deletion of large prefixes does not result in a
+// single large transaction, is rate limited and can be cancelled with ctx.
+// Keys created after the deletion has started are not deleted. Returns the
+// number of keys deleted, which is less than the number of keys matching the
+// prefix if an error is returned.
+func DeletePrefixChunked(ctx context.Context, prefix string, opts DeletePrefixOptions) (int, error) {
+	deleted, err := deletePrefixChunked(ctx, Client(), prefix, opts)
+	Trace("DeletePrefixChunked", err, logrus.Fields{fieldPrefix: prefix, fieldNumEntries: deleted})
+	return deleted, err
+}
+
+func deletePrefixChunked(ctx context.Context, backend BackendOperations, prefix string, opts DeletePrefixOptions) (int, error) {
+	pairs, err := backend.ListPrefixContext(ctx, prefix)
+	if err != nil {
+		return 0, fmt.Errorf("unable to list keys to delete: %s", err)
+	}
+
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultDeleteBatchSize
+	}
+
+	var limiter *rate.Limiter
+	if opts.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), 1)
+	}
+
+	deleted := 0
+	for len(keys) > 0 {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		n := batchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+
+		for _, key := range keys[:n] {
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					return deleted, err
+				}
+			}
+
+			if err := backend.DeleteContext(ctx, key); err != nil {
+				return deleted, fmt.Errorf("unable to delete key %s: %s", key, err)
+			}
+			deleted++
+		}
+		keys = keys[n:]
+
+		if opts.Progress != nil {
+			opts.Progress(deleted, len(pairs))
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// mapBackend is a BackendOperations listing and deleting keys of a map
+type mapBackend struct {
+	BackendOperations
+	keys    map[string]struct{}
+	deleted []string
+}
+
+func newMapBackend(n int) *mapBackend {
+	m := &mapBackend{keys: map[string]struct{}{}}
+	for i := 0; i < n; i++ {
+		m.keys[fmt.Sprintf("prefix/%03d", i)] = struct{}{}
+	}
+	return m
+}
+
+func (m *mapBackend) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	pairs := KeyValuePairs{}
+	for key := range m.keys {
+		pairs[key] = Value{}
+	}
+	return pairs, nil
+}
+
+func (m *mapBackend) DeleteContext(ctx context.Context, key string) error {
+	delete(m.keys, key)
+	m.deleted = append(m.deleted, key)
+	return nil
+}
+
+func (s *independentSuite) TestDeletePrefixChunked(c *C) {
+	backend := newMapBackend(25)
+
+	progress := []int{}
+	deleted, err := deletePrefixChunked(context.Background(), backend, "prefix", DeletePrefixOptions{
+		BatchSize: 10,
+		Progress: func(deleted, total int) {
+			c.Assert(total, Equals, 25)
+			progress = append(progress, deleted)
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 25)
+	c.Assert(progress, DeepEquals, []int{10, 20, 25})
+	c.Assert(backend.keys, HasLen, 0)
+	c.Assert(backend.deleted[0], Equals, "prefix/000")
+}
+
+func (s *independentSuite) TestDeletePrefixChunkedCancel(c *C) {
+	backend := newMapBackend(25)
+
+	// Cancellation is honored between batches
+	ctx, cancel := context.WithCancel(context.Background())
+	deleted, err := deletePrefixChunked(ctx, backend, "prefix", DeletePrefixOptions{
+		BatchSize: 10,
+		Progress: func(deleted, total int) {
+			cancel()
+		},
+	})
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(deleted, Equals, 10)
+	c.Assert(backend.keys, HasLen, 15)
+
+	// The rate limiter gives up if the next deletion would exceed the
+	// deadline of the context
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	deleted, err = deletePrefixChunked(ctx, backend, "prefix", DeletePrefixOptions{RateLimit: 1})
+	c.Assert(err, Not(IsNil))
+	c.Assert(deleted, Equals, 1)
+	c.Assert(backend.keys, HasLen, 14)
+}
@@ -0,0 +1,185 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/cilium/cilium/pkg/option"
+
+	"github.com/golang/snappy"
+)
+
+// Compression is the compression algorithm applied to values in the
+// envelope encoding
+type Compression byte
+
+const (
+	// CompressionNone leaves values uncompressed
+	CompressionNone Compression = iota
+
+	// CompressionGzip compresses large values with gzip
+	CompressionGzip
+
+	// CompressionSnappy compresses large values with snappy
+	CompressionSnappy
+)
+
+// String returns the name of the compression algorithm
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionSnappy:
+		return "snappy"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(c))
+}
+
+const (
+	// EnvelopeMarker is the first byte of all values in the envelope
+	// encoding. Plain values written by the users of the envelope never
+	// start with a NUL byte, which allows to read enveloped and plain
+	// values during rolling upgrades.
+	EnvelopeMarker = 0x00
+
+	// EnvelopeVersion is the version of the envelope encoding written.
+	// The envelope consists of the marker, the version, the compression
+	// algorithm and the payload.
+	EnvelopeVersion = 1
+
+	// EnvelopeHeaderLen is the length of the envelope header
+	EnvelopeHeaderLen = 3
+
+	// maxDecodedValueSize is the maximum size of a decompressed value if
+	// no maximum value size is configured
+	maxDecodedValueSize = 16 * 1024 * 1024
+)
+
+// decodedValueLimit returns the maximum size of a decompressed value. A
+// decompressed value is subject to the same limit as the values read from
+// the kvstore so that a small compressed value cannot exhaust the memory of
+// the agent.
+func decodedValueLimit() int {
+	if max := option.Config.KVstoreMaxValueSize; max > 0 {
+		return max
+	}
+	return maxDecodedValueSize
+}
+
+// compress returns value compressed with the given algorithm
+func compress(compression Compression, value []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return value, nil
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, value), nil
+	}
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// decompress returns value decompressed with the given algorithm. It fails
+// if the decompressed value would exceed limit bytes.
+func decompress(compression Compression, value []byte, limit int) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return value, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		plain, err := ioutil.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(plain) > limit {
+			return nil, fmt.Errorf("decompressed value exceeds maximum of %d bytes", limit)
+		}
+		return plain, nil
+	case CompressionSnappy:
+		n, err := snappy.DecodedLen(value)
+		if err != nil {
+			return nil, err
+		}
+		if n > limit {
+			return nil, fmt.Errorf("decompressed value exceeds maximum of %d bytes", limit)
+		}
+		return snappy.Decode(nil, value)
+	}
+	return nil, fmt.Errorf("unknown compression %s", compression)
+}
+
+// EncodeValue wraps value in an envelope. The value is compressed with the
+// given algorithm if it is at least threshold bytes large and compression
+// reduces its size.
+func EncodeValue(value []byte, compression Compression, threshold int) ([]byte, error) {
+	used := CompressionNone
+	payload := value
+	if compression != CompressionNone && len(value) >= threshold {
+		compressed, err := compress(compression, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(value) {
+			used, payload = compression, compressed
+		}
+	}
+
+	encoded := make([]byte, 0, EnvelopeHeaderLen+len(payload))
+	encoded = append(encoded, EnvelopeMarker, EnvelopeVersion, byte(used))
+	return append(encoded, payload...), nil
+}
+
+// DecodeValue returns the plain value of a value read from the kvstore.
+// Values without envelope are returned as-is, enveloped values are unwrapped
+// and decompressed.
+func DecodeValue(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != EnvelopeMarker {
+		return value, nil
+	}
+
+	if len(value) < EnvelopeHeaderLen {
+		return nil, fmt.Errorf("truncated value envelope")
+	}
+
+	if version := value[1]; version != EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported value envelope version %d", version)
+	}
+
+	plain, err := decompress(Compression(value[2]), value[EnvelopeHeaderLen:], decodedValueLimit())
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress value: %s", err)
+	}
+
+	return plain, nil
+}
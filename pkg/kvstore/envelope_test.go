@@ -0,0 +1,81 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"bytes"
+
+	"github.com/cilium/cilium/pkg/option"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestEnvelope(c *C) {
+	small := []byte("foo")
+	large := bytes.Repeat([]byte("foo"), 1000)
+
+	for _, compression := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy} {
+		// Small values are never compressed
+		encoded, err := EncodeValue(small, compression, 100)
+		c.Assert(err, IsNil)
+		c.Assert(encoded, DeepEquals, append([]byte{EnvelopeMarker, EnvelopeVersion, byte(CompressionNone)}, small...))
+
+		encoded, err = EncodeValue(large, compression, 100)
+		c.Assert(err, IsNil)
+		c.Assert(Compression(encoded[2]), Equals, compression)
+
+		decoded, err := DecodeValue(encoded)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, DeepEquals, large)
+	}
+
+	// Values without envelope are read as-is
+	decoded, err := DecodeValue(small)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, small)
+
+	_, err = DecodeValue([]byte{EnvelopeMarker, EnvelopeVersion + 1, byte(CompressionNone)})
+	c.Assert(err, Not(IsNil))
+	_, err = DecodeValue([]byte{EnvelopeMarker, EnvelopeVersion})
+	c.Assert(err, Not(IsNil))
+	_, err = DecodeValue([]byte{EnvelopeMarker, EnvelopeVersion, byte(CompressionGzip), 'x'})
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *independentSuite) TestEnvelopeDecompressionLimit(c *C) {
+	oldMax := option.Config.KVstoreMaxValueSize
+	defer func() { option.Config.KVstoreMaxValueSize = oldMax }()
+
+	large := bytes.Repeat([]byte("foo"), 1000)
+	option.Config.KVstoreMaxValueSize = len(large)
+
+	for _, compression := range []Compression{CompressionGzip, CompressionSnappy} {
+		encoded, err := EncodeValue(large, compression, 0)
+		c.Assert(err, IsNil)
+
+		decoded, err := DecodeValue(encoded)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, DeepEquals, large)
+
+		// Compressed values exceeding the maximum value size once
+		// decompressed are rejected
+		option.Config.KVstoreMaxValueSize = len(large) - 1
+		_, err = DecodeValue(encoded)
+		c.Assert(err, Not(IsNil))
+		option.Config.KVstoreMaxValueSize = len(large)
+	}
+}
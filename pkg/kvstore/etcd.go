@@ -57,6 +57,10 @@ var (
 	// ErrLockLeaseExpired is an error whenever the lease of the lock does not
 	// exist or it was expired.
 	ErrLockLeaseExpired = errors.New("transaction did not succeed: lock lease expired")
+
+	// ErrConditionalKeyAbsent is returned by CreateIfExists if the
+	// conditional key does not exist
+	ErrConditionalKeyAbsent = errors.New("conditional key not present")
 )
 
 func init() {
@@ -82,6 +86,14 @@ var (
 	// the etcd server
 	initialConnectionTimeout = 15 * time.Minute
 
+	// lockTimeout is the timeout for the acquisition of a lock by a
+	// foreground operation
+	lockTimeout = time.Minute
+
+	// backgroundRequestTimeout is the timeout of etcd requests and lock
+	// acquisitions performed by background operations
+	backgroundRequestTimeout = 15 * time.Second
+
 	minRequiredVersion, _ = version.NewConstraint(">= 3.1.0")
 
 	// etcdDummyAddress can be overwritten from test invokers using ldflags
@@ -110,6 +122,28 @@ func newEtcdModule() backendModule {
 					return err
 				},
 			},
+			EtcdReadPreferenceOption: &backendOption{
+				description: "Endpoint serving reads, \"leader\" or \"local\"",
+				validate:    validateReadPreference,
+			},
+			EtcdLocalEndpointOption: &backendOption{
+				description: "Endpoint preferred for reads with read preference \"local\"",
+			},
+			EtcdDiscoverySRVOption: &backendOption{
+				description: "DNS domain to discover etcd endpoints in via SRV records",
+			},
+			EtcdDiscoveryServiceOption: &backendOption{
+				description: "Kubernetes service \"<namespace>/<name>\" to discover etcd endpoints from",
+				validate:    validateDiscoveryService,
+			},
+			EtcdDiscoveryIntervalOption: &backendOption{
+				description: "Interval in which discovered etcd endpoints are refreshed",
+				validate:    validateDiscoveryInterval,
+			},
+			EtcdClockSkewThresholdOption: &backendOption{
+				description: "Clock skew between etcd endpoints and the local node above which a warning is logged, 0 to disable",
+				validate:    validateClockSkewThreshold,
+			},
 		},
 	}
 }
@@ -161,35 +195,69 @@ func (e *etcdModule) newClient(opts *ExtraOptions) (BackendOperations, chan erro
 	if configSet {
 		configPath = configPathOpt.value
 	}
+
+	var localEndpoint string
+	if readPreferenceOpt, ok := e.opts[EtcdReadPreferenceOption]; ok && readPreferenceOpt.value == ReadPreferenceLocal {
+		if localEndpointOpt, ok := e.opts[EtcdLocalEndpointOption]; ok {
+			localEndpoint = localEndpointOpt.value
+		}
+		if localEndpoint == "" {
+			errChan <- fmt.Errorf("invalid etcd configuration, %s must be specified for read preference %s",
+				EtcdLocalEndpointOption, ReadPreferenceLocal)
+			close(errChan)
+			return nil, errChan
+		}
+	}
+
+	clockSkewThreshold := defaultClockSkewThreshold
+	if thresholdOpt, ok := e.opts[EtcdClockSkewThresholdOption]; ok && thresholdOpt.value != "" {
+		// error is discarded here because this option has validation
+		clockSkewThreshold, _ = time.ParseDuration(thresholdOpt.value)
+	}
+
+	discovery, err := newEndpointDiscovery(e.opts, opts)
+	if err != nil {
+		errChan <- err
+		close(errChan)
+		return nil, errChan
+	}
+
 	if e.config == nil {
-		if !endpointsSet && !configSet {
+		if discovery != nil {
+			e.config = &client.Config{}
+		} else if !endpointsSet && !configSet {
 			errChan <- fmt.Errorf("invalid etcd configuration, %s or %s must be specified", EtcdOptionConfig, addrOption)
 			close(errChan)
 			return nil, errChan
 		}
 
-		if endpointsOpt.value == "" && configPath == "" {
+		if discovery == nil && endpointsOpt.value == "" && configPath == "" {
 			errChan <- fmt.Errorf("invalid etcd configuration, %s or %s must be specified",
 				EtcdOptionConfig, addrOption)
 			close(errChan)
 			return nil, errChan
 		}
 
-		e.config = &client.Config{}
+		if e.config == nil {
+			e.config = &client.Config{}
+		}
 	}
 
-	if e.config.Endpoints == nil && endpointsSet {
+	if e.config.Endpoints == nil && endpointsSet && discovery == nil {
 		e.config.Endpoints = []string{endpointsOpt.value}
 	}
 
 	for {
 		// connectEtcdClient will close errChan when the connection attempt has
 		// been successful
-		backend, err := connectEtcdClient(e.config, configPath, errChan, rateLimit, opts)
+		backend, err := connectEtcdClient(e.config, configPath, errChan, rateLimit, localEndpoint, discovery, clockSkewThreshold, opts)
 		switch {
 		case os.IsNotExist(err):
 			log.WithError(err).Info("Waiting for all etcd configuration files to be available")
 			time.Sleep(5 * time.Second)
+		case err == errNoEndpointsDiscovered:
+			log.WithField("source", discovery.source).Info("Waiting for etcd endpoints to be discovered")
+			time.Sleep(discoveryRetryInterval)
 		case err != nil:
 			errChan <- err
 			close(errChan)
@@ -248,6 +316,22 @@ type etcdClient struct {
 	extraOptions *ExtraOptions
 
 	limiter *rate.Limiter
+
+	// backgroundLimiter limits the rate of background operations
+	// separately from limiter so they never delay foreground operations
+	backgroundLimiter *rate.Limiter
+
+	// tlsReloader reloads the TLS files of the client on change, nil if
+	// TLS is not configured
+	tlsReloader *tlsReloader
+
+	// localReads performs serializable reads against the local etcd
+	// endpoint, nil if reads are served by the leader
+	localReads *localReads
+
+	// discovery refreshes the endpoints of the client, nil if the
+	// endpoints are configured statically
+	discovery *endpointDiscovery
 }
 
 func (e *etcdClient) getLogger() *logrus.Entry {
@@ -265,6 +349,24 @@ func (e *etcdClient) getLogger() *logrus.Entry {
 	})
 }
 
+// limiterFor returns the rate limiter for the priority of the request
+// carried by ctx
+func (e *etcdClient) limiterFor(ctx context.Context) *rate.Limiter {
+	if PriorityFromContext(ctx) == PriorityBackground && e.backgroundLimiter != nil {
+		return e.backgroundLimiter
+	}
+	return e.limiter
+}
+
+// requestContext returns the context to perform an etcd request with.
+// Background requests are bound by backgroundRequestTimeout.
+func requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if PriorityFromContext(ctx) == PriorityBackground {
+		return context.WithTimeout(ctx, backgroundRequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
 type etcdMutex struct {
 	mutex *concurrency.Mutex
 }
@@ -471,7 +573,8 @@ func (e *etcdClient) renewLockSession() error {
 	return nil
 }
 
-func connectEtcdClient(config *client.Config, cfgPath string, errChan chan error, rateLimit int, opts *ExtraOptions) (BackendOperations, error) {
+func connectEtcdClient(config *client.Config, cfgPath string, errChan chan error, rateLimit int, localEndpoint string, discovery *endpointDiscovery, clockSkewThreshold time.Duration, opts *ExtraOptions) (BackendOperations, error) {
+	var reloader *tlsReloader
 	if cfgPath != "" {
 		cfg, err := clientyaml.NewConfig(cfgPath)
 		if err != nil {
@@ -481,15 +584,58 @@ func connectEtcdClient(config *client.Config, cfgPath string, errChan chan error
 		config = cfg
 	}
 
+	// Discovered endpoints take precedence over the endpoints of the
+	// configuration file
+	if discovery != nil {
+		endpoints, err := discovery.endpoints(config.TLS != nil)
+		if err != nil {
+			if err != errNoEndpointsDiscovered {
+				log.WithError(err).Warning("Unable to discover etcd endpoints")
+			}
+			return nil, errNoEndpointsDiscovered
+		}
+		config.Endpoints = endpoints
+	}
+
+	if cfgPath != "" {
+		var err error
+
+		// Reload client certificates and trusted CAs on change so
+		// that their rotation does not require a new client
+		reloader, err = newTLSReloader(cfgPath, config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("unable to watch etcd TLS files: %s", err)
+		}
+		if reloader != nil {
+			config.DialOptions = append(config.DialOptions, reloader.dialOptions()...)
+		}
+	}
+
 	// Set DialTimeout to 0, otherwise the creation of a new client will
 	// block until DialTimeout is reached or a connection to the server
 	// is made.
 	config.DialTimeout = 0
 	c, err := client.New(*config)
 	if err != nil {
+		if reloader != nil {
+			reloader.close()
+		}
 		return nil, err
 	}
 
+	var local *localReads
+	if localEndpoint != "" {
+		local, err = newLocalReads(*config, localEndpoint)
+		if err != nil {
+			c.Close()
+			if reloader != nil {
+				reloader.close()
+			}
+			return nil, fmt.Errorf("unable to connect to local etcd endpoint: %s", err)
+		}
+		log.WithField("endpoint", localEndpoint).Info("Preferring local etcd endpoint for reads")
+	}
+
 	log.WithFields(logrus.Fields{
 		"endpoints": config.Endpoints,
 		"config":    cfgPath,
@@ -533,6 +679,10 @@ func connectEtcdClient(config *client.Config, cfgPath string, errChan chan error
 		stopStatusChecker:    make(chan struct{}),
 		extraOptions:         opts,
 		limiter:              rate.NewLimiter(rate.Limit(rateLimit), rateLimit),
+		backgroundLimiter:    rate.NewLimiter(rate.Limit(rateLimit), rateLimit),
+		tlsReloader:          reloader,
+		localReads:           local,
+		discovery:            discovery,
 	}
 
 	// wait for session to be created also in parallel
@@ -578,6 +728,28 @@ func connectEtcdClient(config *client.Config, cfgPath string, errChan chan error
 		},
 	)
 
+	if discovery != nil {
+		ec.controllers.UpdateController("kvstore-etcd-endpoint-discovery",
+			controller.ControllerParams{
+				DoFunc: func(ctx context.Context) error {
+					return ec.refreshEndpoints()
+				},
+				RunInterval: discovery.interval,
+			},
+		)
+	}
+
+	if clockSkewThreshold > 0 {
+		ec.controllers.UpdateController("kvstore-etcd-clock-skew-check",
+			controller.ControllerParams{
+				DoFunc: func(ctx context.Context) error {
+					return ec.checkClockSkew(ctx, clockSkewThreshold)
+				},
+				RunInterval: clockSkewCheckInterval,
+			},
+		)
+	}
+
 	return ec, nil
 }
 
@@ -634,14 +806,20 @@ func (e *etcdClient) LockPath(ctx context.Context, path string) (KVLocker, error
 		return nil, fmt.Errorf("lock cancelled via context: %s", ctx.Err())
 	}
 
+	duration := spanstat.Start()
 	e.RLock()
 	mu := concurrency.NewMutex(e.lockSession, path)
 	leaseID := e.lockSession.Lease()
 	e.RUnlock()
 
-	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	timeout := lockTimeout
+	if PriorityFromContext(ctx) == PriorityBackground {
+		timeout = backgroundRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	err := mu.Lock(ctx)
+	increaseMetric(path, metricLock, "Lock", duration.EndError(err).Total(), err)
 	if err != nil {
 		e.checkLockSession(err, leaseID)
 		return nil, Hint(err)
@@ -650,10 +828,19 @@ func (e *etcdClient) LockPath(ctx context.Context, path string) (KVLocker, error
 	return &etcdMutex{mutex: mu}, nil
 }
 
+// DeletePrefix implements BackendOperations
 func (e *etcdClient) DeletePrefix(path string) error {
+	return e.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (e *etcdClient) DeletePrefixContext(ctx context.Context, path string) error {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx.TODO())
-	_, err := e.client.Delete(ctx.Background(), path, client.WithPrefix())
+	e.limiterFor(ctx).Wait(ctx)
+	_, err := e.client.Delete(ctx, path, client.WithPrefix())
 	increaseMetric(path, metricDelete, "DeletePrefix", duration.EndError(err).Total(), err)
 	return Hint(err)
 }
@@ -669,10 +856,28 @@ func (e *etcdClient) Watch(w *Watcher) {
 	})
 	<-e.Connected()
 
+	// watchCtx is cancelled when the watcher is stopped so that waiting
+	// for the rate limiter or for etcd never outlives the watcher
+	watchCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stopWatch:
+			cancel()
+		case <-watchCtx.Done():
+		}
+	}()
+
 reList:
 	for {
-		e.limiter.Wait(ctx.TODO())
-		res, err := e.client.Get(ctx.Background(), w.prefix, client.WithPrefix(),
+		if watchCtx.Err() != nil {
+			close(w.Events)
+			w.stopWait.Done()
+			return
+		}
+
+		e.limiter.Wait(watchCtx)
+		res, err := e.client.Get(watchCtx, w.prefix, client.WithPrefix(),
 			client.WithSerializable())
 		if err != nil {
 			scopedLog.WithError(Hint(err)).Warn("Unable to list keys before starting watcher")
@@ -684,6 +889,12 @@ reList:
 
 		if res.Count > 0 {
 			for _, key := range res.Kvs {
+				// Oversized values are not marked in use
+				// and thus removed from all caches below
+				if quarantineValue(string(key.Key), key.Value) {
+					continue
+				}
+
 				t := EventTypeCreate
 				if localCache.Exists(key.Key) {
 					t = EventTypeModify
@@ -694,9 +905,10 @@ reList:
 
 				queueStart := spanstat.Start()
 				w.Events <- KeyValueEvent{
-					Key:   string(key.Key),
-					Value: key.Value,
-					Typ:   t,
+					Key:         string(key.Key),
+					Value:       key.Value,
+					Typ:         t,
+					ModRevision: uint64(key.ModRevision),
 				}
 				trackEventQueued(string(key.Key), t, queueStart.End(true).Total())
 			}
@@ -712,8 +924,9 @@ reList:
 		// received via Get
 		localCache.RemoveDeleted(func(k string) {
 			event := KeyValueEvent{
-				Key: k,
-				Typ: EventTypeDelete,
+				Key:         k,
+				Typ:         EventTypeDelete,
+				ModRevision: uint64(res.Header.Revision),
 			}
 
 			scopedLog.Debugf("Emitting EventTypeDelete event for %s", k)
@@ -731,8 +944,8 @@ reList:
 	recreateWatcher:
 		scopedLog.WithField(fieldRev, nextRev).Debug("Starting to watch a prefix")
 
-		e.limiter.Wait(ctx.TODO())
-		etcdWatch := e.client.Watch(ctx.Background(), w.prefix,
+		e.limiter.Wait(watchCtx)
+		etcdWatch := e.client.Watch(watchCtx, w.prefix,
 			client.WithPrefix(), client.WithRev(nextRev))
 		for {
 			select {
@@ -743,20 +956,32 @@ reList:
 
 			case r, ok := <-etcdWatch:
 				if !ok {
-					time.Sleep(50 * time.Millisecond)
+					if watchCtx.Err() == nil {
+						time.Sleep(50 * time.Millisecond)
+					}
 					goto recreateWatcher
 				}
 
 				scopedLog := scopedLog.WithField(fieldRev, r.Header.Revision)
 
 				if err := r.Err(); err != nil {
+					// The watch broke for a reason
+					// unrelated to the revision, e.g. a
+					// lost connection or leader election.
+					// Resume watching from the last seen
+					// revision instead of listing the
+					// entire prefix again.
+					if !watchRequiresReList(err) {
+						scopedLog.WithError(Hint(err)).Debug("Watch failed, resuming from last seen revision")
+						time.Sleep(50 * time.Millisecond)
+						goto recreateWatcher
+					}
+
 					// We tried to watch on a compacted
 					// revision that may no longer exist,
-					// recreate the watcher and try to
-					// watch on the next possible revision
-					if err == v3rpcErrors.ErrCompacted {
-						scopedLog.WithError(Hint(err)).Debug("Tried watching on compacted revision")
-					}
+					// list the prefix again and watch on
+					// the next possible revision
+					scopedLog.WithError(Hint(err)).Debug("Tried watching on unavailable revision")
 
 					// mark all local keys in state for
 					// deletion unless the upcoming GET
@@ -771,14 +996,24 @@ reList:
 
 				for _, ev := range r.Events {
 					event := KeyValueEvent{
-						Key:   string(ev.Kv.Key),
-						Value: ev.Kv.Value,
+						Key:         string(ev.Kv.Key),
+						Value:       ev.Kv.Value,
+						ModRevision: uint64(ev.Kv.ModRevision),
 					}
 
 					switch {
 					case ev.Type == client.EventTypeDelete:
 						event.Typ = EventTypeDelete
 						localCache.RemoveKey(ev.Kv.Key)
+					case quarantineValue(event.Key, event.Value):
+						// Remove a previously valid value
+						// from all caches
+						if !localCache.Exists(ev.Kv.Key) {
+							continue
+						}
+						event.Typ = EventTypeDelete
+						event.Value = nil
+						localCache.RemoveKey(ev.Kv.Key)
 					case ev.IsCreate():
 						event.Typ = EventTypeCreate
 						localCache.MarkInUse(ev.Kv.Key)
@@ -798,6 +1033,36 @@ reList:
 	}
 }
 
+// watchRequiresReList returns true if a watch which failed with err cannot
+// be resumed from the last seen revision because the revision has been
+// compacted or is unknown to the etcd cluster. The prefix must then be listed
+// again to recover the state.
+func watchRequiresReList(err error) bool {
+	return err == v3rpcErrors.ErrCompacted || err == v3rpcErrors.ErrFutureRev
+}
+
+// LeaseStatus returns the status of the session lease
+func (e *etcdClient) LeaseStatus(ctx context.Context) (LeaseStatus, error) {
+	leaseID := e.GetSessionLeaseID()
+
+	e.limiterFor(ctx).Wait(ctx)
+	resp, err := e.client.TimeToLive(ctx, leaseID)
+	if err != nil {
+		return LeaseStatus{}, Hint(err)
+	}
+
+	status := LeaseStatus{
+		ID:  fmt.Sprintf("%x", leaseID),
+		TTL: time.Duration(resp.GrantedTTL) * time.Second,
+	}
+	// A negative TTL indicates that the lease has expired
+	if resp.TTL > 0 {
+		status.Remaining = time.Duration(resp.TTL) * time.Second
+	}
+
+	return status, nil
+}
+
 func (e *etcdClient) determineEndpointStatus(endpointAddress string) (string, error) {
 	ctxTimeout, cancel := ctx.WithTimeout(ctx.Background(), statusCheckTimeout)
 	defer cancel()
@@ -837,6 +1102,10 @@ func (e *etcdClient) statusChecker() {
 
 		allConnected := len(endpoints) == ok
 
+		if e.localReads != nil {
+			e.localReads.checkHealth()
+		}
+
 		e.RWMutex.RLock()
 		sessionLeaseID := e.session.Lease()
 		lockSessionLeaseID := e.lockSession.Lease()
@@ -872,11 +1141,19 @@ func (e *etcdClient) Status() (string, error) {
 
 // GetIfLocked returns value of key if the client is still holding the given lock.
 func (e *etcdClient) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
+	return e.GetIfLockedContext(context.TODO(), key, lock)
+}
+
+// GetIfLockedContext implements BackendOperations
+func (e *etcdClient) GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx.TODO())
+	e.limiterFor(ctx).Wait(ctx)
 	opGet := client.OpGet(key)
 	cmp := lock.Comparator().(client.Cmp)
-	txnReply, err := e.client.Txn(context.Background()).If(cmp).Then(opGet).Commit()
+	txnReply, err := e.client.Txn(ctx).If(cmp).Then(opGet).Commit()
 	if err == nil && !txnReply.Succeeded {
 		err = ErrLockLeaseExpired
 	}
@@ -887,7 +1164,7 @@ func (e *etcdClient) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
 
 	getR := txnReply.Responses[0].GetResponseRange()
 	// RangeResponse
-	if getR.Count == 0 {
+	if getR.Count == 0 || quarantineValue(key, getR.Kvs[0].Value) {
 		return nil, nil
 	}
 	return getR.Kvs[0].Value, nil
@@ -895,73 +1172,141 @@ func (e *etcdClient) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
 
 // Get returns value of key
 func (e *etcdClient) Get(key string) ([]byte, error) {
+	return e.GetContext(context.TODO(), key)
+}
+
+// GetContext implements BackendOperations
+func (e *etcdClient) GetContext(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx.TODO())
-	getR, err := e.client.Get(ctx.Background(), key)
+	e.limiterFor(ctx).Wait(ctx)
+	getR, err := e.get(ctx, key)
 	increaseMetric(key, metricRead, "Get", duration.EndError(err).Total(), err)
 	if err != nil {
 		return nil, Hint(err)
 	}
 
-	if getR.Count == 0 {
+	if getR.Count == 0 || quarantineValue(key, getR.Kvs[0].Value) {
 		return nil, nil
 	}
 	return getR.Kvs[0].Value, nil
 }
 
-// GetPrefixIfLocked returns the first key which matches the prefix and its value if the client is still holding the given lock.
-func (e *etcdClient) GetPrefixIfLocked(ctx context.Context, prefix string, lock KVLocker) (string, []byte, error) {
-	duration := spanstat.Start()
-	e.limiter.Wait(ctx)
-	opGet := client.OpGet(prefix, client.WithPrefix(), client.WithLimit(1))
-	cmp := lock.Comparator().(client.Cmp)
-	txnReply, err := e.client.Txn(ctx).If(cmp).Then(opGet).Commit()
-	if err == nil && !txnReply.Succeeded {
-		err = ErrLockLeaseExpired
+// getPrefixPageSize is the number of keys fetched per request by GetPrefix
+// and GetPrefixIfLocked. Further keys are only fetched if all keys of a page
+// hold oversized values.
+const getPrefixPageSize = 16
+
+// firstValidKey returns the first key of getR whose value is not
+// quarantined. If there is none, the returned options fetch the next page of
+// keys matching prefix at the same revision, or are nil if there are no
+// further keys.
+func firstValidKey(prefix string, getR *client.GetResponse) (string, []byte, []client.OpOption) {
+	for _, kv := range getR.Kvs {
+		if !quarantineValue(string(kv.Key), kv.Value) {
+			return string(kv.Key), kv.Value, nil
+		}
 	}
-	increaseMetric(prefix, metricRead, "GetPrefixLocked", duration.EndError(err).Total(), err)
-	if err != nil {
-		return "", nil, Hint(err)
+	if !getR.More || len(getR.Kvs) == 0 {
+		return "", nil, nil
 	}
-	getR := txnReply.Responses[0].GetResponseRange()
 
-	if getR.Count == 0 {
-		return "", nil, nil
+	next := string(getR.Kvs[len(getR.Kvs)-1].Key) + "\x00"
+	return next, nil, []client.OpOption{
+		client.WithRange(client.GetPrefixRangeEnd(prefix)),
+		client.WithLimit(getPrefixPageSize),
+		client.WithRev(getR.Header.Revision),
+	}
+}
+
+// GetPrefixIfLocked returns the first key which matches the prefix and its value if the client is still holding the given lock.
+func (e *etcdClient) GetPrefixIfLocked(ctx context.Context, prefix string, lock KVLocker) (string, []byte, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	key, opts := prefix, []client.OpOption{client.WithPrefix(), client.WithLimit(getPrefixPageSize)}
+	for {
+		duration := spanstat.Start()
+		e.limiterFor(ctx).Wait(ctx)
+		opGet := client.OpGet(key, opts...)
+		cmp := lock.Comparator().(client.Cmp)
+		txnReply, err := e.client.Txn(ctx).If(cmp).Then(opGet).Commit()
+		if err == nil && !txnReply.Succeeded {
+			err = ErrLockLeaseExpired
+		}
+		increaseMetric(prefix, metricRead, "GetPrefixLocked", duration.EndError(err).Total(), err)
+		if err != nil {
+			return "", nil, Hint(err)
+		}
+		getR := (*client.GetResponse)(txnReply.Responses[0].GetResponseRange())
+
+		var value []byte
+		if key, value, opts = firstValidKey(prefix, getR); opts == nil {
+			return key, value, nil
+		}
 	}
-	return string(getR.Kvs[0].Key), getR.Kvs[0].Value, nil
 }
 
 // GetPrefix returns the first key which matches the prefix and its value
 func (e *etcdClient) GetPrefix(ctx context.Context, prefix string) (string, []byte, error) {
-	duration := spanstat.Start()
-	e.limiter.Wait(ctx)
-	getR, err := e.client.Get(ctx, prefix, client.WithPrefix(), client.WithLimit(1))
-	increaseMetric(prefix, metricRead, "GetPrefix", duration.EndError(err).Total(), err)
-	if err != nil {
-		return "", nil, Hint(err)
-	}
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
 
-	if getR.Count == 0 {
-		return "", nil, nil
+	key, opts := prefix, []client.OpOption{client.WithPrefix(), client.WithLimit(getPrefixPageSize)}
+	for {
+		duration := spanstat.Start()
+		e.limiterFor(ctx).Wait(ctx)
+		getR, err := e.client.Get(ctx, key, opts...)
+		increaseMetric(prefix, metricRead, "GetPrefix", duration.EndError(err).Total(), err)
+		if err != nil {
+			return "", nil, Hint(err)
+		}
+
+		var value []byte
+		if key, value, opts = firstValidKey(prefix, getR); opts == nil {
+			return key, value, nil
+		}
 	}
-	return string(getR.Kvs[0].Key), getR.Kvs[0].Value, nil
 }
 
 // Set sets value of key
 func (e *etcdClient) Set(key string, value []byte) error {
+	return e.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (e *etcdClient) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx.TODO())
-	_, err := e.client.Put(ctx.Background(), key, string(value))
+	e.limiterFor(ctx).Wait(ctx)
+	_, err := e.client.Put(ctx, key, string(value))
 	increaseMetric(key, metricSet, "Set", duration.EndError(err).Total(), err)
 	return Hint(err)
 }
 
 // DeleteIfLocked deletes a key if the client is still holding the given lock.
 func (e *etcdClient) DeleteIfLocked(key string, lock KVLocker) error {
+	return e.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (e *etcdClient) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
+	e.limiterFor(ctx).Wait(ctx)
 	opDel := client.OpDelete(key)
 	cmp := lock.Comparator().(client.Cmp)
-	txnReply, err := e.client.Txn(context.Background()).If(cmp).Then(opDel).Commit()
+	txnReply, err := e.client.Txn(ctx).If(cmp).Then(opDel).Commit()
 	if err == nil && !txnReply.Succeeded {
 		err = ErrLockLeaseExpired
 	}
@@ -971,9 +1316,17 @@ func (e *etcdClient) DeleteIfLocked(key string, lock KVLocker) error {
 
 // Delete deletes a key
 func (e *etcdClient) Delete(key string) error {
+	return e.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (e *etcdClient) DeleteContext(ctx context.Context, key string) error {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx.TODO())
-	_, err := e.client.Delete(ctx.Background(), key)
+	e.limiterFor(ctx).Wait(ctx)
+	_, err := e.client.Delete(ctx, key)
 	increaseMetric(key, metricDelete, "Delete", duration.EndError(err).Total(), err)
 	return Hint(err)
 }
@@ -990,6 +1343,13 @@ func (e *etcdClient) createOpPut(key string, value []byte, leaseID client.LeaseI
 
 // UpdateIfLocked atomically creates a key or fails if it already exists if the client is still holding the given lock.
 func (e *etcdClient) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) error {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
 	select {
 	case <-e.firstSession:
 	case <-ctx.Done():
@@ -1002,7 +1362,7 @@ func (e *etcdClient) UpdateIfLocked(ctx context.Context, key string, value []byt
 	)
 
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx)
+	e.limiterFor(ctx).Wait(ctx)
 	if lease {
 		leaseID := e.GetSessionLeaseID()
 		opPut := client.OpPut(key, string(value), client.WithLease(leaseID))
@@ -1023,6 +1383,13 @@ func (e *etcdClient) UpdateIfLocked(ctx context.Context, key string, value []byt
 
 // Update creates or updates a key
 func (e *etcdClient) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
 	select {
 	case <-e.firstSession:
 	case <-ctx.Done():
@@ -1032,7 +1399,7 @@ func (e *etcdClient) Update(ctx context.Context, key string, value []byte, lease
 	if lease {
 		duration := spanstat.Start()
 		leaseID := e.GetSessionLeaseID()
-		e.limiter.Wait(ctx)
+		e.limiterFor(ctx).Wait(ctx)
 		_, err := e.client.Put(ctx, key, string(value), client.WithLease(leaseID))
 		e.checkSession(err, leaseID)
 		increaseMetric(key, metricSet, "Update", duration.EndError(err).Total(), err)
@@ -1040,7 +1407,7 @@ func (e *etcdClient) Update(ctx context.Context, key string, value []byte, lease
 	}
 
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx)
+	e.limiterFor(ctx).Wait(ctx)
 	_, err := e.client.Put(ctx, key, string(value))
 	increaseMetric(key, metricSet, "Update", duration.EndError(err).Total(), err)
 	return Hint(err)
@@ -1048,13 +1415,16 @@ func (e *etcdClient) Update(ctx context.Context, key string, value []byte, lease
 
 // UpdateIfDifferentIfLocked updates a key if the value is different and if the client is still holding the given lock.
 func (e *etcdClient) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	select {
 	case <-e.firstSession:
 	case <-ctx.Done():
 		return false, fmt.Errorf("update cancelled via context: %s", ctx.Err())
 	}
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx)
+	e.limiterFor(ctx).Wait(ctx)
 	cnds := lock.Comparator().(client.Cmp)
 	txnresp, err := e.client.Txn(ctx).If(cnds).Then(client.OpGet(key)).Commit()
 
@@ -1092,6 +1462,9 @@ func (e *etcdClient) UpdateIfDifferentIfLocked(ctx context.Context, key string,
 
 // UpdateIfDifferent updates a key if the value is different
 func (e *etcdClient) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	select {
 	case <-e.firstSession:
 	case <-ctx.Done():
@@ -1099,7 +1472,7 @@ func (e *etcdClient) UpdateIfDifferent(ctx context.Context, key string, value []
 	}
 
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx)
+	e.limiterFor(ctx).Wait(ctx)
 	getR, err := e.client.Get(ctx, key)
 	increaseMetric(key, metricRead, "Get", duration.EndError(err).Total(), err)
 	// On error, attempt update blindly
@@ -1124,6 +1497,13 @@ func (e *etcdClient) UpdateIfDifferent(ctx context.Context, key string, value []
 
 // CreateOnlyIfLocked atomically creates a key if the client is still holding the given lock or fails if it already exists
 func (e *etcdClient) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	if err := checkValueSize(key, value); err != nil {
+		return false, err
+	}
+
 	duration := spanstat.Start()
 	var leaseID client.LeaseID
 	if lease {
@@ -1141,7 +1521,7 @@ func (e *etcdClient) CreateOnlyIfLocked(ctx context.Context, key string, value [
 		client.OpGet(key),
 	}
 
-	e.limiter.Wait(ctx)
+	e.limiterFor(ctx).Wait(ctx)
 	txnresp, err := e.client.Txn(ctx).If(cnds...).Then(*req).Else(opGets...).Commit()
 	increaseMetric(key, metricSet, "CreateOnlyLocked", duration.EndError(err).Total(), err)
 	if err != nil {
@@ -1178,6 +1558,13 @@ func (e *etcdClient) CreateOnlyIfLocked(ctx context.Context, key string, value [
 
 // CreateOnly creates a key with the value and will fail if the key already exists
 func (e *etcdClient) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
+	if err := checkValueSize(key, value); err != nil {
+		return false, err
+	}
+
 	duration := spanstat.Start()
 	var leaseID client.LeaseID
 	if lease {
@@ -1186,7 +1573,7 @@ func (e *etcdClient) CreateOnly(ctx context.Context, key string, value []byte, l
 	req := e.createOpPut(key, value, leaseID)
 	cond := client.Compare(client.Version(key), "=", 0)
 
-	e.limiter.Wait(ctx)
+	e.limiterFor(ctx).Wait(ctx)
 	txnresp, err := e.client.Txn(ctx).If(cond).Then(*req).Commit()
 	increaseMetric(key, metricSet, "CreateOnly", duration.EndError(err).Total(), err)
 	if err != nil {
@@ -1199,6 +1586,18 @@ func (e *etcdClient) CreateOnly(ctx context.Context, key string, value []byte, l
 
 // CreateIfExists creates a key with the value only if key condKey exists
 func (e *etcdClient) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return e.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (e *etcdClient) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
 	var leaseID client.LeaseID
 	if lease {
@@ -1207,8 +1606,8 @@ func (e *etcdClient) CreateIfExists(condKey, key string, value []byte, lease boo
 	req := e.createOpPut(key, value, leaseID)
 	cond := client.Compare(client.Version(condKey), "!=", 0)
 
-	e.limiter.Wait(ctx.TODO())
-	txnresp, err := e.client.Txn(ctx.TODO()).If(cond).Then(*req).Commit()
+	e.limiterFor(ctx).Wait(ctx)
+	txnresp, err := e.client.Txn(ctx).If(cond).Then(*req).Commit()
 	increaseMetric(key, metricSet, "CreateIfExists", duration.EndError(err).Total(), err)
 	if err != nil {
 		e.checkSession(err, leaseID)
@@ -1216,7 +1615,7 @@ func (e *etcdClient) CreateIfExists(condKey, key string, value []byte, lease boo
 	}
 
 	if !txnresp.Succeeded {
-		return fmt.Errorf("create was unsuccessful")
+		return ErrConditionalKeyAbsent
 	}
 
 	return nil
@@ -1243,11 +1642,19 @@ func (e *etcdClient) CreateIfExists(condKey, key string, value []byte, lease boo
 
 // ListPrefixIfLocked returns a list of keys matching the prefix only if the client is still holding the given lock.
 func (e *etcdClient) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
+	return e.ListPrefixIfLockedContext(context.TODO(), prefix, lock)
+}
+
+// ListPrefixIfLockedContext implements BackendOperations
+func (e *etcdClient) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
-	e.limiter.Wait(ctx.TODO())
+	e.limiterFor(ctx).Wait(ctx)
 	opGet := client.OpGet(prefix, client.WithPrefix())
 	cmp := lock.Comparator().(client.Cmp)
-	txnReply, err := e.client.Txn(context.Background()).If(cmp).Then(opGet).Commit()
+	txnReply, err := e.client.Txn(ctx).If(cmp).Then(opGet).Commit()
 	if err == nil && !txnReply.Succeeded {
 		err = ErrLockLeaseExpired
 	}
@@ -1259,6 +1666,10 @@ func (e *etcdClient) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValueP
 
 	pairs := KeyValuePairs(make(map[string]Value, getR.Count))
 	for i := int64(0); i < getR.Count; i++ {
+		if quarantineValue(string(getR.Kvs[i].Key), getR.Kvs[i].Value) {
+			continue
+		}
+
 		pairs[string(getR.Kvs[i].Key)] = Value{
 			Data:        getR.Kvs[i].Value,
 			ModRevision: uint64(getR.Kvs[i].ModRevision),
@@ -1271,10 +1682,18 @@ func (e *etcdClient) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValueP
 
 // ListPrefix returns a map of matching keys
 func (e *etcdClient) ListPrefix(prefix string) (KeyValuePairs, error) {
+	return e.ListPrefixContext(context.TODO(), prefix)
+}
+
+// ListPrefixContext implements BackendOperations
+func (e *etcdClient) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+
 	duration := spanstat.Start()
 
-	e.limiter.Wait(ctx.TODO())
-	getR, err := e.client.Get(ctx.Background(), prefix, client.WithPrefix())
+	e.limiterFor(ctx).Wait(ctx)
+	getR, err := e.get(ctx, prefix, client.WithPrefix())
 	increaseMetric(prefix, metricRead, "ListPrefix", duration.EndError(err).Total(), err)
 	if err != nil {
 		return nil, Hint(err)
@@ -1282,6 +1701,10 @@ func (e *etcdClient) ListPrefix(prefix string) (KeyValuePairs, error) {
 
 	pairs := KeyValuePairs(make(map[string]Value, getR.Count))
 	for i := int64(0); i < getR.Count; i++ {
+		if quarantineValue(string(getR.Kvs[i].Key), getR.Kvs[i].Value) {
+			continue
+		}
+
 		pairs[string(getR.Kvs[i].Key)] = Value{
 			Data:        getR.Kvs[i].Value,
 			ModRevision: uint64(getR.Kvs[i].ModRevision),
@@ -1304,6 +1727,12 @@ func (e *etcdClient) Close() {
 	e.lockSession.Close()
 	e.session.Close()
 	e.client.Close()
+	if e.localReads != nil {
+		e.localReads.close()
+	}
+	if e.tlsReloader != nil {
+		e.tlsReloader.close()
+	}
 }
 
 // GetCapabilities returns the capabilities of the backend
@@ -0,0 +1,154 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EtcdClockSkewThresholdOption is the difference between the clock of
+	// an etcd endpoint and the local clock above which a warning is logged.
+	// A threshold of 0 disables the check.
+	EtcdClockSkewThresholdOption = "etcd.clockSkewThreshold"
+
+	// defaultClockSkewThreshold is the default value of
+	// EtcdClockSkewThresholdOption
+	defaultClockSkewThreshold = 5 * time.Second
+
+	// clockSkewCheckInterval is the interval in which the clocks of the
+	// etcd endpoints are compared to the local clock
+	clockSkewCheckInterval = 5 * time.Minute
+
+	// clockSkewCheckTimeout is the timeout of a single clock comparison
+	clockSkewCheckTimeout = 10 * time.Second
+
+	// dateResolution is the resolution of the HTTP Date header
+	dateResolution = time.Second
+)
+
+// validateClockSkewThreshold validates the value of
+// EtcdClockSkewThresholdOption
+func validateClockSkewThreshold(v string) error {
+	threshold, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	if threshold < 0 {
+		return fmt.Errorf("clock skew threshold must not be negative")
+	}
+	return nil
+}
+
+// estimateClockSkew estimates the offset of the clock of a server to the
+// local clock based on the Date header of a response received at received
+// to a request sent at sent. The server time is assumed to be taken halfway
+// through the round trip. Returns the estimated skew, positive if the server
+// clock is ahead, and the uncertainty of the estimation caused by the round
+// trip and the resolution of the Date header.
+func estimateClockSkew(date, sent, received time.Time) (skew, uncertainty time.Duration) {
+	rtt := received.Sub(sent)
+	local := sent.Add(rtt / 2)
+	// The Date header is truncated to the second
+	server := date.Add(dateResolution / 2)
+	return server.Sub(local), rtt/2 + dateResolution/2
+}
+
+// exceedsClockSkewThreshold returns true if the skew exceeds threshold even
+// when accounting for the uncertainty of its estimation
+func exceedsClockSkewThreshold(skew, uncertainty, threshold time.Duration) bool {
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew-uncertainty > threshold
+}
+
+// endpointURL returns the URL of the etcd endpoint ep, which may lack a
+// scheme
+func endpointURL(ep string, secure bool) string {
+	if strings.Contains(ep, "://") {
+		return ep
+	}
+	if secure {
+		return "https://" + ep
+	}
+	return "http://" + ep
+}
+
+// endpointClockSkew estimates the clock skew between the etcd endpoint ep
+// and the local clock from the Date header of the etcd version endpoint
+func (e *etcdClient) endpointClockSkew(ctx context.Context, httpClient *http.Client, ep string) (skew, uncertainty time.Duration, err error) {
+	url := strings.TrimSuffix(endpointURL(ep, e.config.TLS != nil), "/") + "/version"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sent := time.Now()
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, 0, err
+	}
+	received := time.Now()
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Date header: %s", err)
+	}
+
+	skew, uncertainty = estimateClockSkew(date, sent, received)
+	return skew, uncertainty, nil
+}
+
+// checkClockSkew compares the clocks of all etcd endpoints with the local
+// clock and warns about endpoints whose clock differs by more than
+// threshold. The kvstore users do not depend on the clocks being in sync,
+// leases and revisions are maintained by etcd alone, but a material skew
+// makes timestamps of different nodes incomparable, e.g., in logs and in
+// state published to the kvstore.
+func (e *etcdClient) checkClockSkew(ctx context.Context, threshold time.Duration) error {
+	transport := &http.Transport{TLSClientConfig: e.config.TLS}
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{
+		Transport: transport,
+		Timeout:   clockSkewCheckTimeout,
+	}
+
+	for _, ep := range e.client.Endpoints() {
+		scopedLog := e.getLogger().WithField("endpoint", ep)
+
+		skew, uncertainty, err := e.endpointClockSkew(ctx, httpClient, ep)
+		if err != nil {
+			scopedLog.WithError(err).Debug("Unable to compare clock of etcd endpoint")
+			continue
+		}
+
+		if exceedsClockSkewThreshold(skew, uncertainty, threshold) {
+			scopedLog.WithFields(logrus.Fields{
+				"skew":      skew,
+				"threshold": threshold,
+			}).Warning("Clock of etcd endpoint differs from the local clock, ensure that the clocks are synchronized")
+		}
+	}
+
+	return nil
+}
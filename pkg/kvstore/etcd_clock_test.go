@@ -0,0 +1,74 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	client "github.com/coreos/etcd/clientv3"
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestEstimateClockSkew(c *C) {
+	sent := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	received := sent.Add(200 * time.Millisecond)
+
+	// Server clock 10s ahead, the Date header is truncated to the second
+	skew, uncertainty := estimateClockSkew(sent.Add(10*time.Second), sent, received)
+	c.Assert(skew, Equals, 10*time.Second+400*time.Millisecond)
+	c.Assert(uncertainty, Equals, 600*time.Millisecond)
+	c.Assert(exceedsClockSkewThreshold(skew, uncertainty, 5*time.Second), Equals, true)
+
+	// Server clock 10s behind
+	skew, uncertainty = estimateClockSkew(sent.Add(-10*time.Second), sent, received)
+	c.Assert(exceedsClockSkewThreshold(skew, uncertainty, 5*time.Second), Equals, true)
+
+	// Clocks in sync
+	skew, uncertainty = estimateClockSkew(sent, sent, received)
+	c.Assert(exceedsClockSkewThreshold(skew, uncertainty, 5*time.Second), Equals, false)
+
+	// The uncertainty of a slow round trip does not cause a warning
+	skew, uncertainty = estimateClockSkew(sent.Add(6*time.Second), sent, sent.Add(12*time.Second))
+	c.Assert(exceedsClockSkewThreshold(skew, uncertainty, 5*time.Second), Equals, false)
+
+	c.Assert(validateClockSkewThreshold("5s"), IsNil)
+	c.Assert(validateClockSkewThreshold("0s"), IsNil)
+	c.Assert(validateClockSkewThreshold("-1s"), Not(IsNil))
+	c.Assert(validateClockSkewThreshold("foo"), Not(IsNil))
+}
+
+func (s *independentSuite) TestEndpointClockSkew(c *C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/version")
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		w.Write([]byte(`{"etcdserver":"3.3.12","etcdcluster":"3.3.0"}`))
+	}))
+	defer server.Close()
+
+	e := &etcdClient{config: &client.Config{}}
+	skew, uncertainty, err := e.endpointClockSkew(context.Background(), server.Client(), server.URL)
+	c.Assert(err, IsNil)
+	c.Assert(exceedsClockSkewThreshold(skew, uncertainty, 5*time.Second), Equals, true)
+	c.Assert(skew < -59*time.Minute, Equals, true)
+
+	c.Assert(endpointURL("etcd.local:2379", true), Equals, "https://etcd.local:2379")
+	c.Assert(endpointURL("etcd.local:2379", false), Equals, "http://etcd.local:2379")
+	c.Assert(endpointURL("http://etcd.local:2379", true), Equals, "http://etcd.local:2379")
+}
@@ -0,0 +1,268 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EtcdDiscoverySRVOption is the DNS domain in which the etcd endpoints
+	// are discovered via the "_etcd-client-ssl._tcp" and "_etcd-client._tcp"
+	// SRV records
+	EtcdDiscoverySRVOption = "etcd.discoverySRV"
+
+	// EtcdDiscoveryServiceOption is the Kubernetes service, in the form
+	// "<namespace>/<name>", whose endpoints are the etcd endpoints
+	EtcdDiscoveryServiceOption = "etcd.discoveryService"
+
+	// EtcdServicePortName is the name of the port of the etcd endpoints
+	// used if the Kubernetes service exposes several ports
+	EtcdServicePortName = "client"
+
+	// EtcdDiscoveryIntervalOption is the interval in which the discovered
+	// etcd endpoints are refreshed
+	EtcdDiscoveryIntervalOption = "etcd.discoveryInterval"
+
+	// defaultDiscoveryInterval is the default interval in which the
+	// discovered etcd endpoints are refreshed
+	defaultDiscoveryInterval = 30 * time.Second
+
+	// discoveryRetryInterval is the interval in which the discovery of the
+	// initial etcd endpoints is retried
+	discoveryRetryInterval = 5 * time.Second
+)
+
+var (
+	// errNoEndpointsDiscovered is returned if the discovery did not yield
+	// any etcd endpoints
+	errNoEndpointsDiscovered = errors.New("no etcd endpoints discovered")
+
+	// lookupSRV is the SRV resolver, it can be overwritten by tests
+	lookupSRV = net.LookupSRV
+)
+
+// etcdSRVServices are the SRV services of etcd client endpoints in order of
+// preference along with the scheme of the endpoints
+var etcdSRVServices = []struct {
+	service string
+	scheme  string
+}{
+	{service: "etcd-client-ssl", scheme: "https"},
+	{service: "etcd-client", scheme: "http"},
+}
+
+// validateDiscoveryService validates the value of EtcdDiscoveryServiceOption
+func validateDiscoveryService(v string) error {
+	if _, _, err := parseDiscoveryService(v); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateDiscoveryInterval validates the value of
+// EtcdDiscoveryIntervalOption
+func validateDiscoveryInterval(v string) error {
+	interval, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return fmt.Errorf("discovery interval must be positive")
+	}
+	return nil
+}
+
+// parseDiscoveryService parses a Kubernetes service in the form
+// "<namespace>/<name>"
+func parseDiscoveryService(v string) (namespace, name string, err error) {
+	parts := strings.Split(v, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid service %q, expecting \"<namespace>/<name>\"", v)
+	}
+	return parts[0], parts[1], nil
+}
+
+// endpointDiscovery discovers the etcd endpoints. The endpoints are resolved
+// when the client connects and are then refreshed periodically, so that etcd
+// members can be added or replaced without reconfiguring or restarting the
+// client.
+type endpointDiscovery struct {
+	// source describes where the endpoints are discovered
+	source string
+
+	// interval is the interval in which the endpoints are refreshed
+	interval time.Duration
+
+	// resolve returns the current endpoints. secure is true if the
+	// client is configured for TLS.
+	resolve func(secure bool) ([]string, error)
+}
+
+// newEndpointDiscovery returns the endpoint discovery configured in opts or
+// nil if the endpoints are not discovered
+func newEndpointDiscovery(opts backendOptions, extraOpts *ExtraOptions) (*endpointDiscovery, error) {
+	var domain, svc string
+	if o, ok := opts[EtcdDiscoverySRVOption]; ok {
+		domain = o.value
+	}
+	if o, ok := opts[EtcdDiscoveryServiceOption]; ok {
+		svc = o.value
+	}
+
+	if domain == "" && svc == "" {
+		return nil, nil
+	}
+	if domain != "" && svc != "" {
+		return nil, fmt.Errorf("invalid etcd configuration, %s and %s are mutually exclusive",
+			EtcdDiscoverySRVOption, EtcdDiscoveryServiceOption)
+	}
+	if o, ok := opts[addrOption]; ok && o.value != "" {
+		return nil, fmt.Errorf("invalid etcd configuration, %s cannot be combined with endpoint discovery", addrOption)
+	}
+
+	d := &endpointDiscovery{interval: defaultDiscoveryInterval}
+	if o, ok := opts[EtcdDiscoveryIntervalOption]; ok && o.value != "" {
+		// error is discarded here because this option has validation
+		d.interval, _ = time.ParseDuration(o.value)
+	}
+
+	if domain != "" {
+		d.source = "DNS SRV records of " + domain
+		d.resolve = func(bool) ([]string, error) {
+			return resolveSRVEndpoints(domain)
+		}
+		return d, nil
+	}
+
+	namespace, name, err := parseDiscoveryService(svc)
+	if err != nil {
+		return nil, err
+	}
+	if extraOpts == nil || extraOpts.ServiceEndpoints == nil {
+		return nil, fmt.Errorf("invalid etcd configuration, %s requires Kubernetes", EtcdDiscoveryServiceOption)
+	}
+	d.source = "Kubernetes service " + svc
+	d.resolve = func(secure bool) ([]string, error) {
+		addrs, err := extraOpts.ServiceEndpoints(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		endpoints := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			endpoints = append(endpoints, scheme+"://"+addr)
+		}
+		sort.Strings(endpoints)
+		return endpoints, nil
+	}
+	return d, nil
+}
+
+// endpoints returns the current endpoints, or errNoEndpointsDiscovered if
+// none were found
+func (d *endpointDiscovery) endpoints(secure bool) ([]string, error) {
+	endpoints, err := d.resolve(secure)
+	if err != nil {
+		return nil, fmt.Errorf("unable to discover etcd endpoints via %s: %s", d.source, err)
+	}
+	if len(endpoints) == 0 {
+		return nil, errNoEndpointsDiscovered
+	}
+	return endpoints, nil
+}
+
+// resolveSRVEndpoints returns the etcd endpoints announced via SRV records
+// in domain. The "_etcd-client-ssl._tcp" records are preferred over the
+// "_etcd-client._tcp" records.
+func resolveSRVEndpoints(domain string) ([]string, error) {
+	var lastErr error
+	for _, s := range etcdSRVServices {
+		_, addrs, err := lookupSRV(s.service, "tcp", domain)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(addrs) == 0 {
+			continue
+		}
+
+		endpoints := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			endpoints = append(endpoints, s.scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+		}
+		sort.Strings(endpoints)
+		return endpoints, nil
+	}
+
+	// A missing record is not an error, the etcd cluster may not have
+	// been announced yet
+	if dnsErr, ok := lastErr.(*net.DNSError); ok && !dnsErr.Temporary() {
+		return nil, nil
+	}
+	return nil, lastErr
+}
+
+// sameEndpoints returns true if a and b contain the same endpoints
+func sameEndpoints(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshEndpoints updates the endpoints of the client to the currently
+// discovered endpoints. The endpoints are left unchanged if none are
+// discovered, e.g. while the etcd cluster is being replaced.
+func (e *etcdClient) refreshEndpoints() error {
+	endpoints, err := e.discovery.endpoints(e.config.TLS != nil)
+	if err != nil {
+		return err
+	}
+
+	current := e.client.Endpoints()
+	if sameEndpoints(current, endpoints) {
+		return nil
+	}
+
+	e.getLogger().WithFields(logrus.Fields{
+		"old": current,
+		"new": endpoints,
+	}).Info("Discovered new set of etcd endpoints")
+
+	e.client.SetEndpoints(endpoints...)
+	return nil
+}
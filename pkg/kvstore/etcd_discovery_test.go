@@ -0,0 +1,130 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"errors"
+	"net"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestDiscoveryOptions(c *C) {
+	c.Assert(validateDiscoveryService("kube-system/etcd"), IsNil)
+	c.Assert(validateDiscoveryService("etcd"), Not(IsNil))
+	c.Assert(validateDiscoveryService("/etcd"), Not(IsNil))
+	c.Assert(validateDiscoveryInterval("10s"), IsNil)
+	c.Assert(validateDiscoveryInterval("0s"), Not(IsNil))
+
+	module := newEtcdModule()
+	c.Assert(module.setConfig(map[string]string{EtcdDiscoveryIntervalOption: "foo"}), Not(IsNil))
+
+	// Discovery is mutually exclusive with static endpoints
+	module = newEtcdModule()
+	c.Assert(module.setConfig(map[string]string{
+		addrOption:             "https://etcd.local:2379",
+		EtcdDiscoverySRVOption: "cluster.local",
+	}), IsNil)
+	backend, errChan := module.newClient(nil)
+	c.Assert(backend, IsNil)
+	c.Assert(<-errChan, ErrorMatches, ".*cannot be combined with endpoint discovery.*")
+
+	// Discovery via a Kubernetes service requires a service resolver
+	module = newEtcdModule()
+	c.Assert(module.setConfig(map[string]string{EtcdDiscoveryServiceOption: "kube-system/etcd"}), IsNil)
+	backend, errChan = module.newClient(nil)
+	c.Assert(backend, IsNil)
+	c.Assert(<-errChan, ErrorMatches, ".*requires Kubernetes.*")
+}
+
+func (s *independentSuite) TestDiscoverySRV(c *C) {
+	oldLookupSRV := lookupSRV
+	defer func() { lookupSRV = oldLookupSRV }()
+
+	records := map[string][]*net.SRV{}
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if addrs, ok := records[service]; ok {
+			return "", addrs, nil
+		}
+		return "", nil, &net.DNSError{Err: "no such host", Name: name}
+	}
+
+	d, err := newEndpointDiscovery(backendOptions{
+		EtcdDiscoverySRVOption: &backendOption{value: "cluster.local"},
+	}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(d.interval, Equals, defaultDiscoveryInterval)
+
+	// No records yet
+	_, err = d.endpoints(false)
+	c.Assert(err, Equals, errNoEndpointsDiscovered)
+
+	records["etcd-client"] = []*net.SRV{
+		{Target: "etcd-1.cluster.local.", Port: 2379},
+		{Target: "etcd-0.cluster.local.", Port: 2379},
+	}
+	endpoints, err := d.endpoints(false)
+	c.Assert(err, IsNil)
+	c.Assert(endpoints, DeepEquals, []string{"http://etcd-0.cluster.local:2379", "http://etcd-1.cluster.local:2379"})
+
+	// TLS endpoints are preferred
+	records["etcd-client-ssl"] = []*net.SRV{{Target: "etcd-0.cluster.local.", Port: 2379}}
+	endpoints, err = d.endpoints(false)
+	c.Assert(err, IsNil)
+	c.Assert(endpoints, DeepEquals, []string{"https://etcd-0.cluster.local:2379"})
+}
+
+func (s *independentSuite) TestDiscoveryService(c *C) {
+	var addrs []string
+	var resolveErr error
+	extraOpts := &ExtraOptions{
+		ServiceEndpoints: func(namespace, name string) ([]string, error) {
+			c.Assert(namespace, Equals, "kube-system")
+			c.Assert(name, Equals, "etcd")
+			return addrs, resolveErr
+		},
+	}
+
+	d, err := newEndpointDiscovery(backendOptions{
+		EtcdDiscoveryServiceOption:  &backendOption{value: "kube-system/etcd"},
+		EtcdDiscoveryIntervalOption: &backendOption{value: "5s"},
+	}, extraOpts)
+	c.Assert(err, IsNil)
+	c.Assert(d.interval.Seconds(), Equals, float64(5))
+
+	_, err = d.endpoints(true)
+	c.Assert(err, Equals, errNoEndpointsDiscovered)
+
+	addrs = []string{"10.0.0.2:2379", "10.0.0.1:2379"}
+	endpoints, err := d.endpoints(true)
+	c.Assert(err, IsNil)
+	c.Assert(endpoints, DeepEquals, []string{"https://10.0.0.1:2379", "https://10.0.0.2:2379"})
+
+	endpoints, err = d.endpoints(false)
+	c.Assert(err, IsNil)
+	c.Assert(endpoints, DeepEquals, []string{"http://10.0.0.1:2379", "http://10.0.0.2:2379"})
+
+	resolveErr = errors.New("cache not synced")
+	_, err = d.endpoints(true)
+	c.Assert(err, ErrorMatches, ".*cache not synced.*")
+}
+
+func (s *independentSuite) TestSameEndpoints(c *C) {
+	c.Assert(sameEndpoints([]string{"a", "b"}, []string{"b", "a"}), Equals, true)
+	c.Assert(sameEndpoints([]string{"a", "b"}, []string{"a"}), Equals, false)
+	c.Assert(sameEndpoints([]string{"a", "b"}, []string{"a", "c"}), Equals, false)
+}
@@ -0,0 +1,141 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+
+	client "github.com/coreos/etcd/clientv3"
+)
+
+const (
+	// EtcdReadPreferenceOption selects the etcd endpoint serving reads
+	EtcdReadPreferenceOption = "etcd.readPreference"
+
+	// EtcdLocalEndpointOption is the etcd endpoint preferred for reads if
+	// the read preference is ReadPreferenceLocal
+	EtcdLocalEndpointOption = "etcd.localEndpoint"
+
+	// ReadPreferenceLeader performs linearizable reads which are served
+	// by the etcd leader. This is the default.
+	ReadPreferenceLeader = "leader"
+
+	// ReadPreferenceLocal performs serializable reads against the local
+	// etcd endpoint, falling back to linearizable reads if the local
+	// endpoint is unavailable
+	ReadPreferenceLocal = "local"
+
+	// localReadsFailoverPeriod is the time local reads are suspended
+	// after the local endpoint failed
+	localReadsFailoverPeriod = 30 * time.Second
+)
+
+// validateReadPreference validates the value of EtcdReadPreferenceOption
+func validateReadPreference(v string) error {
+	switch v {
+	case ReadPreferenceLeader, ReadPreferenceLocal:
+		return nil
+	}
+	return fmt.Errorf("invalid read preference %q, must be %q or %q", v, ReadPreferenceLeader, ReadPreferenceLocal)
+}
+
+// localReads performs serializable reads against the local etcd endpoint.
+// Serializable reads are served by the member without consensus with the
+// leader, which avoids cross-zone round trips at the cost of possibly
+// reading slightly outdated state. Writes always go through the main
+// client and are forwarded to the leader by etcd.
+type localReads struct {
+	endpoint string
+	client   *client.Client
+
+	// mutex protects failedUntil
+	mutex lock.Mutex
+
+	// failedUntil is the time until which local reads are suspended
+	// after a failure of the local endpoint
+	failedUntil time.Time
+}
+
+// newLocalReads returns a client for serializable reads against endpoint
+// based on config
+func newLocalReads(config client.Config, endpoint string) (*localReads, error) {
+	config.Endpoints = []string{endpoint}
+	c, err := client.New(config)
+	if err != nil {
+		return nil, err
+	}
+	return &localReads{endpoint: endpoint, client: c}, nil
+}
+
+// available returns true if local reads are not suspended
+func (l *localReads) available(now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return !now.Before(l.failedUntil)
+}
+
+// fail suspends local reads for localReadsFailoverPeriod
+func (l *localReads) fail(now time.Time, err error) {
+	l.mutex.Lock()
+	suspended := !now.Before(l.failedUntil)
+	l.failedUntil = now.Add(localReadsFailoverPeriod)
+	l.mutex.Unlock()
+
+	if suspended {
+		log.WithError(err).WithField("endpoint", l.endpoint).
+			Warning("Local etcd endpoint failed, reading from leader")
+	}
+}
+
+// checkHealth suspends local reads if the local endpoint is unreachable or
+// has lost its leader, in which case its state may be outdated
+func (l *localReads) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), statusCheckTimeout)
+	defer cancel()
+
+	status, err := l.client.Status(ctx, l.endpoint)
+	switch {
+	case err != nil:
+		l.fail(time.Now(), err)
+	case status.Leader == 0:
+		l.fail(time.Now(), fmt.Errorf("endpoint has no leader"))
+	}
+}
+
+// close closes the client of the local endpoint
+func (l *localReads) close() {
+	l.client.Close()
+}
+
+// get reads key with opts from the local endpoint if local reads are
+// configured and available, and from the leader otherwise
+func (e *etcdClient) get(ctx context.Context, key string, opts ...client.OpOption) (*client.GetResponse, error) {
+	if l := e.localReads; l != nil && l.available(time.Now()) {
+		getR, err := l.client.Get(ctx, key, append(opts, client.WithSerializable())...)
+		if err == nil {
+			return getR, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		l.fail(time.Now(), err)
+	}
+
+	return e.client.Get(ctx, key, opts...)
+}
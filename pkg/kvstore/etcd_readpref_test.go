@@ -0,0 +1,55 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestReadPreferenceOptions(c *C) {
+	c.Assert(validateReadPreference(ReadPreferenceLeader), IsNil)
+	c.Assert(validateReadPreference(ReadPreferenceLocal), IsNil)
+	c.Assert(validateReadPreference("nearest"), Not(IsNil))
+
+	module := newEtcdModule()
+	c.Assert(module.setConfig(map[string]string{EtcdReadPreferenceOption: "nearest"}), Not(IsNil))
+
+	// The local read preference requires a local endpoint
+	module = newEtcdModule()
+	c.Assert(module.setConfig(map[string]string{
+		addrOption:               "https://etcd.local:2379",
+		EtcdReadPreferenceOption: ReadPreferenceLocal,
+	}), IsNil)
+	backend, errChan := module.newClient(nil)
+	c.Assert(backend, IsNil)
+	c.Assert(<-errChan, ErrorMatches, ".*"+EtcdLocalEndpointOption+" must be specified.*")
+}
+
+func (s *independentSuite) TestLocalReadsFailover(c *C) {
+	l := &localReads{endpoint: "https://127.0.0.1:2379"}
+	now := time.Now()
+	c.Assert(l.available(now), Equals, true)
+
+	// Reads fail over to the leader for localReadsFailoverPeriod
+	l.fail(now, errors.New("connection refused"))
+	c.Assert(l.available(now), Equals, false)
+	c.Assert(l.available(now.Add(localReadsFailoverPeriod-time.Second)), Equals, false)
+	c.Assert(l.available(now.Add(localReadsFailoverPeriod)), Equals, true)
+}
@@ -26,8 +26,12 @@ import (
 	"time"
 
 	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/option"
 
 	etcdAPI "github.com/coreos/etcd/clientv3"
+	v3rpcErrors "github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	. "gopkg.in/check.v1"
 )
 
@@ -185,6 +189,42 @@ type EtcdHelpersSuite struct{}
 
 var _ = Suite(&EtcdHelpersSuite{})
 
+func (s *EtcdHelpersSuite) TestFirstValidKey(c *C) {
+	oldMaxValueSize := option.Config.KVstoreMaxValueSize
+	defer func() { option.Config.KVstoreMaxValueSize = oldMaxValueSize }()
+	option.Config.KVstoreMaxValueSize = 4
+
+	getR := &etcdAPI.GetResponse{
+		Header: &etcdserverpb.ResponseHeader{Revision: 10},
+		Kvs: []*mvccpb.KeyValue{
+			{Key: []byte("foo/a"), Value: []byte("12345")},
+			{Key: []byte("foo/b"), Value: []byte("1234")},
+		},
+	}
+	key, value, next := firstValidKey("foo", getR)
+	c.Assert(key, Equals, "foo/b")
+	c.Assert(value, checker.DeepEquals, []byte("1234"))
+	c.Assert(next, IsNil)
+
+	// All values of the page are oversized, the next page is requested
+	getR.Kvs = getR.Kvs[:1]
+	getR.More = true
+	key, value, next = firstValidKey("foo", getR)
+	c.Assert(key, Equals, "foo/a\x00")
+	c.Assert(value, IsNil)
+	c.Assert(len(next), Equals, 3)
+	op := etcdAPI.OpGet(key, next...)
+	c.Assert(string(op.RangeBytes()), Equals, "fop")
+	c.Assert(op.Rev(), Equals, int64(10))
+
+	// No further keys
+	getR.More = false
+	key, value, next = firstValidKey("foo", getR)
+	c.Assert(key, Equals, "")
+	c.Assert(value, IsNil)
+	c.Assert(next, IsNil)
+}
+
 func (s *EtcdHelpersSuite) TestIsEtcdOperator(c *C) {
 	temp := c.MkDir()
 	etcdConfigByte := []byte(`---
@@ -400,6 +440,50 @@ func (e *EtcdLockedSuite) TestGetIfLocked(c *C) {
 	}
 }
 
+func (e *EtcdLockedSuite) TestGetOversized(c *C) {
+	oldMaxValueSize := option.Config.KVstoreMaxValueSize
+	defer func() { option.Config.KVstoreMaxValueSize = oldMaxValueSize }()
+	option.Config.KVstoreMaxValueSize = 4
+
+	key := c.MkDir() + "foo"
+	_, err := e.etcdClient.Put(context.Background(), key, "12345")
+	c.Assert(err, IsNil)
+	defer e.etcdClient.Delete(context.Background(), key)
+
+	// Oversized values written by other clients are not handed out
+	value, err := Client().Get(key)
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	k, value, err := Client().GetPrefix(context.Background(), key)
+	c.Assert(err, IsNil)
+	c.Assert(k, Equals, "")
+	c.Assert(value, IsNil)
+
+	kvlocker, err := Client().LockPath(context.Background(), "locks/"+key+"/.lock")
+	c.Assert(err, IsNil)
+	defer kvlocker.Unlock()
+
+	value, err = Client().GetIfLocked(key, kvlocker)
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	// Keys following an oversized value are still found
+	_, err = e.etcdClient.Put(context.Background(), key+"/valid", "1234")
+	c.Assert(err, IsNil)
+	defer e.etcdClient.Delete(context.Background(), key+"/valid")
+
+	k, value, err = Client().GetPrefix(context.Background(), key)
+	c.Assert(err, IsNil)
+	c.Assert(k, Equals, key+"/valid")
+	c.Assert(value, checker.DeepEquals, []byte("1234"))
+
+	k, value, err = Client().GetPrefixIfLocked(context.Background(), key, kvlocker)
+	c.Assert(err, IsNil)
+	c.Assert(k, Equals, key+"/valid")
+	c.Assert(value, checker.DeepEquals, []byte("1234"))
+}
+
 func (e *EtcdLockedSuite) TestGetPrefixIfLocked(c *C) {
 	randomPath := c.MkDir()
 	type args struct {
@@ -1498,3 +1582,10 @@ func (e *EtcdLockedSuite) TestListPrefixIfLocked(c *C) {
 		c.Assert(err, IsNil)
 	}
 }
+
+func (s *independentSuite) TestWatchRequiresReList(c *C) {
+	c.Assert(watchRequiresReList(v3rpcErrors.ErrCompacted), Equals, true)
+	c.Assert(watchRequiresReList(v3rpcErrors.ErrFutureRev), Equals, true)
+	c.Assert(watchRequiresReList(v3rpcErrors.ErrNoLeader), Equals, false)
+	c.Assert(watchRequiresReList(errors.New("connection lost")), Equals, false)
+}
@@ -0,0 +1,313 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	fsnotify "gopkg.in/fsnotify.v1"
+)
+
+// etcdTLSFiles are the TLS related fields of the etcd configuration file,
+// see github.com/coreos/etcd/clientv3/yaml
+type etcdTLSFiles struct {
+	InsecureTransport     bool   `json:"insecure-transport"`
+	InsecureSkipTLSVerify bool   `json:"insecure-skip-tls-verify"`
+	Certfile              string `json:"cert-file"`
+	Keyfile               string `json:"key-file"`
+	TrustedCAfile         string `json:"trusted-ca-file"`
+	CAfile                string `json:"ca-file"`
+}
+
+// tlsReloader reloads the client certificate and the trusted CAs of the etcd
+// client from disk whenever the files change. New connections, including
+// reconnects of the established gRPC connection, use the reloaded files
+// without having to recreate the client and thus all watches.
+type tlsReloader struct {
+	files etcdTLSFiles
+
+	// config is the TLS configuration of the client which all
+	// connections are derived from
+	config *tls.Config
+
+	// verify is true if the server certificate is verified against the
+	// reloaded trusted CAs
+	verify bool
+
+	watcher *fsnotify.Watcher
+	stop    chan struct{}
+
+	// mutex protects cert and roots
+	mutex lock.RWMutex
+	cert  *tls.Certificate
+	roots *x509.CertPool
+}
+
+// newTLSReloader reads the etcd configuration file at cfgPath and, if client
+// certificates or trusted CAs are configured, returns a tlsReloader whose
+// dialOptions() make all connections use the latest version of the files.
+// Returns nil if TLS is not configured.
+func newTLSReloader(cfgPath string, config *tls.Config) (*tlsReloader, error) {
+	b, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := etcdTLSFiles{}
+	if err := yaml.Unmarshal(b, &files); err != nil {
+		return nil, err
+	}
+
+	if files.CAfile != "" && files.TrustedCAfile == "" {
+		files.TrustedCAfile = files.CAfile
+	}
+	if !files.InsecureSkipTLSVerify {
+		files.InsecureSkipTLSVerify = config != nil && config.InsecureSkipVerify
+	}
+
+	hasCert := files.Certfile != "" && files.Keyfile != ""
+	hasCA := files.TrustedCAfile != "" && !files.InsecureSkipTLSVerify
+	if files.InsecureTransport || config == nil || (!hasCert && !hasCA) {
+		return nil, nil
+	}
+
+	r := &tlsReloader{
+		files:  files,
+		verify: hasCA,
+		stop:   make(chan struct{}),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	if hasCert {
+		config.Certificates = nil
+		config.GetClientCertificate = r.getClientCertificate
+	}
+	r.config = config.Clone()
+
+	if err := r.watch(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reload reads the client certificate and trusted CAs from disk. On error,
+// the previously loaded files remain in use.
+func (r *tlsReloader) reload() error {
+	var (
+		cert  *tls.Certificate
+		roots *x509.CertPool
+	)
+
+	if r.files.Certfile != "" && r.files.Keyfile != "" {
+		c, err := tls.LoadX509KeyPair(r.files.Certfile, r.files.Keyfile)
+		if err != nil {
+			return fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		cert = &c
+	}
+
+	if r.files.TrustedCAfile != "" && !r.files.InsecureSkipTLSVerify {
+		pem, err := ioutil.ReadFile(r.files.TrustedCAfile)
+		if err != nil {
+			return fmt.Errorf("unable to read trusted CA file: %s", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificates found in trusted CA file %s", r.files.TrustedCAfile)
+		}
+	}
+
+	r.mutex.Lock()
+	r.cert, r.roots = cert, roots
+	r.mutex.Unlock()
+
+	return nil
+}
+
+// getClientCertificate implements tls.Config.GetClientCertificate
+func (r *tlsReloader) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.cert, nil
+}
+
+// clientConfig returns the TLS configuration of a connection to the etcd
+// endpoint host. The certificate of the server is verified against the
+// latest trusted CAs and must be valid for host.
+func (r *tlsReloader) clientConfig(host string) *tls.Config {
+	cfg := r.config.Clone()
+	cfg.ServerName = host
+	if r.verify {
+		r.mutex.RLock()
+		cfg.RootCAs = r.roots
+		r.mutex.RUnlock()
+	}
+	return cfg
+}
+
+// endpointConn is a connection to an etcd endpoint which remembers the host
+// name of the endpoint it was dialed for
+type endpointConn struct {
+	net.Conn
+	host string
+}
+
+// dial implements the dialer of the gRPC connection. The etcd balancer
+// shares a single gRPC connection between all endpoints and thus passes the
+// same authority to the handshake of all connections, the host name of the
+// endpoint actually dialed is therefore attached to the connection.
+func (r *tlsReloader) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Not a host:port pair, dial a unix socket
+		return net.DialTimeout("unix", addr, timeout)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &endpointConn{Conn: conn, host: host}, nil
+}
+
+// dialOptions returns the gRPC dial options to make all connections of the
+// etcd client use the latest TLS files and verify the certificate of the
+// server against the endpoint dialed
+func (r *tlsReloader) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithDialer(r.dial),
+		grpc.WithTransportCredentials(&reloadingCredentials{reloader: r}),
+	}
+}
+
+// reloadingCredentials are the gRPC transport credentials of connections
+// using the TLS files of a tlsReloader
+type reloadingCredentials struct {
+	reloader *tlsReloader
+}
+
+// ClientHandshake implements credentials.TransportCredentials
+func (c *reloadingCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	host := authority
+	if h, _, err := net.SplitHostPort(authority); err == nil {
+		host = h
+	}
+	if conn, ok := rawConn.(*endpointConn); ok {
+		host, rawConn = conn.host, conn.Conn
+	}
+
+	return credentials.NewTLS(c.reloader.clientConfig(host)).ClientHandshake(ctx, host, rawConn)
+}
+
+// ServerHandshake implements credentials.TransportCredentials
+func (c *reloadingCredentials) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("etcd client credentials cannot be used by servers")
+}
+
+// Info implements credentials.TransportCredentials. The server name is left
+// empty so that gRPC uses the endpoint as authority.
+func (c *reloadingCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+	}
+}
+
+// Clone implements credentials.TransportCredentials
+func (c *reloadingCredentials) Clone() credentials.TransportCredentials {
+	return &reloadingCredentials{reloader: c.reloader}
+}
+
+// OverrideServerName implements credentials.TransportCredentials. The
+// certificate of the server is always verified against the endpoint dialed.
+func (c *reloadingCredentials) OverrideServerName(string) error {
+	return fmt.Errorf("overriding the server name of etcd connections is not supported")
+}
+
+// watch starts watching the directories of all TLS files and reloads the
+// files on change. The directories are watched rather than the files as
+// Kubernetes secrets are updated by replacing a symlink.
+func (r *tlsReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := map[string]struct{}{}
+	for _, f := range []string{r.files.Certfile, r.files.Keyfile, r.files.TrustedCAfile} {
+		if f != "" {
+			dirs[filepath.Dir(f)] = struct{}{}
+		}
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event := <-watcher.Events:
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				scopedLog := log.WithFields(logrus.Fields{
+					"file":      event.Name,
+					"operation": event.Op,
+				})
+				if err := r.reload(); err != nil {
+					scopedLog.WithError(err).Warning("Unable to reload etcd TLS files, continuing with previous files")
+				} else {
+					scopedLog.Info("Reloaded etcd TLS files")
+				}
+
+			case err := <-watcher.Errors:
+				log.WithError(err).Warning("Error encountered while watching etcd TLS files")
+
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// close stops watching the TLS files
+func (r *tlsReloader) close() {
+	close(r.stop)
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
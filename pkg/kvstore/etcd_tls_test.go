@@ -0,0 +1,180 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/cilium/pkg/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+// testCert is a certificate and its private key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert returns a new certificate signed by parent, or a self-signed
+// CA certificate if parent is nil
+func newTestCert(c *C, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return &testCert{cert: cert, key: key}
+}
+
+// write writes the certificate and key in PEM format to the given files
+func (t *testCert) write(c *C, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: t.cert.Raw})
+	c.Assert(ioutil.WriteFile(certFile, certPEM, 0600), IsNil)
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(t.key)
+		c.Assert(err, IsNil)
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		c.Assert(ioutil.WriteFile(keyFile, keyPEM, 0600), IsNil)
+	}
+}
+
+// handshake performs the TLS handshake of a connection to the etcd endpoint
+// host with the credentials of r against a server presenting server. The
+// authority is the one of the first endpoint, as passed by the etcd balancer.
+func handshake(r *tlsReloader, host string, server *testCert) error {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	go func() {
+		defer serverConn.Close()
+		cert := tls.Certificate{Certificate: [][]byte{server.cert.Raw}, PrivateKey: server.key}
+		tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	creds := &reloadingCredentials{reloader: r}
+	conn, _, err := creds.ClientHandshake(ctx, "etcd.local:2379", &endpointConn{Conn: clientConn, host: host})
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func (s *independentSuite) TestTLSReloader(c *C) {
+	dir, err := ioutil.TempDir("", "etcd-tls")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	var (
+		caFile   = filepath.Join(dir, "ca.crt")
+		certFile = filepath.Join(dir, "client.crt")
+		keyFile  = filepath.Join(dir, "client.key")
+		cfgFile  = filepath.Join(dir, "etcd.config")
+	)
+
+	ca := newTestCert(c, "ca", nil)
+	ca.write(c, caFile, "")
+	client := newTestCert(c, "client", ca)
+	client.write(c, certFile, keyFile)
+
+	cfg := fmt.Sprintf("endpoints:\n- https://etcd.local:2379\n- https://other.local:2379\ntrusted-ca-file: %s\ncert-file: %s\nkey-file: %s\n", caFile, certFile, keyFile)
+	c.Assert(ioutil.WriteFile(cfgFile, []byte(cfg), 0600), IsNil)
+
+	tlsConfig := &tls.Config{}
+	r, err := newTLSReloader(cfgFile, tlsConfig)
+	c.Assert(err, IsNil)
+	c.Assert(r, Not(IsNil))
+	defer r.close()
+
+	cert, err := tlsConfig.GetClientCertificate(nil)
+	c.Assert(err, IsNil)
+	c.Assert(cert.Certificate[0], DeepEquals, client.cert.Raw)
+
+	// The server certificate must be signed by the trusted CA and be
+	// valid for the endpoint dialed, not just for any of the endpoints
+	server := newTestCert(c, "etcd.local", ca)
+	c.Assert(handshake(r, "etcd.local", server), IsNil)
+	c.Assert(handshake(r, "other.local", server), Not(IsNil))
+	other := newTestCert(c, "other.local", ca)
+	c.Assert(handshake(r, "other.local", other), IsNil)
+	c.Assert(handshake(r, "etcd.local", newTestCert(c, "etcd.local", newTestCert(c, "ca", nil))), Not(IsNil))
+
+	// Rotate the CA and the client certificate
+	newCA := newTestCert(c, "ca", nil)
+	newCA.write(c, caFile, "")
+	newClient := newTestCert(c, "client", newCA)
+	newClient.write(c, certFile, keyFile)
+
+	newServer := newTestCert(c, "etcd.local", newCA)
+	c.Assert(testutils.WaitUntil(func() bool {
+		cert, err := tlsConfig.GetClientCertificate(nil)
+		return err == nil && bytes.Equal(cert.Certificate[0], newClient.cert.Raw) &&
+			handshake(r, "etcd.local", newServer) == nil
+	}, 10*time.Second), IsNil)
+	c.Assert(handshake(r, "etcd.local", server), Not(IsNil))
+}
+
+func (s *independentSuite) TestTLSReloaderInsecure(c *C) {
+	dir, err := ioutil.TempDir("", "etcd-tls")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	cfgFile := filepath.Join(dir, "etcd.config")
+	c.Assert(ioutil.WriteFile(cfgFile, []byte("endpoints:\n- http://127.0.0.1:2379\n"), 0600), IsNil)
+
+	r, err := newTLSReloader(cfgFile, &tls.Config{})
+	c.Assert(err, IsNil)
+	c.Assert(r, IsNil)
+}
@@ -58,6 +58,11 @@ type KeyValueEvent struct {
 
 	// Value is the kvstore value associated with the key
 	Value []byte
+
+	// ModRevision is the kvstore revision at which the key was last
+	// modified. For delete events, it is the revision at which the
+	// deletion was observed.
+	ModRevision uint64
 }
 
 // EventChan is a channel to receive events on
@@ -95,6 +100,30 @@ func newWatcher(name, prefix string, chanSize int) *Watcher {
 	return w
 }
 
+// NewWatcher returns a new watcher of prefix for use by backends registered
+// with RegisterBackend(). The backend must send events to Events until
+// StopChan() is closed and then call Stopped().
+func NewWatcher(name, prefix string, chanSize int) *Watcher {
+	return newWatcher(name, prefix, chanSize)
+}
+
+// Prefix returns the prefix watched by the watcher
+func (w *Watcher) Prefix() string {
+	return w.prefix
+}
+
+// StopChan returns a channel which is closed when the watcher is stopped
+func (w *Watcher) StopChan() <-chan struct{} {
+	return w.stopWatch
+}
+
+// Stopped must be called by the backend once it has stopped sending events to
+// a watcher created with NewWatcher(). It closes Events and unblocks Stop().
+func (w *Watcher) Stopped() {
+	close(w.Events)
+	w.stopWait.Done()
+}
+
 // String returns the name of the wather
 func (w *Watcher) String() string {
 	return w.name
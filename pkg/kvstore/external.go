@@ -0,0 +1,89 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+// BackendFactory is implemented by kvstore backends maintained outside of
+// this package, e.g. shims to other key-value stores or test doubles. A
+// registered backend is selected by name via option.Config.KVStore like the
+// built-in backends and can be used by all users of the kvstore, including
+// the allocator and the shared store.
+type BackendFactory interface {
+	// ValidateConfig is called with the options passed via --kvstore-opt
+	// before any client is created. Returning an error aborts the setup
+	// of the kvstore.
+	ValidateConfig(opts map[string]string) error
+
+	// NewClient creates a new client for the backend configured with
+	// opts. The returned channel must be closed once the client has
+	// connected or receive an error if the connection could not be
+	// established. If no client can be created at all, nil must be
+	// returned along with an error sent on the channel.
+	NewClient(opts map[string]string, extraOpts *ExtraOptions) (BackendOperations, chan error)
+}
+
+// RegisterBackend makes the backend created by factory available under the
+// given name. Returns an error if a backend with the same name is already
+// registered. Backends are typically registered in an init() function so
+// that they are available before the kvstore is set up.
+func RegisterBackend(name string, factory BackendFactory) error {
+	return addBackend(name, &externalModule{name: name, factory: factory})
+}
+
+// externalModule adapts a BackendFactory to the backendModule interface
+type externalModule struct {
+	name    string
+	factory BackendFactory
+	opts    map[string]string
+}
+
+func (e *externalModule) createInstance() backendModule {
+	return &externalModule{
+		name:    e.name,
+		factory: e.factory,
+		opts:    map[string]string{},
+	}
+}
+
+func (e *externalModule) getName() string {
+	return e.name
+}
+
+func (e *externalModule) setConfigDummy() {
+	e.opts = map[string]string{}
+}
+
+func (e *externalModule) setConfig(opts map[string]string) error {
+	if err := e.factory.ValidateConfig(opts); err != nil {
+		return err
+	}
+
+	e.opts = make(map[string]string, len(opts))
+	for key, val := range opts {
+		e.opts[key] = val
+	}
+	return nil
+}
+
+func (e *externalModule) setExtraConfig(opts *ExtraOptions) error {
+	return nil
+}
+
+func (e *externalModule) getConfig() map[string]string {
+	return e.opts
+}
+
+func (e *externalModule) newClient(opts *ExtraOptions) (BackendOperations, chan error) {
+	return e.factory.NewClient(e.opts, opts)
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+// testFactory creates recordingBackend clients and records the options
+// they were created with
+type testFactory struct {
+	backend *recordingBackend
+	opts    map[string]string
+}
+
+func (f *testFactory) ValidateConfig(opts map[string]string) error {
+	if _, ok := opts["invalid"]; ok {
+		return errors.New("invalid option")
+	}
+	return nil
+}
+
+func (f *testFactory) NewClient(opts map[string]string, extraOpts *ExtraOptions) (BackendOperations, chan error) {
+	f.opts = opts
+	errChan := make(chan error)
+	close(errChan)
+	return f.backend, errChan
+}
+
+func (s *independentSuite) TestRegisterBackend(c *C) {
+	factory := &testFactory{backend: &recordingBackend{}}
+	c.Assert(RegisterBackend("test-external", factory), IsNil)
+	c.Assert(RegisterBackend("test-external", factory), Not(IsNil))
+	c.Assert(RegisterBackend(EtcdBackendName, factory), Not(IsNil))
+
+	client, errChan := NewClient("test-external", map[string]string{"address": "foo"}, nil)
+	c.Assert(client, Not(IsNil))
+	_, isErr := <-errChan
+	c.Assert(isErr, Equals, false)
+	c.Assert(factory.opts, DeepEquals, map[string]string{"address": "foo"})
+
+	// Writes are passed through the wrappers of the kvstore package
+	c.Assert(client.Set("foo", []byte("bar")), IsNil)
+	c.Assert(factory.backend.written, DeepEquals, []string{"foo"})
+
+	client, errChan = NewClient("test-external", map[string]string{"invalid": ""}, nil)
+	c.Assert(client, IsNil)
+	c.Assert(<-errChan, Not(IsNil))
+}
@@ -0,0 +1,252 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+
+	"github.com/cilium/cilium/pkg/metrics"
+	"github.com/cilium/cilium/pkg/option"
+)
+
+// Operations reported by the client-side kvstore metrics
+const (
+	operationGet        = "Get"
+	operationGetPrefix  = "GetPrefix"
+	operationListPrefix = "ListPrefix"
+	operationSet        = "Set"
+	operationUpdate     = "Update"
+	operationCreate     = "Create"
+	operationDelete     = "Delete"
+	operationLock       = "Lock"
+)
+
+// instrumentedBackend wraps a BackendOperations and reports the number of
+// operations in flight and the number of failed operations, labeled by
+// operation. This allows troubleshooting of kvstore users without access to
+// the metrics of the kvstore itself.
+type instrumentedBackend struct {
+	BackendOperations
+}
+
+// instrumentClient wraps the client with an instrumentedBackend if the
+// client-side operation metrics are enabled
+func instrumentClient(c BackendOperations) BackendOperations {
+	if c == nil || !option.Config.MetricsConfig.KVStoreOperationsInflightEnabled {
+		return c
+	}
+	return &instrumentedBackend{BackendOperations: c}
+}
+
+// begin marks the start of an operation on key. The returned function must
+// be called with the outcome of the operation once it has completed.
+func (i *instrumentedBackend) begin(operation, key string) func(error) {
+	metrics.KVStoreOperationsInflight.WithLabelValues(operation).Inc()
+	return func(err error) {
+		metrics.KVStoreOperationsInflight.WithLabelValues(operation).Dec()
+		if err != nil {
+			metrics.KVStoreOperationErrors.WithLabelValues(getScopeFromKey(key), operation).Inc()
+		}
+	}
+}
+
+// LockPath implements BackendOperations
+func (i *instrumentedBackend) LockPath(ctx context.Context, path string) (KVLocker, error) {
+	done := i.begin(operationLock, path)
+	l, err := i.BackendOperations.LockPath(ctx, path)
+	done(err)
+	return l, err
+}
+
+// Get implements BackendOperations
+func (i *instrumentedBackend) Get(key string) ([]byte, error) {
+	return i.GetContext(context.TODO(), key)
+}
+
+// GetContext implements BackendOperations
+func (i *instrumentedBackend) GetContext(ctx context.Context, key string) ([]byte, error) {
+	done := i.begin(operationGet, key)
+	v, err := i.BackendOperations.GetContext(ctx, key)
+	done(err)
+	return v, err
+}
+
+// GetIfLocked implements BackendOperations
+func (i *instrumentedBackend) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
+	return i.GetIfLockedContext(context.TODO(), key, lock)
+}
+
+// GetIfLockedContext implements BackendOperations
+func (i *instrumentedBackend) GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error) {
+	done := i.begin(operationGet, key)
+	v, err := i.BackendOperations.GetIfLockedContext(ctx, key, lock)
+	done(err)
+	return v, err
+}
+
+// GetPrefix implements BackendOperations
+func (i *instrumentedBackend) GetPrefix(ctx context.Context, prefix string) (string, []byte, error) {
+	done := i.begin(operationGetPrefix, prefix)
+	k, v, err := i.BackendOperations.GetPrefix(ctx, prefix)
+	done(err)
+	return k, v, err
+}
+
+// GetPrefixIfLocked implements BackendOperations
+func (i *instrumentedBackend) GetPrefixIfLocked(ctx context.Context, prefix string, lock KVLocker) (string, []byte, error) {
+	done := i.begin(operationGetPrefix, prefix)
+	k, v, err := i.BackendOperations.GetPrefixIfLocked(ctx, prefix, lock)
+	done(err)
+	return k, v, err
+}
+
+// Set implements BackendOperations
+func (i *instrumentedBackend) Set(key string, value []byte) error {
+	return i.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (i *instrumentedBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	done := i.begin(operationSet, key)
+	err := i.BackendOperations.SetContext(ctx, key, value)
+	done(err)
+	return err
+}
+
+// Delete implements BackendOperations
+func (i *instrumentedBackend) Delete(key string) error {
+	return i.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (i *instrumentedBackend) DeleteContext(ctx context.Context, key string) error {
+	done := i.begin(operationDelete, key)
+	err := i.BackendOperations.DeleteContext(ctx, key)
+	done(err)
+	return err
+}
+
+// DeleteIfLocked implements BackendOperations
+func (i *instrumentedBackend) DeleteIfLocked(key string, lock KVLocker) error {
+	return i.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (i *instrumentedBackend) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	done := i.begin(operationDelete, key)
+	err := i.BackendOperations.DeleteIfLockedContext(ctx, key, lock)
+	done(err)
+	return err
+}
+
+// DeletePrefix implements BackendOperations
+func (i *instrumentedBackend) DeletePrefix(path string) error {
+	return i.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (i *instrumentedBackend) DeletePrefixContext(ctx context.Context, path string) error {
+	done := i.begin(operationDelete, path)
+	err := i.BackendOperations.DeletePrefixContext(ctx, path)
+	done(err)
+	return err
+}
+
+// Update implements BackendOperations
+func (i *instrumentedBackend) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	done := i.begin(operationUpdate, key)
+	err := i.BackendOperations.Update(ctx, key, value, lease)
+	done(err)
+	return err
+}
+
+// UpdateIfLocked implements BackendOperations
+func (i *instrumentedBackend) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) error {
+	done := i.begin(operationUpdate, key)
+	err := i.BackendOperations.UpdateIfLocked(ctx, key, value, lease, lock)
+	done(err)
+	return err
+}
+
+// UpdateIfDifferent implements BackendOperations
+func (i *instrumentedBackend) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	done := i.begin(operationUpdate, key)
+	recreated, err := i.BackendOperations.UpdateIfDifferent(ctx, key, value, lease)
+	done(err)
+	return recreated, err
+}
+
+// UpdateIfDifferentIfLocked implements BackendOperations
+func (i *instrumentedBackend) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	done := i.begin(operationUpdate, key)
+	recreated, err := i.BackendOperations.UpdateIfDifferentIfLocked(ctx, key, value, lease, lock)
+	done(err)
+	return recreated, err
+}
+
+// CreateOnly implements BackendOperations
+func (i *instrumentedBackend) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	done := i.begin(operationCreate, key)
+	success, err := i.BackendOperations.CreateOnly(ctx, key, value, lease)
+	done(err)
+	return success, err
+}
+
+// CreateOnlyIfLocked implements BackendOperations
+func (i *instrumentedBackend) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	done := i.begin(operationCreate, key)
+	success, err := i.BackendOperations.CreateOnlyIfLocked(ctx, key, value, lease, lock)
+	done(err)
+	return success, err
+}
+
+// CreateIfExists implements BackendOperations
+func (i *instrumentedBackend) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return i.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (i *instrumentedBackend) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	done := i.begin(operationCreate, key)
+	err := i.BackendOperations.CreateIfExistsContext(ctx, condKey, key, value, lease)
+	done(err)
+	return err
+}
+
+// ListPrefix implements BackendOperations
+func (i *instrumentedBackend) ListPrefix(prefix string) (KeyValuePairs, error) {
+	return i.ListPrefixContext(context.TODO(), prefix)
+}
+
+// ListPrefixContext implements BackendOperations
+func (i *instrumentedBackend) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	done := i.begin(operationListPrefix, prefix)
+	pairs, err := i.BackendOperations.ListPrefixContext(ctx, prefix)
+	done(err)
+	return pairs, err
+}
+
+// ListPrefixIfLocked implements BackendOperations
+func (i *instrumentedBackend) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
+	return i.ListPrefixIfLockedContext(context.TODO(), prefix, lock)
+}
+
+// ListPrefixIfLockedContext implements BackendOperations
+func (i *instrumentedBackend) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	done := i.begin(operationListPrefix, prefix)
+	pairs, err := i.BackendOperations.ListPrefixIfLockedContext(ctx, prefix, lock)
+	done(err)
+	return pairs, err
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cilium/cilium/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	. "gopkg.in/check.v1"
+)
+
+// blockingBackend is a BackendOperations whose GetContext() blocks until
+// release is closed and then fails
+type blockingBackend struct {
+	BackendOperations
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) GetContext(ctx context.Context, key string) ([]byte, error) {
+	close(b.started)
+	<-b.release
+	return nil, errors.New("failed")
+}
+
+func (s *independentSuite) TestInstrumentedBackend(c *C) {
+	oldInflight, oldErrors := metrics.KVStoreOperationsInflight, metrics.KVStoreOperationErrors
+	defer func() {
+		metrics.KVStoreOperationsInflight, metrics.KVStoreOperationErrors = oldInflight, oldErrors
+	}()
+
+	inflight := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "inflight"}, []string{metrics.LabelOperation})
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors"}, []string{metrics.LabelScope, metrics.LabelOperation})
+	metrics.KVStoreOperationsInflight, metrics.KVStoreOperationErrors = inflight, errs
+
+	value := func(m prometheus.Metric) float64 {
+		var pb dto.Metric
+		c.Assert(m.Write(&pb), IsNil)
+		if pb.Gauge != nil {
+			return pb.Gauge.GetValue()
+		}
+		return pb.Counter.GetValue()
+	}
+
+	backend := &blockingBackend{started: make(chan struct{}), release: make(chan struct{})}
+	i := &instrumentedBackend{BackendOperations: backend}
+
+	const key = "cilium/state/nodes/v1/default/node1"
+	done := make(chan error)
+	go func() {
+		_, err := i.Get(key)
+		done <- err
+	}()
+
+	<-backend.started
+	c.Assert(value(inflight.WithLabelValues(operationGet)), Equals, float64(1))
+
+	close(backend.release)
+	c.Assert(<-done, Not(IsNil))
+	c.Assert(value(inflight.WithLabelValues(operationGet)), Equals, float64(0))
+	c.Assert(value(errs.WithLabelValues("nodes/v1", operationGet)), Equals, float64(1))
+}
@@ -0,0 +1,297 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/lock"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WriteOpType is the type of a write operation passed to a WriteInterceptor
+type WriteOpType string
+
+const (
+	// WriteOpSet is an unconditional write of a key
+	WriteOpSet WriteOpType = "set"
+
+	// WriteOpUpdate is an update of a key, possibly creating it
+	WriteOpUpdate WriteOpType = "update"
+
+	// WriteOpCreate is the creation of a key which must not exist yet
+	WriteOpCreate WriteOpType = "create"
+
+	// WriteOpDelete is the deletion of a key
+	WriteOpDelete WriteOpType = "delete"
+
+	// WriteOpDeletePrefix is the deletion of all keys with a prefix
+	WriteOpDeletePrefix WriteOpType = "delete-prefix"
+)
+
+// WriteOp describes a write operation about to be performed against the
+// kvstore
+type WriteOp struct {
+	// Type is the type of the write operation
+	Type WriteOpType
+
+	// Key is the key being written. For WriteOpDeletePrefix, this is the
+	// prefix being deleted.
+	Key string
+
+	// Value is the value being written, if any
+	Value []byte
+
+	// Lease is true if the key is attached to the lease of the client
+	Lease bool
+}
+
+// WriteInterceptor is implemented by audit and security hooks observing
+// writes to the kvstore
+type WriteInterceptor interface {
+	// InterceptWrite is called before op is performed. If the
+	// interceptor was registered as enforcing, returning an error vetoes
+	// the write and the error is returned to the writer. Otherwise, the
+	// error is only logged.
+	InterceptWrite(op WriteOp) error
+}
+
+// WriteInterceptorID identifies the registration of a write interceptor
+type WriteInterceptorID uint64
+
+type registeredInterceptor struct {
+	id          WriteInterceptorID
+	prefix      string
+	interceptor WriteInterceptor
+	enforcing   bool
+}
+
+var (
+	// interceptorsMutex protects interceptors and lastInterceptorID
+	interceptorsMutex lock.RWMutex
+
+	// interceptors is the chain of write interceptors in order of
+	// registration
+	interceptors []registeredInterceptor
+
+	// lastInterceptorID is the ID of the last registered interceptor
+	lastInterceptorID WriteInterceptorID
+)
+
+// RegisterWriteInterceptor adds interceptor to the chain of interceptors
+// called for all writes to keys with the given prefix. An empty prefix
+// matches all keys. If enforcing is true, the interceptor can veto writes.
+// The returned ID can be passed to UnregisterWriteInterceptor to remove the
+// registration again.
+func RegisterWriteInterceptor(prefix string, interceptor WriteInterceptor, enforcing bool) WriteInterceptorID {
+	interceptorsMutex.Lock()
+	defer interceptorsMutex.Unlock()
+
+	lastInterceptorID++
+	interceptors = append(interceptors, registeredInterceptor{
+		id:          lastInterceptorID,
+		prefix:      prefix,
+		interceptor: interceptor,
+		enforcing:   enforcing,
+	})
+	return lastInterceptorID
+}
+
+// UnregisterWriteInterceptor removes the registration with the given ID from
+// the chain of interceptors
+func UnregisterWriteInterceptor(id WriteInterceptorID) {
+	interceptorsMutex.Lock()
+	defer interceptorsMutex.Unlock()
+
+	// The slice is copied as interceptWrite() may still be iterating
+	// over the previous chain
+	remaining := make([]registeredInterceptor, 0, len(interceptors))
+	for _, r := range interceptors {
+		if r.id != id {
+			remaining = append(remaining, r)
+		}
+	}
+	interceptors = remaining
+}
+
+// matches returns true if op affects keys with the prefix of the
+// registration. The deletion of a parent prefix affects all keys below it.
+func (r *registeredInterceptor) matches(op WriteOp) bool {
+	if strings.HasPrefix(op.Key, r.prefix) {
+		return true
+	}
+	return op.Type == WriteOpDeletePrefix && strings.HasPrefix(r.prefix, op.Key)
+}
+
+// interceptWrite runs op through the chain of interceptors. Returns an error
+// if an enforcing interceptor vetoed the write.
+func interceptWrite(op WriteOp) error {
+	// The interceptors are called without holding the mutex so that an
+	// interceptor can (un)register interceptors itself
+	interceptorsMutex.RLock()
+	chain := interceptors
+	interceptorsMutex.RUnlock()
+
+	for i := range chain {
+		r := &chain[i]
+		if !r.matches(op) {
+			continue
+		}
+
+		if err := r.interceptor.InterceptWrite(op); err != nil {
+			scopedLog := log.WithError(err).WithFields(logrus.Fields{
+				fieldKey:    op.Key,
+				"operation": op.Type,
+			})
+			if r.enforcing {
+				scopedLog.Warning("kvstore write vetoed by interceptor")
+				return fmt.Errorf("write to %s vetoed: %s", op.Key, err)
+			}
+			scopedLog.Info("kvstore write flagged by interceptor")
+		}
+	}
+
+	return nil
+}
+
+// interceptedBackend wraps a BackendOperations and runs all write operations
+// through the chain of registered write interceptors
+type interceptedBackend struct {
+	BackendOperations
+}
+
+// interceptClient wraps the client with an interceptedBackend
+func interceptClient(c BackendOperations) BackendOperations {
+	if c == nil {
+		return c
+	}
+	return &interceptedBackend{BackendOperations: c}
+}
+
+// Set implements BackendOperations
+func (i *interceptedBackend) Set(key string, value []byte) error {
+	return i.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (i *interceptedBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpSet, Key: key, Value: value}); err != nil {
+		return err
+	}
+	return i.BackendOperations.SetContext(ctx, key, value)
+}
+
+// Delete implements BackendOperations
+func (i *interceptedBackend) Delete(key string) error {
+	return i.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (i *interceptedBackend) DeleteContext(ctx context.Context, key string) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpDelete, Key: key}); err != nil {
+		return err
+	}
+	return i.BackendOperations.DeleteContext(ctx, key)
+}
+
+// DeleteIfLocked implements BackendOperations
+func (i *interceptedBackend) DeleteIfLocked(key string, lock KVLocker) error {
+	return i.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (i *interceptedBackend) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpDelete, Key: key}); err != nil {
+		return err
+	}
+	return i.BackendOperations.DeleteIfLockedContext(ctx, key, lock)
+}
+
+// DeletePrefix implements BackendOperations
+func (i *interceptedBackend) DeletePrefix(path string) error {
+	return i.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (i *interceptedBackend) DeletePrefixContext(ctx context.Context, path string) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpDeletePrefix, Key: path}); err != nil {
+		return err
+	}
+	return i.BackendOperations.DeletePrefixContext(ctx, path)
+}
+
+// Update implements BackendOperations
+func (i *interceptedBackend) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpUpdate, Key: key, Value: value, Lease: lease}); err != nil {
+		return err
+	}
+	return i.BackendOperations.Update(ctx, key, value, lease)
+}
+
+// UpdateIfLocked implements BackendOperations
+func (i *interceptedBackend) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpUpdate, Key: key, Value: value, Lease: lease}); err != nil {
+		return err
+	}
+	return i.BackendOperations.UpdateIfLocked(ctx, key, value, lease, lock)
+}
+
+// UpdateIfDifferent implements BackendOperations
+func (i *interceptedBackend) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if err := interceptWrite(WriteOp{Type: WriteOpUpdate, Key: key, Value: value, Lease: lease}); err != nil {
+		return false, err
+	}
+	return i.BackendOperations.UpdateIfDifferent(ctx, key, value, lease)
+}
+
+// UpdateIfDifferentIfLocked implements BackendOperations
+func (i *interceptedBackend) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	if err := interceptWrite(WriteOp{Type: WriteOpUpdate, Key: key, Value: value, Lease: lease}); err != nil {
+		return false, err
+	}
+	return i.BackendOperations.UpdateIfDifferentIfLocked(ctx, key, value, lease, lock)
+}
+
+// CreateOnly implements BackendOperations
+func (i *interceptedBackend) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if err := interceptWrite(WriteOp{Type: WriteOpCreate, Key: key, Value: value, Lease: lease}); err != nil {
+		return false, err
+	}
+	return i.BackendOperations.CreateOnly(ctx, key, value, lease)
+}
+
+// CreateOnlyIfLocked implements BackendOperations
+func (i *interceptedBackend) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	if err := interceptWrite(WriteOp{Type: WriteOpCreate, Key: key, Value: value, Lease: lease}); err != nil {
+		return false, err
+	}
+	return i.BackendOperations.CreateOnlyIfLocked(ctx, key, value, lease, lock)
+}
+
+// CreateIfExists implements BackendOperations
+func (i *interceptedBackend) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return i.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (i *interceptedBackend) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	if err := interceptWrite(WriteOp{Type: WriteOpCreate, Key: key, Value: value, Lease: lease}); err != nil {
+		return err
+	}
+	return i.BackendOperations.CreateIfExistsContext(ctx, condKey, key, value, lease)
+}
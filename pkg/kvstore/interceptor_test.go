@@ -0,0 +1,120 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+
+	. "gopkg.in/check.v1"
+)
+
+// recordingBackend is a BackendOperations recording the keys written
+type recordingBackend struct {
+	BackendOperations
+	written []string
+}
+
+func (r *recordingBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	r.written = append(r.written, key)
+	return nil
+}
+
+func (r *recordingBackend) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	r.written = append(r.written, key)
+	return nil
+}
+
+func (r *recordingBackend) DeletePrefixContext(ctx context.Context, path string) error {
+	r.written = append(r.written, path)
+	return nil
+}
+
+// recordingInterceptor records all intercepted writes and fails all of them
+// if veto is true
+type recordingInterceptor struct {
+	seen []WriteOp
+	veto bool
+}
+
+func (r *recordingInterceptor) InterceptWrite(op WriteOp) error {
+	r.seen = append(r.seen, op)
+	if r.veto {
+		return errors.New("unexpected write")
+	}
+	return nil
+}
+
+func (s *independentSuite) TestInterceptedBackend(c *C) {
+	audit := &recordingInterceptor{veto: true}
+	enforcer := &recordingInterceptor{veto: true}
+	auditID := RegisterWriteInterceptor("", audit, false)
+	enforcerID := RegisterWriteInterceptor("cilium/state/identities/", enforcer, true)
+	defer UnregisterWriteInterceptor(auditID)
+	defer UnregisterWriteInterceptor(enforcerID)
+
+	backend := &recordingBackend{}
+	i := interceptClient(backend)
+
+	// Failing non-enforcing interceptors only observe the write
+	c.Assert(i.Set("cilium/state/nodes/v1/node1", []byte("v")), IsNil)
+	c.Assert(audit.seen, DeepEquals, []WriteOp{{Type: WriteOpSet, Key: "cilium/state/nodes/v1/node1", Value: []byte("v")}})
+	c.Assert(enforcer.seen, HasLen, 0)
+
+	// Enforcing interceptors veto writes to their prefix
+	c.Assert(i.Set("cilium/state/identities/v1/id/1", []byte("v")), Not(IsNil))
+	c.Assert(enforcer.seen, HasLen, 1)
+
+	// Deleting a parent prefix affects the protected prefix
+	c.Assert(i.DeletePrefix("cilium/state/"), Not(IsNil))
+	c.Assert(enforcer.seen, HasLen, 2)
+	c.Assert(backend.written, DeepEquals, []string{"cilium/state/nodes/v1/node1"})
+
+	UnregisterWriteInterceptor(enforcerID)
+	c.Assert(i.DeletePrefix("cilium/state/"), IsNil)
+	c.Assert(backend.written, DeepEquals, []string{"cilium/state/nodes/v1/node1", "cilium/state/"})
+	c.Assert(audit.seen, HasLen, 4)
+}
+
+// funcInterceptor is a non-comparable WriteInterceptor
+type funcInterceptor []func(op WriteOp) error
+
+func (f funcInterceptor) InterceptWrite(op WriteOp) error {
+	for _, fn := range f {
+		if err := fn(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *independentSuite) TestInterceptorUnregistersItself(c *C) {
+	var id WriteInterceptorID
+	calls := 0
+	id = RegisterWriteInterceptor("", funcInterceptor{func(op WriteOp) error {
+		calls++
+		UnregisterWriteInterceptor(id)
+		return nil
+	}}, false)
+	otherID := RegisterWriteInterceptor("", funcInterceptor{}, false)
+	defer UnregisterWriteInterceptor(otherID)
+
+	i := interceptClient(&recordingBackend{})
+	c.Assert(i.Set("cilium/state/nodes/v1/node1", []byte("v")), IsNil)
+	c.Assert(i.Set("cilium/state/nodes/v1/node1", []byte("v")), IsNil)
+	c.Assert(calls, Equals, 1)
+}
@@ -0,0 +1,1262 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/controller"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/spanstat"
+
+	"github.com/pborman/uuid"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// KubernetesBackendName is the backend name for the Kubernetes backend
+	KubernetesBackendName = "kubernetes"
+
+	// KubernetesNamespaceOption is the namespace in which the ConfigMaps
+	// and Leases of the backend are stored
+	KubernetesNamespaceOption = "kubernetes.namespace"
+
+	// kubernetesTypeLabel is the label identifying all objects owned by
+	// the backend and their purpose
+	kubernetesTypeLabel = "io.cilium.kvstore"
+
+	// kubernetesSessionLabel is the label referring to the session lease
+	// of keys created with lease=true
+	kubernetesSessionLabel = "io.cilium.kvstore.session"
+
+	// kubernetesKeyAnnotation holds the full key of a ConfigMap
+	kubernetesKeyAnnotation = "io.cilium.kvstore/key"
+
+	// kubernetesValueField is the BinaryData field holding the value
+	kubernetesValueField = "value"
+
+	kubernetesTypeKey     = "key"
+	kubernetesTypeSession = "session"
+	kubernetesTypeLock    = "lock"
+
+	kubernetesObjectPrefix = "cilium-kv-"
+)
+
+var (
+	// kubernetesWatchRetryInterval is the interval in which a watcher
+	// retries to list and watch the keys after a failure
+	kubernetesWatchRetryInterval = 5 * time.Second
+
+	// kubernetesLockRetryInterval is the interval in which an attempt to
+	// acquire a lock held by another client is retried
+	kubernetesLockRetryInterval = 100 * time.Millisecond
+)
+
+type kubernetesModule struct {
+	opts   backendOptions
+	client kubernetes.Interface
+}
+
+func init() {
+	registerBackend(KubernetesBackendName, newKubernetesModule())
+}
+
+func newKubernetesModule() backendModule {
+	return &kubernetesModule{
+		opts: backendOptions{
+			KubernetesNamespaceOption: &backendOption{
+				description: "Namespace to store ConfigMaps and Leases in, defaults to the Cilium namespace",
+			},
+		},
+	}
+}
+
+func (k *kubernetesModule) createInstance() backendModule {
+	return newKubernetesModule()
+}
+
+func (k *kubernetesModule) getName() string {
+	return KubernetesBackendName
+}
+
+func (k *kubernetesModule) setConfigDummy() {
+}
+
+func (k *kubernetesModule) setConfig(opts map[string]string) error {
+	return setOpts(opts, k.opts)
+}
+
+func (k *kubernetesModule) setExtraConfig(opts *ExtraOptions) error {
+	if opts != nil && opts.KubernetesClient != nil {
+		k.client = opts.KubernetesClient
+	}
+	return nil
+}
+
+func (k *kubernetesModule) getConfig() map[string]string {
+	return getOpts(k.opts)
+}
+
+func (k *kubernetesModule) newClient(opts *ExtraOptions) (BackendOperations, chan error) {
+	errChan := make(chan error, 1)
+	defer close(errChan)
+
+	if k.client == nil && opts != nil {
+		k.client = opts.KubernetesClient
+	}
+	if k.client == nil {
+		errChan <- fmt.Errorf("the %s kvstore backend requires Kubernetes to be enabled", KubernetesBackendName)
+		return nil, errChan
+	}
+
+	namespace := option.Config.K8sNamespace
+	if opt := k.opts[KubernetesNamespaceOption]; opt != nil && opt.value != "" {
+		namespace = opt.value
+	}
+
+	backend, err := newKubernetesClient(k.client, namespace, opts)
+	if err != nil {
+		errChan <- err
+		return nil, errChan
+	}
+
+	return backend, errChan
+}
+
+// kubernetesClient is a kvstore backend storing keys in ConfigMaps. Keys
+// created with lease=true are bound to a coordination.k8s.io Lease renewed by
+// the client, locks are represented by Leases as well. All objects are
+// listed and filtered on the client side which makes the backend suitable
+// for small clusters only.
+type kubernetesClient struct {
+	client       kubernetes.Interface
+	namespace    string
+	controllers  *controller.Manager
+	extraOptions *ExtraOptions
+
+	disconnectedMu lock.RWMutex
+	disconnected   chan struct{}
+
+	// leaseMutex protects all fields below
+	leaseMutex lock.RWMutex
+	// sessionName and sessionUID identify the Lease all keys created
+	// with lease=true are bound to
+	sessionName string
+	sessionUID  types.UID
+	// session is the latest known version of the session Lease
+	session *coordinationv1.Lease
+	// lastRenew is the time the session was last renewed successfully
+	lastRenew time.Time
+	// leasedKeys contains the values of all keys bound to the session.
+	// They are re-published when the session has to be recreated.
+	leasedKeys map[string][]byte
+	// staleSession is the name of a session which has been replaced and
+	// which is deleted once all keys have been re-published
+	staleSession string
+}
+
+func newKubernetesClient(client kubernetes.Interface, namespace string, opts *ExtraOptions) (*kubernetesClient, error) {
+	k := &kubernetesClient{
+		client:       client,
+		namespace:    namespace,
+		controllers:  controller.NewManager(),
+		extraOptions: opts,
+		disconnected: make(chan struct{}),
+		leasedKeys:   map[string][]byte{},
+	}
+
+	session, err := k.createSession()
+	if err != nil {
+		return nil, err
+	}
+	k.setSession(session)
+
+	k.controllers.UpdateController("kubernetes-kvstore-session-keepalive",
+		controller.ControllerParams{
+			DoFunc:      k.renewSession,
+			RunInterval: option.Config.KVstoreKeepAliveInterval,
+		},
+	)
+
+	k.controllers.UpdateController("kubernetes-kvstore-session-gc",
+		controller.ControllerParams{
+			DoFunc: func(ctx context.Context) error {
+				return k.collectExpiredSessions()
+			},
+			RunInterval: option.Config.KVstoreLeaseTTL,
+		},
+	)
+
+	return k, nil
+}
+
+// createSession creates a new session Lease
+func (k *kubernetesClient) createSession() (*coordinationv1.Lease, error) {
+	now := metav1.NewMicroTime(time.Now())
+	name := kubernetesObjectPrefix + kubernetesTypeSession + "-" + uuid.New()
+	ttl := int32(option.Config.KVstoreLeaseTTL.Seconds())
+	session, err := k.client.CoordinationV1().Leases(k.namespace).Create(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{kubernetesTypeLabel: kubernetesTypeSession},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &name,
+			LeaseDurationSeconds: &ttl,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create session lease: %s", err)
+	}
+	return session, nil
+}
+
+// setSession makes session the session all keys created with lease=true are
+// bound to
+func (k *kubernetesClient) setSession(session *coordinationv1.Lease) {
+	k.leaseMutex.Lock()
+	k.session = session
+	k.sessionName = session.Name
+	k.sessionUID = session.UID
+	k.lastRenew = session.Spec.RenewTime.Time
+	k.leaseMutex.Unlock()
+}
+
+// sessionID returns the name and UID of the current session
+func (k *kubernetesClient) sessionID() (string, types.UID) {
+	k.leaseMutex.RLock()
+	defer k.leaseMutex.RUnlock()
+	return k.sessionName, k.sessionUID
+}
+
+// trackKey records the value of key if it is bound to the session so that
+// it can be re-published with a new session
+func (k *kubernetesClient) trackKey(key string, value []byte, lease bool) {
+	k.leaseMutex.Lock()
+	if lease {
+		k.leasedKeys[key] = value
+	} else {
+		delete(k.leasedKeys, key)
+	}
+	k.leaseMutex.Unlock()
+}
+
+// kubernetesObjectName returns the name of the object of the given type
+// representing key. Keys are hashed as they may contain characters which
+// are not valid in object names.
+func kubernetesObjectName(typ, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return kubernetesObjectPrefix + typ + "-" + hex.EncodeToString(sum[:])
+}
+
+// leaseExpired returns true if the lease has not been renewed within its
+// duration
+func leaseExpired(lease *coordinationv1.Lease, now time.Time) bool {
+	renewTime := lease.Spec.RenewTime
+	if renewTime == nil {
+		renewTime = lease.Spec.AcquireTime
+	}
+	if renewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	duration := time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	return renewTime.Add(duration).Before(now)
+}
+
+func (k *kubernetesClient) setDisconnected() {
+	k.disconnectedMu.Lock()
+	close(k.disconnected)
+	k.disconnected = make(chan struct{})
+	k.disconnectedMu.Unlock()
+}
+
+// renewSession renews the session Lease. A session which has expired may
+// already have been removed along with its keys by other clients, it is
+// replaced with a new session to which all keys are re-published.
+func (k *kubernetesClient) renewSession(ctx context.Context) error {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+
+	k.leaseMutex.RLock()
+	session := k.session.DeepCopy()
+	k.leaseMutex.RUnlock()
+
+	now := metav1.NewMicroTime(time.Now())
+	if leaseExpired(session, now.Time) {
+		return k.replaceSession()
+	}
+
+	session.Spec.RenewTime = &now
+	updated, err := leases.Update(session)
+	switch {
+	case k8sErrors.IsNotFound(err):
+		return k.replaceSession()
+	case k8sErrors.IsConflict(err):
+		// Refresh the resource version and retry on the next run
+		if latest, getErr := leases.Get(session.Name, metav1.GetOptions{}); getErr == nil {
+			k.leaseMutex.Lock()
+			k.session = latest
+			k.leaseMutex.Unlock()
+		}
+	}
+	if err != nil {
+		k.setDisconnected()
+		return err
+	}
+
+	k.leaseMutex.Lock()
+	k.session = updated
+	k.lastRenew = now.Time
+	k.leaseMutex.Unlock()
+
+	return k.republishKeys()
+}
+
+// replaceSession replaces the current session with a new session and
+// re-publishes all keys bound to the session
+func (k *kubernetesClient) replaceSession() error {
+	session, err := k.createSession()
+	if err != nil {
+		k.setDisconnected()
+		return err
+	}
+
+	k.leaseMutex.Lock()
+	// A session which could not be deleted yet is deleted once the keys
+	// have been re-published, keys are never bound to it anymore
+	if k.staleSession == "" {
+		k.staleSession = k.sessionName
+	}
+	k.leaseMutex.Unlock()
+	k.setSession(session)
+
+	log.WithField("session", session.Name).Warning("kvstore session expired, re-publishing keys with new session")
+
+	return k.republishKeys()
+}
+
+// republishKeys re-publishes all keys bound to the session after the session
+// has been replaced, followed by the deletion of the replaced session
+func (k *kubernetesClient) republishKeys() error {
+	k.leaseMutex.RLock()
+	staleSession := k.staleSession
+	keys := make([]string, 0, len(k.leasedKeys))
+	for key := range k.leasedKeys {
+		keys = append(keys, key)
+	}
+	k.leaseMutex.RUnlock()
+
+	if staleSession == "" {
+		return nil
+	}
+
+	for _, key := range keys {
+		// The key may have been updated or deleted in the meantime
+		k.leaseMutex.RLock()
+		value, ok := k.leasedKeys[key]
+		k.leaseMutex.RUnlock()
+		if !ok {
+			continue
+		}
+
+		if err := k.updateKey(key, value, true); err != nil {
+			return fmt.Errorf("unable to re-publish key %s: %s", key, err)
+		}
+	}
+
+	err := k.client.CoordinationV1().Leases(k.namespace).Delete(staleSession, nil)
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return err
+	}
+
+	k.leaseMutex.Lock()
+	k.staleSession = ""
+	k.leaseMutex.Unlock()
+	return nil
+}
+
+// collectExpiredSessions deletes all sessions of other clients which have not
+// been renewed in time, followed by the keys bound to them. The session is
+// deleted first so that its owner cannot renew it anymore and re-publishes
+// its keys with a new session instead.
+func (k *kubernetesClient) collectExpiredSessions() error {
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	list, err := leases.List(metav1.ListOptions{
+		LabelSelector: kubernetesTypeLabel + "=" + kubernetesTypeSession,
+	})
+	if err != nil {
+		return err
+	}
+
+	k.leaseMutex.RLock()
+	sessionName, staleSession := k.sessionName, k.staleSession
+	k.leaseMutex.RUnlock()
+
+	now := time.Now()
+	for i := range list.Items {
+		session := &list.Items[i]
+		// The replaced session of this client is deleted once its keys
+		// have been re-published
+		if session.Name == sessionName || session.Name == staleSession || !leaseExpired(session, now) {
+			continue
+		}
+
+		scopedLog := log.WithField("session", session.Name)
+		scopedLog.Info("Removing keys of expired kvstore session")
+
+		// The session may have been renewed since it was listed
+		err := leases.Delete(session.Name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &session.UID, ResourceVersion: &session.ResourceVersion},
+		})
+		switch {
+		case k8sErrors.IsConflict(err):
+			continue
+		case err != nil && !k8sErrors.IsNotFound(err):
+			return err
+		}
+
+		configMaps := k.client.CoreV1().ConfigMaps(k.namespace)
+		keys, err := configMaps.List(metav1.ListOptions{
+			LabelSelector: kubernetesSessionLabel + "=" + session.Name,
+		})
+		if err != nil {
+			return err
+		}
+		for i := range keys.Items {
+			if err := k.deleteSessionKey(&keys.Items[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteSessionKey deletes the ConfigMap cm bound to an expired session. The
+// key is only deleted if it has not been modified since it was listed, e.g.
+// re-published by its owner with a new session.
+func (k *kubernetesClient) deleteSessionKey(cm *v1.ConfigMap) error {
+	err := k.client.CoreV1().ConfigMaps(k.namespace).Delete(cm.Name, &metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &cm.UID, ResourceVersion: &cm.ResourceVersion},
+	})
+	if k8sErrors.IsNotFound(err) || k8sErrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// kubernetesLocker is a lock represented by a Lease held by the client. The
+// Lease is renewed while the lock is held.
+type kubernetesLocker struct {
+	client *kubernetesClient
+	name   string
+	uid    types.UID
+	holder string
+}
+
+// controllerName returns the name of the controller renewing the lock
+func (l *kubernetesLocker) controllerName() string {
+	return "kubernetes-kvstore-lock-" + string(l.uid)
+}
+
+// renew extends the Lease of the lock so that it is not taken over by other
+// clients while held for longer than the lease TTL
+func (l *kubernetesLocker) renew() error {
+	leases := l.client.client.CoordinationV1().Leases(l.client.namespace)
+	lease, err := leases.Get(l.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.UID != l.uid {
+		return fmt.Errorf("lock %s has been taken over", l.name)
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(lease)
+	return err
+}
+
+// Unlock stops renewing the lock and releases it by deleting the Lease
+func (l *kubernetesLocker) Unlock() error {
+	l.client.controllers.RemoveController(l.controllerName())
+
+	err := l.client.client.CoordinationV1().Leases(l.client.namespace).Delete(l.name,
+		&metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &l.uid}})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Comparator returns the locker itself. ConfigMaps cannot be modified in
+// transactions with the Lease of the lock, operations on behalf of the lock
+// verify that the lock is still held before accessing the key instead.
+func (l *kubernetesLocker) Comparator() interface{} {
+	return l
+}
+
+// verify returns ErrLockLeaseExpired if the Lease of the lock has been
+// released, taken over by another client or has not been renewed in time
+func (l *kubernetesLocker) verify() error {
+	lease, err := l.client.client.CoordinationV1().Leases(l.client.namespace).Get(l.name, metav1.GetOptions{})
+	switch {
+	case k8sErrors.IsNotFound(err):
+		return ErrLockLeaseExpired
+	case err != nil:
+		return err
+	}
+
+	holder := lease.Spec.HolderIdentity
+	if lease.UID != l.uid || holder == nil || *holder != l.holder || leaseExpired(lease, time.Now()) {
+		return ErrLockLeaseExpired
+	}
+	return nil
+}
+
+// checkKubernetesLock returns an error if the client no longer holds lock
+func checkKubernetesLock(lock KVLocker) error {
+	l, ok := lock.Comparator().(*kubernetesLocker)
+	if !ok {
+		return fmt.Errorf("lock of type %T is not a kubernetes lock", lock)
+	}
+	return l.verify()
+}
+
+// LockPath locks the provided path by creating a Lease. Leases of locks held
+// for longer than the lease TTL are considered stale and are taken over.
+func (k *kubernetesClient) LockPath(ctx context.Context, path string) (locker KVLocker, err error) {
+	duration := spanstat.Start()
+	defer func() {
+		increaseMetric(path, metricLock, "Lock", duration.EndError(err).Total(), err)
+	}()
+
+	timeout := lockTimeout
+	if PriorityFromContext(ctx) == PriorityBackground {
+		timeout = backgroundRequestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	name := kubernetesObjectName(kubernetesTypeLock, getLockPath(path))
+	sessionName, _ := k.sessionID()
+	holder := sessionName + "/" + uuid.New()
+	ttl := int32(option.Config.KVstoreLeaseTTL.Seconds())
+
+	for {
+		now := metav1.NewMicroTime(time.Now())
+		lease, err := leases.Create(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{kubernetesTypeLabel: kubernetesTypeLock},
+				Annotations: map[string]string{kubernetesKeyAnnotation: getLockPath(path)},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &ttl,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+		if err == nil {
+			locker := &kubernetesLocker{client: k, name: name, uid: lease.UID, holder: holder}
+			k.controllers.UpdateController(locker.controllerName(),
+				controller.ControllerParams{
+					DoFunc: func(ctx context.Context) error {
+						return locker.renew()
+					},
+					RunInterval: option.Config.KVstoreKeepAliveInterval,
+				},
+			)
+			return locker, nil
+		}
+		if !k8sErrors.IsAlreadyExists(err) {
+			return nil, err
+		}
+
+		existing, err := leases.Get(name, metav1.GetOptions{})
+		if err == nil && leaseExpired(existing, now.Time) {
+			Trace("Taking over stale lock", nil, logrus.Fields{fieldKey: path})
+			uid := existing.UID
+			leases.Delete(name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lock cancelled via context: %s", ctx.Err())
+		case <-time.After(kubernetesLockRetryInterval):
+		}
+	}
+}
+
+// listKeys returns all ConfigMaps representing keys matching prefix
+func (k *kubernetesClient) listKeys(prefix string) ([]v1.ConfigMap, error) {
+	keys, _, err := k.listKeysWithVersion(prefix)
+	return keys, err
+}
+
+// listKeysWithVersion returns all ConfigMaps representing keys matching
+// prefix and the resource version of the list
+func (k *kubernetesClient) listKeysWithVersion(prefix string) ([]v1.ConfigMap, string, error) {
+	list, err := k.client.CoreV1().ConfigMaps(k.namespace).List(metav1.ListOptions{
+		LabelSelector: kubernetesTypeLabel + "=" + kubernetesTypeKey,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	result := make([]v1.ConfigMap, 0, len(list.Items))
+	for _, cm := range list.Items {
+		key := cm.Annotations[kubernetesKeyAnnotation]
+		if !strings.HasPrefix(key, prefix) || quarantineValue(key, cm.BinaryData[kubernetesValueField]) {
+			continue
+		}
+		result = append(result, cm)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Annotations[kubernetesKeyAnnotation] < result[j].Annotations[kubernetesKeyAnnotation]
+	})
+
+	return result, list.ResourceVersion, nil
+}
+
+// getKey returns the ConfigMap representing key or nil if it does not exist
+func (k *kubernetesClient) getKey(key string) (*v1.ConfigMap, error) {
+	cm, err := k.client.CoreV1().ConfigMaps(k.namespace).Get(kubernetesObjectName(kubernetesTypeKey, key), metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return cm, err
+}
+
+// setKeyValue sets the value of the ConfigMap cm and binds it to the session
+// if lease is true
+func (k *kubernetesClient) setKeyValue(cm *v1.ConfigMap, key string, value []byte, lease bool) {
+	cm.Name = kubernetesObjectName(kubernetesTypeKey, key)
+	if cm.Labels == nil {
+		cm.Labels = map[string]string{}
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Labels[kubernetesTypeLabel] = kubernetesTypeKey
+	cm.Annotations[kubernetesKeyAnnotation] = key
+	cm.BinaryData = map[string][]byte{kubernetesValueField: value}
+
+	if lease {
+		sessionName, sessionUID := k.sessionID()
+		cm.Labels[kubernetesSessionLabel] = sessionName
+		cm.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: coordinationv1.SchemeGroupVersion.String(),
+			Kind:       "Lease",
+			Name:       sessionName,
+			UID:        sessionUID,
+		}}
+	} else {
+		delete(cm.Labels, kubernetesSessionLabel)
+		cm.OwnerReferences = nil
+	}
+}
+
+// createKey creates the ConfigMap for key, returns false if it already exists
+func (k *kubernetesClient) createKey(key string, value []byte, lease bool) (bool, error) {
+	cm := &v1.ConfigMap{}
+	k.setKeyValue(cm, key, value, lease)
+	_, err := k.client.CoreV1().ConfigMaps(k.namespace).Create(cm)
+	if k8sErrors.IsAlreadyExists(err) {
+		return false, nil
+	}
+	if err == nil {
+		k.trackKey(key, value, lease)
+	}
+	return err == nil, err
+}
+
+// updateKey creates or updates the ConfigMap for key
+func (k *kubernetesClient) updateKey(key string, value []byte, lease bool) error {
+	if err := checkValueSize(key, value); err != nil {
+		return err
+	}
+
+	configMaps := k.client.CoreV1().ConfigMaps(k.namespace)
+	for {
+		cm, err := k.getKey(key)
+		if err != nil {
+			return err
+		}
+
+		if cm == nil {
+			created, err := k.createKey(key, value, lease)
+			if err != nil || created {
+				return err
+			}
+			// Created concurrently, update instead
+			continue
+		}
+
+		k.setKeyValue(cm, key, value, lease)
+		_, err = configMaps.Update(cm)
+		if err == nil {
+			k.trackKey(key, value, lease)
+		}
+		if !k8sErrors.IsConflict(err) && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+	}
+}
+
+func (k *kubernetesClient) deleteKey(key string) error {
+	k.trackKey(key, nil, false)
+	err := k.client.CoreV1().ConfigMaps(k.namespace).Delete(kubernetesObjectName(kubernetesTypeKey, key), nil)
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// modRevision returns the resource version of the object as revision
+func modRevision(meta metav1.ObjectMeta) uint64 {
+	rev, _ := strconv.ParseUint(meta.ResourceVersion, 10, 64)
+	return rev
+}
+
+// Watch starts watching for changes in a prefix. All keys matching the prefix
+// are listed, changes are then received by watching the ConfigMaps starting
+// at the resource version of the list. Whenever the watch has to be restarted,
+// the keys are listed again and the differences to the last known state are
+// emitted.
+func (k *kubernetesClient) Watch(w *Watcher) {
+	configMaps := k.client.CoreV1().ConfigMaps(k.namespace)
+	// Last known state of all keys matching the prefix
+	localState := map[string]v1.ConfigMap{}
+	listDone := false
+
+	for {
+		keys, resourceVersion, err := k.listKeysWithVersion(w.prefix)
+		if err != nil {
+			Trace("List of Watch failed", err, logrus.Fields{fieldPrefix: w.prefix, fieldWatcher: w.name})
+			if !waitForWatchRetry(w) {
+				return
+			}
+			continue
+		}
+
+		// The watch is started before the listed keys are emitted so
+		// that no change is missed
+		watcher, err := configMaps.Watch(metav1.ListOptions{
+			LabelSelector:   kubernetesTypeLabel + "=" + kubernetesTypeKey,
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			Trace("Watch failed", err, logrus.Fields{fieldPrefix: w.prefix, fieldWatcher: w.name})
+			if !waitForWatchRetry(w) {
+				return
+			}
+			continue
+		}
+
+		listed := make(map[string]struct{}, len(keys))
+		for _, cm := range keys {
+			listed[cm.Annotations[kubernetesKeyAnnotation]] = struct{}{}
+			updateWatchState(w, localState, cm)
+		}
+		// Everything not listed anymore has been deleted
+		for key := range localState {
+			if _, ok := listed[key]; !ok {
+				deleteWatchState(w, localState, key)
+			}
+		}
+
+		// Initial list operation has been completed, signal this
+		if !listDone {
+			listDone = true
+			w.Events <- KeyValueEvent{Typ: EventTypeListDone}
+		}
+
+		stopped := processWatchEvents(w, watcher, localState)
+		watcher.Stop()
+		if stopped {
+			close(w.Events)
+			w.stopWait.Done()
+			return
+		}
+	}
+}
+
+// waitForWatchRetry waits before the watcher is restarted after a failure.
+// It returns false if the watcher has been stopped in the meantime.
+func waitForWatchRetry(w *Watcher) bool {
+	select {
+	case <-time.After(kubernetesWatchRetryInterval):
+		return true
+	case <-w.stopWatch:
+		close(w.Events)
+		w.stopWait.Done()
+		return false
+	}
+}
+
+// processWatchEvents emits the changes of the keys matching the prefix of
+// the watcher until the watch is closed by the apiserver, in which case false
+// is returned, or until the watcher is stopped.
+func processWatchEvents(w *Watcher, watcher watch.Interface, localState map[string]v1.ConfigMap) bool {
+	for {
+		select {
+		case <-w.stopWatch:
+			return true
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false
+			}
+			if event.Type == watch.Error {
+				Trace("Watch closed with error", nil, logrus.Fields{fieldPrefix: w.prefix, fieldWatcher: w.name})
+				return false
+			}
+
+			cm, ok := event.Object.(*v1.ConfigMap)
+			if !ok || cm.Labels[kubernetesTypeLabel] != kubernetesTypeKey {
+				continue
+			}
+			key := cm.Annotations[kubernetesKeyAnnotation]
+			if !strings.HasPrefix(key, w.prefix) {
+				continue
+			}
+
+			switch {
+			case event.Type == watch.Deleted:
+				deleteWatchState(w, localState, key)
+			case quarantineValue(key, cm.BinaryData[kubernetesValueField]):
+				// Oversized values are treated as if the key
+				// did not exist, as with a list
+				deleteWatchState(w, localState, key)
+			default:
+				updateWatchState(w, localState, *cm)
+			}
+		}
+	}
+}
+
+// updateWatchState emits a create or modify event for the key represented by
+// cm unless it is unchanged compared to the last known state
+func updateWatchState(w *Watcher, localState map[string]v1.ConfigMap, cm v1.ConfigMap) {
+	key := cm.Annotations[kubernetesKeyAnnotation]
+	value := cm.BinaryData[kubernetesValueField]
+
+	typ := EventTypeCreate
+	if oldKey, ok := localState[key]; ok {
+		if oldKey.ResourceVersion == cm.ResourceVersion &&
+			bytes.Equal(oldKey.BinaryData[kubernetesValueField], value) {
+			return
+		}
+		typ = EventTypeModify
+	}
+	localState[key] = cm
+
+	queueStart := spanstat.Start()
+	w.Events <- KeyValueEvent{
+		Typ:         typ,
+		Key:         key,
+		Value:       value,
+		ModRevision: modRevision(cm.ObjectMeta),
+	}
+	trackEventQueued(key, typ, queueStart.End(true).Total())
+}
+
+// deleteWatchState emits a delete event for key if it is part of the last
+// known state
+func deleteWatchState(w *Watcher, localState map[string]v1.ConfigMap, key string) {
+	deletedKey, ok := localState[key]
+	if !ok {
+		return
+	}
+	delete(localState, key)
+
+	queueStart := spanstat.Start()
+	w.Events <- KeyValueEvent{
+		Typ:         EventTypeDelete,
+		Key:         key,
+		Value:       deletedKey.BinaryData[kubernetesValueField],
+		ModRevision: modRevision(deletedKey.ObjectMeta),
+	}
+	trackEventQueued(key, EventTypeDelete, queueStart.End(true).Total())
+}
+
+// Connected closes the returned channel once the session lease has been
+// created, which is the case as soon as the client exists.
+func (k *kubernetesClient) Connected() <-chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+// Disconnected closes the returned channel when the session lease could not
+// be renewed.
+func (k *kubernetesClient) Disconnected() <-chan struct{} {
+	k.disconnectedMu.RLock()
+	ch := k.disconnected
+	k.disconnectedMu.RUnlock()
+	return ch
+}
+
+// Status returns the status of the session lease
+func (k *kubernetesClient) Status() (string, error) {
+	status, _ := k.LeaseStatus(context.TODO())
+	msg := fmt.Sprintf("Kubernetes: namespace %s, session %s", k.namespace, status.ID)
+	if !status.Healthy() {
+		return msg, fmt.Errorf("session lease has not been renewed in time")
+	}
+	return msg, nil
+}
+
+// DeletePrefix deletes all keys matching the prefix
+func (k *kubernetesClient) DeletePrefix(path string) (err error) {
+	return k.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (k *kubernetesClient) DeletePrefixContext(ctx context.Context, path string) (err error) {
+	duration := spanstat.Start()
+	defer func() {
+		increaseMetric(path, metricDelete, "DeletePrefix", duration.EndError(err).Total(), err)
+	}()
+
+	keys, err := k.listKeys(path)
+	if err != nil {
+		return err
+	}
+	for _, cm := range keys {
+		if err = k.deleteKey(cm.Annotations[kubernetesKeyAnnotation]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set sets value of key
+func (k *kubernetesClient) Set(key string, value []byte) error {
+	return k.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (k *kubernetesClient) SetContext(ctx context.Context, key string, value []byte) error {
+	duration := spanstat.Start()
+	err := k.updateKey(key, value, false)
+	increaseMetric(key, metricSet, "Set", duration.EndError(err).Total(), err)
+	return err
+}
+
+// DeleteIfLocked deletes a key if the client is still holding the given lock.
+func (k *kubernetesClient) DeleteIfLocked(key string, lock KVLocker) error {
+	return k.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (k *kubernetesClient) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	if err := checkKubernetesLock(lock); err != nil {
+		return err
+	}
+	return k.DeleteContext(ctx, key)
+}
+
+// Delete deletes a key
+func (k *kubernetesClient) Delete(key string) error {
+	return k.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (k *kubernetesClient) DeleteContext(ctx context.Context, key string) error {
+	duration := spanstat.Start()
+	err := k.deleteKey(key)
+	increaseMetric(key, metricDelete, "Delete", duration.EndError(err).Total(), err)
+	return err
+}
+
+// GetIfLocked returns value of key if the client is still holding the given lock.
+func (k *kubernetesClient) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
+	return k.GetIfLockedContext(context.TODO(), key, lock)
+}
+
+// GetIfLockedContext implements BackendOperations
+func (k *kubernetesClient) GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error) {
+	if err := checkKubernetesLock(lock); err != nil {
+		return nil, err
+	}
+	return k.GetContext(ctx, key)
+}
+
+// Get returns value of key
+func (k *kubernetesClient) Get(key string) ([]byte, error) {
+	return k.GetContext(context.TODO(), key)
+}
+
+// GetContext implements BackendOperations
+func (k *kubernetesClient) GetContext(ctx context.Context, key string) ([]byte, error) {
+	duration := spanstat.Start()
+	cm, err := k.getKey(key)
+	increaseMetric(key, metricRead, "Get", duration.EndError(err).Total(), err)
+	if err != nil || cm == nil {
+		return nil, err
+	}
+	value := cm.BinaryData[kubernetesValueField]
+	if quarantineValue(key, value) {
+		return nil, nil
+	}
+	return value, nil
+}
+
+// GetPrefixIfLocked returns the first key which matches the prefix and its value if the client is still holding the given lock.
+func (k *kubernetesClient) GetPrefixIfLocked(ctx context.Context, prefix string, lock KVLocker) (string, []byte, error) {
+	if err := checkKubernetesLock(lock); err != nil {
+		return "", nil, err
+	}
+	return k.GetPrefix(ctx, prefix)
+}
+
+// GetPrefix returns the first key which matches the prefix and its value
+func (k *kubernetesClient) GetPrefix(ctx context.Context, prefix string) (string, []byte, error) {
+	duration := spanstat.Start()
+	keys, err := k.listKeys(prefix)
+	increaseMetric(prefix, metricRead, "GetPrefix", duration.EndError(err).Total(), err)
+	if err != nil || len(keys) == 0 {
+		return "", nil, err
+	}
+
+	return keys[0].Annotations[kubernetesKeyAnnotation], keys[0].BinaryData[kubernetesValueField], nil
+}
+
+// UpdateIfLocked atomically creates a key or fails if it already exists if the client is still holding the given lock.
+func (k *kubernetesClient) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) error {
+	if err := checkKubernetesLock(lock); err != nil {
+		return err
+	}
+	return k.Update(ctx, key, value, lease)
+}
+
+// Update creates or updates a key with the value
+func (k *kubernetesClient) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	duration := spanstat.Start()
+	err := k.updateKey(key, value, lease)
+	increaseMetric(key, metricSet, "Update", duration.EndError(err).Total(), err)
+	return err
+}
+
+// UpdateIfDifferentIfLocked updates a key if the value is different and if the client is still holding the given lock.
+func (k *kubernetesClient) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	if err := checkKubernetesLock(lock); err != nil {
+		return false, err
+	}
+	return k.UpdateIfDifferent(ctx, key, value, lease)
+}
+
+// UpdateIfDifferent updates a key if the value or the lease is different
+func (k *kubernetesClient) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	duration := spanstat.Start()
+	cm, err := k.getKey(key)
+	increaseMetric(key, metricRead, "Get", duration.EndError(err).Total(), err)
+	// On error, attempt update blindly
+	if err != nil || cm == nil {
+		return true, k.Update(ctx, key, value, lease)
+	}
+
+	sessionName, _ := k.sessionID()
+	if lease != (cm.Labels[kubernetesSessionLabel] == sessionName) ||
+		!bytes.Equal(cm.BinaryData[kubernetesValueField], value) {
+		return true, k.Update(ctx, key, value, lease)
+	}
+
+	return false, nil
+}
+
+// CreateOnlyIfLocked atomically creates a key if the client is still holding the given lock or fails if it already exists
+func (k *kubernetesClient) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	if err := checkKubernetesLock(lock); err != nil {
+		return false, err
+	}
+	return k.CreateOnly(ctx, key, value, lease)
+}
+
+// CreateOnly creates a key with the value and will fail if the key already exists
+func (k *kubernetesClient) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if err := checkValueSize(key, value); err != nil {
+		return false, err
+	}
+
+	duration := spanstat.Start()
+	created, err := k.createKey(key, value, lease)
+	increaseMetric(key, metricSet, "CreateOnly", duration.EndError(err).Total(), err)
+	return created, err
+}
+
+// createIfExists creates a key with the value only if key condKey exists
+func (k *kubernetesClient) createIfExists(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	// ConfigMaps cannot be modified in transactions, lock the conditional
+	// key to serialize all CreateIfExists() calls. Acquiring the lock is
+	// bound by the lock timeout, see LockPath().
+	l, err := k.LockPath(ctx, condKey)
+	if err != nil {
+		return fmt.Errorf("unable to lock condKey for CreateIfExists: %s", err)
+	}
+
+	defer l.Unlock()
+
+	cm, err := k.getKey(condKey)
+	if err != nil {
+		return err
+	}
+	if cm == nil {
+		return ErrConditionalKeyAbsent
+	}
+
+	_, err = k.CreateOnlyIfLocked(ctx, key, value, lease, l)
+	return err
+}
+
+// CreateIfExists creates a key with the value only if key condKey exists
+func (k *kubernetesClient) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return k.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (k *kubernetesClient) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	duration := spanstat.Start()
+	err := k.createIfExists(ctx, condKey, key, value, lease)
+	increaseMetric(key, metricSet, "CreateIfExists", duration.EndError(err).Total(), err)
+	return err
+}
+
+// ListPrefixIfLocked returns a list of keys matching the prefix only if the client is still holding the given lock.
+func (k *kubernetesClient) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
+	return k.ListPrefixIfLockedContext(context.TODO(), prefix, lock)
+}
+
+// ListPrefixIfLockedContext implements BackendOperations
+func (k *kubernetesClient) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	if err := checkKubernetesLock(lock); err != nil {
+		return nil, err
+	}
+	return k.ListPrefixContext(ctx, prefix)
+}
+
+// ListPrefix returns a map of matching keys
+func (k *kubernetesClient) ListPrefix(prefix string) (KeyValuePairs, error) {
+	return k.ListPrefixContext(context.TODO(), prefix)
+}
+
+// ListPrefixContext implements BackendOperations
+func (k *kubernetesClient) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	duration := spanstat.Start()
+	keys, err := k.listKeys(prefix)
+	increaseMetric(prefix, metricRead, "ListPrefix", duration.EndError(err).Total(), err)
+	if err != nil {
+		return nil, err
+	}
+
+	p := KeyValuePairs(make(map[string]Value, len(keys)))
+	for _, cm := range keys {
+		p[cm.Annotations[kubernetesKeyAnnotation]] = Value{
+			Data:        cm.BinaryData[kubernetesValueField],
+			ModRevision: modRevision(cm.ObjectMeta),
+		}
+	}
+
+	return p, nil
+}
+
+// LeaseStatus returns the status of the session lease. The remaining TTL is
+// derived from the time of the last successful renewal.
+func (k *kubernetesClient) LeaseStatus(ctx context.Context) (LeaseStatus, error) {
+	k.leaseMutex.RLock()
+	lastRenew := k.lastRenew
+	sessionName := k.sessionName
+	k.leaseMutex.RUnlock()
+
+	status := LeaseStatus{
+		ID:  sessionName,
+		TTL: option.Config.KVstoreLeaseTTL,
+	}
+	if remaining := status.TTL - time.Since(lastRenew); remaining > 0 {
+		status.Remaining = remaining
+	}
+
+	return status, nil
+}
+
+// Close stops renewing the session and deletes it along with all keys bound
+// to it
+func (k *kubernetesClient) Close() {
+	// Wait for a running renewal which could otherwise replace the
+	// session after it has been deleted
+	if k.controllers != nil {
+		k.controllers.RemoveAllAndWait()
+	}
+
+	sessionName, _ := k.sessionID()
+	configMaps := k.client.CoreV1().ConfigMaps(k.namespace)
+	if keys, err := configMaps.List(metav1.ListOptions{
+		LabelSelector: kubernetesSessionLabel + "=" + sessionName,
+	}); err == nil {
+		for _, cm := range keys.Items {
+			configMaps.Delete(cm.Name, nil)
+		}
+	}
+	k.client.CoordinationV1().Leases(k.namespace).Delete(sessionName, nil)
+}
+
+// GetCapabilities returns the capabilities of the backend
+func (k *kubernetesClient) GetCapabilities() Capabilities {
+	return Capabilities(0)
+}
+
+// Encode encodes a binary slice into a character set that the backend supports
+func (k *kubernetesClient) Encode(in []byte) string {
+	return base64.URLEncoding.EncodeToString([]byte(in))
+}
+
+// Decode decodes a key previously encoded back into the original binary slice
+func (k *kubernetesClient) Decode(in string) ([]byte, error) {
+	return base64.URLEncoding.DecodeString(in)
+}
+
+// ListAndWatch implements the BackendOperations.ListAndWatch using Kubernetes
+func (k *kubernetesClient) ListAndWatch(name, prefix string, chanSize int) *Watcher {
+	w := newWatcher(name, prefix, chanSize)
+
+	log.WithField(fieldWatcher, w).Debug("Starting watcher...")
+
+	go k.Watch(w)
+
+	return w
+}
@@ -0,0 +1,344 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/option"
+
+	. "gopkg.in/check.v1"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestKubernetesClient(c *C) *kubernetesClient {
+	if option.Config.KVstoreLeaseTTL == 0 {
+		option.Config.KVstoreLeaseTTL = defaults.KVstoreLeaseTTL
+	}
+
+	client, errChan := newKubernetesModule().newClient(&ExtraOptions{
+		KubernetesClient: fake.NewSimpleClientset(),
+	})
+	c.Assert(client, Not(IsNil))
+	_, isErr := <-errChan
+	c.Assert(isErr, Equals, false)
+
+	return client.(*kubernetesClient)
+}
+
+func (s *independentSuite) TestKubernetesBackendRequiresClient(c *C) {
+	client, errChan := newKubernetesModule().newClient(nil)
+	c.Assert(client, IsNil)
+	err, isErr := <-errChan
+	c.Assert(isErr, Equals, true)
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *independentSuite) TestKubernetesBackendKeys(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	c.Assert(k.Set("cilium/state/foo", []byte("bar")), IsNil)
+	value, err := k.Get("cilium/state/foo")
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("bar"))
+
+	value, err = k.Get("cilium/state/unknown")
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+
+	created, err := k.CreateOnly(context.TODO(), "cilium/state/foo", []byte("baz"), false)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, false)
+
+	created, err = k.CreateOnly(context.TODO(), "cilium/state/bar", []byte("baz"), true)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, true)
+
+	updated, err := k.UpdateIfDifferent(context.TODO(), "cilium/state/bar", []byte("baz"), true)
+	c.Assert(err, IsNil)
+	c.Assert(updated, Equals, false)
+
+	updated, err = k.UpdateIfDifferent(context.TODO(), "cilium/state/bar", []byte("baz"), false)
+	c.Assert(err, IsNil)
+	c.Assert(updated, Equals, true)
+
+	c.Assert(k.CreateIfExists("cilium/state/unknown", "cilium/state/cond", []byte("x"), false), Not(IsNil))
+	c.Assert(k.CreateIfExists("cilium/state/foo", "cilium/state/cond", []byte("x"), false), IsNil)
+
+	pairs, err := k.ListPrefix("cilium/state/")
+	c.Assert(err, IsNil)
+	c.Assert(len(pairs), Equals, 3)
+	c.Assert(pairs["cilium/state/bar"].Data, DeepEquals, []byte("baz"))
+
+	key, value, err := k.GetPrefix(context.TODO(), "cilium/state/")
+	c.Assert(err, IsNil)
+	c.Assert(key, Equals, "cilium/state/bar")
+	c.Assert(value, DeepEquals, []byte("baz"))
+
+	c.Assert(k.Delete("cilium/state/cond"), IsNil)
+	c.Assert(k.DeletePrefix("cilium/state/"), IsNil)
+	pairs, err = k.ListPrefix("cilium/")
+	c.Assert(err, IsNil)
+	c.Assert(len(pairs), Equals, 0)
+}
+
+func (s *independentSuite) TestKubernetesBackendLock(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	locker, err := k.LockPath(context.TODO(), "cilium/locks/foo")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err = k.LockPath(ctx, "cilium/locks/foo")
+	cancel()
+	c.Assert(err, Not(IsNil))
+
+	c.Assert(locker.Unlock(), IsNil)
+	locker, err = k.LockPath(context.TODO(), "cilium/locks/foo")
+	c.Assert(err, IsNil)
+	c.Assert(locker.Unlock(), IsNil)
+}
+
+func (s *independentSuite) TestKubernetesBackendLockRenewal(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	locker, err := k.LockPath(context.TODO(), "cilium/locks/foo")
+	c.Assert(err, IsNil)
+	defer locker.Unlock()
+
+	// A lock held for longer than the lease TTL is renewed and thus not
+	// taken over by other clients
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	name := kubernetesObjectName(kubernetesTypeLock, getLockPath("cilium/locks/foo"))
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+	lease.Spec.RenewTime = &expired
+	_, err = leases.Update(lease)
+	c.Assert(err, IsNil)
+
+	c.Assert(locker.(*kubernetesLocker).renew(), IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	_, err = k.LockPath(ctx, "cilium/locks/foo")
+	cancel()
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *independentSuite) TestKubernetesBackendLockLost(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	locker, err := k.LockPath(context.TODO(), "cilium/locks/foo")
+	c.Assert(err, IsNil)
+	defer locker.Unlock()
+
+	c.Assert(k.UpdateIfLocked(context.TODO(), "cilium/state/foo", []byte("x"), false, locker), IsNil)
+	value, err := k.GetIfLocked("cilium/state/foo", locker)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("x"))
+
+	// The stale lock was taken over by another client
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	name := kubernetesObjectName(kubernetesTypeLock, getLockPath("cilium/locks/foo"))
+	lease, err := leases.Get(name, metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	holder := "other"
+	lease.Spec.HolderIdentity = &holder
+	_, err = leases.Update(lease)
+	c.Assert(err, IsNil)
+
+	c.Assert(k.UpdateIfLocked(context.TODO(), "cilium/state/foo", []byte("y"), false, locker), Equals, ErrLockLeaseExpired)
+	created, err := k.CreateOnlyIfLocked(context.TODO(), "cilium/state/bar", []byte("y"), false, locker)
+	c.Assert(err, Equals, ErrLockLeaseExpired)
+	c.Assert(created, Equals, false)
+	c.Assert(k.DeleteIfLocked("cilium/state/foo", locker), Equals, ErrLockLeaseExpired)
+
+	value, err = k.Get("cilium/state/foo")
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("x"))
+
+	// A released lock is no longer held either
+	c.Assert(leases.Delete(name, nil), IsNil)
+	_, err = k.ListPrefixIfLocked("cilium/state/", locker)
+	c.Assert(err, Equals, ErrLockLeaseExpired)
+}
+
+func (s *independentSuite) TestKubernetesBackendReplaceSession(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	created, err := k.CreateOnly(context.TODO(), "cilium/state/leased", []byte("x"), true)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, true)
+
+	// Another client removed the session along with its keys
+	oldSession, _ := k.sessionID()
+	c.Assert(k.client.CoordinationV1().Leases(k.namespace).Delete(oldSession, nil), IsNil)
+	c.Assert(k.Delete("cilium/state/leased"), IsNil)
+	k.trackKey("cilium/state/leased", []byte("x"), true)
+
+	c.Assert(k.renewSession(context.TODO()), IsNil)
+	newSession, _ := k.sessionID()
+	c.Assert(newSession, Not(Equals), oldSession)
+
+	value, err := k.Get("cilium/state/leased")
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("x"))
+	cm, err := k.getKey("cilium/state/leased")
+	c.Assert(err, IsNil)
+	c.Assert(cm.Labels[kubernetesSessionLabel], Equals, newSession)
+
+	// An expired session is replaced as well and deleted afterwards
+	k.leaseMutex.Lock()
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * time.Duration(*k.session.Spec.LeaseDurationSeconds) * time.Second))
+	k.session.Spec.RenewTime = &expired
+	k.leaseMutex.Unlock()
+
+	c.Assert(k.renewSession(context.TODO()), IsNil)
+	replacedSession, _ := k.sessionID()
+	c.Assert(replacedSession, Not(Equals), newSession)
+	_, err = k.client.CoordinationV1().Leases(k.namespace).Get(newSession, metav1.GetOptions{})
+	c.Assert(err, Not(IsNil))
+	cm, err = k.getKey("cilium/state/leased")
+	c.Assert(err, IsNil)
+	c.Assert(cm.Labels[kubernetesSessionLabel], Equals, replacedSession)
+}
+
+func (s *independentSuite) TestKubernetesBackendExpiredSession(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	// A second client sharing the same API server
+	other, err := newKubernetesClient(k.client, k.namespace, nil)
+	c.Assert(err, IsNil)
+	other.controllers.RemoveAllAndWait()
+
+	created, err := other.CreateOnly(context.TODO(), "cilium/state/leased", []byte("x"), true)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, true)
+	c.Assert(other.Set("cilium/state/permanent", []byte("x")), IsNil)
+
+	// Keys of sessions which are still being renewed are retained
+	c.Assert(k.collectExpiredSessions(), IsNil)
+	pairs, err := k.ListPrefix("cilium/state/")
+	c.Assert(err, IsNil)
+	c.Assert(len(pairs), Equals, 2)
+
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	session, err := leases.Get(other.sessionName, metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * time.Duration(*session.Spec.LeaseDurationSeconds) * time.Second))
+	session.Spec.RenewTime = &expired
+	_, err = leases.Update(session)
+	c.Assert(err, IsNil)
+
+	c.Assert(k.collectExpiredSessions(), IsNil)
+	pairs, err = k.ListPrefix("cilium/state/")
+	c.Assert(err, IsNil)
+	c.Assert(len(pairs), Equals, 1)
+	_, ok := pairs["cilium/state/permanent"]
+	c.Assert(ok, Equals, true)
+
+	_, err = leases.Get(other.sessionName, metav1.GetOptions{})
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *independentSuite) TestKubernetesBackendExpiredSessionRepublished(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	other, err := newKubernetesClient(k.client, k.namespace, nil)
+	c.Assert(err, IsNil)
+	other.controllers.RemoveAllAndWait()
+
+	created, err := other.CreateOnly(context.TODO(), "cilium/state/leased", []byte("x"), true)
+	c.Assert(err, IsNil)
+	c.Assert(created, Equals, true)
+
+	leases := k.client.CoordinationV1().Leases(k.namespace)
+	session, err := leases.Get(other.sessionName, metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * time.Duration(*session.Spec.LeaseDurationSeconds) * time.Second))
+	session.Spec.RenewTime = &expired
+	_, err = leases.Update(session)
+	c.Assert(err, IsNil)
+
+	// The replaced session of the client itself is left to the client
+	k.leaseMutex.Lock()
+	k.staleSession = other.sessionName
+	k.leaseMutex.Unlock()
+	c.Assert(k.collectExpiredSessions(), IsNil)
+	_, err = leases.Get(other.sessionName, metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	k.leaseMutex.Lock()
+	k.staleSession = ""
+	k.leaseMutex.Unlock()
+
+	// The key is re-published with a new session after it has been
+	// listed, the precondition of the deletion fails
+	k.client.(*fake.Clientset).PrependReactor("delete", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8sErrors.NewConflict(v1.Resource("configmaps"), action.(k8stesting.DeleteAction).GetName(), nil)
+	})
+	c.Assert(k.collectExpiredSessions(), IsNil)
+
+	value, err := k.Get("cilium/state/leased")
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("x"))
+	_, err = leases.Get(other.sessionName, metav1.GetOptions{})
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *independentSuite) TestKubernetesBackendWatch(c *C) {
+	k := newTestKubernetesClient(c)
+	defer k.Close()
+
+	c.Assert(k.Set("cilium/watch/a", []byte("1")), IsNil)
+
+	w := k.ListAndWatch("test", "cilium/watch/", 10)
+	defer w.Stop()
+
+	expectEvent := func(typ EventType, key string) {
+		select {
+		case event := <-w.Events:
+			c.Assert(event.Typ, Equals, typ)
+			c.Assert(event.Key, Equals, key)
+		case <-time.After(5 * time.Second):
+			c.Fatalf("timeout waiting for %s event of key %s", typ, key)
+		}
+	}
+
+	expectEvent(EventTypeCreate, "cilium/watch/a")
+	expectEvent(EventTypeListDone, "")
+
+	c.Assert(k.Set("cilium/watch/a", []byte("2")), IsNil)
+	expectEvent(EventTypeModify, "cilium/watch/a")
+
+	c.Assert(k.Set("cilium/other/b", []byte("1")), IsNil)
+	c.Assert(k.Delete("cilium/watch/a"), IsNil)
+	expectEvent(EventTypeDelete, "cilium/watch/a")
+}
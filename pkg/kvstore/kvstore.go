@@ -82,6 +82,14 @@ func ListPrefix(prefix string) (KeyValuePairs, error) {
 	return v, err
 }
 
+// ListPrefixContext returns the list of keys matching the prefix, ctx
+// carries the priority of the operation
+func ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	v, err := Client().ListPrefixContext(ctx, prefix)
+	Trace("ListPrefix", err, logrus.Fields{fieldPrefix: prefix, fieldNumEntries: len(v)})
+	return v, err
+}
+
 // ListPrefixIfLocked  returns a list of keys matching the prefix only if the client is still holding the given lock.
 func ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
 	v, err := Client().ListPrefixIfLocked(prefix, lock)
@@ -89,6 +97,15 @@ func ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
 	return v, err
 }
 
+// ListPrefixIfLockedContext returns a list of keys matching the prefix only
+// if the client is still holding the given lock, ctx carries the priority of
+// the operation
+func ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	v, err := Client().ListPrefixIfLockedContext(ctx, prefix, lock)
+	Trace("ListPrefixIfLocked", err, logrus.Fields{fieldPrefix: prefix, fieldNumEntries: len(v)})
+	return v, err
+}
+
 // CreateOnly atomically creates a key or fails if it already exists
 func CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
 	success, err := Client().CreateOnly(ctx, key, value, lease)
@@ -170,6 +187,14 @@ func DeleteIfLocked(key string, lock KVLocker) error {
 	return err
 }
 
+// DeleteIfLockedContext deletes a key if the client is still holding the
+// given lock, ctx carries the priority of the operation
+func DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	err := Client().DeleteIfLockedContext(ctx, key, lock)
+	Trace("DeleteIfLocked", err, logrus.Fields{fieldKey: key})
+	return err
+}
+
 // DeletePrefix deletes all keys matching a prefix
 func DeletePrefix(prefix string) error {
 	err := Client().DeletePrefix(prefix)
@@ -196,6 +221,14 @@ func Decode(in string) ([]byte, error) {
 	return out, err
 }
 
+// GetLeaseStatus returns the status of the lease shared by all lease-protected
+// keys of the default client
+func GetLeaseStatus(ctx context.Context) (LeaseStatus, error) {
+	status, err := Client().LeaseStatus(ctx)
+	Trace("LeaseStatus", err, logrus.Fields{"remaining": status.Remaining})
+	return status, err
+}
+
 // Close closes the kvstore client
 func Close() {
 	defaultClient.Close()
@@ -17,8 +17,12 @@
 package kvstore
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/option"
+
 	. "gopkg.in/check.v1"
 )
 
@@ -49,3 +53,41 @@ func (s *independentSuite) TestValidateScopesFromKey(c *C) {
 		c.Assert(getScopeFromKey(key), Equals, val)
 	}
 }
+
+func (s *independentSuite) TestCheckValueSize(c *C) {
+	oldMaxValueSize := option.Config.KVstoreMaxValueSize
+	defer func() { option.Config.KVstoreMaxValueSize = oldMaxValueSize }()
+
+	option.Config.KVstoreMaxValueSize = 4
+	c.Assert(checkValueSize("foo", []byte("1234")), IsNil)
+	c.Assert(quarantineValue("foo", []byte("1234")), Equals, false)
+
+	err := checkValueSize("foo", []byte("12345"))
+	c.Assert(err, Not(IsNil))
+	tooLarge, ok := err.(*ErrValueTooLarge)
+	c.Assert(ok, Equals, true)
+	c.Assert(*tooLarge, Equals, ErrValueTooLarge{Key: "foo", Size: 5, MaxSize: 4})
+	c.Assert(quarantineValue("foo", []byte("12345")), Equals, true)
+
+	option.Config.KVstoreMaxValueSize = 0
+	c.Assert(checkValueSize("foo", []byte("12345")), IsNil)
+	c.Assert(quarantineValue("foo", []byte("12345")), Equals, false)
+}
+
+func (s *independentSuite) TestLogOversizedValue(c *C) {
+	oversizedValuesMutex.Lock()
+	oversizedValues = map[string]int{}
+	oversizedValuesMutex.Unlock()
+
+	// Each value is remembered to only be logged once
+	logOversizedValue("foo", 5)
+	logOversizedValue("foo", 5)
+	c.Assert(oversizedValues, checker.DeepEquals, map[string]int{"foo": 5})
+	logOversizedValue("foo", 6)
+	c.Assert(oversizedValues, checker.DeepEquals, map[string]int{"foo": 6})
+
+	for i := 0; i < maxLoggedOversizedValues; i++ {
+		logOversizedValue(fmt.Sprintf("bar%d", i), 5)
+	}
+	c.Assert(len(oversizedValues) <= maxLoggedOversizedValues, Equals, true)
+}
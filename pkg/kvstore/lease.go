@@ -0,0 +1,90 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cilium/cilium/pkg/controller"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/metrics"
+	"github.com/cilium/cilium/pkg/option"
+
+	"github.com/sirupsen/logrus"
+)
+
+const leaseHealthController = "kvstore-lease-health"
+
+// LeaseStatus is the status of the lease, or session, shared by all keys a
+// client creates with lease=true. All allocator slave keys and node store
+// keys of an agent are attached to this single lease.
+type LeaseStatus struct {
+	// ID is the identifier of the lease
+	ID string
+
+	// TTL is the time-to-live granted to the lease
+	TTL time.Duration
+
+	// Remaining is the time until the lease expires unless renewed
+	Remaining time.Duration
+}
+
+// Healthy returns true if the lease is being renewed in time. The lease is
+// renewed KVstoreKeepAliveIntervalFactor times per TTL, less than a
+// corresponding fraction of the TTL remaining indicates that keep-alives
+// have been missed.
+func (s LeaseStatus) Healthy() bool {
+	return s.Remaining >= s.TTL/defaults.KVstoreKeepAliveIntervalFactor
+}
+
+// leaseHealthControllers runs the lease health reporting of the default client
+var leaseHealthControllers = controller.NewManager()
+
+// checkLeaseHealth queries the lease status of c and reports it to the
+// metrics. Returns an error if the status is unavailable or the lease is not
+// renewed in time so that the failure shows in the controller status.
+func checkLeaseHealth(ctx context.Context, c BackendOperations) error {
+	status, err := c.LeaseStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to query kvstore lease status: %s", err)
+	}
+
+	metrics.KVStoreLeaseRemainingTTL.Set(status.Remaining.Seconds())
+
+	if !status.Healthy() {
+		log.WithFields(logrus.Fields{
+			"lease":     status.ID,
+			"ttl":       status.TTL,
+			"remaining": status.Remaining,
+		}).Warning("kvstore lease keep-alive is lagging, lease-protected keys may expire")
+		return fmt.Errorf("kvstore lease %s expires in %s", status.ID, status.Remaining)
+	}
+
+	return nil
+}
+
+// startLeaseHealthReporting periodically checks the health of the lease of c
+func startLeaseHealthReporting(c BackendOperations) {
+	leaseHealthControllers.UpdateController(leaseHealthController,
+		controller.ControllerParams{
+			DoFunc: func(ctx context.Context) error {
+				return checkLeaseHealth(ctx, c)
+			},
+			RunInterval: option.Config.KVstoreKeepAliveInterval,
+		},
+	)
+}
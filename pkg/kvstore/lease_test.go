@@ -0,0 +1,52 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// leaseBackend is a BackendOperations returning a fixed lease status
+type leaseBackend struct {
+	BackendOperations
+	status LeaseStatus
+	err    error
+}
+
+func (l *leaseBackend) LeaseStatus(ctx context.Context) (LeaseStatus, error) {
+	return l.status, l.err
+}
+
+func (s *independentSuite) TestLeaseHealth(c *C) {
+	c.Assert(LeaseStatus{TTL: 15 * time.Minute, Remaining: 14 * time.Minute}.Healthy(), Equals, true)
+	c.Assert(LeaseStatus{TTL: 15 * time.Minute, Remaining: 5 * time.Minute}.Healthy(), Equals, true)
+	c.Assert(LeaseStatus{TTL: 15 * time.Minute, Remaining: 4 * time.Minute}.Healthy(), Equals, false)
+	c.Assert(LeaseStatus{TTL: 15 * time.Minute}.Healthy(), Equals, false)
+
+	backend := &leaseBackend{status: LeaseStatus{ID: "1", TTL: 15 * time.Minute, Remaining: 14 * time.Minute}}
+	c.Assert(checkLeaseHealth(context.Background(), backend), IsNil)
+
+	backend.status.Remaining = time.Minute
+	c.Assert(checkLeaseHealth(context.Background(), backend), Not(IsNil))
+
+	backend.err = errors.New("unavailable")
+	c.Assert(checkLeaseHealth(context.Background(), backend), Not(IsNil))
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/cilium/cilium/pkg/debug"
 	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/option"
 	uuidfactor "github.com/cilium/cilium/pkg/uuid"
 
 	"github.com/davecgh/go-spew/spew"
@@ -59,9 +60,25 @@ type lockOwner struct {
 	id      uuid.UUID
 }
 
+// lockWaiter is a lock attempt queued for a path held by another local
+// user
+type lockWaiter struct {
+	// id is the id of the lock handed over to the waiter, valid once
+	// granted is closed
+	id uuid.UUID
+
+	// granted is closed when the lock has been handed over to the waiter
+	granted chan struct{}
+}
+
 type pathLocks struct {
 	mutex     lock.RWMutex
 	lockPaths map[string]lockOwner
+
+	// waiters is the FIFO queue of lock attempts waiting for each path if
+	// fair queuing is enabled. Released locks are handed over to the
+	// first waiter directly.
+	waiters map[string][]*lockWaiter
 }
 
 func init() {
@@ -89,13 +106,42 @@ func (pl *pathLocks) runGC() {
 	for path, owner := range pl.lockPaths {
 		if time.Since(owner.created) > staleLockTimeout {
 			log.WithField("path", path).Error("Forcefully unlocking local kvstore lock")
-			delete(pl.lockPaths, path)
+			pl.release(path)
 		}
 	}
 	pl.mutex.Unlock()
 }
 
+// release releases the lock of path and hands it over to the first waiter,
+// if any. Must be called with mutex held.
+func (pl *pathLocks) release(path string) {
+	delete(pl.lockPaths, path)
+
+	waiters := pl.waiters[path]
+	if len(waiters) == 0 {
+		return
+	}
+
+	w := waiters[0]
+	if len(waiters) == 1 {
+		delete(pl.waiters, path)
+	} else {
+		pl.waiters[path] = waiters[1:]
+	}
+
+	w.id = uuidfactor.NewUUID()
+	pl.lockPaths[path] = lockOwner{
+		created: time.Now(),
+		id:      w.id,
+	}
+	close(w.granted)
+}
+
 func (pl *pathLocks) lock(ctx context.Context, path string) (id uuid.UUID, err error) {
+	if option.Config.KVstoreLockFairQueuing {
+		return pl.lockFair(ctx, path)
+	}
+
 	for {
 		pl.mutex.Lock()
 		if _, ok := pl.lockPaths[path]; !ok {
@@ -118,10 +164,64 @@ func (pl *pathLocks) lock(ctx context.Context, path string) (id uuid.UUID, err e
 	}
 }
 
+// lockFair acquires the lock of path in the order of the lock attempts.
+// Unlike lock, which polls for the lock, a lock attempt cannot be starved by
+// other local users repeatedly locking the same path, e.g. the garbage
+// collector of the allocator competing with allocations.
+func (pl *pathLocks) lockFair(ctx context.Context, path string) (uuid.UUID, error) {
+	pl.mutex.Lock()
+	if _, ok := pl.lockPaths[path]; !ok && len(pl.waiters[path]) == 0 {
+		id := uuidfactor.NewUUID()
+		pl.lockPaths[path] = lockOwner{
+			created: time.Now(),
+			id:      id,
+		}
+		pl.mutex.Unlock()
+		return id, nil
+	}
+
+	w := &lockWaiter{granted: make(chan struct{})}
+	if pl.waiters == nil {
+		pl.waiters = map[string][]*lockWaiter{}
+	}
+	pl.waiters[path] = append(pl.waiters[path], w)
+	pl.mutex.Unlock()
+
+	select {
+	case <-w.granted:
+		return w.id, nil
+	case <-ctx.Done():
+	}
+
+	pl.mutex.Lock()
+	defer pl.mutex.Unlock()
+
+	select {
+	case <-w.granted:
+		// The lock was handed over concurrently, pass it on
+		if owner, ok := pl.lockPaths[path]; ok && uuid.Equal(owner.id, w.id) {
+			pl.release(path)
+		}
+	default:
+		waiters := pl.waiters[path]
+		for i := range waiters {
+			if waiters[i] == w {
+				pl.waiters[path] = append(waiters[:i:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(pl.waiters[path]) == 0 {
+			delete(pl.waiters, path)
+		}
+	}
+
+	return nil, fmt.Errorf("lock was cancelled: %s", ctx.Err())
+}
+
 func (pl *pathLocks) unlock(path string, id uuid.UUID) {
 	pl.mutex.Lock()
 	if owner, ok := pl.lockPaths[path]; ok && uuid.Equal(owner.id, id) {
-		delete(pl.lockPaths, path)
+		pl.release(path)
 	}
 	pl.mutex.Unlock()
 }
@@ -139,6 +239,9 @@ type Lock struct {
 //
 // It is required to call Unlock() on the returned Lock to unlock
 func LockPath(ctx context.Context, path string) (l *Lock, err error) {
+	start := time.Now()
+	defer func() { trackLockWait(ctx, path, time.Since(start), err) }()
+
 	id, err := kvstoreLocks.lock(ctx, path)
 	if err != nil {
 		return nil, err
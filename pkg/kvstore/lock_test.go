@@ -20,6 +20,8 @@ import (
 	"context"
 	"time"
 
+	"github.com/cilium/cilium/pkg/testutils"
+
 	"github.com/pborman/uuid"
 	. "gopkg.in/check.v1"
 )
@@ -70,3 +72,48 @@ func (s *independentSuite) TestLocalLockCancel(c *C) {
 	_, err = locks.lock(ctx, path)
 	c.Assert(err, Not(IsNil))
 }
+
+func (s *independentSuite) TestLocalLockFairQueuing(c *C) {
+	path := "locktest/foo"
+	locks := pathLocks{lockPaths: map[string]lockOwner{}}
+
+	id, err := locks.lockFair(context.Background(), path)
+	c.Assert(err, IsNil)
+
+	waiting := func() int {
+		locks.mutex.RLock()
+		defer locks.mutex.RUnlock()
+		return len(locks.waiters[path])
+	}
+
+	// Queue lock attempts one after another
+	acquired := make(chan int, 3)
+	ids := make(chan uuid.UUID, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			id, err := locks.lockFair(context.Background(), path)
+			c.Assert(err, IsNil)
+			acquired <- i
+			ids <- id
+		}(i)
+		c.Assert(testutils.WaitUntil(func() bool { return waiting() == i+1 }, time.Second), IsNil)
+	}
+
+	// A cancelled lock attempt leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = locks.lockFair(ctx, path)
+	c.Assert(err, Not(IsNil))
+	c.Assert(waiting(), Equals, 3)
+
+	// The lock is handed over in order of the lock attempts
+	for i := 0; i < 3; i++ {
+		locks.unlock(path, id)
+		c.Assert(<-acquired, Equals, i)
+		id = <-ids
+	}
+	c.Assert(waiting(), Equals, 0)
+
+	locks.unlock(path, id)
+	c.Assert(locks.lockPaths, HasLen, 0)
+}
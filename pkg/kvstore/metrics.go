@@ -15,6 +15,7 @@
 package kvstore
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -25,6 +26,7 @@ import (
 
 const (
 	metricDelete = "delete"
+	metricLock   = "lock"
 	metricRead   = "read"
 	metricSet    = "set"
 )
@@ -50,6 +52,15 @@ func increaseMetric(key, kind, action string, duration time.Duration, err error)
 		WithLabelValues(namespace, kind, action, outcome).Observe(duration.Seconds())
 }
 
+// trackLockWait records the time spent waiting for the local and the
+// kvstore lock of path
+func trackLockWait(ctx context.Context, path string, duration time.Duration, err error) {
+	if !option.Config.MetricsConfig.KVStoreLockWaitEnabled {
+		return
+	}
+	metrics.KVStoreLockWait.WithLabelValues(getScopeFromKey(path), PriorityFromContext(ctx).String(), metrics.Error2Outcome(err)).Observe(duration.Seconds())
+}
+
 func trackEventQueued(key string, typ EventType, duration time.Duration) {
 	if !option.Config.MetricsConfig.KVStoreEventsQueueDurationEnabled {
 		return
@@ -0,0 +1,67 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+)
+
+// OperationPriority classifies kvstore operations so that background
+// operations such as garbage collection never delay foreground operations
+// such as allocations sharing the same client
+type OperationPriority int
+
+const (
+	// PriorityForeground is the priority of operations on which a user
+	// is waiting. This is the priority of all operations unless
+	// specified otherwise.
+	PriorityForeground OperationPriority = iota
+
+	// PriorityBackground is the priority of periodic and housekeeping
+	// operations. Background operations are rate limited separately and
+	// are subject to shorter request timeouts.
+	PriorityBackground
+)
+
+// String returns the name of the priority
+func (p OperationPriority) String() string {
+	if p == PriorityBackground {
+		return "background"
+	}
+	return "foreground"
+}
+
+// priorityKey is the context key of the OperationPriority
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying the given priority. Operations
+// performed with the returned context are classified accordingly. The
+// priority only applies to operations accepting a context, all other
+// operations are always foreground operations.
+func WithPriority(ctx context.Context, prio OperationPriority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, prio)
+}
+
+// PriorityFromContext returns the priority carried by ctx, or
+// PriorityForeground if ctx does not carry a priority
+func PriorityFromContext(ctx context.Context) OperationPriority {
+	if ctx == nil {
+		return PriorityForeground
+	}
+	if prio, ok := ctx.Value(priorityKey{}).(OperationPriority); ok {
+		return prio
+	}
+	return PriorityForeground
+}
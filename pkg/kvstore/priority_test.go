@@ -0,0 +1,43 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestOperationPriority(c *C) {
+	c.Assert(PriorityFromContext(context.Background()), Equals, PriorityForeground)
+
+	ctx := WithPriority(context.Background(), PriorityBackground)
+	c.Assert(PriorityFromContext(ctx), Equals, PriorityBackground)
+	c.Assert(PriorityFromContext(ctx).String(), Equals, "background")
+
+	// Derived contexts inherit the priority
+	ctx, cancel := requestContext(ctx)
+	defer cancel()
+	c.Assert(PriorityFromContext(ctx), Equals, PriorityBackground)
+	_, hasDeadline := ctx.Deadline()
+	c.Assert(hasDeadline, Equals, true)
+
+	ctx, cancel = requestContext(context.Background())
+	defer cancel()
+	_, hasDeadline = ctx.Deadline()
+	c.Assert(hasDeadline, Equals, false)
+}
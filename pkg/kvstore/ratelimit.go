@@ -0,0 +1,293 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/cilium/pkg/metrics"
+	"github.com/cilium/cilium/pkg/option"
+
+	"golang.org/x/time/rate"
+)
+
+// Classes of operations limited by the client-side rate limiter
+const (
+	operationClassRead  = "read"
+	operationClassWrite = "write"
+	operationClassLock  = "lock"
+
+	// operationClassBackground is the class of all background operations
+	// if background operations are limited separately
+	operationClassBackground = "background"
+)
+
+// rateLimitedBackend wraps a BackendOperations and limits the rate of reads,
+// writes and lock acquisitions with a separate token bucket per class of
+// operations. This prevents bursty users such as the allocator garbage
+// collector or the ipcache synchronization from overwhelming small kvstore
+// clusters. Watches are not rate limited. If configured, operations with
+// PriorityBackground are limited by a separate token bucket instead so that
+// they never consume the tokens of foreground operations.
+type rateLimitedBackend struct {
+	BackendOperations
+
+	// reads, writes and locks are the limiters of the respective class
+	// of operations, nil if the class is not limited
+	reads  *rate.Limiter
+	writes *rate.Limiter
+	locks  *rate.Limiter
+
+	// background is the limiter of all background operations, nil if
+	// background operations are limited by the limiter of their class
+	background *rate.Limiter
+}
+
+// newLimiter returns a token bucket limiter allowing limit operations per
+// second with a burst of the same size, or nil if limit is 0
+func newLimiter(limit int) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(limit), limit)
+}
+
+// rateLimitClient wraps the client with a rateLimitedBackend if any of the
+// client-side rate limits is configured
+func rateLimitClient(c BackendOperations) BackendOperations {
+	if c == nil {
+		return c
+	}
+
+	r := &rateLimitedBackend{
+		BackendOperations: c,
+		reads:             newLimiter(option.Config.KVstoreRateLimitReads),
+		writes:            newLimiter(option.Config.KVstoreRateLimitWrites),
+		locks:             newLimiter(option.Config.KVstoreRateLimitLocks),
+		background:        newLimiter(option.Config.KVstoreRateLimitBackground),
+	}
+	if r.reads == nil && r.writes == nil && r.locks == nil && r.background == nil {
+		return c
+	}
+	return r
+}
+
+// wait blocks until limiter permits an operation of the given class or ctx
+// is cancelled. Background operations wait for the background limiter
+// instead, if configured. The time spent waiting is reported to the metrics.
+func (r *rateLimitedBackend) wait(ctx context.Context, limiter *rate.Limiter, class string) error {
+	if r.background != nil && PriorityFromContext(ctx) == PriorityBackground {
+		limiter, class = r.background, operationClassBackground
+	}
+
+	if limiter == nil {
+		return nil
+	}
+
+	start := time.Now()
+	err := limiter.Wait(ctx)
+	metrics.KVStoreRateLimitWait.WithLabelValues(class).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// LockPath implements BackendOperations
+func (r *rateLimitedBackend) LockPath(ctx context.Context, path string) (KVLocker, error) {
+	if err := r.wait(ctx, r.locks, operationClassLock); err != nil {
+		return nil, err
+	}
+	return r.BackendOperations.LockPath(ctx, path)
+}
+
+// Get implements BackendOperations
+func (r *rateLimitedBackend) Get(key string) ([]byte, error) {
+	return r.GetContext(context.TODO(), key)
+}
+
+// GetContext implements BackendOperations
+func (r *rateLimitedBackend) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if err := r.wait(ctx, r.reads, operationClassRead); err != nil {
+		return nil, err
+	}
+	return r.BackendOperations.GetContext(ctx, key)
+}
+
+// GetIfLocked implements BackendOperations
+func (r *rateLimitedBackend) GetIfLocked(key string, lock KVLocker) ([]byte, error) {
+	return r.GetIfLockedContext(context.TODO(), key, lock)
+}
+
+// GetIfLockedContext implements BackendOperations
+func (r *rateLimitedBackend) GetIfLockedContext(ctx context.Context, key string, lock KVLocker) ([]byte, error) {
+	if err := r.wait(ctx, r.reads, operationClassRead); err != nil {
+		return nil, err
+	}
+	return r.BackendOperations.GetIfLockedContext(ctx, key, lock)
+}
+
+// GetPrefix implements BackendOperations
+func (r *rateLimitedBackend) GetPrefix(ctx context.Context, prefix string) (string, []byte, error) {
+	if err := r.wait(ctx, r.reads, operationClassRead); err != nil {
+		return "", nil, err
+	}
+	return r.BackendOperations.GetPrefix(ctx, prefix)
+}
+
+// GetPrefixIfLocked implements BackendOperations
+func (r *rateLimitedBackend) GetPrefixIfLocked(ctx context.Context, prefix string, lock KVLocker) (string, []byte, error) {
+	if err := r.wait(ctx, r.reads, operationClassRead); err != nil {
+		return "", nil, err
+	}
+	return r.BackendOperations.GetPrefixIfLocked(ctx, prefix, lock)
+}
+
+// ListPrefix implements BackendOperations
+func (r *rateLimitedBackend) ListPrefix(prefix string) (KeyValuePairs, error) {
+	return r.ListPrefixContext(context.TODO(), prefix)
+}
+
+// ListPrefixContext implements BackendOperations
+func (r *rateLimitedBackend) ListPrefixContext(ctx context.Context, prefix string) (KeyValuePairs, error) {
+	if err := r.wait(ctx, r.reads, operationClassRead); err != nil {
+		return nil, err
+	}
+	return r.BackendOperations.ListPrefixContext(ctx, prefix)
+}
+
+// ListPrefixIfLocked implements BackendOperations
+func (r *rateLimitedBackend) ListPrefixIfLocked(prefix string, lock KVLocker) (KeyValuePairs, error) {
+	return r.ListPrefixIfLockedContext(context.TODO(), prefix, lock)
+}
+
+// ListPrefixIfLockedContext implements BackendOperations
+func (r *rateLimitedBackend) ListPrefixIfLockedContext(ctx context.Context, prefix string, lock KVLocker) (KeyValuePairs, error) {
+	if err := r.wait(ctx, r.reads, operationClassRead); err != nil {
+		return nil, err
+	}
+	return r.BackendOperations.ListPrefixIfLockedContext(ctx, prefix, lock)
+}
+
+// Set implements BackendOperations
+func (r *rateLimitedBackend) Set(key string, value []byte) error {
+	return r.SetContext(context.TODO(), key, value)
+}
+
+// SetContext implements BackendOperations
+func (r *rateLimitedBackend) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.SetContext(ctx, key, value)
+}
+
+// Delete implements BackendOperations
+func (r *rateLimitedBackend) Delete(key string) error {
+	return r.DeleteContext(context.TODO(), key)
+}
+
+// DeleteContext implements BackendOperations
+func (r *rateLimitedBackend) DeleteContext(ctx context.Context, key string) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.DeleteContext(ctx, key)
+}
+
+// DeleteIfLocked implements BackendOperations
+func (r *rateLimitedBackend) DeleteIfLocked(key string, lock KVLocker) error {
+	return r.DeleteIfLockedContext(context.TODO(), key, lock)
+}
+
+// DeleteIfLockedContext implements BackendOperations
+func (r *rateLimitedBackend) DeleteIfLockedContext(ctx context.Context, key string, lock KVLocker) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.DeleteIfLockedContext(ctx, key, lock)
+}
+
+// DeletePrefix implements BackendOperations
+func (r *rateLimitedBackend) DeletePrefix(path string) error {
+	return r.DeletePrefixContext(context.TODO(), path)
+}
+
+// DeletePrefixContext implements BackendOperations
+func (r *rateLimitedBackend) DeletePrefixContext(ctx context.Context, path string) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.DeletePrefixContext(ctx, path)
+}
+
+// Update implements BackendOperations
+func (r *rateLimitedBackend) Update(ctx context.Context, key string, value []byte, lease bool) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.Update(ctx, key, value, lease)
+}
+
+// UpdateIfLocked implements BackendOperations
+func (r *rateLimitedBackend) UpdateIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.UpdateIfLocked(ctx, key, value, lease, lock)
+}
+
+// UpdateIfDifferent implements BackendOperations
+func (r *rateLimitedBackend) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return false, err
+	}
+	return r.BackendOperations.UpdateIfDifferent(ctx, key, value, lease)
+}
+
+// UpdateIfDifferentIfLocked implements BackendOperations
+func (r *rateLimitedBackend) UpdateIfDifferentIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return false, err
+	}
+	return r.BackendOperations.UpdateIfDifferentIfLocked(ctx, key, value, lease, lock)
+}
+
+// CreateOnly implements BackendOperations
+func (r *rateLimitedBackend) CreateOnly(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return false, err
+	}
+	return r.BackendOperations.CreateOnly(ctx, key, value, lease)
+}
+
+// CreateOnlyIfLocked implements BackendOperations
+func (r *rateLimitedBackend) CreateOnlyIfLocked(ctx context.Context, key string, value []byte, lease bool, lock KVLocker) (bool, error) {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return false, err
+	}
+	return r.BackendOperations.CreateOnlyIfLocked(ctx, key, value, lease, lock)
+}
+
+// CreateIfExists implements BackendOperations
+func (r *rateLimitedBackend) CreateIfExists(condKey, key string, value []byte, lease bool) error {
+	return r.CreateIfExistsContext(context.TODO(), condKey, key, value, lease)
+}
+
+// CreateIfExistsContext implements BackendOperations
+func (r *rateLimitedBackend) CreateIfExistsContext(ctx context.Context, condKey, key string, value []byte, lease bool) error {
+	if err := r.wait(ctx, r.writes, operationClassWrite); err != nil {
+		return err
+	}
+	return r.BackendOperations.CreateIfExistsContext(ctx, condKey, key, value, lease)
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"context"
+
+	"github.com/cilium/cilium/pkg/option"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestRateLimitedBackend(c *C) {
+	oldWrites := option.Config.KVstoreRateLimitWrites
+	defer func() { option.Config.KVstoreRateLimitWrites = oldWrites }()
+
+	backend := &recordingBackend{}
+
+	// No limits configured, the client is not wrapped
+	option.Config.KVstoreRateLimitWrites = 0
+	c.Assert(rateLimitClient(backend), Equals, BackendOperations(backend))
+
+	option.Config.KVstoreRateLimitWrites = 1
+	r, ok := rateLimitClient(backend).(*rateLimitedBackend)
+	c.Assert(ok, Equals, true)
+	c.Assert(r.reads, IsNil)
+	c.Assert(r.locks, IsNil)
+
+	// The first write consumes the burst, the next write must wait and
+	// gives up when the context is cancelled
+	c.Assert(r.Set("foo", []byte("bar")), IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(r.Update(ctx, "foo", []byte("baz"), false), Not(IsNil))
+	c.Assert(backend.written, DeepEquals, []string{"foo"})
+}
+
+func (s *independentSuite) TestRateLimitedBackendBackground(c *C) {
+	oldWrites := option.Config.KVstoreRateLimitWrites
+	oldBackground := option.Config.KVstoreRateLimitBackground
+	defer func() {
+		option.Config.KVstoreRateLimitWrites = oldWrites
+		option.Config.KVstoreRateLimitBackground = oldBackground
+	}()
+
+	backend := &recordingBackend{}
+	option.Config.KVstoreRateLimitWrites = 1
+	option.Config.KVstoreRateLimitBackground = 1
+	r := rateLimitClient(backend).(*rateLimitedBackend)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	background := WithPriority(cancelled, PriorityBackground)
+
+	// Background writes do not consume the tokens of foreground writes
+	c.Assert(r.Update(WithPriority(context.Background(), PriorityBackground), "bg", []byte("bar"), false), IsNil)
+	c.Assert(r.Update(background, "bg", []byte("baz"), false), Not(IsNil))
+	c.Assert(r.Update(context.Background(), "fg", []byte("bar"), false), IsNil)
+	c.Assert(r.Update(cancelled, "fg", []byte("baz"), false), Not(IsNil))
+	c.Assert(backend.written, DeepEquals, []string{"bg", "fg"})
+}
+
+func (s *independentSuite) TestRateLimitedBackendContext(c *C) {
+	oldWrites := option.Config.KVstoreRateLimitWrites
+	oldBackground := option.Config.KVstoreRateLimitBackground
+	defer func() {
+		option.Config.KVstoreRateLimitWrites = oldWrites
+		option.Config.KVstoreRateLimitBackground = oldBackground
+	}()
+
+	backend := &recordingBackend{}
+	option.Config.KVstoreRateLimitWrites = 1
+	option.Config.KVstoreRateLimitBackground = 1
+	r := rateLimitClient(backend).(*rateLimitedBackend)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	background := WithPriority(cancelled, PriorityBackground)
+
+	// Operations without a context in their signature are background
+	// operations if called with a background context
+	c.Assert(r.SetContext(WithPriority(context.Background(), PriorityBackground), "bg", []byte("bar")), IsNil)
+	c.Assert(r.DeletePrefixContext(background, "bg"), Not(IsNil))
+	c.Assert(r.Set("fg", []byte("bar")), IsNil)
+	c.Assert(r.DeletePrefixContext(cancelled, "fg"), Not(IsNil))
+	c.Assert(backend.written, DeepEquals, []string{"bg", "fg"})
+}
@@ -0,0 +1,295 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+// ConflictResolution is the outcome of a ConflictResolver
+type ConflictResolution int
+
+const (
+	// PreferRemote applies the update received from the kvstore and
+	// passes it on to the observer
+	PreferRemote ConflictResolution = iota
+
+	// PreferLocal discards the update received from the kvstore and
+	// writes the local key to the kvstore again
+	PreferLocal
+)
+
+// ConflictResolver is called when an update received from the kvstore would
+// overwrite a key owned by the local store instance with a different value.
+// It decides whether the local or the remote version of the key is kept.
+type ConflictResolver func(local LocalKey, remote Key) ConflictResolution
+
+// LastWriterWins is a ConflictResolver which always applies the update
+// received from the kvstore. This matches the behavior of a store without
+// ConflictResolver.
+func LastWriterWins(local LocalKey, remote Key) ConflictResolution {
+	return PreferRemote
+}
+
+// LocalPreferred is a ConflictResolver which always keeps the local key
+func LocalPreferred(local LocalKey, remote Key) ConflictResolution {
+	return PreferLocal
+}
+
+const (
+	// conflictBackoffBase is the delay before a local key is re-written
+	// again after it has been re-written due to a conflict
+	conflictBackoffBase = time.Second
+
+	// conflictBackoffMax is the maximum delay between two re-writes of a
+	// local key due to conflicts
+	conflictBackoffMax = time.Minute
+
+	// conflictLoopThreshold is the number of consecutive re-writes of a
+	// local key after which a write loop with another writer is reported
+	conflictLoopThreshold = 5
+
+	// maxTrackedWrites is the number of values written for each local key
+	// which are recognized as echoes of own writes
+	maxTrackedWrites = 4
+)
+
+// keyConflicts is the conflict state of a single local key
+type keyConflicts struct {
+	// written are the last values written for the local key, most
+	// recent last
+	written [][]byte
+
+	// revision is the highest kvstore revision at which the current
+	// value of the local key has been observed
+	revision uint64
+
+	// observed is true once the last written value has been received
+	// from the kvstore. Until then, updates to previously written values
+	// may be echoes of own writes.
+	observed bool
+
+	// rewrites is the number of consecutive re-writes due to conflicts
+	rewrites int
+
+	// lastRewrite is the time of the last re-write due to a conflict
+	lastRewrite time.Time
+
+	// timer is the timer of the scheduled re-write, if any
+	timer *time.Timer
+}
+
+// conflictState is the state of the conflict resolution of a store. The zero
+// value is ready to use.
+type conflictState struct {
+	// mutex protects keys
+	mutex lock.Mutex
+
+	// keys is the conflict state of local keys by name
+	keys map[string]*keyConflicts
+}
+
+// get returns the conflict state of the local key with the given name. The
+// mutex must be held.
+func (c *conflictState) get(name string) *keyConflicts {
+	if c.keys == nil {
+		c.keys = map[string]*keyConflicts{}
+	}
+	k, ok := c.keys[name]
+	if !ok {
+		k = &keyConflicts{}
+		c.keys[name] = k
+	}
+	return k
+}
+
+// recordWrite records value as written for the local key with the given name
+// so that its echo received from the kvstore is not mistaken for a conflict
+func (c *conflictState) recordWrite(name string, value []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	k := c.get(name)
+	if n := len(k.written); n > 0 && bytes.Equal(k.written[n-1], value) {
+		return
+	}
+	k.observed = false
+	for i, v := range k.written {
+		if bytes.Equal(v, value) {
+			k.written = append(k.written[:i], k.written[i+1:]...)
+			break
+		}
+	}
+	if len(k.written) == maxTrackedWrites {
+		k.written = k.written[1:]
+	}
+	k.written = append(k.written, value)
+}
+
+// forget removes the conflict state of the local key with the given name
+func (c *conflictState) forget(name string) {
+	c.mutex.Lock()
+	if k, ok := c.keys[name]; ok {
+		if k.timer != nil {
+			k.timer.Stop()
+		}
+		delete(c.keys, name)
+	}
+	c.mutex.Unlock()
+}
+
+// stop cancels all scheduled re-writes
+func (c *conflictState) stop() {
+	c.mutex.Lock()
+	for _, k := range c.keys {
+		if k.timer != nil {
+			k.timer.Stop()
+			k.timer = nil
+		}
+	}
+	c.mutex.Unlock()
+}
+
+// isOwnWrite returns true if value may be the echo of a value recently
+// written for the local key. The mutex must be held.
+func (k *keyConflicts) isOwnWrite(value []byte) bool {
+	if k.observed {
+		return false
+	}
+	for _, v := range k.written {
+		if bytes.Equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the next re-write after rewrites
+// consecutive re-writes
+func backoff(rewrites int) time.Duration {
+	delay := conflictBackoffBase
+	for i := 1; i < rewrites && delay < conflictBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > conflictBackoffMax {
+		delay = conflictBackoffMax
+	}
+	return delay
+}
+
+// resolveConflict checks whether the update of the key with the given name
+// to value at the kvstore revision revision, 0 if unknown, conflicts with a
+// local key and, if so, resolves the conflict with the configured
+// ConflictResolver. Echoes of own writes and updates older than the last
+// observed write of the local key are no conflict and are discarded. If the
+// local key is preferred, it is re-written to the kvstore in the background,
+// with an exponential backoff between consecutive re-writes so that two
+// writers preferring their own value do not overwrite each other in a tight
+// loop.
+func (s *SharedStore) resolveConflict(name string, value []byte, remote Key, revision uint64) ConflictResolution {
+	if s.conf.ConflictResolver == nil {
+		return PreferRemote
+	}
+
+	localKey := s.lookupLocalKey(name)
+	if localKey == nil {
+		return PreferRemote
+	}
+
+	localValue, err := localKey.Marshal()
+	if err != nil {
+		return PreferRemote
+	}
+
+	s.conflicts.mutex.Lock()
+	defer s.conflicts.mutex.Unlock()
+	k := s.conflicts.get(name)
+
+	if bytes.Equal(localValue, value) {
+		if revision > k.revision {
+			k.revision = revision
+		}
+		if n := len(k.written); n > 0 && bytes.Equal(k.written[n-1], value) {
+			k.observed = true
+		}
+		return PreferRemote
+	}
+
+	scopedLog := s.getLogger().WithField("key", name)
+
+	// Updates older than the last observed write of the local key and
+	// echoes of previous writes are superseded by the local key already
+	if (revision != 0 && revision <= k.revision) || k.isOwnWrite(value) {
+		scopedLog.Debug("Ignoring outdated update of local key received from kvstore")
+		return PreferLocal
+	}
+
+	resolution := s.conf.ConflictResolver(localKey, remote)
+	if resolution != PreferLocal {
+		return resolution
+	}
+
+	if k.timer != nil {
+		// A re-write is already scheduled
+		return PreferLocal
+	}
+
+	// The backoff is reset once the key has not been in conflict for
+	// longer than the maximum backoff
+	if time.Since(k.lastRewrite) > 2*conflictBackoffMax {
+		k.rewrites = 0
+	}
+
+	delay := time.Duration(0)
+	if k.rewrites > 0 {
+		delay = backoff(k.rewrites) - time.Since(k.lastRewrite)
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	k.rewrites++
+	if k.rewrites == conflictLoopThreshold {
+		scopedLog.Warning("Local key is repeatedly overwritten by another writer in the kvstore")
+	}
+
+	scopedLog.WithField("delay", delay).Info("Update received from kvstore conflicts with local key. Re-writing local key")
+	k.timer = time.AfterFunc(delay, func() { s.rewriteLocalKey(name) })
+
+	return PreferLocal
+}
+
+// rewriteLocalKey re-writes the local key with the given name to the kvstore
+// after a conflict
+func (s *SharedStore) rewriteLocalKey(name string) {
+	s.conflicts.mutex.Lock()
+	if k, ok := s.conflicts.keys[name]; ok {
+		k.timer = nil
+		k.lastRewrite = time.Now()
+	}
+	s.conflicts.mutex.Unlock()
+
+	localKey := s.lookupLocalKey(name)
+	if localKey == nil {
+		return
+	}
+
+	if err := s.syncLocalKey(localKey); err != nil {
+		s.getLogger().WithError(err).WithField("key", name).Warning("Unable to re-write local key to kvstore")
+	}
+}
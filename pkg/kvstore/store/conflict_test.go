@@ -0,0 +1,218 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/testutils"
+
+	. "gopkg.in/check.v1"
+)
+
+type ConflictSuite struct{}
+
+var _ = Suite(&ConflictSuite{})
+
+// versionedKey is a key with a value which can change for the same name
+type versionedKey struct {
+	Name  string
+	Value string
+}
+
+func (v *versionedKey) GetKeyName() string          { return v.Name }
+func (v *versionedKey) DeepKeyCopy() LocalKey       { return &versionedKey{Name: v.Name, Value: v.Value} }
+func (v *versionedKey) Marshal() ([]byte, error)    { return json.Marshal(v) }
+func (v *versionedKey) Unmarshal(data []byte) error { return json.Unmarshal(data, v) }
+
+// writeRecorder is a backend recording all keys written
+type writeRecorder struct {
+	kvstore.BackendOperations
+	mutex   lock.Mutex
+	written map[string]string
+	writes  int
+}
+
+func (w *writeRecorder) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	w.mutex.Lock()
+	w.written[key] = string(value)
+	w.writes++
+	w.mutex.Unlock()
+	return true, nil
+}
+
+// getWritten returns a copy of the keys written
+func (w *writeRecorder) getWritten() map[string]string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	written := make(map[string]string, len(w.written))
+	for k, v := range w.written {
+		written[k] = v
+	}
+	return written
+}
+
+// getWrites returns the number of writes
+func (w *writeRecorder) getWrites() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writes
+}
+
+func newConflictTestStore(resolver ConflictResolver) (*SharedStore, *writeRecorder) {
+	backend := &writeRecorder{written: map[string]string{}}
+	s := &SharedStore{
+		conf: Configuration{
+			Prefix:           "conflict",
+			KeyCreator:       func() Key { return &versionedKey{} },
+			ConflictResolver: resolver,
+		},
+		backend:        backend,
+		localKeys:      map[string]LocalKey{},
+		sharedKeys:     map[string]Key{},
+		sharedKeysInfo: map[string]KeyInfo{},
+	}
+	s.localKeys["foo"] = &versionedKey{Name: "foo", Value: "local"}
+	return s, backend
+}
+
+func (s *ConflictSuite) TestConflictResolution(c *C) {
+	remote, _ := json.Marshal(&versionedKey{Name: "foo", Value: "remote"})
+	local, _ := json.Marshal(&versionedKey{Name: "foo", Value: "local"})
+
+	// Without resolver, the update is always applied
+	store, backend := newConflictTestStore(nil)
+	c.Assert(store.updateKey("foo", remote, 0), IsNil)
+	c.Assert(store.sharedKeys["foo"].(*versionedKey).Value, Equals, "remote")
+	c.Assert(backend.written, HasLen, 0)
+
+	store, backend = newConflictTestStore(LastWriterWins)
+	c.Assert(store.updateKey("foo", remote, 0), IsNil)
+	c.Assert(store.sharedKeys["foo"].(*versionedKey).Value, Equals, "remote")
+	c.Assert(backend.written, HasLen, 0)
+
+	// The local key is preferred and re-written to the kvstore in the
+	// background
+	store, backend = newConflictTestStore(LocalPreferred)
+	c.Assert(store.updateKey("foo", remote, 0), IsNil)
+	c.Assert(store.sharedKeys["foo"], IsNil)
+	c.Assert(testutils.WaitUntil(func() bool {
+		return backend.getWrites() == 1
+	}, 5*time.Second), IsNil)
+	c.Assert(backend.getWritten(), DeepEquals, map[string]string{"conflict/foo": string(local)})
+	store.Release()
+
+	// Updates matching the local key are no conflict
+	store, backend = newConflictTestStore(LocalPreferred)
+	c.Assert(store.updateKey("foo", local, 0), IsNil)
+	c.Assert(store.sharedKeys["foo"].(*versionedKey).Value, Equals, "local")
+	c.Assert(backend.written, HasLen, 0)
+
+	// Keys not owned locally are no conflict
+	other, _ := json.Marshal(&versionedKey{Name: "bar", Value: "remote"})
+	c.Assert(store.updateKey("bar", other, 0), IsNil)
+	c.Assert(store.sharedKeys["bar"].(*versionedKey).Value, Equals, "remote")
+}
+
+func (s *ConflictSuite) TestConflictEchoesAndOutdatedUpdates(c *C) {
+	old, _ := json.Marshal(&versionedKey{Name: "foo", Value: "old"})
+	local, _ := json.Marshal(&versionedKey{Name: "foo", Value: "local"})
+
+	resolved := 0
+	store, backend := newConflictTestStore(func(local LocalKey, remote Key) ConflictResolution {
+		resolved++
+		return PreferLocal
+	})
+	defer store.Release()
+
+	// The echo of a previous write received after the local key changed
+	// is no conflict
+	store.localKeys["foo"] = &versionedKey{Name: "foo", Value: "old"}
+	c.Assert(store.syncLocalKey(store.localKeys["foo"]), IsNil)
+	store.localKeys["foo"] = &versionedKey{Name: "foo", Value: "local"}
+	c.Assert(store.syncLocalKey(store.localKeys["foo"]), IsNil)
+	c.Assert(store.updateKey("foo", old, 10), IsNil)
+	c.Assert(resolved, Equals, 0)
+
+	// Updates older than the last observed write are no conflict either
+	c.Assert(store.updateKey("foo", local, 11), IsNil)
+	remote, _ := json.Marshal(&versionedKey{Name: "foo", Value: "remote"})
+	c.Assert(store.updateKey("foo", remote, 11), IsNil)
+	c.Assert(resolved, Equals, 0)
+
+	// Once the last write has been observed, a previously written value
+	// is written by another writer
+	c.Assert(store.updateKey("foo", old, 12), IsNil)
+	c.Assert(resolved, Equals, 1)
+	c.Assert(store.sharedKeys["foo"].(*versionedKey).Value, Equals, "local")
+	c.Assert(testutils.WaitUntil(func() bool {
+		return backend.getWrites() == 3
+	}, 5*time.Second), IsNil)
+}
+
+func (s *ConflictSuite) TestConflictBackoff(c *C) {
+	remote, _ := json.Marshal(&versionedKey{Name: "foo", Value: "remote"})
+
+	store, backend := newConflictTestStore(LocalPreferred)
+	defer store.Release()
+
+	c.Assert(store.updateKey("foo", remote, 10), IsNil)
+	c.Assert(testutils.WaitUntil(func() bool {
+		return backend.getWrites() == 1
+	}, 5*time.Second), IsNil)
+
+	// A conflict right after the re-write is re-written after a delay
+	c.Assert(store.updateKey("foo", remote, 20), IsNil)
+	store.conflicts.mutex.Lock()
+	k := store.conflicts.keys["foo"]
+	c.Assert(k.rewrites, Equals, 2)
+	c.Assert(k.timer, Not(IsNil))
+	store.conflicts.mutex.Unlock()
+
+	// Further conflicts do not schedule additional re-writes
+	c.Assert(store.updateKey("foo", remote, 30), IsNil)
+	store.conflicts.mutex.Lock()
+	c.Assert(k.rewrites, Equals, 2)
+	store.conflicts.mutex.Unlock()
+	c.Assert(backend.getWrites(), Equals, 1)
+
+	c.Assert(backoff(1), Equals, conflictBackoffBase)
+	c.Assert(backoff(3), Equals, 4*conflictBackoffBase)
+	c.Assert(backoff(100), Equals, conflictBackoffMax)
+}
+
+func (s *ConflictSuite) TestSharedKeysInfo(c *C) {
+	store, _ := newConflictTestStore(nil)
+	value, _ := json.Marshal(&versionedKey{Name: "bar", Value: "a"})
+
+	before := time.Now()
+	c.Assert(store.updateKey("bar", value, 10), IsNil)
+	info := store.SharedKeysInfo()
+	c.Assert(info["bar"].ModRevision, Equals, uint64(10))
+	c.Assert(info["bar"].LastUpdate.Before(before), Equals, false)
+
+	// Updates of unknown revision keep the last known revision
+	c.Assert(store.updateKey("bar", value, 0), IsNil)
+	c.Assert(store.SharedKeysInfo()["bar"].ModRevision, Equals, uint64(10))
+
+	store.deleteKey("bar")
+	c.Assert(store.SharedKeysInfo(), HasLen, 0)
+}
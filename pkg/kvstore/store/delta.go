@@ -0,0 +1,312 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/lock"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+const (
+	// journalDir is the directory below the prefix of the store holding
+	// the journal keys of the delta encoding. Key names must not start
+	// with it.
+	//
+	// WARNING - STABLE API: Changing the journal layout will break
+	// backwards compatibility
+	journalDir = ".journal"
+
+	// defaultSnapshotInterval is the default maximum age of a snapshot
+	// before a new snapshot is written in the delta encoding
+	defaultSnapshotInterval = 10 * time.Minute
+)
+
+// journalEntry is the value of a journal key. It holds the JSON merge patch
+// (RFC 7386) transforming the snapshot identified by Base into the current
+// value of the key. The patch is cumulative, readers only require the
+// snapshot and the latest journal entry to reconstruct the value.
+type journalEntry struct {
+	// Base is the hash of the snapshot the patch applies to
+	Base string `json:"base"`
+
+	// Patch is the merge patch to apply to the snapshot
+	Patch json.RawMessage `json:"patch"`
+}
+
+// publishedSnapshot is the last snapshot written for a local key
+type publishedSnapshot struct {
+	value   []byte
+	hash    string
+	written time.Time
+}
+
+// receivedSnapshot is the last snapshot received for a shared key
+type receivedSnapshot struct {
+	value []byte
+	hash  string
+}
+
+// deltaState is the state of the delta encoding of a store. The zero value
+// is ready to use.
+type deltaState struct {
+	// mutex protects all fields below
+	mutex lock.Mutex
+
+	// published is the last snapshot written for each local key
+	published map[string]publishedSnapshot
+
+	// snapshots is the last snapshot received for each shared key
+	snapshots map[string]receivedSnapshot
+
+	// journals is the last journal entry received for each shared key
+	journals map[string]journalEntry
+}
+
+// snapshotHash returns the hash identifying a snapshot
+func snapshotHash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:8])
+}
+
+// journalKeyName returns the name of the key a journal key of the given name
+// refers to and true, or false if name is not a journal key
+func journalKeyName(name string) (string, bool) {
+	if !strings.HasPrefix(name, journalDir+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, journalDir+"/"), true
+}
+
+// journalPath returns the absolute kvstore path of the journal of a key
+func (s *SharedStore) journalPath(name string) string {
+	return path.Join(s.conf.Prefix, journalDir, name)
+}
+
+// syncDelta writes the marshaled value of the local key with the given name
+// to the kvstore in the delta encoding. Only the journal key is updated as
+// long as the last snapshot is younger than the snapshot interval and the
+// patch against it is considerably smaller than the value itself. A new
+// snapshot is written otherwise and the journal is removed.
+func (s *SharedStore) syncDelta(name string, value []byte) error {
+	s.delta.mutex.Lock()
+	defer s.delta.mutex.Unlock()
+
+	if snapshot, ok := s.delta.published[name]; ok && time.Since(snapshot.written) < s.conf.SnapshotInterval {
+		patch, err := jsonpatch.CreateMergePatch(snapshot.value, value)
+		if err == nil && 2*len(patch) < len(value) {
+			return s.writeJournal(name, journalEntry{Base: snapshot.hash, Patch: patch})
+		}
+	}
+
+	encoded, err := s.encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.backend.UpdateIfDifferent(context.TODO(), path.Join(s.conf.Prefix, name), encoded, true); err != nil {
+		return err
+	}
+
+	if s.delta.published == nil {
+		s.delta.published = map[string]publishedSnapshot{}
+	}
+	s.delta.published[name] = publishedSnapshot{
+		value:   value,
+		hash:    snapshotHash(value),
+		written: time.Now(),
+	}
+
+	if err := s.backend.Delete(s.journalPath(name)); err != nil {
+		s.getLogger().WithError(err).WithField("key", name).Warning("Unable to delete journal in kvstore")
+	}
+
+	return nil
+}
+
+// writeJournal writes the journal entry of the local key with the given name
+func (s *SharedStore) writeJournal(name string, entry journalEntry) error {
+	value, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+
+	value, err = s.encodeValue(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.backend.UpdateIfDifferent(context.TODO(), s.journalPath(name), value, true)
+	return err
+}
+
+// deleteDelta removes the journal of the local key with the given name from
+// the kvstore
+func (s *SharedStore) deleteDelta(name string) {
+	s.delta.mutex.Lock()
+	delete(s.delta.published, name)
+	s.delta.mutex.Unlock()
+
+	if err := s.backend.Delete(s.journalPath(name)); err != nil {
+		s.getLogger().WithError(err).WithField("key", name).Warning("Unable to delete journal in kvstore")
+	}
+}
+
+// reconstruct returns the value of the shared key with the given name by
+// applying the journal to the snapshot. Returns false if there is no
+// snapshot or no journal based on the snapshot. Must be called with
+// mutex held.
+func (d *deltaState) reconstruct(name string) ([]byte, bool, error) {
+	snapshot, ok := d.snapshots[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry, ok := d.journals[name]
+	if !ok || entry.Base != snapshot.hash {
+		return snapshot.value, false, nil
+	}
+
+	value, err := jsonpatch.MergePatch(snapshot.value, entry.Patch)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// onSnapshot records the snapshot of a shared key received from the kvstore
+// and returns the current value of the key
+func (d *deltaState) onSnapshot(name string, value []byte) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.snapshots == nil {
+		d.snapshots = map[string]receivedSnapshot{}
+	}
+	d.snapshots[name] = receivedSnapshot{value: value, hash: snapshotHash(value)}
+
+	value, _, err := d.reconstruct(name)
+	return value, err
+}
+
+// onJournal records the journal entry of a shared key received from the
+// kvstore and returns the current value of the key. Returns nil if the
+// journal is not based on the snapshot received last, the snapshot will
+// follow.
+func (d *deltaState) onJournal(name string, data []byte) ([]byte, error) {
+	entry := journalEntry{}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.journals == nil {
+		d.journals = map[string]journalEntry{}
+	}
+	d.journals[name] = entry
+
+	value, applied, err := d.reconstruct(name)
+	if !applied {
+		return nil, err
+	}
+	return value, nil
+}
+
+// forgetJournal removes the journal entry of a shared key. The snapshot
+// written before the journal is removed carries the full value.
+func (d *deltaState) forgetJournal(name string) {
+	d.mutex.Lock()
+	delete(d.journals, name)
+	d.mutex.Unlock()
+}
+
+// forget removes all state of a deleted shared key
+func (d *deltaState) forget(name string) {
+	d.mutex.Lock()
+	delete(d.snapshots, name)
+	delete(d.journals, name)
+	d.mutex.Unlock()
+}
+
+// listedValue is the value of a key of a listing of the prefix of the store
+type listedValue struct {
+	// value is the value of the key, nil if it cannot be decoded
+	value []byte
+
+	// revision is the kvstore revision at which the key, or its journal
+	// if applied, was last modified
+	revision uint64
+}
+
+// reconstructListing returns the values of all keys of a listing of the
+// prefix of the store indexed by name, with journals applied to their
+// snapshots. Keys whose value cannot be decoded map to a nil value.
+func (s *SharedStore) reconstructListing(pairs kvstore.KeyValuePairs) map[string]listedValue {
+	listing := deltaState{
+		snapshots: map[string]receivedSnapshot{},
+		journals:  map[string]journalEntry{},
+	}
+	values := make(map[string]listedValue, len(pairs))
+	revisions := make(map[string]uint64, len(pairs))
+	journalRevisions := map[string]uint64{}
+
+	for path, value := range pairs {
+		name := s.keyName(path)
+		keyName, isJournal := journalKeyName(name)
+
+		data, err := decodeValue(value.Data)
+		if err != nil {
+			if !isJournal {
+				values[name] = listedValue{revision: value.ModRevision}
+			}
+			continue
+		}
+
+		if isJournal {
+			entry := journalEntry{}
+			if err := json.Unmarshal(data, &entry); err == nil {
+				listing.journals[keyName] = entry
+				journalRevisions[keyName] = value.ModRevision
+			}
+			continue
+		}
+		listing.snapshots[name] = receivedSnapshot{value: data, hash: snapshotHash(data)}
+		revisions[name] = value.ModRevision
+	}
+
+	for name := range listing.snapshots {
+		listed := listedValue{revision: revisions[name]}
+		value, journaled, err := listing.reconstruct(name)
+		if err == nil {
+			listed.value = value
+		}
+		if journaled && journalRevisions[name] > listed.revision {
+			listed.revision = journalRevisions[name]
+		}
+		values[name] = listed
+	}
+	return values
+}
@@ -0,0 +1,110 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	. "gopkg.in/check.v1"
+)
+
+type DeltaSuite struct{}
+
+var _ = Suite(&DeltaSuite{})
+
+// deleteRecorder is a backend recording all keys written and deleted
+type deleteRecorder struct {
+	writeRecorder
+}
+
+func (d *deleteRecorder) Delete(key string) error {
+	delete(d.written, key)
+	return nil
+}
+
+func (s *DeltaSuite) TestDeltaEncoding(c *C) {
+	backend := &deleteRecorder{writeRecorder{written: map[string]string{}}}
+	writer := &SharedStore{
+		conf: Configuration{
+			Prefix:           "delta",
+			KeyCreator:       func() Key { return &versionedKey{} },
+			Delta:            true,
+			SnapshotInterval: time.Hour,
+		},
+		backend:        backend,
+		localKeys:      map[string]LocalKey{},
+		sharedKeys:     map[string]Key{},
+		sharedKeysInfo: map[string]KeyInfo{},
+	}
+
+	// Use a long name so the value is considerably larger than a patch
+	// of the value field
+	name := strings.Repeat("n", 64)
+	key := &versionedKey{Name: name, Value: "a"}
+
+	// The first synchronization writes a snapshot
+	c.Assert(writer.syncLocalKey(key), IsNil)
+	c.Assert(backend.written, HasLen, 1)
+	snapshot := backend.written["delta/"+name]
+	c.Assert(snapshot, Not(Equals), "")
+
+	// Changes are written to the journal only
+	key.Value = "b"
+	c.Assert(writer.syncLocalKey(key), IsNil)
+	c.Assert(backend.written, HasLen, 2)
+	c.Assert(backend.written["delta/"+name], Equals, snapshot)
+	journal := backend.written["delta/.journal/"+name]
+	c.Assert(journal, Matches, `.*"patch":\{"Value":"b"\}.*`)
+
+	// Readers reconstruct the value from the snapshot and the journal,
+	// in any order
+	reader := deltaState{}
+	value, err := reader.onJournal(name, []byte(journal))
+	c.Assert(err, IsNil)
+	c.Assert(value, IsNil)
+	value, err = reader.onSnapshot(name, []byte(snapshot))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, `{"Name":"`+name+`","Value":"b"}`)
+
+	pairs := kvstore.KeyValuePairs{}
+	for k, v := range backend.written {
+		pairs[k] = kvstore.Value{Data: []byte(v)}
+	}
+	values := writer.reconstructListing(pairs)
+	c.Assert(values, HasLen, 1)
+	c.Assert(string(values[name].value), Equals, string(value))
+
+	// A new snapshot replaces the journal once the snapshot expired
+	writer.conf.SnapshotInterval = time.Nanosecond
+	key.Value = "c"
+	c.Assert(writer.syncLocalKey(key), IsNil)
+	c.Assert(backend.written, HasLen, 1)
+	snapshot = backend.written["delta/"+name]
+	c.Assert(snapshot, Equals, `{"Name":"`+name+`","Value":"c"}`)
+
+	// A journal based on the previous snapshot is no longer applied
+	value, err = reader.onSnapshot(name, []byte(snapshot))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, snapshot)
+
+	// Deleting the key removes its journal
+	writer.deleteDelta(name)
+	c.Assert(writer.delta.published, HasLen, 0)
+}
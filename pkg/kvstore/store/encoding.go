@@ -0,0 +1,42 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/cilium/cilium/pkg/kvstore"
+)
+
+// compressionThreshold is the minimum size in bytes of a value for it to be
+// compressed
+const compressionThreshold = 512
+
+// encodeValue returns the representation of the marshaled key value to be
+// written to the kvstore. If the envelope encoding is enabled, the value is
+// wrapped in the kvstore value envelope and compressed if it is large enough
+// to benefit from compression.
+func (s *SharedStore) encodeValue(value []byte) ([]byte, error) {
+	if !s.conf.Envelope {
+		return value, nil
+	}
+
+	return kvstore.EncodeValue(value, s.conf.Compression, compressionThreshold)
+}
+
+// decodeValue returns the marshaled key value of a value read from the
+// kvstore. Plain values are returned as-is, enveloped values are unwrapped
+// and decompressed regardless of the encoding configured for the store.
+func decodeValue(value []byte) ([]byte, error) {
+	return kvstore.DecodeValue(value)
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"bytes"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	. "gopkg.in/check.v1"
+)
+
+type EncodingSuite struct{}
+
+var _ = Suite(&EncodingSuite{})
+
+func (s *EncodingSuite) TestValueEncoding(c *C) {
+	small := []byte(`{"Name":"foo"}`)
+	large := bytes.Repeat([]byte(`{"Name":"foo"}`), 100)
+
+	// Without envelope, values are written as-is
+	store := &SharedStore{}
+	encoded, err := store.encodeValue(large)
+	c.Assert(err, IsNil)
+	c.Assert(encoded, DeepEquals, large)
+
+	for _, compression := range []kvstore.Compression{kvstore.CompressionNone, kvstore.CompressionGzip, kvstore.CompressionSnappy} {
+		store := &SharedStore{conf: Configuration{Envelope: true, Compression: compression}}
+
+		// Small values are never compressed
+		encoded, err := store.encodeValue(small)
+		c.Assert(err, IsNil)
+		c.Assert(encoded[:kvstore.EnvelopeHeaderLen], DeepEquals, []byte{kvstore.EnvelopeMarker, kvstore.EnvelopeVersion, byte(kvstore.CompressionNone)})
+
+		decoded, err := decodeValue(encoded)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, DeepEquals, small)
+
+		encoded, err = store.encodeValue(large)
+		c.Assert(err, IsNil)
+		c.Assert(kvstore.Compression(encoded[2]), Equals, compression)
+		if compression != kvstore.CompressionNone {
+			c.Assert(len(encoded) < len(large), Equals, true)
+		}
+
+		decoded, err = decodeValue(encoded)
+		c.Assert(err, IsNil)
+		c.Assert(decoded, DeepEquals, large)
+	}
+
+	// Plain values written by stores without envelope are read as-is
+	decoded, err := decodeValue(small)
+	c.Assert(err, IsNil)
+	c.Assert(decoded, DeepEquals, small)
+}
+
+func (s *EncodingSuite) TestCompressionRequiresEnvelope(c *C) {
+	conf := Configuration{
+		Prefix:      "encoding",
+		KeyCreator:  func() Key { return &versionedKey{} },
+		Backend:     &writeRecorder{},
+		Compression: kvstore.CompressionGzip,
+	}
+	c.Assert(conf.validate(), Not(IsNil))
+
+	conf.Envelope = true
+	c.Assert(conf.validate(), IsNil)
+
+	conf.Compression = kvstore.Compression(42)
+	c.Assert(conf.validate(), Not(IsNil))
+}
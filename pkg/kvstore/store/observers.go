@@ -0,0 +1,136 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+// defaultObserverBufferSize is the default size of the event buffer of
+// observers added with AddObserver
+const defaultObserverBufferSize = 1024
+
+// observerEvent is an event buffered for an observer added with AddObserver.
+// Exactly one of update, deleted and sync is set.
+type observerEvent struct {
+	update  Key
+	deleted NamedKey
+	sync    bool
+}
+
+// bufferedObserver delivers the events buffered for an observer added with
+// AddObserver from a dedicated goroutine so that a slow observer does not
+// delay the delivery to the observer of the store configuration until its
+// buffer is full
+type bufferedObserver struct {
+	observer Observer
+	events   chan observerEvent
+	done     chan struct{}
+}
+
+func newBufferedObserver(o Observer, size int) *bufferedObserver {
+	b := &bufferedObserver{
+		observer: o,
+		events:   make(chan observerEvent, size),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *bufferedObserver) run() {
+	defer close(b.done)
+
+	for ev := range b.events {
+		switch {
+		case ev.update != nil:
+			b.observer.OnUpdate(ev.update)
+		case ev.deleted != nil:
+			b.observer.OnDelete(ev.deleted)
+		case ev.sync:
+			if o, ok := b.observer.(SyncObserver); ok {
+				o.OnSync()
+			}
+		}
+	}
+}
+
+// stop stops the delivery once all buffered events have been delivered
+func (b *bufferedObserver) stop() {
+	close(b.events)
+	<-b.done
+}
+
+// AddObserver registers o to receive the events of the store in addition to
+// the observer of the store configuration, e.g. for a subsystem started after
+// the store has been joined. All keys currently shared are replayed to o as
+// OnUpdate events, followed by OnSync if o is a SyncObserver and the initial
+// list of keys has already been received. The replay and subsequent events
+// are delivered in order without gaps. Events are buffered for up to
+// Configuration.ObserverBufferSize events, further events wait for o to catch
+// up. AddObserver must not be called from within an observer of the store.
+func (s *SharedStore) AddObserver(o Observer) {
+	// Changes of the shared keys are blocked until o has been added so
+	// that no event is missed or replayed twice
+	s.observersMutex.Lock()
+	defer s.observersMutex.Unlock()
+
+	if _, ok := s.observers[o]; ok {
+		return
+	}
+
+	b := newBufferedObserver(o, s.conf.ObserverBufferSize)
+	for _, key := range s.getSharedKeys() {
+		b.events <- observerEvent{update: key}
+	}
+	if s.synced {
+		b.events <- observerEvent{sync: true}
+	}
+
+	if s.observers == nil {
+		s.observers = map[Observer]*bufferedObserver{}
+	}
+	s.observers[o] = b
+}
+
+// RemoveObserver unregisters an observer added with AddObserver. Events
+// already buffered for the observer are delivered before RemoveObserver
+// returns.
+func (s *SharedStore) RemoveObserver(o Observer) {
+	s.observersMutex.Lock()
+	b, ok := s.observers[o]
+	delete(s.observers, o)
+	s.observersMutex.Unlock()
+
+	if ok {
+		b.stop()
+	}
+}
+
+// removeAllObservers unregisters all observers added with AddObserver
+func (s *SharedStore) removeAllObservers() {
+	s.observersMutex.Lock()
+	observers := s.observers
+	s.observers = nil
+	s.observersMutex.Unlock()
+
+	for _, b := range observers {
+		b.stop()
+	}
+}
+
+// notifyObservers buffers ev for all observers added with AddObserver. Must
+// be called with s.observersMutex held.
+func (s *SharedStore) notifyObservers(ev observerEvent) {
+	for _, b := range s.observers {
+		b.events <- ev
+	}
+}
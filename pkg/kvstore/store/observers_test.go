@@ -0,0 +1,98 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/cilium/cilium/pkg/lock"
+
+	. "gopkg.in/check.v1"
+)
+
+type ObserversSuite struct{}
+
+var _ = Suite(&ObserversSuite{})
+
+// eventObserver records the events received as "update:<name>",
+// "delete:<name>" and "sync"
+type eventObserver struct {
+	mutex  lock.Mutex
+	events []string
+}
+
+func (r *eventObserver) OnUpdate(k Key) {
+	r.mutex.Lock()
+	r.events = append(r.events, "update:"+k.GetKeyName())
+	r.mutex.Unlock()
+}
+
+func (r *eventObserver) OnDelete(k NamedKey) {
+	r.mutex.Lock()
+	r.events = append(r.events, "delete:"+k.GetKeyName())
+	r.mutex.Unlock()
+}
+
+func (r *eventObserver) OnSync() {
+	r.mutex.Lock()
+	r.events = append(r.events, "sync")
+	r.mutex.Unlock()
+}
+
+func (r *eventObserver) getEvents() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (s *ObserversSuite) TestAddObserver(c *C) {
+	store, _ := newConflictTestStore(nil)
+	store.conf.ObserverBufferSize = 1
+
+	value, _ := json.Marshal(&versionedKey{Name: "foo", Value: "v1"})
+	c.Assert(store.updateKey("foo", value, 0), IsNil)
+	store.onSync()
+
+	// The current keys are replayed to a late observer, followed by the
+	// live events
+	late := &eventObserver{}
+	store.AddObserver(late)
+	value, _ = json.Marshal(&versionedKey{Name: "bar", Value: "v1"})
+	c.Assert(store.updateKey("bar", value, 0), IsNil)
+	store.deleteKey("foo")
+
+	// Removing the observer delivers all buffered events
+	store.RemoveObserver(late)
+	c.Assert(late.getEvents(), DeepEquals, []string{"update:foo", "sync", "update:bar", "delete:foo"})
+
+	c.Assert(store.updateKey("foo", value, 0), IsNil)
+	c.Assert(late.getEvents(), HasLen, 4)
+}
+
+func (s *ObserversSuite) TestAddObserverBeforeSync(c *C) {
+	store, _ := newConflictTestStore(nil)
+	store.conf.ObserverBufferSize = 10
+
+	early := &eventObserver{}
+	store.AddObserver(early)
+	value, _ := json.Marshal(&versionedKey{Name: "foo", Value: "v1"})
+	c.Assert(store.updateKey("foo", value, 0), IsNil)
+	store.onSync()
+
+	store.removeAllObservers()
+	c.Assert(early.getEvents(), DeepEquals, []string{"update:foo", "sync"})
+}
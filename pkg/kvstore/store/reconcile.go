@@ -0,0 +1,172 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/metrics"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// reconcileActionUpdate is the metric label of entries repaired by a
+	// synthetic update
+	reconcileActionUpdate = "update"
+
+	// reconcileActionDelete is the metric label of entries repaired by a
+	// synthetic delete
+	reconcileActionDelete = "delete"
+)
+
+// keyName returns the name of the key stored at the given kvstore path
+func (s *SharedStore) keyName(key string) string {
+	name := strings.TrimPrefix(key, s.conf.Prefix)
+	if len(name) > 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	return name
+}
+
+// marshalShared returns the marshaled value of the shared key with the given
+// name or nil if the key is unknown
+func (s *SharedStore) marshalShared(name string) []byte {
+	s.mutex.RLock()
+	key, ok := s.sharedKeys[name]
+	s.mutex.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	value, err := key.Marshal()
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// divergedKeys lists the contents of the kvstore and returns the names of
+// all keys whose state differs from sharedKeys. Keys present in the kvstore
+// are mapped to their listed value, keys only present in sharedKeys map to a
+// nil value. Keys listed at a revision not newer than the last update
+// received by the watcher are not considered diverged as the listing is
+// outdated.
+func (s *SharedStore) divergedKeys() (map[string]listedValue, error) {
+	pairs, err := s.backend.ListPrefix(s.conf.Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values := s.reconstructListing(pairs)
+
+	diverged := map[string]listedValue{}
+	for name, listed := range values {
+		if listed.value == nil {
+			continue
+		}
+
+		s.mutex.RLock()
+		known := s.sharedKeysInfo[name].ModRevision
+		s.mutex.RUnlock()
+		if listed.revision != 0 && listed.revision <= known {
+			continue
+		}
+
+		// Compare the normalized representation of both sides to not
+		// depend on the byte-wise encoding of other collaborators
+		key := s.conf.KeyCreator()
+		if err := key.Unmarshal(listed.value); err != nil {
+			continue
+		}
+		remote, err := key.Marshal()
+		if err != nil {
+			continue
+		}
+
+		if local := s.marshalShared(name); local == nil || !bytes.Equal(local, remote) {
+			diverged[name] = listed
+		}
+	}
+
+	s.mutex.RLock()
+	for name := range s.sharedKeys {
+		if _, ok := values[name]; !ok {
+			diverged[name] = listedValue{revision: s.sharedKeysInfo[name].ModRevision}
+		}
+	}
+	s.mutex.RUnlock()
+
+	return diverged, nil
+}
+
+// reconcile compares the contents of the kvstore with sharedKeys and repairs
+// entries missed by the watcher by emitting synthetic updates and deletes.
+// As events for the listed keys may still be in flight in the watcher, only
+// keys which have diverged at the same revision in two consecutive passes
+// are repaired. Keys present in the kvstore are updated only if the listed
+// revision is newer than the last update received by the watcher.
+func (s *SharedStore) reconcile() error {
+	diverged, err := s.divergedKeys()
+	if err != nil {
+		return err
+	}
+
+	suspects := s.reconcileSuspects
+	s.reconcileSuspects = make(map[string]uint64, len(diverged))
+
+	updated, deleted := 0, 0
+	for name, listed := range diverged {
+		if revision, ok := suspects[name]; !ok || revision != listed.revision {
+			s.reconcileSuspects[name] = listed.revision
+			continue
+		}
+
+		scopedLog := s.getLogger().WithField("key", name)
+		switch {
+		case listed.value != nil:
+			applied, err := s.updateKeyIfNewer(name, listed.value, listed.revision)
+			if err != nil {
+				scopedLog.WithError(err).Warning("Unable to unmarshal store value during reconciliation")
+				continue
+			}
+			if applied {
+				updated++
+			}
+
+		case s.lookupLocalKey(name) != nil:
+			scopedLog.Warning("Local key missing in kvstore. Re-creating the key in the kvstore")
+			s.syncLocalKey(s.lookupLocalKey(name))
+
+		default:
+			if s.deleteKeyIfRevision(name, listed.revision) {
+				deleted++
+			}
+		}
+	}
+
+	if updated > 0 || deleted > 0 {
+		metrics.KVStoreStoreReconciledEntries.WithLabelValues(s.conf.Prefix, reconcileActionUpdate).Add(float64(updated))
+		metrics.KVStoreStoreReconciledEntries.WithLabelValues(s.conf.Prefix, reconcileActionDelete).Add(float64(deleted))
+		s.getLogger().WithFields(logrus.Fields{
+			"updated": updated,
+			"deleted": deleted,
+		}).Info("Repaired shared store entries missed by the kvstore watcher")
+	}
+
+	return nil
+}
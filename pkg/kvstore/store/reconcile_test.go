@@ -0,0 +1,203 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+
+	. "gopkg.in/check.v1"
+)
+
+type ReconcileSuite struct{}
+
+var _ = Suite(&ReconcileSuite{})
+
+// listBackend is a backend returning a fixed set of keys on ListPrefix
+type listBackend struct {
+	writeRecorder
+	pairs kvstore.KeyValuePairs
+}
+
+func (l *listBackend) ListPrefix(prefix string) (kvstore.KeyValuePairs, error) {
+	return l.pairs, nil
+}
+
+// recordingObserver records the names of all keys updated and deleted
+type recordingObserver struct {
+	updated []string
+	deleted []string
+}
+
+func (r *recordingObserver) OnUpdate(k Key)      { r.updated = append(r.updated, k.GetKeyName()) }
+func (r *recordingObserver) OnDelete(k NamedKey) { r.deleted = append(r.deleted, k.GetKeyName()) }
+
+func (s *ReconcileSuite) TestReconcile(c *C) {
+	value := func(name, v string) kvstore.Value {
+		data, _ := json.Marshal(&versionedKey{Name: name, Value: v})
+		return kvstore.Value{Data: data}
+	}
+
+	backend := &listBackend{
+		writeRecorder: writeRecorder{written: map[string]string{}},
+		pairs: kvstore.KeyValuePairs{
+			"reconcile/insync":  value("insync", "a"),
+			"reconcile/missed":  value("missed", "a"),
+			"reconcile/changed": value("changed", "new"),
+		},
+	}
+	observer := &recordingObserver{}
+	store := &SharedStore{
+		conf: Configuration{
+			Prefix:     "reconcile",
+			KeyCreator: func() Key { return &versionedKey{} },
+			Observer:   observer,
+		},
+		backend:        backend,
+		localKeys:      map[string]LocalKey{},
+		sharedKeys:     map[string]Key{},
+		sharedKeysInfo: map[string]KeyInfo{},
+	}
+	store.sharedKeys["insync"] = &versionedKey{Name: "insync", Value: "a"}
+	store.sharedKeys["changed"] = &versionedKey{Name: "changed", Value: "old"}
+	store.sharedKeys["ghost"] = &versionedKey{Name: "ghost", Value: "a"}
+
+	// Diverged keys are only suspected in the first pass
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(observer.updated, HasLen, 0)
+	c.Assert(observer.deleted, HasLen, 0)
+
+	// The watcher caught up with the missed key in the meantime
+	store.sharedKeys["missed"] = &versionedKey{Name: "missed", Value: "a"}
+
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(observer.updated, DeepEquals, []string{"changed"})
+	c.Assert(observer.deleted, DeepEquals, []string{"ghost"})
+	c.Assert(store.sharedKeys["changed"].(*versionedKey).Value, Equals, "new")
+	c.Assert(store.sharedKeys["ghost"], IsNil)
+
+	// The store is in sync now
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(store.reconcileSuspects, HasLen, 0)
+	c.Assert(backend.written, HasLen, 0)
+}
+
+func (s *ReconcileSuite) TestReconcileRevisions(c *C) {
+	value := func(name, v string, revision uint64) kvstore.Value {
+		data, _ := json.Marshal(&versionedKey{Name: name, Value: v})
+		return kvstore.Value{Data: data, ModRevision: revision}
+	}
+
+	backend := &listBackend{
+		writeRecorder: writeRecorder{written: map[string]string{}},
+		pairs: kvstore.KeyValuePairs{
+			"reconcile/outdated": value("outdated", "old", 5),
+			"reconcile/changing": value("changing", "a", 10),
+		},
+	}
+	observer := &recordingObserver{}
+	store := &SharedStore{
+		conf: Configuration{
+			Prefix:     "reconcile",
+			KeyCreator: func() Key { return &versionedKey{} },
+			Observer:   observer,
+		},
+		backend:        backend,
+		localKeys:      map[string]LocalKey{},
+		sharedKeys:     map[string]Key{},
+		sharedKeysInfo: map[string]KeyInfo{},
+	}
+
+	// The watcher already received a newer revision than listed
+	c.Assert(store.updateKey("outdated", []byte(`{"Name":"outdated","Value":"new"}`), 7), IsNil)
+	observer.updated = nil
+
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(store.reconcileSuspects, DeepEquals, map[string]uint64{"changing": 10})
+
+	// The key changed in between both passes
+	backend.pairs["reconcile/changing"] = value("changing", "b", 11)
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(observer.updated, HasLen, 0)
+	c.Assert(store.reconcileSuspects, DeepEquals, map[string]uint64{"changing": 11})
+
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(observer.updated, DeepEquals, []string{"changing"})
+	c.Assert(store.sharedKeys["outdated"].(*versionedKey).Value, Equals, "new")
+	c.Assert(store.SharedKeysInfo()["changing"].ModRevision, Equals, uint64(11))
+
+	// Updates not newer than the last known revision are discarded
+	applied, err := store.updateKeyIfNewer("changing", []byte(`{"Name":"changing","Value":"c"}`), 11)
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, false)
+	c.Assert(store.sharedKeys["changing"].(*versionedKey).Value, Equals, "b")
+
+	// Keys updated after they went missing from the listing are kept
+	delete(backend.pairs, "reconcile/changing")
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(store.updateKey("changing", []byte(`{"Name":"changing","Value":"d"}`), 12), IsNil)
+	c.Assert(store.reconcile(), IsNil)
+	c.Assert(observer.deleted, HasLen, 0)
+	c.Assert(store.deleteKeyIfRevision("changing", 11), Equals, false)
+	c.Assert(store.sharedKeys["changing"], Not(IsNil))
+}
+
+// getBackend is a backend serving Get from the written keys
+type getBackend struct {
+	writeRecorder
+}
+
+func (g *getBackend) Get(key string) ([]byte, error) {
+	if value, ok := g.written[key]; ok {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+func (s *ReconcileSuite) TestEnsureLocalKey(c *C) {
+	backend := &getBackend{writeRecorder: writeRecorder{written: map[string]string{}}}
+	store := &SharedStore{
+		conf: Configuration{
+			Prefix:     "ensure",
+			KeyCreator: func() Key { return &versionedKey{} },
+		},
+		backend:        backend,
+		localKeys:      map[string]LocalKey{},
+		sharedKeys:     map[string]Key{},
+		sharedKeysInfo: map[string]KeyInfo{},
+	}
+
+	// Unknown keys are ignored
+	recreated, err := store.EnsureLocalKey("local")
+	c.Assert(err, IsNil)
+	c.Assert(recreated, Equals, false)
+
+	c.Assert(store.UpdateLocalKeySync(&versionedKey{Name: "local", Value: "a"}), IsNil)
+	c.Assert(backend.written, HasLen, 1)
+
+	recreated, err = store.EnsureLocalKey("local")
+	c.Assert(err, IsNil)
+	c.Assert(recreated, Equals, false)
+
+	// The key vanished from the kvstore
+	delete(backend.written, "ensure/local")
+	recreated, err = store.EnsureLocalKey("local")
+	c.Assert(err, IsNil)
+	c.Assert(recreated, Equals, true)
+	c.Assert(backend.written["ensure/local"], Not(Equals), "")
+}
@@ -18,7 +18,6 @@ import (
 	"context"
 	"fmt"
 	"path"
-	"strings"
 	"time"
 
 	"github.com/cilium/cilium/pkg/controller"
@@ -74,6 +73,57 @@ type Configuration struct {
 
 	// Observer is the observe that will receive events on key mutations
 	Observer Observer
+
+	// ReconciliationInterval is the interval in which the store is
+	// compared with the contents of the kvstore to repair entries missed
+	// by the watcher. If not specified,
+	// option.Config.KVstoreStoreReconcileInterval is used. A negative
+	// interval disables the reconciliation. This parameter is optional.
+	ReconciliationInterval time.Duration
+
+	// ConflictResolver is called when an update received from the
+	// kvstore would overwrite a local key with a different value. If
+	// not specified, the update is always applied. Echoes of own writes
+	// and updates older than the last write of the local key are never
+	// passed to the resolver. This parameter is optional.
+	ConflictResolver ConflictResolver
+
+	// Envelope enables the envelope encoding of values written to the
+	// kvstore. Enveloped values carry a version and are optionally
+	// compressed. Values of both encodings are read regardless of this
+	// parameter, but not by versions predating the envelope encoding, so
+	// it must only be enabled once all collaborators of the store have
+	// been upgraded. This parameter is optional.
+	Envelope bool
+
+	// Compression is the compression applied to large enveloped values.
+	// Requires Envelope. This parameter is optional.
+	Compression kvstore.Compression
+
+	// Delta enables the delta encoding of local keys. Changes of a key
+	// are published as a JSON merge patch against a periodic snapshot of
+	// the key, which reduces the watch traffic of large values with few
+	// changing fields. Marshaled keys must be JSON objects. Values of
+	// both encodings are read regardless of this parameter, but not by
+	// versions predating the delta encoding, so it must only be enabled
+	// once all collaborators of the store have been upgraded. This
+	// parameter is optional.
+	Delta bool
+
+	// SnapshotInterval is the maximum age of a snapshot in the delta
+	// encoding before a new snapshot is written. If not specified,
+	// defaultSnapshotInterval is used. This parameter is optional.
+	SnapshotInterval time.Duration
+
+	// Tenant is the tenant prefix injected into the prefix of the store,
+	// see kvstore.TenantPrefix(). If empty, the tenant configured via
+	// option.Config.KVStoreTenant is used. This parameter is optional.
+	Tenant string
+
+	// ObserverBufferSize is the number of events buffered for each
+	// observer added with AddObserver. If not specified,
+	// defaultObserverBufferSize is used. This parameter is optional.
+	ObserverBufferSize int
 }
 
 // validate is invoked by JoinSharedStore to validate and complete the
@@ -87,10 +137,37 @@ func (c *Configuration) validate() error {
 		return fmt.Errorf("KeyCreator must be specified")
 	}
 
+	switch c.Compression {
+	case kvstore.CompressionNone:
+	case kvstore.CompressionGzip, kvstore.CompressionSnappy:
+		if !c.Envelope {
+			return fmt.Errorf("compression requires the envelope encoding")
+		}
+	default:
+		return fmt.Errorf("unknown compression %s", c.Compression)
+	}
+
 	if c.SynchronizationInterval == 0 {
 		c.SynchronizationInterval = option.Config.KVstorePeriodicSync
 	}
 
+	if c.ReconciliationInterval == 0 {
+		c.ReconciliationInterval = option.Config.KVstoreStoreReconcileInterval
+	}
+
+	if c.SnapshotInterval == 0 {
+		c.SnapshotInterval = defaultSnapshotInterval
+	}
+
+	if c.ObserverBufferSize == 0 {
+		c.ObserverBufferSize = defaultObserverBufferSize
+	}
+
+	if c.Tenant == "" {
+		c.Tenant = option.Config.KVStoreTenant
+	}
+	c.Prefix = kvstore.TenantPrefix(c.Tenant, c.Prefix)
+
 	if c.Backend == nil {
 		c.Backend = kvstore.Client()
 	}
@@ -114,10 +191,19 @@ type SharedStore struct {
 	// with the kvstore. It is derived from the name.
 	controllerName string
 
+	// reconcileControllerName is the name of the controller used to
+	// reconcile the store with the kvstore. It is derived from the name.
+	reconcileControllerName string
+
+	// reconcileSuspects are the keys which diverged from the kvstore in
+	// the last reconciliation, mapped to the revision at which they
+	// diverged. It is only accessed by the reconciliation controller.
+	reconcileSuspects map[string]uint64
+
 	// backend is the backend as configured via Configuration
 	backend kvstore.BackendOperations
 
-	// mutex protects mutations to localKeys and sharedKeys
+	// mutex protects mutations to localKeys, sharedKeys and sharedKeysInfo
 	mutex lock.RWMutex
 
 	// localKeys is a map of keys that are owned by the local instance. All
@@ -131,6 +217,26 @@ type SharedStore struct {
 	// kvstore events.
 	sharedKeys map[string]Key
 
+	// sharedKeysInfo holds the kvstore metadata of all shared keys
+	sharedKeysInfo map[string]KeyInfo
+
+	// delta is the state of the delta encoding of local and shared keys
+	delta deltaState
+
+	// conflicts is the state of the conflict resolution of local keys
+	conflicts conflictState
+
+	// observersMutex protects observers and synced. It is held for
+	// reading while the shared keys are changed and the observers are
+	// notified so that observers are added in between two changes.
+	observersMutex lock.RWMutex
+
+	// observers are the observers added with AddObserver
+	observers map[Observer]*bufferedObserver
+
+	// synced is true once the initial list of keys has been received
+	synced bool
+
 	kvstoreWatcher *kvstore.Watcher
 }
 
@@ -144,6 +250,16 @@ type Observer interface {
 	OnUpdate(k Key)
 }
 
+// SyncObserver is an Observer which is additionally notified once the
+// initial list of keys has been received from the kvstore
+type SyncObserver interface {
+	Observer
+
+	// OnSync is called after OnUpdate has been called for all keys of the
+	// initial list
+	OnSync()
+}
+
 // NamedKey is an interface that a data structure must implement in order to
 // be deleted from a SharedStore.
 type NamedKey interface {
@@ -193,14 +309,16 @@ func JoinSharedStore(c Configuration) (*SharedStore, error) {
 	}
 
 	s := &SharedStore{
-		conf:       c,
-		localKeys:  map[string]LocalKey{},
-		sharedKeys: map[string]Key{},
-		backend:    c.Backend,
+		conf:           c,
+		localKeys:      map[string]LocalKey{},
+		sharedKeys:     map[string]Key{},
+		sharedKeysInfo: map[string]KeyInfo{},
+		backend:        c.Backend,
 	}
 
 	s.name = "store-" + s.conf.Prefix
 	s.controllerName = "kvstore-sync-" + s.name
+	s.reconcileControllerName = "kvstore-reconcile-" + s.name
 
 	if err := s.listAndStartWatcher(); err != nil {
 		return nil, err
@@ -215,19 +333,47 @@ func JoinSharedStore(c Configuration) (*SharedStore, error) {
 		},
 	)
 
+	if s.conf.ReconciliationInterval > 0 {
+		controllers.UpdateController(s.reconcileControllerName,
+			controller.ControllerParams{
+				DoFunc: func(ctx context.Context) error {
+					return s.reconcile()
+				},
+				RunInterval: s.conf.ReconciliationInterval,
+			},
+		)
+	}
+
 	return s, nil
 }
 
+// onDelete notifies all observers of the deletion of k. Must be called with
+// s.observersMutex held for reading.
 func (s *SharedStore) onDelete(k NamedKey) {
 	if s.conf.Observer != nil {
 		s.conf.Observer.OnDelete(k)
 	}
+	s.notifyObservers(observerEvent{deleted: k})
 }
 
+// onUpdate notifies all observers of the update of k. Must be called with
+// s.observersMutex held for reading.
 func (s *SharedStore) onUpdate(k Key) {
 	if s.conf.Observer != nil {
 		s.conf.Observer.OnUpdate(k)
 	}
+	s.notifyObservers(observerEvent{update: k})
+}
+
+func (s *SharedStore) onSync() {
+	s.observersMutex.Lock()
+	s.synced = true
+	s.notifyObservers(observerEvent{sync: true})
+	s.observersMutex.Unlock()
+
+	if o, ok := s.conf.Observer.(SyncObserver); ok {
+		o.OnSync()
+	}
 }
 
 // Release frees all resources own by the store but leaves all keys in the
@@ -243,6 +389,8 @@ func (s *SharedStore) Release() {
 	}
 
 	controllers.RemoveController(s.controllerName)
+	controllers.RemoveController(s.reconcileControllerName)
+	s.conflicts.stop()
 }
 
 // Close stops participation with a shared store and removes all keys owned by
@@ -256,9 +404,17 @@ func (s *SharedStore) Close() {
 			s.getLogger().WithError(err).Warning("Unable to delete key in kvstore")
 		}
 
+		if s.conf.Delta {
+			s.deleteDelta(name)
+		}
+
 		delete(s.localKeys, name)
+		s.observersMutex.RLock()
 		s.onDelete(key)
+		s.observersMutex.RUnlock()
 	}
+
+	s.removeAllObservers()
 }
 
 // keyPath returns the absolute kvstore path of a key
@@ -275,6 +431,19 @@ func (s *SharedStore) syncLocalKey(key LocalKey) error {
 		return err
 	}
 
+	if s.conf.ConflictResolver != nil {
+		s.conflicts.recordWrite(key.GetKeyName(), jsonValue)
+	}
+
+	if s.conf.Delta {
+		return s.syncDelta(key.GetKeyName(), jsonValue)
+	}
+
+	jsonValue, err = s.encodeValue(jsonValue)
+	if err != nil {
+		return err
+	}
+
 	// Update key in kvstore, overwrite an eventual existing key, attach
 	// lease to expire entry when agent dies and never comes back up.
 	if _, err := s.backend.UpdateIfDifferent(context.TODO(), s.keyPath(key), jsonValue, true); err != nil {
@@ -331,6 +500,30 @@ func (s *SharedStore) SharedKeysMap() map[string]Key {
 	return sharedKeysCopy
 }
 
+// KeyInfo is the kvstore metadata of a shared key
+type KeyInfo struct {
+	// ModRevision is the kvstore revision at which the key was last
+	// modified, 0 if unknown
+	ModRevision uint64
+
+	// LastUpdate is the time the last update of the key was received
+	LastUpdate time.Time
+}
+
+// SharedKeysInfo returns the kvstore metadata of all shared keys indexed by
+// key name. Keys whose LastUpdate lags behind the synchronization interval
+// of their owner have stopped being refreshed.
+func (s *SharedStore) SharedKeysInfo() map[string]KeyInfo {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	info := make(map[string]KeyInfo, len(s.sharedKeysInfo))
+	for k, v := range s.sharedKeysInfo {
+		info[k] = v
+	}
+	return info
+}
+
 // UpdateLocalKey adds a key to be synchronized with the kvstore
 func (s *SharedStore) UpdateLocalKey(key LocalKey) {
 	s.mutex.Lock()
@@ -351,6 +544,41 @@ func (s *SharedStore) UpdateLocalKeySync(key LocalKey) error {
 	return err
 }
 
+// EnsureLocalKey verifies that the local key with the given name exists in
+// the kvstore and re-creates it if it went missing, e.g. because the kvstore
+// has been restored from an older snapshot. The periodic synchronization
+// does not cover this case if delta updates are enabled as it only writes
+// journal entries against the snapshot which went missing. Returns true if
+// the key has been re-created.
+func (s *SharedStore) EnsureLocalKey(name string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.localKeys[name]
+	if !ok {
+		return false, nil
+	}
+
+	value, err := s.backend.Get(s.keyPath(key))
+	if err != nil {
+		return false, err
+	}
+	if value != nil {
+		return false, nil
+	}
+
+	if s.conf.Delta {
+		s.delta.mutex.Lock()
+		delete(s.delta.published, name)
+		s.delta.mutex.Unlock()
+	}
+
+	if err := s.syncLocalKey(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // UpdateKeySync synchronously synchronizes a key with the kvstore.
 func (s *SharedStore) UpdateKeySync(key LocalKey) error {
 	return s.syncLocalKey(key)
@@ -366,13 +594,19 @@ func (s *SharedStore) DeleteLocalKey(key NamedKey) {
 	s.mutex.Unlock()
 
 	err := s.backend.Delete(s.keyPath(key))
+	if s.conf.Delta {
+		s.deleteDelta(name)
+	}
+	s.conflicts.forget(name)
 
 	if ok {
 		if err != nil {
 			s.getLogger().WithError(err).Warning("Unable to delete key in kvstore")
 		}
 
+		s.observersMutex.RLock()
 		s.onDelete(key)
+		s.observersMutex.RUnlock()
 	}
 }
 
@@ -412,24 +646,82 @@ func (s *SharedStore) getLogger() *logrus.Entry {
 	})
 }
 
-func (s *SharedStore) updateKey(name string, value []byte) error {
+// updateKey updates the shared key with the given name to value. revision is
+// the kvstore revision of the update, 0 if unknown.
+func (s *SharedStore) updateKey(name string, value []byte, revision uint64) error {
+	_, err := s.applyUpdate(name, value, revision, false)
+	return err
+}
+
+// updateKeyIfNewer updates the shared key with the given name to value if
+// revision is newer than the revision of the last update of the key, or if
+// either revision is unknown. Returns true if the update has been applied.
+func (s *SharedStore) updateKeyIfNewer(name string, value []byte, revision uint64) (bool, error) {
+	return s.applyUpdate(name, value, revision, true)
+}
+
+// applyUpdate updates the shared key with the given name to value at the
+// given revision. If newerOnly is true, updates not newer than the last
+// known revision of the key are discarded. Returns true if the update has
+// been applied.
+func (s *SharedStore) applyUpdate(name string, value []byte, revision uint64, newerOnly bool) (bool, error) {
 	newKey := s.conf.KeyCreator()
 	if err := newKey.Unmarshal(value); err != nil {
-		return err
+		return false, err
 	}
 
+	if s.resolveConflict(name, value, newKey, revision) == PreferLocal {
+		return false, nil
+	}
+
+	s.observersMutex.RLock()
+	defer s.observersMutex.RUnlock()
+
 	s.mutex.Lock()
+	if newerOnly && revision != 0 && revision <= s.sharedKeysInfo[name].ModRevision {
+		s.mutex.Unlock()
+		return false, nil
+	}
 	s.sharedKeys[name] = newKey
+	info := KeyInfo{ModRevision: revision, LastUpdate: time.Now()}
+	if revision == 0 {
+		info.ModRevision = s.sharedKeysInfo[name].ModRevision
+	}
+	s.sharedKeysInfo[name] = info
 	s.mutex.Unlock()
 
 	s.onUpdate(newKey)
-	return nil
+	return true, nil
 }
 
 func (s *SharedStore) deleteKey(name string) {
+	s.deleteKeyIf(name, func() bool { return true })
+}
+
+// deleteKeyIfRevision deletes the shared key with the given name if the
+// revision of its last update is still revision. Returns true if the key
+// has been deleted.
+func (s *SharedStore) deleteKeyIfRevision(name string, revision uint64) bool {
+	return s.deleteKeyIf(name, func() bool {
+		return s.sharedKeysInfo[name].ModRevision == revision
+	})
+}
+
+// deleteKeyIf deletes the shared key with the given name if cond, called
+// with the mutex held, returns true. Returns true if the key has been
+// deleted.
+func (s *SharedStore) deleteKeyIf(name string, cond func() bool) bool {
+	s.observersMutex.RLock()
+	defer s.observersMutex.RUnlock()
+
 	s.mutex.Lock()
+	if !cond() {
+		s.mutex.Unlock()
+		return false
+	}
 	existingKey, ok := s.sharedKeys[name]
 	delete(s.sharedKeys, name)
+	delete(s.sharedKeysInfo, name)
 	s.mutex.Unlock()
 
 	if ok {
@@ -438,6 +730,7 @@ func (s *SharedStore) deleteKey(name string) {
 		s.getLogger().WithField("key", name).
 			Warning("Unable to find deleted key in local state")
 	}
+	return ok
 }
 
 func (s *SharedStore) listAndStartWatcher() error {
@@ -460,6 +753,7 @@ func (s *SharedStore) watcher(listDone chan bool) {
 	for event := range s.kvstoreWatcher.Events {
 		if event.Typ == kvstore.EventTypeListDone {
 			s.getLogger().Debug("Initial list of objects received from kvstore")
+			s.onSync()
 			close(listDone)
 			continue
 		}
@@ -471,25 +765,75 @@ func (s *SharedStore) watcher(listDone chan bool) {
 
 		logger.Debugf("Received key update via kvstore [value %s]", string(event.Value))
 
-		keyName := strings.TrimPrefix(event.Key, s.conf.Prefix)
-		if keyName[0] == '/' {
-			keyName = keyName[1:]
+		keyName := s.keyName(event.Key)
+		if name, ok := journalKeyName(keyName); ok {
+			s.handleJournalEvent(name, event, logger)
+			continue
 		}
 
 		switch event.Typ {
 		case kvstore.EventTypeCreate, kvstore.EventTypeModify:
-			if err := s.updateKey(keyName, event.Value); err != nil {
-				logger.WithError(err).Warningf("Unable to unmarshal store value: %s", string(event.Value))
+			value, err := decodeValue(event.Value)
+			if err != nil {
+				logger.WithError(err).Warning("Unable to decode store value")
+				continue
+			}
+
+			value, err = s.delta.onSnapshot(keyName, value)
+			if err != nil {
+				logger.WithError(err).Warning("Unable to apply journal to store value")
+				continue
+			}
+
+			if err := s.updateKey(keyName, value, event.ModRevision); err != nil {
+				logger.WithError(err).Warningf("Unable to unmarshal store value: %s", string(value))
 			}
 
 		case kvstore.EventTypeDelete:
 			if localKey := s.lookupLocalKey(keyName); localKey != nil {
 				logger.Warning("Received delete event for local key. Re-creating the key in the kvstore")
 
+				// The published snapshot was deleted, the full key
+				// must be written again rather than a journal entry
+				if s.conf.Delta {
+					s.delta.mutex.Lock()
+					delete(s.delta.published, keyName)
+					s.delta.mutex.Unlock()
+				}
+
 				s.syncLocalKey(localKey)
 			} else {
+				s.delta.forget(keyName)
 				s.deleteKey(keyName)
 			}
 		}
 	}
 }
+
+// handleJournalEvent handles a kvstore event of the journal of the key with
+// the given name
+func (s *SharedStore) handleJournalEvent(name string, event kvstore.KeyValueEvent, logger *logrus.Entry) {
+	switch event.Typ {
+	case kvstore.EventTypeCreate, kvstore.EventTypeModify:
+		data, err := decodeValue(event.Value)
+		if err != nil {
+			logger.WithError(err).Warning("Unable to decode store journal")
+			return
+		}
+
+		value, err := s.delta.onJournal(name, data)
+		if err != nil {
+			logger.WithError(err).Warning("Unable to apply store journal")
+			return
+		}
+
+		if value != nil {
+			if err := s.updateKey(name, value, event.ModRevision); err != nil {
+				logger.WithError(err).Warningf("Unable to unmarshal store value: %s", string(value))
+			}
+		}
+
+	case kvstore.EventTypeDelete:
+		s.delta.forgetJournal(name)
+	}
+}
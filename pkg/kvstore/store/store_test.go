@@ -156,6 +156,14 @@ func (s *StoreSuite) TestStoreCreation(c *C) {
 	c.Assert(store, Not(IsNil))
 	c.Assert(store.conf.SynchronizationInterval, Equals, option.Config.KVstorePeriodicSync)
 	store.Close()
+
+	// Tenant prefix must be injected into the prefix
+	name := testutils.RandomRune()
+	store, err = JoinSharedStore(Configuration{Prefix: kvstore.BaseKeyPrefix + "/" + name, KeyCreator: newTestType, Tenant: "tenant-a"})
+	c.Assert(err, IsNil)
+	c.Assert(store, Not(IsNil))
+	c.Assert(store.conf.Prefix, Equals, "cilium/tenants/tenant-a/"+name)
+	store.Close()
 }
 
 func expect(check func() bool) error {
@@ -0,0 +1,55 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"path"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/option"
+)
+
+const (
+	// tenantsPrefix is the prefix below BaseKeyPrefix under which the
+	// keys of all tenants are stored
+	tenantsPrefix = BaseKeyPrefix + "/tenants"
+)
+
+// TenantPrefix injects the prefix of tenant into key if key is derived from
+// BaseKeyPrefix, e.g. "cilium/state/nodes/v1" becomes
+// "cilium/tenants/<tenant>/state/nodes/v1". Keys outside of BaseKeyPrefix,
+// keys which already carry a tenant prefix and an empty tenant leave the key
+// unmodified.
+func TenantPrefix(tenant, key string) string {
+	if tenant == "" || strings.HasPrefix(key, tenantsPrefix+"/") {
+		return key
+	}
+
+	if key == BaseKeyPrefix {
+		return path.Join(tenantsPrefix, tenant)
+	}
+
+	if !strings.HasPrefix(key, BaseKeyPrefix+"/") {
+		return key
+	}
+
+	return path.Join(tenantsPrefix, tenant, strings.TrimPrefix(key, BaseKeyPrefix+"/"))
+}
+
+// TenantPath injects the prefix of the tenant configured via
+// option.Config.KVStoreTenant into key. See TenantPrefix().
+func TenantPath(key string) string {
+	return TenantPrefix(option.Config.KVStoreTenant, key)
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package kvstore
+
+import (
+	"github.com/cilium/cilium/pkg/option"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *independentSuite) TestTenantPrefix(c *C) {
+	c.Assert(TenantPrefix("", "cilium/state/nodes/v1"), Equals, "cilium/state/nodes/v1")
+	c.Assert(TenantPrefix("a", "cilium/state/nodes/v1"), Equals, "cilium/tenants/a/state/nodes/v1")
+	c.Assert(TenantPrefix("a", "cilium"), Equals, "cilium/tenants/a")
+	c.Assert(TenantPrefix("a", "ciliumfoo/bar"), Equals, "ciliumfoo/bar")
+	c.Assert(TenantPrefix("a", "other/prefix"), Equals, "other/prefix")
+
+	// Keys already carrying a tenant prefix are not prefixed again
+	c.Assert(TenantPrefix("b", "cilium/tenants/a/state/nodes/v1"), Equals, "cilium/tenants/a/state/nodes/v1")
+
+	oldTenant := option.Config.KVStoreTenant
+	defer func() { option.Config.KVStoreTenant = oldTenant }()
+	option.Config.KVStoreTenant = "a"
+	c.Assert(TenantPath("cilium/state/ip/v1"), Equals, "cilium/tenants/a/state/ip/v1")
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvstore
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/metrics"
+	"github.com/cilium/cilium/pkg/option"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	metricRejected    = "rejected"
+	metricQuarantined = "quarantined"
+
+	fieldValueSize    = "valueSize"
+	fieldMaxValueSize = "maxValueSize"
+)
+
+// ErrValueTooLarge is returned when a value to be written to the kvstore
+// exceeds the configured maximum value size
+type ErrValueTooLarge struct {
+	Key     string
+	Size    int
+	MaxSize int
+}
+
+// Error returns the string representation of the error
+func (e *ErrValueTooLarge) Error() string {
+	return fmt.Sprintf("value of key %s is too large: %d bytes exceeds maximum of %d bytes",
+		e.Key, e.Size, e.MaxSize)
+}
+
+// isOversized returns true if the value exceeds the maximum value size
+func isOversized(value []byte) bool {
+	max := option.Config.KVstoreMaxValueSize
+	return max > 0 && len(value) > max
+}
+
+func trackOversizedValue(key, action string) {
+	if !option.Config.MetricsConfig.KVStoreOversizedValuesEnabled {
+		return
+	}
+	metrics.KVStoreOversizedValues.WithLabelValues(getScopeFromKey(key), action).Inc()
+}
+
+// checkValueSize must be called before a value is written to the kvstore. It
+// returns ErrValueTooLarge if the value exceeds the maximum value size.
+func checkValueSize(key string, value []byte) error {
+	if !isOversized(value) {
+		return nil
+	}
+
+	trackOversizedValue(key, metricRejected)
+	return &ErrValueTooLarge{
+		Key:     key,
+		Size:    len(value),
+		MaxSize: option.Config.KVstoreMaxValueSize,
+	}
+}
+
+// maxLoggedOversizedValues is the maximum number of oversized values
+// remembered to log each of them once
+const maxLoggedOversizedValues = 1024
+
+var (
+	oversizedValuesMutex lock.Mutex
+
+	// oversizedValues is the size of each oversized value logged, indexed
+	// by key. Protected by oversizedValuesMutex.
+	oversizedValues = map[string]int{}
+)
+
+// logOversizedValue logs an oversized value once per key and value size, the
+// same value is read again on every watcher restart and every lookup
+func logOversizedValue(key string, size int) {
+	oversizedValuesMutex.Lock()
+	logged := oversizedValues[key] == size
+	if !logged {
+		if len(oversizedValues) >= maxLoggedOversizedValues {
+			oversizedValues = map[string]int{}
+		}
+		oversizedValues[key] = size
+	}
+	oversizedValuesMutex.Unlock()
+
+	scopedLog := log.WithFields(logrus.Fields{
+		fieldKey:          key,
+		fieldValueSize:    size,
+		fieldMaxValueSize: option.Config.KVstoreMaxValueSize,
+	})
+	if logged {
+		scopedLog.Debug("Ignoring oversized kvstore value")
+	} else {
+		scopedLog.Warning("Ignoring oversized kvstore value")
+	}
+}
+
+// quarantineValue must be called for every value read from the kvstore. It
+// returns true if the value exceeds the maximum value size and must not be
+// passed on to any cache.
+func quarantineValue(key string, value []byte) bool {
+	if !isOversized(value) {
+		return false
+	}
+
+	logOversizedValue(key, len(value))
+	trackOversizedValue(key, metricQuarantined)
+	return true
+}
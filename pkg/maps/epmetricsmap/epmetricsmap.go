@@ -0,0 +1,324 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package epmetricsmap
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/cilium/pkg/bpf"
+	"github.com/cilium/cilium/pkg/controller"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/maps/metricsmap"
+	"github.com/cilium/cilium/pkg/metrics"
+	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
+	"github.com/cilium/cilium/pkg/option"
+)
+
+var (
+	// Metrics is the bpf endpoint metrics map
+	Metrics *bpf.Map
+	log     = logging.DefaultLogger.WithField(logfields.LogSubsys, "map-ep-metrics")
+
+	// endpointExists returns true if an endpoint with the given ID
+	// exists. It is set by Init.
+	endpointExists func(id uint16) bool
+)
+
+const (
+	// MapName for endpoint metrics map.
+	MapName = "cilium_ep_metrics"
+	// MaxEntries is the maximum number of keys that can be present in the
+	// endpoint metrics map.
+	MaxEntries = 65536
+
+	// GCInterval is the interval in which the entries of endpoints which
+	// no longer exist are removed from the endpoint metrics map
+	GCInterval = 5 * time.Minute
+
+	// registeredDrops and registeredForwards are the names under which
+	// the endpoint metrics map is registered with the metrics map sync
+	registeredDrops    = "endpoint-drops"
+	registeredForwards = "endpoint-forwards"
+
+	// dirIngress and dirEgress must match METRIC_INGRESS and
+	// METRIC_EGRESS in <bpf/lib/common.h>
+	dirIngress = 1
+	dirEgress  = 2
+)
+
+// Key must be in sync with struct ep_metrics_key in <bpf/lib/common.h>
+// +k8s:deepcopy-gen=true
+// +k8s:deepcopy-gen:interfaces=github.com/cilium/cilium/pkg/bpf.MapKey
+type Key struct {
+	EndpointID uint16 `align:"ep_id"`
+	Reason     uint8  `align:"reason"`
+	Dir        uint8  `align:"dir"`
+	Reserved   uint32 `align:"reserved"`
+}
+
+// String converts the key into a human readable string format
+func (k *Key) String() string {
+	return fmt.Sprintf("endpoint:%d reason:%d dir:%d", k.EndpointID, k.Reason, k.Dir)
+}
+
+// GetKeyPtr returns the unsafe pointer to the BPF key
+func (k *Key) GetKeyPtr() unsafe.Pointer { return unsafe.Pointer(k) }
+
+// NewValue returns a new empty instance of the structure representing the BPF
+// map value
+func (k *Key) NewValue() bpf.MapValue { return &metricsmap.Value{} }
+
+// Endpoint returns the endpoint ID in the format used for the endpoint label
+// of the prometheus metrics
+func (k *Key) Endpoint() string {
+	return strconv.FormatUint(uint64(k.EndpointID), 10)
+}
+
+// Direction gets the direction in human readable string format
+func (k *Key) Direction() string {
+	return metricsmap.MetricDirection(k.Dir)
+}
+
+// DropForwardReason gets the forwarded/dropped reason in human readable string format
+func (k *Key) DropForwardReason() string {
+	return monitorAPI.DropReason(k.Reason)
+}
+
+// IsDrop checks if the reason is drop or not.
+func (k *Key) IsDrop() bool {
+	return isDrop(k.Reason)
+}
+
+func isDrop(reason uint8) bool {
+	return reason == monitorAPI.DropInvalid || reason >= monitorAPI.DropMin
+}
+
+// Enabled returns true if any of the metrics exported from the endpoint
+// metrics map is enabled. The datapath only accounts packets to endpoints if
+// this is the case.
+func Enabled() bool {
+	c := option.Config.MetricsConfig
+	return c.EndpointDropCountEnabled || c.EndpointDropBytesEnabled ||
+		c.EndpointForwardCountEnabled || c.EndpointForwardBytesEnabled
+}
+
+// bpfMap is the subset of the operations on the endpoint metrics map used
+// for the export and garbage collection of its entries. It is replaced by an
+// emulation of the map in unit tests.
+type bpfMap interface {
+	metricsmap.MapDumper
+	Delete(key bpf.MapKey) error
+}
+
+// endpointMetricsMap is the endpoint metrics map read by the metrics map
+// sync and GC
+var endpointMetricsMap bpfMap
+
+// filteredMap is the view of the endpoint metrics map registered with the
+// metrics map sync. It only contains the drops or the forwards of endpoints
+// which exist so that the metrics of deleted endpoints are not re-created
+// before their entries have been garbage collected.
+type filteredMap struct {
+	drops bool
+}
+
+func (f filteredMap) DumpWithCallbackIfExists(cb bpf.DumpCallback) error {
+	return endpointMetricsMap.DumpWithCallbackIfExists(func(key bpf.MapKey, value bpf.MapValue) {
+		k := key.(*Key)
+		if k.IsDrop() == f.drops && endpointExists(k.EndpointID) {
+			cb(key, value)
+		}
+	})
+}
+
+// sum returns the sum of the per-CPU values of an entry
+func sum(value bpf.MapValue) metricsmap.Value {
+	var total metricsmap.Value
+	for _, v := range *value.(*metricsmap.Values) {
+		total.Count += v.Count
+		total.Bytes += v.Bytes
+	}
+	return total
+}
+
+func countValue(value bpf.MapValue) float64 {
+	return float64(sum(value).Count)
+}
+
+func bytesValue(value bpf.MapValue) float64 {
+	return float64(sum(value).Bytes)
+}
+
+// Init registers the endpoint metrics map with the metrics map sync which
+// exports its entries as the endpoint drop and forward metrics. exists must
+// return true if an endpoint with the given ID exists, entries of all other
+// endpoints are not exported and are removed by the GC.
+func Init(exists func(id uint16) bool) error {
+	endpointExists = exists
+
+	err := metricsmap.RegisterMap(registeredDrops, metricsmap.RegisteredMap{
+		Map: filteredMap{drops: true},
+		Labels: func(key bpf.MapKey) []string {
+			k := key.(*Key)
+			return []string{k.Endpoint(), k.DropForwardReason(), k.Direction()}
+		},
+		Counters: []metricsmap.CounterMapping{
+			{Counter: metrics.EndpointDropCount, Value: countValue},
+			{Counter: metrics.EndpointDropBytes, Value: bytesValue},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = metricsmap.RegisterMap(registeredForwards, metricsmap.RegisteredMap{
+		Map: filteredMap{drops: false},
+		Labels: func(key bpf.MapKey) []string {
+			k := key.(*Key)
+			return []string{k.Endpoint(), k.Direction()}
+		},
+		Counters: []metricsmap.CounterMapping{
+			{Counter: metrics.EndpointForwardCount, Value: countValue},
+			{Counter: metrics.EndpointForwardBytes, Value: bytesValue},
+		},
+	})
+	if err != nil {
+		metricsmap.UnregisterMap(registeredDrops)
+	}
+	return err
+}
+
+// StartGC starts the controller removing the entries of endpoints which no
+// longer exist from the endpoint metrics map. It must only be started once
+// all endpoints have been restored.
+func StartGC() {
+	controller.NewManager().UpdateController("epmetricsmap-gc",
+		controller.ControllerParams{
+			DoFunc:      GC,
+			RunInterval: GCInterval,
+		})
+}
+
+// GC removes the entries of endpoints which no longer exist from the
+// endpoint metrics map along with their metrics
+func GC(ctx context.Context) error {
+	keys, err := deleteEntries(func(k *Key) bool { return !endpointExists(k.EndpointID) })
+	for i := range keys {
+		deletePrometheusMetrics(keys[i].Endpoint(), keys[i].Reason, keys[i].Direction())
+	}
+
+	if len(keys) > 0 {
+		log.WithField("entries", len(keys)).Debug("Removed entries of deleted endpoints from endpoint metrics map")
+	}
+
+	return err
+}
+
+// deleteEntries removes the entries for which match returns true from the
+// endpoint metrics map and returns the keys of the removed entries
+func deleteEntries(match func(k *Key) bool) ([]Key, error) {
+	keys := []Key{}
+	err := endpointMetricsMap.DumpWithCallbackIfExists(func(key bpf.MapKey, value bpf.MapValue) {
+		if k := key.(*Key); match(k) {
+			keys = append(keys, *k)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		if err := endpointMetricsMap.Delete(&keys[i]); err != nil {
+			return keys[:i], fmt.Errorf("unable to delete endpoint metrics map entry %s: %s", keys[i].String(), err)
+		}
+	}
+
+	return keys, nil
+}
+
+// deletePrometheusMetrics deletes the prometheus metrics of an endpoint for
+// reason and direction
+func deletePrometheusMetrics(endpoint string, reason uint8, direction string) {
+	if isDrop(reason) {
+		metrics.EndpointDropCount.DeleteLabelValues(endpoint, monitorAPI.DropReason(reason), direction)
+		metrics.EndpointDropBytes.DeleteLabelValues(endpoint, monitorAPI.DropReason(reason), direction)
+	} else {
+		metrics.EndpointForwardCount.DeleteLabelValues(endpoint, direction)
+		metrics.EndpointForwardBytes.DeleteLabelValues(endpoint, direction)
+	}
+}
+
+// DeleteEndpoint removes the entries of the endpoint with the given ID from
+// the endpoint metrics map along with its prometheus metrics. It is called
+// when the endpoint is deleted. Entries left behind, e.g. by packets
+// accounted while the endpoint was being deleted, are removed by the GC.
+func DeleteEndpoint(id uint16) {
+	if !Enabled() {
+		return
+	}
+
+	if err := deleteEndpoint(id); err != nil {
+		log.WithError(err).WithField(logfields.EndpointID, id).
+			Warning("Unable to remove entries of deleted endpoint from endpoint metrics map")
+	}
+}
+
+// deleteEndpoint removes the entries and the prometheus metrics of the
+// endpoint with the given ID
+func deleteEndpoint(id uint16) error {
+	_, err := deleteEntries(func(k *Key) bool { return k.EndpointID == id })
+	deleteEndpointMetrics(id)
+	return err
+}
+
+// deleteEndpointMetrics deletes the prometheus metrics of the endpoint with
+// the given ID for all directions and reasons
+func deleteEndpointMetrics(id uint16) {
+	endpoint := strconv.FormatUint(uint64(id), 10)
+	for _, dir := range []uint8{dirIngress, dirEgress} {
+		direction := metricsmap.MetricDirection(dir)
+		deletePrometheusMetrics(endpoint, 0, direction)
+		for reason := 0; reason <= 255; reason++ {
+			if isDrop(uint8(reason)) {
+				deletePrometheusMetrics(endpoint, uint8(reason), direction)
+			}
+		}
+	}
+}
+
+func init() {
+	cpus := metricsmap.PossibleCPUs()
+	vs := make(metricsmap.Values, cpus)
+
+	// Metrics is a mapping of the packet drops and forwards of each
+	// endpoint on ingress/egress direction
+	Metrics = bpf.NewPerCPUHashMap(
+		MapName,
+		&Key{},
+		int(unsafe.Sizeof(Key{})),
+		&vs,
+		int(unsafe.Sizeof(metricsmap.Value{})),
+		cpus,
+		MaxEntries,
+		0, 0,
+		bpf.ConvertKeyValue,
+	)
+	endpointMetricsMap = Metrics
+}
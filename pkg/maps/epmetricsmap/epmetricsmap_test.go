@@ -0,0 +1,202 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package epmetricsmap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cilium/cilium/pkg/bpf"
+	"github.com/cilium/cilium/pkg/maps/metricsmap"
+	"github.com/cilium/cilium/pkg/metrics"
+	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type EPMetricsMapTestSuite struct{}
+
+var _ = Suite(&EPMetricsMapTestSuite{})
+
+// fakeMap is a userspace emulation of the per-CPU endpoint metrics map
+type fakeMap struct {
+	entries map[Key]metricsmap.Values
+}
+
+func (f *fakeMap) set(id uint16, reason, dir uint8, values ...metricsmap.Value) {
+	f.entries[Key{EndpointID: id, Reason: reason, Dir: dir}] = values
+}
+
+func (f *fakeMap) DumpWithCallbackIfExists(cb bpf.DumpCallback) error {
+	for key, values := range f.entries {
+		k, v := key, values
+		cb(&k, &v)
+	}
+	return nil
+}
+
+func (f *fakeMap) Delete(key bpf.MapKey) error {
+	delete(f.entries, *key.(*Key))
+	return nil
+}
+
+// harness registers a fakeMap with the metrics map sync and collects the
+// results in private prometheus metrics
+type harness struct {
+	m *fakeMap
+
+	// endpoints is the set of existing endpoints
+	endpoints map[uint16]bool
+
+	dropCount, dropBytes, forwardCount, forwardBytes *prometheus.CounterVec
+	restore                                          func()
+}
+
+func newHarness(c *C) *harness {
+	oldMap := endpointMetricsMap
+	oldDropCount, oldDropBytes := metrics.EndpointDropCount, metrics.EndpointDropBytes
+	oldForwardCount, oldForwardBytes := metrics.EndpointForwardCount, metrics.EndpointForwardBytes
+
+	h := &harness{
+		m:            &fakeMap{entries: map[Key]metricsmap.Values{}},
+		endpoints:    map[uint16]bool{},
+		dropCount:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "drop_count"}, []string{"endpoint", "reason", "direction"}),
+		dropBytes:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "drop_bytes"}, []string{"endpoint", "reason", "direction"}),
+		forwardCount: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "forward_count"}, []string{"endpoint", "direction"}),
+		forwardBytes: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "forward_bytes"}, []string{"endpoint", "direction"}),
+		restore: func() {
+			metricsmap.UnregisterMap(registeredDrops)
+			metricsmap.UnregisterMap(registeredForwards)
+			endpointMetricsMap = oldMap
+			metrics.EndpointDropCount, metrics.EndpointDropBytes = oldDropCount, oldDropBytes
+			metrics.EndpointForwardCount, metrics.EndpointForwardBytes = oldForwardCount, oldForwardBytes
+		},
+	}
+
+	endpointMetricsMap = h.m
+	metrics.EndpointDropCount, metrics.EndpointDropBytes = h.dropCount, h.dropBytes
+	metrics.EndpointForwardCount, metrics.EndpointForwardBytes = h.forwardCount, h.forwardBytes
+	c.Assert(Init(func(id uint16) bool { return h.endpoints[id] }), IsNil)
+
+	return h
+}
+
+// dump returns the sums of the entries of the registered view of the map
+// of drops or forwards
+func (h *harness) dump(drops bool) map[Key]metricsmap.Value {
+	sums := map[Key]metricsmap.Value{}
+	filteredMap{drops: drops}.DumpWithCallbackIfExists(func(key bpf.MapKey, value bpf.MapValue) {
+		sums[*key.(*Key)] = sum(value)
+	})
+	return sums
+}
+
+func (s *EPMetricsMapTestSuite) TestFilteredMap(c *C) {
+	h := newHarness(c)
+	defer h.restore()
+
+	h.endpoints[10] = true
+	h.endpoints[20] = true
+
+	dropReason := monitorAPI.DropMin + 1
+	h.m.set(10, 0, dirIngress, metricsmap.Value{Count: 1, Bytes: 100}, metricsmap.Value{Count: 2, Bytes: 200})
+	h.m.set(10, dropReason, dirEgress, metricsmap.Value{Count: 3, Bytes: 30}, metricsmap.Value{Count: 0, Bytes: 0})
+	h.m.set(20, dropReason, dirEgress, metricsmap.Value{Count: 0, Bytes: 0}, metricsmap.Value{Count: 5, Bytes: 50})
+	// Entries of endpoints which do not exist are not exported
+	h.m.set(30, dropReason, dirEgress, metricsmap.Value{Count: 7, Bytes: 70})
+
+	c.Assert(h.dump(false), DeepEquals, map[Key]metricsmap.Value{
+		{EndpointID: 10, Dir: dirIngress}: {Count: 3, Bytes: 300},
+	})
+	c.Assert(h.dump(true), DeepEquals, map[Key]metricsmap.Value{
+		{EndpointID: 10, Reason: dropReason, Dir: dirEgress}: {Count: 3, Bytes: 30},
+		{EndpointID: 20, Reason: dropReason, Dir: dirEgress}: {Count: 5, Bytes: 50},
+	})
+
+	// Both views are registered with the metrics map sync
+	c.Assert(Init(func(id uint16) bool { return true }), Not(IsNil))
+}
+
+func (s *EPMetricsMapTestSuite) TestDeleteEndpoint(c *C) {
+	h := newHarness(c)
+	defer h.restore()
+
+	dropReason := monitorAPI.DropMin + 1
+	h.m.set(10, 0, dirIngress, metricsmap.Value{Count: 1, Bytes: 100})
+	h.m.set(10, dropReason, dirEgress, metricsmap.Value{Count: 3, Bytes: 30})
+	h.m.set(20, dropReason, dirEgress, metricsmap.Value{Count: 5, Bytes: 50})
+	h.forwardCount.WithLabelValues("10", metricsmap.MetricDirection(dirIngress)).Add(1)
+	h.dropCount.WithLabelValues("10", monitorAPI.DropReason(dropReason), metricsmap.MetricDirection(dirEgress)).Add(3)
+	h.dropCount.WithLabelValues("20", monitorAPI.DropReason(dropReason), metricsmap.MetricDirection(dirEgress)).Add(5)
+
+	// The entries of the endpoint are removed without waiting for the GC
+	c.Assert(deleteEndpoint(10), IsNil)
+	c.Assert(h.m.entries, HasLen, 1)
+	_, ok := h.m.entries[Key{EndpointID: 20, Reason: dropReason, Dir: dirEgress}]
+	c.Assert(ok, Equals, true)
+	c.Assert(numSeries(h.dropCount), Equals, 1)
+	c.Assert(numSeries(h.forwardCount), Equals, 0)
+
+	count := h.dropCount.WithLabelValues("20", monitorAPI.DropReason(dropReason), metricsmap.MetricDirection(dirEgress))
+	c.Assert(metrics.GetCounterValue(count), Equals, float64(5))
+}
+
+func (s *EPMetricsMapTestSuite) TestGC(c *C) {
+	h := newHarness(c)
+	defer h.restore()
+
+	h.endpoints[20] = true
+
+	dropReason := monitorAPI.DropMin + 1
+	h.m.set(10, 0, dirIngress, metricsmap.Value{Count: 1, Bytes: 100})
+	h.m.set(10, dropReason, dirEgress, metricsmap.Value{Count: 3, Bytes: 30})
+	h.m.set(20, dropReason, dirEgress, metricsmap.Value{Count: 5, Bytes: 50})
+	h.dropCount.WithLabelValues("10", monitorAPI.DropReason(dropReason), metricsmap.MetricDirection(dirEgress)).Add(3)
+
+	// The GC removes the entries of endpoints which no longer exist along
+	// with metrics which may have been re-created concurrently
+	c.Assert(GC(context.Background()), IsNil)
+	c.Assert(h.m.entries, HasLen, 1)
+	_, ok := h.m.entries[Key{EndpointID: 20, Reason: dropReason, Dir: dirEgress}]
+	c.Assert(ok, Equals, true)
+	c.Assert(numSeries(h.dropCount), Equals, 0)
+}
+
+// numSeries returns the number of series of vec
+func numSeries(vec *prometheus.CounterVec) int {
+	ch := make(chan prometheus.Metric, 16)
+	vec.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+func (s *EPMetricsMapTestSuite) TestKey(c *C) {
+	k := &Key{EndpointID: 1234, Reason: monitorAPI.DropMin + 1, Dir: dirIngress}
+	c.Assert(k.String(), Equals, "endpoint:1234 reason:131 dir:1")
+	c.Assert(k.Endpoint(), Equals, "1234")
+	c.Assert(k.Direction(), Equals, "INGRESS")
+	c.Assert(k.IsDrop(), Equals, true)
+
+	k = &Key{EndpointID: 1, Dir: dirEgress}
+	c.Assert(k.IsDrop(), Equals, false)
+	c.Assert(k.Direction(), Equals, "EGRESS")
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsmap
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/cilium/cilium/pkg/bpf"
+)
+
+// batchSize is the maximum number of entries looked up in a single
+// BPF_MAP_LOOKUP_BATCH syscall
+const batchSize = 256
+
+// batchUnsupported is set to 1 once the kernel rejected a batch lookup of the
+// metrics map. Subsequent syncs then iterate over the map key by key.
+var batchUnsupported int32
+
+// batchLookupFunc looks up at most len(keys) entries starting at the position
+// in, which is nil for the first call, stores the position to continue at in
+// out and returns the number of entries looked up and true once the end of
+// the map has been reached. It has the semantics of bpf.LookupBatch.
+type batchLookupFunc func(in, out *Key, keys []Key, values []Value) (int, bool, error)
+
+// lookupBatch returns the batchLookupFunc of the map in fd
+func lookupBatch(fd int) batchLookupFunc {
+	return func(in, out *Key, keys []Key, values []Value) (int, bool, error) {
+		var inPtr unsafe.Pointer
+		if in != nil {
+			inPtr = unsafe.Pointer(in)
+		}
+		n, done, err := bpf.LookupBatch(fd, inPtr, unsafe.Pointer(out),
+			unsafe.Pointer(&keys[0]), unsafe.Pointer(&values[0]), uint32(len(keys)))
+		return int(n), done, err
+	}
+}
+
+// forEachBatch calls fn for each entry returned by lookup with the values of
+// the cpus possible CPUs. Returns false if no entry could be passed to fn
+// because batch lookups are not supported, in which case the caller has to
+// fall back to iterating key by key.
+func forEachBatch(lookup batchLookupFunc, cpus int, fn func(key *Key, values []Value)) (bool, error) {
+	// The batch position is a bucket index for hash maps, which
+	// fits into the size of a key
+	var in, out Key
+	var inPtr *Key
+
+	keys := make([]Key, batchSize)
+	values := make([]Value, batchSize*cpus)
+
+	for {
+		n, done, err := lookup(inPtr, &out, keys, values)
+		if err == bpf.ErrBatchNotSupported && inPtr == nil {
+			return false, nil
+		}
+		if err != nil {
+			return true, err
+		}
+
+		for i := 0; i < n; i++ {
+			fn(&keys[i], values[i*cpus:(i+1)*cpus])
+		}
+
+		if done {
+			return true, nil
+		}
+
+		in = out
+		inPtr = &in
+	}
+}
+
+// forEachEntryBatch calls fn for each entry of the map in fd using batch
+// lookups. Returns false if batch lookups are not supported by the kernel.
+func forEachEntryBatch(fd int, fn func(key *Key, values []Value)) (bool, error) {
+	if atomic.LoadInt32(&batchUnsupported) == 1 {
+		return false, nil
+	}
+
+	ok, err := forEachBatch(lookupBatch(fd), numPossibleCPUs(), fn)
+	if !ok {
+		log.Debug("Batch lookups of the metrics map not supported, falling back to key by key iteration")
+		atomic.StoreInt32(&batchUnsupported, 1)
+	}
+	return ok, err
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package metricsmap
+
+import (
+	"errors"
+
+	"github.com/cilium/cilium/pkg/bpf"
+
+	. "gopkg.in/check.v1"
+)
+
+// fakeBatchLookup returns a batchLookupFunc serving n entries in batches,
+// keeping the index of the next entry in the Line field of the position
+func fakeBatchLookup(n, cpus int) batchLookupFunc {
+	return func(in, out *Key, keys []Key, values []Value) (int, bool, error) {
+		start := 0
+		if in != nil {
+			start = int(in.Line)
+		}
+
+		count := 0
+		for i := start; i < n && count < len(keys); i++ {
+			keys[count] = Key{Reason: uint8(i % 256), Line: uint16(i)}
+			for cpu := 0; cpu < cpus; cpu++ {
+				values[count*cpus+cpu] = Value{Count: uint64(i), Bytes: uint64(cpu)}
+			}
+			count++
+		}
+
+		out.Line = uint16(start + count)
+		return count, start+count == n, nil
+	}
+}
+
+func (m *MetricsMapTestSuite) TestForEachBatch(c *C) {
+	const entries, cpus = 2*batchSize + 10, 3
+
+	seen := map[uint16]bool{}
+	ok, err := forEachBatch(fakeBatchLookup(entries, cpus), cpus, func(key *Key, values []Value) {
+		c.Assert(seen[key.Line], Equals, false)
+		seen[key.Line] = true
+
+		c.Assert(values, HasLen, cpus)
+		for cpu, v := range values {
+			c.Assert(v, Equals, Value{Count: uint64(key.Line), Bytes: uint64(cpu)})
+		}
+	})
+	c.Assert(ok, Equals, true)
+	c.Assert(err, IsNil)
+	c.Assert(seen, HasLen, entries)
+}
+
+func (m *MetricsMapTestSuite) TestForEachBatchFallback(c *C) {
+	calls := 0
+	unsupported := func(in, out *Key, keys []Key, values []Value) (int, bool, error) {
+		calls++
+		return 0, false, bpf.ErrBatchNotSupported
+	}
+
+	ok, err := forEachBatch(unsupported, 1, func(key *Key, values []Value) {
+		c.Fatal("no entry expected")
+	})
+	c.Assert(ok, Equals, false)
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 1)
+
+	// Errors after the first batch are returned, the entries already
+	// passed to fn must not be iterated again
+	lookup := fakeBatchLookup(2*batchSize, 1)
+	failing := func(in, out *Key, keys []Key, values []Value) (int, bool, error) {
+		if in != nil {
+			return 0, false, errors.New("lookup failed")
+		}
+		return lookup(in, out, keys, values)
+	}
+
+	seen := 0
+	ok, err = forEachBatch(failing, 1, func(key *Key, values []Value) { seen++ })
+	c.Assert(ok, Equals, true)
+	c.Assert(err, Not(IsNil))
+	c.Assert(seen, Equals, batchSize)
+}
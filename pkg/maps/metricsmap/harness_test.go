@@ -0,0 +1,312 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package metricsmap
+
+import (
+	"context"
+	"time"
+
+	"github.com/cilium/cilium/pkg/metrics"
+	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "gopkg.in/check.v1"
+)
+
+// fakeMap is a userspace emulation of the per-CPU metrics map
+type fakeMap struct {
+	entries map[Key]Values
+}
+
+func newFakeMap() *fakeMap {
+	return &fakeMap{entries: map[Key]Values{}}
+}
+
+// set replaces the per-CPU values of the entry for reason and dir
+func (f *fakeMap) set(reason, dir uint8, values ...Value) {
+	f.entries[Key{Reason: reason, Dir: dir}] = values
+}
+
+func (f *fakeMap) forEachEntry(fn func(key *Key, values []Value)) error {
+	for key, values := range f.entries {
+		k := key
+		fn(&k, values)
+	}
+	return nil
+}
+
+func (f *fakeMap) resetEntries(match func(key *Key) bool) ([]Key, error) {
+	keys := []Key{}
+	for key, values := range f.entries {
+		k := key
+		if match(&k) {
+			f.entries[key] = make(Values, len(values))
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// harness runs the SyncMetricsMap pipeline against a fakeMap and collects
+// the results in private prometheus metrics
+type harness struct {
+	m *fakeMap
+
+	dropCount, dropBytes, forwardCount, forwardBytes *prometheus.CounterVec
+	restore                                          func()
+}
+
+func newHarness() *harness {
+	oldMap := metricsMap
+	oldDropCount, oldDropBytes := metrics.DropCount, metrics.DropBytes
+	oldForwardCount, oldForwardBytes := metrics.ForwardCount, metrics.ForwardBytes
+
+	h := &harness{
+		m:            newFakeMap(),
+		dropCount:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "drop_count"}, []string{"reason", "direction", "location"}),
+		dropBytes:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: "drop_bytes"}, []string{"reason", "direction", "location"}),
+		forwardCount: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "forward_count"}, []string{"direction"}),
+		forwardBytes: prometheus.NewCounterVec(prometheus.CounterOpts{Name: "forward_bytes"}, []string{"direction"}),
+		restore: func() {
+			metricsMap = oldMap
+			metrics.DropCount, metrics.DropBytes = oldDropCount, oldDropBytes
+			metrics.ForwardCount, metrics.ForwardBytes = oldForwardCount, oldForwardBytes
+		},
+	}
+
+	metricsMap = h.m
+	metrics.DropCount, metrics.DropBytes = h.dropCount, h.dropBytes
+	metrics.ForwardCount, metrics.ForwardBytes = h.forwardCount, h.forwardBytes
+
+	return h
+}
+
+func (h *harness) sync(c *C) {
+	c.Assert(SyncMetricsMap(context.Background()), IsNil)
+}
+
+// drops returns the drop count and bytes for reason and dir without a
+// source location
+func (h *harness) drops(reason, dir uint8) (float64, float64) {
+	return h.dropsAt(reason, dir, "")
+}
+
+// dropsAt returns the drop count and bytes for reason and dir at location
+func (h *harness) dropsAt(reason, dir uint8, location string) (float64, float64) {
+	labels := []string{monitorAPI.DropReason(reason), MetricDirection(dir), location}
+	return metrics.GetCounterValue(h.dropCount.WithLabelValues(labels...)),
+		metrics.GetCounterValue(h.dropBytes.WithLabelValues(labels...))
+}
+
+// forwards returns the forward count and bytes for dir
+func (h *harness) forwards(dir uint8) (float64, float64) {
+	return metrics.GetCounterValue(h.forwardCount.WithLabelValues(MetricDirection(dir))),
+		metrics.GetCounterValue(h.forwardBytes.WithLabelValues(MetricDirection(dir)))
+}
+
+func (m *MetricsMapTestSuite) TestSyncMetricsMapAggregation(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	dropReason := monitorAPI.DropMin + 1
+	h.m.set(0, dirIngress, Value{Count: 1, Bytes: 100}, Value{Count: 0, Bytes: 0}, Value{Count: 2, Bytes: 200})
+	h.m.set(0, dirEgress, Value{Count: 0, Bytes: 0}, Value{Count: 4, Bytes: 400}, Value{Count: 0, Bytes: 0})
+	h.m.set(dropReason, dirIngress, Value{Count: 3, Bytes: 30}, Value{Count: 5, Bytes: 50}, Value{Count: 0, Bytes: 0})
+	h.sync(c)
+
+	count, bytes := h.forwards(dirIngress)
+	c.Assert(count, Equals, float64(3))
+	c.Assert(bytes, Equals, float64(300))
+
+	count, bytes = h.forwards(dirEgress)
+	c.Assert(count, Equals, float64(4))
+	c.Assert(bytes, Equals, float64(400))
+
+	count, bytes = h.drops(dropReason, dirIngress)
+	c.Assert(count, Equals, float64(8))
+	c.Assert(bytes, Equals, float64(80))
+}
+
+func (m *MetricsMapTestSuite) TestSyncMetricsMapMonotonic(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	h.m.set(0, dirEgress, Value{Count: 5, Bytes: 500}, Value{Count: 5, Bytes: 500})
+	h.sync(c)
+	count, bytes := h.forwards(dirEgress)
+	c.Assert(count, Equals, float64(10))
+	c.Assert(bytes, Equals, float64(1000))
+
+	// Syncing the same values again must not increase the counters
+	h.sync(c)
+	count, bytes = h.forwards(dirEgress)
+	c.Assert(count, Equals, float64(10))
+	c.Assert(bytes, Equals, float64(1000))
+
+	// Lower values, e.g. after the map was recreated, must not decrease
+	// the counters
+	h.m.set(0, dirEgress, Value{Count: 1, Bytes: 100}, Value{Count: 0, Bytes: 0})
+	h.sync(c)
+	count, bytes = h.forwards(dirEgress)
+	c.Assert(count, Equals, float64(10))
+	c.Assert(bytes, Equals, float64(1000))
+
+	// Growth on a single CPU is reflected in the aggregate
+	h.m.set(0, dirEgress, Value{Count: 5, Bytes: 500}, Value{Count: 7, Bytes: 700})
+	h.sync(c)
+	count, bytes = h.forwards(dirEgress)
+	c.Assert(count, Equals, float64(12))
+	c.Assert(bytes, Equals, float64(1200))
+}
+
+func (m *MetricsMapTestSuite) TestSyncMetricsMapLocation(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	dropReason := monitorAPI.DropMin + 1
+	h.m.entries[Key{Reason: dropReason, Dir: dirIngress, Line: 120, File: 1}] = Values{{Count: 2, Bytes: 20}}
+	h.m.entries[Key{Reason: dropReason, Dir: dirIngress, Line: 340, File: 2}] = Values{{Count: 3, Bytes: 30}}
+	h.m.entries[Key{Reason: dropReason, Dir: dirIngress, Line: 10, File: 200}] = Values{{Count: 1, Bytes: 10}}
+	h.sync(c)
+
+	count, bytes := h.dropsAt(dropReason, dirIngress, "bpf_lxc.c:120")
+	c.Assert(count, Equals, float64(2))
+	c.Assert(bytes, Equals, float64(20))
+
+	count, bytes = h.dropsAt(dropReason, dirIngress, "bpf_netdev.c:340")
+	c.Assert(count, Equals, float64(3))
+	c.Assert(bytes, Equals, float64(30))
+
+	count, _ = h.dropsAt(dropReason, dirIngress, "200:10")
+	c.Assert(count, Equals, float64(1))
+}
+
+func (m *MetricsMapTestSuite) TestFlush(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	syncMutex.Lock()
+	lastSync = time.Time{}
+	syncMutex.Unlock()
+
+	h.m.set(0, dirIngress, Value{Count: 1, Bytes: 100})
+	c.Assert(Flush(context.Background()), IsNil)
+	count, _ := h.forwards(dirIngress)
+	c.Assert(count, Equals, float64(1))
+
+	// A flush right after a sync does not sync again
+	h.m.set(0, dirIngress, Value{Count: 2, Bytes: 200})
+	c.Assert(Flush(context.Background()), IsNil)
+	count, _ = h.forwards(dirIngress)
+	c.Assert(count, Equals, float64(1))
+
+	syncMutex.Lock()
+	lastSync = time.Now().Add(-flushMinAge)
+	syncMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(Flush(ctx), Equals, context.Canceled)
+
+	c.Assert(Flush(context.Background()), IsNil)
+	count, _ = h.forwards(dirIngress)
+	c.Assert(count, Equals, float64(2))
+}
+
+func (m *MetricsMapTestSuite) TestReset(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	dropReason := monitorAPI.DropMin + 1
+	h.m.set(0, dirIngress, Value{Count: 1, Bytes: 100})
+	h.m.set(dropReason, dirIngress, Value{Count: 3, Bytes: 30}, Value{Count: 5, Bytes: 50})
+	h.m.entries[Key{Reason: dropReason, Dir: dirIngress, Line: 10, File: 1}] = Values{{Count: 2, Bytes: 20}}
+	h.m.set(dropReason, dirEgress, Value{Count: 4, Bytes: 40})
+	h.sync(c)
+
+	// All locations of the reason and direction are reset
+	n, err := Reset(dropReason, dirIngress)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	count, bytes := h.drops(dropReason, dirIngress)
+	c.Assert(count, Equals, float64(0))
+	c.Assert(bytes, Equals, float64(0))
+	count, _ = h.dropsAt(dropReason, dirIngress, "bpf_lxc.c:10")
+	c.Assert(count, Equals, float64(0))
+	count, _ = h.drops(dropReason, dirEgress)
+	c.Assert(count, Equals, float64(4))
+
+	// Drops after the reset are counted from zero
+	h.m.set(dropReason, dirIngress, Value{Count: 1, Bytes: 10})
+	h.sync(c)
+	count, bytes = h.drops(dropReason, dirIngress)
+	c.Assert(count, Equals, float64(1))
+	c.Assert(bytes, Equals, float64(10))
+
+	n, err = ResetAll()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 4)
+	h.sync(c)
+
+	count, _ = h.forwards(dirIngress)
+	c.Assert(count, Equals, float64(0))
+	count, _ = h.drops(dropReason, dirEgress)
+	c.Assert(count, Equals, float64(0))
+}
+
+func (m *MetricsMapTestSuite) TestDumpPerCPU(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	h.m.set(0, dirIngress, Value{Count: 1, Bytes: 100}, Value{Count: 2, Bytes: 200})
+
+	entries, err := DumpPerCPU()
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []PerCPUEntry{{
+		Reason:    0,
+		Direction: MetricDirection(dirIngress),
+		Values:    Values{{Count: 1, Bytes: 100}, {Count: 2, Bytes: 200}},
+	}})
+}
+
+func (m *MetricsMapTestSuite) TestSummarize(c *C) {
+	f := newFakeMap()
+	f.set(0, dirIngress, Value{Count: 1, Bytes: 100}, Value{Count: 2, Bytes: 200})
+	f.set(0, dirEgress, Value{Count: 3, Bytes: 300})
+	f.set(133, dirIngress, Value{Count: 5, Bytes: 500})
+	f.set(133, dirEgress, Value{Count: 1, Bytes: 100})
+	f.set(130, dirIngress, Value{Count: 2, Bytes: 200})
+	f.set(131, dirIngress, Value{Count: 1, Bytes: 100})
+
+	summary, err := summarize(f, 2)
+	c.Assert(err, IsNil)
+	c.Assert(summary.ForwardedPackets, Equals, uint64(6))
+	c.Assert(summary.ForwardedBytes, Equals, uint64(600))
+	c.Assert(summary.DroppedPackets, Equals, uint64(9))
+	c.Assert(summary.DroppedBytes, Equals, uint64(900))
+
+	// Only the reasons with the most drops are included
+	c.Assert(summary.DropReasons, DeepEquals, map[string]uint64{
+		monitorAPI.DropReason(133): 6,
+		monitorAPI.DropReason(130): 2,
+	})
+
+	summary, err = summarize(newFakeMap(), 2)
+	c.Assert(err, IsNil)
+	c.Assert(summary.DropReasons, IsNil)
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsmap
+
+import (
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/metrics"
+	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
+)
+
+// dropMetrics is the set of metrics labeled with the drop reason
+var dropMetrics = map[string]struct{}{
+	metrics.Namespace + "_drop_count_total": {},
+	metrics.Namespace + "_drop_bytes_total": {},
+}
+
+// AddDropHints sets the remediation hint of all non-zero drop counters in
+// dump according to their drop reason
+func AddDropHints(dump []*models.Metric) {
+	for _, m := range dump {
+		if _, ok := dropMetrics[m.Name]; !ok || m.Value == 0 {
+			continue
+		}
+		m.Hint = monitorAPI.DropReasonHintByName(m.Labels[metrics.LabelReason])
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package metricsmap
+
+import (
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/metrics"
+	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
+
+	. "gopkg.in/check.v1"
+)
+
+func (m *MetricsMapTestSuite) TestAddDropHints(c *C) {
+	dump := []*models.Metric{
+		{
+			Name:   metrics.Namespace + "_drop_count_total",
+			Labels: map[string]string{metrics.LabelReason: monitorAPI.DropReason(133)},
+			Value:  10,
+		},
+		{
+			// Zero counters have no hint
+			Name:   metrics.Namespace + "_drop_bytes_total",
+			Labels: map[string]string{metrics.LabelReason: monitorAPI.DropReason(133)},
+		},
+		{
+			// Unknown reasons have no hint
+			Name:   metrics.Namespace + "_drop_count_total",
+			Labels: map[string]string{metrics.LabelReason: "200"},
+			Value:  1,
+		},
+		{
+			// Other metrics are not modified
+			Name:   metrics.Namespace + "_forward_count_total",
+			Labels: map[string]string{metrics.LabelReason: monitorAPI.DropReason(133)},
+			Value:  1,
+		},
+	}
+
+	AddDropHints(dump)
+	c.Assert(dump[0].Hint, Equals, "check-network-policy")
+	c.Assert(dump[1].Hint, Equals, "")
+	c.Assert(dump[2].Hint, Equals, "")
+	c.Assert(dump[3].Hint, Equals, "")
+}
@@ -16,28 +16,50 @@ package metricsmap
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/cilium/cilium/pkg/bpf"
+	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/metrics"
 	monitorAPI "github.com/cilium/cilium/pkg/monitor/api"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	// Metrics is the bpf metrics map
-	Metrics      *bpf.Map
-	log          = logging.DefaultLogger.WithField(logfields.LogSubsys, "map-metrics")
-	possibleCpus int
+	Metrics *bpf.Map
+	log     = logging.DefaultLogger.WithField(logfields.LogSubsys, "map-metrics")
+
+	// possibleCpus is the number of possible CPUs, access it with
+	// numPossibleCPUs
+	possibleCpus int32
+
+	// readPossibleCPUs returns the current number of possible CPUs
+	readPossibleCPUs = getNumPossibleCPUs
+
+	// syncMutex serializes the syncs of the metrics maps and protects
+	// lastSync and lastCPUCheck
+	syncMutex lock.Mutex
+
+	// lastSync is the time the metrics maps were last synced
+	lastSync time.Time
+
+	// lastCPUCheck is the time the number of possible CPUs was last
+	// checked
+	lastCPUCheck time.Time
 )
 
 const (
@@ -53,6 +75,14 @@ const (
 	dirUnknown = 0
 
 	possibleCPUSysfsPath = "/sys/devices/system/cpu/possible"
+
+	// flushMinAge is the age of the last sync below which Flush does not
+	// sync the metrics maps again
+	flushMinAge = time.Second
+
+	// possibleCPUsCheckInterval is the interval at which the number of
+	// possible CPUs is checked for changes, e.g. after a VM resize
+	possibleCPUsCheckInterval = time.Minute
 )
 
 // direction is the metrics direction i.e ingress (to an endpoint)
@@ -64,10 +94,28 @@ var direction = map[uint8]string{
 	2: "EGRESS",
 }
 
-type pad3uint16 [3]uint16
+// sourceFiles maps the __MAGIC_FILE__ identifiers of the datapath programs
+// and of the headers raising drops to their source file. It must be in sync
+// with the __MAGIC_FILE__ defines in bpf/*.c and bpf/lib/*.h.
+var sourceFiles = map[uint8]string{
+	1:  "bpf_lxc.c",
+	2:  "bpf_netdev.c",
+	3:  "bpf_overlay.c",
+	4:  "bpf_lb.c",
+	5:  "bpf_xdp.c",
+	6:  "bpf_sock.c",
+	7:  "bpf_ipsec.c",
+	8:  "bpf_hostdev_ingress.c",
+	9:  "bpf_network.c",
+	10: "nat.h",
+	11: "arp.h",
+	12: "icmp6.h",
+}
+
+type pad3uint8 [3]uint8
 
 // DeepCopyInto is a deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *pad3uint16) DeepCopyInto(out *pad3uint16) {
+func (in *pad3uint8) DeepCopyInto(out *pad3uint8) {
 	copy(out[:], in[:])
 	return
 }
@@ -76,17 +124,23 @@ func (in *pad3uint16) DeepCopyInto(out *pad3uint16) {
 // +k8s:deepcopy-gen=true
 // +k8s:deepcopy-gen:interfaces=github.com/cilium/cilium/pkg/bpf.MapKey
 type Key struct {
-	Reason   uint8      `align:"reason"`
-	Dir      uint8      `align:"dir"`
-	Reserved pad3uint16 `align:"reserved"`
+	Reason uint8 `align:"reason"`
+	Dir    uint8 `align:"dir"`
+	// Line is the line of the source file the packet was dropped at, 0
+	// if unknown
+	Line uint16 `align:"line"`
+	// File is the __MAGIC_FILE__ identifier of the source file the
+	// packet was dropped at, 0 if unknown
+	File     uint8     `align:"file"`
+	Reserved pad3uint8 `align:"reserved"`
 }
 
 // Value must be in sync with struct metrics_value in <bpf/lib/common.h>
 // +k8s:deepcopy-gen=true
 // +k8s:deepcopy-gen:interfaces=github.com/cilium/cilium/pkg/bpf.MapValue
 type Value struct {
-	Count uint64 `align:"count"`
-	Bytes uint64 `align:"bytes"`
+	Count uint64 `align:"count" json:"count"`
+	Bytes uint64 `align:"bytes" json:"bytes"`
 }
 
 // +k8s:deepcopy-gen=true
@@ -104,12 +158,18 @@ func (vs *Values) DeepCopyMapValue() bpf.MapValue {
 
 // String converts the value into a human readable string format
 func (vs Values) String() string {
-	sumCount, sumBytes := uint64(0), uint64(0)
+	sum := vs.sum()
+	return fmt.Sprintf("count:%d bytes:%d", sum.Count, sum.Bytes)
+}
+
+// sum returns the sum of the values of all CPUs
+func (vs Values) sum() Value {
+	var sum Value
 	for _, v := range vs {
-		sumCount += v.Count
-		sumBytes += v.Bytes
+		sum.Count += v.Count
+		sum.Bytes += v.Bytes
 	}
-	return fmt.Sprintf("count:%d bytes:%d", sumCount, sumBytes)
+	return sum
 }
 
 // GetValuePtr returns the unsafe pointer to the BPF value.
@@ -119,7 +179,24 @@ func (vs *Values) GetValuePtr() unsafe.Pointer {
 
 // String converts the key into a human readable string format
 func (k *Key) String() string {
-	return fmt.Sprintf("reason:%d dir:%d", k.Reason, k.Dir)
+	if k.Line == 0 && k.File == 0 {
+		return fmt.Sprintf("reason:%d dir:%d", k.Reason, k.Dir)
+	}
+	return fmt.Sprintf("reason:%d dir:%d location:%s", k.Reason, k.Dir, k.Location())
+}
+
+// Location returns the source location set by the datapath in the format
+// file:line, or an empty string if the location is unknown. Unknown file
+// identifiers are represented by their numeric value.
+func (k *Key) Location() string {
+	if k.Line == 0 && k.File == 0 {
+		return ""
+	}
+	file, ok := sourceFiles[k.File]
+	if !ok {
+		file = strconv.Itoa(int(k.File))
+	}
+	return fmt.Sprintf("%s:%d", file, k.Line)
 }
 
 // MetricDirection gets the direction in human readable string format
@@ -133,6 +210,18 @@ func MetricDirection(dir uint8) string {
 	return direction[dirUnknown]
 }
 
+// ParseMetricDirection returns the direction represented by the human
+// readable string format returned by MetricDirection
+func ParseMetricDirection(dir string) (uint8, error) {
+	switch strings.ToUpper(dir) {
+	case direction[dirIngress]:
+		return dirIngress, nil
+	case direction[dirEgress]:
+		return dirEgress, nil
+	}
+	return dirUnknown, fmt.Errorf("invalid direction %q", dir)
+}
+
 // Direction gets the direction in human readable string format
 func (k *Key) Direction() string {
 	return MetricDirection(k.Dir)
@@ -204,24 +293,42 @@ func updateMetric(getCounter func() (prometheus.Counter, error), newValue float6
 func updatePrometheusMetrics(key *Key, val *Value) {
 	updateMetric(func() (prometheus.Counter, error) {
 		if key.IsDrop() {
-			return metrics.DropCount.GetMetricWithLabelValues(key.DropForwardReason(), key.Direction())
+			return metrics.DropCount.GetMetricWithLabelValues(key.DropForwardReason(), key.Direction(), key.Location())
 		}
 		return metrics.ForwardCount.GetMetricWithLabelValues(key.Direction())
 	}, val.CountFloat())
 
 	updateMetric(func() (prometheus.Counter, error) {
 		if key.IsDrop() {
-			return metrics.DropBytes.GetMetricWithLabelValues(key.DropForwardReason(), key.Direction())
+			return metrics.DropBytes.GetMetricWithLabelValues(key.DropForwardReason(), key.Direction(), key.Location())
 		}
 		return metrics.ForwardBytes.GetMetricWithLabelValues(key.Direction())
 	}, val.bytesFloat())
 }
 
-// SyncMetricsMap is called periodically to sync off the metrics map by
-// aggregating it into drops (by drop reason and direction) and
-// forwards (by direction) with the prometheus server.
-func SyncMetricsMap(ctx context.Context) error {
-	entry := make([]Value, possibleCpus)
+// entryIterator iterates over and resets the entries of a metrics map
+type entryIterator interface {
+	// forEachEntry calls fn for each key with the per-CPU values
+	// associated with it. The values slice may be reused across
+	// invocations and must not be retained by fn.
+	forEachEntry(fn func(key *Key, values []Value)) error
+
+	// resetEntries zeroes the per-CPU values of all entries matched by
+	// match and returns the keys of the entries reset
+	resetEntries(match func(key *Key) bool) ([]Key, error)
+}
+
+// pinnedMap is the entryIterator of the metrics map pinned by the datapath
+type pinnedMap struct{}
+
+// metricsMap is the metrics map read by SyncMetricsMap and DumpPerCPU. It is
+// replaced by an emulation of the map in unit tests.
+var metricsMap entryIterator = pinnedMap{}
+
+// forEachEntry opens the pinned metrics map and calls fn for each key with
+// the per-CPU values associated with it. The entries are looked up in
+// batches if supported by the kernel.
+func (pinnedMap) forEachEntry(fn func(key *Key, values []Value)) error {
 	file := bpf.MapPath(MapName)
 	metricsmap, err := bpf.OpenMap(file)
 
@@ -230,6 +337,14 @@ func SyncMetricsMap(ctx context.Context) error {
 	}
 	defer metricsmap.Close()
 
+	if ok, err := forEachEntryBatch(metricsmap.GetFd(), fn); ok {
+		if err != nil {
+			return fmt.Errorf("unable to lookup metrics map: %s", err)
+		}
+		return nil
+	}
+
+	entry := make([]Value, numPossibleCPUs())
 	var key, nextKey Key
 	for {
 		err := bpf.GetNextKey(metricsmap.GetFd(), unsafe.Pointer(&key), unsafe.Pointer(&nextKey))
@@ -241,18 +356,145 @@ func SyncMetricsMap(ctx context.Context) error {
 			return fmt.Errorf("unable to lookup metrics map: %s", err)
 		}
 
-		// cannot use `range entry` since, if the first value for a particular
-		// CPU is zero, it never iterates over the next non-zero value.
-		for i := 0; i < possibleCpus; i++ {
-			// Increment Prometheus metrics here.
-			updatePrometheusMetrics(&nextKey, &entry[i])
-		}
+		fn(&nextKey, entry)
 		key = nextKey
 
 	}
 	return nil
 }
 
+// SyncMetricsMap is called periodically to sync off the metrics map by
+// aggregating it into drops (by drop reason and direction) and
+// forwards (by direction) with the prometheus server. All metrics maps
+// registered with RegisterMap are synced as well.
+func SyncMetricsMap(ctx context.Context) error {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	return syncLocked()
+}
+
+// syncLocked syncs all metrics maps. syncMutex must be held.
+func syncLocked() error {
+	if time.Since(lastCPUCheck) >= possibleCPUsCheckInterval {
+		refreshPossibleCPUs()
+		lastCPUCheck = time.Now()
+	}
+
+	syncRegisteredMaps()
+	err := syncMetricsMap(metricsMap)
+	lastSync = time.Now()
+	return err
+}
+
+// Flush syncs the metrics maps unless they have been synced less than
+// flushMinAge ago. Concurrent flushes are coalesced into a single sync.
+// It is called before the prometheus metrics are scraped so that scrapes
+// see values no older than flushMinAge, independent of the sync interval.
+func Flush(ctx context.Context) error {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	if time.Since(lastSync) < flushMinAge {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return syncLocked()
+}
+
+// syncMetricsMap updates the prometheus metrics with the sum of the per-CPU
+// values of each entry of m. The metrics are counters, they are only
+// increased to the aggregated value and never decrease.
+func syncMetricsMap(m entryIterator) error {
+	return m.forEachEntry(func(key *Key, values []Value) {
+		sum := Values(values).sum()
+		updatePrometheusMetrics(key, &sum)
+	})
+}
+
+// PerCPUEntry is a metrics map entry including the value of each possible
+// CPU rather than the sum across all CPUs
+type PerCPUEntry struct {
+	Reason    uint8  `json:"reason"`
+	Direction string `json:"direction"`
+	Location  string `json:"location,omitempty"`
+	// Values holds one value per possible CPU, indexed by CPU number
+	Values Values `json:"values"`
+}
+
+// DumpPerCPU returns all entries of the metrics map with the per-CPU
+// breakdown of the values. Unlike the aggregated prometheus metrics, this
+// allows to diagnose an imbalance of traffic across CPUs.
+func DumpPerCPU() ([]PerCPUEntry, error) {
+	entries := []PerCPUEntry{}
+	err := metricsMap.forEachEntry(func(key *Key, values []Value) {
+		entry := PerCPUEntry{
+			Reason:    key.Reason,
+			Direction: key.Direction(),
+			Location:  key.Location(),
+			Values:    make(Values, len(values)),
+		}
+		copy(entry.Values, values)
+		entries = append(entries, entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DebugStatus returns the per-CPU content of the metrics map serialized as
+// JSON. It is registered as debug.StatusFunc to be included in debuginfo.
+func DebugStatus() string {
+	entries, err := DumpPerCPU()
+	if err != nil {
+		return fmt.Sprintf("error: %s", err)
+	}
+	out, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Sprintf("error: %s", err)
+	}
+	return string(out)
+}
+
+// numPossibleCPUs returns the number of possible CPUs the per-CPU values
+// of the metrics map are sized for
+func numPossibleCPUs() int {
+	return int(atomic.LoadInt32(&possibleCpus))
+}
+
+// PossibleCPUs returns the number of possible CPUs the per-CPU values of the
+// metrics maps are sized for. It is updated by SyncMetricsMap if the number
+// of possible CPUs changes.
+func PossibleCPUs() int {
+	return numPossibleCPUs()
+}
+
+// refreshPossibleCPUs re-reads the number of possible CPUs and, if it
+// changed, resizes the per-CPU values of the metrics map accordingly.
+// Returns true if the number of possible CPUs changed.
+func refreshPossibleCPUs() bool {
+	cpus := readPossibleCPUs()
+	old := numPossibleCPUs()
+	if cpus <= 0 || cpus == old {
+		return false
+	}
+
+	log.WithFields(logrus.Fields{
+		"old": old,
+		"new": cpus,
+	}).Info("Number of possible CPUs changed, resizing metrics map values")
+
+	atomic.StoreInt32(&possibleCpus, int32(cpus))
+	vs := make(Values, cpus)
+	Metrics.SetPerCPUValue(&vs, cpus)
+	return true
+}
+
 // getNumPossibleCPUs returns a total number of possible CPUS, i.e. CPUs that
 // have been allocated resources and can be brought online if they are present.
 // The number is retrieved by parsing /sys/device/system/cpu/possible.
@@ -299,9 +541,10 @@ func getNumPossibleCPUsFromReader(r io.Reader) int {
 }
 
 func init() {
-	possibleCpus = getNumPossibleCPUs()
+	cpus := getNumPossibleCPUs()
+	atomic.StoreInt32(&possibleCpus, int32(cpus))
 
-	vs := make(Values, possibleCpus)
+	vs := make(Values, cpus)
 
 	// Metrics is a mapping of all packet drops and forwards associated with
 	// the node on ingress/egress direction
@@ -311,7 +554,7 @@ func init() {
 		int(unsafe.Sizeof(Key{})),
 		&vs,
 		int(unsafe.Sizeof(Value{})),
-		possibleCpus,
+		cpus,
 		MaxEntries,
 		0, 0,
 		bpf.ConvertKeyValue,
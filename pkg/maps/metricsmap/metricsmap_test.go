@@ -17,8 +17,11 @@
 package metricsmap
 
 import (
+	"encoding/json"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"unsafe"
 
 	. "gopkg.in/check.v1"
 )
@@ -48,3 +51,71 @@ func (m *MetricsMapTestSuite) TestGetNumPossibleCPUsFromReader(c *C) {
 	}
 
 }
+
+func (m *MetricsMapTestSuite) TestPerCPUEntryJSON(c *C) {
+	entry := PerCPUEntry{
+		Reason:    1,
+		Direction: MetricDirection(dirEgress),
+		Values:    Values{{Count: 1, Bytes: 100}, {Count: 0, Bytes: 0}},
+	}
+
+	out, err := json.Marshal(entry)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals,
+		`{"reason":1,"direction":"EGRESS","values":[{"count":1,"bytes":100},{"count":0,"bytes":0}]}`)
+}
+
+func (m *MetricsMapTestSuite) TestKeyLocation(c *C) {
+	k := &Key{Reason: 133, Dir: dirIngress}
+	c.Assert(k.Location(), Equals, "")
+	c.Assert(k.String(), Equals, "reason:133 dir:1")
+
+	k = &Key{Reason: 133, Dir: dirIngress, Line: 1024, File: 3}
+	c.Assert(k.Location(), Equals, "bpf_overlay.c:1024")
+	c.Assert(k.String(), Equals, "reason:133 dir:1 location:bpf_overlay.c:1024")
+
+	// Drops raised in headers are attributed to the header
+	k = &Key{Reason: 133, Dir: dirIngress, Line: 957, File: 10}
+	c.Assert(k.Location(), Equals, "nat.h:957")
+}
+
+func (m *MetricsMapTestSuite) TestParseMetricDirection(c *C) {
+	for _, dir := range []uint8{dirIngress, dirEgress} {
+		parsed, err := ParseMetricDirection(MetricDirection(dir))
+		c.Assert(err, IsNil)
+		c.Assert(parsed, Equals, dir)
+	}
+
+	parsed, err := ParseMetricDirection("egress")
+	c.Assert(err, IsNil)
+	c.Assert(parsed, Equals, uint8(dirEgress))
+
+	_, err = ParseMetricDirection("UNKNOWN")
+	c.Assert(err, Not(IsNil))
+}
+
+func (m *MetricsMapTestSuite) TestRefreshPossibleCPUs(c *C) {
+	oldRead, oldCPUs := readPossibleCPUs, numPossibleCPUs()
+	oldValue, oldReadSize := Metrics.MapValue, Metrics.ReadValueSize
+	defer func() {
+		readPossibleCPUs = oldRead
+		Metrics.SetPerCPUValue(oldValue, oldCPUs)
+		c.Assert(Metrics.ReadValueSize, Equals, oldReadSize)
+		atomic.StoreInt32(&possibleCpus, int32(oldCPUs))
+	}()
+
+	cpus := oldCPUs
+	readPossibleCPUs = func() int { return cpus }
+	c.Assert(refreshPossibleCPUs(), Equals, false)
+
+	// Failing to read the number of CPUs keeps the current size
+	cpus = 0
+	c.Assert(refreshPossibleCPUs(), Equals, false)
+	c.Assert(numPossibleCPUs(), Equals, oldCPUs)
+
+	cpus = oldCPUs + 4
+	c.Assert(refreshPossibleCPUs(), Equals, true)
+	c.Assert(numPossibleCPUs(), Equals, cpus)
+	c.Assert(Metrics.ReadValueSize, Equals, uint32(cpus)*uint32(unsafe.Sizeof(Value{})))
+	c.Assert(*Metrics.MapValue.(*Values), HasLen, cpus)
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsmap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/cilium/pkg/bpf"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MapDumper is implemented by BPF maps which can be iterated over, e.g.
+// *bpf.Map
+type MapDumper interface {
+	// DumpWithCallbackIfExists calls cb for each entry of the map. It
+	// returns without error if the map does not exist.
+	DumpWithCallbackIfExists(cb bpf.DumpCallback) error
+}
+
+// CounterMapping exports one value of each entry of a registered metrics map
+// to a prometheus counter
+type CounterMapping struct {
+	// Counter is the counter the value is exported to. It must have the
+	// labels returned by the Labels function of the registered map.
+	Counter metrics.CounterVec
+
+	// Value extracts the value from a map value, e.g. by summing the
+	// values of all CPUs of a per-CPU map
+	Value func(value bpf.MapValue) float64
+}
+
+// RegisteredMap is a BPF metrics map of a datapath feature which is
+// scraped and exported by SyncMetricsMap along with the metrics map
+type RegisteredMap struct {
+	// Map is the BPF map holding the metrics. Keys and values are
+	// decoded by the DumpParser of the map.
+	Map MapDumper
+
+	// Labels maps a key of the map to the label values of the exported
+	// counters. Entries mapping to the same label values are summed up.
+	Labels func(key bpf.MapKey) []string
+
+	// Counters are the counters exported for each entry
+	Counters []CounterMapping
+}
+
+var (
+	// registeredMapsMutex protects registeredMaps
+	registeredMapsMutex lock.Mutex
+
+	// registeredMaps is the set of registered metrics maps indexed by
+	// name
+	registeredMaps = map[string]RegisteredMap{}
+)
+
+// RegisterMap registers the metrics map m of a datapath feature under the
+// given name. All registered maps are scraped and exported periodically
+// along with the metrics map. Returns an error if a map with the same name
+// is already registered or if m is incomplete.
+func RegisterMap(name string, m RegisteredMap) error {
+	if m.Map == nil || m.Labels == nil || len(m.Counters) == 0 {
+		return fmt.Errorf("metrics map %s must specify a map, labels and counters", name)
+	}
+	for _, c := range m.Counters {
+		if c.Counter == nil || c.Value == nil {
+			return fmt.Errorf("counters of metrics map %s must specify a counter and value", name)
+		}
+	}
+
+	registeredMapsMutex.Lock()
+	defer registeredMapsMutex.Unlock()
+
+	if _, ok := registeredMaps[name]; ok {
+		return fmt.Errorf("metrics map %s is already registered", name)
+	}
+	registeredMaps[name] = m
+	return nil
+}
+
+// UnregisterMap removes the metrics map registered under the given name.
+// The counters exported so far are left intact.
+func UnregisterMap(name string) {
+	registeredMapsMutex.Lock()
+	delete(registeredMaps, name)
+	registeredMapsMutex.Unlock()
+}
+
+// labeledSum is the sum of the values of all entries of a registered map
+// mapping to the same label values
+type labeledSum struct {
+	labels []string
+	value  float64
+}
+
+// syncRegisteredMap updates the counters of m with the sums of the values of
+// all entries mapping to the same label values
+func syncRegisteredMap(m RegisteredMap) error {
+	sums := make([]map[string]*labeledSum, len(m.Counters))
+	for i := range sums {
+		sums[i] = map[string]*labeledSum{}
+	}
+
+	err := m.Map.DumpWithCallbackIfExists(func(key bpf.MapKey, value bpf.MapValue) {
+		labels := m.Labels(key)
+		id := strings.Join(labels, "\x00")
+		for i, c := range m.Counters {
+			sum, ok := sums[i][id]
+			if !ok {
+				sum = &labeledSum{labels: labels}
+				sums[i][id] = sum
+			}
+			sum.value += c.Value(value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, c := range m.Counters {
+		counter := c.Counter
+		for _, sum := range sums[i] {
+			labels := sum.labels
+			updateMetric(func() (prometheus.Counter, error) {
+				return counter.GetMetricWithLabelValues(labels...)
+			}, sum.value)
+		}
+	}
+
+	return nil
+}
+
+// syncRegisteredMaps updates the counters of all registered metrics maps.
+// Failures to read a map are logged and do not affect other maps.
+func syncRegisteredMaps() {
+	registeredMapsMutex.Lock()
+	maps := make(map[string]RegisteredMap, len(registeredMaps))
+	for name, m := range registeredMaps {
+		maps[name] = m
+	}
+	registeredMapsMutex.Unlock()
+
+	for name, m := range maps {
+		if err := syncRegisteredMap(m); err != nil {
+			log.WithError(err).WithField("map", name).Warn("Unable to sync registered metrics map")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package metricsmap
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/cilium/cilium/pkg/bpf"
+	"github.com/cilium/cilium/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "gopkg.in/check.v1"
+)
+
+// fakeDumper is a registered metrics map with fixed entries
+type fakeDumper struct {
+	entries map[Key]Value
+	err     error
+}
+
+func (f *fakeDumper) DumpWithCallbackIfExists(cb bpf.DumpCallback) error {
+	for key, value := range f.entries {
+		k, v := key, value
+		cb(&k, &v)
+	}
+	return f.err
+}
+
+func (m *MetricsMapTestSuite) TestRegisteredMaps(c *C) {
+	h := newHarness()
+	defer h.restore()
+
+	packets := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "nat_packets"}, []string{"dir"})
+	bytes := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "nat_bytes"}, []string{"dir"})
+	dumper := &fakeDumper{entries: map[Key]Value{
+		{Reason: 1, Dir: dirIngress}: {Count: 1, Bytes: 10},
+		{Reason: 2, Dir: dirIngress}: {Count: 2, Bytes: 20},
+		{Reason: 1, Dir: dirEgress}:  {Count: 4, Bytes: 40},
+	}}
+	registered := RegisteredMap{
+		Map: dumper,
+		Labels: func(key bpf.MapKey) []string {
+			return []string{strconv.Itoa(int(key.(*Key).Dir))}
+		},
+		Counters: []CounterMapping{
+			{Counter: packets, Value: func(v bpf.MapValue) float64 { return v.(*Value).CountFloat() }},
+			{Counter: bytes, Value: func(v bpf.MapValue) float64 { return v.(*Value).bytesFloat() }},
+		},
+	}
+
+	c.Assert(RegisterMap("nat", RegisteredMap{Map: dumper}), Not(IsNil))
+	c.Assert(RegisterMap("nat", registered), IsNil)
+	defer UnregisterMap("nat")
+	c.Assert(RegisterMap("nat", registered), Not(IsNil))
+
+	// Entries mapping to the same labels are summed up
+	h.sync(c)
+	ingress := strconv.Itoa(dirIngress)
+	c.Assert(metrics.GetCounterValue(packets.WithLabelValues(ingress)), Equals, float64(3))
+	c.Assert(metrics.GetCounterValue(bytes.WithLabelValues(ingress)), Equals, float64(30))
+	c.Assert(metrics.GetCounterValue(packets.WithLabelValues(strconv.Itoa(dirEgress))), Equals, float64(4))
+
+	// Failures to read a registered map do not fail the sync of the
+	// metrics map
+	dumper.err = errors.New("map read failure")
+	h.sync(c)
+
+	UnregisterMap("nat")
+	c.Assert(RegisterMap("nat", registered), IsNil)
+}
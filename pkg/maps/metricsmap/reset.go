@@ -0,0 +1,101 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsmap
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/cilium/cilium/pkg/bpf"
+	"github.com/cilium/cilium/pkg/metrics"
+)
+
+// resetEntries zeroes the per-CPU values of all entries of the pinned
+// metrics map matched by match and returns their keys
+func (p pinnedMap) resetEntries(match func(key *Key) bool) ([]Key, error) {
+	keys := []Key{}
+	err := p.forEachEntry(func(key *Key, values []Value) {
+		if match(key) {
+			keys = append(keys, *key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	metricsmap, err := bpf.OpenMap(bpf.MapPath(MapName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to open metrics map: %s", err)
+	}
+	defer metricsmap.Close()
+
+	zero := make([]Value, numPossibleCPUs())
+	for i := range keys {
+		err := bpf.UpdateElement(metricsmap.GetFd(), unsafe.Pointer(&keys[i]),
+			unsafe.Pointer(&zero[0]), bpf.BPF_EXIST)
+		if err != nil {
+			return keys[:i], fmt.Errorf("unable to reset metrics map entry %s: %s", keys[i].String(), err)
+		}
+	}
+
+	return keys, nil
+}
+
+// deletePrometheusMetrics deletes the prometheus metrics associated with key
+// so that they restart from zero with the next sync
+func deletePrometheusMetrics(key *Key) {
+	if key.IsDrop() {
+		metrics.DropCount.DeleteLabelValues(key.DropForwardReason(), key.Direction(), key.Location())
+		metrics.DropBytes.DeleteLabelValues(key.DropForwardReason(), key.Direction(), key.Location())
+	} else {
+		metrics.ForwardCount.DeleteLabelValues(key.Direction())
+		metrics.ForwardBytes.DeleteLabelValues(key.Direction())
+	}
+}
+
+// reset zeroes all entries of the metrics map matched by match along with the
+// corresponding prometheus metrics. Returns the number of entries reset.
+func reset(match func(key *Key) bool) (int, error) {
+	// Hold the sync lock so that a concurrent sync cannot re-create the
+	// prometheus metrics from the values before the reset
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	keys, err := metricsMap.resetEntries(match)
+	for i := range keys {
+		deletePrometheusMetrics(&keys[i])
+	}
+
+	return len(keys), err
+}
+
+// Reset zeroes the entries of the metrics map with the given reason and
+// direction, regardless of the datapath location, along with the
+// corresponding prometheus metrics. Returns the number of entries reset.
+func Reset(reason, dir uint8) (int, error) {
+	return reset(func(key *Key) bool {
+		return key.Reason == reason && key.Dir == dir
+	})
+}
+
+// ResetAll zeroes all entries of the metrics map along with the corresponding
+// prometheus metrics. Returns the number of entries reset.
+func ResetAll() (int, error) {
+	return reset(func(key *Key) bool { return true })
+}
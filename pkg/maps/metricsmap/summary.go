@@ -0,0 +1,86 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsmap
+
+import (
+	"sort"
+)
+
+// Summary is a compact summary of the drops and forwards of the metrics map,
+// aggregated across all CPUs and directions
+type Summary struct {
+	// ForwardedPackets is the number of forwarded packets
+	ForwardedPackets uint64 `json:"forwarded-packets"`
+	// ForwardedBytes is the number of forwarded bytes
+	ForwardedBytes uint64 `json:"forwarded-bytes"`
+	// DroppedPackets is the number of dropped packets
+	DroppedPackets uint64 `json:"dropped-packets"`
+	// DroppedBytes is the number of dropped bytes
+	DroppedBytes uint64 `json:"dropped-bytes"`
+	// DropReasons is the number of dropped packets by drop reason, limited
+	// to the reasons with the most drops
+	DropReasons map[string]uint64 `json:"drop-reasons,omitempty"`
+}
+
+// Summarize returns the summary of the metrics map. DropReasons holds at most
+// maxDropReasons reasons, those with the most dropped packets.
+func Summarize(maxDropReasons int) (*Summary, error) {
+	return summarize(metricsMap, maxDropReasons)
+}
+
+func summarize(m entryIterator, maxDropReasons int) (*Summary, error) {
+	summary := &Summary{}
+	reasons := map[string]uint64{}
+
+	err := m.forEachEntry(func(key *Key, values []Value) {
+		sum := Values(values).sum()
+		if key.IsDrop() {
+			summary.DroppedPackets += sum.Count
+			summary.DroppedBytes += sum.Bytes
+			if sum.Count > 0 {
+				reasons[key.DropForwardReason()] += sum.Count
+			}
+		} else {
+			summary.ForwardedPackets += sum.Count
+			summary.ForwardedBytes += sum.Bytes
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(reasons))
+	for name := range reasons {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if reasons[names[i]] != reasons[names[j]] {
+			return reasons[names[i]] > reasons[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > maxDropReasons {
+		names = names[:maxDropReasons]
+	}
+
+	if len(names) > 0 {
+		summary.DropReasons = make(map[string]uint64, len(names))
+		for _, name := range names {
+			summary.DropReasons[name] = reasons[name]
+		}
+	}
+
+	return summary, nil
+}
@@ -23,6 +23,7 @@ type CounterVec interface {
 	WithLabelValues(lvls ...string) prometheus.Counter
 	GetMetricWithLabelValues(lvs ...string) (prometheus.Counter, error)
 	With(labels prometheus.Labels) prometheus.Counter
+	DeleteLabelValues(lvs ...string) bool
 	prometheus.Collector
 }
 
@@ -79,6 +80,8 @@ func (cv *counterVec) GetMetricWithLabelValues(lvs ...string) (prometheus.Counte
 	return NoOpCounter, nil
 }
 
+func (cv *counterVec) DeleteLabelValues(lvs ...string) bool { return false }
+
 func (cv *counterVec) With(labels prometheus.Labels) prometheus.Counter { return NoOpCounter }
 
 // Observer
@@ -22,6 +22,7 @@ package metrics
 // - Register the new object in the init function
 
 import (
+	"context"
 	"net/http"
 	"syscall"
 
@@ -71,6 +72,9 @@ const (
 	// names and separated with a '_'
 	Namespace = "cilium"
 
+	// LabelReason is the reason an operation was performed
+	LabelReason = "reason"
+
 	// LabelOutcome indicates whether the outcome of the operation was successful or not
 	LabelOutcome = "outcome"
 
@@ -155,8 +159,19 @@ const (
 	// LabelOperation is the label for BPF maps operations
 	LabelOperation = "operation"
 
+	// LabelOperationClass is the label for the class of kvstore operations
+	// subject to rate limiting
+	LabelOperationClass = "class"
+
 	// LabelMapName is the label for the BPF map name
 	LabelMapName = "mapName"
+
+	// LabelState is the label for the state of an object
+	LabelState = "state"
+
+	// LabelKVStorePrefix is the label for the kvstore prefix of an
+	// allocator cache
+	LabelKVStorePrefix = "prefix"
 )
 
 var (
@@ -259,6 +274,11 @@ var (
 	// by error, protocol and span time
 	ProxyUpstreamTime = NoOpObserverVec
 
+	// ProxyL7VerdictLatency is the time between the first byte of a request
+	// being received by a proxylib parser and its verdict being delivered,
+	// labeled by L7 protocol
+	ProxyL7VerdictLatency = NoOpObserverVec
+
 	// L3-L4 statistics
 
 	// DropCount is the total drop requests,
@@ -277,6 +297,22 @@ var (
 	// tagged by ingress/egress direction
 	ForwardBytes = NoOpCounterVec
 
+	// EndpointDropCount is the total drop requests of each endpoint,
+	// tagged by endpoint, drop reason and direction(ingress/egress)
+	EndpointDropCount = NoOpCounterVec
+
+	// EndpointDropBytes is the total dropped bytes of each endpoint,
+	// tagged by endpoint, drop reason and direction(ingress/egress)
+	EndpointDropBytes = NoOpCounterVec
+
+	// EndpointForwardCount is the total forwarded packets of each endpoint,
+	// tagged by endpoint and ingress/egress direction
+	EndpointForwardCount = NoOpCounterVec
+
+	// EndpointForwardBytes is the total forwarded bytes of each endpoint,
+	// tagged by endpoint and ingress/egress direction
+	EndpointForwardBytes = NoOpCounterVec
+
 	// Datapath statistics
 
 	// DatapathErrors is the number of errors managing datapath components
@@ -339,6 +375,44 @@ var (
 	// complete a CNP status update
 	KubernetesCNPStatusCompletion = NoOpObserverVec
 
+	// KubernetesNodeEventHandlerDuration is the duration in seconds of
+	// the handling of Kubernetes node events labeled by action
+	KubernetesNodeEventHandlerDuration = NoOpObserverVec
+
+	// KubernetesNodeResyncs is the number of Kubernetes nodes resynced
+	// labeled by reason
+	KubernetesNodeResyncs = NoOpCounterVec
+
+	// Nodes
+
+	// NodeReregistrations is the number of times the local node was found
+	// missing in the kvstore and re-registered
+	NodeReregistrations = NoOpCounter
+
+	// NodeValidationFailures is the number of nodes received from
+	// Kubernetes or the kvstore which failed validation, labeled by
+	// reason and the action taken
+	NodeValidationFailures = NoOpCounterVec
+
+	// NodeStoreNodes is the number of nodes known to the node store
+	NodeStoreNodes = NoOpGauge
+
+	// NodeStoreEvents is the number of node events received by the node
+	// store labeled by action
+	NodeStoreEvents = NoOpCounterVec
+
+	// NodeStoreEventDuration is the duration in seconds of the processing
+	// of node events by the node store labeled by action
+	NodeStoreEventDuration = NoOpObserverVec
+
+	// NodeStorePendingDeletions is the number of node deletions delayed
+	// by the node store
+	NodeStorePendingDeletions = NoOpGauge
+
+	// NodeStoreIPCacheFailures is the number of ipcache upserts of node
+	// entries which failed
+	NodeStoreIPCacheFailures = NoOpCounter
+
 	// IPAM events
 
 	// IpamEvent is the number of IPAM events received labeled by action and
@@ -350,10 +424,73 @@ var (
 	// KVStoreOperationsDuration records the duration of kvstore operations
 	KVStoreOperationsDuration = NoOpObserverVec
 
+	// KVStoreOperationsInflight is the number of kvstore operations in
+	// flight, labeled by operation
+	KVStoreOperationsInflight = NoOpGaugeVec
+
+	// KVStoreOperationErrors is the number of failed kvstore operations,
+	// labeled by scope and operation
+	KVStoreOperationErrors = NoOpCounterVec
+
+	// KVStoreLeaseRemainingTTL is the time in seconds until the kvstore
+	// lease shared by all lease-protected keys of the agent expires unless
+	// renewed
+	KVStoreLeaseRemainingTTL = NoOpGauge
+
+	// KVStoreRateLimitWait records the duration in seconds kvstore
+	// operations were throttled by the client-side rate limiter, labeled
+	// by operation class
+	KVStoreRateLimitWait = NoOpObserverVec
+
+	// KVStoreLockWait records the duration in seconds spent waiting for
+	// kvstore locks, labeled by scope, operation class and outcome
+	KVStoreLockWait = NoOpObserverVec
+
 	// KVStoreEventsQueueDuration records the duration in seconds of time
 	// received event was blocked before it could be queued
 	KVStoreEventsQueueDuration = NoOpObserverVec
 
+	// KVStoreAllocatorCacheRepairs is the number of times an allocator
+	// cache was found to be diverged from the kvstore and was repaired
+	KVStoreAllocatorCacheRepairs = NoOpCounterVec
+
+	// KVStoreAllocatorPoolIDs is the number of IDs of an allocator ID
+	// space labeled by state
+	KVStoreAllocatorPoolIDs = NoOpGaugeVec
+
+	// KVStoreAllocatorPoolLargestFreeRange is the size of the largest
+	// range of consecutive available IDs of an allocator ID space
+	KVStoreAllocatorPoolLargestFreeRange = NoOpGaugeVec
+
+	// KVStoreAllocatorLocalKeySyncDuration is the duration of the periodic
+	// synchronization of locally used allocator keys with the kvstore
+	KVStoreAllocatorLocalKeySyncDuration = NoOpObserverVec
+
+	// KVStoreAllocatorLocalKeysRecreated is the number of allocator keys
+	// found missing in the kvstore and re-created by the local key sync
+	KVStoreAllocatorLocalKeysRecreated = NoOpCounterVec
+
+	// KVStoreAllocatorQuarantineIDs is the number of released allocator
+	// IDs held in quarantine before they can be reused
+	KVStoreAllocatorQuarantineIDs = NoOpGaugeVec
+
+	// KVStoreAllocatorQuarantineOldest is the age in seconds of the
+	// oldest allocator ID held in quarantine
+	KVStoreAllocatorQuarantineOldest = NoOpGaugeVec
+
+	// KVStoreAllocatorRetriesShed is the number of allocator allocations
+	// which gave up because the retry budget was exhausted
+	KVStoreAllocatorRetriesShed = NoOpCounterVec
+
+	// KVStoreOversizedValues is the number of kvstore values exceeding the
+	// maximum value size which have been rejected or quarantined
+	KVStoreOversizedValues = NoOpCounterVec
+
+	// KVStoreStoreReconciledEntries is the number of shared store entries
+	// missed by the kvstore watcher and repaired by the periodic
+	// reconciliation, labeled by store and action
+	KVStoreStoreReconciledEntries = NoOpCounterVec
+
 	// FQDNGarbageCollectorCleanedTotal is the number of domains cleaned by the
 	// GC job.
 	FQDNGarbageCollectorCleanedTotal = NoOpCounter
@@ -389,10 +526,15 @@ type Configuration struct {
 	ProxyDeniedEnabled                      bool
 	ProxyReceivedEnabled                    bool
 	NoOpObserverVecEnabled                  bool
+	ProxyL7VerdictLatencyEnabled            bool
 	DropCountEnabled                        bool
 	DropBytesEnabled                        bool
 	NoOpCounterVecEnabled                   bool
 	ForwardBytesEnabled                     bool
+	EndpointDropCountEnabled                bool
+	EndpointDropBytesEnabled                bool
+	EndpointForwardCountEnabled             bool
+	EndpointForwardBytesEnabled             bool
 	DatapathErrorsEnabled                   bool
 	ConntrackGCRunsEnabled                  bool
 	ConntrackGCKeyFallbacksEnabled          bool
@@ -408,9 +550,29 @@ type Configuration struct {
 	KubernetesAPIInteractionsEnabled        bool
 	KubernetesAPICallsEnabled               bool
 	KubernetesCNPStatusCompletionEnabled    bool
+	KubernetesNodeEventHandlerEnabled       bool
+	KubernetesNodeResyncsEnabled            bool
+	NodeReregistrationsEnabled              bool
+	NodeValidationFailuresEnabled           bool
+	NodeStoreNodesEnabled                   bool
+	NodeStoreEventsEnabled                  bool
+	NodeStoreEventDurationEnabled           bool
+	NodeStorePendingDeletionsEnabled        bool
+	NodeStoreIPCacheFailuresEnabled         bool
 	IpamEventEnabled                        bool
 	KVStoreOperationsDurationEnabled        bool
 	KVStoreEventsQueueDurationEnabled       bool
+	KVStoreOperationsInflightEnabled        bool
+	KVStoreRateLimitWaitEnabled             bool
+	KVStoreLockWaitEnabled                  bool
+	KVStoreLeaseRemainingTTLEnabled         bool
+	KVStoreAllocatorCacheRepairsEnabled     bool
+	KVStoreAllocatorPoolEnabled             bool
+	KVStoreAllocatorLocalKeySyncEnabled     bool
+	KVStoreAllocatorQuarantineEnabled       bool
+	KVStoreAllocatorRetriesShedEnabled      bool
+	KVStoreOversizedValuesEnabled           bool
+	KVStoreStoreReconciledEntriesEnabled    bool
 	FQDNGarbageCollectorCleanedTotalEnabled bool
 	BPFSyscallDurationEnabled               bool
 	BPFMapOps                               bool
@@ -438,6 +600,7 @@ func DefaultMetrics() map[string]struct{} {
 		Namespace + "_policy_l7_denied_total":                                     {},
 		Namespace + "_policy_l7_received_total":                                   {},
 		Namespace + "_proxy_upstream_reply_seconds":                               {},
+		Namespace + "_proxy_l7_verdict_latency_seconds":                           {},
 		Namespace + "_drop_count_total":                                           {},
 		Namespace + "_drop_bytes_total":                                           {},
 		Namespace + "_forward_count_total":                                        {},
@@ -457,9 +620,29 @@ func DefaultMetrics() map[string]struct{} {
 		Namespace + "_" + SubsystemK8sClient + "_api_latency_time_seconds":        {},
 		Namespace + "_" + SubsystemK8sClient + "_api_calls_counter":               {},
 		Namespace + "_" + SubsystemK8s + "_cnp_status_completion_seconds":         {},
+		Namespace + "_" + SubsystemK8s + "_node_event_handler_seconds":            {},
+		Namespace + "_" + SubsystemK8s + "_node_resyncs_total":                    {},
+		Namespace + "_" + SubsystemNodes + "_reregistrations_total":               {},
+		Namespace + "_" + SubsystemNodes + "_validation_failures_total":           {},
+		Namespace + "_" + SubsystemNodes + "_store_nodes":                         {},
+		Namespace + "_" + SubsystemNodes + "_store_events_total":                  {},
+		Namespace + "_" + SubsystemNodes + "_store_event_duration_seconds":        {},
+		Namespace + "_" + SubsystemNodes + "_store_pending_deletions":             {},
+		Namespace + "_" + SubsystemNodes + "_store_ipcache_failures_total":        {},
 		Namespace + "_ipam_events_total":                                          {},
 		Namespace + "_" + SubsystemKVStore + "_operations_duration_seconds":       {},
+		Namespace + "_" + SubsystemKVStore + "_operations_inflight":               {},
+		Namespace + "_" + SubsystemKVStore + "_rate_limit_wait_seconds":           {},
+		Namespace + "_" + SubsystemKVStore + "_lock_wait_seconds":                 {},
+		Namespace + "_" + SubsystemKVStore + "_lease_remaining_ttl_seconds":       {},
 		Namespace + "_" + SubsystemKVStore + "_events_queue_seconds":              {},
+		Namespace + "_" + SubsystemKVStore + "_allocator_cache_repairs_total":     {},
+		Namespace + "_" + SubsystemKVStore + "_allocator_pool_ids":                {},
+		Namespace + "_" + SubsystemKVStore + "_allocator_local_key_sync_seconds":  {},
+		Namespace + "_" + SubsystemKVStore + "_allocator_quarantine_ids":          {},
+		Namespace + "_" + SubsystemKVStore + "_allocator_retries_shed_total":      {},
+		Namespace + "_" + SubsystemKVStore + "_oversized_values_total":            {},
+		Namespace + "_" + SubsystemKVStore + "_store_reconciled_entries_total":    {},
 		Namespace + "_fqdn_gc_deletions_total":                                    {},
 		Namespace + "_" + SubsystemBPF + "_map_ops_total":                         {},
 	}
@@ -700,13 +883,23 @@ func CreateConfiguration(metricsEnabled []string) (Configuration, []prometheus.C
 			collectors = append(collectors, ProxyUpstreamTime)
 			c.NoOpObserverVecEnabled = true
 
+		case Namespace + "_proxy_l7_verdict_latency_seconds":
+			ProxyL7VerdictLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Name:      "proxy_l7_verdict_latency_seconds",
+				Help:      "Seconds between the first byte of a request being received by a proxylib parser and its verdict being delivered",
+			}, []string{LabelProtocolL7})
+
+			collectors = append(collectors, ProxyL7VerdictLatency)
+			c.ProxyL7VerdictLatencyEnabled = true
+
 		case Namespace + "_drop_count_total":
 			DropCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 				Namespace: Namespace,
 				Name:      "drop_count_total",
-				Help:      "Total dropped packets, tagged by drop reason and ingress/egress direction",
+				Help:      "Total dropped packets, tagged by drop reason, ingress/egress direction and datapath location",
 			},
-				[]string{"reason", "direction"})
+				[]string{"reason", "direction", "location"})
 
 			collectors = append(collectors, DropCount)
 			c.DropCountEnabled = true
@@ -715,9 +908,9 @@ func CreateConfiguration(metricsEnabled []string) (Configuration, []prometheus.C
 			DropBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
 				Namespace: Namespace,
 				Name:      "drop_bytes_total",
-				Help:      "Total dropped bytes, tagged by drop reason and ingress/egress direction",
+				Help:      "Total dropped bytes, tagged by drop reason, ingress/egress direction and datapath location",
 			},
-				[]string{"reason", "direction"})
+				[]string{"reason", "direction", "location"})
 
 			collectors = append(collectors, DropBytes)
 			c.DropBytesEnabled = true
@@ -744,6 +937,50 @@ func CreateConfiguration(metricsEnabled []string) (Configuration, []prometheus.C
 			collectors = append(collectors, ForwardBytes)
 			c.ForwardBytesEnabled = true
 
+		case Namespace + "_endpoint_drop_count_total":
+			EndpointDropCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "endpoint_drop_count_total",
+				Help:      "Total dropped packets of each endpoint, tagged by endpoint, drop reason and ingress/egress direction",
+			},
+				[]string{"endpoint", "reason", "direction"})
+
+			collectors = append(collectors, EndpointDropCount)
+			c.EndpointDropCountEnabled = true
+
+		case Namespace + "_endpoint_drop_bytes_total":
+			EndpointDropBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "endpoint_drop_bytes_total",
+				Help:      "Total dropped bytes of each endpoint, tagged by endpoint, drop reason and ingress/egress direction",
+			},
+				[]string{"endpoint", "reason", "direction"})
+
+			collectors = append(collectors, EndpointDropBytes)
+			c.EndpointDropBytesEnabled = true
+
+		case Namespace + "_endpoint_forward_count_total":
+			EndpointForwardCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "endpoint_forward_count_total",
+				Help:      "Total forwarded packets of each endpoint, tagged by endpoint and ingress/egress direction",
+			},
+				[]string{"endpoint", "direction"})
+
+			collectors = append(collectors, EndpointForwardCount)
+			c.EndpointForwardCountEnabled = true
+
+		case Namespace + "_endpoint_forward_bytes_total":
+			EndpointForwardBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "endpoint_forward_bytes_total",
+				Help:      "Total forwarded bytes of each endpoint, tagged by endpoint and ingress/egress direction",
+			},
+				[]string{"endpoint", "direction"})
+
+			collectors = append(collectors, EndpointForwardBytes)
+			c.EndpointForwardBytesEnabled = true
+
 		case Namespace + "_" + SubsystemDatapath + "_errors_total":
 			DatapathErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
 				Namespace: Namespace,
@@ -905,6 +1142,105 @@ func CreateConfiguration(metricsEnabled []string) (Configuration, []prometheus.C
 			collectors = append(collectors, KubernetesCNPStatusCompletion)
 			c.KubernetesCNPStatusCompletionEnabled = true
 
+		case Namespace + "_" + SubsystemK8s + "_node_event_handler_seconds":
+			KubernetesNodeEventHandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemK8s,
+				Name:      "node_event_handler_seconds",
+				Help:      "Duration in seconds of the handling of Kubernetes node events",
+			}, []string{LabelAction})
+
+			collectors = append(collectors, KubernetesNodeEventHandlerDuration)
+			c.KubernetesNodeEventHandlerEnabled = true
+
+		case Namespace + "_" + SubsystemK8s + "_node_resyncs_total":
+			KubernetesNodeResyncs = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemK8s,
+				Name:      "node_resyncs_total",
+				Help:      "Number of Kubernetes nodes resynced labeled by reason",
+			}, []string{LabelReason})
+
+			collectors = append(collectors, KubernetesNodeResyncs)
+			c.KubernetesNodeResyncsEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_reregistrations_total":
+			NodeReregistrations = prometheus.NewCounter(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "reregistrations_total",
+				Help:      "Number of times the local node was found missing in the kvstore and re-registered",
+			})
+
+			collectors = append(collectors, NodeReregistrations)
+			c.NodeReregistrationsEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_validation_failures_total":
+			NodeValidationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "validation_failures_total",
+				Help:      "Number of nodes which failed validation labeled by reason and action taken",
+			}, []string{LabelReason, LabelAction})
+
+			collectors = append(collectors, NodeValidationFailures)
+			c.NodeValidationFailuresEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_store_nodes":
+			NodeStoreNodes = prometheus.NewGauge(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "store_nodes",
+				Help:      "Number of nodes known to the node store",
+			})
+
+			collectors = append(collectors, NodeStoreNodes)
+			c.NodeStoreNodesEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_store_events_total":
+			NodeStoreEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "store_events_total",
+				Help:      "Number of node events received by the node store labeled by action",
+			}, []string{LabelAction})
+
+			collectors = append(collectors, NodeStoreEvents)
+			c.NodeStoreEventsEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_store_event_duration_seconds":
+			NodeStoreEventDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "store_event_duration_seconds",
+				Help:      "Duration in seconds of the processing of node events by the node store",
+			}, []string{LabelAction})
+
+			collectors = append(collectors, NodeStoreEventDuration)
+			c.NodeStoreEventDurationEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_store_pending_deletions":
+			NodeStorePendingDeletions = prometheus.NewGauge(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "store_pending_deletions",
+				Help:      "Number of node deletions delayed by the node store",
+			})
+
+			collectors = append(collectors, NodeStorePendingDeletions)
+			c.NodeStorePendingDeletionsEnabled = true
+
+		case Namespace + "_" + SubsystemNodes + "_store_ipcache_failures_total":
+			NodeStoreIPCacheFailures = prometheus.NewCounter(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemNodes,
+				Name:      "store_ipcache_failures_total",
+				Help:      "Number of ipcache upserts of node entries which failed",
+			})
+
+			collectors = append(collectors, NodeStoreIPCacheFailures)
+			c.NodeStoreIPCacheFailuresEnabled = true
+
 		case Namespace + "_ipam_events_total":
 			IpamEvent = prometheus.NewCounterVec(prometheus.CounterOpts{
 				Namespace: Namespace,
@@ -926,6 +1262,57 @@ func CreateConfiguration(metricsEnabled []string) (Configuration, []prometheus.C
 			collectors = append(collectors, KVStoreOperationsDuration)
 			c.KVStoreOperationsDurationEnabled = true
 
+		case Namespace + "_" + SubsystemKVStore + "_operations_inflight":
+			KVStoreOperationsInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "operations_inflight",
+				Help:      "Number of kvstore operations in flight labeled by operation",
+			}, []string{LabelOperation})
+
+			KVStoreOperationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "operation_errors_total",
+				Help:      "Number of failed kvstore operations labeled by scope and operation",
+			}, []string{LabelScope, LabelOperation})
+
+			collectors = append(collectors, KVStoreOperationsInflight, KVStoreOperationErrors)
+			c.KVStoreOperationsInflightEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_rate_limit_wait_seconds":
+			KVStoreRateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "rate_limit_wait_seconds",
+				Help:      "Duration in seconds kvstore operations were throttled by the client-side rate limiter labeled by operation class",
+			}, []string{LabelOperationClass})
+
+			collectors = append(collectors, KVStoreRateLimitWait)
+			c.KVStoreRateLimitWaitEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_lock_wait_seconds":
+			KVStoreLockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "lock_wait_seconds",
+				Help:      "Duration in seconds spent waiting for kvstore locks labeled by scope, operation class and outcome",
+			}, []string{LabelScope, LabelOperationClass, LabelOutcome})
+
+			collectors = append(collectors, KVStoreLockWait)
+			c.KVStoreLockWaitEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_lease_remaining_ttl_seconds":
+			KVStoreLeaseRemainingTTL = prometheus.NewGauge(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "lease_remaining_ttl_seconds",
+				Help:      "Time in seconds until the kvstore lease of the agent expires unless renewed",
+			})
+
+			collectors = append(collectors, KVStoreLeaseRemainingTTL)
+			c.KVStoreLeaseRemainingTTLEnabled = true
+
 		case Namespace + "_" + SubsystemKVStore + "_events_queue_seconds":
 			KVStoreEventsQueueDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 				Namespace: Namespace,
@@ -938,6 +1325,104 @@ func CreateConfiguration(metricsEnabled []string) (Configuration, []prometheus.C
 			collectors = append(collectors, KVStoreEventsQueueDuration)
 			c.KVStoreEventsQueueDurationEnabled = true
 
+		case Namespace + "_" + SubsystemKVStore + "_allocator_cache_repairs_total":
+			KVStoreAllocatorCacheRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_cache_repairs_total",
+				Help:      "Number of allocator caches found diverged from the kvstore and resynchronized",
+			}, []string{LabelKVStorePrefix})
+
+			collectors = append(collectors, KVStoreAllocatorCacheRepairs)
+			c.KVStoreAllocatorCacheRepairsEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_allocator_pool_ids":
+			KVStoreAllocatorPoolIDs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_pool_ids",
+				Help:      "Number of IDs of an allocator ID space labeled by state",
+			}, []string{LabelScope, LabelState})
+
+			KVStoreAllocatorPoolLargestFreeRange = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_pool_largest_free_range",
+				Help:      "Size of the largest range of consecutive available IDs of an allocator ID space",
+			}, []string{LabelScope})
+
+			collectors = append(collectors, KVStoreAllocatorPoolIDs, KVStoreAllocatorPoolLargestFreeRange)
+			c.KVStoreAllocatorPoolEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_allocator_local_key_sync_seconds":
+			KVStoreAllocatorLocalKeySyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_local_key_sync_seconds",
+				Help:      "Duration in seconds of the synchronization of locally used allocator keys with the kvstore",
+			}, []string{LabelScope})
+
+			KVStoreAllocatorLocalKeysRecreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_local_keys_recreated_total",
+				Help:      "Number of locally used allocator keys found missing in the kvstore and re-created",
+			}, []string{LabelScope, LabelKind})
+
+			collectors = append(collectors, KVStoreAllocatorLocalKeySyncDuration, KVStoreAllocatorLocalKeysRecreated)
+			c.KVStoreAllocatorLocalKeySyncEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_allocator_quarantine_ids":
+			KVStoreAllocatorQuarantineIDs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_quarantine_ids",
+				Help:      "Number of released allocator IDs held in quarantine before they can be reused",
+			}, []string{LabelScope})
+
+			KVStoreAllocatorQuarantineOldest = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_quarantine_oldest_seconds",
+				Help:      "Age in seconds of the oldest allocator ID held in quarantine",
+			}, []string{LabelScope})
+
+			collectors = append(collectors, KVStoreAllocatorQuarantineIDs, KVStoreAllocatorQuarantineOldest)
+			c.KVStoreAllocatorQuarantineEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_allocator_retries_shed_total":
+			KVStoreAllocatorRetriesShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "allocator_retries_shed_total",
+				Help:      "Number of allocations given up because the allocator retry budget was exhausted",
+			}, []string{LabelScope})
+
+			collectors = append(collectors, KVStoreAllocatorRetriesShed)
+			c.KVStoreAllocatorRetriesShedEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_oversized_values_total":
+			KVStoreOversizedValues = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "oversized_values_total",
+				Help:      "Number of kvstore values exceeding the maximum value size",
+			}, []string{LabelScope, LabelAction})
+
+			collectors = append(collectors, KVStoreOversizedValues)
+			c.KVStoreOversizedValuesEnabled = true
+
+		case Namespace + "_" + SubsystemKVStore + "_store_reconciled_entries_total":
+			KVStoreStoreReconciledEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: Namespace,
+				Subsystem: SubsystemKVStore,
+				Name:      "store_reconciled_entries_total",
+				Help:      "Number of shared store entries missed by the kvstore watcher and repaired by the periodic reconciliation",
+			}, []string{LabelScope, LabelAction})
+
+			collectors = append(collectors, KVStoreStoreReconciledEntries)
+			c.KVStoreStoreReconciledEntriesEnabled = true
+
 		case Namespace + "_fqdn_gc_deletions_total":
 			FQDNGarbageCollectorCleanedTotal = prometheus.NewCounter(prometheus.CounterOpts{
 				Namespace: Namespace,
@@ -1026,7 +1511,7 @@ func Enable(addr string) <-chan error {
 	go func() {
 		// The Handler function provides a default handler to expose metrics
 		// via an HTTP server. "/metrics" is the usual endpoint for that.
-		http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		http.Handle("/metrics", scrapeHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{})))
 		errs <- http.ListenAndServe(addr, nil)
 	}()
 
@@ -1048,6 +1533,7 @@ func GetCounterValue(m prometheus.Counter) float64 {
 // models.Metrics structure.If metrics cannot be retrieved, returns an error
 func DumpMetrics() ([]*models.Metric, error) {
 	result := []*models.Metric{}
+	runScrapeHooks(context.Background())
 	currentMetrics, err := registry.Gather()
 	if err != nil {
 		return result, err
@@ -0,0 +1,59 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cilium/cilium/pkg/lock"
+)
+
+var (
+	// scrapeHooksMutex protects scrapeHooks
+	scrapeHooksMutex lock.RWMutex
+
+	// scrapeHooks are called before the metrics are gathered
+	scrapeHooks []func(ctx context.Context)
+)
+
+// RegisterScrapeHook registers hook to be called before the metrics are
+// gathered for a scrape or DumpMetrics(). It allows to update metrics which
+// are synced periodically, so that scrapes never see stale values. The hook
+// must return once ctx is cancelled.
+func RegisterScrapeHook(hook func(ctx context.Context)) {
+	scrapeHooksMutex.Lock()
+	scrapeHooks = append(scrapeHooks, hook)
+	scrapeHooksMutex.Unlock()
+}
+
+// runScrapeHooks calls all registered scrape hooks
+func runScrapeHooks(ctx context.Context) {
+	scrapeHooksMutex.RLock()
+	hooks := scrapeHooks
+	scrapeHooksMutex.RUnlock()
+
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+}
+
+// scrapeHandler runs the scrape hooks before passing the request to next
+func scrapeHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runScrapeHooks(r.Context())
+		next.ServeHTTP(w, r)
+	})
+}
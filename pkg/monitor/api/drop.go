@@ -77,6 +77,69 @@ var errors = map[uint8]string{
 	172: "Unknown sender",
 }
 
+// dropHints maps drop reasons to short machine-readable hints naming what to
+// check to remediate the drops. Reasons which are expected during normal
+// operation or which are unused have no hint.
+var dropHints = map[uint8]string{
+	2:   "check-packet-integrity",
+	5:   "check-service-backends",
+	6:   "check-service-backends",
+	7:   "check-lb-map-size",
+	8:   "check-service-backends",
+	132: "check-source-ip-spoofing",
+	133: "check-network-policy",
+	134: "check-packet-integrity",
+	135: "check-packet-integrity",
+	137: "check-protocol-support",
+	139: "check-protocol-support",
+	140: "check-bpf-programs",
+	141: "check-bpf-programs",
+	142: "check-protocol-support",
+	143: "check-protocol-support",
+	144: "check-protocol-support",
+	145: "check-protocol-support",
+	146: "check-protocol-support",
+	147: "check-tunnel-config",
+	150: "check-routing",
+	151: "check-routing",
+	153: "check-bpf-programs",
+	154: "check-bpf-programs",
+	155: "check-ct-map-size",
+	156: "check-protocol-support",
+	157: "check-mtu",
+	158: "check-service-backends",
+	160: "check-tunnel-config",
+	163: "check-ct-map-size",
+	164: "check-routing",
+	165: "check-endpoint-regeneration",
+	166: "check-protocol-support",
+	167: "check-nat-config",
+	168: "check-nat-config",
+	170: "check-tunnel-config",
+	171: "check-identity-allocation",
+	172: "check-identity-allocation",
+}
+
+// DropReasonHint returns the remediation hint of the drop reason or an empty
+// string if the reason has no hint
+func DropReasonHint(reason uint8) string {
+	return dropHints[reason]
+}
+
+// DropReasonHintByName returns the remediation hint of the drop reason with
+// the given human readable name as returned by DropReason, or an empty string
+// if the reason is unknown or has no hint
+func DropReasonHintByName(name string) string {
+	for reason, err := range errors {
+		if err == name {
+			if hint, ok := dropHints[reason]; ok {
+				return hint
+			}
+		}
+	}
+	return ""
+}
+
 // DropReason prints the drop reason in a human readable string
 func DropReason(reason uint8) string {
 	if err, ok := errors[reason]; ok {
@@ -0,0 +1,35 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package api
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *MonitorAPISuite) TestDropReasonHint(c *C) {
+	c.Assert(DropReasonHint(133), Equals, "check-network-policy")
+	c.Assert(DropReasonHint(0), Equals, "")
+	c.Assert(DropReasonHintByName(DropReason(155)), Equals, "check-ct-map-size")
+	c.Assert(DropReasonHintByName(DropReason(0)), Equals, "")
+	c.Assert(DropReasonHintByName("unknown"), Equals, "")
+
+	// All hints must refer to a known drop reason
+	for reason := range dropHints {
+		_, ok := errors[reason]
+		c.Assert(ok, Equals, true, Commentf("reason %d", reason))
+	}
+}
@@ -75,6 +75,12 @@ func (l *LogRecordNotify) DumpInfo() {
 			l.DestinationEndpoint.ID, l.DestinationEndpoint.Labels,
 			l.SourceEndpoint.Identity, l.DestinationEndpoint.Identity,
 			l.Verdict)
+
+	case accesslog.TypeEvent:
+		fmt.Printf("%s %s %s from %d (%s) to %d (%s), identity %d->%d",
+			l.direction(), l.Type, l.l7Proto(), l.SourceEndpoint.ID, l.SourceEndpoint.Labels,
+			l.DestinationEndpoint.ID, l.DestinationEndpoint.Labels,
+			l.SourceEndpoint.Identity, l.DestinationEndpoint.Identity)
 	}
 
 	if http := l.HTTP; http != nil {
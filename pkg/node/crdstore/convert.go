@@ -0,0 +1,172 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdstore
+
+import (
+	"net"
+
+	"github.com/cilium/cilium/pkg/cidr"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/option"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ToCiliumNode returns the CiliumNode custom resource registering n
+func ToCiliumNode(n *node.Node) *ciliumv2.CiliumNode {
+	cn := &ciliumv2.CiliumNode{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: n.Name,
+		},
+	}
+	applyNode(cn, n)
+	return cn
+}
+
+// applyNode sets the registration of n in the CiliumNode resource cn. The
+// ENI configuration and the IPAM pool of cn are preserved.
+func applyNode(cn *ciliumv2.CiliumNode, n *node.Node) {
+	cn.ObjectMeta.Labels = nil
+	if len(n.Labels) != 0 {
+		cn.ObjectMeta.Labels = make(map[string]string, len(n.Labels))
+		for k, v := range n.Labels {
+			cn.ObjectMeta.Labels[k] = v
+		}
+	}
+
+	cn.Spec.Addresses = make([]ciliumv2.NodeAddress, 0, len(n.IPAddresses))
+	for _, addr := range n.IPAddresses {
+		cn.Spec.Addresses = append(cn.Spec.Addresses, ciliumv2.NodeAddress{
+			Type: addr.Type,
+			IP:   addr.IP.String(),
+		})
+	}
+
+	// The primary allocation CIDRs precede the secondary ones
+	var podCIDRs []string
+	for _, c := range []*cidr.CIDR{n.IPv4AllocCIDR, n.IPv6AllocCIDR} {
+		if c != nil {
+			podCIDRs = append(podCIDRs, c.String())
+		}
+	}
+	for _, c := range append(n.IPv4SecondaryAllocCIDRs, n.IPv6SecondaryAllocCIDRs...) {
+		podCIDRs = append(podCIDRs, c.String())
+	}
+	cn.Spec.IPAM.PodCIDRs = podCIDRs
+
+	cn.Spec.HealthAddressing = ciliumv2.HealthAddressingSpec{
+		IPv4: ipString(n.IPv4HealthIP),
+		IPv6: ipString(n.IPv6HealthIP),
+	}
+	cn.Spec.Encryption = ciliumv2.EncryptionSpec{
+		Key:       int(n.EncryptionKey),
+		PublicKey: n.EncryptionPublicKey,
+	}
+	cn.Spec.Datapath = ciliumv2.DatapathSpec{
+		MTU:            n.MTU,
+		TunnelProtocol: n.TunnelProtocol,
+		TunnelPort:     int(n.TunnelPort),
+	}
+}
+
+// withdrawNode removes the registration of the node from the CiliumNode
+// resource cn
+func withdrawNode(cn *ciliumv2.CiliumNode) {
+	cn.Spec.Addresses = nil
+	cn.Spec.IPAM.PodCIDRs = nil
+	cn.Spec.HealthAddressing = ciliumv2.HealthAddressingSpec{}
+	cn.Spec.Encryption = ciliumv2.EncryptionSpec{}
+	cn.Spec.Datapath = ciliumv2.DatapathSpec{}
+}
+
+// isRegistered returns true if the CiliumNode resource cn registers a node.
+// Resources created for IPAM purposes only or withdrawn by their node do
+// not.
+func isRegistered(cn *ciliumv2.CiliumNode) bool {
+	return len(cn.Spec.Addresses) != 0
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// ParseCiliumNode returns the node registered by the CiliumNode resource cn.
+// Malformed addresses are kept as nil IPs so that the node fails validation,
+// malformed pod CIDRs are ignored.
+func ParseCiliumNode(cn *ciliumv2.CiliumNode) node.Node {
+	n := node.Node{
+		Name:                cn.Name,
+		Cluster:             option.Config.ClusterName,
+		ClusterID:           option.Config.ClusterID,
+		EncryptionKey:       uint8(cn.Spec.Encryption.Key),
+		EncryptionPublicKey: cn.Spec.Encryption.PublicKey,
+		MTU:                 cn.Spec.Datapath.MTU,
+		TunnelProtocol:      cn.Spec.Datapath.TunnelProtocol,
+		TunnelPort:          uint16(cn.Spec.Datapath.TunnelPort),
+	}
+
+	if len(cn.ObjectMeta.Labels) != 0 {
+		n.Labels = make(map[string]string, len(cn.ObjectMeta.Labels))
+		for k, v := range cn.ObjectMeta.Labels {
+			n.Labels[k] = v
+		}
+	}
+
+	for _, addr := range cn.Spec.Addresses {
+		n.IPAddresses = append(n.IPAddresses, node.Address{
+			Type: addr.Type,
+			IP:   net.ParseIP(addr.IP),
+		})
+	}
+
+	for _, podCIDR := range cn.Spec.IPAM.PodCIDRs {
+		c, err := cidr.ParseCIDR(podCIDR)
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				logfields.NodeName: cn.Name,
+				"cidr":             podCIDR,
+			}).Warning("Ignoring invalid pod CIDR of CiliumNode")
+			continue
+		}
+		if c.IP.To4() != nil {
+			if n.IPv4AllocCIDR == nil {
+				n.IPv4AllocCIDR = c
+			} else {
+				n.IPv4SecondaryAllocCIDRs = append(n.IPv4SecondaryAllocCIDRs, c)
+			}
+		} else {
+			if n.IPv6AllocCIDR == nil {
+				n.IPv6AllocCIDR = c
+			} else {
+				n.IPv6SecondaryAllocCIDRs = append(n.IPv6SecondaryAllocCIDRs, c)
+			}
+		}
+	}
+
+	if cn.Spec.HealthAddressing.IPv4 != "" {
+		n.IPv4HealthIP = net.ParseIP(cn.Spec.HealthAddressing.IPv4)
+	}
+	if cn.Spec.HealthAddressing.IPv6 != "" {
+		n.IPv6HealthIP = net.ParseIP(cn.Spec.HealthAddressing.IPv6)
+	}
+
+	return n
+}
@@ -0,0 +1,299 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crdstore implements the registration of the local node and the
+// discovery of the nodes of the cluster on top of the CiliumNode custom
+// resource, for installations without a kvstore.
+package crdstore
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/k8s"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	clientset "github.com/cilium/cilium/pkg/k8s/client/clientset/versioned"
+	"github.com/cilium/cilium/pkg/k8s/informer"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/metrics"
+	"github.com/cilium/cilium/pkg/node"
+	nodestore "github.com/cilium/cilium/pkg/node/store"
+
+	"github.com/go-openapi/strfmt"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "node-crd-store")
+
+// resourceInfo is the version of a CiliumNode resource registering a node
+type resourceInfo struct {
+	resourceVersion string
+	lastUpdate      time.Time
+}
+
+// Registrar implements nodestore.Registrar on top of the CiliumNode custom
+// resource. The local node is published in the spec of the CiliumNode
+// resource of the same name, the nodes of the cluster are discovered by
+// watching all CiliumNode resources and passed to the node manager through
+// a nodestore.NodeObserver, exactly like the nodes received from the
+// kvstore.
+type Registrar struct {
+	client clientset.Interface
+
+	// observer passes the discovered nodes to the node manager, set by
+	// RegisterNode
+	observer *nodestore.NodeObserver
+
+	// mutex protects registered
+	mutex lock.Mutex
+
+	// registered maps the names of all nodes registered through a
+	// CiliumNode resource to the last version of their resource
+	registered map[string]resourceInfo
+
+	stop chan struct{}
+}
+
+// NewRegistrar returns a new Registrar using client to access the CiliumNode
+// custom resources
+func NewRegistrar(client clientset.Interface) *Registrar {
+	return &Registrar{
+		client:     client,
+		registered: map[string]resourceInfo{},
+		stop:       make(chan struct{}),
+	}
+}
+
+// RegisterNode publishes the local node n and starts watching the CiliumNode
+// resources of the cluster. Returns once the initial list of nodes has been
+// passed to manager.
+func (r *Registrar) RegisterNode(n *node.Node, manager nodestore.NodeManager) error {
+	if err := r.UpdateLocalKeySync(n); err != nil {
+		return err
+	}
+
+	observer := nodestore.NewNodeObserver(manager)
+	_, controller := informer.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return r.client.CiliumV2().CiliumNodes().List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return r.client.CiliumV2().CiliumNodes().Watch(options)
+			},
+		},
+		&ciliumv2.CiliumNode{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				r.onUpsert(observer, obj)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				r.onUpsert(observer, newObj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				r.onDelete(observer, obj)
+			},
+		},
+		k8s.ConvertToCiliumNode,
+	)
+	go controller.Run(r.stop)
+
+	if !cache.WaitForCacheSync(r.stop, controller.HasSynced) {
+		return fmt.Errorf("unable to synchronize CiliumNode resources")
+	}
+	observer.OnSync()
+	r.observer = observer
+
+	return nil
+}
+
+// PublishNode publishes the local node n in its CiliumNode resource without
+// watching the CiliumNode resources of the cluster
+func (r *Registrar) PublishNode(n *node.Node) error {
+	return r.UpdateLocalKeySync(n)
+}
+
+// onUpsert passes the node registered by the added or updated CiliumNode
+// resource obj to observer
+func (r *Registrar) onUpsert(observer *nodestore.NodeObserver, obj interface{}) {
+	cn, ok := obj.(*ciliumv2.CiliumNode)
+	if !ok {
+		return
+	}
+
+	n := ParseCiliumNode(cn)
+
+	r.mutex.Lock()
+	_, known := r.registered[cn.Name]
+	if !isRegistered(cn) {
+		delete(r.registered, cn.Name)
+		r.mutex.Unlock()
+
+		// The node has withdrawn its registration
+		if known {
+			observer.OnDelete(&n)
+		}
+		return
+	}
+	r.registered[cn.Name] = resourceInfo{
+		resourceVersion: cn.ResourceVersion,
+		lastUpdate:      time.Now(),
+	}
+	r.mutex.Unlock()
+
+	observer.OnUpdate(&n)
+}
+
+// onDelete removes the node registered by the deleted CiliumNode resource
+// obj from observer
+func (r *Registrar) onDelete(observer *nodestore.NodeObserver, obj interface{}) {
+	if deleted, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = deleted.Obj
+	}
+	cn, ok := obj.(*ciliumv2.CiliumNode)
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	_, known := r.registered[cn.Name]
+	delete(r.registered, cn.Name)
+	r.mutex.Unlock()
+
+	if known {
+		n := ParseCiliumNode(cn)
+		observer.OnDelete(&n)
+	}
+}
+
+// UpdateLocalKeySync publishes the local node n in its CiliumNode resource,
+// creating the resource if it does not exist
+func (r *Registrar) UpdateLocalKeySync(n *node.Node) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cn, err := r.client.CiliumV2().CiliumNodes().Get(n.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = r.client.CiliumV2().CiliumNodes().Create(ToCiliumNode(n))
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		updated := cn.DeepCopy()
+		applyNode(updated, n)
+		if reflect.DeepEqual(updated.Spec, cn.Spec) &&
+			reflect.DeepEqual(updated.ObjectMeta.Labels, cn.ObjectMeta.Labels) {
+			return nil
+		}
+		_, err = r.client.CiliumV2().CiliumNodes().Update(updated)
+		return err
+	})
+}
+
+// EnsureRegistered verifies that the CiliumNode resource of the local node
+// still registers the node and re-registers the node otherwise, e.g. after
+// the resource has been deleted. Returns true if the node has been
+// re-registered.
+func (r *Registrar) EnsureRegistered(n *node.Node) (bool, error) {
+	cn, err := r.client.CiliumV2().CiliumNodes().Get(n.Name, metav1.GetOptions{})
+	switch {
+	case err == nil && isRegistered(cn):
+		return false, nil
+	case err != nil && !k8serrors.IsNotFound(err):
+		return false, err
+	}
+
+	if err := r.UpdateLocalKeySync(n); err != nil {
+		return false, err
+	}
+	metrics.NodeReregistrations.Inc()
+	log.WithField(logfields.NodeName, n.Name).Warning("Local node was missing in its CiliumNode resource, re-registered it")
+	return true, nil
+}
+
+// DeleteLocalNode withdraws the registration of the local node n from its
+// CiliumNode resource. The resource itself is kept as it may be owned by
+// IPAM.
+func (r *Registrar) DeleteLocalNode(n *node.Node) {
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cn, err := r.client.CiliumV2().CiliumNodes().Get(n.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		updated := cn.DeepCopy()
+		withdrawNode(updated)
+		_, err = r.client.CiliumV2().CiliumNodes().Update(updated)
+		return err
+	})
+	if err != nil {
+		log.WithError(err).WithField(logfields.NodeName, n.Name).
+			Warning("Unable to withdraw local node from its CiliumNode resource")
+	}
+}
+
+// RefreshHostKeys re-applies the ipcache entries of all nodes discovered
+// with the current IPsec key identity of the local node
+func (r *Registrar) RefreshHostKeys() {
+	if r.observer != nil {
+		r.observer.RefreshHostKeys()
+	}
+}
+
+// GetStoreEntries returns all nodes registered through a CiliumNode resource
+// sorted by name along with the resource version of the resource and the
+// time it was last seen changing, followed by the nodes of which the
+// deletion is pending
+func (r *Registrar) GetStoreEntries() []*models.NodeStoreEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entries := make([]*models.NodeStoreEntry, 0, len(r.registered))
+	for name, info := range r.registered {
+		// Resource versions are opaque but are etcd revisions in
+		// practice
+		revision, _ := strconv.ParseInt(info.resourceVersion, 10, 64)
+		entries = append(entries, &models.NodeStoreEntry{
+			Name:        name,
+			ModRevision: revision,
+			LastUpdate:  strfmt.DateTime(info.lastUpdate),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	if r.observer != nil {
+		pending := r.observer.PendingDeletions()
+		sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+		entries = append(entries, pending...)
+	}
+	return entries
+}
+
+// Close stops watching the CiliumNode resources
+func (r *Registrar) Close() {
+	close(r.stop)
+}
@@ -0,0 +1,191 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package crdstore
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/cidr"
+	ciliumv2 "github.com/cilium/cilium/pkg/k8s/apis/cilium.io/v2"
+	"github.com/cilium/cilium/pkg/k8s/client/clientset/versioned/fake"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/testutils"
+
+	. "gopkg.in/check.v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CRDStoreSuite struct{}
+
+var _ = Suite(&CRDStoreSuite{})
+
+// fakeManager records the nodes updated and deleted
+type fakeManager struct {
+	mutex   lock.Mutex
+	updated []string
+	deleted []string
+}
+
+func (f *fakeManager) NodeSoftUpdated(n node.Node)                                     {}
+func (f *fakeManager) NodeTerminating(n node.Node)                                     {}
+func (f *fakeManager) NodeAddressesChanged(n node.Node, added, removed []node.Address) {}
+func (f *fakeManager) Exists(id node.Identity) bool                                    { return false }
+
+func (f *fakeManager) NodeUpdated(n node.Node) {
+	f.mutex.Lock()
+	f.updated = append(f.updated, n.Name)
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) NodeBatchUpdated(nodes []node.Node) {
+	for _, n := range nodes {
+		f.NodeUpdated(n)
+	}
+}
+
+func (f *fakeManager) NodeDeleted(n node.Node) {
+	f.mutex.Lock()
+	f.deleted = append(f.deleted, n.Name)
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) getUpdated() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.updated...)
+}
+
+func (f *fakeManager) getDeleted() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.deleted...)
+}
+
+func newTestNode(name string, ip string, podCIDR string) *node.Node {
+	return &node.Node{
+		Name:    name,
+		Cluster: option.Config.ClusterName,
+		IPAddresses: []node.Address{
+			{Type: addressing.NodeInternalIP, IP: net.ParseIP(ip)},
+		},
+		IPv4AllocCIDR: cidr.MustParseCIDR(podCIDR),
+	}
+}
+
+func (s *CRDStoreSuite) TestConversion(c *C) {
+	n := node.Node{
+		Name:    "node1",
+		Cluster: option.Config.ClusterName,
+		IPAddresses: []node.Address{
+			{Type: addressing.NodeInternalIP, IP: net.ParseIP("192.0.2.1")},
+			{Type: addressing.NodeCiliumInternalIP, IP: net.ParseIP("10.1.0.1")},
+			{Type: addressing.NodeInternalIP, IP: net.ParseIP("f00d::1")},
+		},
+		IPv4AllocCIDR:           cidr.MustParseCIDR("10.1.0.0/16"),
+		IPv6AllocCIDR:           cidr.MustParseCIDR("f00d:1::/96"),
+		IPv4SecondaryAllocCIDRs: []*cidr.CIDR{cidr.MustParseCIDR("10.2.0.0/16")},
+		IPv4HealthIP:            net.ParseIP("10.1.0.2"),
+		IPv6HealthIP:            net.ParseIP("f00d:1::2"),
+		ClusterID:               option.Config.ClusterID,
+		EncryptionKey:           3,
+		EncryptionPublicKey:     "key",
+		Labels:                  map[string]string{"a": "b"},
+		MTU:                     1450,
+		TunnelProtocol:          "vxlan",
+		TunnelPort:              8472,
+	}
+
+	cn := ToCiliumNode(&n)
+	c.Assert(cn.Spec.IPAM.PodCIDRs, checker.DeepEquals, []string{"10.1.0.0/16", "f00d:1::/96", "10.2.0.0/16"})
+	c.Assert(ParseCiliumNode(cn), checker.DeepEquals, n)
+
+	// Malformed addresses are kept to fail validation
+	cn.Spec.Addresses[0].IP = "192.0.2"
+	c.Assert(ParseCiliumNode(cn).IPAddresses[0].IP, IsNil)
+
+	withdrawNode(cn)
+	c.Assert(isRegistered(cn), Equals, false)
+}
+
+func (s *CRDStoreSuite) TestRegistrar(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "0s"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	client := fake.NewSimpleClientset()
+
+	// A resource created for IPAM only does not register a node, its
+	// IPAM pool is preserved when the node registers
+	_, err := client.CiliumV2().CiliumNodes().Create(&ciliumv2.CiliumNode{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec: ciliumv2.NodeSpec{
+			IPAM: ciliumv2.IPAMSpec{
+				Pool: map[string]ciliumv2.AllocationIP{"10.1.0.10": {}},
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+	_, err = client.CiliumV2().CiliumNodes().Create(ToCiliumNode(newTestNode("node2", "192.0.2.2", "10.2.0.0/16")))
+	c.Assert(err, IsNil)
+
+	manager := &fakeManager{}
+	registrar := NewRegistrar(client)
+	defer registrar.Close()
+
+	local := newTestNode("node1", "192.0.2.1", "10.1.0.0/16")
+	c.Assert(registrar.RegisterNode(local, manager), IsNil)
+
+	cn, err := client.CiliumV2().CiliumNodes().Get("node1", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(isRegistered(cn), Equals, true)
+	c.Assert(cn.Spec.IPAM.Pool, HasLen, 1)
+
+	c.Assert(testutils.WaitUntil(func() bool {
+		return len(registrar.GetStoreEntries()) == 2
+	}, 5*time.Second), IsNil)
+	c.Assert(manager.getUpdated(), checker.DeepEquals, []string{"node1", "node2"})
+
+	// A node withdrawing its registration is deleted
+	other := NewRegistrar(client)
+	other.DeleteLocalNode(newTestNode("node2", "192.0.2.2", "10.2.0.0/16"))
+	c.Assert(testutils.WaitUntil(func() bool {
+		return len(manager.getDeleted()) == 1
+	}, 5*time.Second), IsNil)
+	c.Assert(manager.getDeleted(), checker.DeepEquals, []string{"node2"})
+
+	// The local node is re-registered after its resource was deleted
+	re, err := registrar.EnsureRegistered(local)
+	c.Assert(err, IsNil)
+	c.Assert(re, Equals, false)
+	c.Assert(client.CiliumV2().CiliumNodes().Delete("node1", &metav1.DeleteOptions{}), IsNil)
+	re, err = registrar.EnsureRegistered(local)
+	c.Assert(err, IsNil)
+	c.Assert(re, Equals, true)
+	cn, err = client.CiliumV2().CiliumNodes().Get("node1", metav1.GetOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(isRegistered(cn), Equals, true)
+}
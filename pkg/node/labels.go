@@ -0,0 +1,97 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+const (
+	// TopologyZoneLabel is the label of a node holding the zone the node
+	// is located in
+	TopologyZoneLabel = "topology.kubernetes.io/zone"
+
+	// TopologyRegionLabel is the label of a node holding the region the
+	// node is located in
+	TopologyRegionLabel = "topology.kubernetes.io/region"
+
+	// legacyTopologyZoneLabel is the deprecated beta label replaced by
+	// TopologyZoneLabel
+	legacyTopologyZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+
+	// legacyTopologyRegionLabel is the deprecated beta label replaced by
+	// TopologyRegionLabel
+	legacyTopologyRegionLabel = "failure-domain.beta.kubernetes.io/region"
+)
+
+// legacyTopologyLabels maps the topology labels to their deprecated beta
+// counterparts
+var legacyTopologyLabels = map[string]string{
+	TopologyZoneLabel:   legacyTopologyZoneLabel,
+	TopologyRegionLabel: legacyTopologyRegionLabel,
+}
+
+// NormalizeTopologyLabels sets the topology labels in labels from their
+// deprecated beta counterparts if only the latter are present, so that
+// consumers only need to look at TopologyZoneLabel and TopologyRegionLabel.
+func NormalizeTopologyLabels(labels map[string]string) {
+	if labels == nil {
+		return
+	}
+	for label, legacyLabel := range legacyTopologyLabels {
+		if _, ok := labels[label]; ok {
+			continue
+		}
+		if value, ok := labels[legacyLabel]; ok {
+			labels[label] = value
+		}
+	}
+}
+
+// Zone returns the zone the node is located in or an empty string if unknown
+func (n *Node) Zone() string {
+	return n.topologyLabel(TopologyZoneLabel)
+}
+
+// Region returns the region the node is located in or an empty string if
+// unknown
+func (n *Node) Region() string {
+	return n.topologyLabel(TopologyRegionLabel)
+}
+
+// topologyLabel returns the value of the topology label, falling back to the
+// deprecated beta label for nodes published without normalized labels
+func (n *Node) topologyLabel(label string) string {
+	if value, ok := n.Labels[label]; ok {
+		return value
+	}
+	return n.Labels[legacyTopologyLabels[label]]
+}
+
+// LabelsDiff compares the old and new set of node labels and returns all
+// labels which have been added or whose value has changed as well as the keys
+// of all labels which have been removed.
+func LabelsDiff(oldLabels, newLabels map[string]string) (changed map[string]string, removed []string) {
+	changed = map[string]string{}
+	for k, v := range newLabels {
+		if oldValue, ok := oldLabels[k]; !ok || oldValue != v {
+			changed[k] = v
+		}
+	}
+
+	for k := range oldLabels {
+		if _, ok := newLabels[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,189 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"net"
+
+	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/node"
+)
+
+// cidrTrieNode is a node of a binary trie of prefixes. The node at depth n
+// represents the prefix of length n spelled by the path from the root.
+type cidrTrieNode struct {
+	children [2]*cidrTrieNode
+
+	// owners are the nodes with an allocation CIDR equal to the prefix
+	owners map[node.Identity]int
+
+	// size is the number of allocation CIDRs in the subtree
+	size int
+}
+
+// cidrIndex indexes the allocation CIDRs of nodes so that nodes with
+// overlapping allocation CIDRs can be found without iterating over all nodes
+type cidrIndex struct {
+	v4, v6 *cidrTrieNode
+
+	// cidrs are the indexed allocation CIDRs of each node
+	cidrs map[node.Identity][]*cidr.CIDR
+}
+
+func newCIDRIndex() *cidrIndex {
+	return &cidrIndex{
+		v4:    &cidrTrieNode{},
+		v6:    &cidrTrieNode{},
+		cidrs: map[node.Identity][]*cidr.CIDR{},
+	}
+}
+
+// allocCIDRs returns all IPv4 and IPv6 allocation CIDRs of n
+func allocCIDRs(n node.Node) []*cidr.CIDR {
+	return append(n.GetIPv4AllocCIDRs(), n.GetIPv6AllocCIDRs()...)
+}
+
+// allocCIDRsEqual returns true if a and b have the same allocation CIDRs
+func allocCIDRsEqual(a, b node.Node) bool {
+	x, y := allocCIDRs(a), allocCIDRs(b)
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i].String() != y[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// bit returns the i-th most significant bit of ip
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>uint(7-i%8)) & 1
+}
+
+// lookup returns the root of the trie of c, the address of c in the length
+// of its family and the prefix length of c
+func (x *cidrIndex) lookup(c *cidr.CIDR) (*cidrTrieNode, net.IP, int) {
+	ones, _ := c.Mask.Size()
+	if ip := c.IP.To4(); ip != nil {
+		return x.v4, ip, ones
+	}
+	return x.v6, c.IP.To16(), ones
+}
+
+// insert adds (delta = 1) or removes (delta = -1) c as allocation CIDR of id
+func (x *cidrIndex) insert(id node.Identity, c *cidr.CIDR, delta int) {
+	t, ip, ones := x.lookup(c)
+	if ip == nil {
+		return
+	}
+
+	t.size += delta
+	for i := 0; i < ones; i++ {
+		b := bit(ip, i)
+		if t.children[b] == nil {
+			t.children[b] = &cidrTrieNode{}
+		}
+		t.children[b].size += delta
+		if t.children[b].size == 0 {
+			t.children[b] = nil
+			return
+		}
+		t = t.children[b]
+	}
+
+	if t.owners == nil {
+		t.owners = map[node.Identity]int{}
+	}
+	t.owners[id] += delta
+	if t.owners[id] == 0 {
+		delete(t.owners, id)
+	}
+}
+
+// upsert replaces the indexed allocation CIDRs of id with the allocation
+// CIDRs of n
+func (x *cidrIndex) upsert(id node.Identity, n node.Node) {
+	x.delete(id)
+
+	cidrs := allocCIDRs(n)
+	for _, c := range cidrs {
+		x.insert(id, c, 1)
+	}
+	if len(cidrs) > 0 {
+		x.cidrs[id] = cidrs
+	}
+}
+
+// delete removes all indexed allocation CIDRs of id
+func (x *cidrIndex) delete(id node.Identity) {
+	for _, c := range x.cidrs[id] {
+		x.insert(id, c, -1)
+	}
+	delete(x.cidrs, id)
+}
+
+// otherOwner returns an owner of t other than id
+func otherOwner(t *cidrTrieNode, id node.Identity) (node.Identity, bool) {
+	for owner := range t.owners {
+		if owner != id {
+			return owner, true
+		}
+	}
+	return node.Identity{}, false
+}
+
+// findInSubtree returns an owner other than id of any prefix in the subtree
+// of t, including t itself
+func findInSubtree(t *cidrTrieNode, id node.Identity) (node.Identity, bool) {
+	if t == nil || t.size == 0 {
+		return node.Identity{}, false
+	}
+	if owner, ok := otherOwner(t, id); ok {
+		return owner, true
+	}
+	for _, child := range t.children {
+		if owner, ok := findInSubtree(child, id); ok {
+			return owner, true
+		}
+	}
+	return node.Identity{}, false
+}
+
+// overlapping returns a node other than id with an allocation CIDR which
+// contains or is contained in any of the allocation CIDRs of n
+func (x *cidrIndex) overlapping(id node.Identity, n node.Node) (node.Identity, bool) {
+	for _, c := range allocCIDRs(n) {
+		t, ip, ones := x.lookup(c)
+		if ip == nil {
+			continue
+		}
+
+		// Prefixes containing c are on the path to c, prefixes
+		// contained in c are in the subtree of c
+		for i := 0; i < ones && t != nil; i++ {
+			if owner, ok := otherOwner(t, id); ok {
+				return owner, true
+			}
+			t = t.children[bit(ip, i)]
+		}
+		if owner, ok := findInSubtree(t, id); ok {
+			return owner, true
+		}
+	}
+
+	return node.Identity{}, false
+}
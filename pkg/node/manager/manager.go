@@ -20,10 +20,13 @@ import (
 
 	"github.com/cilium/cilium/pkg/datapath"
 	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/option"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -41,6 +44,54 @@ type nodeEntry struct {
 	// Manager.mutex must *always* be acquired first.
 	mutex lock.Mutex
 	node  node.Node
+
+	// terminating is true once the node has been reported as being about
+	// to be terminated
+	terminating bool
+}
+
+// NodeLabelsHandler is implemented by subsystems which select nodes based on
+// their labels, e.g. policies selecting nodes for host firewalling.
+type NodeLabelsHandler interface {
+	// NodeLabelsChanged is called whenever the labels of a node have
+	// changed. changed contains all labels which have been added or
+	// modified, removed contains the keys of all labels which have been
+	// removed. When a node is deleted, all of its labels are reported as
+	// removed.
+	NodeLabelsChanged(n node.Node, changed map[string]string, removed []string)
+}
+
+// NodeHealthIPsHandler is implemented by subsystems which track the health
+// endpoints of nodes, e.g. the cilium-health prober.
+type NodeHealthIPsHandler interface {
+	// NodeHealthIPsChanged is called whenever the IPv4HealthIP or
+	// IPv6HealthIP of a known node has changed. oldNode is the version of
+	// the node before the change.
+	NodeHealthIPsChanged(oldNode, newNode node.Node)
+}
+
+// NodeTerminationHandler is implemented by subsystems which need to react
+// before a node goes away, e.g. to deregister the local node from the kvstore
+// or to drain connections towards a remote node.
+type NodeTerminationHandler interface {
+	// NodeTerminating is called once when a node is marked as about to be
+	// terminated, ahead of its eventual deletion.
+	NodeTerminating(n node.Node)
+
+	// NodeTerminationCancelled is called when a node previously reported
+	// as terminating is no longer about to be terminated, e.g. after a
+	// scale-down has been cancelled.
+	NodeTerminationCancelled(n node.Node)
+}
+
+// NodeCIDRConflictHandler is implemented by subsystems which surface nodes
+// with conflicting allocation CIDRs to users, e.g. as Kubernetes events.
+type NodeCIDRConflictHandler interface {
+	// NodeCIDRConflict is called once per conflict when the allocation
+	// CIDRs of n are found to overlap with the allocation CIDRs of other.
+	// rejected is true if n has not been programmed because of the
+	// conflict. The manager mutex is held, implementations must not block.
+	NodeCIDRConflict(n, other node.Node, rejected bool)
 }
 
 // Manager is the entity that manages a collection of nodes
@@ -67,12 +118,55 @@ type Manager struct {
 	// nodes is the list of nodes. Access must be protected via mutex.
 	nodes map[node.Identity]*nodeEntry
 
+	// cidrIndex indexes the allocation CIDRs of all nodes. Access must be
+	// protected via mutex.
+	cidrIndex *cidrIndex
+
+	// cidrConflicts maps each node with allocation CIDRs overlapping with
+	// another node to the conflicting node so that each conflict is only
+	// reported once. Access must be protected via mutex.
+	cidrConflicts map[node.Identity]node.Identity
+
+	// rejectedNodes contains the latest update of each node rejected due
+	// to a CIDR conflict. The update is applied again once the
+	// conflicting node has been deleted or its allocation CIDRs have
+	// changed. Access must be protected via mutex.
+	rejectedNodes map[node.Identity]node.Node
+
 	// nodeHandlersMu protects the nodeHandlers map against concurrent access.
 	nodeHandlersMu lock.RWMutex
 	// nodeHandlers has a slice containing all node handlers subscribed to node
 	// events.
 	nodeHandlers map[datapath.NodeHandler]struct{}
 
+	// labelHandlersMu protects the labelHandlers map against concurrent
+	// access.
+	labelHandlersMu lock.RWMutex
+	// labelHandlers contains all handlers subscribed to node label
+	// changes.
+	labelHandlers map[NodeLabelsHandler]struct{}
+
+	// healthIPsHandlersMu protects the healthIPsHandlers map against
+	// concurrent access.
+	healthIPsHandlersMu lock.RWMutex
+	// healthIPsHandlers contains all handlers subscribed to node health
+	// IP changes.
+	healthIPsHandlers map[NodeHealthIPsHandler]struct{}
+
+	// terminationHandlersMu protects the terminationHandlers map against
+	// concurrent access.
+	terminationHandlersMu lock.RWMutex
+	// terminationHandlers contains all handlers subscribed to node
+	// termination events.
+	terminationHandlers map[NodeTerminationHandler]struct{}
+
+	// cidrConflictHandlersMu protects the cidrConflictHandlers map against
+	// concurrent access.
+	cidrConflictHandlersMu lock.RWMutex
+	// cidrConflictHandlers contains all handlers subscribed to CIDR
+	// conflicts between nodes.
+	cidrConflictHandlers map[NodeCIDRConflictHandler]struct{}
+
 	// closeChan is closed when the manager is closed
 	closeChan chan struct{}
 
@@ -91,6 +185,14 @@ type Manager struct {
 	// metricDatapathValidations is the prometheus metric to track the
 	// number of datapath node validation calls
 	metricDatapathValidations prometheus.Counter
+
+	// metricCIDRConflicts is the prometheus metric to track the number of
+	// conflicts between nodes with overlapping allocation CIDRs
+	metricCIDRConflicts prometheus.Counter
+
+	// metricHandlerErrors is the prometheus metric to track the number of
+	// errors returned by node handlers
+	metricHandlerErrors *prometheus.CounterVec
 }
 
 // Subscribe subscribes the given node handler to node events.
@@ -123,13 +225,123 @@ func (m *Manager) Iter(f func(nh datapath.NodeHandler)) {
 	}
 }
 
+// SubscribeLabels subscribes the given handler to node label changes. The
+// labels of all nodes already known to the manager are reported to the handler
+// as added labels.
+func (m *Manager) SubscribeLabels(lh NodeLabelsHandler) {
+	m.labelHandlersMu.Lock()
+	m.labelHandlers[lh] = struct{}{}
+	m.labelHandlersMu.Unlock()
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, v := range m.nodes {
+		v.mutex.Lock()
+		if len(v.node.Labels) > 0 {
+			lh.NodeLabelsChanged(v.node, v.node.Labels, nil)
+		}
+		v.mutex.Unlock()
+	}
+}
+
+// UnsubscribeLabels unsubscribes the given handler from node label changes.
+func (m *Manager) UnsubscribeLabels(lh NodeLabelsHandler) {
+	m.labelHandlersMu.Lock()
+	delete(m.labelHandlers, lh)
+	m.labelHandlersMu.Unlock()
+}
+
+// notifyLabelsChanged notifies all label handlers of node n if oldLabels and
+// newLabels differ.
+func (m *Manager) notifyLabelsChanged(n node.Node, oldLabels, newLabels map[string]string) {
+	changed, removed := node.LabelsDiff(oldLabels, newLabels)
+	if len(changed) == 0 && len(removed) == 0 {
+		return
+	}
+
+	m.labelHandlersMu.RLock()
+	defer m.labelHandlersMu.RUnlock()
+	for lh := range m.labelHandlers {
+		lh.NodeLabelsChanged(n, changed, removed)
+	}
+}
+
+// SubscribeHealthIPs subscribes the given handler to changes of the health
+// IPs of nodes.
+func (m *Manager) SubscribeHealthIPs(hh NodeHealthIPsHandler) {
+	m.healthIPsHandlersMu.Lock()
+	m.healthIPsHandlers[hh] = struct{}{}
+	m.healthIPsHandlersMu.Unlock()
+}
+
+// UnsubscribeHealthIPs unsubscribes the given handler from changes of the
+// health IPs of nodes.
+func (m *Manager) UnsubscribeHealthIPs(hh NodeHealthIPsHandler) {
+	m.healthIPsHandlersMu.Lock()
+	delete(m.healthIPsHandlers, hh)
+	m.healthIPsHandlersMu.Unlock()
+}
+
+// notifyHealthIPsChanged notifies all health IP handlers if the health IPs
+// of oldNode and newNode differ.
+func (m *Manager) notifyHealthIPsChanged(oldNode, newNode node.Node) {
+	if oldNode.HealthIPsEqual(&newNode) {
+		return
+	}
+
+	m.healthIPsHandlersMu.RLock()
+	defer m.healthIPsHandlersMu.RUnlock()
+	for hh := range m.healthIPsHandlers {
+		hh.NodeHealthIPsChanged(oldNode, newNode)
+	}
+}
+
+// SubscribeTermination subscribes the given handler to node termination
+// events.
+func (m *Manager) SubscribeTermination(th NodeTerminationHandler) {
+	m.terminationHandlersMu.Lock()
+	m.terminationHandlers[th] = struct{}{}
+	m.terminationHandlersMu.Unlock()
+}
+
+// UnsubscribeTermination unsubscribes the given handler from node termination
+// events.
+func (m *Manager) UnsubscribeTermination(th NodeTerminationHandler) {
+	m.terminationHandlersMu.Lock()
+	delete(m.terminationHandlers, th)
+	m.terminationHandlersMu.Unlock()
+}
+
+// SubscribeCIDRConflicts subscribes the given handler to CIDR conflicts
+// between nodes.
+func (m *Manager) SubscribeCIDRConflicts(ch NodeCIDRConflictHandler) {
+	m.cidrConflictHandlersMu.Lock()
+	m.cidrConflictHandlers[ch] = struct{}{}
+	m.cidrConflictHandlersMu.Unlock()
+}
+
+// UnsubscribeCIDRConflicts unsubscribes the given handler from CIDR
+// conflicts between nodes.
+func (m *Manager) UnsubscribeCIDRConflicts(ch NodeCIDRConflictHandler) {
+	m.cidrConflictHandlersMu.Lock()
+	delete(m.cidrConflictHandlers, ch)
+	m.cidrConflictHandlersMu.Unlock()
+}
+
 // NewManager returns a new node manager
 func NewManager(name string, dp datapath.NodeHandler) (*Manager, error) {
 	m := &Manager{
-		name:         name,
-		nodes:        map[node.Identity]*nodeEntry{},
-		nodeHandlers: map[datapath.NodeHandler]struct{}{},
-		closeChan:    make(chan struct{}),
+		name:                 name,
+		nodes:                map[node.Identity]*nodeEntry{},
+		cidrIndex:            newCIDRIndex(),
+		cidrConflicts:        map[node.Identity]node.Identity{},
+		rejectedNodes:        map[node.Identity]node.Node{},
+		nodeHandlers:         map[datapath.NodeHandler]struct{}{},
+		labelHandlers:        map[NodeLabelsHandler]struct{}{},
+		healthIPsHandlers:    map[NodeHealthIPsHandler]struct{}{},
+		terminationHandlers:  map[NodeTerminationHandler]struct{}{},
+		cidrConflictHandlers: map[NodeCIDRConflictHandler]struct{}{},
+		closeChan:            make(chan struct{}),
 	}
 	m.Subscribe(dp)
 
@@ -154,7 +366,21 @@ func NewManager(name string, dp datapath.NodeHandler) (*Manager, error) {
 		Help:      "Number of validation calls to implement the datapath implemention of a node",
 	})
 
-	err := metrics.RegisterList([]prometheus.Collector{m.metricDatapathValidations, m.metricEventsReceived, m.metricNumNodes})
+	m.metricCIDRConflicts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "nodes",
+		Name:      name + "_cidr_conflicts_total",
+		Help:      "Number of conflicts between nodes with overlapping allocation CIDRs",
+	})
+
+	m.metricHandlerErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "nodes",
+		Name:      name + "_handler_errors_total",
+		Help:      "Number of errors returned by node handlers",
+	}, []string{"eventType"})
+
+	err := metrics.RegisterList([]prometheus.Collector{m.metricDatapathValidations, m.metricEventsReceived, m.metricNumNodes, m.metricCIDRConflicts, m.metricHandlerErrors})
 	if err != nil {
 		return nil, err
 	}
@@ -174,6 +400,8 @@ func (m *Manager) Close() {
 	metrics.Unregister(m.metricNumNodes)
 	metrics.Unregister(m.metricEventsReceived)
 	metrics.Unregister(m.metricDatapathValidations)
+	metrics.Unregister(m.metricCIDRConflicts)
+	metrics.Unregister(m.metricHandlerErrors)
 
 	// delete all nodes to clean up the datapath for each node
 	for _, n := range m.nodes {
@@ -294,7 +522,7 @@ func overwriteAllowed(oldSource, newSource node.Source) bool {
 // unlike a NodeUpdated does not require the datapath to be updated.
 func (m *Manager) NodeSoftUpdated(n node.Node) {
 	log.Debugf("Received soft node update event from %s: %#v", n.Source, n)
-	m.nodeUpdated(n, false)
+	m.nodeUpdated(n, false, nil)
 }
 
 // NodeUpdated is called after the information of a node has been updated. The
@@ -303,10 +531,44 @@ func (m *Manager) NodeSoftUpdated(n node.Node) {
 // interface is invoked.
 func (m *Manager) NodeUpdated(n node.Node) {
 	log.Debugf("Received node update event from %s: %#v", n.Source, n)
-	m.nodeUpdated(n, true)
+	m.nodeUpdated(n, true, nil)
+}
+
+// NodeBatchUpdated is called with multiple updated nodes at once, e.g. all
+// nodes of the initial synchronization of the node store. Nodes not known
+// before are added to the datapath in a single batch by node handlers
+// implementing datapath.NodeBatchHandler.
+func (m *Manager) NodeBatchUpdated(nodes []node.Node) {
+	log.Debugf("Received batch update event of %d nodes", len(nodes))
+
+	added := make([]node.Node, 0, len(nodes))
+	for _, n := range nodes {
+		m.nodeUpdated(n, true, &added)
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	m.Iter(func(nh datapath.NodeHandler) {
+		if bh, ok := nh.(datapath.NodeBatchHandler); ok {
+			if err := bh.NodeBatchAdd(added); err != nil {
+				m.metricHandlerErrors.WithLabelValues("batchAdd").Inc()
+				log.WithError(err).WithField("nodes", len(added)).Warning("Node handler failed to add batch of nodes")
+			}
+			return
+		}
+		for _, n := range added {
+			if err := nh.NodeAdd(n); err != nil {
+				m.handlerError("add", n, err)
+			}
+		}
+	})
 }
 
-func (m *Manager) nodeUpdated(n node.Node, dpUpdate bool) {
+// nodeUpdated applies the update of n. If added is not nil, nodes not known
+// before are appended to added instead of being added to the datapath.
+func (m *Manager) nodeUpdated(n node.Node, dpUpdate bool, added *[]node.Node) {
 	nodeIdentity := n.Identity()
 
 	m.mutex.Lock()
@@ -319,33 +581,142 @@ func (m *Manager) nodeUpdated(n node.Node, dpUpdate bool) {
 			return
 		}
 
+		if m.rejectCIDRConflict(n) {
+			m.mutex.Unlock()
+			return
+		}
+
 		entry.mutex.Lock()
-		m.mutex.Unlock()
 		oldNode := entry.node
+		var retry []node.Node
+		if !allocCIDRsEqual(oldNode, n) {
+			m.cidrIndex.upsert(nodeIdentity, n)
+			retry = m.releaseCIDRConflicts(nodeIdentity)
+		}
+		m.mutex.Unlock()
 		entry.node = n
 		if dpUpdate {
 			m.Iter(func(nh datapath.NodeHandler) {
 				nh.NodeUpdate(oldNode, entry.node)
 			})
 		}
+		m.notifyLabelsChanged(entry.node, oldNode.Labels, entry.node.Labels)
+		m.notifyHealthIPsChanged(oldNode, entry.node)
 		entry.mutex.Unlock()
+
+		m.retryRejectedNodes(retry)
 	} else {
 		m.metricEventsReceived.WithLabelValues("add", string(n.Source)).Inc()
+
+		if m.rejectCIDRConflict(n) {
+			m.mutex.Unlock()
+			return
+		}
+
 		m.metricNumNodes.Inc()
 
 		entry = &nodeEntry{node: n}
 		entry.mutex.Lock()
 		m.nodes[nodeIdentity] = entry
+		m.cidrIndex.upsert(nodeIdentity, n)
 		m.mutex.Unlock()
 		if dpUpdate {
-			m.Iter(func(nh datapath.NodeHandler) {
-				nh.NodeAdd(entry.node)
-			})
+			if added != nil {
+				*added = append(*added, entry.node)
+			} else {
+				m.Iter(func(nh datapath.NodeHandler) {
+					nh.NodeAdd(entry.node)
+				})
+			}
 		}
+		m.notifyLabelsChanged(entry.node, nil, entry.node.Labels)
 		entry.mutex.Unlock()
 	}
 }
 
+// rejectCIDRConflict checks whether the allocation CIDRs of n overlap with
+// another node, in which case routes to the node would be ambiguous. Each
+// conflict is logged, accounted for and reported to the CIDR conflict
+// handlers once. Returns true if the node must not be programmed as
+// option.Config.RejectConflictingNodeCIDRs is set, the update is then kept
+// to be applied again once the conflict is resolved. The local node is never
+// rejected. m.mutex must be held.
+func (m *Manager) rejectCIDRConflict(n node.Node) bool {
+	nodeIdentity := n.Identity()
+	otherIdentity, conflict := m.cidrIndex.overlapping(nodeIdentity, n)
+	if !conflict {
+		delete(m.cidrConflicts, nodeIdentity)
+		delete(m.rejectedNodes, nodeIdentity)
+		return false
+	}
+
+	reject := option.Config.RejectConflictingNodeCIDRs && n.Source != node.FromLocalNode
+	if reject {
+		m.rejectedNodes[nodeIdentity] = n
+	} else {
+		delete(m.rejectedNodes, nodeIdentity)
+	}
+
+	if prev, ok := m.cidrConflicts[nodeIdentity]; ok && prev == otherIdentity {
+		return reject
+	}
+	m.cidrConflicts[nodeIdentity] = otherIdentity
+	m.metricCIDRConflicts.Inc()
+
+	// otherIdentity is always known as the index only contains nodes
+	// of the manager
+	entry := m.nodes[otherIdentity]
+	entry.mutex.Lock()
+	other := entry.node
+	entry.mutex.Unlock()
+
+	log.WithFields(logrus.Fields{
+		logfields.NodeName:      n.Fullname(),
+		logfields.V4Prefix:      n.IPv4AllocCIDR,
+		logfields.V6Prefix:      n.IPv6AllocCIDR,
+		"conflictingNode":       other.Fullname(),
+		"conflictingNodeSource": other.Source,
+		"rejected":              reject,
+	}).Warning("Allocation CIDRs of node overlap with another node")
+
+	m.cidrConflictHandlersMu.RLock()
+	for ch := range m.cidrConflictHandlers {
+		ch.NodeCIDRConflict(n, other, reject)
+	}
+	m.cidrConflictHandlersMu.RUnlock()
+
+	return reject
+}
+
+// releaseCIDRConflicts forgets all conflicts with the node nodeIdentity after
+// it has been deleted or its allocation CIDRs have changed. Returns the
+// rejected updates of the nodes which were conflicting with it, they must be
+// applied again with retryRejectedNodes() after m.mutex has been released.
+// m.mutex must be held.
+func (m *Manager) releaseCIDRConflicts(nodeIdentity node.Identity) []node.Node {
+	var retry []node.Node
+	for id, other := range m.cidrConflicts {
+		if other != nodeIdentity {
+			continue
+		}
+		delete(m.cidrConflicts, id)
+		if n, ok := m.rejectedNodes[id]; ok {
+			delete(m.rejectedNodes, id)
+			retry = append(retry, n)
+		}
+	}
+	return retry
+}
+
+// retryRejectedNodes applies the previously rejected node updates again. Nodes
+// still conflicting with another node are rejected again.
+func (m *Manager) retryRejectedNodes(nodes []node.Node) {
+	for _, n := range nodes {
+		log.WithField(logfields.NodeName, n.Fullname()).Info("Conflicting node is gone, applying rejected node update again")
+		m.nodeUpdated(n, true, nil)
+	}
+}
+
 // NodeDeleted is called after a node has been deleted. It removes the node
 // from the manager if the node is still owned by the source of which the event
 // orgins from. If the node was removed, NodeDelete() is invoked of the
@@ -360,6 +731,11 @@ func (m *Manager) NodeDeleted(n node.Node) {
 	m.mutex.Lock()
 	entry, oldNodeExists := m.nodes[nodeIdentity]
 	if !oldNodeExists {
+		// The node may only be known as a rejected update
+		if rejected, ok := m.rejectedNodes[nodeIdentity]; ok && rejected.Source == n.Source {
+			delete(m.rejectedNodes, nodeIdentity)
+			delete(m.cidrConflicts, nodeIdentity)
+		}
 		m.mutex.Unlock()
 		return
 	}
@@ -383,11 +759,116 @@ func (m *Manager) NodeDeleted(n node.Node) {
 
 	entry.mutex.Lock()
 	delete(m.nodes, nodeIdentity)
+	m.cidrIndex.delete(nodeIdentity)
+	delete(m.cidrConflicts, nodeIdentity)
+	retry := m.releaseCIDRConflicts(nodeIdentity)
 	m.mutex.Unlock()
 	m.Iter(func(nh datapath.NodeHandler) {
 		nh.NodeDelete(n)
 	})
+	m.notifyLabelsChanged(entry.node, entry.node.Labels, nil)
 	entry.mutex.Unlock()
+
+	m.retryRejectedNodes(retry)
+}
+
+// NodeAddressesChanged is called after an update of n added or removed
+// addresses of the node. Node handlers implementing
+// datapath.NodeAddressHandler are notified of the changed addresses. Updates
+// of nodes unknown to the manager or owned by another source are ignored.
+func (m *Manager) NodeAddressesChanged(n node.Node, added, removed []node.Address) {
+	m.mutex.RLock()
+	entry, ok := m.nodes[n.Identity()]
+	if !ok {
+		m.mutex.RUnlock()
+		return
+	}
+	entry.mutex.Lock()
+	m.mutex.RUnlock()
+	defer entry.mutex.Unlock()
+
+	if entry.node.Source != n.Source {
+		return
+	}
+
+	m.Iter(func(nh datapath.NodeHandler) {
+		if ah, ok := nh.(datapath.NodeAddressHandler); ok {
+			if err := ah.NodeAddressesChanged(entry.node, added, removed); err != nil {
+				m.handlerError("addresses", entry.node, err)
+			}
+		}
+	})
+}
+
+// handlerError logs and counts the error returned by a node handler for the
+// event of type eventType of node n
+func (m *Manager) handlerError(eventType string, n node.Node, err error) {
+	m.metricHandlerErrors.WithLabelValues(eventType).Inc()
+	log.WithError(err).WithFields(logrus.Fields{
+		logfields.NodeName: n.Fullname(),
+		"eventType":        eventType,
+	}).Warning("Node handler failed to process node event")
+}
+
+// NodeTerminating is called when a node has been marked as about to be
+// terminated, e.g. by a cloud provider or the cluster autoscaler. All
+// termination handlers are notified once per node so that the node can be
+// deregistered and peers can drain ahead of the node deletion. Nodes unknown
+// to the manager are ignored.
+func (m *Manager) NodeTerminating(n node.Node) {
+	m.metricEventsReceived.WithLabelValues("terminating", string(n.Source)).Inc()
+
+	m.mutex.RLock()
+	entry, ok := m.nodes[n.Identity()]
+	if !ok {
+		m.mutex.RUnlock()
+		return
+	}
+	entry.mutex.Lock()
+	m.mutex.RUnlock()
+	defer entry.mutex.Unlock()
+
+	if entry.terminating {
+		return
+	}
+	entry.terminating = true
+
+	log.WithField(logfields.NodeName, n.Fullname()).Info("Node is terminating")
+
+	m.terminationHandlersMu.RLock()
+	defer m.terminationHandlersMu.RUnlock()
+	for th := range m.terminationHandlers {
+		th.NodeTerminating(entry.node)
+	}
+}
+
+// NodeTerminationCancelled is called when a node is no longer marked as about
+// to be terminated. If the node was previously reported as terminating, all
+// termination handlers are notified so that the node can be registered again.
+func (m *Manager) NodeTerminationCancelled(n node.Node) {
+	m.mutex.RLock()
+	entry, ok := m.nodes[n.Identity()]
+	if !ok {
+		m.mutex.RUnlock()
+		return
+	}
+	entry.mutex.Lock()
+	m.mutex.RUnlock()
+	defer entry.mutex.Unlock()
+
+	if !entry.terminating {
+		return
+	}
+	entry.terminating = false
+
+	m.metricEventsReceived.WithLabelValues("terminationCancelled", string(n.Source)).Inc()
+	log.WithField(logfields.NodeName, n.Fullname()).Info("Node is no longer terminating")
+
+	m.terminationHandlersMu.RLock()
+	defer m.terminationHandlersMu.RUnlock()
+	for th := range m.terminationHandlers {
+		th.NodeTerminationCancelled(entry.node)
+	}
 }
 
 // Exists returns true if a node with the name exists
@@ -438,5 +919,8 @@ func (m *Manager) DeleteAllNodes() {
 		entry.mutex.Unlock()
 	}
 	m.nodes = map[node.Identity]*nodeEntry{}
+	m.cidrIndex = newCIDRIndex()
+	m.cidrConflicts = map[node.Identity]node.Identity{}
+	m.rejectedNodes = map[node.Identity]node.Node{}
 	m.mutex.Unlock()
 }
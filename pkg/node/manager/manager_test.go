@@ -18,14 +18,19 @@ package manager
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/cilium/cilium/pkg/checker"
+	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/datapath"
 	"github.com/cilium/cilium/pkg/datapath/fake"
+	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/option"
 
 	"gopkg.in/check.v1"
 )
@@ -90,6 +95,54 @@ func (n *signalNodeHandler) NodeConfigurationChanged(config datapath.LocalNodeCo
 	return nil
 }
 
+// batchNodeHandler is a signalNodeHandler which also implements
+// datapath.NodeBatchHandler
+type batchNodeHandler struct {
+	*signalNodeHandler
+	NodeBatchAddEvent chan []node.Node
+}
+
+func (n *batchNodeHandler) NodeBatchAdd(newNodes []node.Node) error {
+	n.NodeBatchAddEvent <- newNodes
+	return nil
+}
+
+func (s *managerTestSuite) TestNodeBatchUpdated(c *check.C) {
+	dp := newSignalNodeHandler()
+	dp.EnableNodeAddEvent = true
+	dp.EnableNodeUpdateEvent = true
+	batchDP := &batchNodeHandler{
+		signalNodeHandler: newSignalNodeHandler(),
+		NodeBatchAddEvent: make(chan []node.Node, 1),
+	}
+	batchDP.EnableNodeAddEvent = true
+	batchDP.EnableNodeUpdateEvent = true
+	mngr, err := NewManager("test", dp)
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+	mngr.Subscribe(batchDP)
+
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKVStore}
+	mngr.NodeUpdated(n1)
+	<-dp.NodeAddEvent
+	<-batchDP.NodeAddEvent
+
+	n1.Labels = map[string]string{"a": "b"}
+	n2 := node.Node{Name: "node2", Cluster: "c1", Source: node.FromKVStore}
+	n3 := node.Node{Name: "node3", Cluster: "c1", Source: node.FromKVStore}
+	mngr.NodeBatchUpdated([]node.Node{n1, n2, n3})
+
+	// Known nodes are updated individually, new nodes are added in a
+	// single batch by batch handlers and individually otherwise
+	c.Assert(<-dp.NodeUpdateEvent, checker.DeepEquals, n1)
+	c.Assert(<-batchDP.NodeUpdateEvent, checker.DeepEquals, n1)
+	c.Assert(<-batchDP.NodeBatchAddEvent, checker.DeepEquals, []node.Node{n2, n3})
+	c.Assert(batchDP.NodeAddEvent, check.HasLen, 0)
+	c.Assert(<-dp.NodeAddEvent, checker.DeepEquals, n2)
+	c.Assert(<-dp.NodeAddEvent, checker.DeepEquals, n3)
+	c.Assert(mngr.GetNodes(), check.HasLen, 3)
+}
+
 func (s *managerTestSuite) TestNodeLifecycle(c *check.C) {
 	dp := newSignalNodeHandler()
 	dp.EnableNodeAddEvent = true
@@ -373,3 +426,338 @@ func (s *managerTestSuite) TestBackgroundSync(c *check.C) {
 
 	allNodeValidateCallsReceived.Wait()
 }
+
+type labelsChange struct {
+	name    string
+	changed map[string]string
+	removed []string
+}
+
+type signalLabelsHandler struct {
+	events chan labelsChange
+}
+
+func (l *signalLabelsHandler) NodeLabelsChanged(n node.Node, changed map[string]string, removed []string) {
+	l.events <- labelsChange{name: n.Name, changed: changed, removed: removed}
+}
+
+func (s *managerTestSuite) TestNodeLabelsChanged(c *check.C) {
+	mngr, err := NewManager("test", fake.NewNodeHandler())
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+
+	lh := &signalLabelsHandler{events: make(chan labelsChange, 10)}
+	mngr.SubscribeLabels(lh)
+
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKubernetes}
+	mngr.NodeUpdated(n1)
+
+	n1.Labels = map[string]string{"role": "ingress", "zone": "a"}
+	mngr.NodeUpdated(n1)
+	c.Assert(<-lh.events, checker.DeepEquals, labelsChange{
+		name:    "node1",
+		changed: map[string]string{"role": "ingress", "zone": "a"},
+	})
+
+	// An update without label changes must not emit an event
+	mngr.NodeUpdated(n1)
+
+	n1.Labels = map[string]string{"zone": "a"}
+	mngr.NodeUpdated(n1)
+	c.Assert(<-lh.events, checker.DeepEquals, labelsChange{
+		name:    "node1",
+		changed: map[string]string{},
+		removed: []string{"role"},
+	})
+
+	mngr.NodeDeleted(n1)
+	c.Assert(<-lh.events, checker.DeepEquals, labelsChange{
+		name:    "node1",
+		changed: map[string]string{},
+		removed: []string{"zone"},
+	})
+
+	select {
+	case ev := <-lh.events:
+		c.Errorf("Unexpected labels event %#v", ev)
+	default:
+	}
+}
+
+func (s *managerTestSuite) TestRejectConflictingCIDRs(c *check.C) {
+	oldReject := option.Config.RejectConflictingNodeCIDRs
+	option.Config.RejectConflictingNodeCIDRs = true
+	defer func() { option.Config.RejectConflictingNodeCIDRs = oldReject }()
+
+	mngr, err := NewManager("test", fake.NewNodeHandler())
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKVStore,
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.0.0.0/16")}
+	mngr.NodeUpdated(n1)
+	c.Assert(mngr.Exists(n1.Identity()), check.Equals, true)
+
+	// Overlapping CIDR of a node in another cluster
+	n2 := node.Node{Name: "node2", Cluster: "c2", Source: node.FromKVStore,
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.0.1.0/24")}
+	mngr.NodeUpdated(n2)
+	c.Assert(mngr.Exists(n2.Identity()), check.Equals, false)
+
+	n3 := node.Node{Name: "node3", Cluster: "c2", Source: node.FromKVStore,
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.1.0.0/16")}
+	mngr.NodeUpdated(n3)
+	c.Assert(mngr.Exists(n3.Identity()), check.Equals, true)
+
+	// An update of node3 conflicting with node1 must not be applied
+	n3Conflict := n3
+	n3Conflict.IPv4AllocCIDR = cidr.MustParseCIDR("10.0.0.0/8")
+	mngr.NodeUpdated(n3Conflict)
+	c.Assert(mngr.GetNodes()[n3.Identity()].IPv4AllocCIDR.String(), check.Equals, "10.1.0.0/16")
+
+	// Secondary CIDRs overlapping with another node are conflicts as well
+	n4 := node.Node{Name: "node4", Cluster: "c2", Source: node.FromKVStore,
+		IPv4AllocCIDR:           cidr.MustParseCIDR("10.2.0.0/16"),
+		IPv4SecondaryAllocCIDRs: []*cidr.CIDR{cidr.MustParseCIDR("10.0.2.0/24")}}
+	mngr.NodeUpdated(n4)
+	c.Assert(mngr.Exists(n4.Identity()), check.Equals, false)
+}
+
+type signalTerminationHandler struct {
+	events chan string
+}
+
+func (t *signalTerminationHandler) NodeTerminating(n node.Node) {
+	t.events <- n.Name
+}
+
+func (t *signalTerminationHandler) NodeTerminationCancelled(n node.Node) {
+	t.events <- "cancelled:" + n.Name
+}
+
+func (s *managerTestSuite) TestNodeTerminating(c *check.C) {
+	mngr, err := NewManager("test", fake.NewNodeHandler())
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+
+	th := &signalTerminationHandler{events: make(chan string, 10)}
+	mngr.SubscribeTermination(th)
+
+	// Unknown nodes are ignored
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKubernetes}
+	mngr.NodeTerminating(n1)
+
+	mngr.NodeUpdated(n1)
+	mngr.NodeTerminating(n1)
+	c.Assert(<-th.events, check.Equals, "node1")
+
+	// Handlers are notified only once per node
+	mngr.NodeTerminating(n1)
+
+	// A cancelled termination is announced once and allows the node to
+	// be reported as terminating again
+	mngr.NodeTerminationCancelled(n1)
+	c.Assert(<-th.events, check.Equals, "cancelled:node1")
+	mngr.NodeTerminationCancelled(n1)
+	mngr.NodeTerminating(n1)
+	c.Assert(<-th.events, check.Equals, "node1")
+
+	mngr.UnsubscribeTermination(th)
+	n2 := node.Node{Name: "node2", Cluster: "c1", Source: node.FromKubernetes}
+	mngr.NodeUpdated(n2)
+	mngr.NodeTerminating(n2)
+
+	select {
+	case ev := <-th.events:
+		c.Errorf("Unexpected termination event for node %s", ev)
+	default:
+	}
+}
+
+type signalHealthIPsHandler struct {
+	events chan [2]string
+}
+
+func (h *signalHealthIPsHandler) NodeHealthIPsChanged(oldNode, newNode node.Node) {
+	h.events <- [2]string{oldNode.IPv4HealthIP.String(), newNode.IPv4HealthIP.String()}
+}
+
+func (s *managerTestSuite) TestNodeHealthIPsChanged(c *check.C) {
+	mngr, err := NewManager("test", fake.NewNodeHandler())
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+
+	hh := &signalHealthIPsHandler{events: make(chan [2]string, 10)}
+	mngr.SubscribeHealthIPs(hh)
+
+	// The addition of a node is not a change of its health IPs
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKVStore,
+		IPv4HealthIP: net.ParseIP("10.0.0.10")}
+	mngr.NodeUpdated(n1)
+
+	n1.IPv4HealthIP = net.ParseIP("10.0.0.11")
+	mngr.NodeSoftUpdated(n1)
+	c.Assert(<-hh.events, check.Equals, [2]string{"10.0.0.10", "10.0.0.11"})
+
+	// An update without health IP changes must not emit an event
+	n1.Labels = map[string]string{"zone": "a"}
+	mngr.NodeUpdated(n1)
+
+	mngr.UnsubscribeHealthIPs(hh)
+	n1.IPv4HealthIP = net.ParseIP("10.0.0.12")
+	mngr.NodeUpdated(n1)
+
+	select {
+	case ev := <-hh.events:
+		c.Errorf("Unexpected health IPs event %#v", ev)
+	default:
+	}
+}
+
+type signalAddressHandler struct {
+	datapath.NodeHandler
+	events chan []node.Address
+	err    error
+}
+
+func (h *signalAddressHandler) NodeAddressesChanged(n node.Node, added, removed []node.Address) error {
+	h.events <- added
+	return h.err
+}
+
+func (s *managerTestSuite) TestNodeAddressesChanged(c *check.C) {
+	ah := &signalAddressHandler{NodeHandler: fake.NewNodeHandler(), events: make(chan []node.Address, 10)}
+	mngr, err := NewManager("test", ah)
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+
+	added := []node.Address{{IP: net.ParseIP("10.0.0.1")}}
+
+	// Nodes unknown to the manager are ignored
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKVStore}
+	mngr.NodeAddressesChanged(n1, added, nil)
+
+	mngr.NodeUpdated(n1)
+	mngr.NodeAddressesChanged(n1, added, nil)
+	c.Assert(<-ah.events, checker.DeepEquals, added)
+
+	// Changes from a source not owning the node are ignored
+	n1.Source = node.FromKubernetes
+	mngr.NodeAddressesChanged(n1, added, nil)
+
+	select {
+	case ev := <-ah.events:
+		c.Errorf("Unexpected address event %#v", ev)
+	default:
+	}
+
+	// Handler errors are counted
+	n1.Source = node.FromKVStore
+	ah.err = fmt.Errorf("failed")
+	mngr.NodeAddressesChanged(n1, added, nil)
+	c.Assert(<-ah.events, checker.DeepEquals, added)
+	c.Assert(metrics.GetCounterValue(mngr.metricHandlerErrors.WithLabelValues("addresses")), check.Equals, float64(1))
+}
+
+type cidrConflict struct {
+	name, other string
+	rejected    bool
+}
+
+type signalCIDRConflictHandler struct {
+	conflicts chan cidrConflict
+}
+
+func (h *signalCIDRConflictHandler) NodeCIDRConflict(n, other node.Node, rejected bool) {
+	h.conflicts <- cidrConflict{name: n.Name, other: other.Name, rejected: rejected}
+}
+
+func (s *managerTestSuite) TestRejectedNodeAppliedAfterConflictResolved(c *check.C) {
+	oldReject := option.Config.RejectConflictingNodeCIDRs
+	option.Config.RejectConflictingNodeCIDRs = true
+	defer func() { option.Config.RejectConflictingNodeCIDRs = oldReject }()
+
+	mngr, err := NewManager("test", fake.NewNodeHandler())
+	c.Assert(err, check.IsNil)
+	defer mngr.Close()
+
+	ch := &signalCIDRConflictHandler{conflicts: make(chan cidrConflict, 10)}
+	mngr.SubscribeCIDRConflicts(ch)
+
+	n1 := node.Node{Name: "node1", Cluster: "c1", Source: node.FromKVStore,
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.0.0.0/16")}
+	mngr.NodeUpdated(n1)
+
+	n2 := node.Node{Name: "node2", Cluster: "c2", Source: node.FromKVStore,
+		IPv6AllocCIDR: cidr.MustParseCIDR("f00d::/96"),
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.0.0.0/8")}
+	mngr.NodeUpdated(n2)
+	c.Assert(<-ch.conflicts, check.Equals, cidrConflict{name: "node2", other: "node1", rejected: true})
+
+	// Repeated updates of the same conflict are only reported once
+	mngr.NodeUpdated(n2)
+	select {
+	case conflict := <-ch.conflicts:
+		c.Errorf("Unexpected conflict reported: %v", conflict)
+	default:
+	}
+
+	// The rejected update is applied once node1 is gone
+	mngr.NodeDeleted(n1)
+	c.Assert(mngr.Exists(n2.Identity()), check.Equals, true)
+
+	// A rejected node deleted before the conflict is resolved must not
+	// be applied later on
+	n3 := node.Node{Name: "node3", Cluster: "c2", Source: node.FromKVStore,
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.1.0.0/24")}
+	mngr.NodeUpdated(n3)
+	c.Assert(<-ch.conflicts, check.Equals, cidrConflict{name: "node3", other: "node2", rejected: true})
+	mngr.NodeDeleted(n3)
+
+	// Moving node2 away from the conflicting CIDR must not add node3
+	n2.IPv4AllocCIDR = cidr.MustParseCIDR("192.168.0.0/16")
+	mngr.NodeUpdated(n2)
+	c.Assert(mngr.Exists(n3.Identity()), check.Equals, false)
+}
+
+func (s *managerTestSuite) TestCIDRIndexOverlapping(c *check.C) {
+	index := newCIDRIndex()
+	n1 := node.Node{Name: "node1", IPv4AllocCIDR: cidr.MustParseCIDR("10.0.0.0/16"),
+		IPv6AllocCIDR: cidr.MustParseCIDR("f00d::/96")}
+	index.upsert(n1.Identity(), n1)
+
+	for _, tt := range []struct {
+		cidr    string
+		overlap bool
+	}{
+		{"10.0.0.0/16", true},
+		{"10.0.0.0/8", true},
+		{"10.0.128.0/24", true},
+		{"10.1.0.0/16", false},
+		{"0.0.0.0/0", true},
+		{"f00d::/64", true},
+		{"f00d::1:0:0/96", false},
+		{"beef::/96", false},
+	} {
+		n := node.Node{Name: "node2"}
+		if strings.Contains(tt.cidr, ":") {
+			n.IPv6AllocCIDR = cidr.MustParseCIDR(tt.cidr)
+		} else {
+			n.IPv4AllocCIDR = cidr.MustParseCIDR(tt.cidr)
+		}
+		other, overlap := index.overlapping(n.Identity(), n)
+		c.Assert(overlap, check.Equals, tt.overlap, check.Commentf("%s", tt.cidr))
+		if overlap {
+			c.Assert(other, check.Equals, n1.Identity())
+		}
+	}
+
+	// A node never conflicts with itself
+	_, overlap := index.overlapping(n1.Identity(), n1)
+	c.Assert(overlap, check.Equals, false)
+
+	index.delete(n1.Identity())
+	c.Assert(index.v4.size, check.Equals, 0)
+	c.Assert(index.v6.size, check.Equals, 0)
+	c.Assert(index.v4.children, check.Equals, [2]*cidrTrieNode{})
+}
@@ -15,12 +15,16 @@
 package node
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net"
 	"path"
+	"sort"
 
 	"github.com/cilium/cilium/api/v1/models"
 	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/comparator"
 	"github.com/cilium/cilium/pkg/defaults"
 	"github.com/cilium/cilium/pkg/kvstore/store"
 	"github.com/cilium/cilium/pkg/node/addressing"
@@ -78,6 +82,16 @@ type Node struct {
 	// allocates IPs for local endpoints from
 	IPv6AllocCIDR *cidr.CIDR
 
+	// IPv4SecondaryAllocCIDRs are additional IPv4 address pools out of
+	// which the node allocates IPs for local endpoints once
+	// IPv4AllocCIDR has been exhausted
+	IPv4SecondaryAllocCIDRs []*cidr.CIDR `json:",omitempty"`
+
+	// IPv6SecondaryAllocCIDRs are additional IPv6 address pools out of
+	// which the node allocates IPs for local endpoints once
+	// IPv6AllocCIDR has been exhausted
+	IPv6SecondaryAllocCIDRs []*cidr.CIDR `json:",omitempty"`
+
 	// IPv4HealthIP if not nil, this is the IPv4 address of the
 	// cilium-health endpoint located on the node.
 	IPv4HealthIP net.IP
@@ -94,6 +108,32 @@ type Node struct {
 
 	// Key index used for transparent encryption or 0 for no encryption
 	EncryptionKey uint8
+
+	// EncryptionPublicKey is the public key peers use to encrypt traffic
+	// to the node, e.g. a base64 encoded WireGuard public key, or empty if
+	// the node does not use public key encryption
+	EncryptionPublicKey string `json:",omitempty"`
+
+	// Labels is the set of labels associated with the node, e.g. the
+	// labels of the corresponding Kubernetes node resource
+	Labels map[string]string
+
+	// MTU is the MTU of the network device used by the node to reach its
+	// peers, or 0 if unknown
+	MTU int
+
+	// TunnelProtocol is the encapsulation protocol used by the node, e.g.
+	// "vxlan" or "geneve", or empty if tunneling is disabled
+	TunnelProtocol string
+
+	// TunnelPort is the UDP destination port of encapsulated traffic sent
+	// to the node, or 0 if tunneling is disabled
+	TunnelPort uint16
+
+	// Hash is the hash of all other fields of the node, set when the node
+	// is marshaled. It allows receivers to detect updates which do not
+	// change the node. Empty for nodes published by older versions.
+	Hash string `json:",omitempty"`
 }
 
 // Fullname returns the node's full name including the cluster name if a
@@ -114,44 +154,95 @@ type Address struct {
 	IP   net.IP
 }
 
+// addressPriority returns the priority of addr when selecting the node IP,
+// lower values take precedence. Addresses within one of the preferred CIDRs
+// take precedence over all other addresses, ties are broken by the position
+// of the address type in the configured node address types. Address types
+// not configured come last.
+func addressPriority(addr Address) int {
+	types := option.Config.NodeAddressTypes
+	priority := len(types)
+	for i, t := range types {
+		if string(addr.Type) == t {
+			priority = i
+			break
+		}
+	}
+
+	if preferred := option.Config.NodeAddressPreferredCIDRs; len(preferred) != 0 {
+		for _, ipnet := range preferred {
+			if ipnet.Contains(addr.IP) {
+				return priority
+			}
+		}
+		priority += len(types) + 1
+	}
+
+	return priority
+}
+
+// SortAddresses sorts addrs by the priority of the addresses when selecting
+// the node IP, see GetNodeIP
+func SortAddresses(addrs []Address) {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		return addressPriority(addrs[i]) < addressPriority(addrs[j])
+	})
+}
+
+// AddressesDiff compares the old and new addresses of a node and returns all
+// addresses which have been added and removed. An address whose type has
+// changed is reported as removed and added.
+func AddressesDiff(oldAddrs, newAddrs []Address) (added, removed []Address) {
+	for _, addr := range newAddrs {
+		if !containsAddress(oldAddrs, addr) {
+			added = append(added, addr)
+		}
+	}
+
+	for _, addr := range oldAddrs {
+		if !containsAddress(newAddrs, addr) {
+			removed = append(removed, addr)
+		}
+	}
+
+	return
+}
+
+func containsAddress(addrs []Address, addr Address) bool {
+	for _, a := range addrs {
+		if a.Type == addr.Type && a.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
 func (n *Node) getNodeIP(ipv6 bool) (net.IP, addressing.AddressType) {
 	var (
-		backupIP net.IP
+		nodeIP   net.IP
 		ipType   addressing.AddressType
+		priority int
 	)
 	for _, addr := range n.IPAddresses {
 		if (ipv6 && addr.IP.To4() != nil) ||
 			(!ipv6 && addr.IP.To4() == nil) {
 			continue
 		}
-		switch addr.Type {
 		// Ignore CiliumInternalIPs
-		case addressing.NodeCiliumInternalIP:
+		if addr.Type == addressing.NodeCiliumInternalIP {
 			continue
-		// Always prefer a cluster internal IP
-		case addressing.NodeInternalIP:
-			return addr.IP, addr.Type
-		case addressing.NodeExternalIP:
-			// Fall back to external Node IP
-			// if no internal IP could be found
-			backupIP = addr.IP
-			ipType = addr.Type
-		default:
-			// As a last resort, if no internal or external
-			// IP was found, use any node address available
-			if backupIP == nil {
-				backupIP = addr.IP
-				ipType = addr.Type
-			}
+		}
+		if p := addressPriority(addr); nodeIP == nil || p < priority {
+			nodeIP, ipType, priority = addr.IP, addr.Type, p
 		}
 	}
-	return backupIP, ipType
+	return nodeIP, ipType
 }
 
 // GetNodeIP returns one of the node's IP addresses available with the
 // following priority:
-// - NodeInternalIP
-// - NodeExternalIP
+// - addresses within one of the preferred node address CIDRs
+// - the configured node address types in order (default NodeInternalIP, NodeExternalIP)
 // - other IP address type
 func (n *Node) GetNodeIP(ipv6 bool) net.IP {
 	result, _ := n.getNodeIP(ipv6)
@@ -285,6 +376,13 @@ func (n *Node) IsLocal() bool {
 	return n != nil && n.Name == GetName() && n.Cluster == getCluster()
 }
 
+// HealthIPsEqual returns true if the IPs of the health endpoints of both nodes
+// are the same
+func (n *Node) HealthIPsEqual(o *Node) bool {
+	return n.IPv4HealthIP.Equal(o.IPv4HealthIP) &&
+		n.IPv6HealthIP.Equal(o.IPv6HealthIP)
+}
+
 // PublicAttrEquals returns true only if the public attributes of both nodes
 // are the same otherwise returns false.
 func (n *Node) PublicAttrEquals(o *Node) bool {
@@ -292,12 +390,34 @@ func (n *Node) PublicAttrEquals(o *Node) bool {
 		return false
 	}
 
-	if n.Name == o.Name &&
+	return n.Name == o.Name &&
 		n.Cluster == o.Cluster &&
-		n.IPv4HealthIP.Equal(o.IPv4HealthIP) &&
-		n.IPv6HealthIP.Equal(o.IPv6HealthIP) &&
+		n.HealthIPsEqual(o) &&
 		n.ClusterID == o.ClusterID &&
-		n.Source == o.Source {
+		n.Source == o.Source &&
+		comparator.MapStringEquals(n.Labels, o.Labels) &&
+		n.datapathAttrEquals(o)
+}
+
+// DatapathAttrEquals returns true if the attributes of both nodes used to
+// program the datapath, i.e. the addresses, allocation CIDRs, encryption
+// keys, MTU and tunnel configuration, are the same. Other attributes such as
+// the labels and health IPs can change without reprogramming the datapath.
+func (n *Node) DatapathAttrEquals(o *Node) bool {
+	if (n == nil) != (o == nil) {
+		return false
+	}
+
+	return n.EncryptionKey == o.EncryptionKey && n.datapathAttrEquals(o)
+}
+
+// datapathAttrEquals compares the datapath attributes of both nodes shared by
+// PublicAttrEquals and DatapathAttrEquals
+func (n *Node) datapathAttrEquals(o *Node) bool {
+	if n.MTU == o.MTU &&
+		n.TunnelProtocol == o.TunnelProtocol &&
+		n.TunnelPort == o.TunnelPort &&
+		n.EncryptionPublicKey == o.EncryptionPublicKey {
 
 		if len(n.IPAddresses) != len(o.IPAddresses) {
 			return false
@@ -313,23 +433,84 @@ func (n *Node) PublicAttrEquals(o *Node) bool {
 		if (n.IPv4AllocCIDR == nil) != (o.IPv4AllocCIDR == nil) {
 			return false
 		}
-		if n.IPv4AllocCIDR.String() != o.IPv4AllocCIDR.String() {
+		if n.IPv4AllocCIDR != nil && n.IPv4AllocCIDR.String() != o.IPv4AllocCIDR.String() {
 			return false
 		}
 
 		if (n.IPv6AllocCIDR == nil) != (o.IPv6AllocCIDR == nil) {
 			return false
 		}
-		if n.IPv6AllocCIDR.String() != o.IPv6AllocCIDR.String() {
+		if n.IPv6AllocCIDR != nil && n.IPv6AllocCIDR.String() != o.IPv6AllocCIDR.String() {
 			return false
 		}
 
-		return true
+		return cidrListEquals(n.IPv4SecondaryAllocCIDRs, o.IPv4SecondaryAllocCIDRs) &&
+			cidrListEquals(n.IPv6SecondaryAllocCIDRs, o.IPv6SecondaryAllocCIDRs)
 	}
 
 	return false
 }
 
+// cidrListEquals returns true if both lists contain the same CIDRs in the same
+// order
+func cidrListEquals(a, b []*cidr.CIDR) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) {
+			return false
+		}
+		if a[i] != nil && a[i].String() != b[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// GetIPv4AllocCIDRs returns the primary IPv4 allocation CIDR of the node, if
+// set, followed by the secondary IPv4 allocation CIDRs
+func (n *Node) GetIPv4AllocCIDRs() []*cidr.CIDR {
+	return allocCIDRs(n.IPv4AllocCIDR, n.IPv4SecondaryAllocCIDRs)
+}
+
+// GetIPv6AllocCIDRs returns the primary IPv6 allocation CIDR of the node, if
+// set, followed by the secondary IPv6 allocation CIDRs
+func (n *Node) GetIPv6AllocCIDRs() []*cidr.CIDR {
+	return allocCIDRs(n.IPv6AllocCIDR, n.IPv6SecondaryAllocCIDRs)
+}
+
+func allocCIDRs(primary *cidr.CIDR, secondary []*cidr.CIDR) []*cidr.CIDR {
+	result := make([]*cidr.CIDR, 0, 1+len(secondary))
+	if primary != nil {
+		result = append(result, primary)
+	}
+	return append(result, secondary...)
+}
+
+// PeerMTU returns the MTU to use for traffic towards the node, i.e. the
+// smaller of localMTU and the MTU announced by the node. Nodes which do not
+// announce an MTU, e.g. because they run an older version, are assumed to use
+// localMTU.
+func (n *Node) PeerMTU(localMTU int) int {
+	if n.MTU > 0 && n.MTU < localMTU {
+		return n.MTU
+	}
+	return localMTU
+}
+
+// TunnelPortForProtocol returns the default UDP port of the given tunnel
+// protocol or 0 if the protocol is unknown or tunneling is disabled
+func TunnelPortForProtocol(protocol string) uint16 {
+	switch protocol {
+	case option.TunnelVXLAN:
+		return defaults.TunnelPortVXLAN
+	case option.TunnelGeneve:
+		return defaults.TunnelPortGeneve
+	}
+	return 0
+}
+
 // GetKeyNodeName constructs the API name for the given cluster and node name.
 func GetKeyNodeName(cluster, node string) string {
 	// WARNING - STABLE API: Changing the structure of the key may break
@@ -347,9 +528,40 @@ func (n *Node) DeepKeyCopy() store.LocalKey {
 	return n.DeepCopy()
 }
 
-// Marshal returns the node object as JSON byte slice
+// Marshal returns the node object as JSON byte slice including the hash of
+// the node
 func (n *Node) Marshal() ([]byte, error) {
-	return json.Marshal(n)
+	nodeCopy := *n
+	nodeCopy.Hash = ""
+	data, err := json.Marshal(&nodeCopy)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeCopy.Hash = hashNode(data)
+	return json.Marshal(&nodeCopy)
+}
+
+// hashNode returns the hash of the JSON representation of a node without
+// hash
+func hashNode(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// GetHash returns the hash of the node. The hash is computed if the node
+// does not carry one, e.g. because it was published by an older version.
+func (n *Node) GetHash() string {
+	if n.Hash != "" {
+		return n.Hash
+	}
+
+	nodeCopy := *n
+	data, err := json.Marshal(&nodeCopy)
+	if err != nil {
+		return ""
+	}
+	return hashNode(data)
 }
 
 // Unmarshal parses the JSON byte slice and updates the node receiver
@@ -27,6 +27,7 @@ import (
 	"github.com/cilium/cilium/pkg/byteorder"
 	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/option"
 )
@@ -44,7 +45,14 @@ var (
 	ipv4AllocRange      *cidr.CIDR
 	ipv6AllocRange      *cidr.CIDR
 
-	ipsecKeyIdentity uint8
+	// secondaryAllocRangesMutex protects the secondary allocation ranges
+	// which are updated at runtime
+	secondaryAllocRangesMutex lock.RWMutex
+	ipv4SecondaryAllocRanges  []*cidr.CIDR
+	ipv6SecondaryAllocRanges  []*cidr.CIDR
+
+	ipsecKeyIdentity    uint8
+	encryptionPublicKey string
 )
 
 func makeIPv6HostIP() net.IP {
@@ -191,6 +199,31 @@ func GetIPv6AllocRange() *cidr.CIDR {
 	})
 }
 
+// SetSecondaryAllocRanges sets the secondary IPv4 and IPv6 allocation prefixes
+// of this node
+func SetSecondaryAllocRanges(v4, v6 []*cidr.CIDR) {
+	secondaryAllocRangesMutex.Lock()
+	ipv4SecondaryAllocRanges = append([]*cidr.CIDR(nil), v4...)
+	ipv6SecondaryAllocRanges = append([]*cidr.CIDR(nil), v6...)
+	secondaryAllocRangesMutex.Unlock()
+}
+
+// GetIPv4SecondaryAllocRanges returns the secondary IPv4 allocation prefixes
+// of this node
+func GetIPv4SecondaryAllocRanges() []*cidr.CIDR {
+	secondaryAllocRangesMutex.RLock()
+	defer secondaryAllocRangesMutex.RUnlock()
+	return append([]*cidr.CIDR(nil), ipv4SecondaryAllocRanges...)
+}
+
+// GetIPv6SecondaryAllocRanges returns the secondary IPv6 allocation prefixes
+// of this node
+func GetIPv6SecondaryAllocRanges() []*cidr.CIDR {
+	secondaryAllocRangesMutex.RLock()
+	defer secondaryAllocRangesMutex.RUnlock()
+	return append([]*cidr.CIDR(nil), ipv6SecondaryAllocRanges...)
+}
+
 // GetIPv6NodeRange returns the IPv6 allocation prefix of this node
 func GetIPv6NodeRange() *cidr.CIDR {
 	return ipv6AllocRange
@@ -432,3 +465,14 @@ func SetIPsecKeyIdentity(id uint8) {
 func GetIPsecKeyIdentity() uint8 {
 	return ipsecKeyIdentity
 }
+
+// SetEncryptionPublicKey sets the public key peers use to encrypt traffic
+// to the node, e.g. a base64 encoded WireGuard public key
+func SetEncryptionPublicKey(key string) {
+	encryptionPublicKey = key
+}
+
+// GetEncryptionPublicKey returns the encryption public key of the node
+func GetEncryptionPublicKey() string {
+	return encryptionPublicKey
+}
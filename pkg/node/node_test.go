@@ -20,8 +20,10 @@ import (
 	"net"
 	"testing"
 
+	"github.com/cilium/cilium/pkg/checker"
 	"github.com/cilium/cilium/pkg/cidr"
 	"github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/option"
 
 	. "gopkg.in/check.v1"
 )
@@ -73,6 +75,39 @@ func (s *NodeSuite) TestGetNodeIP(c *C) {
 
 }
 
+func (s *NodeSuite) TestGetNodeIPPreference(c *C) {
+	oldTypes := option.Config.NodeAddressTypes
+	oldPreferred := option.Config.NodeAddressPreferredCIDRs
+	defer func() {
+		option.Config.NodeAddressTypes = oldTypes
+		option.Config.NodeAddressPreferredCIDRs = oldPreferred
+	}()
+
+	n := Node{
+		Name: "node-1",
+		IPAddresses: []Address{
+			{IP: net.ParseIP("198.51.100.2"), Type: addressing.NodeInternalIP},
+			{IP: net.ParseIP("192.0.2.3"), Type: addressing.NodeExternalIP},
+			{IP: net.ParseIP("203.0.113.4"), Type: addressing.NodeInternalIP},
+		},
+	}
+
+	option.Config.NodeAddressTypes = []string{"ExternalIP", "InternalIP"}
+	c.Assert(n.GetNodeIP(false).String(), Equals, "192.0.2.3")
+
+	// Addresses within a preferred CIDR take precedence over the type
+	_, preferred, _ := net.ParseCIDR("203.0.113.0/24")
+	option.Config.NodeAddressPreferredCIDRs = []*net.IPNet{preferred}
+	c.Assert(n.GetNodeIP(false).String(), Equals, "203.0.113.4")
+
+	SortAddresses(n.IPAddresses)
+	c.Assert(n.IPAddresses, checker.DeepEquals, []Address{
+		{IP: net.ParseIP("203.0.113.4"), Type: addressing.NodeInternalIP},
+		{IP: net.ParseIP("192.0.2.3"), Type: addressing.NodeExternalIP},
+		{IP: net.ParseIP("198.51.100.2"), Type: addressing.NodeInternalIP},
+	})
+}
+
 func (s *NodeSuite) TestPublicAttrEquals(c *C) {
 	type fields struct {
 		Name          string
@@ -254,3 +289,169 @@ func (s *NodeSuite) TestPublicAttrEquals(c *C) {
 		c.Assert(got, Equals, tt.want)
 	}
 }
+
+func (s *NodeSuite) TestLabelsDiff(c *C) {
+	changed, removed := LabelsDiff(nil, nil)
+	c.Assert(len(changed), Equals, 0)
+	c.Assert(len(removed), Equals, 0)
+
+	changed, removed = LabelsDiff(
+		map[string]string{"a": "1", "b": "2", "c": "3"},
+		map[string]string{"a": "1", "b": "3", "d": "4"})
+	c.Assert(changed, DeepEquals, map[string]string{"b": "3", "d": "4"})
+	c.Assert(removed, DeepEquals, []string{"c"})
+}
+
+func (s *NodeSuite) TestAddressesDiff(c *C) {
+	a := Address{Type: addressing.NodeInternalIP, IP: net.ParseIP("10.0.0.1")}
+	b := Address{Type: addressing.NodeExternalIP, IP: net.ParseIP("1.1.1.1")}
+	bInternal := Address{Type: addressing.NodeInternalIP, IP: net.ParseIP("1.1.1.1")}
+
+	added, removed := AddressesDiff(nil, nil)
+	c.Assert(added, HasLen, 0)
+	c.Assert(removed, HasLen, 0)
+
+	added, removed = AddressesDiff([]Address{a, b}, []Address{a, b})
+	c.Assert(added, HasLen, 0)
+	c.Assert(removed, HasLen, 0)
+
+	// A changed address type is a removal and an addition
+	added, removed = AddressesDiff([]Address{a, b}, []Address{bInternal})
+	c.Assert(added, DeepEquals, []Address{bInternal})
+	c.Assert(removed, DeepEquals, []Address{a, b})
+}
+
+func (s *NodeSuite) TestTopologyLabels(c *C) {
+	n := &Node{}
+	c.Assert(n.Zone(), Equals, "")
+	c.Assert(n.Region(), Equals, "")
+
+	// Nodes published without normalized labels
+	n.Labels = map[string]string{
+		"failure-domain.beta.kubernetes.io/zone":   "us-west-1a",
+		"failure-domain.beta.kubernetes.io/region": "us-west-1",
+	}
+	c.Assert(n.Zone(), Equals, "us-west-1a")
+	c.Assert(n.Region(), Equals, "us-west-1")
+
+	// The GA labels take precedence over the beta labels
+	labels := map[string]string{
+		"failure-domain.beta.kubernetes.io/zone":   "us-west-1a",
+		"failure-domain.beta.kubernetes.io/region": "us-west-1",
+		TopologyZoneLabel:                          "us-west-1b",
+	}
+	NormalizeTopologyLabels(labels)
+	c.Assert(labels[TopologyZoneLabel], Equals, "us-west-1b")
+	c.Assert(labels[TopologyRegionLabel], Equals, "us-west-1")
+
+	n.Labels = labels
+	c.Assert(n.Zone(), Equals, "us-west-1b")
+
+	NormalizeTopologyLabels(nil)
+}
+
+func (s *NodeSuite) TestPublicAttrEqualsTunnel(c *C) {
+	n := &Node{
+		Name:           "foo",
+		IPv4AllocCIDR:  cidr.MustParseCIDR("10.0.0.0/24"),
+		IPv6AllocCIDR:  cidr.MustParseCIDR("f00d::/96"),
+		MTU:            1500,
+		TunnelProtocol: "vxlan",
+		TunnelPort:     8472,
+	}
+	c.Assert(n.PublicAttrEquals(n.DeepCopy()), Equals, true)
+
+	o := n.DeepCopy()
+	o.MTU = 9000
+	c.Assert(n.PublicAttrEquals(o), Equals, false)
+
+	o = n.DeepCopy()
+	o.TunnelProtocol = "geneve"
+	o.TunnelPort = 6081
+	c.Assert(n.PublicAttrEquals(o), Equals, false)
+
+	o = n.DeepCopy()
+	o.EncryptionPublicKey = "rotated"
+	c.Assert(n.PublicAttrEquals(o), Equals, false)
+}
+
+func (s *NodeSuite) TestDatapathAttrEquals(c *C) {
+	n := &Node{
+		Name:          "foo",
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.0.0.0/24"),
+		IPv4HealthIP:  net.ParseIP("10.0.0.2"),
+		Labels:        map[string]string{"a": "b"},
+		MTU:           1500,
+	}
+	c.Assert(n.DatapathAttrEquals(n.DeepCopy()), Equals, true)
+	c.Assert(n.DatapathAttrEquals(nil), Equals, false)
+
+	// Labels and health IPs are not used by the datapath
+	o := n.DeepCopy()
+	o.Labels["a"] = "c"
+	o.IPv4HealthIP = net.ParseIP("10.0.0.3")
+	c.Assert(n.DatapathAttrEquals(o), Equals, true)
+	c.Assert(n.PublicAttrEquals(o), Equals, false)
+
+	o = n.DeepCopy()
+	o.IPv4AllocCIDR = cidr.MustParseCIDR("10.0.1.0/24")
+	c.Assert(n.DatapathAttrEquals(o), Equals, false)
+
+	o = n.DeepCopy()
+	o.EncryptionKey = 2
+	c.Assert(n.DatapathAttrEquals(o), Equals, false)
+
+	o = n.DeepCopy()
+	o.IPAddresses = []Address{{Type: addressing.NodeInternalIP, IP: net.ParseIP("192.168.0.1")}}
+	c.Assert(n.DatapathAttrEquals(o), Equals, false)
+
+	o = n.DeepCopy()
+	o.IPv4SecondaryAllocCIDRs = []*cidr.CIDR{cidr.MustParseCIDR("10.1.0.0/24")}
+	c.Assert(n.DatapathAttrEquals(o), Equals, false)
+	c.Assert(o.DatapathAttrEquals(o.DeepCopy()), Equals, true)
+}
+
+func (s *NodeSuite) TestGetAllocCIDRs(c *C) {
+	n := &Node{
+		IPv4SecondaryAllocCIDRs: []*cidr.CIDR{cidr.MustParseCIDR("10.1.0.0/24")},
+	}
+	c.Assert(n.GetIPv4AllocCIDRs(), checker.DeepEquals, []*cidr.CIDR{cidr.MustParseCIDR("10.1.0.0/24")})
+	c.Assert(n.GetIPv6AllocCIDRs(), HasLen, 0)
+
+	n.IPv4AllocCIDR = cidr.MustParseCIDR("10.0.0.0/24")
+	c.Assert(n.GetIPv4AllocCIDRs(), checker.DeepEquals, []*cidr.CIDR{
+		cidr.MustParseCIDR("10.0.0.0/24"),
+		cidr.MustParseCIDR("10.1.0.0/24"),
+	})
+}
+
+func (s *NodeSuite) TestPeerMTU(c *C) {
+	c.Assert((&Node{}).PeerMTU(1500), Equals, 1500)
+	c.Assert((&Node{MTU: 1450}).PeerMTU(1500), Equals, 1450)
+	c.Assert((&Node{MTU: 9000}).PeerMTU(1500), Equals, 1500)
+}
+
+func (s *NodeSuite) TestTunnelPortForProtocol(c *C) {
+	c.Assert(TunnelPortForProtocol("vxlan"), Equals, uint16(8472))
+	c.Assert(TunnelPortForProtocol("geneve"), Equals, uint16(6081))
+	c.Assert(TunnelPortForProtocol("disabled"), Equals, uint16(0))
+}
+
+func (s *NodeSuite) TestMarshalHash(c *C) {
+	n := Node{Name: "foo", Cluster: "default", Labels: map[string]string{"a": "b"}}
+
+	data, err := n.Marshal()
+	c.Assert(err, IsNil)
+	received := Node{}
+	c.Assert(received.Unmarshal(data), IsNil)
+	c.Assert(received.Hash, Not(Equals), "")
+	c.Assert(received.GetHash(), Equals, n.GetHash())
+
+	// Marshaling a received node does not hash the previous hash
+	data2, err := received.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(string(data2), Equals, string(data))
+
+	n.Labels["a"] = "c"
+	c.Assert(n.GetHash(), Not(Equals), received.Hash)
+}
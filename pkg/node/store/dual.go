@@ -0,0 +1,141 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+)
+
+// DualRegistrar publishes the local node to two registration backends and
+// discovers the nodes of the cluster through the primary backend only. It
+// allows to migrate a cluster between backends without a connectivity gap:
+// agents publishing to both backends are visible to all agents regardless
+// of the backend they discover nodes through.
+type DualRegistrar struct {
+	// Primary is the backend the nodes of the cluster are discovered
+	// through
+	Primary Registrar
+
+	// Secondary is the backend the local node is additionally
+	// published to
+	Secondary Registrar
+
+	// mutex protects published
+	mutex lock.Mutex
+
+	// published is true once the local node has been published to the
+	// secondary backend
+	published bool
+}
+
+// RegisterNode registers the local node n with the primary backend and
+// passes the nodes discovered through it to manager. A failure to publish
+// n to the secondary backend does not fail the registration, publishing is
+// retried by later calls of UpdateLocalKeySync and EnsureRegistered.
+func (d *DualRegistrar) RegisterNode(n *node.Node, manager NodeManager) error {
+	if err := d.Primary.RegisterNode(n, manager); err != nil {
+		return err
+	}
+
+	if err := d.publishSecondary(n); err != nil {
+		log.WithError(err).WithField(logfields.NodeName, n.Name).
+			Warning("Unable to publish local node to secondary registration backend")
+	}
+	return nil
+}
+
+// PublishNode publishes the local node n to both backends
+func (d *DualRegistrar) PublishNode(n *node.Node) error {
+	if err := d.Primary.PublishNode(n); err != nil {
+		return err
+	}
+	return d.publishSecondary(n)
+}
+
+// publishSecondary publishes the local node n to the secondary backend,
+// joining the backend first if required
+func (d *DualRegistrar) publishSecondary(n *node.Node) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.published {
+		return d.Secondary.UpdateLocalKeySync(n)
+	}
+	if err := d.Secondary.PublishNode(n); err != nil {
+		return err
+	}
+	d.published = true
+	return nil
+}
+
+// UpdateLocalKeySync publishes the local node n to both backends. Returns
+// the error of the primary backend, if any, or the one of the secondary.
+func (d *DualRegistrar) UpdateLocalKeySync(n *node.Node) error {
+	err := d.Primary.UpdateLocalKeySync(n)
+	if err2 := d.publishSecondary(n); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// EnsureRegistered re-registers the local node n with each backend it went
+// missing in. Returns true if the node has been re-registered with any
+// backend.
+func (d *DualRegistrar) EnsureRegistered(n *node.Node) (bool, error) {
+	reregistered, err := d.Primary.EnsureRegistered(n)
+
+	d.mutex.Lock()
+	published := d.published
+	d.mutex.Unlock()
+
+	var err2 error
+	if published {
+		var secondary bool
+		secondary, err2 = d.Secondary.EnsureRegistered(n)
+		reregistered = reregistered || secondary
+	} else if err2 = d.publishSecondary(n); err2 == nil {
+		reregistered = true
+	}
+
+	if err == nil {
+		err = err2
+	}
+	return reregistered, err
+}
+
+// DeleteLocalNode withdraws the local node n from both backends
+func (d *DualRegistrar) DeleteLocalNode(n *node.Node) {
+	d.Primary.DeleteLocalNode(n)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.published {
+		d.Secondary.DeleteLocalNode(n)
+	}
+}
+
+// RefreshHostKeys re-applies the ipcache entries of all nodes discovered
+// through the primary backend
+func (d *DualRegistrar) RefreshHostKeys() {
+	d.Primary.RefreshHostKeys()
+}
+
+// GetStoreEntries returns all nodes known to the primary backend
+func (d *DualRegistrar) GetStoreEntries() []*models.NodeStoreEntry {
+	return d.Primary.GetStoreEntries()
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"errors"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/node"
+
+	. "gopkg.in/check.v1"
+)
+
+type fakeRegistrar struct {
+	err       error
+	registers int
+	publishes int
+	updates   int
+	deletes   int
+	entries   []*models.NodeStoreEntry
+}
+
+func (f *fakeRegistrar) RegisterNode(n *node.Node, manager NodeManager) error {
+	f.registers++
+	return f.err
+}
+
+func (f *fakeRegistrar) PublishNode(n *node.Node) error {
+	f.publishes++
+	return f.err
+}
+
+func (f *fakeRegistrar) UpdateLocalKeySync(n *node.Node) error {
+	f.updates++
+	return f.err
+}
+
+func (f *fakeRegistrar) EnsureRegistered(n *node.Node) (bool, error) {
+	return false, f.err
+}
+
+func (f *fakeRegistrar) DeleteLocalNode(n *node.Node) {
+	f.deletes++
+}
+
+func (f *fakeRegistrar) RefreshHostKeys() {}
+
+func (f *fakeRegistrar) GetStoreEntries() []*models.NodeStoreEntry {
+	return f.entries
+}
+
+func (s *NodeStoreSuite) TestDualRegistrar(c *C) {
+	n := &node.Node{Name: "node1"}
+	primary := &fakeRegistrar{entries: []*models.NodeStoreEntry{{Name: "node1"}}}
+	secondary := &fakeRegistrar{err: errors.New("unavailable")}
+	d := &DualRegistrar{Primary: primary, Secondary: secondary}
+
+	// A failure of the secondary backend does not fail the registration
+	c.Assert(d.RegisterNode(n, nil), IsNil)
+	c.Assert(primary.registers, Equals, 1)
+	c.Assert(secondary.publishes, Equals, 1)
+
+	// Nodes are only discovered through the primary backend
+	c.Assert(d.GetStoreEntries(), DeepEquals, primary.entries)
+
+	// Publishing is retried until it succeeds
+	c.Assert(d.UpdateLocalKeySync(n), Not(IsNil))
+	c.Assert(secondary.publishes, Equals, 2)
+	c.Assert(secondary.updates, Equals, 0)
+
+	// The node is not withdrawn from a backend it was never published to
+	d.DeleteLocalNode(n)
+	c.Assert(secondary.deletes, Equals, 0)
+
+	secondary.err = nil
+	reregistered, err := d.EnsureRegistered(n)
+	c.Assert(err, IsNil)
+	c.Assert(reregistered, Equals, true)
+	c.Assert(secondary.publishes, Equals, 3)
+
+	// Once published, the secondary backend is updated in place
+	c.Assert(d.UpdateLocalKeySync(n), IsNil)
+	c.Assert(secondary.publishes, Equals, 3)
+	c.Assert(secondary.updates, Equals, 1)
+	c.Assert(primary.updates, Equals, 2)
+
+	d.DeleteLocalNode(n)
+	c.Assert(primary.deletes, Equals, 2)
+	c.Assert(secondary.deletes, Equals, 1)
+}
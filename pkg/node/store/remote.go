@@ -0,0 +1,239 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"path"
+	"sort"
+	"time"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// remoteStoresMutex protects remoteStores
+	remoteStoresMutex lock.RWMutex
+
+	// remoteStores is the list of remote node stores being watched
+	remoteStores = map[*RemoteNodeStore]struct{}{}
+)
+
+// RemoteNodeStoreStatus is the health status of a remote node store
+type RemoteNodeStoreStatus struct {
+	// ClusterName is the name of the remote cluster
+	ClusterName string
+
+	// Synced is true once the initial list of nodes of the remote
+	// cluster has been received
+	Synced bool
+
+	// NumNodes is the number of nodes known in the remote cluster
+	NumNodes int
+
+	// LastEvent is the time the last node event of the remote cluster
+	// was received
+	LastEvent time.Time
+
+	// KVStoreStatus is the status of the kvstore of the remote cluster
+	KVStoreStatus string
+
+	// KVStoreErr is the error of the kvstore of the remote cluster, if any
+	KVStoreErr error
+}
+
+// Healthy returns true if the nodes of the remote cluster have been
+// synchronized and its kvstore is reachable
+func (s RemoteNodeStoreStatus) Healthy() bool {
+	return s.Synced && s.KVStoreErr == nil
+}
+
+// remoteNodeObserver sets the cluster name of all nodes received from a
+// remote cluster before passing them to the observer and keeps track of the
+// status of the remote cluster
+type remoteNodeObserver struct {
+	clusterName string
+	observer    store.Observer
+
+	// mutex protects the following variables
+	mutex     lock.RWMutex
+	nodes     map[string]struct{}
+	synced    bool
+	lastEvent time.Time
+}
+
+func newRemoteNodeObserver(clusterName string, observer store.Observer) *remoteNodeObserver {
+	return &remoteNodeObserver{
+		clusterName: clusterName,
+		observer:    observer,
+		nodes:       map[string]struct{}{},
+	}
+}
+
+// withCluster returns a copy of n associated with the remote cluster
+func (r *remoteNodeObserver) withCluster(n *node.Node) *node.Node {
+	if n.Cluster == r.clusterName {
+		return n
+	}
+
+	if n.Cluster != "" {
+		log.WithFields(logrus.Fields{
+			logfields.ClusterName: r.clusterName,
+			logfields.NodeName:    n.Name,
+			"nodeCluster":         n.Cluster,
+		}).Warning("Node of remote cluster is associated with another cluster, overriding the cluster name")
+	}
+
+	nodeCopy := n.DeepCopy()
+	nodeCopy.Cluster = r.clusterName
+	return nodeCopy
+}
+
+func (r *remoteNodeObserver) OnUpdate(k store.Key) {
+	if n, ok := k.(*node.Node); ok {
+		k = r.withCluster(n)
+	}
+
+	r.mutex.Lock()
+	r.nodes[k.GetKeyName()] = struct{}{}
+	r.lastEvent = time.Now()
+	r.mutex.Unlock()
+
+	r.observer.OnUpdate(k)
+}
+
+func (r *remoteNodeObserver) OnDelete(k store.NamedKey) {
+	if n, ok := k.(*node.Node); ok {
+		k = r.withCluster(n)
+	}
+
+	r.mutex.Lock()
+	delete(r.nodes, k.GetKeyName())
+	r.lastEvent = time.Now()
+	r.mutex.Unlock()
+
+	r.observer.OnDelete(k)
+}
+
+func (r *remoteNodeObserver) OnSync() {
+	r.mutex.Lock()
+	r.synced = true
+	r.mutex.Unlock()
+
+	if o, ok := r.observer.(store.SyncObserver); ok {
+		o.OnSync()
+	}
+}
+
+// RemoteNodeStore represents the node store of a remote cluster. The nodes
+// of the remote cluster are passed to the observer with the cluster name of
+// the remote cluster.
+type RemoteNodeStore struct {
+	*store.SharedStore
+
+	clusterName string
+	backend     kvstore.BackendOperations
+	observer    *remoteNodeObserver
+}
+
+// WatchRemoteNodeStore starts watching the nodes of the remote cluster
+// clusterName in the kvstore represented by backend and passes them to
+// observer. Pass a NodeObserver to merge the nodes into a NodeManager. If
+// keyCreator is nil, KeyCreator is used. The status of all remote node
+// stores being watched is returned by RemoteNodeStoresStatus().
+func WatchRemoteNodeStore(backend kvstore.BackendOperations, clusterName string, keyCreator store.KeyCreator, observer store.Observer) (*RemoteNodeStore, error) {
+	if keyCreator == nil {
+		keyCreator = KeyCreator
+	}
+
+	rs := &RemoteNodeStore{
+		clusterName: clusterName,
+		backend:     backend,
+		observer:    newRemoteNodeObserver(clusterName, observer),
+	}
+
+	s, err := store.JoinSharedStore(store.Configuration{
+		Prefix:                  path.Join(NodeStorePrefix, clusterName),
+		KeyCreator:              keyCreator,
+		SynchronizationInterval: time.Minute,
+		Backend:                 backend,
+		Observer:                rs.observer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rs.SharedStore = s
+
+	remoteStoresMutex.Lock()
+	remoteStores[rs] = struct{}{}
+	remoteStoresMutex.Unlock()
+
+	return rs, nil
+}
+
+// ClusterName returns the name of the remote cluster
+func (rs *RemoteNodeStore) ClusterName() string {
+	return rs.clusterName
+}
+
+// Status returns the health status of the remote node store
+func (rs *RemoteNodeStore) Status() RemoteNodeStoreStatus {
+	status := RemoteNodeStoreStatus{ClusterName: rs.clusterName}
+
+	rs.observer.mutex.RLock()
+	status.Synced = rs.observer.synced
+	status.NumNodes = len(rs.observer.nodes)
+	status.LastEvent = rs.observer.lastEvent
+	rs.observer.mutex.RUnlock()
+
+	if rs.backend != nil {
+		status.KVStoreStatus, status.KVStoreErr = rs.backend.Status()
+	}
+
+	return status
+}
+
+// Close stops watching the remote node store
+func (rs *RemoteNodeStore) Close() {
+	remoteStoresMutex.Lock()
+	delete(remoteStores, rs)
+	remoteStoresMutex.Unlock()
+
+	if rs.SharedStore != nil {
+		rs.SharedStore.Close()
+	}
+}
+
+// RemoteNodeStoresStatus returns the health status of all remote node stores
+// being watched, sorted by cluster name
+func RemoteNodeStoresStatus() []RemoteNodeStoreStatus {
+	remoteStoresMutex.RLock()
+	status := make([]RemoteNodeStoreStatus, 0, len(remoteStores))
+	for rs := range remoteStores {
+		status = append(status, rs.Status())
+	}
+	remoteStoresMutex.RUnlock()
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].ClusterName < status[j].ClusterName
+	})
+
+	return status
+}
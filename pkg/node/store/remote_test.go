@@ -0,0 +1,69 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/node"
+
+	. "gopkg.in/check.v1"
+)
+
+type keyObserver struct {
+	updated []store.Key
+	deleted []store.NamedKey
+	synced  bool
+}
+
+func (o *keyObserver) OnUpdate(k store.Key)      { o.updated = append(o.updated, k) }
+func (o *keyObserver) OnDelete(k store.NamedKey) { o.deleted = append(o.deleted, k) }
+func (o *keyObserver) OnSync()                   { o.synced = true }
+
+func (s *NodeStoreSuite) TestRemoteNodeObserver(c *C) {
+	observer := &keyObserver{}
+	r := newRemoteNodeObserver("remote", observer)
+	rs := &RemoteNodeStore{clusterName: "remote", observer: r}
+
+	status := rs.Status()
+	c.Assert(status.ClusterName, Equals, "remote")
+	c.Assert(status.Healthy(), Equals, false)
+
+	local := &node.Node{Name: "n1"}
+	r.OnUpdate(local)
+	r.OnUpdate(&node.Node{Name: "n2", Cluster: "remote"})
+	r.OnUpdate(&node.Node{Name: "n3", Cluster: "other"})
+	r.OnSync()
+
+	c.Assert(observer.updated, HasLen, 3)
+	for _, k := range observer.updated {
+		c.Assert(k.(*node.Node).Cluster, Equals, "remote")
+	}
+	// The key received from the store must not be modified
+	c.Assert(local.Cluster, Equals, "")
+	c.Assert(observer.synced, Equals, true)
+
+	status = rs.Status()
+	c.Assert(status.Synced, Equals, true)
+	c.Assert(status.NumNodes, Equals, 3)
+	c.Assert(status.LastEvent.IsZero(), Equals, false)
+	c.Assert(status.Healthy(), Equals, true)
+
+	r.OnDelete(&node.Node{Name: "n3", Cluster: "other"})
+	c.Assert(observer.deleted, HasLen, 1)
+	c.Assert(observer.deleted[0].(*node.Node).Cluster, Equals, "remote")
+	c.Assert(rs.Status().NumNodes, Equals, 2)
+}
@@ -0,0 +1,314 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/node"
+)
+
+const (
+	// maxTombstones is the maximum number of deleted nodes remembered to
+	// compute changes. Changes since versions preceding the oldest
+	// remembered deletion require a full snapshot.
+	maxTombstones = 1024
+)
+
+var (
+	// ErrETagExpired is returned if the changes since an ETag cannot be
+	// determined, e.g. because the ETag was issued before a restart or too
+	// many nodes have been deleted since. A full snapshot is required in
+	// that case.
+	ErrETagExpired = errors.New("etag expired, a full snapshot is required")
+
+	// ErrNotRegistered is returned if the node store is accessed before
+	// the local node has been registered
+	ErrNotRegistered = errors.New("local node not registered")
+)
+
+// Snapshot is the state of all nodes known to a node store
+type Snapshot struct {
+	// ETag identifies the version of the snapshot
+	ETag string
+
+	// Nodes are all known nodes sorted by cluster and name
+	Nodes []node.Node
+}
+
+// Changes are the changes of a node store since a previous version
+type Changes struct {
+	// ETag identifies the version after the changes
+	ETag string
+
+	// Updated are the nodes added or updated, sorted by cluster and name
+	Updated []node.Node
+
+	// Deleted are the identities of the nodes deleted, sorted by cluster
+	// and name
+	Deleted []node.Identity
+}
+
+// versionedNode is a node along with the version it was last changed at
+type versionedNode struct {
+	node    node.Node
+	version uint64
+}
+
+// nodeJournal tracks the versions at which nodes have been changed so that
+// consumers can retrieve the changes since a previous version instead of
+// all nodes. Versions are only meaningful within the journal instance which
+// issued them, ETags carry the epoch of the journal to detect the use of an
+// ETag with another instance.
+type nodeJournal struct {
+	// epoch identifies the journal instance
+	epoch string
+
+	// mutex protects all fields below
+	mutex lock.RWMutex
+
+	// version is the version of the last change
+	version uint64
+
+	// horizon is the oldest version changes can be computed since
+	horizon uint64
+
+	// nodes are all known nodes
+	nodes map[node.Identity]versionedNode
+
+	// addresses are the nodes using each address, so that nodes sharing
+	// an address can be found without iterating over all nodes
+	addresses map[string]map[node.Identity]struct{}
+
+	// deleted maps deleted nodes to the version of their deletion
+	deleted map[node.Identity]uint64
+}
+
+func newNodeJournal() *nodeJournal {
+	return &nodeJournal{
+		epoch:     strconv.FormatInt(time.Now().UnixNano(), 36),
+		nodes:     map[node.Identity]versionedNode{},
+		addresses: map[string]map[node.Identity]struct{}{},
+		deleted:   map[node.Identity]uint64{},
+	}
+}
+
+// etag returns the ETag of version. Must be called with mutex held.
+func (j *nodeJournal) etag(version uint64) string {
+	return fmt.Sprintf("%s-%d", j.epoch, version)
+}
+
+// parseETag returns the version identified by etag
+func (j *nodeJournal) parseETag(etag string) (uint64, error) {
+	idx := strings.LastIndex(etag, "-")
+	if idx < 0 || etag[:idx] != j.epoch {
+		return 0, ErrETagExpired
+	}
+	version, err := strconv.ParseUint(etag[idx+1:], 10, 64)
+	if err != nil {
+		return 0, ErrETagExpired
+	}
+	return version, nil
+}
+
+// indexAddresses adds (add = true) or removes the addresses of n to or from
+// the address index. Must be called with mutex held.
+func (j *nodeJournal) indexAddresses(n *node.Node, add bool) {
+	id := n.Identity()
+	for _, addr := range n.IPAddresses {
+		ip := addr.IP.String()
+		if add {
+			if j.addresses[ip] == nil {
+				j.addresses[ip] = map[node.Identity]struct{}{}
+			}
+			j.addresses[ip][id] = struct{}{}
+			continue
+		}
+		delete(j.addresses[ip], id)
+		if len(j.addresses[ip]) == 0 {
+			delete(j.addresses, ip)
+		}
+	}
+}
+
+// update records an update of n
+func (j *nodeJournal) update(n node.Node) {
+	j.mutex.Lock()
+	j.version++
+	if old, ok := j.nodes[n.Identity()]; ok {
+		j.indexAddresses(&old.node, false)
+	}
+	j.nodes[n.Identity()] = versionedNode{node: n, version: j.version}
+	j.indexAddresses(&n, true)
+	delete(j.deleted, n.Identity())
+	j.mutex.Unlock()
+}
+
+// get returns the node with identity id
+func (j *nodeJournal) get(id node.Identity) (node.Node, bool) {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	n, ok := j.nodes[id]
+	return n.node, ok
+}
+
+// sharedAddress returns the error of the first node other than n which uses
+// an address of n. Overlapping allocation CIDRs are left to the node
+// manager, which detects and reports them.
+func (j *nodeJournal) sharedAddress(n *node.Node) error {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	id := n.Identity()
+	for _, addr := range n.IPAddresses {
+		for otherID := range j.addresses[addr.IP.String()] {
+			if otherID == id {
+				continue
+			}
+			other := j.nodes[otherID]
+			if err := n.SharesAddress(&other.node); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// delete records the deletion of the node with identity id. Returns false if
+// the node is not known.
+func (j *nodeJournal) delete(id node.Identity) bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	old, ok := j.nodes[id]
+	if !ok {
+		return false
+	}
+
+	j.version++
+	j.indexAddresses(&old.node, false)
+	delete(j.nodes, id)
+	j.deleted[id] = j.version
+
+	if len(j.deleted) > maxTombstones {
+		oldest, oldestVersion := node.Identity{}, j.version
+		for id, version := range j.deleted {
+			if version < oldestVersion {
+				oldest, oldestVersion = id, version
+			}
+		}
+		delete(j.deleted, oldest)
+		j.horizon = oldestVersion
+	}
+	return true
+}
+
+// sortIdentities sorts ids by cluster and name
+func sortIdentities(ids []node.Identity) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+}
+
+// sortNodes sorts nodes by cluster and name
+func sortNodes(nodes []node.Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Identity().String() < nodes[j].Identity().String() })
+}
+
+// snapshot returns all known nodes
+func (j *nodeJournal) snapshot() Snapshot {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	s := Snapshot{
+		ETag:  j.etag(j.version),
+		Nodes: make([]node.Node, 0, len(j.nodes)),
+	}
+	for _, n := range j.nodes {
+		s.Nodes = append(s.Nodes, *n.node.DeepCopy())
+	}
+	sortNodes(s.Nodes)
+	return s
+}
+
+// changesSince returns the changes since the version identified by etag
+func (j *nodeJournal) changesSince(etag string) (Changes, error) {
+	since, err := j.parseETag(etag)
+	if err != nil {
+		return Changes{}, err
+	}
+
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+
+	if since < j.horizon || since > j.version {
+		return Changes{}, ErrETagExpired
+	}
+
+	c := Changes{
+		ETag:    j.etag(j.version),
+		Updated: []node.Node{},
+		Deleted: []node.Identity{},
+	}
+	for _, n := range j.nodes {
+		if n.version > since {
+			c.Updated = append(c.Updated, *n.node.DeepCopy())
+		}
+	}
+	for id, version := range j.deleted {
+		if version > since {
+			c.Deleted = append(c.Deleted, id)
+		}
+	}
+	sortNodes(c.Updated)
+	sortIdentities(c.Deleted)
+	return c, nil
+}
+
+// Snapshot returns all nodes applied by the observer along with the ETag
+// identifying their version
+func (o *NodeObserver) Snapshot() Snapshot {
+	return o.journal.snapshot()
+}
+
+// ChangesSince returns the changes of the nodes applied by the observer since
+// the version identified by etag, as returned by a previous call to
+// Snapshot() or ChangesSince(). Returns ErrETagExpired if the changes cannot
+// be determined, in which case the consumer must retrieve a full snapshot.
+func (o *NodeObserver) ChangesSince(etag string) (Changes, error) {
+	return o.journal.changesSince(etag)
+}
+
+// Snapshot returns all nodes of the node store along with the ETag
+// identifying their version
+func (nr *NodeRegistrar) Snapshot() (Snapshot, error) {
+	if nr.observer == nil {
+		return Snapshot{}, ErrNotRegistered
+	}
+	return nr.observer.Snapshot(), nil
+}
+
+// ChangesSince returns the changes of the node store since the version
+// identified by etag. Returns ErrETagExpired if a full snapshot is required.
+func (nr *NodeRegistrar) ChangesSince(etag string) (Changes, error) {
+	if nr.observer == nil {
+		return Changes{}, ErrNotRegistered
+	}
+	return nr.observer.ChangesSince(etag)
+}
@@ -15,18 +15,27 @@
 package store
 
 import (
+	"context"
 	"path"
+	"sort"
 	"time"
 
-	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/controller"
 	"github.com/cilium/cilium/pkg/identity"
 	"github.com/cilium/cilium/pkg/ipcache"
 	"github.com/cilium/cilium/pkg/kvstore"
 	"github.com/cilium/cilium/pkg/kvstore/store"
+	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/node"
 	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/trigger"
+
+	"github.com/go-openapi/strfmt"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -45,88 +54,788 @@ var (
 	log = logging.DefaultLogger.WithField(logfields.LogSubsys, "node-store")
 )
 
+const (
+	// upsertBatchInterval is the minimum interval between two batches of
+	// ipcache upserts resulting from node events
+	upsertBatchInterval = 100 * time.Millisecond
+
+	// hostIPSweepInterval is the interval in which ipcache entries of
+	// host IPs of nodes no longer known are removed, see
+	// option.Config.EncryptNode
+	hostIPSweepInterval = 5 * time.Minute
+
+	// hostIPSweepController is the name of the controller removing
+	// stale host IPs from the ipcache
+	hostIPSweepController = "node-store-host-ip-sweep"
+)
+
+// ObserverConfiguration is the configuration of a NodeObserver
+type ObserverConfiguration struct {
+	// DeleteDelay maps node sources to the delay before a deletion of a
+	// node received from that source is handled. A zero delay handles
+	// deletions immediately, e.g. in small clusters with a reliable
+	// kvstore. Sources without an entry use the delay configured by
+	// option.Config.NodeDeleteDelay.
+	DeleteDelay map[node.Source]time.Duration
+}
+
+// deleteDelay returns the delay before a deletion of a node received from
+// source is handled
+func (c ObserverConfiguration) deleteDelay(source node.Source) time.Duration {
+	if delay, ok := c.DeleteDelay[source]; ok {
+		return delay
+	}
+	return option.Config.GetNodeDeleteDelay(string(source))
+}
+
+// pendingDeletion is the deletion of a node pending for its delete delay
+type pendingDeletion struct {
+	timer *time.Timer
+
+	// node is the deleted node
+	node *node.Node
+
+	// received is the time the deletion was received
+	received time.Time
+
+	// deleteAt is the time the deletion is handled
+	deleteAt time.Time
+}
+
 // NodeObserver implements the store.Observer interface and delegates update
 // and deletion events to the node object itself.
 type NodeObserver struct {
 	manager NodeManager
+
+	config ObserverConfiguration
+
+	// mutex protects pending, applied and quarantined
+	mutex lock.Mutex
+
+	// pending maps node identities to their pending deletion. A deletion
+	// is pending for the delete delay of its source and cancelled if the
+	// node re-registers in the meantime.
+	pending map[node.Identity]*pendingDeletion
+
+	// applied maps node identities to the version of the node last
+	// applied to the node manager and the ipcache
+	applied map[node.Identity]appliedNode
+
+	// quarantined maps node identities to the latest version of nodes
+	// held back because they use an address of another node. The
+	// previous version of such a node, if any, remains applied.
+	// Quarantined nodes are re-evaluated whenever a node is deleted.
+	// Overlapping allocation CIDRs are detected by the node manager.
+	quarantined map[node.Identity]*node.Node
+
+	// journal tracks the versions of the nodes applied
+	journal *nodeJournal
+
+	// syncMutex protects synced, initial and initialIndex
+	syncMutex lock.Mutex
+
+	// synced is true once the initial list of nodes has been received
+	synced bool
+
+	// initial are the nodes of the initial list which are passed to the
+	// node manager in a single batch once the list is complete, in the
+	// order received. Nodes deleted before the list is complete are nil.
+	initial []*node.Node
+
+	// initialIndex is the index of each node in initial
+	initialIndex map[node.Identity]int
+
+	// batchMutex protects upserts and hostIPs and serializes the
+	// application of upserts and deletions to the ipcache
+	batchMutex lock.Mutex
+
+	// upserts maps node identities to the ipcache entries to upsert for
+	// the node. Later updates of a node replace its pending entries.
+	upserts map[node.Identity][]ipcache.UpsertEntry
+
+	// hostIPs maps node identities to the host IP inserted into the
+	// ipcache for the node if option.Config.EncryptNode is set
+	hostIPs map[node.Identity]string
+
+	// controllers runs the sweep of stale host IPs
+	controllers *controller.Manager
+
+	// upsertTrigger applies the pending upserts in a single batch. If
+	// nil, upserts are applied synchronously.
+	upsertTrigger *trigger.Trigger
 }
 
 // NewNodeObserver returns a new NodeObserver associated with the specified
 // node manager
 func NewNodeObserver(manager NodeManager) *NodeObserver {
-	return &NodeObserver{manager: manager}
+	return NewNodeObserverWithConfiguration(manager, ObserverConfiguration{})
 }
 
-func (o *NodeObserver) OnUpdate(k store.Key) {
-	if n, ok := k.(*node.Node); ok {
-		nodeCopy := n.DeepCopy()
-		nodeCopy.Source = node.FromKVStore
-		o.manager.NodeUpdated(*nodeCopy)
-
-		ciliumIPv4 := nodeCopy.GetCiliumInternalIP(false)
-		if ciliumIPv4 != nil {
-			hostIP := nodeCopy.GetNodeIP(false)
-			hostKey := node.GetIPsecKeyIdentity()
-			ipcache.IPIdentityCache.Upsert(ciliumIPv4.String(), hostIP, hostKey, ipcache.Identity{
-				ID:     identity.ReservedIdentityHost,
-				Source: ipcache.FromKVStore,
+// NewNodeObserverWithConfiguration returns a new NodeObserver associated with
+// the specified node manager and configured by config
+func NewNodeObserverWithConfiguration(manager NodeManager, config ObserverConfiguration) *NodeObserver {
+	o := &NodeObserver{
+		manager:      manager,
+		config:       config,
+		pending:      map[node.Identity]*pendingDeletion{},
+		applied:      map[node.Identity]appliedNode{},
+		quarantined:  map[node.Identity]*node.Node{},
+		journal:      newNodeJournal(),
+		initialIndex: map[node.Identity]int{},
+		upserts:      map[node.Identity][]ipcache.UpsertEntry{},
+		hostIPs:      map[node.Identity]string{},
+		controllers:  controller.NewManager(),
+	}
+
+	t, err := trigger.NewTrigger(trigger.Parameters{
+		Name:        "node-ipcache-upserts",
+		MinInterval: upsertBatchInterval,
+		TriggerFunc: func(reasons []string) { o.flushUpserts() },
+	})
+	if err != nil {
+		log.WithError(err).Warning("Unable to create ipcache upsert trigger, upserting node entries synchronously")
+	} else {
+		o.upsertTrigger = t
+	}
+
+	if option.Config.EncryptNode {
+		o.controllers.UpdateController(hostIPSweepController,
+			controller.ControllerParams{
+				DoFunc: func(ctx context.Context) error {
+					o.sweepHostIPs()
+					return nil
+				},
+				RunInterval: hostIPSweepInterval,
 			})
+	}
+
+	return o
+}
+
+// queueUpserts queues the ipcache entries of the node with identity id,
+// replacing any entries still pending for the node, and schedules a batch
+func (o *NodeObserver) queueUpserts(id node.Identity, entries []ipcache.UpsertEntry) {
+	o.batchMutex.Lock()
+	o.upserts[id] = entries
+	o.batchMutex.Unlock()
+
+	if o.upsertTrigger != nil {
+		o.upsertTrigger.Trigger()
+	} else {
+		o.flushUpserts()
+	}
+}
+
+// flushUpserts applies all pending ipcache upserts in a single batch
+func (o *NodeObserver) flushUpserts() {
+	o.batchMutex.Lock()
+	defer o.batchMutex.Unlock()
+
+	if len(o.upserts) == 0 {
+		return
+	}
+
+	var entries []ipcache.UpsertEntry
+	for _, e := range o.upserts {
+		entries = append(entries, e...)
+	}
+	o.upserts = map[node.Identity][]ipcache.UpsertEntry{}
+
+	if failed := ipcache.IPIdentityCache.UpsertOrdered(entries); failed > 0 {
+		metrics.NodeStoreIPCacheFailures.Add(float64(failed))
+	}
+}
+
+// appliedNode is the version of a node applied by the NodeObserver
+type appliedNode struct {
+	// hash is the hash of the node
+	hash string
+
+	// hostKey is the IPsec key of the ipcache entries of the node
+	hostKey uint8
+
+	// publicKey is the encryption public key of the node
+	publicKey string
+}
+
+// markApplied records version as the version of the node with identity id
+// last applied and returns the version previously applied. Returns false if
+// the version was already applied and the update can be skipped.
+func (o *NodeObserver) markApplied(id node.Identity, version appliedNode) (appliedNode, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	prev, ok := o.applied[id]
+	if version.hash == "" {
+		delete(o.applied, id)
+		return prev, true
+	}
+
+	if ok && prev == version {
+		return prev, false
+	}
+	o.applied[id] = version
+	return prev, true
+}
+
+// forgetApplied forgets the version of the node with identity id last
+// applied, e.g. because the node has been deleted
+func (o *NodeObserver) forgetApplied(id node.Identity) {
+	o.mutex.Lock()
+	delete(o.applied, id)
+	o.mutex.Unlock()
+}
+
+// cancelDeletion cancels the pending deletion of the node with identity id.
+// Returns true if a deletion was pending.
+func (o *NodeObserver) cancelDeletion(id node.Identity) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	p, ok := o.pending[id]
+	if ok {
+		p.timer.Stop()
+		delete(o.pending, id)
+		metrics.NodeStorePendingDeletions.Dec()
+	}
+	return ok
+}
+
+// scheduleDeletion schedules the deletion of n after the delete delay of the
+// node source, replacing any deletion already pending for the node. Without
+// delay, n is deleted immediately.
+func (o *NodeObserver) scheduleDeletion(n *node.Node) {
+	id := n.Identity()
+	delay := o.config.deleteDelay(n.Source)
+
+	if delay == 0 {
+		o.cancelDeletion(id)
+		o.deleteNode(n)
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if p, ok := o.pending[id]; ok {
+		p.timer.Stop()
+	} else {
+		metrics.NodeStorePendingDeletions.Inc()
+	}
+
+	now := time.Now()
+	p := &pendingDeletion{node: n, received: now, deleteAt: now.Add(delay)}
+	p.timer = time.AfterFunc(delay, func() {
+		o.mutex.Lock()
+		// The deletion has been cancelled or replaced while the timer
+		// fired
+		if o.pending[id] != p {
+			o.mutex.Unlock()
+			return
+		}
+		delete(o.pending, id)
+		metrics.NodeStorePendingDeletions.Dec()
+		o.mutex.Unlock()
+
+		o.deleteNode(n)
+	})
+	o.pending[id] = p
+}
+
+// PendingDeletions returns a node store entry for each node of which the
+// deletion is pending for its delete delay. LastUpdate is the time the
+// deletion has been received.
+func (o *NodeObserver) PendingDeletions() []*models.NodeStoreEntry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	entries := make([]*models.NodeStoreEntry, 0, len(o.pending))
+	for _, p := range o.pending {
+		entries = append(entries, &models.NodeStoreEntry{
+			Name:       p.node.GetKeyName(),
+			LastUpdate: strfmt.DateTime(p.received),
+			DeleteAt:   strfmt.DateTime(p.deleteAt),
+		})
+	}
+	return entries
+}
+
+// quarantine holds back n until the shared address described by err has
+// been released by the other node
+func (o *NodeObserver) quarantine(n *node.Node, err *node.ValidationError) {
+	o.mutex.Lock()
+	o.quarantined[n.Identity()] = n
+	o.mutex.Unlock()
+
+	metrics.NodeValidationFailures.WithLabelValues(err.Reason, "quarantined").Inc()
+	log.WithError(err).WithField(logfields.NodeName, n.Name).
+		Warning("Quarantining node sharing an address with another node")
+}
+
+// releaseQuarantine removes the node with identity id from the quarantine
+func (o *NodeObserver) releaseQuarantine(id node.Identity) {
+	o.mutex.Lock()
+	delete(o.quarantined, id)
+	o.mutex.Unlock()
+}
+
+// reevaluateQuarantine re-applies all quarantined nodes, e.g. after the
+// deletion of a node they shared an address with. Nodes still sharing an
+// address with another node are quarantined again.
+func (o *NodeObserver) reevaluateQuarantine() {
+	o.mutex.Lock()
+	nodes := make([]*node.Node, 0, len(o.quarantined))
+	for _, n := range o.quarantined {
+		nodes = append(nodes, n)
+	}
+	o.quarantined = map[node.Identity]*node.Node{}
+	o.mutex.Unlock()
+
+	for _, n := range nodes {
+		o.applyUpdate(n)
+	}
+}
+
+// deleteNode removes n from the node manager and the ipcache
+func (o *NodeObserver) deleteNode(n *node.Node) {
+	o.releaseQuarantine(n.Identity())
+	defer o.reevaluateQuarantine()
+
+	start := time.Now()
+	defer func() {
+		metrics.NodeStoreEventDuration.WithLabelValues("delete").Observe(time.Since(start).Seconds())
+	}()
+
+	o.forgetApplied(n.Identity())
+	if o.journal.delete(n.Identity()) {
+		metrics.NodeStoreNodes.Dec()
+	}
+
+	// A node deleted before the initial list has been received is dropped
+	// from the initial batch
+	o.syncMutex.Lock()
+	if i, ok := o.initialIndex[n.Identity()]; ok && !o.synced {
+		o.initial[i] = nil
+		delete(o.initialIndex, n.Identity())
+	}
+	o.syncMutex.Unlock()
+
+	o.manager.NodeDeleted(*n)
+
+	// Drop the upserts still pending for the node and hold the batch
+	// lock so that a concurrent batch cannot re-create the entries
+	o.batchMutex.Lock()
+	defer o.batchMutex.Unlock()
+	delete(o.upserts, n.Identity())
+
+	ciliumIPv4 := n.GetCiliumInternalIP(false)
+	if ciliumIPv4 != nil {
+		ipcache.IPIdentityCache.DeleteOrdered(ciliumIPv4.String(), ipcache.FromKVStore)
+	}
+	ciliumIPv6 := n.GetCiliumInternalIP(true)
+	if ciliumIPv6 != nil {
+		ipcache.IPIdentityCache.DeleteOrdered(ciliumIPv6.String(), ipcache.FromKVStore)
+	}
+	for _, ip := range healthIPs(n) {
+		ipcache.IPIdentityCache.DeleteOrdered(ip, ipcache.FromKVStore)
+	}
+	if hostIP, ok := o.hostIPs[n.Identity()]; ok {
+		ipcache.IPIdentityCache.DeleteOrdered(hostIP, ipcache.FromKVStore)
+		delete(o.hostIPs, n.Identity())
+	}
+}
+
+// trackHostIP records the host IP of n inserted into the ipcache if
+// option.Config.EncryptNode is set. The previous host IP of the node is
+// removed from the ipcache if it has changed. Must be called after the
+// ipcache entries of n have been queued.
+func (o *NodeObserver) trackHostIP(n *node.Node) {
+	if !option.Config.EncryptNode {
+		return
+	}
+
+	hostIP := ""
+	if ip := n.GetNodeIP(false); ip != nil {
+		hostIP = ip.String()
+	}
+
+	o.batchMutex.Lock()
+	defer o.batchMutex.Unlock()
+
+	prev, ok := o.hostIPs[n.Identity()]
+	if ok && prev != hostIP {
+		ipcache.IPIdentityCache.DeleteOrdered(prev, ipcache.FromKVStore)
+	}
+	if hostIP == "" {
+		delete(o.hostIPs, n.Identity())
+	} else {
+		o.hostIPs[n.Identity()] = hostIP
+	}
+}
+
+// sweepHostIPs removes the host IPs of all nodes no longer known to the
+// observer, or of which the host IP has changed, from the ipcache. It
+// catches entries left behind by deletions racing with updates of a node.
+func (o *NodeObserver) sweepHostIPs() {
+	current := map[node.Identity]string{}
+	for _, n := range o.journal.snapshot().Nodes {
+		if ip := n.GetNodeIP(false); ip != nil {
+			current[n.Identity()] = ip.String()
+		}
+	}
+
+	o.batchMutex.Lock()
+	defer o.batchMutex.Unlock()
+
+	for id, hostIP := range o.hostIPs {
+		if current[id] == hostIP {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			logfields.NodeName: id.Name,
+			logfields.IPAddr:   hostIP,
+		}).Debug("Removing stale node host IP from ipcache")
+		ipcache.IPIdentityCache.DeleteOrdered(hostIP, ipcache.FromKVStore)
+		delete(o.hostIPs, id)
+	}
+}
+
+// replaceHealthIPs withdraws the health IPs of old no longer used by n from
+// the ipcache and inserts the health IPs of n in a single ipcache
+// transaction so that the health endpoint of the node remains reachable
+// throughout the change
+func (o *NodeObserver) replaceHealthIPs(old, n *node.Node) {
+	var stale []string
+	current := healthIPs(n)
+	for _, ip := range healthIPs(old) {
+		if !containsString(current, ip) {
+			stale = append(stale, ip)
 		}
+	}
 
-		if option.Config.EncryptNode {
-			hostIP := nodeCopy.GetNodeIP(false)
-			if hostIP != nil {
-				hostKey := node.GetIPsecKeyIdentity()
-				ipcache.IPIdentityCache.Upsert(hostIP.String(), hostIP, hostKey, ipcache.Identity{
-					ID:     identity.ReservedIdentityHost,
-					Source: ipcache.FromKVStore,
-				})
+	// Drop the stale IPs from the upserts still pending for the node and
+	// hold the batch lock so that a concurrent batch cannot re-create
+	// them
+	o.batchMutex.Lock()
+	defer o.batchMutex.Unlock()
+	if pending, ok := o.upserts[n.Identity()]; ok {
+		entries := pending[:0]
+		for _, e := range pending {
+			if !containsString(stale, e.IP) {
+				entries = append(entries, e)
 			}
 		}
+		o.upserts[n.Identity()] = entries
+	}
+
+	if failed := ipcache.IPIdentityCache.ReplaceOrdered(stale, ipcache.FromKVStore, healthEntries(n)); failed > 0 {
+		metrics.NodeStoreIPCacheFailures.Add(float64(failed))
+	}
+
+	log.WithFields(logrus.Fields{
+		logfields.NodeName: n.Name,
+		"oldHealthIPs":     healthIPs(old),
+		"newHealthIPs":     current,
+	}).Info("Health IPs of node changed")
+}
+
+func (o *NodeObserver) OnUpdate(k store.Key) {
+	if n, ok := k.(*node.Node); ok {
+		start := time.Now()
+		metrics.NodeStoreEvents.WithLabelValues("update").Inc()
+
+		o.applyUpdate(n)
+
+		metrics.NodeStoreEventDuration.WithLabelValues("update").Observe(time.Since(start).Seconds())
+	}
+}
+
+// applyUpdate applies the update of node n received from the store
+func (o *NodeObserver) applyUpdate(n *node.Node) {
+	nodeCopy := n.DeepCopy()
+	nodeCopy.Source = node.FromKVStore
+
+	// A corrupted entry must not replace the version of the node
+	// already applied
+	if err := nodeCopy.Validate(); err != nil {
+		reason := err.(*node.ValidationError).Reason
+		metrics.NodeValidationFailures.WithLabelValues(reason, "rejected").Inc()
+		log.WithError(err).WithField(logfields.NodeName, nodeCopy.Name).
+			Warning("Rejecting invalid node update")
+		return
+	}
+	if err := o.journal.sharedAddress(nodeCopy); err != nil {
+		o.quarantine(nodeCopy, err.(*node.ValidationError))
+		return
+	}
+	o.releaseQuarantine(nodeCopy.Identity())
+
+	// Cancel the deletion before updating the ipcache so that the
+	// entries of the re-registered node are not removed afterwards
+	if o.cancelDeletion(nodeCopy.Identity()) {
+		log.WithField(logfields.NodeName, nodeCopy.Name).
+			Info("Node re-registered, cancelled pending deletion")
+	}
+
+	// Updates not changing the node, e.g. periodic re-writes of the
+	// node by its owner, do not require datapath changes
+	version := appliedNode{
+		hash:      nodeCopy.GetHash(),
+		hostKey:   node.GetIPsecKeyIdentity(),
+		publicKey: nodeCopy.EncryptionPublicKey,
+	}
+	prev, changed := o.markApplied(nodeCopy.Identity(), version)
+	if !changed {
+		log.WithField(logfields.NodeName, nodeCopy.Name).
+			Debug("Ignoring update of unchanged node")
+		return
+	}
+	if prev.hash != "" && prev.publicKey != version.publicKey {
+		log.WithField(logfields.NodeName, nodeCopy.Name).
+			Info("Encryption public key of node rotated")
+	}
+
+	// Changes not affecting the datapath, e.g. of the labels or
+	// health IPs, take the soft update path so that routes and
+	// tunnels are not reprogrammed
+	old, known := o.journal.get(nodeCopy.Identity())
+	soft := known && prev.hostKey == version.hostKey && old.DatapathAttrEquals(nodeCopy)
+	if !known {
+		metrics.NodeStoreNodes.Inc()
+	}
+
+	var added, removed []node.Address
+	if known {
+		added, removed = node.AddressesDiff(old.IPAddresses, nodeCopy.IPAddresses)
+	}
+
+	o.journal.update(*nodeCopy)
+	o.notifyUpdated(*nodeCopy, soft, added, removed)
+	if !soft {
+		o.queueUpserts(nodeCopy.Identity(), ipcacheEntries(nodeCopy))
+		o.trackHostIP(nodeCopy)
+	}
+	if known && !old.HealthIPsEqual(nodeCopy) {
+		o.replaceHealthIPs(&old, nodeCopy)
+	}
+}
+
+// notifyUpdated passes n to the node manager, as soft update if soft is true,
+// followed by the addresses added and removed by the update, if any. n is
+// added to the initial batch instead if the initial list of nodes has not
+// been received yet.
+func (o *NodeObserver) notifyUpdated(n node.Node, soft bool, added, removed []node.Address) {
+	o.syncMutex.Lock()
+	if !o.synced {
+		if i, ok := o.initialIndex[n.Identity()]; ok {
+			o.initial[i] = &n
+		} else {
+			o.initialIndex[n.Identity()] = len(o.initial)
+			o.initial = append(o.initial, &n)
+		}
+		o.syncMutex.Unlock()
+		return
+	}
+	o.syncMutex.Unlock()
+
+	if soft {
+		o.manager.NodeSoftUpdated(n)
+	} else {
+		o.manager.NodeUpdated(n)
+	}
+	if len(added) != 0 || len(removed) != 0 {
+		o.manager.NodeAddressesChanged(n, added, removed)
+	}
+}
+
+// OnSync passes all nodes of the initial list to the node manager in a single
+// batch. Subsequent updates are passed to the node manager individually.
+func (o *NodeObserver) OnSync() {
+	o.syncMutex.Lock()
+	defer o.syncMutex.Unlock()
+
+	if o.synced {
+		return
+	}
+	o.synced = true
+
+	nodes := make([]node.Node, 0, len(o.initialIndex))
+	for _, n := range o.initial {
+		if n != nil {
+			nodes = append(nodes, *n)
+		}
+	}
+	if len(nodes) != 0 {
+		o.manager.NodeBatchUpdated(nodes)
+	}
+	o.initial = nil
+	o.initialIndex = nil
+}
+
+// RefreshHostKeys re-applies the ipcache entries of all nodes applied by the
+// observer with the current IPsec key identity of the local node. It must be
+// called after the local key identity has been rotated.
+func (o *NodeObserver) RefreshHostKeys() {
+	hostKey := node.GetIPsecKeyIdentity()
+	for _, n := range o.journal.snapshot().Nodes {
+		id := n.Identity()
+
+		o.mutex.Lock()
+		version, ok := o.applied[id]
+		if ok {
+			version.hostKey = hostKey
+			o.applied[id] = version
+		}
+		o.mutex.Unlock()
+
+		o.queueUpserts(id, ipcacheEntries(&n))
+	}
+}
+
+// ipcacheEntries returns the ipcache entries pointing to the host identity
+// of node n
+func ipcacheEntries(n *node.Node) []ipcache.UpsertEntry {
+	var entries []ipcache.UpsertEntry
+	hostKey := node.GetIPsecKeyIdentity()
+	hostIdentity := ipcache.Identity{
+		ID:     identity.ReservedIdentityHost,
+		Source: ipcache.FromKVStore,
+	}
+
+	if ciliumIPv4 := n.GetCiliumInternalIP(false); ciliumIPv4 != nil {
+		entries = append(entries, ipcache.UpsertEntry{
+			IP:       ciliumIPv4.String(),
+			HostIP:   n.GetNodeIP(false),
+			HostKey:  hostKey,
+			Identity: hostIdentity,
+		})
+	}
 
-		ciliumIPv6 := nodeCopy.GetCiliumInternalIP(true)
-		if ciliumIPv6 != nil {
-			hostIP := nodeCopy.GetNodeIP(true)
-			hostKey := node.GetIPsecKeyIdentity()
-			ipcache.IPIdentityCache.Upsert(ciliumIPv6.String(), hostIP, hostKey, ipcache.Identity{
-				ID:     identity.ReservedIdentityHost,
-				Source: ipcache.FromKVStore,
+	if option.Config.EncryptNode {
+		if hostIP := n.GetNodeIP(false); hostIP != nil {
+			entries = append(entries, ipcache.UpsertEntry{
+				IP:       hostIP.String(),
+				HostIP:   hostIP,
+				HostKey:  hostKey,
+				Identity: hostIdentity,
 			})
 		}
 	}
+
+	if ciliumIPv6 := n.GetCiliumInternalIP(true); ciliumIPv6 != nil {
+		entries = append(entries, ipcache.UpsertEntry{
+			IP:       ciliumIPv6.String(),
+			HostIP:   n.GetNodeIP(true),
+			HostKey:  hostKey,
+			Identity: hostIdentity,
+		})
+	}
+
+	return append(entries, healthEntries(n)...)
+}
+
+// healthEntries returns the ipcache entries pointing to the health endpoint
+// of node n
+func healthEntries(n *node.Node) []ipcache.UpsertEntry {
+	var entries []ipcache.UpsertEntry
+	hostKey := node.GetIPsecKeyIdentity()
+	healthIdentity := ipcache.Identity{
+		ID:     identity.ReservedIdentityHealth,
+		Source: ipcache.FromKVStore,
+	}
+
+	if n.IPv4HealthIP != nil {
+		entries = append(entries, ipcache.UpsertEntry{
+			IP:       n.IPv4HealthIP.String(),
+			HostIP:   n.GetNodeIP(false),
+			HostKey:  hostKey,
+			Identity: healthIdentity,
+		})
+	}
+	if n.IPv6HealthIP != nil {
+		entries = append(entries, ipcache.UpsertEntry{
+			IP:       n.IPv6HealthIP.String(),
+			HostIP:   n.GetNodeIP(true),
+			HostKey:  hostKey,
+			Identity: healthIdentity,
+		})
+	}
+
+	return entries
+}
+
+// healthIPs returns the health IPs of node n
+func healthIPs(n *node.Node) []string {
+	var ips []string
+	if n.IPv4HealthIP != nil {
+		ips = append(ips, n.IPv4HealthIP.String())
+	}
+	if n.IPv6HealthIP != nil {
+		ips = append(ips, n.IPv6HealthIP.String())
+	}
+	return ips
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
 
 func (o *NodeObserver) OnDelete(k store.NamedKey) {
 	if n, ok := k.(*node.Node); ok {
+		metrics.NodeStoreEvents.WithLabelValues("delete").Inc()
+
 		nodeCopy := n.DeepCopy()
 		nodeCopy.Source = node.FromKVStore
 
-		go func() {
-			time.Sleep(defaults.NodeDeleteDelay)
+		o.scheduleDeletion(nodeCopy)
+	}
+}
 
-			if o.manager.Exists(nodeCopy.Identity()) {
-				log.Warningf("Received node delete event for node %s which re-appeared within %s",
-					nodeCopy.Name, defaults.NodeDeleteDelay)
-				return
-			}
+// Registrar registers the local node with a node distribution backend and
+// passes the nodes of the cluster received from the backend to a
+// NodeManager. NodeRegistrar implements it on top of the kvstore.
+type Registrar interface {
+	// RegisterNode registers the local node n and starts passing the
+	// nodes of the cluster to manager
+	RegisterNode(n *node.Node, manager NodeManager) error
 
-			o.manager.NodeDeleted(*nodeCopy)
+	// PublishNode registers the local node n without discovering the
+	// nodes of the cluster, e.g. as secondary backend of a DualRegistrar
+	PublishNode(n *node.Node) error
 
-			ciliumIPv4 := nodeCopy.GetCiliumInternalIP(false)
-			if ciliumIPv4 != nil {
-				ipcache.IPIdentityCache.Delete(ciliumIPv4.String(), ipcache.FromKVStore)
-			}
-			ciliumIPv6 := nodeCopy.GetCiliumInternalIP(true)
-			if ciliumIPv6 != nil {
-				ipcache.IPIdentityCache.Delete(ciliumIPv6.String(), ipcache.FromKVStore)
-			}
-		}()
-	}
+	// UpdateLocalKeySync publishes the local node n
+	UpdateLocalKeySync(n *node.Node) error
+
+	// EnsureRegistered re-registers the local node n if it went missing
+	// in the backend. Returns true if the node has been re-registered.
+	EnsureRegistered(n *node.Node) (bool, error)
+
+	// DeleteLocalNode withdraws the local node n from the cluster
+	DeleteLocalNode(n *node.Node)
+
+	// RefreshHostKeys re-applies the ipcache entries of all nodes with
+	// the current IPsec key identity of the local node
+	RefreshHostKeys()
+
+	// GetStoreEntries returns all nodes known to the backend
+	GetStoreEntries() []*models.NodeStoreEntry
 }
 
 // NodeRegistrar is a wrapper around store.SharedStore.
 type NodeRegistrar struct {
 	*store.SharedStore
+
+	// ObserverConfiguration is the configuration of the observer passing
+	// the nodes of the shared store to the node manager
+	ObserverConfiguration ObserverConfiguration
+
+	// observer is the observer of the shared store
+	observer *NodeObserver
 }
 
 // NodeManager is the interface that the manager of nodes has to implement
@@ -139,22 +848,68 @@ type NodeManager interface {
 	// information
 	NodeUpdated(n node.Node)
 
+	// NodeBatchUpdated is called with all nodes of the initial list of
+	// the store instead of calling NodeUpdated for each node
+	NodeBatchUpdated(nodes []node.Node)
+
 	// NodeDeleted is called when the store detects a deletion of a node
 	NodeDeleted(n node.Node)
 
+	// NodeTerminating is called when a node is marked as about to be
+	// terminated, ahead of its deletion
+	NodeTerminating(n node.Node)
+
+	// NodeAddressesChanged is called after an update of a known node
+	// which added or removed addresses of the node
+	NodeAddressesChanged(n node.Node, added, removed []node.Address)
+
 	// Exists is called to verify if a node exists
 	Exists(id node.Identity) bool
 }
 
 // RegisterNode registers the local node in the cluster
 func (nr *NodeRegistrar) RegisterNode(n *node.Node, manager NodeManager) error {
+	observer := NewNodeObserverWithConfiguration(manager, nr.ObserverConfiguration)
+	if err := nr.join(n, observer); err != nil {
+		return err
+	}
+	nr.observer = observer
+	return nil
+}
 
-	// Join the shared store holding node information of entire cluster
-	store, err := store.JoinSharedStore(store.Configuration{
+// ignoreObserver is a store.Observer ignoring all nodes
+type ignoreObserver struct{}
+
+func (ignoreObserver) OnUpdate(k store.Key)      {}
+func (ignoreObserver) OnDelete(k store.NamedKey) {}
+
+// PublishNode registers the local node in the kvstore without passing the
+// nodes of the cluster to a node manager
+func (nr *NodeRegistrar) PublishNode(n *node.Node) error {
+	return nr.join(n, ignoreObserver{})
+}
+
+// join joins the shared store holding the nodes of the cluster, passing all
+// nodes to observer, and publishes the local node n
+func (nr *NodeRegistrar) join(n *node.Node, observer store.Observer) error {
+	conf := store.Configuration{
 		Prefix:     NodeStorePrefix,
 		KeyCreator: KeyCreator,
-		Observer:   NewNodeObserver(manager),
-	})
+		Observer:   observer,
+		// In large clusters, publishing only the changed fields of
+		// a node significantly reduces the watch traffic
+		Delta: option.Config.NodeDeltaUpdates,
+	}
+
+	// If enabled, the local node is authoritative for its own key and
+	// updates by other writers, e.g. a stale instance of the agent, are
+	// overwritten
+	if option.Config.NodePreferLocal {
+		conf.ConflictResolver = store.LocalPreferred
+	}
+
+	// Join the shared store holding node information of entire cluster
+	store, err := store.JoinSharedStore(conf)
 
 	if err != nil {
 		return err
@@ -170,8 +925,72 @@ func (nr *NodeRegistrar) RegisterNode(n *node.Node, manager NodeManager) error {
 	return nil
 }
 
+// RefreshHostKeys re-applies the ipcache entries of all nodes known to the
+// store with the current IPsec key identity of the local node
+func (nr *NodeRegistrar) RefreshHostKeys() {
+	if nr.observer != nil {
+		nr.observer.RefreshHostKeys()
+	}
+}
+
 // UpdateLocalKeySync synchronizes the local key for the node using the
 // SharedStore.
 func (nr *NodeRegistrar) UpdateLocalKeySync(n *node.Node) error {
 	return nr.SharedStore.UpdateLocalKeySync(n)
 }
+
+// DeleteLocalNode removes the key of the local node n from the kvstore
+func (nr *NodeRegistrar) DeleteLocalNode(n *node.Node) {
+	if nr.SharedStore != nil {
+		nr.SharedStore.DeleteLocalKey(n)
+	}
+}
+
+// EnsureRegistered verifies that the local node is still registered in the
+// kvstore and re-registers it if its key went missing, e.g. because the
+// kvstore has been restored from an older snapshot. Returns true if the node
+// has been re-registered.
+func (nr *NodeRegistrar) EnsureRegistered(n *node.Node) (bool, error) {
+	if nr.SharedStore == nil {
+		return false, nil
+	}
+
+	reregistered, err := nr.SharedStore.EnsureLocalKey(n.GetKeyName())
+	if err != nil {
+		return false, err
+	}
+
+	if reregistered {
+		metrics.NodeReregistrations.Inc()
+		log.WithField(logfields.NodeName, n.Name).Warning("Local node was missing in the kvstore, re-registered it")
+	}
+	return reregistered, nil
+}
+
+// GetStoreEntries returns all nodes of the shared store sorted by name along
+// with the kvstore revision and the time of their last update, followed by
+// the nodes of which the deletion is pending. Returns nil if the local node
+// has not been registered.
+func (nr *NodeRegistrar) GetStoreEntries() []*models.NodeStoreEntry {
+	if nr.SharedStore == nil {
+		return nil
+	}
+
+	info := nr.SharedStore.SharedKeysInfo()
+	entries := make([]*models.NodeStoreEntry, 0, len(info))
+	for name, keyInfo := range info {
+		entries = append(entries, &models.NodeStoreEntry{
+			Name:        name,
+			ModRevision: int64(keyInfo.ModRevision),
+			LastUpdate:  strfmt.DateTime(keyInfo.LastUpdate),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	if nr.observer != nil {
+		pending := nr.observer.PendingDeletions()
+		sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+		entries = append(entries, pending...)
+	}
+	return entries
+}
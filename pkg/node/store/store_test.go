@@ -0,0 +1,670 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package store
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/identity"
+	"github.com/cilium/cilium/pkg/ipcache"
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/maps/metricsmap"
+	"github.com/cilium/cilium/pkg/node"
+	"github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/trigger"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type NodeStoreSuite struct{}
+
+var _ = Suite(&NodeStoreSuite{})
+
+// fakeManager records the nodes updated and deleted
+type fakeManager struct {
+	mutex       lock.Mutex
+	updated     []string
+	softUpdated []string
+	batches     [][]string
+	deleted     []string
+
+	addressChanges []addressChange
+}
+
+// addressChange is a change of the addresses of a node
+type addressChange struct {
+	name    string
+	added   []node.Address
+	removed []node.Address
+}
+
+func (f *fakeManager) NodeTerminating(n node.Node)  {}
+func (f *fakeManager) Exists(id node.Identity) bool { return false }
+
+func (f *fakeManager) NodeUpdated(n node.Node) {
+	f.mutex.Lock()
+	f.updated = append(f.updated, n.Name)
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) NodeBatchUpdated(nodes []node.Node) {
+	f.mutex.Lock()
+	var batch []string
+	for _, n := range nodes {
+		batch = append(batch, n.Name)
+	}
+	f.batches = append(f.batches, batch)
+	f.updated = append(f.updated, batch...)
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) getBatches() [][]string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([][]string{}, f.batches...)
+}
+
+func (f *fakeManager) NodeSoftUpdated(n node.Node) {
+	f.mutex.Lock()
+	f.softUpdated = append(f.softUpdated, n.Name)
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) getSoftUpdated() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.softUpdated...)
+}
+
+func (f *fakeManager) getUpdated() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.updated...)
+}
+
+func (f *fakeManager) NodeAddressesChanged(n node.Node, added, removed []node.Address) {
+	f.mutex.Lock()
+	f.addressChanges = append(f.addressChanges, addressChange{name: n.Name, added: added, removed: removed})
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) getAddressChanges() []addressChange {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]addressChange{}, f.addressChanges...)
+}
+
+func (f *fakeManager) NodeDeleted(n node.Node) {
+	f.mutex.Lock()
+	f.deleted = append(f.deleted, n.Name)
+	f.mutex.Unlock()
+}
+
+func (f *fakeManager) getDeleted() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.deleted...)
+}
+
+func (s *NodeStoreSuite) TestPendingDeletion(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "50ms"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+
+	// A node re-registering within the delay is not deleted
+	observer.OnDelete(&node.Node{Name: "returning"})
+	observer.OnUpdate(&node.Node{Name: "returning"})
+
+	// A node not re-registering is deleted after the delay
+	observer.OnDelete(&node.Node{Name: "gone"})
+	c.Assert(manager.getDeleted(), HasLen, 0)
+
+	time.Sleep(200 * time.Millisecond)
+	c.Assert(manager.getDeleted(), DeepEquals, []string{"gone"})
+
+	observer.mutex.Lock()
+	c.Assert(observer.pending, HasLen, 0)
+	observer.mutex.Unlock()
+}
+
+func (s *NodeStoreSuite) TestCoalescedUpserts(c *C) {
+	observer := NewNodeObserver(&fakeManager{})
+	// Apply the batch explicitly to observe the coalescing
+	observer.upsertTrigger.Shutdown()
+	noop, err := trigger.NewTrigger(trigger.Parameters{TriggerFunc: func([]string) {}})
+	c.Assert(err, IsNil)
+	defer noop.Shutdown()
+	observer.upsertTrigger = noop
+
+	newNode := func(ip string) *node.Node {
+		return &node.Node{
+			Name: "coalesced",
+			IPAddresses: []node.Address{
+				{Type: addressing.NodeCiliumInternalIP, IP: net.ParseIP(ip)},
+			},
+		}
+	}
+
+	// Consecutive updates of a node replace its pending upserts
+	observer.OnUpdate(newNode("10.11.0.1"))
+	observer.OnUpdate(newNode("10.11.0.2"))
+
+	observer.batchMutex.Lock()
+	c.Assert(observer.upserts, HasLen, 1)
+	entries := observer.upserts[newNode("").Identity()]
+	observer.batchMutex.Unlock()
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].IP, Equals, "10.11.0.2")
+
+	observer.flushUpserts()
+	defer ipcache.IPIdentityCache.Delete("10.11.0.2", ipcache.FromKVStore)
+
+	id, ok := ipcache.IPIdentityCache.LookupByIP("10.11.0.2")
+	c.Assert(ok, Equals, true)
+	c.Assert(id.ID, Equals, identity.ReservedIdentityHost)
+	_, ok = ipcache.IPIdentityCache.LookupByIP("10.11.0.1")
+	c.Assert(ok, Equals, false)
+
+	observer.batchMutex.Lock()
+	c.Assert(observer.upserts, HasLen, 0)
+	observer.batchMutex.Unlock()
+}
+
+func (s *NodeStoreSuite) TestSkipUnchangedUpdates(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "0s"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+
+	received := func(n *node.Node) *node.Node {
+		data, err := n.Marshal()
+		c.Assert(err, IsNil)
+		r := &node.Node{}
+		c.Assert(r.Unmarshal(data), IsNil)
+		return r
+	}
+
+	n := &node.Node{Name: "unchanged", Labels: map[string]string{"a": "b"}}
+	observer.OnUpdate(received(n))
+	observer.OnUpdate(received(n))
+	c.Assert(manager.getUpdated(), DeepEquals, []string{"unchanged"})
+
+	// Nodes without hash are compared by their computed hash. The
+	// datapath attributes are unchanged, the update is a soft update.
+	observer.OnUpdate(n.DeepCopy())
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getUpdated(), DeepEquals, []string{"unchanged"})
+	c.Assert(manager.getSoftUpdated(), DeepEquals, []string{"unchanged"})
+
+	// Changes not affecting the datapath are soft updates
+	n.Labels["a"] = "c"
+	observer.OnUpdate(received(n))
+	c.Assert(manager.getUpdated(), HasLen, 1)
+	c.Assert(manager.getSoftUpdated(), HasLen, 2)
+
+	n.IPv4HealthIP = net.ParseIP("10.13.0.2")
+	observer.OnUpdate(received(n))
+	c.Assert(manager.getUpdated(), HasLen, 1)
+	c.Assert(manager.getSoftUpdated(), HasLen, 3)
+
+	n.MTU = 1450
+	observer.OnUpdate(received(n))
+	c.Assert(manager.getUpdated(), HasLen, 2)
+	c.Assert(manager.getSoftUpdated(), HasLen, 3)
+
+	// A node deleted and added again is applied again
+	observer.OnDelete(received(n))
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(manager.getDeleted(), DeepEquals, []string{"unchanged"})
+	observer.OnUpdate(received(n))
+	c.Assert(manager.getUpdated(), HasLen, 3)
+}
+
+func (s *NodeStoreSuite) TestNodeValidation(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "0s"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+
+	n1 := &node.Node{Name: "node-1", IPv4AllocCIDR: cidr.MustParseCIDR("10.1.0.0/16")}
+	observer.OnUpdate(n1)
+	c.Assert(manager.getUpdated(), DeepEquals, []string{"node-1"})
+
+	// Invalid updates are rejected, the previous version remains applied
+	invalid := n1.DeepCopy()
+	invalid.IPv4AllocCIDR = cidr.MustParseCIDR("f00d::/96")
+	observer.OnUpdate(invalid)
+	c.Assert(manager.getUpdated(), HasLen, 1)
+	applied, ok := observer.journal.get(n1.Identity())
+	c.Assert(ok, Equals, true)
+	c.Assert(applied.IPv4AllocCIDR.String(), Equals, "10.1.0.0/16")
+
+	// Overlapping allocation CIDRs are left to the node manager
+	n2 := &node.Node{Name: "node-2", IPv4AllocCIDR: cidr.MustParseCIDR("10.1.128.0/17")}
+	observer.OnUpdate(n2)
+	c.Assert(manager.getUpdated(), DeepEquals, []string{"node-1", "node-2"})
+
+	// Nodes using an address of another node are quarantined
+	n1.IPAddresses = []node.Address{{IP: net.ParseIP("192.0.2.1"), Type: addressing.NodeInternalIP}}
+	observer.OnUpdate(n1)
+	n3 := &node.Node{
+		Name:        "node-3",
+		IPAddresses: []node.Address{{IP: net.ParseIP("192.0.2.1"), Type: addressing.NodeInternalIP}},
+	}
+	observer.OnUpdate(n3)
+	c.Assert(manager.getUpdated(), HasLen, 3)
+	_, ok = observer.journal.get(n3.Identity())
+	c.Assert(ok, Equals, false)
+
+	// The quarantined node is applied once the address is released
+	observer.OnDelete(n1)
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(manager.getDeleted(), DeepEquals, []string{"node-1"})
+	c.Assert(manager.getUpdated(), DeepEquals, []string{"node-1", "node-2", "node-1", "node-3"})
+}
+
+func (s *NodeStoreSuite) TestRefreshHostKeys(c *C) {
+	oldKey := node.GetIPsecKeyIdentity()
+	defer node.SetIPsecKeyIdentity(oldKey)
+	node.SetIPsecKeyIdentity(1)
+
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+	observer.upsertTrigger.Shutdown()
+	noop, err := trigger.NewTrigger(trigger.Parameters{TriggerFunc: func([]string) {}})
+	c.Assert(err, IsNil)
+	defer noop.Shutdown()
+	observer.upsertTrigger = noop
+
+	n := &node.Node{
+		Name:                "refreshed",
+		EncryptionPublicKey: "key1",
+		IPAddresses: []node.Address{
+			{Type: addressing.NodeCiliumInternalIP, IP: net.ParseIP("10.12.0.1")},
+		},
+	}
+	observer.OnUpdate(n.DeepCopy())
+	observer.flushUpserts()
+
+	// A rotated public key of the remote node is applied
+	n.EncryptionPublicKey = "key2"
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getUpdated(), HasLen, 2)
+	c.Assert(observer.Snapshot().Nodes[0].EncryptionPublicKey, Equals, "key2")
+	observer.flushUpserts()
+
+	// Rotating the local key identity re-queues the entries of all nodes
+	node.SetIPsecKeyIdentity(2)
+	observer.RefreshHostKeys()
+	observer.batchMutex.Lock()
+	entries := observer.upserts[n.Identity()]
+	observer.batchMutex.Unlock()
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].HostKey, Equals, uint8(2))
+	observer.flushUpserts()
+	defer ipcache.IPIdentityCache.Delete("10.12.0.1", ipcache.FromKVStore)
+
+	// Updates of the unchanged node are skipped after the refresh
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getUpdated(), HasLen, 2)
+}
+
+func (s *NodeStoreSuite) TestHealthIPChange(c *C) {
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+	observer.upsertTrigger.Shutdown()
+	noop, err := trigger.NewTrigger(trigger.Parameters{TriggerFunc: func([]string) {}})
+	c.Assert(err, IsNil)
+	defer noop.Shutdown()
+	observer.upsertTrigger = noop
+
+	n := &node.Node{
+		Name: "health",
+		IPAddresses: []node.Address{
+			{Type: addressing.NodeCiliumInternalIP, IP: net.ParseIP("10.13.0.1")},
+		},
+		IPv4HealthIP: net.ParseIP("10.13.0.10"),
+	}
+	observer.OnUpdate(n.DeepCopy())
+
+	// A health IP change before the initial entries have been applied
+	// must not leave the old health IP behind
+	n.IPv4HealthIP = net.ParseIP("10.13.0.11")
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getSoftUpdated(), HasLen, 1)
+	id, exists := ipcache.IPIdentityCache.LookupByIP("10.13.0.11")
+	c.Assert(exists, Equals, true)
+	c.Assert(id.ID, Equals, identity.ReservedIdentityHealth)
+
+	observer.flushUpserts()
+	_, exists = ipcache.IPIdentityCache.LookupByIP("10.13.0.10")
+	c.Assert(exists, Equals, false)
+	_, exists = ipcache.IPIdentityCache.LookupByIP("10.13.0.1")
+	c.Assert(exists, Equals, true)
+
+	observer.deleteNode(n)
+	_, exists = ipcache.IPIdentityCache.LookupByIP("10.13.0.11")
+	c.Assert(exists, Equals, false)
+	_, exists = ipcache.IPIdentityCache.LookupByIP("10.13.0.1")
+	c.Assert(exists, Equals, false)
+}
+
+func (s *NodeStoreSuite) TestEncryptNodeHostIPs(c *C) {
+	oldEncryptNode := option.Config.EncryptNode
+	option.Config.EncryptNode = true
+	defer func() { option.Config.EncryptNode = oldEncryptNode }()
+
+	observer := NewNodeObserver(&fakeManager{})
+	defer observer.controllers.RemoveAllAndWait()
+	observer.OnSync()
+
+	n := &node.Node{
+		Name: "encrypted",
+		IPAddresses: []node.Address{
+			{Type: addressing.NodeInternalIP, IP: net.ParseIP("192.168.14.1")},
+		},
+	}
+	observer.OnUpdate(n.DeepCopy())
+	observer.flushUpserts()
+	_, exists := ipcache.IPIdentityCache.LookupByIP("192.168.14.1")
+	c.Assert(exists, Equals, true)
+
+	// A changed host IP replaces the previous one
+	n.IPAddresses[0].IP = net.ParseIP("192.168.14.2")
+	observer.OnUpdate(n.DeepCopy())
+	observer.flushUpserts()
+	_, exists = ipcache.IPIdentityCache.LookupByIP("192.168.14.1")
+	c.Assert(exists, Equals, false)
+	_, exists = ipcache.IPIdentityCache.LookupByIP("192.168.14.2")
+	c.Assert(exists, Equals, true)
+
+	observer.deleteNode(n)
+	_, exists = ipcache.IPIdentityCache.LookupByIP("192.168.14.2")
+	c.Assert(exists, Equals, false)
+
+	// Host IPs of nodes no longer known are removed by the sweep
+	stale := node.Identity{Name: "stale"}
+	ipcache.IPIdentityCache.UpsertOrdered([]ipcache.UpsertEntry{{
+		IP:       "192.168.14.3",
+		Identity: ipcache.Identity{ID: identity.ReservedIdentityHost, Source: ipcache.FromKVStore},
+	}})
+	observer.batchMutex.Lock()
+	observer.hostIPs[stale] = "192.168.14.3"
+	observer.batchMutex.Unlock()
+
+	observer.sweepHostIPs()
+	_, exists = ipcache.IPIdentityCache.LookupByIP("192.168.14.3")
+	c.Assert(exists, Equals, false)
+	c.Assert(observer.hostIPs, HasLen, 0)
+}
+
+func (s *NodeStoreSuite) TestNodeAddressesChanged(c *C) {
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+
+	internal := node.Address{Type: addressing.NodeInternalIP, IP: net.ParseIP("192.168.15.1")}
+	external := node.Address{Type: addressing.NodeExternalIP, IP: net.ParseIP("1.1.1.1")}
+	n := &node.Node{Name: "addresses", IPAddresses: []node.Address{internal}}
+
+	// The addresses of new nodes are not reported as changed
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getAddressChanges(), HasLen, 0)
+
+	n.IPAddresses = []node.Address{external}
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getAddressChanges(), DeepEquals, []addressChange{
+		{name: "addresses", added: []node.Address{external}, removed: []node.Address{internal}},
+	})
+
+	// Updates without address changes are not reported
+	n.Labels = map[string]string{"zone": "a"}
+	observer.OnUpdate(n.DeepCopy())
+	c.Assert(manager.getAddressChanges(), HasLen, 1)
+}
+
+func (s *NodeStoreSuite) TestInitialSyncBatch(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "0s"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+
+	// Nodes of the initial list are delivered in a single batch
+	observer.OnUpdate(&node.Node{Name: "a"})
+	observer.OnUpdate(&node.Node{Name: "b"})
+	observer.OnUpdate(&node.Node{Name: "a", Labels: map[string]string{"k": "v"}})
+	observer.OnUpdate(&node.Node{Name: "c"})
+	observer.OnDelete(&node.Node{Name: "c"})
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(manager.getUpdated(), HasLen, 0)
+
+	observer.OnSync()
+	c.Assert(manager.getBatches(), DeepEquals, [][]string{{"a", "b"}})
+
+	// Subsequent updates are delivered individually
+	observer.OnUpdate(&node.Node{Name: "d"})
+	observer.OnSync()
+	c.Assert(manager.getBatches(), HasLen, 1)
+	c.Assert(manager.getUpdated(), DeepEquals, []string{"a", "b", "d"})
+}
+
+func (s *NodeStoreSuite) TestNodeDeleteDelay(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "1m"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	c.Assert(option.Config.GetNodeDeleteDelay(string(node.FromKVStore)), Equals, time.Minute)
+	c.Assert(option.Config.GetNodeDeleteDelay(string(node.FromKubernetes)), Not(Equals), time.Minute)
+}
+
+func (s *NodeStoreSuite) TestObserverDeleteDelay(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "1m"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	manager := &fakeManager{}
+	observer := NewNodeObserver(manager)
+	observer.OnSync()
+
+	// Nodes within their delete delay are listed as pending deletions
+	observer.OnUpdate(&node.Node{Name: "pending", Cluster: "c1"})
+	observer.OnDelete(&node.Node{Name: "pending", Cluster: "c1"})
+	c.Assert(manager.getDeleted(), HasLen, 0)
+	entries := observer.PendingDeletions()
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Name, Equals, "c1/pending")
+	deleteAt := time.Time(entries[0].DeleteAt)
+	c.Assert(deleteAt.Sub(time.Time(entries[0].LastUpdate)), Equals, time.Minute)
+
+	observer.OnUpdate(&node.Node{Name: "pending", Cluster: "c1"})
+	c.Assert(observer.PendingDeletions(), HasLen, 0)
+
+	// The configured delay takes precedence over the option, a zero
+	// delay deletes nodes immediately
+	observer = NewNodeObserverWithConfiguration(manager, ObserverConfiguration{
+		DeleteDelay: map[node.Source]time.Duration{node.FromKVStore: 0},
+	})
+	observer.OnSync()
+	observer.OnUpdate(&node.Node{Name: "immediate"})
+	observer.OnDelete(&node.Node{Name: "immediate"})
+	c.Assert(manager.getDeleted(), DeepEquals, []string{"immediate"})
+	c.Assert(observer.PendingDeletions(), HasLen, 0)
+}
+
+// mapBackend is a kvstore backend storing keys in a map
+type mapBackend struct {
+	kvstore.BackendOperations
+	keys   map[string][]byte
+	writes int
+}
+
+func (m *mapBackend) Get(key string) ([]byte, error) {
+	return m.keys[key], nil
+}
+
+func (m *mapBackend) UpdateIfDifferent(ctx context.Context, key string, value []byte, lease bool) (bool, error) {
+	if string(m.keys[key]) == string(value) {
+		return false, nil
+	}
+	m.keys[key] = value
+	m.writes++
+	return true, nil
+}
+
+func (m *mapBackend) Delete(key string) error {
+	delete(m.keys, key)
+	return nil
+}
+
+func (m *mapBackend) ListPrefix(prefix string) (kvstore.KeyValuePairs, error) {
+	pairs := kvstore.KeyValuePairs{}
+	for key, value := range m.keys {
+		if strings.HasPrefix(key, prefix) {
+			pairs[key] = kvstore.Value{Data: value}
+		}
+	}
+	return pairs, nil
+}
+
+func (s *NodeStoreSuite) TestNodeSummaries(c *C) {
+	backend := &mapBackend{keys: map[string][]byte{}}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	n1 := &node.Node{Name: "b", Cluster: "default"}
+	n2 := &node.Node{Name: "a", Cluster: "default"}
+	c.Assert(writeNodeSummary(backend, n1, &metricsmap.Summary{DroppedPackets: 1}, now), IsNil)
+	c.Assert(writeNodeSummary(backend, n2, &metricsmap.Summary{ForwardedPackets: 2}, now), IsNil)
+	backend.keys[NodeSummaryPrefix+"/default/invalid"] = []byte("{")
+
+	summaries, err := ListNodeSummaries(backend)
+	c.Assert(err, IsNil)
+	c.Assert(summaries, HasLen, 2)
+	c.Assert(summaries[0].Name, Equals, "a")
+	c.Assert(summaries[0].Datapath.ForwardedPackets, Equals, uint64(2))
+	c.Assert(summaries[1].Name, Equals, "b")
+	c.Assert(summaries[1].Datapath.DroppedPackets, Equals, uint64(1))
+	c.Assert(summaries[1].Timestamp.Equal(now), Equals, true)
+
+	// An unchanged summary is not rewritten and keeps its timestamp
+	writes := backend.writes
+	later := now.Add(time.Minute)
+	c.Assert(writeNodeSummary(backend, n1, &metricsmap.Summary{DroppedPackets: 1}, later), IsNil)
+	c.Assert(backend.writes, Equals, writes)
+
+	c.Assert(writeNodeSummary(backend, n1, &metricsmap.Summary{DroppedPackets: 2}, later), IsNil)
+	c.Assert(backend.writes, Equals, writes+1)
+	summaries, err = ListNodeSummaries(backend)
+	c.Assert(err, IsNil)
+	c.Assert(summaries[1].Datapath.DroppedPackets, Equals, uint64(2))
+	c.Assert(summaries[1].Timestamp.Equal(later), Equals, true)
+
+	c.Assert(DeleteNodeSummary(backend, n1), IsNil)
+	summaries, err = ListNodeSummaries(backend)
+	c.Assert(err, IsNil)
+	c.Assert(summaries, HasLen, 1)
+
+	// Summaries of a tenant are stored below the tenant prefix and are
+	// not visible to other tenants
+	oldTenant := option.Config.KVStoreTenant
+	defer func() { option.Config.KVStoreTenant = oldTenant }()
+	option.Config.KVStoreTenant = "a"
+	c.Assert(writeNodeSummary(backend, n1, &metricsmap.Summary{}, now), IsNil)
+	_, ok := backend.keys["cilium/tenants/a/state/nodesummaries/v1/default/b"]
+	c.Assert(ok, Equals, true)
+	summaries, err = ListNodeSummaries(backend)
+	c.Assert(err, IsNil)
+	c.Assert(summaries, HasLen, 1)
+	c.Assert(summaries[0].Name, Equals, "b")
+}
+
+func (s *NodeStoreSuite) TestSnapshotChangesSince(c *C) {
+	oldDelay := option.Config.NodeDeleteDelay
+	option.Config.NodeDeleteDelay = map[string]string{string(node.FromKVStore): "0s"}
+	defer func() { option.Config.NodeDeleteDelay = oldDelay }()
+
+	observer := NewNodeObserver(&fakeManager{})
+	observer.OnUpdate(&node.Node{Cluster: "default", Name: "a"})
+	observer.OnUpdate(&node.Node{Cluster: "default", Name: "b"})
+
+	snapshot := observer.Snapshot()
+	c.Assert(snapshot.Nodes, HasLen, 2)
+	c.Assert(snapshot.Nodes[0].Name, Equals, "a")
+	c.Assert(snapshot.Nodes[1].Name, Equals, "b")
+
+	// Nothing changed
+	changes, err := observer.ChangesSince(snapshot.ETag)
+	c.Assert(err, IsNil)
+	c.Assert(changes.ETag, Equals, snapshot.ETag)
+	c.Assert(changes.Updated, HasLen, 0)
+	c.Assert(changes.Deleted, HasLen, 0)
+
+	observer.OnUpdate(&node.Node{Cluster: "default", Name: "c"})
+	observer.OnDelete(&node.Node{Cluster: "default", Name: "a"})
+	time.Sleep(100 * time.Millisecond)
+
+	changes, err = observer.ChangesSince(snapshot.ETag)
+	c.Assert(err, IsNil)
+	c.Assert(changes.ETag, Not(Equals), snapshot.ETag)
+	c.Assert(changes.Updated, HasLen, 1)
+	c.Assert(changes.Updated[0].Name, Equals, "c")
+	c.Assert(changes.Deleted, DeepEquals, []node.Identity{{Cluster: "default", Name: "a"}})
+
+	// ETags of other instances are rejected
+	_, err = NewNodeObserver(&fakeManager{}).ChangesSince(snapshot.ETag)
+	c.Assert(err, Equals, ErrETagExpired)
+	_, err = observer.ChangesSince("foo")
+	c.Assert(err, Equals, ErrETagExpired)
+
+	// Changes before the oldest remembered deletion cannot be determined
+	for i := 0; i <= maxTombstones; i++ {
+		id := node.Identity{Cluster: "default", Name: strconv.Itoa(i)}
+		observer.journal.update(node.Node{Cluster: id.Cluster, Name: id.Name})
+		observer.journal.delete(id)
+	}
+	_, err = observer.ChangesSince(changes.ETag)
+	c.Assert(err, Equals, ErrETagExpired)
+
+	var registrar NodeRegistrar
+	_, err = registrar.Snapshot()
+	c.Assert(err, Equals, ErrNotRegistered)
+}
@@ -0,0 +1,146 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/maps/metricsmap"
+	"github.com/cilium/cilium/pkg/node"
+)
+
+const (
+	// maxSummaryDropReasons is the maximum number of drop reasons
+	// included in a node summary
+	maxSummaryDropReasons = 8
+)
+
+var (
+	// NodeSummaryPrefix is the kvstore prefix of the datapath summaries
+	// published by the nodes. Summaries are kept separate from the node
+	// store as they change frequently and are only of interest to
+	// central consumers such as the operator. The prefix of the
+	// configured tenant is injected, see kvstore.TenantPath().
+	//
+	// WARNING - STABLE API: Changing the structure or values of this will
+	// break backwards compatibility
+	NodeSummaryPrefix = path.Join(kvstore.BaseKeyPrefix, "state", "nodesummaries", "v1")
+)
+
+// NodeSummary is the datapath summary of a node
+type NodeSummary struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+
+	// Cluster is the name of the cluster the node is associated with
+	Cluster string `json:"cluster"`
+
+	// Timestamp is the time the summary was taken. Summaries are only
+	// rewritten when their content changes, the timestamp is thus the
+	// time of the last change.
+	Timestamp time.Time `json:"timestamp"`
+
+	// Datapath is the summary of the drops and forwards of the node
+	Datapath metricsmap.Summary `json:"datapath"`
+}
+
+// summaryPath returns the kvstore key of the summary of the given node
+func summaryPath(n *node.Node) string {
+	return path.Join(kvstore.TenantPath(NodeSummaryPrefix), n.GetKeyName())
+}
+
+// PublishNodeSummary publishes the datapath summary of the local node n into
+// the kvstore. The summary is attached to the lease of the agent so that it
+// is removed when the agent disappears.
+func PublishNodeSummary(backend kvstore.BackendOperations, n *node.Node) error {
+	datapath, err := metricsmap.Summarize(maxSummaryDropReasons)
+	if err != nil {
+		return err
+	}
+
+	return writeNodeSummary(backend, n, datapath, time.Now())
+}
+
+// writeNodeSummary writes the datapath summary of n taken at time now into
+// the kvstore. If the published summary has the same content, its timestamp
+// is kept so that the kvstore is not written to.
+func writeNodeSummary(backend kvstore.BackendOperations, n *node.Node, datapath *metricsmap.Summary, now time.Time) error {
+	key := summaryPath(n)
+	summary := &NodeSummary{
+		Name:      n.Name,
+		Cluster:   n.Cluster,
+		Timestamp: now,
+		Datapath:  *datapath,
+	}
+
+	if value, err := backend.Get(key); err == nil && value != nil {
+		published := &NodeSummary{}
+		if err := json.Unmarshal(value, published); err == nil && published.sameContent(summary) {
+			summary.Timestamp = published.Timestamp
+		}
+	}
+
+	value, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	_, err = backend.UpdateIfDifferent(context.TODO(), key, value, true)
+	return err
+}
+
+// sameContent returns true if s and o only differ in their timestamp
+func (s *NodeSummary) sameContent(o *NodeSummary) bool {
+	return s.Name == o.Name && s.Cluster == o.Cluster && reflect.DeepEqual(s.Datapath, o.Datapath)
+}
+
+// DeleteNodeSummary removes the datapath summary of the local node n from
+// the kvstore
+func DeleteNodeSummary(backend kvstore.BackendOperations, n *node.Node) error {
+	return backend.Delete(summaryPath(n))
+}
+
+// ListNodeSummaries returns the datapath summaries of all nodes sorted by
+// cluster and name. Summaries which cannot be decoded are skipped.
+func ListNodeSummaries(backend kvstore.BackendOperations) ([]*NodeSummary, error) {
+	pairs, err := backend.ListPrefix(kvstore.TenantPath(NodeSummaryPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]*NodeSummary, 0, len(pairs))
+	for key, value := range pairs {
+		summary := &NodeSummary{}
+		if err := json.Unmarshal(value.Data, summary); err != nil {
+			log.WithError(err).WithField("key", key).Warning("Unable to decode node summary")
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Cluster != summaries[j].Cluster {
+			return summaries[i].Cluster < summaries[j].Cluster
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries, nil
+}
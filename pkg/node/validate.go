@@ -0,0 +1,198 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/node/addressing"
+)
+
+const (
+	// ValidationInvalidIP is the reason of a node address or health IP
+	// which is not a valid unicast IP
+	ValidationInvalidIP = "invalid_ip"
+
+	// ValidationInvalidCIDR is the reason of an allocation CIDR which is
+	// malformed or does not match the address family it is used for
+	ValidationInvalidCIDR = "invalid_cidr"
+
+	// ValidationConflictingCIDR is the reason of allocation CIDRs of a
+	// node which overlap each other
+	ValidationConflictingCIDR = "conflicting_cidr"
+
+	// ValidationOverlappingNode is the reason of a node using an address of
+	// another node
+	ValidationOverlappingNode = "overlapping_node"
+)
+
+// ValidationError is the error returned when a node fails validation
+type ValidationError struct {
+	// Reason is one of the Validation* reasons, used to label metrics
+	Reason string
+
+	// Err describes the invalid field of the node
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func newValidationError(reason, format string, a ...interface{}) *ValidationError {
+	return &ValidationError{Reason: reason, Err: fmt.Errorf(format, a...)}
+}
+
+// isValidIP returns true if ip is a well-formed IP which can be assigned to
+// a node
+func isValidIP(ip net.IP) bool {
+	return (len(ip) == net.IPv4len || len(ip) == net.IPv6len) && !ip.IsUnspecified()
+}
+
+// isValidCIDR returns true if c is a well-formed CIDR of the address family
+// selected by ipv6
+func isValidCIDR(c *cidr.CIDR, ipv6 bool) bool {
+	if c.IPNet == nil || !isValidIP(c.IP) {
+		return false
+	}
+	// Size returns 0 bits for non-canonical masks
+	if _, bits := c.Mask.Size(); bits == 0 {
+		return false
+	}
+	_, bits := c.Mask.Size()
+	return (c.IP.To4() == nil) == ipv6 && (bits == 8*net.IPv6len) == ipv6
+}
+
+// cidrsOverlap returns true if a and b have addresses in common
+func cidrsOverlap(a, b *cidr.CIDR) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// check validates the fields of the node. If sanitize is true, invalid
+// fields are removed from the node. Returns all problems found.
+func (n *Node) check(sanitize bool) []*ValidationError {
+	var errs []*ValidationError
+
+	addresses := n.IPAddresses[:0:0]
+	for _, addr := range n.IPAddresses {
+		if !isValidIP(addr.IP) {
+			errs = append(errs, newValidationError(ValidationInvalidIP,
+				"invalid %s address %q", addr.Type, addr.IP))
+			continue
+		}
+		addresses = append(addresses, addr)
+	}
+	if sanitize {
+		n.IPAddresses = addresses
+	}
+
+	for _, family := range []struct {
+		ipv6      bool
+		alloc     **cidr.CIDR
+		secondary *[]*cidr.CIDR
+		healthIP  *net.IP
+	}{
+		{false, &n.IPv4AllocCIDR, &n.IPv4SecondaryAllocCIDRs, &n.IPv4HealthIP},
+		{true, &n.IPv6AllocCIDR, &n.IPv6SecondaryAllocCIDRs, &n.IPv6HealthIP},
+	} {
+		if c := *family.alloc; c != nil && !isValidCIDR(c, family.ipv6) {
+			errs = append(errs, newValidationError(ValidationInvalidCIDR,
+				"invalid allocation CIDR %s", c))
+			if sanitize {
+				*family.alloc = nil
+			}
+		}
+
+		// Secondary CIDRs must neither overlap the primary CIDR
+		// nor each other
+		accepted := []*cidr.CIDR{}
+		if *family.alloc != nil && isValidCIDR(*family.alloc, family.ipv6) {
+			accepted = append(accepted, *family.alloc)
+		}
+		secondary := (*family.secondary)[:0:0]
+		for _, c := range *family.secondary {
+			if c == nil || !isValidCIDR(c, family.ipv6) {
+				errs = append(errs, newValidationError(ValidationInvalidCIDR,
+					"invalid secondary allocation CIDR %s", c))
+				continue
+			}
+			conflict := false
+			for _, other := range accepted {
+				if cidrsOverlap(c, other) {
+					errs = append(errs, newValidationError(ValidationConflictingCIDR,
+						"secondary allocation CIDR %s overlaps allocation CIDR %s", c, other))
+					conflict = true
+					break
+				}
+			}
+			if !conflict {
+				accepted = append(accepted, c)
+				secondary = append(secondary, c)
+			}
+		}
+		if sanitize && len(secondary) != len(*family.secondary) {
+			*family.secondary = secondary
+		}
+
+		if ip := *family.healthIP; ip != nil && (!isValidIP(ip) || (ip.To4() == nil) != family.ipv6) {
+			errs = append(errs, newValidationError(ValidationInvalidIP,
+				"invalid health IP %q", ip))
+			if sanitize {
+				*family.healthIP = nil
+			}
+		}
+	}
+
+	return errs
+}
+
+// Validate returns a ValidationError describing the first invalid field of
+// the node, or nil if the node is valid
+func (n *Node) Validate() error {
+	if errs := n.check(false); len(errs) != 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Sanitize removes all invalid addresses, allocation CIDRs and health IPs
+// from the node and returns the problems found
+func (n *Node) Sanitize() []*ValidationError {
+	return n.check(true)
+}
+
+// SharesAddress returns a ValidationError if the node uses an address of
+// other, apart from external IPs shared by both nodes. Overlapping allocation
+// CIDRs are detected by the node manager. Both nodes must be valid.
+func (n *Node) SharesAddress(other *Node) error {
+	for _, addr := range n.IPAddresses {
+		for _, o := range other.IPAddresses {
+			if !addr.IP.Equal(o.IP) {
+				continue
+			}
+			// Only addresses used for routing and the ipcache must be
+			// unique, e.g. external IPs may be shared behind a NAT
+			if addr.Type == addressing.NodeExternalIP && o.Type == addressing.NodeExternalIP {
+				continue
+			}
+			return newValidationError(ValidationOverlappingNode,
+				"%s address %s is also used by node %s", addr.Type, addr.IP, other.Fullname())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package node
+
+import (
+	"net"
+
+	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/node/addressing"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *NodeSuite) TestValidate(c *C) {
+	n := Node{
+		Name: "node-1",
+		IPAddresses: []Address{
+			{IP: net.ParseIP("192.0.2.1"), Type: addressing.NodeInternalIP},
+			{IP: net.ParseIP("10.1.0.1"), Type: addressing.NodeCiliumInternalIP},
+		},
+		IPv4AllocCIDR:           cidr.MustParseCIDR("10.1.0.0/16"),
+		IPv4SecondaryAllocCIDRs: []*cidr.CIDR{cidr.MustParseCIDR("10.2.0.0/16")},
+		IPv6AllocCIDR:           cidr.MustParseCIDR("f00d::/96"),
+		IPv4HealthIP:            net.ParseIP("10.1.0.2"),
+	}
+	c.Assert(n.Validate(), IsNil)
+	c.Assert(n.Sanitize(), HasLen, 0)
+
+	invalid := n.DeepCopy()
+	invalid.IPAddresses = append(invalid.IPAddresses, Address{IP: net.IPv4zero, Type: addressing.NodeExternalIP})
+	err := invalid.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ValidationError).Reason, Equals, ValidationInvalidIP)
+
+	invalid = n.DeepCopy()
+	invalid.IPv6AllocCIDR = cidr.MustParseCIDR("10.3.0.0/16")
+	err = invalid.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ValidationError).Reason, Equals, ValidationInvalidCIDR)
+
+	invalid = n.DeepCopy()
+	invalid.IPv4SecondaryAllocCIDRs = append(invalid.IPv4SecondaryAllocCIDRs, cidr.MustParseCIDR("10.1.128.0/17"))
+	err = invalid.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ValidationError).Reason, Equals, ValidationConflictingCIDR)
+
+	invalid = n.DeepCopy()
+	invalid.IPv6HealthIP = net.ParseIP("10.1.0.3")
+	err = invalid.Validate()
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ValidationError).Reason, Equals, ValidationInvalidIP)
+}
+
+func (s *NodeSuite) TestSanitize(c *C) {
+	n := Node{
+		Name: "node-1",
+		IPAddresses: []Address{
+			{IP: net.ParseIP("192.0.2.1"), Type: addressing.NodeInternalIP},
+			{IP: net.IPv6unspecified, Type: addressing.NodeCiliumInternalIP},
+		},
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.1.0.0/16"),
+		IPv4SecondaryAllocCIDRs: []*cidr.CIDR{
+			cidr.MustParseCIDR("10.1.0.0/24"),
+			cidr.MustParseCIDR("10.2.0.0/16"),
+			cidr.MustParseCIDR("10.2.1.0/24"),
+		},
+		IPv6AllocCIDR: cidr.MustParseCIDR("10.3.0.0/16"),
+		IPv4HealthIP:  net.ParseIP("f00d::1"),
+	}
+
+	errs := n.Sanitize()
+	c.Assert(errs, HasLen, 5)
+	c.Assert(n.Validate(), IsNil)
+	c.Assert(n.IPAddresses, HasLen, 1)
+	c.Assert(n.IPv4AllocCIDR.String(), Equals, "10.1.0.0/16")
+	c.Assert(n.IPv4SecondaryAllocCIDRs, DeepEquals, []*cidr.CIDR{cidr.MustParseCIDR("10.2.0.0/16")})
+	c.Assert(n.IPv6AllocCIDR, IsNil)
+	c.Assert(n.IPv4HealthIP, IsNil)
+}
+
+func (s *NodeSuite) TestSharesAddress(c *C) {
+	n1 := Node{
+		Name: "node-1",
+		IPAddresses: []Address{
+			{IP: net.ParseIP("192.0.2.1"), Type: addressing.NodeInternalIP},
+			{IP: net.ParseIP("198.51.100.1"), Type: addressing.NodeExternalIP},
+		},
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.1.0.0/16"),
+	}
+	n2 := Node{
+		Name: "node-2",
+		IPAddresses: []Address{
+			{IP: net.ParseIP("192.0.2.2"), Type: addressing.NodeInternalIP},
+			{IP: net.ParseIP("198.51.100.1"), Type: addressing.NodeExternalIP},
+		},
+		IPv4AllocCIDR: cidr.MustParseCIDR("10.1.0.0/16"),
+	}
+	// Shared external IPs are allowed, allocation CIDRs are not checked
+	c.Assert(n1.SharesAddress(&n2), IsNil)
+
+	n2.IPAddresses[0].IP = net.ParseIP("192.0.2.1")
+	err := n1.SharesAddress(&n2)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*ValidationError).Reason, Equals, ValidationOverlappingNode)
+	c.Assert(n2.SharesAddress(&n1), NotNil)
+}
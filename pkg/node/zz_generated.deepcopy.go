@@ -20,6 +20,8 @@ package node
 
 import (
 	net "net"
+
+	cidr "github.com/cilium/cilium/pkg/cidr"
 )
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
@@ -61,6 +63,26 @@ func (in *Node) DeepCopyInto(out *Node) {
 		in, out := &in.IPv6AllocCIDR, &out.IPv6AllocCIDR
 		*out = (*in).DeepCopy()
 	}
+	if in.IPv4SecondaryAllocCIDRs != nil {
+		in, out := &in.IPv4SecondaryAllocCIDRs, &out.IPv4SecondaryAllocCIDRs
+		*out = make([]*cidr.CIDR, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = (*in).DeepCopy()
+			}
+		}
+	}
+	if in.IPv6SecondaryAllocCIDRs != nil {
+		in, out := &in.IPv6SecondaryAllocCIDRs, &out.IPv6SecondaryAllocCIDRs
+		*out = make([]*cidr.CIDR, len(*in))
+		for i := range *in {
+			if (*in)[i] != nil {
+				in, out := &(*in)[i], &(*out)[i]
+				*out = (*in).DeepCopy()
+			}
+		}
+	}
 	if in.IPv4HealthIP != nil {
 		in, out := &in.IPv4HealthIP, &out.IPv4HealthIP
 		*out = make(net.IP, len(*in))
@@ -71,6 +93,13 @@ func (in *Node) DeepCopyInto(out *Node) {
 		*out = make(net.IP, len(*in))
 		copy(*out, *in)
 	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -19,14 +19,19 @@ import (
 	"time"
 
 	"github.com/cilium/cilium/pkg/cidr"
+	"github.com/cilium/cilium/pkg/comparator"
 	"github.com/cilium/cilium/pkg/controller"
 	"github.com/cilium/cilium/pkg/datapath"
 	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/k8s"
+	"github.com/cilium/cilium/pkg/kvstore"
+	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/mtu"
 	"github.com/cilium/cilium/pkg/node"
 	"github.com/cilium/cilium/pkg/node/addressing"
+	"github.com/cilium/cilium/pkg/node/crdstore"
 	nodemanager "github.com/cilium/cilium/pkg/node/manager"
 	nodestore "github.com/cilium/cilium/pkg/node/store"
 	"github.com/cilium/cilium/pkg/option"
@@ -36,6 +41,12 @@ const (
 	// AutoCIDR indicates that a CIDR should be allocated
 	AutoCIDR = "auto"
 
+	propagateLocalNodeController = "propagating local node change to kv-store"
+
+	registrationWatchdogController = "local-node-registration-watchdog"
+
+	summaryPublisherController = "local-node-summary-publisher"
+
 	nodeDiscoverySubsys = "nodediscovery"
 )
 
@@ -45,9 +56,30 @@ var log = logging.DefaultLogger.WithField(logfields.LogSubsys, nodeDiscoverySubs
 type NodeDiscovery struct {
 	Manager     *nodemanager.Manager
 	LocalConfig datapath.LocalNodeConfiguration
-	Registrar   nodestore.NodeRegistrar
-	LocalNode   node.Node
+	Registrar   nodestore.Registrar
 	Registered  chan struct{}
+	controllers *controller.Manager
+
+	// localNodeMutex protects LocalNode once discovery has been started
+	localNodeMutex lock.RWMutex
+	// LocalNode is the local node. Once discovery has been started it
+	// must only be accessed via GetLocalNode() or with localNodeMutex held
+	LocalNode node.Node
+
+	// terminatingMutex protects terminating and terminationRequested
+	terminatingMutex lock.Mutex
+	// terminating is true once the local node has been marked as
+	// terminating and has been deregistered from the kvstore
+	terminating bool
+	// terminationRequested is the termination state of the local node
+	// last reported by the node manager
+	terminationRequested bool
+	// terminationTrigger is notified whenever terminationRequested has
+	// been changed
+	terminationTrigger chan struct{}
+
+	// stop is closed when the node discovery is closed
+	stop chan struct{}
 }
 
 func enableLocalNodeRoute() bool {
@@ -95,7 +127,11 @@ func NewNodeDiscovery(manager *nodemanager.Manager, mtuConfig mtu.Configuration)
 		LocalNode: node.Node{
 			Source: node.FromLocalNode,
 		},
-		Registered: make(chan struct{}),
+		Registrar:          &nodestore.NodeRegistrar{},
+		Registered:         make(chan struct{}),
+		controllers:        controller.NewManager(),
+		terminationTrigger: make(chan struct{}, 1),
+		stop:               make(chan struct{}),
 	}
 }
 
@@ -103,13 +139,22 @@ func NewNodeDiscovery(manager *nodemanager.Manager, mtuConfig mtu.Configuration)
 // agent startup to configure the local node based on the configuration options
 // passed to the agent. nodeName is the name to be used in the local agent.
 func (n *NodeDiscovery) StartDiscovery(nodeName string) {
+	n.localNodeMutex.Lock()
 	n.LocalNode.Name = nodeName
 	n.LocalNode.Cluster = option.Config.ClusterName
 	n.LocalNode.IPAddresses = []node.Address{}
 	n.LocalNode.IPv4AllocCIDR = node.GetIPv4AllocRange()
 	n.LocalNode.IPv6AllocCIDR = node.GetIPv6AllocRange()
+	n.LocalNode.IPv4SecondaryAllocCIDRs = node.GetIPv4SecondaryAllocRanges()
+	n.LocalNode.IPv6SecondaryAllocCIDRs = node.GetIPv6SecondaryAllocRanges()
 	n.LocalNode.ClusterID = option.Config.ClusterID
 	n.LocalNode.EncryptionKey = node.GetIPsecKeyIdentity()
+	n.LocalNode.EncryptionPublicKey = node.GetEncryptionPublicKey()
+	n.LocalNode.MTU = n.LocalConfig.MtuConfig.GetDeviceMTU()
+	if option.Config.Tunnel != option.TunnelDisabled {
+		n.LocalNode.TunnelProtocol = option.Config.Tunnel
+		n.LocalNode.TunnelPort = node.TunnelPortForProtocol(option.Config.Tunnel)
+	}
 
 	if node.GetExternalIPv4() != nil {
 		n.LocalNode.IPAddresses = append(n.LocalNode.IPAddresses, node.Address{
@@ -140,11 +185,14 @@ func (n *NodeDiscovery) StartDiscovery(nodeName string) {
 	}
 
 	n.Manager.NodeUpdated(n.LocalNode)
+	n.localNodeMutex.Unlock()
+
+	n.Registrar = newRegistrar(n.Registrar)
 
 	go func() {
 		log.Info("Adding local node to cluster")
 		for {
-			if err := n.Registrar.RegisterNode(&n.LocalNode, n.Manager); err != nil {
+			if err := n.Registrar.RegisterNode(n.GetLocalNode(), n.Manager); err != nil {
 				log.WithError(err).Error("Unable to initialize local node. Retrying...")
 				time.Sleep(time.Second)
 			} else {
@@ -162,22 +210,316 @@ func (n *NodeDiscovery) StartDiscovery(nodeName string) {
 		}
 	}()
 
+	// Without a registration backend, the local node is never registered
+	// and there is nothing to deregister it from once terminating
+	if !registrationEnabled() {
+		return
+	}
+
+	n.Manager.SubscribeTermination(n)
 	go func() {
-		<-n.Registered
-		controller.NewManager().UpdateController("propagating local node change to kv-store",
-			controller.ControllerParams{
-				DoFunc: func(ctx context.Context) error {
-					err := n.Registrar.UpdateLocalKeySync(&n.LocalNode)
-					if err != nil {
-						log.WithError(err).Error("Unable to propagate local node change to kvstore")
-					}
-					return err
-				},
-			})
+		select {
+		case <-n.Registered:
+		case <-n.stop:
+			return
+		}
+		n.propagateLocalNode()
+		n.startRegistrationWatchdog()
+		n.startSummaryPublisher()
+		n.handleTermination()
 	}()
 }
 
+// registrationEnabled returns true if a backend to register the local node
+// in is configured
+func registrationEnabled() bool {
+	return option.Config.KVStore != "" ||
+		option.Config.NodeRegistrationBackend == option.NodeRegistrationCRD
+}
+
+// GetLocalNode returns a copy of the local node which is safe to be passed on
+// while the local node is being updated
+func (n *NodeDiscovery) GetLocalNode() *node.Node {
+	n.localNodeMutex.RLock()
+	defer n.localNodeMutex.RUnlock()
+	return n.LocalNode.DeepCopy()
+}
+
+// newRegistrar returns the registrar of the configured registration backend.
+// The Kubernetes client is only available once k8s has been initialized, the
+// CRD registrar is therefore created on start while kvstoreRegistrar is
+// created along with the NodeDiscovery.
+func newRegistrar(kvstoreRegistrar nodestore.Registrar) nodestore.Registrar {
+	crd := option.Config.NodeRegistrationBackend == option.NodeRegistrationCRD
+	if !crd && !option.Config.NodeRegistrationDualWrite {
+		return kvstoreRegistrar
+	}
+
+	if !k8s.IsEnabled() {
+		log.Fatalf("Registering nodes through CiliumNode resources requires Kubernetes")
+	}
+	crdRegistrar := crdstore.NewRegistrar(k8s.CiliumClient())
+
+	switch {
+	case !option.Config.NodeRegistrationDualWrite:
+		return crdRegistrar
+	case crd:
+		return &nodestore.DualRegistrar{Primary: crdRegistrar, Secondary: kvstoreRegistrar}
+	default:
+		return &nodestore.DualRegistrar{Primary: kvstoreRegistrar, Secondary: crdRegistrar}
+	}
+}
+
+// startRegistrationWatchdog starts the controller verifying that the local
+// node remains registered in the kvstore. The node key is only written when
+// the local node changes, it is re-registered if it went missing, e.g.
+// after the kvstore has been restored from an older snapshot.
+func (n *NodeDiscovery) startRegistrationWatchdog() {
+	n.terminatingMutex.Lock()
+	defer n.terminatingMutex.Unlock()
+
+	// A terminating node must not be re-registered
+	if n.terminating {
+		return
+	}
+
+	n.controllers.UpdateController(registrationWatchdogController,
+		controller.ControllerParams{
+			DoFunc: func(ctx context.Context) error {
+				_, err := n.Registrar.EnsureRegistered(n.GetLocalNode())
+				return err
+			},
+			RunInterval: defaults.NodeRegistrationWatchdogInterval,
+		})
+}
+
+// propagateLocalNode (re-)starts the controller propagating the local node to
+// the kvstore
+func (n *NodeDiscovery) propagateLocalNode() {
+	n.terminatingMutex.Lock()
+	defer n.terminatingMutex.Unlock()
+
+	// A terminating node must not be re-published to the kvstore
+	if n.terminating {
+		return
+	}
+
+	n.controllers.UpdateController(propagateLocalNodeController,
+		controller.ControllerParams{
+			DoFunc: func(ctx context.Context) error {
+				err := n.Registrar.UpdateLocalKeySync(n.GetLocalNode())
+				if err != nil {
+					log.WithError(err).Error("Unable to propagate local node change to kvstore")
+				}
+				return err
+			},
+		})
+}
+
+// UpdateLocalNodeLabels updates the labels of the local node. If the labels
+// have changed, the change is announced to the node manager and published to
+// the kvstore so that other nodes can select the local node by its labels.
+func (n *NodeDiscovery) UpdateLocalNodeLabels(labels map[string]string) {
+	n.localNodeMutex.Lock()
+	if comparator.MapStringEquals(n.LocalNode.Labels, labels) {
+		n.localNodeMutex.Unlock()
+		return
+	}
+
+	localNode := n.LocalNode.DeepCopy()
+	localNode.Labels = make(map[string]string, len(labels))
+	for k, v := range labels {
+		localNode.Labels[k] = v
+	}
+	n.LocalNode = *localNode
+	n.Manager.NodeUpdated(*localNode)
+	n.localNodeMutex.Unlock()
+
+	select {
+	case <-n.Registered:
+		n.propagateLocalNode()
+	default:
+		// The local node is published with the new labels as part of
+		// the registration
+	}
+}
+
+// UpdateSecondaryAllocCIDRs updates the secondary allocation CIDRs of the
+// local node, e.g. after the node has been assigned an additional pod CIDR,
+// and publishes them so that other nodes install routes for them.
+func (n *NodeDiscovery) UpdateSecondaryAllocCIDRs(v4, v6 []*cidr.CIDR) {
+	if !option.Config.EnableIPv4 {
+		v4 = nil
+	}
+	if !option.Config.EnableIPv6 {
+		v6 = nil
+	}
+
+	n.localNodeMutex.Lock()
+	localNode := n.LocalNode.DeepCopy()
+	localNode.IPv4SecondaryAllocCIDRs = v4
+	localNode.IPv6SecondaryAllocCIDRs = v6
+	if localNode.DatapathAttrEquals(&n.LocalNode) {
+		n.localNodeMutex.Unlock()
+		return
+	}
+
+	node.SetSecondaryAllocRanges(v4, v6)
+	n.LocalNode = *localNode
+	n.Manager.NodeUpdated(*localNode)
+	n.localNodeMutex.Unlock()
+
+	select {
+	case <-n.Registered:
+		n.propagateLocalNode()
+	default:
+		// The local node is published with the new CIDRs as part of
+		// the registration
+	}
+}
+
+// UpdateEncryptionKeys rotates the encryption keys of the local node. The
+// keys are published to the kvstore so that other nodes pick up the new keys
+// through the node store, and the ipcache entries of all other nodes are
+// updated to use the new IPsec key identity.
+func (n *NodeDiscovery) UpdateEncryptionKeys(keyIdentity uint8, publicKey string) {
+	n.localNodeMutex.Lock()
+	if n.LocalNode.EncryptionKey == keyIdentity && n.LocalNode.EncryptionPublicKey == publicKey {
+		n.localNodeMutex.Unlock()
+		return
+	}
+
+	node.SetIPsecKeyIdentity(keyIdentity)
+	node.SetEncryptionPublicKey(publicKey)
+
+	localNode := n.LocalNode.DeepCopy()
+	localNode.EncryptionKey = keyIdentity
+	localNode.EncryptionPublicKey = publicKey
+	n.LocalNode = *localNode
+	n.Manager.NodeUpdated(*localNode)
+	n.localNodeMutex.Unlock()
+
+	select {
+	case <-n.Registered:
+		n.Registrar.RefreshHostKeys()
+		n.propagateLocalNode()
+	default:
+		// The local node is published with the new keys as part of
+		// the registration
+	}
+}
+
+// publishSummary returns true if the datapath summary of the local node is
+// published to the kvstore
+func publishSummary() bool {
+	return option.Config.NodeSummaryInterval != 0 &&
+		option.Config.NodeRegistrationBackend == option.NodeRegistrationKVStore
+}
+
+// startSummaryPublisher starts the controller publishing the datapath summary
+// of the local node to the kvstore if enabled
+func (n *NodeDiscovery) startSummaryPublisher() {
+	if !publishSummary() {
+		return
+	}
+
+	n.terminatingMutex.Lock()
+	defer n.terminatingMutex.Unlock()
+
+	if n.terminating {
+		return
+	}
+
+	n.controllers.UpdateController(summaryPublisherController,
+		controller.ControllerParams{
+			DoFunc: func(ctx context.Context) error {
+				return nodestore.PublishNodeSummary(kvstore.Client(), n.GetLocalNode())
+			},
+			RunInterval: option.Config.NodeSummaryInterval,
+		})
+}
+
+// NodeTerminating implements nodemanager.NodeTerminationHandler. When the
+// local node is terminating, it is proactively removed from the kvstore so
+// that other nodes do not have to wait for the lease of the node key to
+// expire.
+func (n *NodeDiscovery) NodeTerminating(nd node.Node) {
+	if nd.IsLocal() {
+		n.requestTermination(true)
+	}
+}
+
+// NodeTerminationCancelled implements nodemanager.NodeTerminationHandler. If
+// the local node was deregistered as terminating, it is published to the
+// kvstore again and its controllers are restarted.
+func (n *NodeDiscovery) NodeTerminationCancelled(nd node.Node) {
+	if nd.IsLocal() {
+		n.requestTermination(false)
+	}
+}
+
+// requestTermination records the requested termination state of the local
+// node to be applied by handleTermination()
+func (n *NodeDiscovery) requestTermination(terminating bool) {
+	n.terminatingMutex.Lock()
+	n.terminationRequested = terminating
+	n.terminatingMutex.Unlock()
+
+	select {
+	case n.terminationTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// handleTermination deregisters and re-registers the local node whenever the
+// requested termination state changes until the node discovery is closed.
+// All transitions are applied by this single goroutine, a cancellation
+// reported right after the termination therefore always leaves the local
+// node registered. Must only be called once the local node is registered.
+func (n *NodeDiscovery) handleTermination() {
+	for {
+		select {
+		case <-n.terminationTrigger:
+		case <-n.stop:
+			return
+		}
+
+		n.terminatingMutex.Lock()
+		requested, terminating := n.terminationRequested, n.terminating
+		n.terminating = requested
+		n.terminatingMutex.Unlock()
+
+		switch {
+		case requested && !terminating:
+			n.deregisterLocalNode()
+		case !requested && terminating:
+			log.Info("Local node is no longer terminating, adding it back to the cluster")
+			n.propagateLocalNode()
+			n.startRegistrationWatchdog()
+			n.startSummaryPublisher()
+		}
+	}
+}
+
+// deregisterLocalNode stops all controllers publishing the local node and
+// removes the local node from the cluster
+func (n *NodeDiscovery) deregisterLocalNode() {
+	n.controllers.RemoveControllerAndWait(propagateLocalNodeController)
+	n.controllers.RemoveControllerAndWait(registrationWatchdogController)
+	n.controllers.RemoveControllerAndWait(summaryPublisherController)
+
+	log.Info("Local node is terminating, removing it from the cluster")
+	localNode := n.GetLocalNode()
+	n.Registrar.DeleteLocalNode(localNode)
+	if publishSummary() {
+		if err := nodestore.DeleteNodeSummary(kvstore.Client(), localNode); err != nil {
+			log.WithError(err).Warning("Unable to remove summary of local node from kvstore")
+		}
+	}
+}
+
 // Close shuts down the node discovery engine
 func (n *NodeDiscovery) Close() {
+	close(n.stop)
 	n.Manager.Close()
 }
@@ -198,6 +198,9 @@ const (
 	// KVStoreOpt key-value store options
 	KVStoreOpt = "kvstore-opt"
 
+	// KVStoreTenant is the tenant prefix injected into all kvstore keys
+	KVStoreTenant = "kvstore-tenant"
+
 	// Labels is the list of label prefixes used to determine identity of an endpoint
 	Labels = "labels"
 
@@ -446,10 +449,99 @@ const (
 	// synchronization with the kvstore occurs
 	KVstorePeriodicSync = "kvstore-periodic-sync"
 
+	// KVstoreStoreReconcileInterval is the interval in which shared
+	// stores are reconciled with the contents of the kvstore
+	KVstoreStoreReconcileInterval = "kvstore-store-reconcile-interval"
+
+	// KVstoreMaxValueSize is the maximum size in bytes of a value written
+	// to or read from the kvstore
+	KVstoreMaxValueSize = "kvstore-max-value-size"
+
+	// KVstoreRateLimitReads is the maximum rate of kvstore read operations
+	// per second
+	KVstoreRateLimitReads = "kvstore-rate-limit-reads"
+
+	// KVstoreRateLimitWrites is the maximum rate of kvstore write
+	// operations per second
+	KVstoreRateLimitWrites = "kvstore-rate-limit-writes"
+
+	// KVstoreRateLimitLocks is the maximum rate of kvstore lock
+	// acquisitions per second
+	KVstoreRateLimitLocks = "kvstore-rate-limit-locks"
+
+	// KVstoreRateLimitBackground is the maximum rate of background kvstore
+	// operations per second
+	KVstoreRateLimitBackground = "kvstore-rate-limit-background"
+
+	// KVstoreLockFairQueuing enables the queuing of local kvstore lock
+	// attempts in order of arrival
+	KVstoreLockFairQueuing = "kvstore-lock-fair-queuing"
+
+	// KVstoreCircuitBreakerThreshold is the number of consecutive failed
+	// kvstore operations after which the circuit breaker opens
+	KVstoreCircuitBreakerThreshold = "kvstore-circuit-breaker-threshold"
+
+	// KVstoreCircuitBreakerTimeout is the time the kvstore circuit breaker
+	// stays open before operations are attempted again
+	KVstoreCircuitBreakerTimeout = "kvstore-circuit-breaker-timeout"
+
+	// KVstoreCircuitBreakerMaxAge is the maximum age of cached reads
+	// served while the kvstore circuit breaker is open
+	KVstoreCircuitBreakerMaxAge = "kvstore-circuit-breaker-max-age"
+
 	// IdentityChangeGracePeriod is the name of the
 	// IdentityChangeGracePeriod option
 	IdentityChangeGracePeriod = "identity-change-grace-period"
 
+	// IdentityQuarantinePeriod is the name of the
+	// IdentityQuarantinePeriod option
+	IdentityQuarantinePeriod = "identity-quarantine-period"
+
+	// IdentityCompression is the name of the IdentityCompression option
+	IdentityCompression = "identity-compression"
+
+	// NodeDeltaUpdates is the name of the NodeDeltaUpdates option
+	NodeDeltaUpdates = "node-delta-updates"
+
+	// NodePreferLocal is the name of the NodePreferLocal option
+	NodePreferLocal = "node-prefer-local"
+
+	// NodeDeleteDelay is the name of the NodeDeleteDelay option
+	NodeDeleteDelay = "node-delete-delay"
+
+	// NodeSummaryInterval is the name of the NodeSummaryInterval option
+	NodeSummaryInterval = "node-summary-interval"
+
+	// NodeAddressTypes is the name of the NodeAddressTypes option
+	NodeAddressTypes = "node-address-types"
+
+	// NodeAddressPreferredCIDRs is the name of the
+	// NodeAddressPreferredCIDRs option
+	NodeAddressPreferredCIDRs = "node-address-preferred-cidrs"
+
+	// NodeAddressExcludedCIDRs is the name of the NodeAddressExcludedCIDRs
+	// option
+	NodeAddressExcludedCIDRs = "node-address-excluded-cidrs"
+
+	// ResolveNodeAddressDNS is the name of the ResolveNodeAddressDNS option
+	ResolveNodeAddressDNS = "resolve-node-address-dns"
+
+	// NodeRegistrationBackend is the name of the NodeRegistrationBackend
+	// option
+	NodeRegistrationBackend = "node-registration-backend"
+
+	// NodeRegistrationKVStore is the value to select the kvstore for
+	// option.NodeRegistrationBackend
+	NodeRegistrationKVStore = "kvstore"
+
+	// NodeRegistrationCRD is the value to select the CiliumNode custom
+	// resource for option.NodeRegistrationBackend
+	NodeRegistrationCRD = "crd"
+
+	// NodeRegistrationDualWrite is the name of the
+	// NodeRegistrationDualWrite option
+	NodeRegistrationDualWrite = "node-registration-dual-write"
+
 	// EnableHealthChecking is the name of the EnableHealthChecking option
 	EnableHealthChecking = "enable-health-checking"
 
@@ -467,10 +559,28 @@ const (
 	// K8sEventHandover is the name of the K8sEventHandover option
 	K8sEventHandover = "enable-k8s-event-handover"
 
+	// K8sRewriteLegacyNodeAnnotations is the name of the
+	// K8sRewriteLegacyNodeAnnotations option
+	K8sRewriteLegacyNodeAnnotations = "k8s-rewrite-legacy-node-annotations"
+
+	// K8sNodeResyncPeriod is the name of the K8sNodeResyncPeriod option
+	K8sNodeResyncPeriod = "k8s-node-resync-period"
+
+	// K8sNodeRelistBackoffMin is the name of the K8sNodeRelistBackoffMin
+	// option
+	K8sNodeRelistBackoffMin = "k8s-node-relist-backoff-min"
+
+	// K8sNodeRelistBackoffMax is the name of the K8sNodeRelistBackoffMax
+	// option
+	K8sNodeRelistBackoffMax = "k8s-node-relist-backoff-max"
+
 	// Metrics represents the metrics subsystem that Cilium should expose
 	// to prometheus.
 	Metrics = "metrics"
 
+	// MetricsMapSyncInterval is the name of the MetricsMapSyncInterval option
+	MetricsMapSyncInterval = "metrics-map-sync-interval"
+
 	// LoopbackIPv4 is the address to use for service loopback SNAT
 	LoopbackIPv4 = "ipv4-service-loopback-address"
 
@@ -482,6 +592,10 @@ const (
 	// local route not owned by Cilium conflicts with it
 	BlacklistConflictingRoutes = "blacklist-conflicting-routes"
 
+	// RejectConflictingNodeCIDRs refuses to program nodes whose allocation
+	// CIDRs overlap with the allocation CIDRs of another known node
+	RejectConflictingNodeCIDRs = "reject-conflicting-node-cidrs"
+
 	// ForceLocalPolicyEvalAtSource forces a policy decision at the source
 	// endpoint for all local communication
 	ForceLocalPolicyEvalAtSource = "force-local-policy-eval-at-source"
@@ -597,6 +711,10 @@ const (
 	// is considered timed out
 	ProxyConnectTimeout = "proxy-connect-timeout"
 
+	// ProxylibPassthrough is the list of "[<proto>:]<port>" rules for
+	// connections bypassing L7 parsing in the proxylib parsers
+	ProxylibPassthrough = "proxylib-passthrough"
+
 	// ReadCNIConfiguration reads the CNI configuration file and extracts
 	// Cilium relevant information. This can be used to pass per node
 	// configuration to Cilium.
@@ -607,6 +725,11 @@ const (
 	// allows to keep a Kubernetes node NotReady until Cilium is up and
 	// running and able to schedule endpoints.
 	WriteCNIConfigurationWhenReady = "write-cni-conf-when-ready"
+
+	// AgentNotReadyTaintKey is the key of the taint which is removed from
+	// the Kubernetes node once the agent is ready and added back when the
+	// agent shuts down
+	AgentNotReadyTaintKey = "agent-not-ready-taint-key"
 )
 
 const (
@@ -831,6 +954,12 @@ type DaemonConfig struct {
 	// connection attempt to have timed out.
 	ProxyConnectTimeout int
 
+	// ProxylibPassthrough is the list of "[<proto>:]<port>" rules for
+	// connections whose data is passed by the proxylib without
+	// instantiating an L7 parser. L7 policy remains enforced on all other
+	// ports.
+	ProxylibPassthrough []string
+
 	// BPFCompilationDebug specifies whether to compile BPF programs compilation
 	// debugging enabled.
 	BPFCompilationDebug bool
@@ -981,6 +1110,56 @@ type DaemonConfig struct {
 	// synchronization with the kvstore occurs
 	KVstorePeriodicSync time.Duration
 
+	// KVstoreStoreReconcileInterval is the interval in which shared
+	// stores list the kvstore to repair entries missed by their watcher.
+	// A value of 0 disables the reconciliation.
+	KVstoreStoreReconcileInterval time.Duration
+
+	// KVstoreMaxValueSize is the maximum size in bytes of a value written
+	// to or read from the kvstore. Oversized values are rejected on write
+	// and quarantined on read. A value of 0 disables the limit.
+	KVstoreMaxValueSize int
+
+	// KVstoreRateLimitReads, KVstoreRateLimitWrites and
+	// KVstoreRateLimitLocks are the maximum rates per second of kvstore
+	// reads, writes and lock acquisitions performed by the client. A
+	// value of 0 disables the respective limit.
+	KVstoreRateLimitReads  int
+	KVstoreRateLimitWrites int
+	KVstoreRateLimitLocks  int
+
+	// KVstoreRateLimitBackground is the maximum rate per second of all
+	// kvstore operations classified as background operations, e.g. by
+	// garbage collectors. Background operations are limited separately
+	// and do not consume the limits of foreground operations. A value of
+	// 0 subjects background operations to the limits of their class.
+	KVstoreRateLimitBackground int
+
+	// KVstoreLockFairQueuing enables the queuing of local lock attempts
+	// for the same kvstore path in order of arrival so that frequent
+	// lock users, e.g. the allocator garbage collector, cannot starve
+	// other lock users of the same path
+	KVstoreLockFairQueuing bool
+
+	// KVstoreCircuitBreakerThreshold is the number of consecutive failed
+	// kvstore operations after which operations fail immediately or are
+	// served from the local cache. A value of 0 disables the circuit
+	// breaker.
+	KVstoreCircuitBreakerThreshold int
+
+	// KVstoreCircuitBreakerTimeout is the time the kvstore circuit breaker
+	// stays open before operations are attempted again
+	KVstoreCircuitBreakerTimeout time.Duration
+
+	// KVstoreCircuitBreakerMaxAge is the maximum age of cached reads
+	// served while the kvstore circuit breaker is open
+	KVstoreCircuitBreakerMaxAge time.Duration
+
+	// KVStoreTenant is the tenant prefix injected into all kvstore keys
+	// derived from the base key prefix. This allows multiple Cilium
+	// installations to share a kvstore without key collisions.
+	KVStoreTenant string
+
 	// IdentityChangeGracePeriod is the grace period that needs to pass
 	// before an endpoint that has changed its identity will start using
 	// that new identity. During the grace period, the new identity has
@@ -988,6 +1167,66 @@ type DaemonConfig struct {
 	// to whitelist the new upcoming identity of the endpoint.
 	IdentityChangeGracePeriod time.Duration
 
+	// IdentityQuarantinePeriod is the period a released global identity
+	// is held in quarantine before it can be allocated again. This
+	// prevents the reuse of an identity still referenced by policy maps
+	// of nodes which have not yet learned about the release. A value of
+	// 0 disables the quarantine.
+	IdentityQuarantinePeriod time.Duration
+
+	// IdentityCompression enables the compression of large global
+	// identity keys stored in the kvstore. Compressed identities can only
+	// be read by agents supporting compression.
+	IdentityCompression bool
+
+	// NodeDeltaUpdates enables publishing node changes to the kvstore as
+	// deltas against periodic snapshots. Delta updates can only be read
+	// by agents supporting them.
+	NodeDeltaUpdates bool
+
+	// NodePreferLocal enables re-writing the local node to the kvstore
+	// when it is overwritten by another writer, e.g. a stale instance of
+	// the agent
+	NodePreferLocal bool
+
+	// NodeDeleteDelay maps node sources to the delay before a deletion of
+	// a node received from that source is handled. Sources without an
+	// entry use defaults.NodeDeleteDelay.
+	NodeDeleteDelay map[string]string
+
+	// NodeSummaryInterval is the interval in which the datapath summary
+	// of the local node is published to the kvstore, 0 to disable
+	NodeSummaryInterval time.Duration
+
+	// NodeAddressTypes are the Kubernetes node address types considered
+	// when parsing nodes, in order of preference for the node IP
+	NodeAddressTypes []string
+
+	// NodeAddressPreferredCIDRs are CIDRs whose addresses take precedence
+	// over all other addresses of a node when selecting the node IP, e.g.
+	// the network used for tunneling on multi-homed nodes
+	NodeAddressPreferredCIDRs []*net.IPNet
+
+	// NodeAddressExcludedCIDRs are CIDRs whose addresses are ignored when
+	// parsing nodes
+	NodeAddressExcludedCIDRs []*net.IPNet
+
+	// ResolveNodeAddressDNS enables the resolution of the InternalDNS and
+	// ExternalDNS addresses of nodes into node IPs
+	ResolveNodeAddressDNS bool
+
+	// NodeRegistrationBackend is the backend through which the local node
+	// is registered and the nodes of the cluster are discovered
+	NodeRegistrationBackend string
+
+	// NodeRegistrationDualWrite additionally publishes the local node to
+	// the registration backend not selected by NodeRegistrationBackend.
+	// Nodes are only discovered through NodeRegistrationBackend. This
+	// allows to migrate between backends by first enabling dual writes
+	// on all nodes, then switching the backend of all nodes and finally
+	// disabling dual writes.
+	NodeRegistrationDualWrite bool
+
 	// PolicyQueueSize is the size of the queues for the policy repository.
 	// A larger queue means that more events related to policy can be buffered.
 	PolicyQueueSize int
@@ -1015,9 +1254,29 @@ type DaemonConfig struct {
 	// clusters.
 	K8sEventHandover bool
 
+	// K8sRewriteLegacyNodeAnnotations enables rewriting the annotations
+	// of the local Kubernetes node written by older versions of Cilium to
+	// the current representation
+	K8sRewriteLegacyNodeAnnotations bool
+
+	// K8sNodeResyncPeriod is the period in which all Kubernetes nodes are
+	// resynced from the local cache of the node watcher. A value of 0
+	// disables the periodic resync.
+	K8sNodeResyncPeriod time.Duration
+
+	// K8sNodeRelistBackoffMin and K8sNodeRelistBackoffMax bound the
+	// exponential backoff between attempts to re-list a single
+	// Kubernetes node from the apiserver
+	K8sNodeRelistBackoffMin time.Duration
+	K8sNodeRelistBackoffMax time.Duration
+
 	// MetricsConfig is the configuration set in metrics
 	MetricsConfig metrics.Configuration
 
+	// MetricsMapSyncInterval is the interval in which the BPF metrics map
+	// is synced with the prometheus metrics
+	MetricsMapSyncInterval time.Duration
+
 	// LoopbackIPv4 is the address to use for service loopback SNAT
 	LoopbackIPv4 string
 
@@ -1029,6 +1288,10 @@ type DaemonConfig struct {
 	// local route not owned by Cilium conflicts with it
 	BlacklistConflictingRoutes bool
 
+	// RejectConflictingNodeCIDRs refuses to program nodes whose allocation
+	// CIDRs overlap with the allocation CIDRs of another known node
+	RejectConflictingNodeCIDRs bool
+
 	// ForceLocalPolicyEvalAtSource forces a policy decision at the source
 	// endpoint for all local communication
 	ForceLocalPolicyEvalAtSource bool
@@ -1057,6 +1320,11 @@ type DaemonConfig struct {
 	// running and able to schedule endpoints.
 	WriteCNIConfigurationWhenReady string
 
+	// AgentNotReadyTaintKey is the key of the taint which is removed from
+	// the Kubernetes node once the agent is ready and added back when the
+	// agent shuts down. Empty disables the taint management.
+	AgentNotReadyTaintKey string
+
 	// EnableNodePort enables k8s NodePort service implementation in BPF
 	EnableNodePort bool
 
@@ -1094,29 +1362,37 @@ type DaemonConfig struct {
 var (
 	// Config represents the daemon configuration
 	Config = &DaemonConfig{
-		Opts:                         NewIntOptions(&DaemonOptionLibrary),
-		Monitor:                      &models.MonitorStatus{Cpus: int64(runtime.NumCPU()), Npages: 64, Pagesize: int64(os.Getpagesize()), Lost: 0, Unknown: 0},
-		IPv6ClusterAllocCIDR:         defaults.IPv6ClusterAllocCIDR,
-		IPv6ClusterAllocCIDRBase:     defaults.IPv6ClusterAllocCIDRBase,
-		EnableHostIPRestore:          defaults.EnableHostIPRestore,
-		EnableHealthChecking:         defaults.EnableHealthChecking,
-		EnableIPv4:                   defaults.EnableIPv4,
-		EnableIPv6:                   defaults.EnableIPv6,
-		ToFQDNsMaxIPsPerHost:         defaults.ToFQDNsMaxIPsPerHost,
-		KVstorePeriodicSync:          defaults.KVstorePeriodicSync,
-		IdentityChangeGracePeriod:    defaults.IdentityChangeGracePeriod,
-		ContainerRuntimeEndpoint:     make(map[string]string),
-		FixedIdentityMapping:         make(map[string]string),
-		KVStoreOpt:                   make(map[string]string),
-		LogOpt:                       make(map[string]string),
-		SelectiveRegeneration:        defaults.SelectiveRegeneration,
-		LoopbackIPv4:                 defaults.LoopbackIPv4,
-		EndpointInterfaceNamePrefix:  defaults.EndpointInterfaceNamePrefix,
-		BlacklistConflictingRoutes:   defaults.BlacklistConflictingRoutes,
-		ForceLocalPolicyEvalAtSource: defaults.ForceLocalPolicyEvalAtSource,
-		EnableEndpointRoutes:         defaults.EnableEndpointRoutes,
-		AnnotateK8sNode:              defaults.AnnotateK8sNode,
-		AutoCreateCiliumNodeResource: defaults.AutoCreateCiliumNodeResource,
+		Opts:                          NewIntOptions(&DaemonOptionLibrary),
+		Monitor:                       &models.MonitorStatus{Cpus: int64(runtime.NumCPU()), Npages: 64, Pagesize: int64(os.Getpagesize()), Lost: 0, Unknown: 0},
+		IPv6ClusterAllocCIDR:          defaults.IPv6ClusterAllocCIDR,
+		IPv6ClusterAllocCIDRBase:      defaults.IPv6ClusterAllocCIDRBase,
+		EnableHostIPRestore:           defaults.EnableHostIPRestore,
+		EnableHealthChecking:          defaults.EnableHealthChecking,
+		EnableIPv4:                    defaults.EnableIPv4,
+		EnableIPv6:                    defaults.EnableIPv6,
+		ToFQDNsMaxIPsPerHost:          defaults.ToFQDNsMaxIPsPerHost,
+		KVstorePeriodicSync:           defaults.KVstorePeriodicSync,
+		KVstoreStoreReconcileInterval: defaults.KVstoreStoreReconcileInterval,
+		KVstoreMaxValueSize:           defaults.KVstoreMaxValueSize,
+		KVstoreCircuitBreakerTimeout:  defaults.KVstoreCircuitBreakerTimeout,
+		KVstoreCircuitBreakerMaxAge:   defaults.KVstoreCircuitBreakerMaxAge,
+		IdentityChangeGracePeriod:     defaults.IdentityChangeGracePeriod,
+		MetricsMapSyncInterval:        defaults.MetricsMapSyncInterval,
+		ContainerRuntimeEndpoint:      make(map[string]string),
+		FixedIdentityMapping:          make(map[string]string),
+		KVStoreOpt:                    make(map[string]string),
+		NodeDeleteDelay:               make(map[string]string),
+		NodeAddressTypes:              defaults.NodeAddressTypes,
+		NodeRegistrationBackend:       NodeRegistrationKVStore,
+		LogOpt:                        make(map[string]string),
+		SelectiveRegeneration:         defaults.SelectiveRegeneration,
+		LoopbackIPv4:                  defaults.LoopbackIPv4,
+		EndpointInterfaceNamePrefix:   defaults.EndpointInterfaceNamePrefix,
+		BlacklistConflictingRoutes:    defaults.BlacklistConflictingRoutes,
+		ForceLocalPolicyEvalAtSource:  defaults.ForceLocalPolicyEvalAtSource,
+		EnableEndpointRoutes:          defaults.EnableEndpointRoutes,
+		AnnotateK8sNode:               defaults.AnnotateK8sNode,
+		AutoCreateCiliumNodeResource:  defaults.AutoCreateCiliumNodeResource,
 	}
 )
 
@@ -1197,6 +1473,34 @@ func (c *DaemonConfig) TracingEnabled() bool {
 	return c.Opts.IsEnabled(PolicyTracing)
 }
 
+// GetNodeDeleteDelay returns the delay before a deletion of a node received
+// from source is handled
+func (c *DaemonConfig) GetNodeDeleteDelay(source string) time.Duration {
+	if val, ok := c.NodeDeleteDelay[source]; ok {
+		if delay, err := time.ParseDuration(val); err == nil {
+			return delay
+		}
+	}
+	return defaults.NodeDeleteDelay
+}
+
+// NodeDeleteDelayValidator validates a "<source>=<duration>" entry of the
+// NodeDeleteDelay option
+func NodeDeleteDelayValidator(val string) (string, error) {
+	vals := strings.SplitN(val, "=", 2)
+	if len(vals) != 2 || vals[0] == "" {
+		return "", fmt.Errorf(`invalid node delete delay: expecting "<source>=<duration>" got %q`, val)
+	}
+	delay, err := time.ParseDuration(vals[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid node delete delay %q: %s", val, err)
+	}
+	if delay < 0 {
+		return "", fmt.Errorf("invalid node delete delay %q: must not be negative", val)
+	}
+	return val, nil
+}
+
 // IsFlannelMasterDeviceSet returns if the flannel master device is set.
 func (c *DaemonConfig) IsFlannelMasterDeviceSet() bool {
 	return len(c.FlannelMasterDevice) != 0
@@ -1232,6 +1536,33 @@ func (c *DaemonConfig) Validate() error {
 		return fmt.Errorf("MTU '%d' cannot be negative", c.MTU)
 	}
 
+	if c.MetricsMapSyncInterval <= 0 {
+		return fmt.Errorf("invalid value of option --%s: %s must be positive", MetricsMapSyncInterval, c.MetricsMapSyncInterval)
+	}
+
+	for source, delay := range c.NodeDeleteDelay {
+		if _, err := NodeDeleteDelayValidator(source + "=" + delay); err != nil {
+			return fmt.Errorf("invalid value of option --%s: %s", NodeDeleteDelay, err)
+		}
+	}
+
+	switch c.NodeRegistrationBackend {
+	case NodeRegistrationKVStore, NodeRegistrationCRD:
+	default:
+		return fmt.Errorf("invalid value '%s' of option --%s, valid values = {%s, %s}",
+			c.NodeRegistrationBackend, NodeRegistrationBackend, NodeRegistrationKVStore, NodeRegistrationCRD)
+	}
+
+	if len(c.NodeAddressTypes) == 0 {
+		return fmt.Errorf("option --%s requires at least one address type", NodeAddressTypes)
+	}
+	for _, t := range c.NodeAddressTypes {
+		if _, ok := validNodeAddressTypes[t]; !ok {
+			return fmt.Errorf("invalid node address type '%s' of option --%s, valid types = {%s}",
+				t, NodeAddressTypes, strings.Join(defaults.NodeAddressTypes, ", "))
+		}
+	}
+
 	switch c.Tunnel {
 	case TunnelVXLAN, TunnelGeneve, "":
 	case TunnelDisabled:
@@ -1283,6 +1614,10 @@ func (c *DaemonConfig) Validate() error {
 			int64(defaults.KVstoreLeaseMaxTTL.Seconds()))
 	}
 
+	if err := ValidateKVStoreTenant(c.KVStoreTenant); err != nil {
+		return err
+	}
+
 	if c.WriteCNIConfigurationWhenReady != "" && c.ReadCNIConfiguration == "" {
 		return fmt.Errorf("%s must be set when using %s", ReadCNIConfiguration, WriteCNIConfigurationWhenReady)
 	}
@@ -1290,6 +1625,15 @@ func (c *DaemonConfig) Validate() error {
 	return nil
 }
 
+// ValidateKVStoreTenant returns an error if tenant cannot be used as the
+// tenant prefix of kvstore keys
+func ValidateKVStoreTenant(tenant string) error {
+	if strings.Contains(tenant, "/") {
+		return fmt.Errorf("invalid kvstore tenant '%s': must not contain '/'", tenant)
+	}
+	return nil
+}
+
 // ReadDirConfig reads the given directory and returns a map that maps the
 // filename to the contents of that file.
 func ReadDirConfig(dirName string) (map[string]interface{}, error) {
@@ -1378,6 +1722,25 @@ func (c *DaemonConfig) parseExcludedLocalAddresses(s []string) error {
 	return nil
 }
 
+// validNodeAddressTypes are the node address types which hold an IP
+var validNodeAddressTypes = map[string]struct{}{
+	"InternalIP": {},
+	"ExternalIP": {},
+}
+
+// parseCIDRs parses the CIDRs s of the option with the given name
+func parseCIDRs(name string, s []string) ([]*net.IPNet, error) {
+	var cidrs []*net.IPNet
+	for _, cidrString := range s {
+		_, ipnet, err := net.ParseCIDR(cidrString)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse CIDR %s of option --%s: %s", cidrString, name, err)
+		}
+		cidrs = append(cidrs, ipnet)
+	}
+	return cidrs, nil
+}
+
 // Populate sets all options with the values from viper
 func (c *DaemonConfig) Populate() {
 	var err error
@@ -1429,6 +1792,15 @@ func (c *DaemonConfig) Populate() {
 	c.HTTPRetryTimeout = viper.GetInt(HTTPRetryTimeout)
 	c.IPv4ClusterCIDRMaskSize = viper.GetInt(IPv4ClusterCIDRMaskSize)
 	c.IdentityChangeGracePeriod = viper.GetDuration(IdentityChangeGracePeriod)
+	c.IdentityQuarantinePeriod = viper.GetDuration(IdentityQuarantinePeriod)
+	c.IdentityCompression = viper.GetBool(IdentityCompression)
+	c.NodeDeltaUpdates = viper.GetBool(NodeDeltaUpdates)
+	c.NodePreferLocal = viper.GetBool(NodePreferLocal)
+	c.NodeSummaryInterval = viper.GetDuration(NodeSummaryInterval)
+	c.MetricsMapSyncInterval = viper.GetDuration(MetricsMapSyncInterval)
+	c.ResolveNodeAddressDNS = viper.GetBool(ResolveNodeAddressDNS)
+	c.NodeRegistrationBackend = viper.GetString(NodeRegistrationBackend)
+	c.NodeRegistrationDualWrite = viper.GetBool(NodeRegistrationDualWrite)
 	c.IPAM = viper.GetString(IPAM)
 	c.IPv4Range = viper.GetString(IPv4Range)
 	c.IPv4NodeAddr = viper.GetString(IPv4NodeAddr)
@@ -1443,6 +1815,10 @@ func (c *DaemonConfig) Populate() {
 	c.K8sRequireIPv6PodCIDR = viper.GetBool(K8sRequireIPv6PodCIDRName)
 	c.K8sForceJSONPatch = viper.GetBool(K8sForceJSONPatch)
 	c.K8sEventHandover = viper.GetBool(K8sEventHandover)
+	c.K8sRewriteLegacyNodeAnnotations = viper.GetBool(K8sRewriteLegacyNodeAnnotations)
+	c.K8sNodeResyncPeriod = viper.GetDuration(K8sNodeResyncPeriod)
+	c.K8sNodeRelistBackoffMin = viper.GetDuration(K8sNodeRelistBackoffMin)
+	c.K8sNodeRelistBackoffMax = viper.GetDuration(K8sNodeRelistBackoffMax)
 	c.K8sWatcherQueueSize = uint(viper.GetInt(K8sWatcherQueueSize))
 	c.K8sWatcherEndpointSelector = viper.GetString(K8sWatcherEndpointSelector)
 	c.KeepTemplates = viper.GetBool(KeepBPFTemplates)
@@ -1451,6 +1827,17 @@ func (c *DaemonConfig) Populate() {
 	c.KVstoreLeaseTTL = viper.GetDuration(KVstoreLeaseTTL)
 	c.KVstoreKeepAliveInterval = c.KVstoreLeaseTTL / defaults.KVstoreKeepAliveIntervalFactor
 	c.KVstorePeriodicSync = viper.GetDuration(KVstorePeriodicSync)
+	c.KVstoreStoreReconcileInterval = viper.GetDuration(KVstoreStoreReconcileInterval)
+	c.KVstoreMaxValueSize = viper.GetInt(KVstoreMaxValueSize)
+	c.KVstoreRateLimitReads = viper.GetInt(KVstoreRateLimitReads)
+	c.KVstoreRateLimitWrites = viper.GetInt(KVstoreRateLimitWrites)
+	c.KVstoreRateLimitLocks = viper.GetInt(KVstoreRateLimitLocks)
+	c.KVstoreRateLimitBackground = viper.GetInt(KVstoreRateLimitBackground)
+	c.KVstoreLockFairQueuing = viper.GetBool(KVstoreLockFairQueuing)
+	c.KVstoreCircuitBreakerThreshold = viper.GetInt(KVstoreCircuitBreakerThreshold)
+	c.KVstoreCircuitBreakerTimeout = viper.GetDuration(KVstoreCircuitBreakerTimeout)
+	c.KVstoreCircuitBreakerMaxAge = viper.GetDuration(KVstoreCircuitBreakerMaxAge)
+	c.KVStoreTenant = viper.GetString(KVStoreTenant)
 	c.LabelPrefixFile = viper.GetString(LabelPrefixFile)
 	c.Labels = viper.GetStringSlice(Labels)
 	c.LBInterface = viper.GetString(LB)
@@ -1475,7 +1862,9 @@ func (c *DaemonConfig) Populate() {
 	c.PrependIptablesChains = viper.GetBool(PrependIptablesChainsName)
 	c.PrometheusServeAddr = getPrometheusServerAddr()
 	c.ProxyConnectTimeout = viper.GetInt(ProxyConnectTimeout)
+	c.ProxylibPassthrough = viper.GetStringSlice(ProxylibPassthrough)
 	c.BlacklistConflictingRoutes = viper.GetBool(BlacklistConflictingRoutes)
+	c.RejectConflictingNodeCIDRs = viper.GetBool(RejectConflictingNodeCIDRs)
 	c.ReadCNIConfiguration = viper.GetString(ReadCNIConfiguration)
 	c.RestoreState = viper.GetBool(Restore)
 	c.RunDir = viper.GetString(StateDir)
@@ -1488,6 +1877,7 @@ func (c *DaemonConfig) Populate() {
 	c.Version = viper.GetString(Version)
 	c.Workloads = viper.GetStringSlice(ContainerRuntime)
 	c.WriteCNIConfigurationWhenReady = viper.GetString(WriteCNIConfigurationWhenReady)
+	c.AgentNotReadyTaintKey = viper.GetString(AgentNotReadyTaintKey)
 
 	if nativeCIDR := viper.GetString(IPv4NativeRoutingCIDR); nativeCIDR != "" {
 		c.ipv4NativeRoutingCIDR = cidr.MustParseCIDR(nativeCIDR)
@@ -1567,6 +1957,22 @@ func (c *DaemonConfig) Populate() {
 		c.LogOpt = m
 	}
 
+	if m := viper.GetStringMapString(NodeDeleteDelay); len(m) != 0 {
+		c.NodeDeleteDelay = m
+	}
+
+	if types := viper.GetStringSlice(NodeAddressTypes); len(types) != 0 {
+		c.NodeAddressTypes = types
+	}
+
+	if c.NodeAddressPreferredCIDRs, err = parseCIDRs(NodeAddressPreferredCIDRs, viper.GetStringSlice(NodeAddressPreferredCIDRs)); err != nil {
+		log.WithError(err).Fatal("Unable to parse preferred node address CIDRs")
+	}
+
+	if c.NodeAddressExcludedCIDRs, err = parseCIDRs(NodeAddressExcludedCIDRs, viper.GetStringSlice(NodeAddressExcludedCIDRs)); err != nil {
+		log.WithError(err).Fatal("Unable to parse excluded node address CIDRs")
+	}
+
 	if val := viper.GetInt(ConntrackGarbageCollectorIntervalDeprecated); val != 0 {
 		c.ConntrackGCInterval = time.Duration(val) * time.Second
 	} else {
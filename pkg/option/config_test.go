@@ -23,6 +23,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/cilium/cilium/pkg/defaults"
 
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -112,6 +115,12 @@ func TestGetEnvName(t *testing.T) {
 	}
 }
 
+func (s *OptionSuite) TestValidateKVStoreTenant(c *C) {
+	c.Assert(ValidateKVStoreTenant(""), IsNil)
+	c.Assert(ValidateKVStoreTenant("tenant-a"), IsNil)
+	c.Assert(ValidateKVStoreTenant("tenant/a"), Not(IsNil))
+}
+
 func (s *OptionSuite) TestReadDirConfig(c *C) {
 	var dirName string
 	type args struct {
@@ -250,3 +259,18 @@ func (s *OptionSuite) TestLocalAddressExclusion(c *C) {
 	c.Assert(d.IsExcludedLocalAddress(net.ParseIP("f00d::1")), Equals, true)
 	c.Assert(d.IsExcludedLocalAddress(net.ParseIP("f00d::2")), Equals, false)
 }
+
+func (s *OptionSuite) TestNodeDeleteDelay(c *C) {
+	for _, val := range []string{"kvstore=30s", "k8s=0s"} {
+		_, err := NodeDeleteDelayValidator(val)
+		c.Assert(err, IsNil)
+	}
+	for _, val := range []string{"kvstore", "=30s", "kvstore=foo", "kvstore=-1s"} {
+		_, err := NodeDeleteDelayValidator(val)
+		c.Assert(err, Not(IsNil))
+	}
+
+	d := &DaemonConfig{NodeDeleteDelay: map[string]string{"kvstore": "1m"}}
+	c.Assert(d.GetNodeDeleteDelay("kvstore"), Equals, time.Minute)
+	c.Assert(d.GetNodeDeleteDelay("k8s"), Equals, defaults.NodeDeleteDelay)
+}
@@ -32,6 +32,10 @@ const (
 
 	// TypeSample is a packet sample
 	TypeSample FlowType = "Sample"
+
+	// TypeEvent is a synthetic event emitted by an L7 parser which is not
+	// the request or response of a flow
+	TypeEvent FlowType = "Event"
 )
 
 // FlowVerdict is the verdict passed on the flow
@@ -299,6 +303,16 @@ type LogRecordDNS struct {
 	AnswerTypes []uint16 `json:"AnswerTypes,omitempty"`
 }
 
+// FieldVerdictLatency is the key of the generic L7 log record field holding
+// the time in microseconds between the first byte of the request being
+// received by the proxylib parser and its verdict being delivered
+const FieldVerdictLatency = "verdict_latency_us"
+
+// FieldEvent is the key of the generic L7 log record field holding the name
+// of a synthetic event emitted by a proxylib parser. Log records carrying it
+// are of type TypeEvent.
+const FieldEvent = "event"
+
 // LogRecordL7 contains the generic L7 portion of a log record
 type LogRecordL7 struct {
 	// Proto is the name of the protocol this record represents
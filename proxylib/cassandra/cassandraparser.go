@@ -185,13 +185,13 @@ func (p *CassandraParser) OnData(reply, endStream bool, dataArray [][]byte) (OpT
 	if len(data) < cassHdrLen {
 		// Partial header received, ask for more
 		needs := cassHdrLen - len(data)
-		log.Debugf("Did not receive full header, need %d more bytes", needs)
+		p.connection.Tracef("Did not receive full header, need %d more bytes", needs)
 		return MORE, needs
 	}
 
 	// full header available, read full request length
 	requestLen := binary.BigEndian.Uint32(data[5:9])
-	log.Debugf("Request length = %d", requestLen)
+	p.connection.Tracef("Request length = %d", requestLen)
 	if requestLen > cassMaxLen {
 		log.Errorf("Request length of %d is greater than 256 MB", requestLen)
 		return ERROR, int(ERROR_INVALID_FRAME_LENGTH)
@@ -201,20 +201,20 @@ func (p *CassandraParser) OnData(reply, endStream bool, dataArray [][]byte) (OpT
 	if dataMissing > 0 {
 		// full header received, but only partial request
 
-		log.Debugf("Hdr received, but need %d more bytes of request", dataMissing)
+		p.connection.Tracef("Hdr received, but need %d more bytes of request", dataMissing)
 		return MORE, dataMissing
 	}
 
 	// we parse replies, but only to look for prepared-query-id responses
 	if reply {
 		if len(data) == 0 {
-			log.Debugf("ignoring zero length reply call to onData")
+			p.connection.Tracef("ignoring zero length reply call to onData")
 			return NOP, 0
 
 		}
 		cassandraParseReply(p, data[0:(cassHdrLen+requestLen)])
 
-		log.Debugf("reply, passing %d bytes", (cassHdrLen + requestLen))
+		p.connection.Tracef("reply, passing %d bytes", (cassHdrLen + requestLen))
 		return PASS, (cassHdrLen + int(requestLen))
 	}
 
@@ -224,7 +224,7 @@ func (p *CassandraParser) OnData(reply, endStream bool, dataArray [][]byte) (OpT
 		return ERROR, int(err)
 	}
 
-	log.Debugf("Request paths = %s", paths)
+	p.connection.Tracef("Request paths = %s", paths)
 
 	matches := true
 	access_log_entry_type := cilium.EntryType_Request
@@ -485,7 +485,7 @@ func parseQuery(p *CassandraParser, query string) (string, string) {
 		table = strings.ToLower(fields[1])
 	case "use":
 		p.keyspace = strings.Trim(fields[1], "\"\\'")
-		log.Debugf("Saving keyspace '%s'", p.keyspace)
+		p.connection.Tracef("Saving keyspace '%s'", p.keyspace)
 		table = p.keyspace
 	case "alter", "create", "drop", "truncate", "list":
 
@@ -577,7 +577,7 @@ func cassandraParseRequest(p *CassandraParser, data []byte) (OpError, []string)
 			// stash 'path' for this prepared query based on stream id
 			// rewrite 'opcode' portion of the path to be 'execute' rather than 'prepare'
 			streamID := binary.BigEndian.Uint16(data[2:4])
-			log.Debugf("Prepare query path '%s' with stream-id %d", path, streamID)
+			p.connection.Tracef("Prepare query path '%s' with stream-id %d", path, streamID)
 			p.preparedQueryPathByStreamID[streamID] = strings.Replace(path, "prepare", "execute", 1)
 		}
 		return 0, []string{path}
@@ -586,7 +586,7 @@ func cassandraParseRequest(p *CassandraParser, data []byte) (OpError, []string)
 
 		numQueries := binary.BigEndian.Uint16(data[10:12])
 		paths := make([]string, numQueries)
-		log.Debugf("batch query count = %d", numQueries)
+		p.connection.Tracef("batch query count = %d", numQueries)
 		offset := 12
 		for i := 0; i < int(numQueries); i++ {
 			kind := data[offset]
@@ -610,7 +610,7 @@ func cassandraParseRequest(p *CassandraParser, data []byte) (OpError, []string)
 
 				idLen := int(binary.BigEndian.Uint16(data[offset+1 : offset+3]))
 				preparedID := string(data[offset+3 : (offset + 3 + idLen)])
-				log.Debugf("Batch entry with prepared-id = '%s'", preparedID)
+				p.connection.Tracef("Batch entry with prepared-id = '%s'", preparedID)
 				path := p.preparedQueryPathByPreparedID[preparedID]
 				if len(path) > 0 {
 					paths[i] = path
@@ -636,7 +636,7 @@ func cassandraParseRequest(p *CassandraParser, data []byte) (OpError, []string)
 		// cached query path for policy evaluation.
 		idLen := binary.BigEndian.Uint16(data[9:11])
 		preparedID := string(data[11:(11 + idLen)])
-		log.Debugf("Execute with prepared-id = '%s'", preparedID)
+		p.connection.Tracef("Execute with prepared-id = '%s'", preparedID)
 		path := p.preparedQueryPathByPreparedID[preparedID]
 
 		if len(path) == 0 {
@@ -688,23 +688,23 @@ func cassandraParseReply(p *CassandraParser, data []byte) {
 	}
 
 	streamID := binary.BigEndian.Uint16(data[2:4])
-	log.Debugf("Reply with opcode %d and stream-id %d", data[4], streamID)
+	p.connection.Tracef("Reply with opcode %d and stream-id %d", data[4], streamID)
 	// if this is an opcode == RESULT message of type 'prepared', associate the prepared
 	// statement id with the full query string that was included in the
 	// associated PREPARE request.  The stream-id in this reply allows us to
 	// find the associated prepare query string.
 	if data[4] == 0x08 {
 		resultKind := binary.BigEndian.Uint32(data[9:13])
-		log.Debugf("resultKind = %d", resultKind)
+		p.connection.Tracef("resultKind = %d", resultKind)
 		if resultKind == 0x0004 {
 			idLen := binary.BigEndian.Uint16(data[13:15])
 			preparedID := string(data[15 : 15+idLen])
-			log.Debugf("Result with prepared-id = '%s' for stream-id %d", preparedID, streamID)
+			p.connection.Tracef("Result with prepared-id = '%s' for stream-id %d", preparedID, streamID)
 			path := p.preparedQueryPathByStreamID[streamID]
 			if len(path) > 0 {
 				// found cached query path to associate with this preparedID
 				p.preparedQueryPathByPreparedID[preparedID] = path
-				log.Debugf("Associating query path '%s' with prepared-id %s as part of stream-id %d", path, preparedID, streamID)
+				p.connection.Tracef("Associating query path '%s' with prepared-id %s as part of stream-id %d", path, preparedID, streamID)
 			} else {
 				log.Warnf("Unable to find prepared query path associated with stream-id %d", streamID)
 			}
@@ -100,6 +100,12 @@ extern FilterResult OnNewConnection(GoUint64 p0, GoString p1, GoUint64 p2, GoUin
 
 extern FilterResult OnData(GoUint64 p0, GoUint8 p1, GoUint8 p2, GoSlice* p3, GoSlice* p4);
 
+// OnL7Bypass returns true if the connection needs no L7 processing, i.e., all
+// data of the connection is passed. The caller may then stop buffering the
+// data of the connection and pass it without calling OnData.
+
+extern GoUint8 OnL7Bypass(GoUint64 p0);
+
 // Make this more general connection event callback
 
 extern void Close(GoUint64 p0);
@@ -67,11 +67,11 @@ func (p *Parser) OnData(reply, endStream bool, dataBuffers [][]byte) (proxylib.O
 
 	//TODO don't copy data from buffers
 	data := bytes.Join(dataBuffers, []byte{})
-	log.Debugf("Data length: %d", len(data))
+	p.connection.Tracef("Data length: %d", len(data))
 
 	if headerSize > len(data) {
 		headerMissing := headerSize - len(data)
-		log.Debugf("Did not receive needed header data, need %d more bytes", headerMissing)
+		p.connection.Tracef("Did not receive needed header data, need %d more bytes", headerMissing)
 		return proxylib.MORE, headerMissing
 	}
 
@@ -84,7 +84,7 @@ func (p *Parser) OnData(reply, endStream bool, dataBuffers [][]byte) (proxylib.O
 		neededData := headerSize + int(keyLength) + int(extrasLength)
 		if neededData > len(data) {
 			keyMissing := neededData - len(data)
-			log.Debugf("Did not receive enough bytes for key, need %d more bytes", keyMissing)
+			p.connection.Tracef("Did not receive enough bytes for key, need %d more bytes", keyMissing)
 			return proxylib.MORE, keyMissing
 		}
 	}
@@ -106,7 +106,7 @@ func (p *Parser) OnData(reply, endStream bool, dataBuffers [][]byte) (proxylib.O
 
 	// we don't filter reply traffic
 	if reply {
-		log.Debugf("reply, passing %d bytes", len(data))
+		p.connection.Tracef("reply, passing %d bytes", len(data))
 		p.connection.Log(cilium.EntryType_Response, logEntry)
 		p.replyCount++
 		return proxylib.PASS, int(bodyLength + headerSize)
@@ -82,11 +82,11 @@ func (p *Parser) OnData(reply, endStream bool, dataBuffers [][]byte) (proxylib.O
 
 	// TODO: don't copy data to new slices
 	data := (bytes.Join(dataBuffers, []byte{}))
-	log.Debugf("Data length: %d", len(data))
+	p.connection.Tracef("Data length: %d", len(data))
 
 	linefeed := bytes.Index(data, []byte("\r\n"))
 	if linefeed < 0 {
-		log.Debugf("Did not receive full first line")
+		p.connection.Tracef("Did not receive full first line")
 		if len(data) > 0 && data[len(data)-1] == '\r' {
 			return proxylib.MORE, 1
 		}
@@ -198,7 +198,7 @@ func (p *Parser) OnData(reply, endStream bool, dataBuffers [][]byte) (proxylib.O
 	}
 
 	//reply
-	log.Debugf("reply, parsing to figure out if we have it all")
+	p.connection.Tracef("reply, parsing to figure out if we have it all")
 
 	intent := p.replyQueue[0]
 
@@ -20,12 +20,15 @@ package main
 import "C"
 
 import (
+	"strconv"
+
 	"github.com/cilium/cilium/proxylib/accesslog"
 	_ "github.com/cilium/cilium/proxylib/cassandra"
 	_ "github.com/cilium/cilium/proxylib/memcached"
 	"github.com/cilium/cilium/proxylib/npds"
 	. "github.com/cilium/cilium/proxylib/proxylib"
 	_ "github.com/cilium/cilium/proxylib/r2d2"
+	_ "github.com/cilium/cilium/proxylib/redis"
 	_ "github.com/cilium/cilium/proxylib/testparsers"
 
 	"github.com/cilium/cilium/pkg/lock"
@@ -107,6 +110,18 @@ func OnData(connectionId uint64, reply, endStream bool, data *[][]byte, filterOp
 	return C.FilterResult(connection.OnData(reply, endStream, data, filterOps))
 }
 
+// OnL7Bypass returns true if the connection needs no L7 processing, i.e., all
+// data of the connection is passed. The caller may then stop buffering the
+// data of the connection and pass it without calling OnData.
+//export OnL7Bypass
+func OnL7Bypass(connectionId uint64) bool {
+	mutex.RLock()
+	connection, ok := connections[connectionId]
+	mutex.RUnlock()
+
+	return ok && connection.L7Bypass
+}
+
 // Make this more general connection event callback
 //export Close
 func Close(connectionId uint64) {
@@ -120,9 +135,16 @@ func Close(connectionId uint64) {
 // Returns a library instance ID that must be passed to all other API calls.
 // Calls with the same parameters will return the same instance.
 // Zero return value indicates an error.
+// The "default-policy" parameter ("deny", "allow" or "policy:<name>") selects
+// the policy enforced on all endpoints of the instance for which no policy
+// has been received, it cannot be scoped to a namespace.
 //export OpenModule
 func OpenModule(params [][2]string, debug bool) uint64 {
-	var accessLogPath, xdsPath, nodeID string
+	var accessLogPath, xdsPath, nodeID, traceSocketPath string
+	var passthrough Passthrough
+	var l7Bypass bool
+	var policyMemoryBudget int
+	var defaultPolicy DefaultPolicy
 	for i := range params {
 		key := params[i][0]
 		value := strcpy(params[i][1])
@@ -134,6 +156,32 @@ func OpenModule(params [][2]string, debug bool) uint64 {
 			xdsPath = value
 		case "node-id":
 			nodeID = value
+		case "trace-socket":
+			traceSocketPath = value
+		case "passthrough":
+			var err error
+			if passthrough, err = ParsePassthrough(value); err != nil {
+				log.WithError(err).Warning("Invalid passthrough rules")
+				return 0
+			}
+		case "l7-bypass":
+			var err error
+			if l7Bypass, err = strconv.ParseBool(value); err != nil {
+				log.WithError(err).Warning("Invalid l7-bypass value")
+				return 0
+			}
+		case "policy-memory-budget":
+			var err error
+			if policyMemoryBudget, err = strconv.Atoi(value); err != nil || policyMemoryBudget < 0 {
+				log.WithError(err).Warning("Invalid policy-memory-budget value")
+				return 0
+			}
+		case "default-policy":
+			var err error
+			if defaultPolicy, err = ParseDefaultPolicy(value); err != nil {
+				log.WithError(err).Warning("Invalid default-policy value")
+				return 0
+			}
 		default:
 			return 0
 		}
@@ -146,7 +194,13 @@ func OpenModule(params [][2]string, debug bool) uint64 {
 	}
 	// Copy strings from C-memory to Go-memory so that the string remains valid
 	// also after this function returns
-	return OpenInstance(nodeID, xdsPath, npds.NewClient, accessLogPath, accesslog.NewClient)
+	id := OpenInstanceWithPassthrough(nodeID, xdsPath, npds.NewClient, accessLogPath, accesslog.NewClient, passthrough, l7Bypass, policyMemoryBudget, defaultPolicy)
+	if id != 0 && traceSocketPath != "" {
+		if err := FindInstance(id).ServeTraceSocket(traceSocketPath); err != nil {
+			log.WithError(err).Warning("Unable to serve trace socket")
+		}
+	}
+	return id
 }
 
 //export CloseModule
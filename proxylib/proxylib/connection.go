@@ -20,6 +20,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/cilium/cilium/pkg/proxy/accesslog"
+
 	"github.com/cilium/proxy/go/cilium/api"
 	log "github.com/sirupsen/logrus"
 )
@@ -59,8 +61,30 @@ type Connection struct {
 	Parser     Parser    // Parser instance used on this connection
 	OrigBuf    InjectBuf // Buffer for injected frames in original direction
 	ReplyBuf   InjectBuf // Buffer for injected frames in reply direction
+	L7Bypass   bool      // All data is passed without parsing, the caller may stop calling OnData
+
+	policyCache        map[interface{}]bool // Policy decisions cached by MatchesCached()
+	policyCacheVersion uint64               // Policy version the cached decisions are valid for
+
+	requestStart [2]time.Time // Arrival of the first byte of the pending request, per direction
+	reply        bool         // Direction of the data currently being parsed
+
+	tracing      bool   // Connection is selected by the trace filters
+	traceVersion uint64 // Trace filter version 'tracing' is valid for
 }
 
+// direction returns the index into per-direction connection state
+func direction(reply bool) int {
+	if reply {
+		return 1
+	}
+	return 0
+}
+
+// maxPolicyCacheEntries limits the number of policy decisions cached per
+// connection. The cache is cleared when the limit is reached.
+const maxPolicyCacheEntries = 1024
+
 func NewConnection(instance *Instance, proto string, connectionId uint64, ingress bool, srcId, dstId uint32, srcAddr, dstAddr, policyName string, origBuf, replyBuf *[]byte) (error, *Connection) {
 	// Find the parser for the proto
 	parserFactory := GetParserFactory(proto)
@@ -90,6 +114,23 @@ func NewConnection(instance *Instance, proto string, connectionId uint64, ingres
 		OrigBuf:    origBuf,
 		ReplyBuf:   replyBuf,
 	}
+	if instance.passthrough.Matches(proto, connection.Port) {
+		// Bypass parsing, the parser is not instantiated
+		connection.Parser = passthroughParser{}
+		connection.L7Bypass = true
+		return nil, connection
+	}
+
+	// Policy without L7 rules for the port allows all data, so there is
+	// nothing to parse. The decision is made once per connection, policy
+	// changes only apply to new connections.
+	if instance.PolicyBypassesL7(policyName, ingress, connection.Port) {
+		log.Debugf("Connection %d: No L7 rules for port %d, bypassing parser %s", connectionId, connection.Port, proto)
+		connection.Parser = passthroughParser{}
+		connection.L7Bypass = true
+		return nil, connection
+	}
+
 	connection.Parser = parserFactory.Create(connection)
 	if connection.Parser == nil {
 		// Parser rejected the new connection based on the connection metadata
@@ -114,6 +155,16 @@ func advanceInput(input [][]byte, bytes int) [][]byte {
 	return input
 }
 
+// hasInput returns true if there is any data left in input.
+func hasInput(input [][]byte) bool {
+	for _, slice := range input {
+		if len(slice) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (connection *Connection) OnData(reply, endStream bool, data *[][]byte, filterOps *[][2]int64) (res FilterResult) {
 	defer func() {
 		// Recover from any possible parser datapath panics
@@ -135,6 +186,15 @@ func (connection *Connection) OnData(reply, endStream bool, data *[][]byte, filt
 
 	input := *data
 
+	// Track the arrival of the first byte of each request to report the
+	// latency until its verdict is delivered
+	arrival := time.Now()
+	dir := direction(reply)
+	connection.reply = reply
+	if connection.requestStart[dir].IsZero() && hasInput(input) {
+		connection.requestStart[dir] = arrival
+	}
+
 	parser := connection.Parser
 	// Loop until `filterOps` becomes full, or parser is done with the data.
 	for len(*filterOps) < cap(*filterOps) {
@@ -156,6 +216,13 @@ func (connection *Connection) OnData(reply, endStream bool, data *[][]byte, filt
 
 		if op == PASS || op == DROP {
 			input = advanceInput(input, bytes)
+			// The verdict for the pending request has been delivered,
+			// any remaining data arrived with this call
+			if hasInput(input) {
+				connection.requestStart[dir] = arrival
+			} else {
+				connection.requestStart[dir] = time.Time{}
+			}
 			// Loop back to parser even if have no more data to allow the parser to
 			// inject frames at the end of the input.
 		}
@@ -173,7 +240,7 @@ func (connection *Connection) OnData(reply, endStream bool, data *[][]byte, filt
 }
 
 func (connection *Connection) Matches(l7 interface{}) bool {
-	log.Debugf("proxylib: Matching policy on connection %v", connection)
+	connection.Tracef("proxylib: Matching policy on connection %v", connection)
 	remoteID := connection.DstId
 	if connection.Ingress {
 		remoteID = connection.SrcId
@@ -181,6 +248,29 @@ func (connection *Connection) Matches(l7 interface{}) bool {
 	return connection.Instance.PolicyMatches(connection.PolicyName, connection.Ingress, connection.Port, remoteID, l7)
 }
 
+// MatchesCached is like Matches, but memoizes the policy decision for the
+// connection. 'l7' is used as the cache key and must hence be comparable
+// (e.g., a struct of strings holding the extracted request attributes).
+// Cached decisions are discarded whenever the policy is updated. Parsers seeing
+// many identical requests on a connection can use this to skip rule
+// evaluation.
+func (connection *Connection) MatchesCached(l7 interface{}) bool {
+	version := connection.Instance.getPolicyVersion()
+	if connection.policyCache == nil || connection.policyCacheVersion != version ||
+		len(connection.policyCache) >= maxPolicyCacheEntries {
+		connection.policyCache = make(map[interface{}]bool)
+		connection.policyCacheVersion = version
+	}
+
+	if matches, ok := connection.policyCache[l7]; ok {
+		return matches
+	}
+
+	matches := connection.Matches(l7)
+	connection.policyCache[l7] = matches
+	return matches
+}
+
 // getInjectBuf return the pointer to the inject buffer slice header for the indicated direction
 func (connection *Connection) getInjectBuf(reply bool) InjectBuf {
 	if reply {
@@ -197,7 +287,7 @@ func (connection *Connection) Inject(reply bool, data []byte) int {
 	n := copy((*buf)[offset:cap(*buf)], data)
 	*buf = (*buf)[:offset+n] // update the buffer length
 
-	log.Debugf("proxylib: Injected %d bytes: %s (given: %s)", n, string((*buf)[offset:offset+n]), string(data))
+	connection.Tracef("proxylib: Injected %d bytes: %s (given: %s)", n, string((*buf)[offset:offset+n]), string(data))
 
 	// return the number of bytes injected. This may be less than the length of `data` is
 	// the buffer becomes full.
@@ -211,9 +301,21 @@ func (connection *Connection) IsInjectBufFull(reply bool) bool {
 	return len(*buf) == cap(*buf)
 }
 
+// Log sends an access log entry for the connection. Generic L7 entries are
+// annotated with the time elapsed since the first byte of the pending request
+// was received, i.e., the latency budget consumed by the parser to reach its
+// verdict.
 func (conn *Connection) Log(entryType cilium.EntryType, l7 *cilium.LogEntry_GenericL7) {
+	now := time.Now()
+	if start := conn.requestStart[direction(conn.reply)]; !start.IsZero() && l7 != nil && l7.GenericL7 != nil {
+		if l7.GenericL7.Fields == nil {
+			l7.GenericL7.Fields = make(map[string]string)
+		}
+		l7.GenericL7.Fields[accesslog.FieldVerdictLatency] = strconv.FormatInt(int64(now.Sub(start)/time.Microsecond), 10)
+	}
+
 	pblog := &cilium.LogEntry{
-		Timestamp:             uint64(time.Now().UnixNano()),
+		Timestamp:             uint64(now.UnixNano()),
 		IsIngress:             conn.Ingress,
 		EntryType:             entryType,
 		PolicyName:            conn.PolicyName,
@@ -225,3 +327,34 @@ func (conn *Connection) Log(entryType cilium.EntryType, l7 *cilium.LogEntry_Gene
 	}
 	conn.Instance.Log(pblog)
 }
+
+// EmitEvent sends a synthetic event named 'name' for the connection to the
+// Cilium monitor. Unlike the entries sent by Log(), events do not carry a
+// verdict on a request, but report parser observations, e.g., a protocol
+// upgrade or a malformed frame, which are then shown by 'cilium monitor' like
+// the L7 events of the host proxy.
+func (conn *Connection) EmitEvent(name string, fields map[string]string) {
+	eventFields := make(map[string]string, len(fields)+1)
+	for k, v := range fields {
+		eventFields[k] = v
+	}
+	eventFields[accesslog.FieldEvent] = name
+
+	pblog := &cilium.LogEntry{
+		Timestamp:             uint64(time.Now().UnixNano()),
+		IsIngress:             conn.Ingress,
+		EntryType:             cilium.EntryType_Request,
+		PolicyName:            conn.PolicyName,
+		SourceSecurityId:      conn.SrcId,
+		DestinationSecurityId: conn.DstId,
+		SourceAddress:         conn.SrcAddr,
+		DestinationAddress:    conn.DstAddr,
+		L7: &cilium.LogEntry_GenericL7{
+			GenericL7: &cilium.L7LogEntry{
+				Proto:  conn.ParserName,
+				Fields: eventFields,
+			},
+		},
+	}
+	conn.Instance.Log(pblog)
+}
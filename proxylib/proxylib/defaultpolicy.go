@@ -0,0 +1,88 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxylib
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// defaultPolicyDeny drops all data of connections of endpoints with
+	// no policy
+	defaultPolicyDeny = "deny"
+	// defaultPolicyAllow passes all data of connections of endpoints with
+	// no policy
+	defaultPolicyAllow = "allow"
+)
+
+// DefaultPolicy selects how connections are enforced when no policy has been
+// received for their endpoint. The zero value drops all data of such
+// connections. Applying a less strict default allows sidecars to be rolled out
+// before the policies of all endpoints have been distributed. The default
+// policy applies to all endpoints of an instance, endpoint policy names do
+// not identify the namespace of the endpoint. Data is parsed and checked
+// against the policy map on every request, the policy of the endpoint is
+// thus enforced on existing connections as soon as it has been received.
+type DefaultPolicy struct {
+	allow bool
+	// name is the name of the policy in the policy map enforced instead of
+	// the missing policy, if not empty
+	name string
+}
+
+// ParseDefaultPolicy parses a default policy of the form "deny", "allow" or
+// "policy:<name>". An empty string is equivalent to "deny".
+func ParseDefaultPolicy(s string) (DefaultPolicy, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == defaultPolicyDeny:
+		return DefaultPolicy{}, nil
+	case s == defaultPolicyAllow:
+		return DefaultPolicy{allow: true}, nil
+	case strings.HasPrefix(s, "policy:"):
+		name := strings.TrimPrefix(s, "policy:")
+		if name == "" {
+			return DefaultPolicy{}, fmt.Errorf("missing policy name in default policy %q", s)
+		}
+		return DefaultPolicy{name: name}, nil
+	}
+	return DefaultPolicy{}, fmt.Errorf("invalid default policy %q", s)
+}
+
+// String returns the default policy in the format accepted by
+// ParseDefaultPolicy
+func (d DefaultPolicy) String() string {
+	switch {
+	case d.allow:
+		return defaultPolicyAllow
+	case d.name != "":
+		return "policy:" + d.name
+	}
+	return defaultPolicyDeny
+}
+
+// lookup returns the policy enforced on an endpoint without a policy of its
+// own. If allowAll is true all connections are allowed. Otherwise, nil is
+// returned if there is no policy to enforce and all data is dropped.
+func (d DefaultPolicy) lookup(policyMap PolicyMap) (policy *PolicyInstance, allowAll bool) {
+	if d.allow {
+		return nil, true
+	}
+	if d.name != "" {
+		return policyMap[d.name], false
+	}
+	return nil, false
+}
@@ -16,6 +16,7 @@ package proxylib
 
 import (
 	"fmt"
+	"net"
 	"sync/atomic"
 
 	"github.com/cilium/cilium/pkg/lock"
@@ -47,8 +48,24 @@ type Instance struct {
 	nodeID       string
 	accessLogger AccessLogger
 	policyClient PolicyClient
+	passthrough  Passthrough
+	l7Bypass     bool // Bypass parsing on ports without L7 rules
 
-	policyMap atomic.Value // holds PolicyMap
+	// policyMemoryBudget is the maximum estimated memory usage of a single
+	// policy, in bytes. Zero means unlimited.
+	policyMemoryBudget int
+
+	// defaultPolicy is enforced on endpoints for which no policy has
+	// been received
+	defaultPolicy DefaultPolicy
+
+	policyMap     atomic.Value // holds PolicyMap
+	policyVersion uint64       // incremented on each policy map change, accessed atomically
+
+	traceFilters  atomic.Value // holds TraceFilters
+	traceVersion  uint64       // incremented on each trace filter change, accessed atomically
+	traceMutex    lock.Mutex   // protects traceListener and serializes trace commands
+	traceListener net.Listener // trace admin socket, if any
 }
 
 var (
@@ -84,6 +101,19 @@ func NewInstance(nodeID string, accessLogger AccessLogger) *Instance {
 // returns the instance id.
 func OpenInstance(nodeID string, xdsPath string, newPolicyClient func(path, nodeID string, updater PolicyUpdater) PolicyClient,
 	accessLogPath string, newAccessLogger func(accessLogPath string) AccessLogger) uint64 {
+	return OpenInstanceWithPassthrough(nodeID, xdsPath, newPolicyClient, accessLogPath, newAccessLogger, nil, false, 0, DefaultPolicy{})
+}
+
+// OpenInstanceWithPassthrough is like OpenInstance, but connections matching
+// the passthrough rules of the instance bypass L7 parsing. If l7Bypass is
+// true, connections to ports for which the policy has no L7 rules bypass L7
+// parsing as well. Policy updates containing a policy whose estimated memory
+// usage exceeds policyMemoryBudget bytes are rejected, unless
+// policyMemoryBudget is zero. Connections of endpoints for which no policy
+// has been received are enforced according to defaultPolicy.
+func OpenInstanceWithPassthrough(nodeID string, xdsPath string, newPolicyClient func(path, nodeID string, updater PolicyUpdater) PolicyClient,
+	accessLogPath string, newAccessLogger func(accessLogPath string) AccessLogger, passthrough Passthrough, l7Bypass bool,
+	policyMemoryBudget int, defaultPolicy DefaultPolicy) uint64 {
 	mutex.Lock()
 	defer mutex.Unlock()
 
@@ -97,7 +127,9 @@ func OpenInstance(nodeID string, xdsPath string, newPolicyClient func(path, node
 		if old.accessLogger != nil {
 			oldAccessLogPath = old.accessLogger.Path()
 		}
-		if (nodeID == "" || old.nodeID == nodeID) && xdsPath == oldXdsPath && accessLogPath == oldAccessLogPath {
+		if (nodeID == "" || old.nodeID == nodeID) && xdsPath == oldXdsPath && accessLogPath == oldAccessLogPath &&
+			passthrough.String() == old.passthrough.String() && l7Bypass == old.l7Bypass &&
+			policyMemoryBudget == old.policyMemoryBudget && defaultPolicy == old.defaultPolicy {
 			old.openCount++
 			log.Infof("Opened existing library instance %d, open count: %d", id, old.openCount)
 			return id
@@ -105,6 +137,10 @@ func OpenInstance(nodeID string, xdsPath string, newPolicyClient func(path, node
 	}
 
 	ins := NewInstance(nodeID, newAccessLogger(accessLogPath))
+	ins.passthrough = passthrough
+	ins.l7Bypass = l7Bypass
+	ins.policyMemoryBudget = policyMemoryBudget
+	ins.defaultPolicy = defaultPolicy
 	// policy client needs the instance so we set it after instance has been created
 	ins.policyClient = newPolicyClient(xdsPath, ins.nodeID, ins)
 
@@ -137,6 +173,7 @@ func CloseInstance(id uint64) uint64 {
 			if ins.accessLogger != nil {
 				ins.accessLogger.Close()
 			}
+			ins.closeTraceSocket()
 			delete(instances, id)
 		}
 		log.Infof("CloseInstance(%d): Remaining open count: %d", id, count)
@@ -150,18 +187,59 @@ func (ins *Instance) getPolicyMap() PolicyMap {
 	return ins.policyMap.Load().(PolicyMap)
 }
 
+// PolicyHashes returns the hashes of all policies enforced by the instance,
+// keyed by policy name
+func (ins *Instance) PolicyHashes() map[string]string {
+	policyMap := ins.getPolicyMap()
+	hashes := make(map[string]string, len(policyMap))
+	for name, policy := range policyMap {
+		hashes[name] = policy.Hash
+	}
+	return hashes
+}
+
 func (ins *Instance) setPolicyMap(newMap PolicyMap) {
 	ins.policyMap.Store(newMap)
+	atomic.AddUint64(&ins.policyVersion, 1)
+}
+
+// getPolicyVersion returns a version number which changes whenever the policy
+// map is replaced. Used to invalidate cached policy decisions.
+func (ins *Instance) getPolicyVersion() uint64 {
+	return atomic.LoadUint64(&ins.policyVersion)
 }
 
 func (ins *Instance) PolicyMatches(endpointPolicyName string, ingress bool, port, remoteId uint32, l7 interface{}) bool {
+	policy, allowAll := ins.lookupPolicy(endpointPolicyName)
+	return allowAll || policy != nil && policy.Matches(ingress, port, remoteId, l7)
+}
+
+// PolicyBypassesL7 returns true if L7 bypass is enabled for the instance and
+// the policy of the endpoint allows all connections in the given direction to
+// port without L7 parsing. Connections of endpoints enforced by the default
+// policy are never bypassed, as the bypass decision is made once per
+// connection while the policy of the endpoint may still be received.
+func (ins *Instance) PolicyBypassesL7(endpointPolicyName string, ingress bool, port uint32) bool {
+	if !ins.l7Bypass {
+		return false
+	}
 	// Policy maps are never modified once published
 	policy, found := ins.getPolicyMap()[endpointPolicyName]
-	if !found {
-		log.Debugf("NPDS: Policy for %s not found", endpointPolicyName)
-	}
+	return found && policy.BypassL7(ingress, port)
+}
 
-	return found && policy.Matches(ingress, port, remoteId, l7)
+// lookupPolicy returns the policy of the endpoint, falling back to the default
+// policy of the instance if no policy has been received for the endpoint. If
+// allowAll is true all connections are allowed.
+func (ins *Instance) lookupPolicy(endpointPolicyName string) (policy *PolicyInstance, allowAll bool) {
+	// Policy maps are never modified once published
+	policyMap := ins.getPolicyMap()
+	policy, found := policyMap[endpointPolicyName]
+	if found {
+		return policy, false
+	}
+	log.Debugf("NPDS: Policy for %s not found, applying default policy %s", endpointPolicyName, ins.defaultPolicy)
+	return ins.defaultPolicy.lookup(policyMap)
 }
 
 // Update the PolicyMap from a protobuf. PolicyMap is only ever changed if the whole update is successful.
@@ -208,12 +286,22 @@ func (ins *Instance) PolicyUpdate(resp *envoy_api_v2.DiscoveryResponse) (err err
 		}
 
 		// Create new PolicyInstance, may panic
-		newMap[policyName] = newPolicyInstance(&config)
+		policy := newPolicyInstance(&config)
+		if ins.policyMemoryBudget > 0 && policy.MemoryUsage > ins.policyMemoryBudget {
+			return fmt.Errorf("NPDS: Policy %s exceeds memory budget: %d > %d bytes", policyName, policy.MemoryUsage, ins.policyMemoryBudget)
+		}
+		newMap[policyName] = policy
 	}
 
 	// Store the new policy map
 	ins.setPolicyMap(newMap)
 
+	for policyName, policy := range newMap {
+		if oldPolicy, found := oldMap[policyName]; !found || oldPolicy.Hash != policy.Hash {
+			log.Infof("NPDS: Instance %d enforcing policy %s with hash %s", ins.id, policyName, policy.Hash)
+		}
+	}
+
 	log.Debugf("NPDS: Policy Update completed for instance %d: %v", ins.id, newMap)
 	return
 }
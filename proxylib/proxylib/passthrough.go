@@ -0,0 +1,104 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxylib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// passthroughAnyProto matches connections of all parsers in a PassthroughRule
+const passthroughAnyProto = "*"
+
+// PassthroughRule exempts connections to a destination port from L7 parsing
+type PassthroughRule struct {
+	// Proto is the name of the parser the rule applies to, or "*" for
+	// all parsers
+	Proto string
+	// Port is the destination port of the connection
+	Port uint32
+}
+
+// Passthrough is a list of rules for connections whose data is passed
+// without instantiating a parser. This allows operators to exempt known-safe
+// high-throughput flows from the L7 parsing overhead while L7 policy remains
+// enforced on all other ports.
+type Passthrough []PassthroughRule
+
+// ParsePassthrough parses a comma-separated list of passthrough rules of the
+// form "[<proto>:]<port>". A rule without a proto applies to all parsers.
+func ParsePassthrough(s string) (Passthrough, error) {
+	var p Passthrough
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rule := PassthroughRule{Proto: passthroughAnyProto}
+		port := entry
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			rule.Proto, port = entry[:i], entry[i+1:]
+			if rule.Proto == "" {
+				return nil, fmt.Errorf("missing proto in passthrough rule %q", entry)
+			}
+		}
+
+		n, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid port in passthrough rule %q", entry)
+		}
+		rule.Port = uint32(n)
+		p = append(p, rule)
+	}
+	return p, nil
+}
+
+// Matches returns true if connections of parser proto to port bypass parsing
+func (p Passthrough) Matches(proto string, port uint32) bool {
+	for _, rule := range p {
+		if rule.Port == port && (rule.Proto == passthroughAnyProto || rule.Proto == proto) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the passthrough rules in the format accepted by
+// ParsePassthrough
+func (p Passthrough) String() string {
+	rules := make([]string, 0, len(p))
+	for _, rule := range p {
+		rules = append(rules, fmt.Sprintf("%s:%d", rule.Proto, rule.Port))
+	}
+	return strings.Join(rules, ",")
+}
+
+// passthroughParser is the parser of connections matching a passthrough rule.
+// It passes all data in both directions.
+type passthroughParser struct{}
+
+// OnData implements Parser
+func (passthroughParser) OnData(reply, endStream bool, data [][]byte) (OpType, int) {
+	n := 0
+	for _, slice := range data {
+		n += len(slice)
+	}
+	if n == 0 {
+		return NOP, 0
+	}
+	return PASS, n
+}
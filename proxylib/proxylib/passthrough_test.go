@@ -0,0 +1,53 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package proxylib
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (l *LibSuite) TestParsePassthrough(c *C) {
+	p, err := ParsePassthrough("r2d2:4444, 9000,")
+	c.Assert(err, IsNil)
+	c.Assert(p, DeepEquals, Passthrough{{Proto: "r2d2", Port: 4444}, {Proto: "*", Port: 9000}})
+	c.Assert(p.String(), Equals, "r2d2:4444,*:9000")
+
+	c.Assert(p.Matches("r2d2", 4444), Equals, true)
+	c.Assert(p.Matches("cassandra", 4444), Equals, false)
+	c.Assert(p.Matches("cassandra", 9000), Equals, true)
+	c.Assert(p.Matches("r2d2", 80), Equals, false)
+
+	p, err = ParsePassthrough("")
+	c.Assert(err, IsNil)
+	c.Assert(p, HasLen, 0)
+
+	for _, invalid := range []string{"r2d2", ":80", "r2d2:0", "r2d2:70000"} {
+		_, err = ParsePassthrough(invalid)
+		c.Assert(err, Not(IsNil), Commentf("%q", invalid))
+	}
+}
+
+func (l *LibSuite) TestPassthroughParser(c *C) {
+	var p passthroughParser
+
+	op, n := p.OnData(false, false, [][]byte{[]byte("ABCD"), []byte("123")})
+	c.Assert(op, Equals, PASS)
+	c.Assert(n, Equals, 7)
+
+	op, _ = p.OnData(true, true, [][]byte{})
+	c.Assert(op, Equals, NOP)
+}
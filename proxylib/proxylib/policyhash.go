@@ -0,0 +1,124 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxylib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/cilium/proxy/go/cilium/api"
+	"github.com/golang/protobuf/proto"
+)
+
+// policyHashLen is the number of bytes of the SHA-256 digest used as policy
+// hash
+const policyHashLen = 8
+
+// encodeDeterministic returns the deterministic protobuf encoding of m. Map
+// entries are encoded in the order of their keys.
+func encodeDeterministic(m proto.Message) []byte {
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(m); err != nil {
+		ParseError(fmt.Sprintf("Unable to encode policy: %v", err), m)
+	}
+	return buf.Bytes()
+}
+
+// messageSorter sorts a slice of protobuf messages by their deterministic
+// encoding
+type messageSorter struct {
+	keys [][]byte
+	swap func(i, j int)
+}
+
+func (s *messageSorter) Len() int           { return len(s.keys) }
+func (s *messageSorter) Less(i, j int) bool { return bytes.Compare(s.keys[i], s.keys[j]) < 0 }
+func (s *messageSorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.swap(i, j)
+}
+
+// sortMessages sorts slice, which must be a slice of protobuf messages, by
+// the deterministic encoding of its elements
+func sortMessages(slice interface{}) {
+	v := reflect.ValueOf(slice)
+	keys := make([][]byte, v.Len())
+	for i := range keys {
+		keys[i] = encodeDeterministic(v.Index(i).Interface().(proto.Message))
+	}
+	sort.Sort(&messageSorter{keys: keys, swap: reflect.Swapper(slice)})
+}
+
+// canonicalPortNetworkPolicies sorts all lists in policies for which the
+// order has no effect on policy enforcement. Policies are sorted by
+// protocol and port, all rules by their encoding.
+func canonicalPortNetworkPolicies(policies []*cilium.PortNetworkPolicy) {
+	for _, policy := range policies {
+		for _, rule := range policy.Rules {
+			remotes := rule.RemotePolicies
+			sort.Slice(remotes, func(i, j int) bool { return remotes[i] < remotes[j] })
+
+			switch l7 := rule.L7.(type) {
+			case *cilium.PortNetworkPolicyRule_HttpRules:
+				if l7.HttpRules != nil {
+					sortMessages(l7.HttpRules.HttpRules)
+				}
+			case *cilium.PortNetworkPolicyRule_KafkaRules:
+				if l7.KafkaRules != nil {
+					sortMessages(l7.KafkaRules.KafkaRules)
+				}
+			case *cilium.PortNetworkPolicyRule_L7Rules:
+				if l7.L7Rules != nil {
+					sortMessages(l7.L7Rules.L7Rules)
+				}
+			}
+		}
+		sortMessages(policy.Rules)
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].Protocol != policies[j].Protocol {
+			return policies[i].Protocol < policies[j].Protocol
+		}
+		return policies[i].Port < policies[j].Port
+	})
+}
+
+// canonicalPolicy returns a copy of config in canonical form. Policies which
+// only differ in the order of their ports, rules, remote policies or L7 rules
+// have the same canonical form.
+func canonicalPolicy(config *cilium.NetworkPolicy) *cilium.NetworkPolicy {
+	canonical := proto.Clone(config).(*cilium.NetworkPolicy)
+	canonicalPortNetworkPolicies(canonical.IngressPerPortPolicies)
+	canonicalPortNetworkPolicies(canonical.EgressPerPortPolicies)
+	return canonical
+}
+
+// policyHash returns a stable hash of the rules of the canonical policy.
+// The name and the policy ID of the endpoint are not part of the hash so
+// that all sidecars enforcing the same rules report the same hash.
+func policyHash(canonical *cilium.NetworkPolicy) string {
+	rules := &cilium.NetworkPolicy{
+		IngressPerPortPolicies: canonical.IngressPerPortPolicies,
+		EgressPerPortPolicies:  canonical.EgressPerPortPolicies,
+	}
+	digest := sha256.Sum256(encodeDeterministic(rules))
+	return hex.EncodeToString(digest[:policyHashLen])
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package proxylib
+
+import (
+	"github.com/cilium/proxy/go/cilium/api"
+	"github.com/golang/protobuf/proto"
+	. "gopkg.in/check.v1"
+)
+
+var (
+	hashPolicyA = `
+		name: "FooBar"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    remote_policies: 11
+		    remote_policies: 10
+		    http_rules: <
+		      http_rules: <
+			headers: <
+			  name: ":path"
+			  exact_match: "/allowed"
+			>
+		      >
+		      http_rules: <
+			headers: <
+			  name: ":method"
+			  exact_match: "GET"
+			>
+		      >
+		    >
+		  >
+		>
+		ingress_per_port_policies: <
+		  port: 8080
+		  rules: <
+		    remote_policies: 12
+		  >
+		>
+		`
+
+	// hashPolicyB has the same rules as hashPolicyA in a different order
+	hashPolicyB = `
+		name: "BarBaz"
+		policy: 3
+		ingress_per_port_policies: <
+		  port: 8080
+		  rules: <
+		    remote_policies: 12
+		  >
+		>
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    remote_policies: 10
+		    remote_policies: 11
+		    http_rules: <
+		      http_rules: <
+			headers: <
+			  name: ":method"
+			  exact_match: "GET"
+			>
+		      >
+		      http_rules: <
+			headers: <
+			  name: ":path"
+			  exact_match: "/allowed"
+			>
+		      >
+		    >
+		  >
+		>
+		`
+
+	// hashPolicyC differs from hashPolicyA in a single remote policy
+	hashPolicyC = `
+		name: "FooBar"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 8080
+		  rules: <
+		    remote_policies: 13
+		  >
+		>
+		`
+)
+
+func parsePolicyText(c *C, text string) *cilium.NetworkPolicy {
+	pb := new(cilium.NetworkPolicy)
+	c.Assert(proto.UnmarshalText(text, pb), IsNil)
+	return pb
+}
+
+func (l *LibSuite) TestPolicyHash(c *C) {
+	a := parsePolicyText(c, hashPolicyA)
+	b := parsePolicyText(c, hashPolicyB)
+
+	canonicalA := canonicalPolicy(a)
+	c.Assert(proto.Equal(canonicalA.IngressPerPortPolicies[0], canonicalPolicy(b).IngressPerPortPolicies[0]), Equals, true)
+	c.Assert(canonicalA.IngressPerPortPolicies[0].Port, Equals, uint32(80))
+	c.Assert(canonicalA.IngressPerPortPolicies[0].Rules[0].RemotePolicies, DeepEquals, []uint64{10, 11})
+
+	// Canonicalization must not modify the original policy
+	c.Assert(a.IngressPerPortPolicies[0].Rules[0].RemotePolicies, DeepEquals, []uint64{11, 10})
+
+	hashA := policyHash(canonicalA)
+	c.Assert(hashA, HasLen, 2*policyHashLen)
+	c.Assert(policyHash(canonicalPolicy(b)), Equals, hashA)
+	c.Assert(policyHash(canonicalPolicy(parsePolicyText(c, hashPolicyC))), Not(Equals), hashA)
+}
+
+func (l *LibSuite) TestInstancePolicyHashes(c *C) {
+	ins := NewInstance("", nil)
+	ins.CheckInsertPolicyText(c, "1", []string{hashPolicyA, hashPolicyB})
+
+	hashes := ins.PolicyHashes()
+	c.Assert(hashes, HasLen, 2)
+	c.Assert(hashes["FooBar"], Equals, hashes["BarBaz"])
+
+	ins.CheckInsertPolicyText(c, "2", []string{hashPolicyC})
+	hashes = ins.PolicyHashes()
+	c.Assert(hashes, HasLen, 1)
+	c.Assert(hashes["FooBar"], Not(Equals), "")
+}
@@ -20,6 +20,7 @@ import (
 
 	"github.com/cilium/proxy/go/cilium/api"
 	core "github.com/cilium/proxy/go/envoy/api/v2/core"
+	"github.com/golang/protobuf/proto"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -28,6 +29,21 @@ type L7NetworkPolicyRule interface {
 	Matches(interface{}) bool
 }
 
+// L7NetworkPolicyRules may optionally implement this interface to report the
+// amount of memory they use. Rules not implementing it are accounted for with
+// a fixed estimate.
+type L7NetworkPolicyRuleMemoryUsage interface {
+	MemoryUsage() int
+}
+
+// Estimates of the memory used by the parsed policy, in bytes
+const (
+	allowedRemoteMemoryUsage = 48  // one entry in AllowedRemotes
+	ruleMemoryUsage          = 64  // one PortNetworkPolicyRule
+	portMemoryUsage          = 96  // one entry in PortNetworkPolicies.Rules
+	l7RuleMemoryUsage        = 256 // one L7 rule not reporting its own usage
+)
+
 // L7RuleParser takes the protobuf and converts the oneof relevant for the given L7 to an array
 // of L7 rules. A packet matches if the 'Matches' method of any of these rules matches the
 // 'l7' interface passed by the L7 implementation to PolicyMap.Matches() as the last parameter.
@@ -87,6 +103,18 @@ func newPortNetworkPolicyRule(config *cilium.PortNetworkPolicyRule) (PortNetwork
 	return rule, "", true // No L7 is ok
 }
 
+func (p *PortNetworkPolicyRule) memoryUsage() int {
+	usage := ruleMemoryUsage + len(p.AllowedRemotes)*allowedRemoteMemoryUsage
+	for _, rule := range p.L7Rules {
+		if r, ok := rule.(L7NetworkPolicyRuleMemoryUsage); ok {
+			usage += r.MemoryUsage()
+		} else {
+			usage += l7RuleMemoryUsage
+		}
+	}
+	return usage
+}
+
 func (p *PortNetworkPolicyRule) Matches(remoteId uint32, l7 interface{}) bool {
 	// Remote ID must match if we have any.
 	if len(p.AllowedRemotes) > 0 {
@@ -204,6 +232,17 @@ func newPortNetworkPolicies(config []*cilium.PortNetworkPolicy) PortNetworkPolic
 	return policy
 }
 
+func (p *PortNetworkPolicies) memoryUsage() int {
+	usage := 0
+	for _, rules := range p.Rules {
+		usage += portMemoryUsage
+		for i := range rules.Rules {
+			usage += rules.Rules[i].memoryUsage()
+		}
+	}
+	return usage
+}
+
 func (p *PortNetworkPolicies) Matches(port, remoteId uint32, l7 interface{}) bool {
 	rules, found := p.Rules[port]
 	if found {
@@ -234,20 +273,50 @@ func (p *PortNetworkPolicies) Matches(port, remoteId uint32, l7 interface{}) boo
 	return false
 }
 
+// BypassL7 returns true if all connections to port are allowed without L7
+// parsing, i.e., the rules applying to the port have no L7 rules. Matches()
+// allows any data on such connections regardless of the remote identity.
+func (p *PortNetworkPolicies) BypassL7(port uint32) bool {
+	if rules, found := p.Rules[port]; found && !rules.HaveL7Rules {
+		return true
+	}
+	if rules, found := p.Rules[0]; found && !rules.HaveL7Rules {
+		return true
+	}
+	return false
+}
+
 type PolicyInstance struct {
 	protobuf cilium.NetworkPolicy
 	Ingress  PortNetworkPolicies
 	Egress   PortNetworkPolicies
+
+	// Hash is a stable hash of the rules of the policy. Policies with
+	// the same rules have the same hash regardless of the order in which
+	// the rules were received.
+	Hash string
+
+	// MemoryUsage is an estimate of the memory attributable to the
+	// policy, in bytes
+	MemoryUsage int
 }
 
 func newPolicyInstance(config *cilium.NetworkPolicy) *PolicyInstance {
 	log.Debugf("NPDS::PolicyInstance: Inserting policy %s", config.String())
 
-	return &PolicyInstance{
+	// Rules are parsed in canonical order so that the same policy is
+	// enforced identically by all instances
+	canonical := canonicalPolicy(config)
+
+	p := &PolicyInstance{
 		protobuf: *config,
-		Ingress:  newPortNetworkPolicies(config.GetIngressPerPortPolicies()),
-		Egress:   newPortNetworkPolicies(config.GetEgressPerPortPolicies()),
+		Ingress:  newPortNetworkPolicies(canonical.GetIngressPerPortPolicies()),
+		Egress:   newPortNetworkPolicies(canonical.GetEgressPerPortPolicies()),
+		Hash:     policyHash(canonical),
 	}
+	// Both the original protobuf and the parsed rules are retained
+	p.MemoryUsage = proto.Size(config) + p.Ingress.memoryUsage() + p.Egress.memoryUsage()
+	return p
 }
 
 func (p *PolicyInstance) Matches(ingress bool, port, remoteId uint32, l7 interface{}) bool {
@@ -258,6 +327,15 @@ func (p *PolicyInstance) Matches(ingress bool, port, remoteId uint32, l7 interfa
 	return p.Egress.Matches(port, remoteId, l7)
 }
 
+// BypassL7 returns true if connections in the given direction to port are
+// allowed without L7 parsing
+func (p *PolicyInstance) BypassL7(ingress bool, port uint32) bool {
+	if ingress {
+		return p.Ingress.BypassL7(port)
+	}
+	return p.Egress.BypassL7(port)
+}
+
 // Network policies keyed by endpoint policy names
 type PolicyMap map[string]*PolicyInstance
 
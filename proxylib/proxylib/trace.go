@@ -0,0 +1,288 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxylib
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TraceFilter selects connections for which verbose parser tracing is
+// enabled. Empty fields match any connection.
+type TraceFilter struct {
+	// Proto is the name of the parser
+	Proto string
+	// SrcAddr is the source IP address, optionally with a port
+	SrcAddr string
+	// DstAddr is the original destination IP address, optionally with a
+	// port
+	DstAddr string
+	// SrcId is the source security ID
+	SrcId uint32
+	// DstId is the destination security ID
+	DstId uint32
+}
+
+// ParseTraceFilter parses a trace filter of space-separated fields of the
+// form "<key>=<value>" with the keys "proto", "src", "dst", "srcid" and
+// "dstid", e.g. "proto=r2d2 src=10.0.0.1 dst=10.0.0.2:80 dstid=1000". An
+// empty filter matches all connections.
+func ParseTraceFilter(s string) (TraceFilter, error) {
+	var f TraceFilter
+	for _, field := range strings.Fields(s) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return TraceFilter{}, fmt.Errorf("invalid trace filter field %q", field)
+		}
+
+		switch kv[0] {
+		case "proto":
+			f.Proto = kv[1]
+		case "src", "dst":
+			if err := validateTraceAddr(kv[1]); err != nil {
+				return TraceFilter{}, err
+			}
+			if kv[0] == "src" {
+				f.SrcAddr = kv[1]
+			} else {
+				f.DstAddr = kv[1]
+			}
+		case "srcid", "dstid":
+			id, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return TraceFilter{}, fmt.Errorf("invalid security ID in trace filter field %q", field)
+			}
+			if kv[0] == "srcid" {
+				f.SrcId = uint32(id)
+			} else {
+				f.DstId = uint32(id)
+			}
+		default:
+			return TraceFilter{}, fmt.Errorf("unknown trace filter field %q", field)
+		}
+	}
+	return f, nil
+}
+
+// validateTraceAddr returns an error if addr is neither an IP address nor an
+// IP address with a port
+func validateTraceAddr(addr string) error {
+	if net.ParseIP(addr) != nil {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && net.ParseIP(host) != nil {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q in trace filter", addr)
+}
+
+// matchesAddr returns true if the connection address addr in "host:port"
+// format matches the filter address, which may omit the port
+func matchesAddr(filter, addr string) bool {
+	if filter == "" || filter == addr {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && net.ParseIP(filter).Equal(net.ParseIP(host))
+}
+
+// Matches returns true if the filter selects the connection
+func (f TraceFilter) Matches(connection *Connection) bool {
+	return (f.Proto == "" || f.Proto == connection.ParserName) &&
+		matchesAddr(f.SrcAddr, connection.SrcAddr) &&
+		matchesAddr(f.DstAddr, connection.DstAddr) &&
+		(f.SrcId == 0 || f.SrcId == connection.SrcId) &&
+		(f.DstId == 0 || f.DstId == connection.DstId)
+}
+
+// String returns the filter in the format accepted by ParseTraceFilter
+func (f TraceFilter) String() string {
+	var fields []string
+	if f.Proto != "" {
+		fields = append(fields, "proto="+f.Proto)
+	}
+	if f.SrcAddr != "" {
+		fields = append(fields, "src="+f.SrcAddr)
+	}
+	if f.DstAddr != "" {
+		fields = append(fields, "dst="+f.DstAddr)
+	}
+	if f.SrcId != 0 {
+		fields = append(fields, fmt.Sprintf("srcid=%d", f.SrcId))
+	}
+	if f.DstId != 0 {
+		fields = append(fields, fmt.Sprintf("dstid=%d", f.DstId))
+	}
+	return strings.Join(fields, " ")
+}
+
+// TraceFilters is a set of trace filters, a connection is traced if any of
+// the filters matches
+type TraceFilters []TraceFilter
+
+// Matches returns true if any of the filters selects the connection
+func (t TraceFilters) Matches(connection *Connection) bool {
+	for _, f := range t {
+		if f.Matches(connection) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTraceFilters replaces the trace filters of the instance. Connections
+// re-evaluate the filters on their next trace message.
+func (ins *Instance) SetTraceFilters(filters TraceFilters) {
+	ins.traceFilters.Store(filters)
+	atomic.AddUint64(&ins.traceVersion, 1)
+}
+
+// TraceFilters returns the trace filters of the instance
+func (ins *Instance) TraceFilters() TraceFilters {
+	filters, _ := ins.traceFilters.Load().(TraceFilters)
+	return filters
+}
+
+// Tracing returns true if verbose tracing is enabled for the connection
+func (connection *Connection) Tracing() bool {
+	version := atomic.LoadUint64(&connection.Instance.traceVersion)
+	if connection.traceVersion != version {
+		connection.tracing = connection.Instance.TraceFilters().Matches(connection)
+		connection.traceVersion = version
+	}
+	return connection.tracing
+}
+
+// Tracef logs a parser trace message if tracing is enabled for the
+// connection. Unlike debug logging, which applies to all connections, trace
+// messages are only emitted for connections selected by the trace filters.
+func (connection *Connection) Tracef(format string, args ...interface{}) {
+	if !connection.Tracing() {
+		return
+	}
+	log.WithFields(log.Fields{
+		"connection": connection.Id,
+		"proto":      connection.ParserName,
+		"src":        connection.SrcAddr,
+		"dst":        connection.DstAddr,
+	}).Infof(format, args...)
+}
+
+// ServeTraceSocket starts serving the trace admin socket of the instance at
+// path. Each line received on the socket is a command:
+//
+//	trace <filter>  adds a trace filter, see ParseTraceFilter
+//	clear           removes all trace filters
+//	list            lists the trace filters, one per line
+//
+// Each command is answered with a line starting with "OK" or "ERROR". The
+// socket is removed when the instance is closed. Calling ServeTraceSocket
+// again on the same instance is a no-op.
+func (ins *Instance) ServeTraceSocket(path string) error {
+	ins.traceMutex.Lock()
+	defer ins.traceMutex.Unlock()
+
+	if ins.traceListener != nil {
+		return nil
+	}
+
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("unable to listen on trace socket %s: %s", path, err)
+	}
+	ins.traceListener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go ins.serveTraceConn(conn)
+		}
+	}()
+
+	log.Infof("proxylib: Serving trace socket %s for instance %d", path, ins.id)
+	return nil
+}
+
+// serveTraceConn processes the commands received on a trace socket
+// connection
+func (ins *Instance) serveTraceConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fmt.Fprintln(conn, ins.traceCommand(scanner.Text()))
+	}
+}
+
+// traceCommand executes a trace socket command and returns the response
+func (ins *Instance) traceCommand(line string) string {
+	command := strings.TrimSpace(line)
+	args := ""
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		command, args = command[:i], command[i+1:]
+	}
+
+	// Commands are serialized to not lose concurrent updates
+	ins.traceMutex.Lock()
+	defer ins.traceMutex.Unlock()
+
+	switch command {
+	case "trace":
+		filter, err := ParseTraceFilter(args)
+		if err != nil {
+			return "ERROR " + err.Error()
+		}
+		filters := append(TraceFilters{}, ins.TraceFilters()...)
+		ins.SetTraceFilters(append(filters, filter))
+		log.Infof("proxylib: Tracing connections matching %q", filter.String())
+		return "OK"
+
+	case "clear":
+		ins.SetTraceFilters(nil)
+		log.Info("proxylib: Tracing disabled")
+		return "OK"
+
+	case "list":
+		var b strings.Builder
+		for _, f := range ins.TraceFilters() {
+			b.WriteString(f.String())
+			b.WriteString("\n")
+		}
+		return b.String() + "OK"
+	}
+	return fmt.Sprintf("ERROR unknown command %q", command)
+}
+
+// closeTraceSocket stops serving the trace socket
+func (ins *Instance) closeTraceSocket() {
+	ins.traceMutex.Lock()
+	defer ins.traceMutex.Unlock()
+
+	if ins.traceListener != nil {
+		ins.traceListener.Close()
+		ins.traceListener = nil
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package proxylib
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (l *LibSuite) TestParseTraceFilter(c *C) {
+	f, err := ParseTraceFilter("proto=r2d2 src=10.0.0.1 dst=10.0.0.2:80 srcid=1 dstid=2")
+	c.Assert(err, IsNil)
+	c.Assert(f, DeepEquals, TraceFilter{Proto: "r2d2", SrcAddr: "10.0.0.1", DstAddr: "10.0.0.2:80", SrcId: 1, DstId: 2})
+	c.Assert(f.String(), Equals, "proto=r2d2 src=10.0.0.1 dst=10.0.0.2:80 srcid=1 dstid=2")
+
+	f, err = ParseTraceFilter("")
+	c.Assert(err, IsNil)
+	c.Assert(f, DeepEquals, TraceFilter{})
+
+	for _, invalid := range []string{"proto", "proto=", "src=foo", "dst=foo:80", "srcid=-1", "port=80"} {
+		_, err = ParseTraceFilter(invalid)
+		c.Assert(err, Not(IsNil), Commentf("%q", invalid))
+	}
+}
+
+func (l *LibSuite) TestTraceFilterMatches(c *C) {
+	conn := &Connection{ParserName: "r2d2", SrcAddr: "10.0.0.1:34567", DstAddr: "10.0.0.2:80", SrcId: 1, DstId: 2}
+
+	for _, tc := range []struct {
+		filter  string
+		matches bool
+	}{
+		{"", true},
+		{"proto=r2d2", true},
+		{"proto=cassandra", false},
+		{"src=10.0.0.1", true},
+		{"src=10.0.0.1:34567", true},
+		{"src=10.0.0.1:1", false},
+		{"dst=10.0.0.2 dstid=2", true},
+		{"dst=10.0.0.3", false},
+		{"srcid=1 dstid=3", false},
+	} {
+		f, err := ParseTraceFilter(tc.filter)
+		c.Assert(err, IsNil)
+		c.Assert(f.Matches(conn), Equals, tc.matches, Commentf("%q", tc.filter))
+	}
+}
+
+func (l *LibSuite) TestTracing(c *C) {
+	ins := &Instance{}
+	conn := &Connection{Instance: ins, ParserName: "r2d2", SrcAddr: "10.0.0.1:34567", DstAddr: "10.0.0.2:80"}
+	c.Assert(conn.Tracing(), Equals, false)
+
+	ins.SetTraceFilters(TraceFilters{{Proto: "cassandra"}, {DstAddr: "10.0.0.2"}})
+	c.Assert(conn.Tracing(), Equals, true)
+
+	ins.SetTraceFilters(TraceFilters{{Proto: "cassandra"}})
+	c.Assert(conn.Tracing(), Equals, false)
+
+	ins.SetTraceFilters(nil)
+	c.Assert(conn.Tracing(), Equals, false)
+}
+
+func (l *LibSuite) TestTraceSocket(c *C) {
+	dir, err := ioutil.TempDir("", "proxylib-trace")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ins := &Instance{}
+	path := filepath.Join(dir, "trace.sock")
+	c.Assert(ins.ServeTraceSocket(path), IsNil)
+	defer ins.closeTraceSocket()
+	c.Assert(ins.ServeTraceSocket(path), IsNil)
+
+	conn, err := net.Dial("unix", path)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	command := func(cmd string) string {
+		fmt.Fprintln(conn, cmd)
+		line, err := reader.ReadString('\n')
+		c.Assert(err, IsNil)
+		return line
+	}
+
+	c.Assert(command("trace proto=r2d2 dstid=2"), Equals, "OK\n")
+	c.Assert(command("trace src=foo"), Matches, "ERROR .*\n")
+	c.Assert(ins.TraceFilters(), DeepEquals, TraceFilters{{Proto: "r2d2", DstId: 2}})
+	c.Assert(command("list"), Equals, "proto=r2d2 dstid=2\n")
+	line, err := reader.ReadString('\n')
+	c.Assert(err, IsNil)
+	c.Assert(line, Equals, "OK\n")
+
+	c.Assert(command("clear"), Equals, "OK\n")
+	c.Assert(ins.TraceFilters(), HasLen, 0)
+	c.Assert(command("foo"), Matches, "ERROR .*\n")
+}
@@ -120,6 +120,36 @@ func TestOnNewConnection(t *testing.T) {
 	CheckClose(t, 12345678901234567890, nil, 1)
 }
 
+func TestPassthrough(t *testing.T) {
+	mod := OpenModule([][2]string{{"passthrough", "invalid"}}, debug)
+	if mod != 0 {
+		t.Error("OpenModule() with invalid passthrough rules accepted")
+		defer CloseModule(mod)
+	}
+
+	mod = OpenModule([][2]string{{"passthrough", "test.passer:80"}}, debug)
+	if mod == 0 {
+		t.Error("OpenModule() with passthrough rules failed")
+	} else {
+		defer CloseModule(mod)
+	}
+
+	// The parser would reject the connection, but is never instantiated
+	CheckOnNewConnection(t, mod, "test.passer", 1, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "invalid-policy",
+		80, proxylib.OK, 1)
+
+	data := [][]byte{[]byte("foo"), []byte("bar")}
+	CheckOnData(t, 1, false, false, &data, []ExpFilterOp{
+		{proxylib.PASS, 6},
+	}, proxylib.OK, "")
+
+	// Other ports are still parsed
+	CheckOnNewConnection(t, mod, "test.passer", 2, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:81", "invalid-policy",
+		80, proxylib.POLICY_DROP, 1)
+
+	CheckClose(t, 1, nil, 1)
+}
+
 func checkAccessLogs(t *testing.T, logServer *test.AccessLogServer, expPasses, expDrops int) {
 	t.Helper()
 	passes, drops := 0, 0
@@ -694,3 +724,219 @@ func TestAllowAllPolicyL3Egress(t *testing.T) {
 
 	CheckClose(t, 1, buf, 1)
 }
+
+func TestL7Bypass(t *testing.T) {
+	logServer := test.StartAccessLogServer("access_log.sock", 10)
+	defer logServer.Close()
+
+	mod := OpenModule([][2]string{{"l7-bypass", "invalid"}}, debug)
+	if mod != 0 {
+		t.Error("OpenModule() with invalid l7-bypass value accepted")
+		defer CloseModule(mod)
+	}
+
+	mod = OpenModule([][2]string{{"access-log-path", logServer.Path}, {"l7-bypass", "true"}}, debug)
+	if mod == 0 {
+		t.Errorf("OpenModule() with access log path %s failed", logServer.Path)
+	} else {
+		defer CloseModule(mod)
+	}
+
+	insertPolicyText(t, mod, "1", []string{`
+		name: "FooBar"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    remote_policies: 1
+		  >
+		>
+		ingress_per_port_policies: <
+		  port: 81
+		  rules: <
+		    l7_proto: "test.headerparser"
+		    l7_rules: <
+		      l7_rules: <>
+		    >
+		  >
+		>
+		`})
+
+	// No L7 rules on the port, the parser is bypassed
+	CheckOnNewConnection(t, mod, "test.headerparser", 1, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "FooBar",
+		80, proxylib.OK, 1)
+	if !OnL7Bypass(1) {
+		t.Error("OnL7Bypass() returned false for a port without L7 rules")
+	}
+	data := [][]byte{[]byte("foo\n"), []byte("bar")}
+	CheckOnData(t, 1, false, false, &data, []ExpFilterOp{
+		{proxylib.PASS, 7},
+	}, proxylib.OK, "")
+
+	// L7 rules on the port, data is parsed
+	CheckOnNewConnection(t, mod, "test.headerparser", 2, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:81", "FooBar",
+		80, proxylib.OK, 2)
+	if OnL7Bypass(2) {
+		t.Error("OnL7Bypass() returned true for a port with L7 rules")
+	}
+
+	// No policy for the endpoint, data is parsed and dropped
+	CheckOnNewConnection(t, mod, "test.headerparser", 3, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "Unknown",
+		80, proxylib.OK, 3)
+	if OnL7Bypass(3) {
+		t.Error("OnL7Bypass() returned true for an unknown policy")
+	}
+	if OnL7Bypass(4) {
+		t.Error("OnL7Bypass() returned true for an unknown connection")
+	}
+
+	CheckClose(t, 1, nil, 3)
+	CheckClose(t, 2, nil, 2)
+	CheckClose(t, 3, nil, 1)
+}
+
+func TestPolicyMemoryBudget(t *testing.T) {
+	logServer := test.StartAccessLogServer("access_log.sock", 10)
+	defer logServer.Close()
+
+	mod := OpenModule([][2]string{{"policy-memory-budget", "-1"}}, debug)
+	if mod != 0 {
+		t.Error("OpenModule() with invalid policy-memory-budget value accepted")
+		defer CloseModule(mod)
+	}
+
+	mod = OpenModule([][2]string{{"access-log-path", logServer.Path}, {"policy-memory-budget", "1024"}}, debug)
+	if mod == 0 {
+		t.Errorf("OpenModule() with access log path %s failed", logServer.Path)
+	} else {
+		defer CloseModule(mod)
+	}
+
+	if !insertPolicyText(t, mod, "1", []string{`
+		name: "FooBar"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    remote_policies: 1
+		  >
+		>
+		`}) {
+		t.Error("Policy within the memory budget rejected")
+	}
+	hash := proxylib.FindInstance(mod).PolicyHashes()["FooBar"]
+
+	// 20 remotes exceed the budget
+	err := insertPolicyTextRaw(t, mod, "2", []string{`
+		name: "FooBar"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    remote_policies: [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20]
+		  >
+		>
+		`}, "update")
+	if err == nil {
+		t.Error("Expected Policy Update to fail due to exceeding the memory budget, but it succeeded")
+	} else {
+		log.Infof("Expected error: %s", err)
+	}
+
+	// The old policy is still enforced
+	if hashes := proxylib.FindInstance(mod).PolicyHashes(); hashes["FooBar"] != hash {
+		t.Errorf("Policy changed after a rejected update: %s != %s", hashes["FooBar"], hash)
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	logServer := test.StartAccessLogServer("access_log.sock", 10)
+	defer logServer.Close()
+
+	mod := OpenModule([][2]string{{"default-policy", "policy:"}}, debug)
+	if mod != 0 {
+		t.Error("OpenModule() with invalid default-policy value accepted")
+		defer CloseModule(mod)
+	}
+
+	mod = OpenModule([][2]string{{"access-log-path", logServer.Path}, {"default-policy", "policy:FooBar"}}, debug)
+	if mod == 0 {
+		t.Errorf("OpenModule() with access log path %s failed", logServer.Path)
+	} else {
+		defer CloseModule(mod)
+	}
+
+	insertPolicyText(t, mod, "1", []string{`
+		name: "FooBar"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "test.headerparser"
+		    l7_rules: <
+		      l7_rules: <>
+		    >
+		  >
+		>
+		`})
+
+	// No policy for the endpoint, the named default policy is enforced
+	buf := CheckOnNewConnection(t, mod, "test.headerparser", 1, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "Unknown",
+		80, proxylib.OK, 1)
+	line1, line2 := "foo\n", "bar\n"
+	CheckOnData(t, 1, false, false, &[][]byte{[]byte(line1 + line2)}, []ExpFilterOp{
+		{proxylib.PASS, len(line1)},
+		{proxylib.PASS, len(line2)},
+	}, proxylib.OK, "")
+
+	// Port not allowed by the named default policy, data is dropped
+	CheckOnNewConnection(t, mod, "test.headerparser", 2, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:81", "Unknown",
+		81, proxylib.OK, 2)
+	CheckOnData(t, 2, false, false, &[][]byte{[]byte(line1)}, []ExpFilterOp{
+		{proxylib.DROP, len(line1)},
+	}, proxylib.OK, "Line dropped: "+line1)
+
+	expPasses, expDrops := 2, 1
+	checkAccessLogs(t, logServer, expPasses, expDrops)
+
+	CheckClose(t, 1, buf, 2)
+	CheckClose(t, 2, nil, 1)
+
+	mod2 := OpenModule([][2]string{{"access-log-path", logServer.Path}, {"default-policy", "allow"}, {"l7-bypass", "true"}}, debug)
+	if mod2 == 0 {
+		t.Error("OpenModule() with default-policy allow failed")
+	} else {
+		defer CloseModule(mod2)
+	}
+	if mod2 == mod {
+		t.Error("OpenModule() with a different default-policy returned an existing instance")
+	}
+
+	// No policy for the endpoint, all data is passed but not bypassed
+	CheckOnNewConnection(t, mod2, "test.headerparser", 3, true, 1, 2, "1.1.1.1:34567", "2.2.2.2:81", "Unknown",
+		81, proxylib.OK, 1)
+	if OnL7Bypass(3) {
+		t.Error("OnL7Bypass() returned true for a connection enforced by the default policy")
+	}
+	CheckOnData(t, 3, false, false, &[][]byte{[]byte(line1)}, []ExpFilterOp{
+		{proxylib.PASS, len(line1)},
+	}, proxylib.OK, "")
+
+	// The policy of the endpoint is enforced on the existing connection
+	// once it has been received
+	insertPolicyText(t, mod2, "1", []string{`
+		name: "Unknown"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    remote_policies: 1
+		  >
+		>
+		`})
+	CheckOnData(t, 3, false, false, &[][]byte{[]byte(line2)}, []ExpFilterOp{
+		{proxylib.DROP, len(line2)},
+	}, proxylib.OK, "Line dropped: "+line2)
+
+	CheckClose(t, 3, nil, 1)
+}
@@ -180,7 +180,7 @@ func (p *parser) OnData(reply, endStream bool, dataArray [][]byte) (proxylib.OpT
 	matches := true
 	access_log_entry_type := cilium.EntryType_Request
 
-	if !p.connection.Matches(reqData) {
+	if !p.connection.MatchesCached(reqData) {
 		matches = false
 		access_log_entry_type = cilium.EntryType_Denied
 	}
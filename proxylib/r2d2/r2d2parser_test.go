@@ -17,8 +17,11 @@
 package r2d2
 
 import (
+	"strconv"
 	"testing"
+	"time"
 
+	pkgaccesslog "github.com/cilium/cilium/pkg/proxy/accesslog"
 	"github.com/cilium/cilium/proxylib/accesslog"
 	"github.com/cilium/cilium/proxylib/proxylib"
 	"github.com/cilium/cilium/proxylib/test"
@@ -178,3 +181,113 @@ func (s *R2d2Suite) TestR2d2OnDataAllowDenyRegex(c *C) {
 		proxylib.DROP, len(msg2),
 		proxylib.MORE, 1)
 }
+
+func (s *R2d2Suite) TestR2d2PolicyCacheInvalidation(c *C) {
+
+	s.ins.CheckInsertPolicyText(c, "1", []string{`
+		name: "cp4"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "r2d2"
+		    l7_rules: <
+		      l7_rules: <
+		        rule: <
+		          key: "cmd"
+		          value: "READ"
+		        >
+		      >
+		    >
+		  >
+		>
+		`})
+	conn := s.ins.CheckNewConnectionOK(c, "r2d2", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "cp4")
+	msg1 := "WRITE xssss\r\n"
+	data := [][]byte{[]byte(msg1 + msg1)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte("ERROR\r\nERROR\r\n"),
+		proxylib.DROP, len(msg1),
+		proxylib.DROP, len(msg1),
+		proxylib.MORE, 1)
+
+	// Allowing WRITE must take effect on the existing connection
+	s.ins.CheckInsertPolicyText(c, "2", []string{`
+		name: "cp4"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "r2d2"
+		    l7_rules: <
+		      l7_rules: <
+		        rule: <
+		          key: "cmd"
+		          value: "WRITE"
+		        >
+		      >
+		    >
+		  >
+		>
+		`})
+	data = [][]byte{[]byte(msg1)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.PASS, len(msg1),
+		proxylib.MORE, 1)
+}
+
+func (s *R2d2Suite) TestR2d2VerdictLatency(c *C) {
+
+	// allow all rule
+	s.ins.CheckInsertPolicyText(c, "1", []string{`
+		name: "cp5"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "r2d2"
+		  >
+		>
+		`})
+	conn := s.ins.CheckNewConnectionOK(c, "r2d2", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "cp5")
+	msgPart1 := "RE"
+	msgPart2 := "AD xssss\r\n"
+	data := [][]byte{[]byte(msgPart1)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.MORE, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	// The latency is measured from the first byte of the request
+	data = [][]byte{[]byte(msgPart1 + msgPart2)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.PASS, len(msgPart1+msgPart2),
+		proxylib.MORE, 1)
+
+	select {
+	case pblog := <-s.logServer.Logs:
+		latency, ok := pblog.GetGenericL7().GetFields()[pkgaccesslog.FieldVerdictLatency]
+		c.Assert(ok, Equals, true)
+		us, err := strconv.ParseUint(latency, 10, 64)
+		c.Assert(err, IsNil)
+		c.Assert(us >= uint64(10*time.Millisecond/time.Microsecond), Equals, true)
+	case <-time.After(time.Second):
+		c.Fatal("No access log entry received")
+	}
+}
+
+func (s *R2d2Suite) TestR2d2EmitEvent(c *C) {
+	conn := s.ins.CheckNewConnectionOK(c, "r2d2", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "no-policy")
+	conn.EmitEvent("malformed-frame", map[string]string{"reason": "test"})
+
+	select {
+	case pblog := <-s.logServer.Logs:
+		c.Assert(pblog.SourceSecurityId, Equals, uint32(1))
+		c.Assert(pblog.DestinationSecurityId, Equals, uint32(2))
+		c.Assert(pblog.GetGenericL7().GetProto(), Equals, "r2d2")
+		c.Assert(pblog.GetGenericL7().GetFields(), DeepEquals, map[string]string{
+			pkgaccesslog.FieldEvent: "malformed-frame",
+			"reason":                "test",
+		})
+	case <-time.After(time.Second):
+		c.Fatal("No event received")
+	}
+}
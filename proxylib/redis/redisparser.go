@@ -0,0 +1,811 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/cilium/proxylib/proxylib"
+
+	"github.com/cilium/proxy/go/cilium/api"
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Redis Parser
+//
+// Parses the Redis serialization protocol (RESP) as specified in
+// https://redis.io/topics/protocol
+//
+// Requests are either sent as an array of bulk strings or as inline commands:
+// "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n" or "GET foo\r\n"
+//
+// Clients may pipeline requests, i.e. send multiple requests without waiting
+// for the replies. Redis answers requests in order, so the parser keeps a
+// queue of pending requests to correlate replies with requests and to inject
+// error replies for denied requests at the correct position of the reply
+// stream.
+//
+// Policy Examples:
+// {command: "GET"}                     - Allow GET commands on all keys
+// {commandClass: "read"}               - Allow all read-only commands
+// {commandClass: "write", keyPattern: "session:*"} - Allow writes of session keys
+// {keyPattern: "public:*"}             - Allow all commands on public keys
+
+const (
+	parserName = "redis"
+
+	// CommandClassRead is the class of commands reading keys
+	CommandClassRead = "read"
+	// CommandClassWrite is the class of commands creating or modifying keys
+	CommandClassWrite = "write"
+	// CommandClassDelete is the class of commands deleting keys
+	CommandClassDelete = "delete"
+
+	// maxInlineLength is the maximum length of an inline command, equal
+	// to the limit enforced by Redis
+	maxInlineLength = 64 * 1024
+)
+
+// commandClasses maps commands to the class of commands they belong to
+var commandClasses = map[string]string{
+	"GET":       CommandClassRead,
+	"MGET":      CommandClassRead,
+	"GETRANGE":  CommandClassRead,
+	"STRLEN":    CommandClassRead,
+	"EXISTS":    CommandClassRead,
+	"TTL":       CommandClassRead,
+	"PTTL":      CommandClassRead,
+	"TYPE":      CommandClassRead,
+	"HGET":      CommandClassRead,
+	"HMGET":     CommandClassRead,
+	"HGETALL":   CommandClassRead,
+	"HKEYS":     CommandClassRead,
+	"HVALS":     CommandClassRead,
+	"HEXISTS":   CommandClassRead,
+	"HLEN":      CommandClassRead,
+	"LRANGE":    CommandClassRead,
+	"LINDEX":    CommandClassRead,
+	"LLEN":      CommandClassRead,
+	"SMEMBERS":  CommandClassRead,
+	"SISMEMBER": CommandClassRead,
+	"SCARD":     CommandClassRead,
+	"ZRANGE":    CommandClassRead,
+	"ZSCORE":    CommandClassRead,
+	"ZCARD":     CommandClassRead,
+
+	"SET":     CommandClassWrite,
+	"SETNX":   CommandClassWrite,
+	"SETEX":   CommandClassWrite,
+	"PSETEX":  CommandClassWrite,
+	"GETSET":  CommandClassWrite,
+	"MSET":    CommandClassWrite,
+	"MSETNX":  CommandClassWrite,
+	"APPEND":  CommandClassWrite,
+	"INCR":    CommandClassWrite,
+	"INCRBY":  CommandClassWrite,
+	"DECR":    CommandClassWrite,
+	"DECRBY":  CommandClassWrite,
+	"EXPIRE":  CommandClassWrite,
+	"PEXPIRE": CommandClassWrite,
+	"PERSIST": CommandClassWrite,
+	"HSET":    CommandClassWrite,
+	"HSETNX":  CommandClassWrite,
+	"HMSET":   CommandClassWrite,
+	"HINCRBY": CommandClassWrite,
+	"LPUSH":   CommandClassWrite,
+	"RPUSH":   CommandClassWrite,
+	"LPOP":    CommandClassWrite,
+	"RPOP":    CommandClassWrite,
+	"LSET":    CommandClassWrite,
+	"SADD":    CommandClassWrite,
+	"SPOP":    CommandClassWrite,
+	"ZADD":    CommandClassWrite,
+	"ZINCRBY": CommandClassWrite,
+
+	"RENAME":      CommandClassWrite,
+	"RENAMENX":    CommandClassWrite,
+	"SMOVE":       CommandClassWrite,
+	"RPOPLPUSH":   CommandClassWrite,
+	"BRPOPLPUSH":  CommandClassWrite,
+	"BITOP":       CommandClassWrite,
+	"SINTERSTORE": CommandClassWrite,
+	"SUNIONSTORE": CommandClassWrite,
+	"SDIFFSTORE":  CommandClassWrite,
+	"ZUNIONSTORE": CommandClassWrite,
+	"ZINTERSTORE": CommandClassWrite,
+
+	"DEL":    CommandClassDelete,
+	"UNLINK": CommandClassDelete,
+	"HDEL":   CommandClassDelete,
+	"LREM":   CommandClassDelete,
+	"SREM":   CommandClassDelete,
+	"ZREM":   CommandClassDelete,
+}
+
+// keySpec describes the positions of the keys in the arguments of a command
+// following the command name, modeled after the key specifications reported
+// by the Redis COMMAND command. Positions are 1-based, a negative last
+// position counts from the end of the arguments, e.g. -1 is the last
+// argument.
+type keySpec struct {
+	// first is the position of the first key, 0 if the command has no
+	// keys at fixed positions
+	first int
+	// last is the position of the last key
+	last int
+	// step is the distance between keys
+	step int
+	// numKeys is the position of an argument holding the number of keys
+	// immediately following it, e.g. for EVAL. 0 if not used.
+	numKeys int
+	// keys returns the keys of commands with keys at positions depending
+	// on other arguments. The positions above are not used if set.
+	keys func(args []string) ([]string, bool)
+}
+
+var (
+	singleKey    = keySpec{first: 1, last: 1, step: 1}
+	allKeys      = keySpec{first: 1, last: -1, step: 1}
+	twoKeys      = keySpec{first: 1, last: 2, step: 1}
+	keyValuePair = keySpec{first: 1, last: -1, step: 2}
+)
+
+// commandKeys maps all commands operating on keys to the positions of their
+// keys. Commands which are neither listed here nor in keylessCommands are
+// unknown and never match rules with a key pattern.
+var commandKeys = map[string]keySpec{
+	"GET":              singleKey,
+	"GETRANGE":         singleKey,
+	"GETBIT":           singleKey,
+	"STRLEN":           singleKey,
+	"BITCOUNT":         singleKey,
+	"BITPOS":           singleKey,
+	"TTL":              singleKey,
+	"PTTL":             singleKey,
+	"TYPE":             singleKey,
+	"DUMP":             singleKey,
+	"HGET":             singleKey,
+	"HMGET":            singleKey,
+	"HGETALL":          singleKey,
+	"HKEYS":            singleKey,
+	"HVALS":            singleKey,
+	"HEXISTS":          singleKey,
+	"HLEN":             singleKey,
+	"HSTRLEN":          singleKey,
+	"HSCAN":            singleKey,
+	"LRANGE":           singleKey,
+	"LINDEX":           singleKey,
+	"LLEN":             singleKey,
+	"SMEMBERS":         singleKey,
+	"SISMEMBER":        singleKey,
+	"SCARD":            singleKey,
+	"SRANDMEMBER":      singleKey,
+	"SSCAN":            singleKey,
+	"ZRANGE":           singleKey,
+	"ZREVRANGE":        singleKey,
+	"ZRANGEBYSCORE":    singleKey,
+	"ZREVRANGEBYSCORE": singleKey,
+	"ZRANK":            singleKey,
+	"ZREVRANK":         singleKey,
+	"ZSCORE":           singleKey,
+	"ZCARD":            singleKey,
+	"ZCOUNT":           singleKey,
+	"ZSCAN":            singleKey,
+	"SET":              singleKey,
+	"SETNX":            singleKey,
+	"SETEX":            singleKey,
+	"PSETEX":           singleKey,
+	"SETRANGE":         singleKey,
+	"SETBIT":           singleKey,
+	"GETSET":           singleKey,
+	"APPEND":           singleKey,
+	"INCR":             singleKey,
+	"INCRBY":           singleKey,
+	"INCRBYFLOAT":      singleKey,
+	"DECR":             singleKey,
+	"DECRBY":           singleKey,
+	"EXPIRE":           singleKey,
+	"PEXPIRE":          singleKey,
+	"EXPIREAT":         singleKey,
+	"PEXPIREAT":        singleKey,
+	"PERSIST":          singleKey,
+	"RESTORE":          singleKey,
+	"HSET":             singleKey,
+	"HSETNX":           singleKey,
+	"HMSET":            singleKey,
+	"HINCRBY":          singleKey,
+	"HINCRBYFLOAT":     singleKey,
+	"LPUSH":            singleKey,
+	"RPUSH":            singleKey,
+	"LPUSHX":           singleKey,
+	"RPUSHX":           singleKey,
+	"LINSERT":          singleKey,
+	"LPOP":             singleKey,
+	"RPOP":             singleKey,
+	"LSET":             singleKey,
+	"LTRIM":            singleKey,
+	"SADD":             singleKey,
+	"SPOP":             singleKey,
+	"ZADD":             singleKey,
+	"ZINCRBY":          singleKey,
+	"HDEL":             singleKey,
+	"LREM":             singleKey,
+	"SREM":             singleKey,
+	"ZREM":             singleKey,
+	"ZREMRANGEBYRANK":  singleKey,
+	"ZREMRANGEBYSCORE": singleKey,
+
+	"MGET":        allKeys,
+	"EXISTS":      allKeys,
+	"DEL":         allKeys,
+	"UNLINK":      allKeys,
+	"TOUCH":       allKeys,
+	"WATCH":       allKeys,
+	"SINTER":      allKeys,
+	"SUNION":      allKeys,
+	"SDIFF":       allKeys,
+	"SINTERSTORE": allKeys,
+	"SUNIONSTORE": allKeys,
+	"SDIFFSTORE":  allKeys,
+	"PFMERGE":     allKeys,
+
+	"MSET":   keyValuePair,
+	"MSETNX": keyValuePair,
+
+	"RENAME":     twoKeys,
+	"RENAMENX":   twoKeys,
+	"SMOVE":      twoKeys,
+	"RPOPLPUSH":  twoKeys,
+	"BRPOPLPUSH": twoKeys,
+
+	// BLPOP key [key ...] timeout
+	"BLPOP": {first: 1, last: -2, step: 1},
+	"BRPOP": {first: 1, last: -2, step: 1},
+	// BITOP operation destkey key [key ...]
+	"BITOP": {first: 2, last: -1, step: 1},
+	// OBJECT subcommand key
+	"OBJECT": {first: 2, last: 2, step: 1},
+	// ZUNIONSTORE destination numkeys key [key ...] ...
+	"ZUNIONSTORE": {first: 1, last: 1, step: 1, numKeys: 2},
+	"ZINTERSTORE": {first: 1, last: 1, step: 1, numKeys: 2},
+	// EVAL script numkeys key [key ...] arg [arg ...]
+	"EVAL":    {numKeys: 2},
+	"EVALSHA": {numKeys: 2},
+
+	"SORT":    {keys: sortKeys},
+	"MIGRATE": {keys: migrateKeys},
+}
+
+// sortKeys returns the keys of SORT key [...] [STORE destination]
+func sortKeys(args []string) ([]string, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+	keys := []string{args[0]}
+	for i := 1; i < len(args); i++ {
+		if strings.ToUpper(args[i]) == "STORE" {
+			if i+1 >= len(args) {
+				return nil, false
+			}
+			keys = append(keys, args[i+1])
+			i++
+		}
+	}
+	return keys, true
+}
+
+// migrateKeys returns the keys of
+// MIGRATE host port key|"" destination-db timeout [...] [KEYS key [key ...]]
+func migrateKeys(args []string) ([]string, bool) {
+	if len(args) < 5 {
+		return nil, false
+	}
+	if args[2] != "" {
+		return []string{args[2]}, true
+	}
+	for i := 5; i < len(args); i++ {
+		if strings.ToUpper(args[i]) == "KEYS" {
+			if i+1 >= len(args) {
+				return nil, false
+			}
+			return args[i+1:], true
+		}
+	}
+	return nil, false
+}
+
+// position returns the index into args of the 1-based position pos, negative
+// positions count from the end of args
+func position(pos int, args []string) int {
+	if pos < 0 {
+		return len(args) + pos
+	}
+	return pos - 1
+}
+
+// keysOf returns the keys in args according to the key specification.
+// Returns false if the arguments do not match the specification.
+func (spec keySpec) keysOf(args []string) ([]string, bool) {
+	if spec.keys != nil {
+		return spec.keys(args)
+	}
+
+	var keys []string
+	if spec.first != 0 {
+		first, last := position(spec.first, args), position(spec.last, args)
+		if first < 0 || first >= len(args) || last < first || last >= len(args) {
+			return nil, false
+		}
+		for i := first; i <= last; i += spec.step {
+			keys = append(keys, args[i])
+		}
+	}
+
+	if spec.numKeys != 0 {
+		idx := position(spec.numKeys, args)
+		if idx < 0 || idx >= len(args) {
+			return nil, false
+		}
+		n, err := strconv.Atoi(args[idx])
+		if err != nil || n < 0 || n > len(args)-idx-1 {
+			return nil, false
+		}
+		keys = append(keys, args[idx+1:idx+1+n]...)
+	}
+
+	return keys, true
+}
+
+// keylessCommands are commands which do not operate on keys
+var keylessCommands = map[string]struct{}{
+	"PING":         {},
+	"ECHO":         {},
+	"AUTH":         {},
+	"SELECT":       {},
+	"QUIT":         {},
+	"INFO":         {},
+	"DBSIZE":       {},
+	"TIME":         {},
+	"MULTI":        {},
+	"EXEC":         {},
+	"DISCARD":      {},
+	"COMMAND":      {},
+	"CLIENT":       {},
+	"CONFIG":       {},
+	"FLUSHDB":      {},
+	"FLUSHALL":     {},
+	"SCAN":         {},
+	"KEYS":         {},
+	"MONITOR":      {},
+	"SUBSCRIBE":    {},
+	"PSUBSCRIBE":   {},
+	"UNSUBSCRIBE":  {},
+	"PUNSUBSCRIBE": {},
+	"PUBLISH":      {},
+}
+
+// pushCommands switch the connection into a mode in which the server pushes
+// messages which are not correlated to requests
+var pushCommands = map[string]struct{}{
+	"SUBSCRIBE":  {},
+	"PSUBSCRIBE": {},
+	"MONITOR":    {},
+}
+
+// redisRequest is the request data passed to the policy rules
+type redisRequest struct {
+	command string
+	keys    []string
+	// known is false if the command is unknown or its keys could not
+	// be determined from its arguments
+	known bool
+}
+
+// getKeys returns the keys of a request given the command and its arguments.
+// Returns false if the command is unknown or the arguments do not contain
+// the keys expected for the command.
+func getKeys(command string, args []string) ([]string, bool) {
+	if _, ok := keylessCommands[command]; ok {
+		return nil, true
+	}
+	spec, ok := commandKeys[command]
+	if !ok {
+		return nil, false
+	}
+	return spec.keysOf(args)
+}
+
+type redisRule struct {
+	command  string
+	class    string
+	keyRegex *regexp.Regexp
+}
+
+// globToRegexp converts a Redis style glob pattern supporting '*', '?' and
+// character classes into an anchored regular expression
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+			if c == '\\' && i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+				continue
+			}
+			b.WriteByte(c)
+		case c == '*':
+			b.WriteString(".*")
+		case c == '?':
+			b.WriteString(".")
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func (rule *redisRule) Matches(data interface{}) bool {
+	req, ok := data.(redisRequest)
+	if !ok {
+		log.Warning("Matches() called with type other than redisRequest")
+		return false
+	}
+
+	if rule.command != "" && rule.command != req.command {
+		return false
+	}
+	if rule.class != "" && commandClasses[req.command] != rule.class {
+		return false
+	}
+	if rule.keyRegex != nil {
+		// Commands without keys and unknown commands, which may
+		// operate on arbitrary keys, can't be matched by a key pattern
+		if !req.known || len(req.keys) == 0 {
+			return false
+		}
+		for _, key := range req.keys {
+			if !rule.keyRegex.MatchString(key) {
+				return false
+			}
+		}
+	}
+
+	log.Debugf("Redis policy match for rule: %v", rule)
+	return true
+}
+
+// ruleParser parses protobuf L7 rules to enforcement objects
+// May panic
+func ruleParser(rule *cilium.PortNetworkPolicyRule) []proxylib.L7NetworkPolicyRule {
+	var rules []proxylib.L7NetworkPolicyRule
+	l7Rules := rule.GetL7Rules()
+	if l7Rules == nil {
+		return rules
+	}
+	for _, l7Rule := range l7Rules.GetL7Rules() {
+		var rr redisRule
+		for k, v := range l7Rule.Rule {
+			switch k {
+			case "command":
+				rr.command = strings.ToUpper(v)
+			case "commandClass":
+				switch v {
+				case CommandClassRead, CommandClassWrite, CommandClassDelete:
+					rr.class = v
+				default:
+					proxylib.ParseError(fmt.Sprintf("Unsupported command class: %s", v), rule)
+				}
+			case "keyPattern":
+				if v != "" {
+					re, err := globToRegexp(v)
+					if err != nil {
+						proxylib.ParseError(fmt.Sprintf("Invalid key pattern '%s': %s", v, err), rule)
+					}
+					rr.keyRegex = re
+				}
+			default:
+				proxylib.ParseError(fmt.Sprintf("Unsupported key: %s", k), rule)
+			}
+		}
+		log.Debugf("Parsed redis rule: %v", rr)
+		rules = append(rules, &rr)
+	}
+	return rules
+}
+
+type factory struct{}
+
+func init() {
+	log.Info("init(): Registering redisParserFactory")
+	proxylib.RegisterParserFactory(parserName, &factory{})
+	proxylib.RegisterL7RuleParser(parserName, ruleParser)
+}
+
+// replyIntent is a request waiting for its reply
+type replyIntent struct {
+	command string
+	denied  bool
+}
+
+type parser struct {
+	connection *proxylib.Connection
+
+	// replyQueue holds all requests in the order they have been received
+	// which have not been replied to yet
+	replyQueue []replyIntent
+
+	// pushMode is set when the client subscribed to server pushed
+	// messages. Replies can no longer be correlated with requests in this
+	// mode and are passed as they arrive.
+	pushMode bool
+}
+
+func (f *factory) Create(connection *proxylib.Connection) proxylib.Parser {
+	log.Debugf("RedisParserFactory: Create: %v", connection)
+	return &parser{connection: connection}
+}
+
+// DeniedMsg is the error reply injected for requests denied by policy
+var DeniedMsg = []byte("-NOPERM access denied by policy\r\n")
+
+// readLine returns the index of the first "\r\n" in data starting at offset.
+// If no delimiter is found, the number of additional bytes needed is returned
+// as a negative value.
+func readLine(data []byte, offset int) int {
+	idx := bytes.Index(data[offset:], []byte("\r\n"))
+	if idx < 0 {
+		if len(data) > 0 && data[len(data)-1] == '\r' {
+			return -1
+		}
+		return -2
+	}
+	return offset + idx
+}
+
+// parseValue parses a single RESP value starting at offset. Returns the
+// offset after the end of the value. If the value is incomplete, the number
+// of additional bytes needed is returned as a negative value.
+func parseValue(data []byte, offset int) (int, error) {
+	if offset >= len(data) {
+		return -1, nil
+	}
+	eol := readLine(data, offset)
+	if eol < 0 {
+		return eol, nil
+	}
+	line := data[offset+1 : eol]
+	next := eol + 2
+
+	switch data[offset] {
+	case '+', '-', ':':
+		return next, nil
+	case '$':
+		n, err := strconv.Atoi(string(line))
+		if err != nil {
+			return 0, fmt.Errorf("invalid bulk string length: %s", line)
+		}
+		if n < 0 {
+			return next, nil
+		}
+		end := next + n + 2
+		if end > len(data) {
+			return len(data) - end, nil
+		}
+		return end, nil
+	case '*':
+		n, err := strconv.Atoi(string(line))
+		if err != nil {
+			return 0, fmt.Errorf("invalid array length: %s", line)
+		}
+		for i := 0; i < n; i++ {
+			next, err = parseValue(data, next)
+			if err != nil || next < 0 {
+				return next, err
+			}
+		}
+		return next, nil
+	}
+
+	return 0, fmt.Errorf("invalid RESP type '%c'", data[offset])
+}
+
+// parseRequest parses a single request. Returns the length of the request
+// and its arguments. If the request is incomplete, the number of additional
+// bytes needed is returned as a negative value.
+func parseRequest(data []byte) (int, []string, error) {
+	if data[0] != '*' {
+		// Inline command
+		eol := readLine(data, 0)
+		if eol < 0 {
+			if len(data) > maxInlineLength {
+				return 0, nil, fmt.Errorf("inline command exceeds %d bytes", maxInlineLength)
+			}
+			return eol, nil, nil
+		}
+		if eol > maxInlineLength {
+			return 0, nil, fmt.Errorf("inline command exceeds %d bytes", maxInlineLength)
+		}
+		return eol + 2, strings.Fields(string(data[:eol])), nil
+	}
+
+	eol := readLine(data, 0)
+	if eol < 0 {
+		return eol, nil, nil
+	}
+	n, err := strconv.Atoi(string(data[1:eol]))
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf("invalid request array length: %s", data[1:eol])
+	}
+
+	args := make([]string, 0, n)
+	next := eol + 2
+	for i := 0; i < n; i++ {
+		if next >= len(data) {
+			return -1, nil, nil
+		}
+		if data[next] != '$' {
+			return 0, nil, fmt.Errorf("request argument is not a bulk string")
+		}
+		end, err := parseValue(data, next)
+		if err != nil || end < 0 {
+			return end, nil, err
+		}
+		eol = readLine(data, next)
+		args = append(args, string(data[eol+2:end-2]))
+		next = end
+	}
+
+	return next, args, nil
+}
+
+// injectFromQueue injects the error replies of all denied requests at the
+// head of the reply queue. Returns the number of bytes injected.
+func (p *parser) injectFromQueue() int {
+	injected := 0
+	for _, intent := range p.replyQueue {
+		if !intent.denied {
+			break
+		}
+		p.connection.Inject(true, DeniedMsg)
+		injected++
+	}
+	p.replyQueue = p.replyQueue[injected:]
+	return injected * len(DeniedMsg)
+}
+
+func (p *parser) OnData(reply, endStream bool, dataArray [][]byte) (proxylib.OpType, int) {
+	if reply {
+		if injected := p.injectFromQueue(); injected > 0 {
+			return proxylib.INJECT, injected
+		}
+	}
+
+	// inefficient, but simple
+	data := bytes.Join(dataArray, []byte{})
+	if len(data) == 0 {
+		if reply {
+			return proxylib.NOP, 0
+		}
+		return proxylib.MORE, 1
+	}
+
+	if reply {
+		return p.onReply(data)
+	}
+	return p.onRequest(data)
+}
+
+func (p *parser) onReply(data []byte) (proxylib.OpType, int) {
+	length, err := parseValue(data, 0)
+	if err != nil {
+		log.WithError(err).Error("Unable to parse redis reply")
+		return proxylib.ERROR, int(proxylib.ERROR_INVALID_FRAME_TYPE)
+	}
+	if length < 0 {
+		return proxylib.MORE, -length
+	}
+
+	if p.pushMode || len(p.replyQueue) == 0 {
+		return proxylib.PASS, length
+	}
+
+	intent := p.replyQueue[0]
+	p.replyQueue = p.replyQueue[1:]
+	p.connection.Log(cilium.EntryType_Response,
+		&cilium.LogEntry_GenericL7{
+			GenericL7: &cilium.L7LogEntry{
+				Proto: parserName,
+				Fields: map[string]string{
+					"command": intent.command,
+				},
+			},
+		})
+
+	return proxylib.PASS, length
+}
+
+func (p *parser) onRequest(data []byte) (proxylib.OpType, int) {
+	length, args, err := parseRequest(data)
+	if err != nil {
+		log.WithError(err).Error("Unable to parse redis request")
+		return proxylib.ERROR, int(proxylib.ERROR_INVALID_FRAME_TYPE)
+	}
+	if length < 0 {
+		return proxylib.MORE, -length
+	}
+	if len(args) == 0 {
+		// Empty inline command, ignored by redis
+		return proxylib.PASS, length
+	}
+
+	req := redisRequest{command: strings.ToUpper(args[0])}
+	req.keys, req.known = getKeys(req.command, args[1:])
+
+	entryType := cilium.EntryType_Request
+	matches := p.connection.Matches(req)
+	if !matches {
+		entryType = cilium.EntryType_Denied
+	}
+
+	p.connection.Log(entryType,
+		&cilium.LogEntry_GenericL7{
+			GenericL7: &cilium.L7LogEntry{
+				Proto: parserName,
+				Fields: map[string]string{
+					"command": req.command,
+					"keys":    strings.Join(req.keys, ", "),
+				},
+			},
+		})
+
+	if !matches {
+		// Replies must be injected in order, queue the denial if
+		// replies to previous requests are still outstanding
+		if len(p.replyQueue) == 0 || p.pushMode {
+			p.connection.Inject(true, DeniedMsg)
+		} else {
+			p.replyQueue = append(p.replyQueue, replyIntent{command: req.command, denied: true})
+		}
+		return proxylib.DROP, length
+	}
+
+	if _, ok := pushCommands[req.command]; ok {
+		p.pushMode = true
+		p.replyQueue = nil
+	} else if !p.pushMode {
+		p.replyQueue = append(p.replyQueue, replyIntent{command: req.command})
+	}
+
+	return proxylib.PASS, length
+}
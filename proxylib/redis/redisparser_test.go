@@ -0,0 +1,300 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package redis
+
+import (
+	"testing"
+
+	"github.com/cilium/cilium/proxylib/accesslog"
+	"github.com/cilium/cilium/proxylib/proxylib"
+	"github.com/cilium/cilium/proxylib/test"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type RedisSuite struct {
+	logServer *test.AccessLogServer
+	ins       *proxylib.Instance
+}
+
+var _ = Suite(&RedisSuite{})
+
+// Set up access log server and Library instance for all the test cases
+func (s *RedisSuite) SetUpSuite(c *C) {
+	s.logServer = test.StartAccessLogServer("access_log.sock", 10)
+	c.Assert(s.logServer, Not(IsNil))
+	s.ins = proxylib.NewInstance("node1", accesslog.NewClient(s.logServer.Path))
+	c.Assert(s.ins, Not(IsNil))
+}
+
+func (s *RedisSuite) checkAccessLogs(c *C, expPasses, expDrops int) {
+	passes, drops := s.logServer.Clear()
+	c.Check(passes, Equals, expPasses, Commentf("Unxpected number of passed access log messages"))
+	c.Check(drops, Equals, expDrops, Commentf("Unxpected number of dropped access log messages"))
+}
+
+func (s *RedisSuite) TearDownTest(c *C) {
+	s.logServer.Clear()
+}
+
+func (s *RedisSuite) TearDownSuite(c *C) {
+	s.logServer.Close()
+}
+
+const (
+	getFoo    = "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"
+	setFoo    = "*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n"
+	delPublic = "*3\r\n$3\r\nDEL\r\n$8\r\npublic:a\r\n$3\r\nfoo\r\n"
+)
+
+func (s *RedisSuite) TestRedisOnDataIncomplete(c *C) {
+	conn := s.ins.CheckNewConnectionOK(c, "redis", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "no-policy")
+	data := [][]byte{[]byte(getFoo[:10])}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{}, proxylib.MORE, 3)
+}
+
+func (s *RedisSuite) TestRedisOnDataAllowAll(c *C) {
+	s.ins.CheckInsertPolicyText(c, "1", []string{`
+		name: "rp1"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "redis"
+		  >
+		>
+		`})
+	conn := s.ins.CheckNewConnectionOK(c, "redis", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "rp1")
+	inline := "PING\r\n"
+	data := [][]byte{[]byte(getFoo), []byte(setFoo + inline)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.PASS, len(getFoo),
+		proxylib.PASS, len(setFoo),
+		proxylib.PASS, len(inline),
+		proxylib.MORE, 1)
+	s.checkAccessLogs(c, 3, 0)
+
+	reply1 := "$3\r\nbar\r\n"
+	reply2 := "+OK\r\n"
+	reply3 := "+PONG\r\n"
+	data = [][]byte{[]byte(reply1 + reply2 + reply3)}
+	conn.CheckOnDataOK(c, true, false, &data, []byte{},
+		proxylib.PASS, len(reply1),
+		proxylib.PASS, len(reply2),
+		proxylib.PASS, len(reply3))
+}
+
+func (s *RedisSuite) TestRedisOnDataCommandClass(c *C) {
+	s.ins.CheckInsertPolicyText(c, "1", []string{`
+		name: "rp2"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "redis"
+		    l7_rules: <
+		      l7_rules: <
+		        rule: <
+		          key: "commandClass"
+		          value: "read"
+		        >
+		      >
+		    >
+		  >
+		>
+		`})
+	conn := s.ins.CheckNewConnectionOK(c, "redis", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "rp2")
+	data := [][]byte{[]byte(getFoo + setFoo)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.PASS, len(getFoo),
+		proxylib.DROP, len(setFoo),
+		proxylib.MORE, 1)
+	s.checkAccessLogs(c, 1, 1)
+
+	// The denial of the pipelined SET must be injected after the reply to
+	// the GET
+	reply := "$3\r\nbar\r\n"
+	data = [][]byte{[]byte(reply)}
+	conn.CheckOnDataOK(c, true, false, &data, DeniedMsg,
+		proxylib.PASS, len(reply),
+		proxylib.INJECT, len(DeniedMsg))
+}
+
+func (s *RedisSuite) TestRedisOnDataKeyPattern(c *C) {
+	s.ins.CheckInsertPolicyText(c, "1", []string{`
+		name: "rp3"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "redis"
+		    l7_rules: <
+		      l7_rules: <
+		        rule: <
+		          key: "keyPattern"
+		          value: "public:*"
+		        >
+		      >
+		    >
+		  >
+		>
+		`})
+	conn := s.ins.CheckNewConnectionOK(c, "redis", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "rp3")
+	getPublic := "*2\r\n$3\r\nget\r\n$8\r\npublic:b\r\n"
+	data := [][]byte{[]byte(delPublic)}
+	conn.CheckOnDataOK(c, false, false, &data, DeniedMsg,
+		proxylib.DROP, len(delPublic),
+		proxylib.MORE, 1)
+	data = [][]byte{[]byte(getPublic)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.PASS, len(getPublic),
+		proxylib.MORE, 1)
+	s.checkAccessLogs(c, 1, 1)
+}
+
+func (s *RedisSuite) TestRedisParseReply(c *C) {
+	for _, reply := range []string{
+		"+OK\r\n",
+		"-ERR unknown\r\n",
+		":1000\r\n",
+		"$-1\r\n",
+		"$0\r\n\r\n",
+		"*-1\r\n",
+		"*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n",
+	} {
+		length, err := parseValue([]byte(reply+"+OK\r\n"), 0)
+		c.Assert(err, IsNil)
+		c.Assert(length, Equals, len(reply), Commentf("reply %q", reply))
+
+		length, err = parseValue([]byte(reply[:len(reply)-1]), 0)
+		c.Assert(err, IsNil)
+		c.Assert(length < 0, Equals, true, Commentf("reply %q", reply))
+	}
+
+	_, err := parseValue([]byte("!foo\r\n"), 0)
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *RedisSuite) TestGlobToRegexp(c *C) {
+	re, err := globToRegexp("user:?:[ab]*")
+	c.Assert(err, IsNil)
+	c.Assert(re.MatchString("user:1:alice"), Equals, true)
+	c.Assert(re.MatchString("user:1:carol"), Equals, false)
+	c.Assert(re.MatchString("user:12:alice"), Equals, false)
+
+	re, err = globToRegexp("a.b")
+	c.Assert(err, IsNil)
+	c.Assert(re.MatchString("a.b"), Equals, true)
+	c.Assert(re.MatchString("axb"), Equals, false)
+}
+
+func (s *RedisSuite) TestRedisGetKeys(c *C) {
+	for _, tc := range []struct {
+		args  []string
+		keys  []string
+		known bool
+	}{
+		{[]string{"GET", "a"}, []string{"a"}, true},
+		{[]string{"PING"}, nil, true},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}, true},
+		{[]string{"RENAME", "public:x", "secret:y"}, []string{"public:x", "secret:y"}, true},
+		{[]string{"SMOVE", "a", "b", "member"}, []string{"a", "b"}, true},
+		{[]string{"BRPOPLPUSH", "a", "b", "0"}, []string{"a", "b"}, true},
+		{[]string{"BLPOP", "a", "b", "0"}, []string{"a", "b"}, true},
+		{[]string{"BITOP", "AND", "dest", "a", "b"}, []string{"dest", "a", "b"}, true},
+		{[]string{"SUNIONSTORE", "dest", "a", "b"}, []string{"dest", "a", "b"}, true},
+		{[]string{"ZUNIONSTORE", "dest", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"dest", "a", "b"}, true},
+		{[]string{"EVAL", "return 1", "2", "a", "b", "arg"}, []string{"a", "b"}, true},
+		{[]string{"EVALSHA", "sha", "0", "arg"}, nil, true},
+		{[]string{"SORT", "a", "LIMIT", "0", "10", "STORE", "b"}, []string{"a", "b"}, true},
+		{[]string{"OBJECT", "ENCODING", "a"}, []string{"a"}, true},
+		{[]string{"MIGRATE", "host", "6379", "a", "0", "1000"}, []string{"a"}, true},
+		{[]string{"MIGRATE", "host", "6379", "", "0", "1000", "COPY", "KEYS", "a", "b"}, []string{"a", "b"}, true},
+
+		// Arguments not matching the key specification
+		{[]string{"RENAME", "a"}, nil, false},
+		{[]string{"EVAL", "return 1", "3", "a"}, nil, false},
+		{[]string{"EVAL", "return 1", "x"}, nil, false},
+		{[]string{"SORT", "a", "STORE"}, nil, false},
+		{[]string{"MIGRATE", "host", "6379", "", "0", "1000"}, nil, false},
+
+		// Unknown commands
+		{[]string{"XADD", "a", "*", "f", "v"}, nil, false},
+	} {
+		keys, known := getKeys(tc.args[0], tc.args[1:])
+		c.Assert(known, Equals, tc.known, Commentf("args %v", tc.args))
+		if tc.known {
+			c.Assert(keys, DeepEquals, tc.keys, Commentf("args %v", tc.args))
+		}
+	}
+}
+
+func (s *RedisSuite) TestRedisOnDataKeyPatternMultiKey(c *C) {
+	s.ins.CheckInsertPolicyText(c, "1", []string{`
+		name: "rp4"
+		policy: 2
+		ingress_per_port_policies: <
+		  port: 80
+		  rules: <
+		    l7_proto: "redis"
+		    l7_rules: <
+		      l7_rules: <
+		        rule: <
+		          key: "keyPattern"
+		          value: "public:*"
+		        >
+		      >
+		    >
+		  >
+		>
+		`})
+	conn := s.ins.CheckNewConnectionOK(c, "redis", true, 1, 2, "1.1.1.1:34567", "2.2.2.2:80", "rp4")
+	renameSecret := "*3\r\n$6\r\nRENAME\r\n$8\r\npublic:x\r\n$8\r\nsecret:y\r\n"
+	unknown := "XADD public:x * f v\r\n"
+	data := [][]byte{[]byte(renameSecret + unknown)}
+	conn.CheckOnDataOK(c, false, false, &data, append(append([]byte{}, DeniedMsg...), DeniedMsg...),
+		proxylib.DROP, len(renameSecret),
+		proxylib.DROP, len(unknown),
+		proxylib.MORE, 1)
+
+	renamePublic := "RENAME public:x public:y\r\n"
+	data = [][]byte{[]byte(renamePublic)}
+	conn.CheckOnDataOK(c, false, false, &data, []byte{},
+		proxylib.PASS, len(renamePublic),
+		proxylib.MORE, 1)
+	s.checkAccessLogs(c, 1, 2)
+}
+
+func (s *RedisSuite) TestRedisInlineLength(c *C) {
+	line := make([]byte, maxInlineLength+1)
+	for i := range line {
+		line[i] = 'a'
+	}
+	_, _, err := parseRequest(line)
+	c.Assert(err, Not(IsNil))
+	_, _, err = parseRequest(append(line, '\r', '\n'))
+	c.Assert(err, Not(IsNil))
+
+	length, _, err := parseRequest(line[:100])
+	c.Assert(err, IsNil)
+	c.Assert(length < 0, Equals, true)
+}